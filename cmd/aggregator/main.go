@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/smukkama/weather-server/internal/aggregation"
 	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/leader"
+	"github.com/smukkama/weather-server/internal/quality"
+	"github.com/smukkama/weather-server/internal/queue"
 	"github.com/smukkama/weather-server/internal/timer"
 	"github.com/smukkama/weather-server/pkg/config"
 )
@@ -24,89 +30,270 @@ func main() {
 	fmt.Println("Starting Aggregation Service...")
 
 	// Connect to database
-	db, err := database.Connect(cfg.Database.ConnectionString())
+	db, err := database.Connect(cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
 	fmt.Println("Connected to database")
 
-	// Create timer manager
-	timerManager := timer.NewTimerManager(2)
-	timerManager.Start()
-	defer timerManager.Stop()
-	fmt.Println("Timer manager started")
+	// Redis keeps the timers and elects the leader
+	var redisClient *redis.Client
+	if cfg.Timer.Persistence || cfg.Leader.Election {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		defer redisClient.Close()
+		if err := redisClient.Ping(context.Background()).Err(); err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+	}
 
 	// Create aggregators
+	completeness := aggregation.Completeness{
+		Interval:  cfg.Aggregation.ExpectedInterval,
+		Threshold: cfg.Aggregation.CompletenessThreshold,
+	}
 	hourlyAgg := aggregation.NewHourlyAggregator(db)
+	hourlyAgg.SetExcludeFlagged(cfg.Aggregation.ExcludeFlagged)
+	hourlyAgg.SetCompleteness(completeness)
+	if cfg.Aggregation.Continuous {
+		if !cfg.Database.TimescaleDB {
+			log.Fatalf("Invalid configuration: AGGREGATION_CONTINUOUS needs DB_TIMESCALEDB=true")
+		}
+		if !cfg.Aggregation.ExcludeFlagged {
+			fmt.Println("Warning: the continuous aggregate always leaves flagged measurements out")
+		}
+		hourlyAgg.SetContinuous(true)
+		fmt.Println("Hourly averages come from the raw_metrics_hourly continuous aggregate")
+	}
 	dailyAgg := aggregation.NewDailyAggregator(db)
+	dailyAgg.SetExcludeFlagged(cfg.Aggregation.ExcludeFlagged)
+	dailyAgg.SetDegreeDayBase(cfg.Aggregation.DegreeDayBase)
 
-	// Schedule hourly aggregation
-	scheduleHourlyAggregation(timerManager, hourlyAgg, cfg.Aggregation.HourlyDelay)
-
-	// Schedule daily aggregation
-	scheduleDailyAggregation(timerManager, dailyAgg, cfg.Aggregation.DailyTime)
-
-	fmt.Println("\n✓ Aggregation Service is running")
-	fmt.Println("✓ Press Ctrl+C to stop")
-
-	// Wait for interrupt signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	var broker queue.Broker
+	if cfg.Aggregation.Streaming {
+		if cfg.Aggregation.Continuous {
+			log.Fatalf("Invalid configuration: AGGREGATION_STREAMING and AGGREGATION_CONTINUOUS can't be combined")
+		}
+		// Messages stay uncommitted until their hour closes, which needs
+		// Kafka's offsets; other backends limit unacknowledged messages
+		if cfg.Queue.Backend != queue.BackendKafka {
+			log.Fatalf("Invalid configuration: AGGREGATION_STREAMING needs QUEUE_BACKEND=kafka")
+		}
+		if broker, err = queue.NewBroker(cfg); err != nil {
+			log.Fatalf("Failed to connect to message queue: %v", err)
+		}
+		defer broker.Close()
+		fmt.Println("Hourly aggregates are streamed from the metrics topic")
+	}
 
-	fmt.Println("\nShutting down gracefully...")
-}
+	hourlySpec, err := hourlyAgg.Schedule(cfg.Aggregation.HourlyDelay)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	dailySpec, err := dailyAgg.Schedule(cfg.Aggregation.DailyTime)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-func scheduleHourlyAggregation(tm *timer.TimerManager, agg *aggregation.HourlyAggregator, delay time.Duration) {
-	taskID := "hourly-aggregation"
+	// Further windows, e.g. 15m or 6h, each aggregated the hourly delay
+	// after it ends
+	type window struct {
+		agg  *aggregation.WindowAggregator
+		id   string // of its timer and handler, e.g. window-aggregation-15m0s
+		spec string
+	}
+	var windows []window
+	for _, length := range cfg.Aggregation.Windows {
+		size, err := time.ParseDuration(length)
+		if err != nil {
+			log.Fatalf("Invalid configuration: invalid aggregation window %q: %v", length, err)
+		}
+		windowAgg := aggregation.NewWindowAggregator(db, size)
+		windowAgg.SetExcludeFlagged(cfg.Aggregation.ExcludeFlagged)
+		spec, err := windowAgg.Schedule(cfg.Aggregation.HourlyDelay)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		windows = append(windows, window{agg: windowAgg, id: "window-aggregation-" + size.String(), spec: spec})
+	}
 
-	var scheduleNext func()
-	scheduleNext = func() {
-		nextRun := agg.CalculateNextRunTime(delay)
-		fmt.Printf("Next hourly aggregation scheduled for: %s\n", nextRun.Format("2006-01-02 15:04:05"))
+	// Windows whose last run failed, or that operators re-triggered, are
+	// retried with every hourly run, back as far as the backfill looks
+	retryDays := max(cfg.Aggregation.BackfillDays, 1)
+	retry := func(ctx context.Context) {
+		retried, err := aggregation.Retry(ctx, hourlyAgg, dailyAgg, time.Now().AddDate(0, 0, -retryDays))
+		if retried > 0 {
+			fmt.Printf("Retried %d aggregation windows\n", retried)
+		}
+		if err != nil {
+			log.Printf("Aggregation retry failed: %v\n", err)
+		}
+	}
 
-		callback := func() {
-			fmt.Println("\n--- Running Hourly Aggregation ---")
-			if err := agg.AggregatePreviousHour(); err != nil {
+	runHourly := func(ctx context.Context) {
+		fmt.Println("\n--- Running Hourly Aggregation ---")
+		if err := waitForDatabase(ctx, db); err != nil {
+			log.Printf("Hourly aggregation skipped: %v\n", err)
+			return
+		}
+		// Streamed hours are written as they close; only retries run here
+		if !cfg.Aggregation.Streaming {
+			if err := hourlyAgg.AggregatePreviousHour(ctx); err != nil {
 				log.Printf("Hourly aggregation failed: %v\n", err)
 			}
-			fmt.Println("--- Hourly Aggregation Complete ---")
-
-			// Schedule next run
-			scheduleNext()
 		}
-
-		tm.Schedule(taskID, nextRun, callback)
+		retry(ctx)
+		fmt.Println("--- Hourly Aggregation Complete ---")
+	}
+	runDaily := func(ctx context.Context) {
+		fmt.Println("\n--- Running Daily Aggregation ---")
+		if err := waitForDatabase(ctx, db); err != nil {
+			log.Printf("Daily aggregation skipped: %v\n", err)
+			return
+		}
+		if err := dailyAgg.AggregatePreviousDay(ctx); err != nil {
+			log.Printf("Daily aggregation failed: %v\n", err)
+		}
+		fmt.Println("--- Daily Aggregation Complete ---")
+	}
+	runWindow := func(ctx context.Context, windowAgg *aggregation.WindowAggregator) {
+		if err := waitForDatabase(ctx, db); err != nil {
+			log.Printf("%s window aggregation skipped: %v\n", windowAgg.Size(), err)
+			return
+		}
+		if err := windowAgg.AggregatePreviousWindow(ctx); err != nil {
+			log.Printf("%s window aggregation failed: %v\n", windowAgg.Size(), err)
+		}
 	}
 
-	scheduleNext()
-}
+	// Stop on an interrupt, during the backfill as well
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-func scheduleDailyAggregation(tm *timer.TimerManager, agg *aggregation.DailyAggregator, timeOfDay string) {
-	taskID := "daily-aggregation"
+	// lead backfills, schedules the aggregations and streams readings until
+	// ctx is done
+	lead := func(ctx context.Context) {
+		timerManager := timer.NewTimerManager(2)
+		timerManager.SetDefaultTimeout(cfg.Aggregation.Timeout)
+		if cfg.Timer.Persistence {
+			// Keep the next aggregation runs in Redis so a run due while the
+			// service is down is caught up on restart
+			timerManager.SetStore(timer.NewRedisStore(redisClient, "weather:timers:aggregator"))
+		}
+		timerManager.RegisterHandler("hourly-aggregation", func(ctx context.Context, _ string, _ json.RawMessage) { runHourly(ctx) })
+		timerManager.RegisterHandler("daily-aggregation", func(ctx context.Context, _ string, _ json.RawMessage) { runDaily(ctx) })
+		for _, w := range windows {
+			timerManager.RegisterHandler(w.id, func(ctx context.Context, _ string, _ json.RawMessage) { runWindow(ctx, w.agg) })
+		}
 
-	var scheduleNext func()
-	scheduleNext = func() {
-		nextRun, err := agg.CalculateNextRunTime(timeOfDay)
-		if err != nil {
-			log.Fatalf("Failed to calculate daily run time: %v", err)
+		if cfg.Timer.Persistence {
+			restored, err := timerManager.Restore(ctx)
+			if err != nil {
+				log.Fatalf("Failed to restore timers: %v", err)
+			}
+			fmt.Printf("Restored %d persisted timers\n", restored)
 		}
-		fmt.Printf("Next daily aggregation scheduled for: %s\n", nextRun.Format("2006-01-02 15:04:05"))
 
-		callback := func() {
-			fmt.Println("\n--- Running Daily Aggregation ---")
-			if err := agg.AggregatePreviousDay(); err != nil {
-				log.Printf("Daily aggregation failed: %v\n", err)
+		// Catch up on hours and days missed while the aggregator was down
+		// before the schedule takes over
+		if cfg.Aggregation.BackfillDays > 0 {
+			now := time.Now()
+			from := now.AddDate(0, 0, -cfg.Aggregation.BackfillDays)
+			fmt.Printf("\n--- Backfilling aggregates since %s ---\n", from.Format("2006-01-02 15:04"))
+			if err := waitForDatabase(ctx, db); err != nil {
+				log.Printf("Backfill skipped: %v\n", err)
+			} else if hours, days, err := aggregation.Backfill(ctx, hourlyAgg, dailyAgg, from, now); err != nil {
+				log.Printf("Backfill failed after %d hours and %d days: %v\n", hours, days, err)
+			} else {
+				fmt.Printf("--- Backfill Complete: %d hours and %d days aggregated ---\n", hours, days)
 			}
-			fmt.Println("--- Daily Aggregation Complete ---")
+		}
+		retry(ctx)
 
-			// Schedule next run
-			scheduleNext()
+		var streaming sync.WaitGroup
+		if broker != nil {
+			// Joins the consumer group only now, so a standby holds no
+			// partitions
+			consumer, err := broker.NewConsumer(cfg.Kafka.TopicMetrics, "aggregator-group")
+			if err != nil {
+				log.Fatalf("Failed to create consumer: %v", err)
+			}
+			defer consumer.Close()
+			streamAgg := aggregation.NewStreamAggregator(db, consumer, cfg.Aggregation.HourlyDelay, cfg.Aggregation.StreamFlushInterval)
+			streamAgg.SetCompleteness(completeness)
+			if cfg.Aggregation.ExcludeFlagged && cfg.Quality.Enabled {
+				streamAgg.SetQualityChecker(quality.NewChecker(quality.Config{
+					HistorySize:   cfg.Quality.HistorySize,
+					StuckReadings: cfg.Quality.StuckReadings,
+				}))
+			}
+			streaming.Add(1)
+			go func() {
+				defer streaming.Done()
+				streamAgg.Run(ctx)
+			}()
+			// Let the open hours be written before the consumer closes
+			defer streaming.Wait()
 		}
 
-		tm.Schedule(taskID, nextRun, callback)
+		timerManager.Start()
+		defer timerManager.Stop()
+		fmt.Println("Timer manager started")
+
+		// Schedule hourly and daily aggregation
+		scheduleAggregation(timerManager, "hourly-aggregation", hourlySpec, runHourly, cfg.Timer.Persistence)
+		scheduleAggregation(timerManager, "daily-aggregation", dailySpec, runDaily, cfg.Timer.Persistence)
+		for _, w := range windows {
+			scheduleAggregation(timerManager, w.id, w.spec, func(ctx context.Context) { runWindow(ctx, w.agg) }, cfg.Timer.Persistence)
+		}
+
+		<-ctx.Done()
+	}
+
+	fmt.Println("\n✓ Aggregation Service is running")
+	fmt.Println("✓ Press Ctrl+C to stop")
+
+	// With several instances only the leader aggregates; the others stand
+	// by, and whichever is elected next runs lead from the start
+	if cfg.Leader.Election {
+		leader.NewElector(redisClient, "aggregator", cfg.Leader.InstanceID, cfg.Leader.TTL).Lead(ctx, lead)
+	} else {
+		lead(ctx)
+	}
+
+	fmt.Println("\nShutting down gracefully...")
+}
+
+// waitForDatabase holds an aggregation run due during a database outage
+// until the database is back, for as long as the run's timeout allows
+func waitForDatabase(ctx context.Context, db *database.DB) error {
+	err := db.CheckHealth(ctx)
+	if err == nil {
+		return nil
 	}
+	fmt.Printf("Waiting for the database: %v\n", err)
+	return db.WaitHealthy(ctx)
+}
 
-	scheduleNext()
+// scheduleAggregation schedules a recurring aggregation. Persistent runs
+// go through the handler registered under id so they can be restored.
+func scheduleAggregation(tm *timer.TimerManager, id, spec string, run func(ctx context.Context), persistent bool) {
+	var nextRun time.Time
+	var err error
+	if persistent {
+		nextRun, err = tm.ScheduleRecurringPersistent(id, spec, id, nil)
+	} else {
+		var recurrence timer.Recurrence
+		if recurrence, err = timer.ParseRecurrence(spec); err == nil {
+			nextRun, err = tm.ScheduleRecurring(id, recurrence, run)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to schedule %s: %v", id, err)
+	}
+	fmt.Printf("Next %s scheduled for: %s (%s)\n", id, nextRun.Format("2006-01-02 15:04:05"), spec)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/coldstorage"
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	fmt.Println("Starting Cold-Storage Exporter...")
+
+	if cfg.Export.AfterDays < 1 {
+		log.Fatalf("Invalid configuration: EXPORT_AFTER_DAYS must be at least 1")
+	}
+
+	// Connect to database
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	fmt.Println("Connected to database")
+
+	store, err := coldstorage.NewS3Store(cfg.Export)
+	if err != nil {
+		log.Fatalf("Failed to set up object storage: %v", err)
+	}
+	exporter := coldstorage.NewExporter(db, store, cfg.Export.AfterDays, cfg.Export.Prefix)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runExport := func() {
+		fmt.Printf("\n--- Exporting readings before %s ---\n", exporter.Cutoff().Format("2006-01-02"))
+		files, err := exporter.Run(ctx)
+		if err != nil {
+			log.Printf("Export failed: %v\n", err)
+		}
+		fmt.Printf("--- Export Complete: %d file(s) written ---\n", files)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runExport()
+		ticker := time.NewTicker(cfg.Export.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runExport()
+			}
+		}
+	}()
+
+	fmt.Println("\n✓ Cold-Storage Exporter is running")
+	fmt.Printf("✓ Moving raw metrics older than %d days to %s every %v\n",
+		cfg.Export.AfterDays, store.URL(cfg.Export.Prefix), cfg.Export.Interval)
+	fmt.Println("✓ Press Ctrl+C to stop")
+
+	// Wait for interrupt signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	fmt.Println("\nShutting down gracefully...")
+	// An interrupted export rolls back and removes the file it uploaded
+	cancel()
+	<-done
+}
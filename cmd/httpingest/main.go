@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/ingest"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if len(cfg.HTTPIngest.APIKeys) == 0 {
+		log.Fatalf("HTTP_INGEST_API_KEYS must list at least one API key")
+	}
+
+	fmt.Println("Starting HTTP Ingest Service...")
+
+	broker, err := queue.NewBroker(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to message queue: %v", err)
+	}
+	defer broker.Close()
+
+	// Same producer settings as the TCP server so both paths behave alike;
+	// the router also carries quarantined readings to their topic
+	router := queue.NewRouter(broker, cfg.Kafka.TopicMetrics)
+	defer router.Close()
+	var producer queue.Producer = router.Topic(cfg.Kafka.TopicMetrics)
+	if cfg.Kafka.TopicLatest != "" {
+		producer = queue.NewLatestProducer(producer, router.Topic(cfg.Kafka.TopicLatest))
+	}
+	fmt.Printf("%s producer initialized (topic=%s, async=%v)\n", cfg.Queue.Backend, cfg.Kafka.TopicMetrics, producer.IsAsync())
+
+	// Readings outside physical ranges go to the quarantine topic
+	var quarantine *queue.Quarantine
+	if cfg.Validation.RangeValidation {
+		ranges, err := protocol.ParseMetricRanges(protocol.DefaultMetricRanges(), cfg.Validation.MetricRanges)
+		if err != nil {
+			log.Fatalf("Invalid METRIC_RANGES: %v", err)
+		}
+		quarantine = queue.NewQuarantine(router.Topic(cfg.Kafka.TopicQuarantine), ranges)
+		defer quarantine.Close()
+		fmt.Printf("Range validation enabled (quarantine topic=%s)\n", cfg.Kafka.TopicQuarantine)
+	}
+
+	mux := http.NewServeMux()
+	handler := ingest.NewHTTPHandler(producer, cfg.HTTPIngest.APIKeys, cfg.HTTPIngest.MaxBodyBytes)
+	handler.SetQuarantine(quarantine)
+	handler.SetInstanceID(cfg.HTTPIngest.InstanceID)
+	mux.Handle("/v1/metrics", handler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.HTTPIngest.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP ingest server failed: %v", err)
+		}
+	}()
+
+	fmt.Println("\n✓ HTTP Ingest Service is running")
+	fmt.Printf("✓ POST readings to http://localhost:%d/v1/metrics\n", cfg.HTTPIngest.Port)
+	fmt.Println("✓ Press Ctrl+C to stop")
+
+	// Wait for interrupt signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	fmt.Println("\nShutting down gracefully...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("HTTP ingest shutdown error: %v\n", err)
+	}
+}
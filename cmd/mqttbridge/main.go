@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/smukkama/weather-server/internal/ingest"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if cfg.MQTT.QoS < 0 || cfg.MQTT.QoS > 2 {
+		log.Fatalf("MQTT_QOS must be 0, 1 or 2 (got %d)", cfg.MQTT.QoS)
+	}
+
+	fmt.Println("Starting MQTT Bridge Service...")
+
+	broker, err := queue.NewBroker(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to message queue: %v", err)
+	}
+	defer broker.Close()
+
+	// Same producer settings as the TCP server so both paths behave alike;
+	// the router also carries quarantined readings to their topic
+	router := queue.NewRouter(broker, cfg.Kafka.TopicMetrics)
+	defer router.Close()
+	var producer queue.Producer = router.Topic(cfg.Kafka.TopicMetrics)
+	if cfg.Kafka.TopicLatest != "" {
+		producer = queue.NewLatestProducer(producer, router.Topic(cfg.Kafka.TopicLatest))
+	}
+	fmt.Printf("%s producer initialized (topic=%s, async=%v)\n", cfg.Queue.Backend, cfg.Kafka.TopicMetrics, producer.IsAsync())
+
+	// Readings outside physical ranges go to the quarantine topic
+	var quarantine *queue.Quarantine
+	if cfg.Validation.RangeValidation {
+		ranges, err := protocol.ParseMetricRanges(protocol.DefaultMetricRanges(), cfg.Validation.MetricRanges)
+		if err != nil {
+			log.Fatalf("Invalid METRIC_RANGES: %v", err)
+		}
+		quarantine = queue.NewQuarantine(router.Topic(cfg.Kafka.TopicQuarantine), ranges)
+		defer quarantine.Close()
+		fmt.Printf("Range validation enabled (quarantine topic=%s)\n", cfg.Kafka.TopicQuarantine)
+	}
+
+	bridge := ingest.NewMQTTBridge(&ingest.MQTTConfig{
+		Broker:   cfg.MQTT.Broker,
+		ClientID: cfg.MQTT.ClientID,
+		Username: cfg.MQTT.Username,
+		Password: cfg.MQTT.Password,
+		Topics:   cfg.MQTT.Topics,
+		QoS:      byte(cfg.MQTT.QoS),
+	}, producer)
+
+	bridge.SetQuarantine(quarantine)
+	if err := bridge.Start(); err != nil {
+		log.Fatalf("Failed to start MQTT bridge: %v", err)
+	}
+	defer bridge.Stop()
+
+	fmt.Println("\n✓ MQTT Bridge Service is running")
+	fmt.Printf("✓ Forwarding %v from %s to %s\n", cfg.MQTT.Topics, cfg.MQTT.Broker, cfg.Kafka.TopicMetrics)
+	fmt.Println("✓ Press Ctrl+C to stop")
+
+	// Wait for interrupt signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	fmt.Println("\nShutting down gracefully...")
+}
@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/smukkama/weather-server/internal/database"
 	"github.com/smukkama/weather-server/internal/notification"
 	"github.com/smukkama/weather-server/internal/protocol"
 	"github.com/smukkama/weather-server/internal/queue"
@@ -31,12 +32,65 @@ func main() {
 		fmt.Printf("Note: %v (notifications will be logged only)\n", err)
 	}
 
+	// Escalated alarms name their channel; email is the default
+	router := notification.NewRouter(notifier)
+	router.Register("email", notifier)
+	if cfg.SMS.AccountSID != "" {
+		sms, err := notification.NewSMSNotifier(&cfg.SMS)
+		if err != nil {
+			log.Fatalf("Failed to configure SMS: %v", err)
+		}
+		router.Register("sms", sms)
+		fmt.Println("SMS notifications enabled via Twilio")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	// Notifications go to their subscribers; ones nobody subscribed to go
+	// to SMTP_TO or the SMS routes as before
+	router.Subscribe(db)
+
+	// Every notification also goes to the webhook endpoints, whose
+	// deliveries are logged in the database
+	if len(cfg.Webhook.Endpoints) > 0 {
+		webhooks, err := notification.NewWebhookNotifier(ctx, db, &cfg.Webhook)
+		if err != nil {
+			log.Fatalf("Failed to configure webhooks: %v", err)
+		}
+		router.Broadcast(webhooks)
+		fmt.Printf("Webhook notifications enabled for %d endpoints\n", len(cfg.Webhook.Endpoints))
+	}
+
+	// Rate-limit each zipcode and metric's notifications, whatever the
+	// channel
+	throttle := notification.NewThrottle(router, cfg.Notification.ThrottleLimit, cfg.Notification.ThrottleWindow)
+
+	broker, err := queue.NewBroker(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to message queue: %v", err)
+	}
+	defer broker.Close()
+
 	// Create consumer for alarm notifications
-	consumer := queue.NewConsumer(cfg.Kafka.Brokers, cfg.Kafka.TopicAlarms, "notification-group")
+	consumer, err := broker.NewConsumer(cfg.Kafka.TopicAlarms, "notification-group")
+	if err != nil {
+		log.Fatalf("Failed to create consumer: %v", err)
+	}
 	defer consumer.Close()
-	fmt.Println("Kafka consumer initialized")
+	fmt.Printf("%s consumer initialized\n", cfg.Queue.Backend)
 
-	ctx := context.Background()
+	deadLetters, err := queue.OpenDeadLetterQueue(broker, cfg, "notification-group")
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer deadLetters.Close()
 
 	fmt.Println("\n✓ Notification Service is running")
 	fmt.Println("✓ Press Ctrl+C to stop")
@@ -53,17 +107,20 @@ func main() {
 				continue
 			}
 
-			// Decode alarm notification
-			alarmNotification, err := protocol.DecodeAlarmNotification(msg.Value)
+			// Decode and send, retrying failed sends and dead-lettering
+			// notifications that keep failing
+			err = deadLetters.Handle(ctx, msg, func() error {
+				alarmNotification, err := protocol.DecodeAlarmNotification(msg.Value)
+				if err != nil {
+					return queue.Permanent(fmt.Errorf("failed to decode notification: %w", err))
+				}
+				if err := throttle.SendAlarmNotification(alarmNotification); err != nil {
+					return fmt.Errorf("failed to send notification: %w", err)
+				}
+				return nil
+			})
 			if err != nil {
-				log.Printf("Failed to decode notification: %v\n", err)
-				consumer.Commit(ctx, msg)
-				continue
-			}
-
-			// Send notification
-			if err := notifier.SendAlarmNotification(alarmNotification); err != nil {
-				log.Printf("Failed to send notification: %v\n", err)
+				log.Printf("%v\n", err)
 				// Don't commit on error - retry
 				continue
 			}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/smukkama/weather-server/internal/app"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// standalone runs the TCP server, database writer and alarming service in
+// one process, for single-node deployments. With QUEUE_BACKEND=bolt or
+// memory they exchange messages without an external broker.
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	switch cfg.Queue.Backend {
+	case queue.BackendBolt, queue.BackendMemory:
+	default:
+		fmt.Printf("Warning: QUEUE_BACKEND=%s needs an external broker; use bolt or memory for a single node\n", cfg.Queue.Backend)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// One failing service stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	services := []struct {
+		name string
+		run  func(context.Context, *config.Config) error
+	}{
+		{"dbwriter", app.RunDBWriter},
+		{"server", app.RunServer},
+		{"alarming", app.RunAlarming},
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for _, svc := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := svc.run(ctx, cfg); err != nil {
+				errOnce.Do(func() { firstErr = fmt.Errorf("%s: %w", svc.name, err) })
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		log.Fatalf("%v", firstErr)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"log"
 	"math/rand"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -31,18 +34,39 @@ type MetricData struct {
 
 type MetricsMessage struct {
 	Type string     `json:"type"`
+	Seq  uint64     `json:"seq"`
 	Data MetricData `json:"data"`
 }
 
+// metricsSeq numbers each reading so the server can ack it
+var metricsSeq uint64
+
 type KeepaliveMessage struct {
 	Type string `json:"type"`
 }
 
+type GoodbyeMessage struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason,omitempty"`
+}
+
 type AckMessage struct {
 	Type   string `json:"type"`
 	Status string `json:"status"`
 }
 
+// ServerMessage covers every message the server can push to the client
+type ServerMessage struct {
+	Type            string `json:"type"`
+	Status          string `json:"status,omitempty"`
+	IntervalSeconds int    `json:"interval_seconds,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	Message         string `json:"message,omitempty"`
+	Code            string `json:"code,omitempty"`
+	Detail          string `json:"detail,omitempty"`
+	Seq             uint64 `json:"seq,omitempty"`
+}
+
 func main() {
 	// Configuration
 	serverAddr := "localhost:8080"
@@ -89,18 +113,39 @@ func main() {
 	defer metricsTicker.Stop()
 	defer keepaliveTicker.Stop()
 
-	// Background goroutine to read server responses
+	// Background goroutine to read server responses and commands
+	commands := make(chan *ServerMessage, 10)
 	go func() {
+		defer close(commands)
 		for {
-			ack, err := readAck(reader)
+			msg, err := readServerMessage(reader)
 			if err != nil {
 				fmt.Printf("Connection closed: %v\n", err)
 				return
 			}
-			fmt.Printf("← Received ack: %s\n", ack.Status)
+			switch msg.Type {
+			case "ack":
+				if msg.Seq > 0 {
+					fmt.Printf("← Received ack: %s (seq=%d)\n", msg.Status, msg.Seq)
+				} else {
+					fmt.Printf("← Received ack: %s\n", msg.Status)
+				}
+				continue
+			case "error":
+				fmt.Printf("← Received error: %s (%s)\n", msg.Code, msg.Detail)
+				continue
+			case "notice":
+				fmt.Printf("← Notice from server: %s\n", msg.Message)
+				continue
+			}
+			commands <- msg
 		}
 	}()
 
+	// Say goodbye on Ctrl+C so the server can release the connection immediately
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
 	fmt.Println("✓ Client running (Ctrl+C to stop)")
 
 	// Send initial metrics
@@ -114,6 +159,35 @@ func main() {
 
 		case <-keepaliveTicker.C:
 			sendKeepalive(conn)
+
+		case <-sigCh:
+			goodbye := GoodbyeMessage{Type: "goodbye", Reason: "client shutting down"}
+			if err := sendMessage(conn, goodbye); err != nil {
+				log.Printf("Failed to send goodbye: %v", err)
+			}
+			fmt.Println("→ Sent goodbye")
+			return
+
+		case cmd, ok := <-commands:
+			if !ok {
+				return
+			}
+			switch cmd.Type {
+			case "set_interval":
+				if cmd.IntervalSeconds > 0 {
+					metricsInterval = time.Duration(cmd.IntervalSeconds) * time.Second
+					metricsTicker.Reset(metricsInterval)
+					fmt.Printf("← Metrics interval set to %s\n", metricsInterval)
+				}
+			case "request_metrics_now":
+				fmt.Println("← Server requested metrics")
+				sendWeatherMetrics(conn)
+			case "shutdown":
+				fmt.Printf("← Server requested shutdown: %s\n", cmd.Reason)
+				return
+			default:
+				fmt.Printf("← Ignoring unknown server message: %s\n", cmd.Type)
+			}
 		}
 	}
 }
@@ -132,8 +206,10 @@ func sendWeatherMetrics(conn net.Conn) {
 	pollution := 20.0 + rand.Float64()*80.0 // 20-100
 	pollen := 10.0 + rand.Float64()*90.0    // 10-100
 
+	metricsSeq++
 	metrics := MetricsMessage{
 		Type: "metrics",
+		Seq:  metricsSeq,
 		Data: MetricData{
 			Timestamp:      time.Now().UTC().Format(time.RFC3339),
 			Temperature:    roundFloat(temp, 2),
@@ -187,6 +263,20 @@ func readAck(reader *bufio.Reader) (*AckMessage, error) {
 	return &ack, nil
 }
 
+func readServerMessage(reader *bufio.Reader) (*ServerMessage, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var msg ServerMessage
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
 func roundFloat(val float64, precision int) float64 {
 	ratio := 1.0
 	for i := 0; i < precision; i++ {
@@ -0,0 +1,153 @@
+package aggregation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// derivedInputs are the measurements each derived metric is computed
+// from; a derived value is left out when any of them is flagged
+var derivedInputs = map[string][]string{
+	"heat_index": {"temperature", "humidity"},
+	"wind_chill": {"temperature", "wind_speed"},
+	"dew_point":  {"temperature", "humidity"},
+}
+
+// aggregateSQL writes aggregate expressions over raw_metrics columns in
+// the database's dialect. SQLite gets percentile_cont, stddev_samp and
+// mode from the database package, called with the column as an argument
+// instead of WITHIN GROUP.
+type aggregateSQL struct {
+	driver  string
+	flagged func(metrics ...string) string
+	exclude string // the parameter saying whether flagged readings are left out
+}
+
+func newAggregateSQL(driver, exclude string) aggregateSQL {
+	return aggregateSQL{driver: driver, flagged: flaggedSQL(driver), exclude: exclude}
+}
+
+// unflagged is the FILTER clause leaving out a column's flagged readings
+// when a.exclude is set
+func (a aggregateSQL) unflagged(column string) string {
+	inputs, ok := derivedInputs[column]
+	if !ok {
+		inputs = []string{column}
+	}
+	return "FILTER (WHERE NOT (" + a.exclude + " AND " + a.flagged(inputs...) + "))"
+}
+
+// aggregate applies fn, e.g. AVG, to a column's unflagged readings
+func (a aggregateSQL) aggregate(fn, column string) string {
+	return fn + "(" + column + ") " + a.unflagged(column)
+}
+
+// percentile interpolates the fraction-th percentile of a column's
+// unflagged readings
+func (a aggregateSQL) percentile(column string, fraction float64) string {
+	if a.driver == database.DriverSQLite {
+		return fmt.Sprintf("percentile_cont(%s, %g) %s", column, fraction, a.unflagged(column))
+	}
+	return fmt.Sprintf("percentile_cont(%g) WITHIN GROUP (ORDER BY %s) %s", fraction, column, a.unflagged(column))
+}
+
+// spread returns the p50_, p95_ and stddev_ expressions of a metric's
+// readings, named after its aggregate columns
+func (a aggregateSQL) spread(name, column string) []string {
+	return []string{
+		a.percentile(column, 0.5) + " AS p50_" + name,
+		a.percentile(column, 0.95) + " AS p95_" + name,
+		a.aggregate("stddev_samp", column) + " AS stddev_" + name,
+	}
+}
+
+// modeWindDirection is the most common wind direction reported
+func (a aggregateSQL) modeWindDirection() string {
+	if a.driver == database.DriverSQLite {
+		return "mode(wind_direction) AS mode_wind_direction"
+	}
+	return "mode() WITHIN GROUP (ORDER BY wind_direction) AS mode_wind_direction"
+}
+
+// zipcodeAggregatesSQL returns the CTEs aggregating each zipcode's
+// raw_metrics from $1 to $2: averages, with each metric named after it and
+// the samples and stations counted, and spread, with its min_, max_, p50_,
+// p95_ and stddev_ columns and mode_wind_direction.
+//
+// Measurements a station didn't report are stored as NULL, which the
+// aggregates skip, so a missing sensor doesn't pull the average toward
+// zero. A window with no values for a metric aggregates to NULL. When $3
+// is set, measurements flagged in quality_flags are left out the same way;
+// derived metrics are left out when any of their inputs is flagged.
+//
+// Each station is averaged first and a zipcode's stations then count
+// equally, so a station reporting more often doesn't outweigh the rest.
+// The extremes, percentiles and standard deviation are over all the
+// zipcode's readings, whichever station sent them.
+func zipcodeAggregatesSQL(driver string) string {
+	sql := newAggregateSQL(driver, "$3")
+	var stationAvgs, zipcodeAvgs, spread []string
+	for _, m := range database.AggregateMetrics {
+		stationAvgs = append(stationAvgs, sql.aggregate("AVG", m.Column)+" AS "+m.Name)
+		zipcodeAvgs = append(zipcodeAvgs, "AVG("+m.Name+") AS "+m.Name)
+		spread = append(spread,
+			sql.aggregate("MIN", m.Column)+" AS min_"+m.Name,
+			sql.aggregate("MAX", m.Column)+" AS max_"+m.Name)
+		spread = append(spread, sql.spread(m.Name, m.Column)...)
+	}
+	spread = append(spread, sql.modeWindDirection())
+
+	return `stations AS (
+			SELECT
+				zipcode,
+				station_id,
+				` + sqlList(stationAvgs) + `,
+				COUNT(*) AS samples
+			FROM raw_metrics
+			WHERE timestamp >= $1 AND timestamp < $2
+			GROUP BY zipcode, station_id
+		), averages AS (
+			SELECT
+				zipcode,
+				` + sqlList(zipcodeAvgs) + `,
+				SUM(samples) AS samples,
+				COUNT(*) AS stations
+			FROM stations
+			GROUP BY zipcode
+		), spread AS (
+			SELECT
+				zipcode,
+				` + sqlList(spread) + `
+			FROM raw_metrics
+			WHERE timestamp >= $1 AND timestamp < $2
+			GROUP BY zipcode
+		)`
+}
+
+// metricColumns lists each metric's aggregate column with each prefix, by
+// metric, e.g. p50_temp, p95_temp, p50_humidity, ...
+func metricColumns(prefixes ...string) []string {
+	var columns []string
+	for _, metric := range database.AggregateMetrics {
+		for _, prefix := range prefixes {
+			columns = append(columns, prefix+metric.Name)
+		}
+	}
+	return columns
+}
+
+// prefixed returns each column prefixed, e.g. with a table alias
+func prefixed(prefix string, columns []string) []string {
+	out := make([]string, len(columns))
+	for i, column := range columns {
+		out[i] = prefix + column
+	}
+	return out
+}
+
+// sqlList joins SQL list items one per line at the query's indentation
+func sqlList(items []string) string {
+	return strings.Join(items, ",\n\t\t\t\t")
+}
@@ -0,0 +1,530 @@
+package aggregation
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// openSQLite opens a migrated SQLite database with one location, 94105
+func openSQLite(t *testing.T) *database.DB {
+	t.Helper()
+	ctx := context.Background()
+	db, err := database.Connect(config.DatabaseConfig{
+		Driver:       database.DriverSQLite,
+		SQLitePath:   filepath.Join(t.TempDir(), "weather.db"),
+		MaxOpenConns: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.RunMigrations(ctx, "../../migrations/sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertLocation(ctx, &database.Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAggregateSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	hour := time.Date(2026, time.October, 17, 14, 0, 0, 0, time.UTC)
+	var metrics []*database.RawMetric
+	for i, temp := range []float64{20, 22, 90} {
+		direction := []string{"W", "NW", "NW"}[i]
+		metric := &database.RawMetric{
+			Zipcode:       "94105",
+			Timestamp:     hour.Add(time.Duration(i) * 5 * time.Minute),
+			Temperature:   &temp,
+			WindDirection: &direction,
+			ReceivedAt:    hour,
+		}
+		if temp == 90 {
+			metric.QualityFlags = map[string]string{"temperature": "spike"}
+		}
+		metrics = append(metrics, metric)
+	}
+	// A second station reporting once an hour counts as much as the first
+	park := 25.0
+	metrics = append(metrics, &database.RawMetric{
+		Zipcode:     "94105",
+		StationID:   "park",
+		Timestamp:   hour,
+		Temperature: &park,
+		ReceivedAt:  hour,
+	})
+	if err := db.InsertRawMetricsBatch(ctx, metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	hourly := NewHourlyAggregator(db)
+	hourly.SetExcludeFlagged(true)
+	if err := hourly.Aggregate(ctx, hour); err != nil {
+		t.Fatalf("Hourly aggregation failed: %v", err)
+	}
+	got, err := db.GetHourlyMetrics(ctx, "94105", hour, hour.Add(time.Hour), 0, 0)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected one hour, got %d (%v)", len(got), err)
+	}
+	if got[0].AvgTemp == nil || *got[0].AvgTemp != 23 || got[0].SampleCount != 4 {
+		t.Errorf("Expected the stations' averages of 21 and 25 to average 23 over 4 samples, got %v over %d", got[0].AvgTemp, got[0].SampleCount)
+	}
+	// Two stations should have sent 24 readings
+	if got[0].ExpectedSamples == nil || *got[0].ExpectedSamples != 24 ||
+		got[0].Completeness == nil || math.Abs(*got[0].Completeness-100.0/6) > 0.01 ||
+		got[0].Incomplete == nil || !*got[0].Incomplete {
+		t.Errorf("Expected 4 of 24 readings flagged incomplete, got %v, %v, %v", got[0].ExpectedSamples, got[0].Completeness, got[0].Incomplete)
+	}
+	// The unflagged readings are 20, 22 and 25
+	stats := got[0].TempStats
+	for name, c := range map[string]struct {
+		got  *float64
+		want float64
+	}{
+		"min":    {stats.Min, 20},
+		"max":    {stats.Max, 25},
+		"p50":    {stats.P50, 22},
+		"p95":    {stats.P95, 24.7},
+		"stddev": {stats.StdDev, math.Sqrt(19.0 / 3)},
+	} {
+		if c.got == nil || math.Abs(*c.got-c.want) > 1e-9 {
+			t.Errorf("Expected an hourly %s of %v, got %v", name, c.want, c.got)
+		}
+	}
+	if got[0].HumidityStats.P50 != nil || got[0].HumidityStats.StdDev != nil {
+		t.Errorf("Expected no humidity spread without humidity readings")
+	}
+	if got[0].ModeWindDirection == nil || *got[0].ModeWindDirection != "NW" {
+		t.Errorf("Expected NW as the most common wind direction, got %v", got[0].ModeWindDirection)
+	}
+
+	daily := NewDailyAggregator(db)
+	daily.SetExcludeFlagged(true)
+	if err := daily.Aggregate(ctx, hour); err != nil {
+		t.Fatalf("Daily aggregation failed: %v", err)
+	}
+	days, err := db.GetDailySummaries(ctx, "94105", hour, hour.AddDate(0, 0, 1), 0, 0)
+	if err != nil || len(days) != 1 {
+		t.Fatalf("Expected one day, got %d (%v)", len(days), err)
+	}
+	if days[0].MaxTemp == nil || *days[0].MaxTemp != 25 {
+		t.Errorf("Expected a daily maximum of 25, got %v", days[0].MaxTemp)
+	}
+	if p50 := days[0].TempSpread.P50; p50 == nil || *p50 != 22 {
+		t.Errorf("Expected a daily median of 22, got %v", p50)
+	}
+
+	// Without raw readings the day's spread is kept
+	if _, err := db.ExecContext(ctx, "DELETE FROM raw_metrics"); err != nil {
+		t.Fatal(err)
+	}
+	if err := daily.Aggregate(ctx, hour); err != nil {
+		t.Fatalf("Daily aggregation failed: %v", err)
+	}
+	days, err = db.GetDailySummaries(ctx, "94105", hour, hour.AddDate(0, 0, 1), 0, 0)
+	if err != nil || len(days) != 1 {
+		t.Fatalf("Expected one day, got %d (%v)", len(days), err)
+	}
+	if p50 := days[0].TempSpread.P50; p50 == nil || *p50 != 22 {
+		t.Errorf("Expected the daily median of 22 to be kept, got %v", p50)
+	}
+}
+
+func TestBackfillSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	// Readings late yesterday and early today; yesterday's 21:00 hour was
+	// aggregated on schedule, the rest were missed
+	now := time.Date(2026, time.October, 17, 3, 30, 0, 0, time.UTC)
+	hours := []time.Time{
+		now.Add(-6 * time.Hour).Truncate(time.Hour),
+		now.Add(-5 * time.Hour).Truncate(time.Hour),
+		now.Add(-2 * time.Hour).Truncate(time.Hour),
+		now.Truncate(time.Hour),
+	}
+	for i, hour := range hours {
+		temp := float64(10 + i)
+		if err := db.InsertRawMetric(ctx, &database.RawMetric{Zipcode: "94105", Timestamp: hour.Add(time.Minute), Temperature: &temp, ReceivedAt: hour}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hourly, daily := NewHourlyAggregator(db), NewDailyAggregator(db)
+	if err := hourly.Aggregate(ctx, hours[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The unfinished hour is left for its scheduled run
+	from := now.AddDate(0, 0, -1)
+	gotHours, gotDays, err := Backfill(ctx, hourly, daily, from, now)
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if gotHours != 2 || gotDays != 1 {
+		t.Errorf("Expected 2 hours and 1 day backfilled, got %d and %d", gotHours, gotDays)
+	}
+	averages, err := db.GetHourlyMetrics(ctx, "94105", from, now, 0, 0)
+	if err != nil || len(averages) != 3 {
+		t.Fatalf("Expected 3 hourly averages, got %d (%v)", len(averages), err)
+	}
+	summaries, err := db.GetDailySummaries(ctx, "94105", from, now, 0, 0)
+	if err != nil || len(summaries) != 1 || *summaries[0].MaxTemp != 11 {
+		t.Fatalf("Expected yesterday summarised with both hours, got %+v (%v)", summaries, err)
+	}
+
+	if gotHours, gotDays, err := Backfill(ctx, hourly, daily, from, now); err != nil || gotHours != 0 || gotDays != 0 {
+		t.Errorf("Expected nothing left to backfill, got %d hours and %d days (%v)", gotHours, gotDays, err)
+	}
+}
+
+func TestAggregationRunsSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	hourly, daily := NewHourlyAggregator(db), NewDailyAggregator(db)
+
+	hour := time.Date(2026, time.October, 17, 14, 0, 0, 0, time.UTC)
+	temp := 20.0
+	if err := db.InsertRawMetric(ctx, &database.RawMetric{Zipcode: "94105", Timestamp: hour, Temperature: &temp, ReceivedAt: hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hourly.Aggregate(ctx, hour); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.GetAggregationRuns(ctx, database.AggregationHourly, "", 0)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("Expected one recorded run, got %d (%v)", len(runs), err)
+	}
+	if r := runs[0]; r.Status != database.AggregationRunSucceeded || r.RowsAffected == nil || *r.RowsAffected != 1 || r.FinishedAt == nil {
+		t.Errorf("Expected a finished run that wrote one zipcode, got %+v", r)
+	}
+
+	// A window already running elsewhere is skipped until that run is
+	// abandoned
+	next := hour.Add(time.Hour)
+	if _, err := db.StartAggregationRun(ctx, database.AggregationHourly, next, next.Add(time.Hour), hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.StartAggregationRun(ctx, database.AggregationHourly, next, next.Add(time.Hour), hour); err != database.ErrAggregationRunning {
+		t.Errorf("Expected ErrAggregationRunning, got %v", err)
+	}
+	if _, err := db.StartAggregationRun(ctx, database.AggregationHourly, next, next.Add(time.Hour), time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("Expected the abandoned run to be replaced, got %v", err)
+	}
+
+	// An operator re-triggers a day; the abandoned hour is retried too
+	if _, err := db.ExecContext(ctx, "INSERT INTO aggregation_runs (kind, window_start, window_end, status) VALUES ('daily', $1, $2, 'pending')", hour.Truncate(24*time.Hour), hour.Truncate(24*time.Hour).AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE aggregation_runs SET status = 'failed' WHERE status = 'running'"); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := Retry(ctx, hourly, daily, hour.AddDate(0, 0, -1))
+	if err != nil || retried != 2 {
+		t.Fatalf("Expected 2 windows retried, got %d (%v)", retried, err)
+	}
+	if left, err := db.GetAggregationRetries(ctx, hour.AddDate(0, 0, -1)); err != nil || len(left) != 0 {
+		t.Errorf("Expected nothing left to retry, got %d (%v)", len(left), err)
+	}
+	summaries, err := db.GetDailySummaries(ctx, "94105", hour.Truncate(24*time.Hour), hour.AddDate(0, 0, 1), 0, 0)
+	if err != nil || len(summaries) != 1 {
+		t.Errorf("Expected the re-triggered day summarised, got %d (%v)", len(summaries), err)
+	}
+}
+
+// commitRecorder is a queue.Consumer that records commits; messages are
+// handed to the stream aggregator directly
+type commitRecorder struct {
+	queue.Consumer
+	committed []int64
+}
+
+func (c *commitRecorder) Commit(_ context.Context, msg queue.Message) error {
+	c.committed = append(c.committed, msg.Offset)
+	return nil
+}
+
+func TestStreamAggregatorSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	consumer := &commitRecorder{}
+	stream := NewStreamAggregator(db, consumer, 5*time.Minute, time.Minute)
+
+	hour := time.Now().Add(-3 * time.Hour).Truncate(time.Hour)
+	var offset int64
+	send := func(station string, at time.Time, temp float64) {
+		t.Helper()
+		offset++
+		value, err := protocol.EncodeMetricMessage(&protocol.MetricMessage{
+			Zipcode:   "94105",
+			StationID: station,
+			Data:      protocol.MetricData{Timestamp: at.Format(time.RFC3339), Temperature: &temp},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.add(queue.Message{Offset: offset, Value: value})
+	}
+
+	// Two stations, averaged separately and then equally
+	send("", hour, 20)
+	send("", hour.Add(5*time.Minute), 22)
+	send("park", hour.Add(10*time.Minute), 25)
+	stream.flush(ctx, time.Now())
+	got, err := db.GetHourlyMetrics(ctx, "94105", hour, hour.Add(time.Hour), 0, 0)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected the open hour written, got %d (%v)", len(got), err)
+	}
+	if got[0].AvgTemp == nil || *got[0].AvgTemp != 23 || got[0].SampleCount != 3 {
+		t.Errorf("Expected an average of 23 over 3 samples, got %v over %d", got[0].AvgTemp, got[0].SampleCount)
+	}
+	if p50 := got[0].TempStats.P50; p50 == nil || *p50 != 22 {
+		t.Errorf("Expected a median of 22, got %v", p50)
+	}
+	if got[0].ExpectedSamples == nil || *got[0].ExpectedSamples != 24 || got[0].Incomplete == nil || !*got[0].Incomplete {
+		t.Errorf("Expected 3 of 24 readings flagged incomplete, got %v, %v", got[0].ExpectedSamples, got[0].Incomplete)
+	}
+	if len(consumer.committed) != 0 {
+		t.Errorf("Expected nothing committed while the hour is open, got %v", consumer.committed)
+	}
+
+	// A reading past the hour and its lateness closes it
+	send("", hour.Add(time.Hour+6*time.Minute), 18)
+	stream.flush(ctx, time.Now())
+	runs, err := db.GetAggregationRuns(ctx, database.AggregationHourly, database.AggregationRunSucceeded, 0)
+	if err != nil || len(runs) != 1 || !runs[0].WindowStart.Equal(hour) {
+		t.Fatalf("Expected the closed hour recorded as a run, got %v (%v)", runs, err)
+	}
+	if len(consumer.committed) != 1 || consumer.committed[0] != 3 {
+		t.Errorf("Expected the closed hour's messages committed up to offset 3, got %v", consumer.committed)
+	}
+
+	send("", hour.Add(50*time.Minute), 30)
+	if stream.Late() != 1 {
+		t.Errorf("Expected a late reading, got %d", stream.Late())
+	}
+	stream.flush(ctx, time.Now())
+	if got, err = db.GetHourlyMetrics(ctx, "94105", hour, hour.Add(time.Hour), 0, 0); err != nil || len(got) != 1 || *got[0].AvgTemp != 23 {
+		t.Errorf("Expected the closed hour unchanged, got %v (%v)", got, err)
+	}
+}
+
+func TestDegreeDaysSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	hourly, daily := NewHourlyAggregator(db), NewDailyAggregator(db)
+
+	day := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	reading := func(at time.Time, temp, precip float64) {
+		t.Helper()
+		if err := db.InsertRawMetric(ctx, &database.RawMetric{Zipcode: "94105", Timestamp: at, Temperature: &temp, Precipitation: &precip, ReceivedAt: at}); err != nil {
+			t.Fatal(err)
+		}
+		if err := hourly.Aggregate(ctx, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summarise := func(date time.Time) *database.DailySummary {
+		t.Helper()
+		if err := daily.Aggregate(ctx, date); err != nil {
+			t.Fatal(err)
+		}
+		days, err := db.GetDailySummaries(ctx, "94105", date, date.AddDate(0, 0, 1), 0, 0)
+		if err != nil || len(days) != 1 {
+			t.Fatalf("Expected one day, got %d (%v)", len(days), err)
+		}
+		return days[0]
+	}
+	near := func(got *float64, want float64) bool {
+		return got != nil && math.Abs(*got-want) < 1e-9
+	}
+
+	// A mean of 12°C is 6.3 heating degree days below the base of 18.3
+	reading(day.Add(10*time.Hour), 10, 2)
+	reading(day.Add(11*time.Hour), 14, 1)
+	first := summarise(day)
+	if !near(first.HeatingDegreeDays, 6.3) || !near(first.CoolingDegreeDays, 0) {
+		t.Errorf("Expected 6.3 heating and no cooling degree days, got %v and %v", first.HeatingDegreeDays, first.CoolingDegreeDays)
+	}
+	if !near(first.TotalPrecip, 3) || !near(first.PrecipYearToDate, 3) {
+		t.Errorf("Expected 3mm on the day and the year, got %v and %v", first.TotalPrecip, first.PrecipYearToDate)
+	}
+
+	next := day.AddDate(0, 0, 1)
+	reading(next.Add(10*time.Hour), 20, 4)
+	second := summarise(next)
+	if !near(second.HeatingDegreeDays, 0) || !near(second.CoolingDegreeDays, 1.7) {
+		t.Errorf("Expected 1.7 cooling degree days, got %v and %v", second.HeatingDegreeDays, second.CoolingDegreeDays)
+	}
+	if !near(second.PrecipYearToDate, 7) {
+		t.Errorf("Expected 7mm in the year, got %v", second.PrecipYearToDate)
+	}
+
+	// Summarising the first day again carries its new total forward
+	reading(day.Add(12*time.Hour), 12, 5)
+	summarise(day)
+	days, err := db.GetDailySummaries(ctx, "94105", next, next.AddDate(0, 0, 1), 0, 0)
+	if err != nil || len(days) != 1 || !near(days[0].PrecipYearToDate, 12) {
+		t.Errorf("Expected 12mm in the year after the first day changed, got %v (%v)", days, err)
+	}
+}
+
+func TestRegionalRollupSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	ca, sf, la := "CA", "San Francisco County", "Los Angeles County"
+	for _, loc := range []struct {
+		zipcode, city string
+		county        *string
+	}{
+		{"94105", "San Francisco", &sf},
+		{"94103", "San Francisco", &sf},
+		{"90012", "Los Angeles", &la},
+		{"10001", "New York", nil},
+	} {
+		if err := db.UpsertLocation(ctx, &database.Location{Zipcode: loc.zipcode, CityName: loc.city}); err != nil {
+			t.Fatal(err)
+		}
+		// 10001 has no state, so it isn't rolled up
+		if loc.county != nil {
+			if err := db.SetLocationRegion(ctx, loc.zipcode, loc.county, &ca); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.SetLocationRegion(ctx, "00000", nil, &ca); err != database.ErrLocationNotFound {
+		t.Errorf("Expected ErrLocationNotFound for an unknown zipcode, got %v", err)
+	}
+
+	hour := time.Date(2026, time.October, 17, 14, 0, 0, 0, time.UTC)
+	var metrics []*database.RawMetric
+	for _, r := range []struct {
+		zipcode string
+		temps   []float64
+	}{
+		{"94105", []float64{14, 16}},
+		{"94103", []float64{18}},
+		{"90012", []float64{26}},
+		{"10001", []float64{5}},
+	} {
+		for i, temp := range r.temps {
+			metrics = append(metrics, &database.RawMetric{
+				Zipcode:     r.zipcode,
+				Timestamp:   hour.Add(time.Duration(i) * 5 * time.Minute),
+				Temperature: &temp,
+				ReceivedAt:  hour,
+			})
+		}
+	}
+	if err := db.InsertRawMetricsBatch(ctx, metrics); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHourlyAggregator(db).Aggregate(ctx, hour); err != nil {
+		t.Fatalf("Hourly aggregation failed: %v", err)
+	}
+	if err := NewDailyAggregator(db).Aggregate(ctx, hour); err != nil {
+		t.Fatalf("Daily aggregation failed: %v", err)
+	}
+
+	// Each zipcode counts equally: San Francisco averages 15 and 18
+	for _, c := range []struct {
+		regionType, region string
+		avg, min, max      float64
+		zipcodes, samples  int
+	}{
+		{database.RegionCity, "San Francisco, CA", 16.5, 14, 18, 2, 3},
+		{database.RegionCounty, "Los Angeles County, CA", 26, 26, 26, 1, 1},
+		{database.RegionState, "CA", 59.0 / 3, 14, 26, 3, 4},
+	} {
+		hours, err := db.GetRegionalHourlyMetrics(ctx, c.regionType, c.region, hour, hour.Add(time.Hour), 0, 0)
+		if err != nil || len(hours) != 1 {
+			t.Fatalf("Expected one hour for %s %s, got %d (%v)", c.regionType, c.region, len(hours), err)
+		}
+		h := hours[0]
+		if h.AvgTemp == nil || math.Abs(*h.AvgTemp-c.avg) > 1e-9 || h.ZipcodeCount != c.zipcodes || h.SampleCount != c.samples {
+			t.Errorf("Expected %s to average %v over %d zipcodes and %d samples, got %v over %d and %d",
+				c.region, c.avg, c.zipcodes, c.samples, h.AvgTemp, h.ZipcodeCount, h.SampleCount)
+		}
+		if h.TempStats.Min == nil || *h.TempStats.Min != c.min || h.TempStats.Max == nil || *h.TempStats.Max != c.max {
+			t.Errorf("Expected %s to range from %v to %v, got %v to %v", c.region, c.min, c.max, h.TempStats.Min, h.TempStats.Max)
+		}
+
+		days, err := db.GetRegionalDailySummaries(ctx, c.regionType, c.region, hour, hour.AddDate(0, 0, 1), 0, 0)
+		if err != nil || len(days) != 1 {
+			t.Fatalf("Expected one day for %s %s, got %d (%v)", c.regionType, c.region, len(days), err)
+		}
+		if d := days[0]; d.MinTemp == nil || *d.MinTemp != c.min || d.MaxTemp == nil || *d.MaxTemp != c.max || d.ZipcodeCount != c.zipcodes {
+			t.Errorf("Expected %s's day to range from %v to %v over %d zipcodes, got %v to %v over %d",
+				c.region, c.min, c.max, c.zipcodes, d.MinTemp, d.MaxTemp, d.ZipcodeCount)
+		}
+	}
+
+	// Moving a zipcode to another county takes it out of its old one
+	if err := db.SetLocationRegion(ctx, "94103", &la, &ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHourlyAggregator(db).Aggregate(ctx, hour); err != nil {
+		t.Fatalf("Hourly aggregation failed: %v", err)
+	}
+	hours, err := db.GetRegionalHourlyMetrics(ctx, database.RegionCounty, "San Francisco County, CA", hour, hour.Add(time.Hour), 0, 0)
+	if err != nil || len(hours) != 1 || hours[0].ZipcodeCount != 1 {
+		t.Errorf("Expected San Francisco County to have one zipcode left, got %v (%v)", hours, err)
+	}
+}
+
+func TestWindowAggregatorSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	start := time.Date(2026, time.October, 17, 14, 15, 0, 0, time.UTC)
+	var metrics []*database.RawMetric
+	for i, temp := range []float64{20, 22, 30} {
+		// The third reading is in the next window
+		metrics = append(metrics, &database.RawMetric{
+			Zipcode:     "94105",
+			Timestamp:   start.Add(time.Duration(i) * 10 * time.Minute),
+			Temperature: &temp,
+			ReceivedAt:  start,
+		})
+	}
+	if err := db.InsertRawMetricsBatch(ctx, metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	windows := NewWindowAggregator(db, 15*time.Minute)
+	if err := windows.Aggregate(ctx, start.Add(7*time.Minute)); err != nil {
+		t.Fatalf("Window aggregation failed: %v", err)
+	}
+	got, err := db.GetWindowedMetrics(ctx, "94105", 15*time.Minute, start, start.Add(time.Hour), 0, 0)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected one window, got %d (%v)", len(got), err)
+	}
+	w := got[0]
+	if !w.HourTimestamp.Equal(start) || !w.WindowEnd.Equal(start.Add(15*time.Minute)) {
+		t.Errorf("Expected the window from %s, got %s to %s", start, w.HourTimestamp, w.WindowEnd)
+	}
+	if w.AvgTemp == nil || *w.AvgTemp != 21 || w.SampleCount != 2 || w.TempStats.Max == nil || *w.TempStats.Max != 22 {
+		t.Errorf("Expected an average of 21 and a max of 22 over 2 samples, got %v, %v over %d", w.AvgTemp, w.TempStats.Max, w.SampleCount)
+	}
+	if other, err := db.GetWindowedMetrics(ctx, "94105", time.Hour, start.Add(-time.Hour), start.Add(time.Hour), 0, 0); err != nil || len(other) != 0 {
+		t.Errorf("Expected no hour-long windows, got %d (%v)", len(other), err)
+	}
+
+	if spec, err := windows.Schedule(5 * time.Minute); err != nil || spec != "@every 15m0s offset 5m0s" {
+		t.Errorf("Expected a run 5m after every window, got %q (%v)", spec, err)
+	}
+	if _, err := NewWindowAggregator(db, 90*time.Second).Schedule(0); err == nil {
+		t.Error("Expected an error for a window of part of a minute")
+	}
+}
@@ -0,0 +1,113 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// MissingHours returns the hours from from up to to, oldest first, in which
+// some zipcode has readings but no hourly average, e.g. because the
+// aggregator was down when they were due
+func (h *HourlyAggregator) MissingHours(ctx context.Context, from, to time.Time) ([]time.Time, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM raw_metrics r
+			WHERE r.timestamp >= $1 AND r.timestamp < $2
+			  AND NOT EXISTS (
+				SELECT 1 FROM hourly_metrics m
+				WHERE m.zipcode = r.zipcode AND m.hour_timestamp = $1
+			  )
+		)
+	`
+
+	var missing []time.Time
+	for hour := from.Truncate(time.Hour); !hour.Add(time.Hour).After(to); hour = hour.Add(time.Hour) {
+		var gap bool
+		if err := h.db.QueryRowContext(ctx, query, hour, hour.Add(time.Hour)).Scan(&gap); err != nil {
+			return nil, fmt.Errorf("failed to check hour %s: %w", hour.Format("2006-01-02 15:04"), err)
+		}
+		if gap {
+			missing = append(missing, hour)
+		}
+	}
+	return missing, nil
+}
+
+// MissingDays returns the days from from up to to, oldest first, in which
+// some zipcode has hourly averages but no daily summary
+func (d *DailyAggregator) MissingDays(ctx context.Context, from, to time.Time) ([]time.Time, error) {
+	day := "$3::date"
+	if d.db.Driver() == database.DriverSQLite {
+		day = "$3"
+	}
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM hourly_metrics m
+			WHERE m.hour_timestamp >= $1 AND m.hour_timestamp < $2
+			  AND NOT EXISTS (
+				SELECT 1 FROM daily_summary s
+				WHERE s.zipcode = m.zipcode AND s.date = ` + day + `
+			  )
+		)
+	`
+
+	var missing []time.Time
+	for date := from.Truncate(24 * time.Hour); !date.AddDate(0, 0, 1).After(to); date = date.AddDate(0, 0, 1) {
+		dateArg := any(date)
+		if d.db.Driver() == database.DriverSQLite {
+			dateArg = date.Format("2006-01-02")
+		}
+		var gap bool
+		if err := d.db.QueryRowContext(ctx, query, date, date.AddDate(0, 0, 1), dateArg).Scan(&gap); err != nil {
+			return nil, fmt.Errorf("failed to check day %s: %w", date.Format("2006-01-02"), err)
+		}
+		if gap {
+			missing = append(missing, date)
+		}
+	}
+	return missing, nil
+}
+
+// Backfill aggregates the finished hours and days since from that were
+// never aggregated, hours first since the days are summarised from them.
+// A day with a backfilled hour is summarised again even if it had a
+// summary, which was made without that hour. It returns how many hours
+// and days it aggregated.
+func Backfill(ctx context.Context, hourly *HourlyAggregator, daily *DailyAggregator, from, now time.Time) (int, int, error) {
+	hours, err := hourly.MissingHours(ctx, from, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	today := now.Truncate(24 * time.Hour)
+	days := make(map[time.Time]bool)
+	for _, hour := range hours {
+		if err := hourly.Aggregate(ctx, hour); err != nil {
+			return 0, 0, err
+		}
+		if date := hour.Truncate(24 * time.Hour); date.Before(today) {
+			days[date] = true
+		}
+	}
+
+	missingDays, err := daily.MissingDays(ctx, from, today)
+	if err != nil {
+		return len(hours), 0, err
+	}
+	for _, date := range missingDays {
+		days[date] = true
+	}
+	for date := from.Truncate(24 * time.Hour); date.Before(today); date = date.AddDate(0, 0, 1) {
+		if !days[date] {
+			continue
+		}
+		if err := daily.Aggregate(ctx, date); err != nil {
+			return len(hours), 0, err
+		}
+	}
+	return len(hours), len(days), nil
+}
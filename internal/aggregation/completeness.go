@@ -0,0 +1,50 @@
+package aggregation
+
+import (
+	"fmt"
+	"time"
+)
+
+// Completeness says how many readings an hour should have and how few make
+// its aggregates untrustworthy. Each station that reported during the hour
+// is expected to report every Interval; a silent station isn't expected.
+type Completeness struct {
+	Interval  time.Duration // how often a station reports
+	Threshold float64       // percentage of the expected readings below which an hour is incomplete
+}
+
+// DefaultCompleteness expects a reading every 5 minutes, 12 an hour, and
+// marks hours with fewer than three quarters of them incomplete
+var DefaultCompleteness = Completeness{Interval: 5 * time.Minute, Threshold: 75}
+
+// perStation is how many readings a station should send in an hour
+func (c Completeness) perStation() int {
+	if c.Interval <= 0 {
+		return 1
+	}
+	return max(1, int(time.Hour/c.Interval))
+}
+
+// of returns the readings expected from stations, the percentage of them
+// that samples is, capped at 100, and whether that is below the threshold
+func (c Completeness) of(samples, stations int) (int, float64, bool) {
+	expected := c.perStation() * max(stations, 1)
+	percent := min(100, 100*float64(samples)/float64(expected))
+	return expected, percent, percent < c.Threshold
+}
+
+// sql returns expressions for the expected_samples, completeness and
+// incomplete columns of an hour with samples readings from stations
+func (c Completeness) sql(samples, stations string) []string {
+	expected := fmt.Sprintf("(%d * %s)", c.perStation(), stations)
+	percent := "CASE WHEN " + samples + " >= " + expected + " THEN 100 ELSE 100.0 * " + samples + " / " + expected + " END"
+	return []string{
+		expected,
+		percent,
+		fmt.Sprintf("(%s) < %g", percent, c.Threshold),
+	}
+}
+
+// completenessColumns are the hourly_metrics columns Completeness.sql
+// fills
+var completenessColumns = []string{"expected_samples", "completeness", "incomplete"}
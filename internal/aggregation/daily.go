@@ -1,7 +1,10 @@
 package aggregation
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/smukkama/weather-server/internal/database"
@@ -9,104 +12,207 @@ import (
 
 // DailyAggregator performs daily aggregation
 type DailyAggregator struct {
-	db *database.DB
+	db             *database.DB
+	excludeFlagged bool
+	degreeDayBase  float64
 }
 
+// DefaultDegreeDayBase is the base temperature of heating and cooling
+// degree days, in °C (65°F)
+const DefaultDegreeDayBase = 18.3
+
 // NewDailyAggregator creates a new daily aggregator
 func NewDailyAggregator(db *database.DB) *DailyAggregator {
-	return &DailyAggregator{db: db}
+	return &DailyAggregator{db: db, degreeDayBase: DefaultDegreeDayBase}
+}
+
+// SetDegreeDayBase sets the base temperature, in °C, that a day's mean
+// temperature is measured against for heating and cooling degree days
+func (d *DailyAggregator) SetDegreeDayBase(base float64) {
+	d.degreeDayBase = base
 }
 
-// Aggregate performs daily aggregation for the specified date
-func (d *DailyAggregator) Aggregate(targetDate time.Time) error {
+// SetExcludeFlagged leaves measurements flagged by the data-quality checks
+// out of the percentiles and standard deviations, which are computed from
+// raw_metrics; the extremes come from hourly_metrics, which already leaves
+// them out
+func (d *DailyAggregator) SetExcludeFlagged(exclude bool) {
+	d.excludeFlagged = exclude
+}
+
+// Aggregate performs daily aggregation for the specified date, recording
+// the run in aggregation_runs
+func (d *DailyAggregator) Aggregate(ctx context.Context, targetDate time.Time) error {
 	// Truncate to beginning of day
 	date := targetDate.Truncate(24 * time.Hour)
+	return track(ctx, d.db, database.AggregationDaily, date, date.AddDate(0, 0, 1), d.aggregate)
+}
 
+// aggregate summarises the day starting at date into daily_summary and
+// rolls it up by region, returning how many zipcodes it wrote
+func (d *DailyAggregator) aggregate(ctx context.Context, date, _ time.Time) (int64, error) {
 	fmt.Printf("Running daily aggregation for %s\n", date.Format("2006-01-02"))
 
-	query := `
+	// SQLite has no date type; its dates are 'YYYY-MM-DD' text
+	dateArg := func(date time.Time) any {
+		if d.db.Driver() == database.DriverSQLite {
+			return date.Format("2006-01-02")
+		}
+		return date
+	}
+
+	tx, err := d.db.BeginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, d.query(), dateArg(date), date, date.AddDate(0, 0, 1), d.excludeFlagged)
+	if err != nil {
+		return 0, fmt.Errorf("failed to aggregate daily data: %w", err)
+	}
+
+	// The running total of the day and the rest of its year, which a day
+	// summarised again changes
+	yearStart := time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := tx.ExecContext(ctx, d.yearToDateQuery(), dateArg(date), dateArg(yearStart), dateArg(yearStart.AddDate(1, 0, 0))); err != nil {
+		return 0, fmt.Errorf("failed to total precipitation year to date: %w", err)
+	}
+	if _, err := rollupDay(ctx, tx, d.day(), dateArg(date)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit daily aggregation: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	fmt.Printf("Daily aggregation completed: %d zipcodes processed\n", rowsAffected)
+
+	return rowsAffected, nil
+}
+
+// query summarises the day $1, running from $2 to $3, into daily_summary.
+//
+// The extremes are the lowest and highest of the day's hourly extremes,
+// which skip NULLs (hours without readings for a metric); an hour copied
+// from the continuous aggregate has only its average, which stands in for
+// both. The percentiles, standard deviations and most common wind direction
+// are over the day's readings, leaving flagged ones out when $4 is set. If
+// raw_metrics no longer has the day's readings, the ones summarised before
+// are kept.
+//
+// Degree days measure the day's mean temperature, halfway between its
+// extremes, against the base temperature. The precipitation total adds up
+// the hourly averages, each an hour's rate in mm.
+func (d *DailyAggregator) query() string {
+	sql := newAggregateSQL(d.db.Driver(), "$4")
+	day := d.day()
+
+	var extremes, spread []string
+	for _, m := range database.AggregateMetrics {
+		extremes = append(extremes,
+			"MIN(COALESCE(min_"+m.Name+", avg_"+m.Name+")) AS min_"+m.Name,
+			"MAX(COALESCE(max_"+m.Name+", avg_"+m.Name+")) AS max_"+m.Name)
+		spread = append(spread, sql.spread(m.Name, m.Column)...)
+	}
+	spread = append(spread, sql.modeWindDirection())
+
+	extremes = append(extremes, "SUM(avg_precip) AS total_precip")
+
+	// A NULL mean falls through to the ELSE, so a day without temperatures
+	// has no degree days
+	base := strconv.FormatFloat(d.degreeDayBase, 'f', -1, 64)
+	mean := "(h.min_temp + h.max_temp) / 2"
+	fromHours := append(metricColumns("min_", "max_"), "total_precip")
+	degreeDays := []string{"heating_degree_days", "cooling_degree_days"}
+	spreads := append(metricColumns("p50_", "p95_", "stddev_"), "mode_wind_direction")
+	columns := slices.Concat(fromHours, degreeDays, spreads)
+	values := slices.Concat(prefixed("h.", fromHours), []string{
+		"CASE WHEN " + mean + " >= " + base + " THEN 0 ELSE " + base + " - " + mean + " END",
+		"CASE WHEN " + mean + " <= " + base + " THEN 0 ELSE " + mean + " - " + base + " END",
+	}, prefixed("s.", spreads))
+	var updates []string
+	for _, column := range slices.Concat(fromHours, degreeDays) {
+		updates = append(updates, column+" = EXCLUDED."+column)
+	}
+	for _, column := range spreads {
+		updates = append(updates, column+" = COALESCE(EXCLUDED."+column+", daily_summary."+column+")")
+	}
+
+	return `
+		WITH hours AS (
+			SELECT
+				zipcode,
+				` + sqlList(extremes) + `
+			FROM hourly_metrics
+			WHERE hour_timestamp >= $2 AND hour_timestamp < $3
+			GROUP BY zipcode
+		), spread AS (
+			SELECT
+				zipcode,
+				` + sqlList(spread) + `
+			FROM raw_metrics
+			WHERE timestamp >= $2 AND timestamp < $3
+			GROUP BY zipcode
+		)
 		INSERT INTO daily_summary (
 			zipcode, date,
-			min_temp, max_temp,
-			min_humidity, max_humidity,
-			min_precip, max_precip,
-			min_wind, max_wind,
-			min_pollution, max_pollution,
-			min_pollen, max_pollen
+				` + sqlList(columns) + `
 		)
 		SELECT
-			zipcode,
-			$1::date AS date,
-			MIN(avg_temp) AS min_temp,
-			MAX(avg_temp) AS max_temp,
-			MIN(avg_humidity) AS min_humidity,
-			MAX(avg_humidity) AS max_humidity,
-			MIN(avg_precip) AS min_precip,
-			MAX(avg_precip) AS max_precip,
-			MIN(avg_wind) AS min_wind,
-			MAX(avg_wind) AS max_wind,
-			MIN(avg_pollution) AS min_pollution,
-			MAX(avg_pollution) AS max_pollution,
-			MIN(avg_pollen) AS min_pollen,
-			MAX(avg_pollen) AS max_pollen
-		FROM
-			hourly_metrics
-		WHERE
-			DATE(hour_timestamp) = $1::date
-		GROUP BY
-			zipcode
+			h.zipcode, ` + day + `,
+				` + sqlList(values) + `
+		FROM hours h
+		LEFT JOIN spread s ON s.zipcode = h.zipcode
+		WHERE true
 		ON CONFLICT (zipcode, date) DO UPDATE
 		SET
-			min_temp = EXCLUDED.min_temp,
-			max_temp = EXCLUDED.max_temp,
-			min_humidity = EXCLUDED.min_humidity,
-			max_humidity = EXCLUDED.max_humidity,
-			min_precip = EXCLUDED.min_precip,
-			max_precip = EXCLUDED.max_precip,
-			min_wind = EXCLUDED.min_wind,
-			max_wind = EXCLUDED.max_wind,
-			min_pollution = EXCLUDED.min_pollution,
-			max_pollution = EXCLUDED.max_pollution,
-			min_pollen = EXCLUDED.min_pollen,
-			max_pollen = EXCLUDED.max_pollen
+				` + sqlList(updates) + `
 	`
+}
 
-	result, err := d.db.Exec(query, date)
-	if err != nil {
-		return fmt.Errorf("failed to aggregate daily data: %w", err)
+// day is the query parameter $1 as a date
+func (d *DailyAggregator) day() string {
+	if d.db.Driver() == database.DriverSQLite {
+		return "$1"
 	}
+	return "$1::date"
+}
 
-	rowsAffected, _ := result.RowsAffected()
-	fmt.Printf("Daily aggregation completed: %d zipcodes processed\n", rowsAffected)
-
-	return nil
+// yearToDateQuery sets precip_year_to_date on the days from $1 up to $3,
+// the next January 1st, to the total since January 1st, $2
+func (d *DailyAggregator) yearToDateQuery() string {
+	date := func(param string) string {
+		if d.db.Driver() == database.DriverSQLite {
+			return param
+		}
+		return param + "::date"
+	}
+	return `
+		UPDATE daily_summary
+		SET precip_year_to_date = (
+			SELECT SUM(p.total_precip)
+			FROM daily_summary p
+			WHERE p.zipcode = daily_summary.zipcode
+			  AND p.date >= ` + date("$2") + ` AND p.date <= daily_summary.date
+		)
+		WHERE date >= ` + date("$1") + ` AND date < ` + date("$3") + `
+	`
 }
 
 // AggregatePreviousDay aggregates the previous full day
-func (d *DailyAggregator) AggregatePreviousDay() error {
+func (d *DailyAggregator) AggregatePreviousDay(ctx context.Context) error {
 	now := time.Now()
 	yesterday := now.AddDate(0, 0, -1).Truncate(24 * time.Hour)
-	return d.Aggregate(yesterday)
+	return d.Aggregate(ctx, yesterday)
 }
 
-// CalculateNextRunTime calculates when the daily aggregation should next run
-// It runs at a specific time each day (e.g., 00:05:00)
-func (d *DailyAggregator) CalculateNextRunTime(timeOfDay string) (time.Time, error) {
-	now := time.Now()
-
-	// Parse time of day (format: "HH:MM")
+// Schedule returns when the daily aggregation runs as a cron expression: at
+// timeOfDay ("HH:MM") local time each day, e.g. 00:05
+func (d *DailyAggregator) Schedule(timeOfDay string) (string, error) {
 	var hour, minute int
 	if _, err := fmt.Sscanf(timeOfDay, "%d:%d", &hour, &minute); err != nil {
-		return time.Time{}, fmt.Errorf("invalid time format: %s (expected HH:MM)", timeOfDay)
+		return "", fmt.Errorf("invalid time format: %s (expected HH:MM)", timeOfDay)
 	}
-
-	// Today's run time
-	todayRun := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
-
-	// If we're past today's run time, schedule for tomorrow
-	if now.After(todayRun) {
-		return todayRun.AddDate(0, 0, 1), nil
-	}
-
-	return todayRun, nil
+	return fmt.Sprintf("%d %d * * *", minute, hour), nil
 }
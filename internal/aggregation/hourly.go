@@ -1,7 +1,10 @@
 package aggregation
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/smukkama/weather-server/internal/database"
@@ -9,43 +12,134 @@ import (
 
 // HourlyAggregator performs hourly aggregation
 type HourlyAggregator struct {
-	db *database.DB
+	db             *database.DB
+	excludeFlagged bool
+	continuous     bool
+	completeness   Completeness
 }
 
 // NewHourlyAggregator creates a new hourly aggregator
 func NewHourlyAggregator(db *database.DB) *HourlyAggregator {
-	return &HourlyAggregator{db: db}
+	return &HourlyAggregator{db: db, completeness: DefaultCompleteness}
 }
 
-// Aggregate performs hourly aggregation for the specified hour
-func (h *HourlyAggregator) Aggregate(targetHour time.Time) error {
+// SetCompleteness sets how many readings an hour should have, and how few
+// mark it incomplete
+func (h *HourlyAggregator) SetCompleteness(c Completeness) {
+	h.completeness = c
+}
+
+// SetExcludeFlagged leaves measurements flagged by the data-quality checks
+// (spikes, stuck sensors) out of the averages
+func (h *HourlyAggregator) SetExcludeFlagged(exclude bool) {
+	h.excludeFlagged = exclude
+}
+
+// SetContinuous copies hourly averages from the raw_metrics_hourly
+// TimescaleDB continuous aggregate instead of computing them from
+// raw_metrics. The aggregate always leaves flagged measurements out.
+func (h *HourlyAggregator) SetContinuous(continuous bool) {
+	h.continuous = continuous
+}
+
+// Aggregate performs hourly aggregation for the specified hour, recording
+// the run in aggregation_runs
+func (h *HourlyAggregator) Aggregate(ctx context.Context, targetHour time.Time) error {
 	// Truncate to the beginning of the hour
 	startTime := targetHour.Truncate(time.Hour)
-	endTime := startTime.Add(time.Hour)
+	return track(ctx, h.db, database.AggregationHourly, startTime, startTime.Add(time.Hour), h.aggregate)
+}
 
+// aggregate averages the hour from startTime to endTime into
+// hourly_metrics and rolls it up by region, returning how many zipcodes it
+// wrote
+func (h *HourlyAggregator) aggregate(ctx context.Context, startTime, endTime time.Time) (int64, error) {
 	fmt.Printf("Running hourly aggregation for %s\n", startTime.Format("2006-01-02 15:04:05"))
+	var rowsAffected int64
+	if h.continuous {
+		n, err := h.copyContinuous(ctx, startTime, endTime)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected = n
+	} else {
+		result, err := h.db.ExecContext(ctx, h.query(), startTime, endTime, h.excludeFlagged)
+		if err != nil {
+			return 0, fmt.Errorf("failed to aggregate hourly data: %w", err)
+		}
+		rowsAffected, _ = result.RowsAffected()
+		fmt.Printf("Hourly aggregation completed: %d zipcodes processed\n", rowsAffected)
+	}
+
+	if _, err := rollupHour(ctx, h.db, startTime, endTime); err != nil {
+		return rowsAffected, err
+	}
+	return rowsAffected, nil
+}
+
+// query aggregates the hour from $1 to $2 into hourly_metrics, by
+// zipcodeAggregatesSQL
+func (h *HourlyAggregator) query() string {
+	averages := metricColumns("")
+	stats := append(metricColumns("min_", "max_", "p50_", "p95_", "stddev_"), "mode_wind_direction")
+	columns := slices.Concat(prefixed("avg_", averages), stats, completenessColumns, []string{"sample_count"})
+	values := slices.Concat(prefixed("a.", averages), prefixed("s.", stats),
+		h.completeness.sql("a.samples", "a.stations"), []string{"a.samples"})
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = column + " = EXCLUDED." + column
+	}
+
+	// SQLite needs the WHERE to tell the upsert's ON CONFLICT from a join
+	// constraint
+	return `
+		WITH ` + zipcodeAggregatesSQL(h.db.Driver()) + `
+		INSERT INTO hourly_metrics (
+			zipcode, hour_timestamp,
+				` + sqlList(columns) + `
+		)
+		SELECT
+			a.zipcode, $1,
+				` + sqlList(values) + `
+		FROM averages a
+		JOIN spread s ON s.zipcode = a.zipcode
+		WHERE true
+		ON CONFLICT (zipcode, hour_timestamp) DO UPDATE
+		SET
+				` + sqlList(updates) + `
+	`
+}
+
+// copyContinuous brings the hour up to date in the continuous aggregate,
+// which may not have seen late readings yet, and copies it into
+// hourly_metrics. The aggregate has averages only; the extremes,
+// percentiles and standard deviations are left NULL. It doesn't count
+// stations either, so its hours expect one station's readings.
+func (h *HourlyAggregator) copyContinuous(ctx context.Context, startTime, endTime time.Time) (int64, error) {
+	// Can't run in a transaction, so it isn't part of the copy below
+	if _, err := h.db.ExecContext(ctx, "CALL refresh_continuous_aggregate('raw_metrics_hourly', $1::timestamptz, $2::timestamptz)", startTime, endTime); err != nil {
+		return 0, fmt.Errorf("failed to refresh continuous aggregate: %w", err)
+	}
 
+	completeness := h.completeness.sql("sample_count", "1")
 	query := `
 		INSERT INTO hourly_metrics (
 			zipcode, hour_timestamp, avg_temp, avg_humidity, avg_precip,
-			avg_wind, avg_pollution, avg_pollen, sample_count
+			avg_wind, avg_pollution, avg_pollen,
+			avg_pressure, avg_visibility, avg_uv_index, avg_snow_depth,
+			avg_heat_index, avg_wind_chill, avg_dew_point, sample_count,
+			expected_samples, completeness, incomplete
 		)
 		SELECT
-			zipcode,
-			$1 AS hour_timestamp,
-			AVG(temperature) AS avg_temp,
-			AVG(humidity) AS avg_humidity,
-			AVG(precipitation) AS avg_precip,
-			AVG(wind_speed) AS avg_wind,
-			AVG(pollution_index) AS avg_pollution,
-			AVG(pollen_index) AS avg_pollen,
-			COUNT(*) AS sample_count
+			zipcode, hour_timestamp, avg_temp, avg_humidity, avg_precip,
+			avg_wind, avg_pollution, avg_pollen,
+			avg_pressure, avg_visibility, avg_uv_index, avg_snow_depth,
+			avg_heat_index, avg_wind_chill, avg_dew_point, sample_count,
+			` + strings.Join(completeness, ",\n\t\t\t") + `
 		FROM
-			raw_metrics
+			raw_metrics_hourly
 		WHERE
-			timestamp >= $1 AND timestamp < $2
-		GROUP BY
-			zipcode
+			hour_timestamp = $1
 		ON CONFLICT (zipcode, hour_timestamp) DO UPDATE
 		SET
 			avg_temp = EXCLUDED.avg_temp,
@@ -54,42 +148,60 @@ func (h *HourlyAggregator) Aggregate(targetHour time.Time) error {
 			avg_wind = EXCLUDED.avg_wind,
 			avg_pollution = EXCLUDED.avg_pollution,
 			avg_pollen = EXCLUDED.avg_pollen,
-			sample_count = EXCLUDED.sample_count
+			avg_pressure = EXCLUDED.avg_pressure,
+			avg_visibility = EXCLUDED.avg_visibility,
+			avg_uv_index = EXCLUDED.avg_uv_index,
+			avg_snow_depth = EXCLUDED.avg_snow_depth,
+			avg_heat_index = EXCLUDED.avg_heat_index,
+			avg_wind_chill = EXCLUDED.avg_wind_chill,
+			avg_dew_point = EXCLUDED.avg_dew_point,
+			sample_count = EXCLUDED.sample_count,
+			expected_samples = EXCLUDED.expected_samples,
+			completeness = EXCLUDED.completeness,
+			incomplete = EXCLUDED.incomplete
 	`
 
-	result, err := h.db.Exec(query, startTime, endTime)
+	result, err := h.db.ExecContext(ctx, query, startTime)
 	if err != nil {
-		return fmt.Errorf("failed to aggregate hourly data: %w", err)
+		return 0, fmt.Errorf("failed to copy hourly data from continuous aggregate: %w", err)
 	}
 
 	rowsAffected, _ := result.RowsAffected()
-	fmt.Printf("Hourly aggregation completed: %d zipcodes processed\n", rowsAffected)
+	fmt.Printf("Hourly aggregation completed from continuous aggregate: %d zipcodes processed\n", rowsAffected)
 
-	return nil
+	return rowsAffected, nil
 }
 
 // AggregatePreviousHour aggregates the previous full hour
-func (h *HourlyAggregator) AggregatePreviousHour() error {
+func (h *HourlyAggregator) AggregatePreviousHour(ctx context.Context) error {
 	now := time.Now()
 	previousHour := now.Add(-1 * time.Hour).Truncate(time.Hour)
-	return h.Aggregate(previousHour)
+	return h.Aggregate(ctx, previousHour)
 }
 
-// CalculateNextRunTime calculates when the hourly aggregation should next run
-// It runs at HH:05:00 (5 minutes past each hour)
-func (h *HourlyAggregator) CalculateNextRunTime(delay time.Duration) time.Time {
-	now := time.Now()
-
-	// Next hour
-	nextHour := now.Truncate(time.Hour).Add(time.Hour)
-
-	// Add delay (e.g., 5 minutes past the hour)
-	nextRun := nextHour.Add(delay)
-
-	// If we're past the next run time, add another hour
-	if now.After(nextRun) {
-		nextRun = nextRun.Add(time.Hour)
+// Schedule returns when the hourly aggregation runs as a cron expression:
+// delay past every hour (e.g. HH:05:00), once the hour's readings have arrived
+func (h *HourlyAggregator) Schedule(delay time.Duration) (string, error) {
+	if delay < 0 || delay >= time.Hour || delay%time.Minute != 0 {
+		return "", fmt.Errorf("invalid hourly delay: %s (expected whole minutes under an hour)", delay)
 	}
+	return fmt.Sprintf("%d * * * *", int(delay.Minutes())), nil
+}
 
-	return nextRun
+// flaggedSQL returns a function writing the condition that quality_flags
+// flags any of the given metrics
+func flaggedSQL(driver string) func(metrics ...string) string {
+	return func(metrics ...string) string {
+		if driver == database.DriverSQLite {
+			conditions := make([]string, len(metrics))
+			for i, metric := range metrics {
+				conditions[i] = "json_extract(quality_flags, '$." + metric + "') IS NOT NULL"
+			}
+			return "(" + strings.Join(conditions, " OR ") + ")"
+		}
+		if len(metrics) == 1 {
+			return "quality_flags ? '" + metrics[0] + "'"
+		}
+		return "quality_flags ?| ARRAY['" + strings.Join(metrics, "', '") + "']"
+	}
 }
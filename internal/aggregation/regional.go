@@ -0,0 +1,130 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// regionNames are the region types zipcodes are rolled up into, and the
+// expression naming a location's region of each type. It is NULL for a
+// location without one, which leaves it out of that rollup.
+var regionNames = []struct{ Type, Name string }{
+	{database.RegionCity, "CASE WHEN l.city_name <> '' THEN l.city_name || ', ' || l.state END"},
+	{database.RegionCounty, "l.county || ', ' || l.state"},
+	{database.RegionState, "l.state"},
+}
+
+// regionsSQL selects the aggregates of source's rows matching where,
+// aliased m, by the regions of their zipcodes
+func regionsSQL(source, where string, aggregates []string) string {
+	parts := make([]string, len(regionNames))
+	for i, r := range regionNames {
+		parts[i] = `
+			SELECT
+				'` + r.Type + `' AS region_type,
+				` + r.Name + ` AS region,
+				` + sqlList(aggregates) + `
+			FROM ` + source + ` m
+			JOIN locations l ON l.zipcode = m.zipcode
+			WHERE ` + where + ` AND ` + r.Name + ` IS NOT NULL
+			GROUP BY ` + r.Name
+	}
+	return strings.Join(parts, "\n\t\t\tUNION ALL")
+}
+
+// rollupHour replaces the regional aggregates of the hour from start to
+// end with ones averaging its zipcodes' hourly_metrics, each zipcode
+// counting equally, and returns how many regions it wrote. An hour copied
+// from the continuous aggregate has only averages, which stand in for its
+// extremes.
+func rollupHour(ctx context.Context, db *database.DB, start, end time.Time) (int64, error) {
+	aggregates := []string{"COUNT(*) AS zipcode_count", "SUM(m.sample_count) AS sample_count"}
+	for _, m := range database.AggregateMetrics {
+		aggregates = append(aggregates,
+			"AVG(m.avg_"+m.Name+") AS avg_"+m.Name,
+			"MIN(COALESCE(m.min_"+m.Name+", m.avg_"+m.Name+")) AS min_"+m.Name,
+			"MAX(COALESCE(m.max_"+m.Name+", m.avg_"+m.Name+")) AS max_"+m.Name)
+	}
+	columns := slices.Concat(metricColumns("avg_", "min_", "max_"), []string{"zipcode_count", "sample_count"})
+	query := `
+		WITH regions AS (` + regionsSQL("hourly_metrics", "m.hour_timestamp >= $1 AND m.hour_timestamp < $2", aggregates) + `
+		)
+		INSERT INTO regional_hourly_metrics (
+			region_type, region, hour_timestamp,
+				` + sqlList(columns) + `
+		)
+		SELECT
+			region_type, region, $1,
+				` + sqlList(columns) + `
+		FROM regions
+	`
+
+	tx, err := db.BeginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Replaced rather than upserted, so a zipcode moved to another region
+	// leaves its old one
+	if _, err := tx.ExecContext(ctx, "DELETE FROM regional_hourly_metrics WHERE hour_timestamp >= $1 AND hour_timestamp < $2", start, end); err != nil {
+		return 0, fmt.Errorf("failed to clear regional hourly aggregates: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, query, start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up hourly aggregates by region: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit regional hourly aggregates: %w", err)
+	}
+
+	regions, _ := result.RowsAffected()
+	fmt.Printf("Regional hourly rollup completed: %d regions processed\n", regions)
+	return regions, nil
+}
+
+// rollupDay replaces the regional summaries of the day $1 in tx with ones
+// over its zipcodes' daily_summary, and returns how many regions it wrote.
+// day is $1 as a date in the driver's dialect.
+func rollupDay(ctx context.Context, tx *database.Tx, day string, dateArg any) (int64, error) {
+	aggregates := []string{"COUNT(*) AS zipcode_count"}
+	for _, m := range database.AggregateMetrics {
+		aggregates = append(aggregates,
+			"MIN(m.min_"+m.Name+") AS min_"+m.Name,
+			"MAX(m.max_"+m.Name+") AS max_"+m.Name)
+	}
+	totals := []string{"heating_degree_days", "cooling_degree_days", "total_precip", "precip_year_to_date"}
+	for _, column := range totals {
+		aggregates = append(aggregates, "AVG(m."+column+") AS "+column)
+	}
+	columns := slices.Concat(metricColumns("min_", "max_"), totals, []string{"zipcode_count"})
+	query := `
+		WITH regions AS (` + regionsSQL("daily_summary", "m.date = "+day, aggregates) + `
+		)
+		INSERT INTO regional_daily_summary (
+			region_type, region, date,
+				` + sqlList(columns) + `
+		)
+		SELECT
+			region_type, region, ` + day + `,
+				` + sqlList(columns) + `
+		FROM regions
+	`
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM regional_daily_summary WHERE date = "+day, dateArg); err != nil {
+		return 0, fmt.Errorf("failed to clear regional daily summaries: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, query, dateArg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up daily summaries by region: %w", err)
+	}
+
+	regions, _ := result.RowsAffected()
+	fmt.Printf("Regional daily rollup completed: %d regions processed\n", regions)
+	return regions, nil
+}
@@ -0,0 +1,65 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// abandonedRunAfter is how long a run may be marked running before it is
+// taken to have died with its aggregator, and its window may run again
+const abandonedRunAfter = 2 * time.Hour
+
+// track runs aggregate on the window from start to end, recording the run
+// and its outcome in aggregation_runs. It skips the window, returning nil,
+// if another run of it is in progress.
+func track(ctx context.Context, db *database.DB, kind string, start, end time.Time, aggregate func(ctx context.Context, start, end time.Time) (int64, error)) error {
+	id, err := db.StartAggregationRun(ctx, kind, start, end, time.Now().Add(-abandonedRunAfter))
+	if errors.Is(err, database.ErrAggregationRunning) {
+		fmt.Printf("Skipping %s aggregation of %s: another run is in progress\n", kind, start.Format("2006-01-02 15:04"))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record %s aggregation run: %w", kind, err)
+	}
+
+	rows, runErr := aggregate(ctx, start, end)
+
+	// Record the outcome even when the run was cancelled or timed out
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := db.FinishAggregationRun(finishCtx, id, rows, runErr); err != nil {
+		fmt.Printf("Failed to record the end of aggregation run %d: %v\n", id, err)
+	}
+	return runErr
+}
+
+// Retry aggregates again the windows since since whose latest run failed,
+// or that an operator asked for with a pending run, hours before days. It
+// carries on past windows that fail again and returns how many succeeded.
+func Retry(ctx context.Context, hourly *HourlyAggregator, daily *DailyAggregator, since time.Time) (int, error) {
+	runs, err := hourly.db.GetAggregationRetries(ctx, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find aggregation runs to retry: %w", err)
+	}
+
+	var retried int
+	var errs []error
+	for _, run := range runs {
+		fmt.Printf("Retrying %s aggregation of %s (last run %s)\n", run.Kind, run.WindowStart.Format("2006-01-02 15:04"), run.Status)
+		if run.Kind == database.AggregationDaily {
+			err = daily.Aggregate(ctx, run.WindowStart)
+		} else {
+			err = hourly.Aggregate(ctx, run.WindowStart)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		retried++
+	}
+	return retried, errors.Join(errs...)
+}
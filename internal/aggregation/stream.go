@@ -0,0 +1,424 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/quality"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// StreamAggregator computes hourly aggregates from the metrics topic as
+// readings arrive, instead of querying raw_metrics once the hour is over.
+// It keeps a window per zipcode and hour in memory, writes the open
+// windows to hourly_metrics every flush interval, and closes a window,
+// writing it a last time as an aggregation run, once readings are lateness
+// past the end of its hour.
+//
+// Messages are committed only once the windows they went into are closed,
+// so after a restart the open windows are rebuilt from the messages
+// redelivered. Readings for a window already closed are counted as late and
+// left out; an hour can be aggregated again from raw_metrics with a pending
+// aggregation run.
+type StreamAggregator struct {
+	db            *database.DB
+	consumer      queue.Consumer
+	lateness      time.Duration
+	flushInterval time.Duration
+	completeness  Completeness
+
+	// Optional data-quality scoring; flagged measurements are left out
+	quality *quality.Checker
+
+	mu        sync.Mutex
+	windows   map[windowKey]*window
+	pending   []pendingMessage // uncommitted, in the order they were read
+	watermark time.Time        // newest reading time seen, up to the clock
+	lastRead  time.Time
+	late      int64
+}
+
+// windowKey identifies a zipcode's hour
+type windowKey struct {
+	zipcode string
+	hour    time.Time
+}
+
+// pendingMessage is a message read but not yet committed, and the window
+// it went into, if any
+type pendingMessage struct {
+	msg    queue.Message
+	window *windowKey
+}
+
+// NewStreamAggregator creates a stream aggregator reading from consumer
+func NewStreamAggregator(db *database.DB, consumer queue.Consumer, lateness, flushInterval time.Duration) *StreamAggregator {
+	return &StreamAggregator{
+		db:            db,
+		consumer:      consumer,
+		lateness:      lateness,
+		flushInterval: flushInterval,
+		completeness:  DefaultCompleteness,
+		windows:       make(map[windowKey]*window),
+	}
+}
+
+// SetQualityChecker scores readings like the DB writer does and leaves the
+// measurements it flags out of the aggregates. It sees the same readings
+// in the same order, so it flags the same measurements.
+func (s *StreamAggregator) SetQualityChecker(checker *quality.Checker) {
+	s.quality = checker
+}
+
+// SetCompleteness sets how many readings an hour should have, and how few
+// mark it incomplete
+func (s *StreamAggregator) SetCompleteness(c Completeness) {
+	s.completeness = c
+}
+
+// Run consumes readings and writes their aggregates until ctx is cancelled,
+// then writes the open windows a last time
+func (s *StreamAggregator) Run(ctx context.Context) {
+	var consuming sync.WaitGroup
+	consuming.Add(1)
+	go func() {
+		defer consuming.Done()
+		for {
+			msg, err := s.consumer.Consume(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// Don't log EOF errors (happens when no messages available)
+				if err.Error() != "failed to fetch message: EOF" {
+					log.Printf("Failed to consume message: %v\n", err)
+				}
+				continue
+			}
+			s.add(msg)
+		}
+	}()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			consuming.Wait()
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+			defer cancel()
+			s.flush(flushCtx, time.Now())
+			return
+		case now := <-ticker.C:
+			s.flush(ctx, now)
+		}
+	}
+}
+
+// add puts a message's reading into its window
+func (s *StreamAggregator) add(msg queue.Message) {
+	metricMsg, err := protocol.DecodeMetricMessage(msg.Value)
+	if err != nil {
+		log.Printf("Skipping message: failed to decode message: %v\n", err)
+		s.mu.Lock()
+		s.pending = append(s.pending, pendingMessage{msg: msg})
+		s.mu.Unlock()
+		return
+	}
+	parsed, err := metricMsg.Data.Parse()
+	if err != nil {
+		log.Printf("Skipping message: failed to parse metric data: %v\n", err)
+		s.mu.Lock()
+		s.pending = append(s.pending, pendingMessage{msg: msg})
+		s.mu.Unlock()
+		return
+	}
+	// Scored even when late, to keep the station's history in step with
+	// the DB writer's
+	flags := s.quality.Check(metricMsg.Zipcode+"/"+metricMsg.StationID, parsed.Timestamp, &metricMsg.Data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.lastRead = now
+	if parsed.Timestamp.After(s.watermark) && !parsed.Timestamp.After(now) {
+		s.watermark = parsed.Timestamp
+	}
+
+	key := windowKey{metricMsg.Zipcode, parsed.Timestamp.Truncate(time.Hour)}
+	if s.closed(key.hour) {
+		s.late++
+		fmt.Printf("Late reading from zipcode %s for %s left out of the hourly aggregates\n", key.zipcode, key.hour.Format("2006-01-02 15:04"))
+		s.pending = append(s.pending, pendingMessage{msg: msg})
+		return
+	}
+	w, ok := s.windows[key]
+	if !ok {
+		w = newWindow()
+		s.windows[key] = w
+	}
+	w.add(metricMsg, flags)
+	s.pending = append(s.pending, pendingMessage{msg: msg, window: &key})
+}
+
+// closed reports whether the hour's window takes no more readings. s.mu
+// must be held.
+func (s *StreamAggregator) closed(hour time.Time) bool {
+	return !hour.Add(time.Hour + s.lateness).After(s.watermark)
+}
+
+// flush writes the open windows with new readings, closes the windows due,
+// and commits the messages whose windows are all closed. Windows close by
+// the newest reading's time, so a backlog replayed after a restart closes
+// them as it goes; once no reading has arrived for a flush interval, the
+// consumer has caught up and the clock closes them instead.
+func (s *StreamAggregator) flush(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastRead) >= s.flushInterval && now.After(s.watermark) {
+		s.watermark = now
+	}
+	var open []*database.HourlyMetric
+	var openKeys []windowKey
+	closing := make(map[time.Time][]*database.HourlyMetric)
+	for key, w := range s.windows {
+		switch {
+		case s.closed(key.hour):
+			closing[key.hour] = append(closing[key.hour], w.metric(key, s.completeness))
+		case w.dirty:
+			open = append(open, w.metric(key, s.completeness))
+			openKeys = append(openKeys, key)
+			w.dirty = false
+		}
+	}
+	s.mu.Unlock()
+
+	if len(open) > 0 {
+		if err := s.db.UpsertHourlyMetrics(ctx, open); err != nil {
+			log.Printf("Failed to write open hourly windows: %v\n", err)
+			s.mu.Lock()
+			for _, key := range openKeys {
+				if w, ok := s.windows[key]; ok {
+					w.dirty = true
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	hours := make([]time.Time, 0, len(closing))
+	for hour := range closing {
+		hours = append(hours, hour)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+	for _, hour := range hours {
+		metrics := closing[hour]
+		err := track(ctx, s.db, database.AggregationHourly, hour, hour.Add(time.Hour), func(ctx context.Context, _, _ time.Time) (int64, error) {
+			if err := s.db.UpsertHourlyMetrics(ctx, metrics); err != nil {
+				return 0, err
+			}
+			if _, err := rollupHour(ctx, s.db, hour, hour.Add(time.Hour)); err != nil {
+				return int64(len(metrics)), err
+			}
+			return int64(len(metrics)), nil
+		})
+		if err != nil {
+			// Kept, and written again at the next flush
+			log.Printf("Failed to write hourly aggregates for %s: %v\n", hour.Format("2006-01-02 15:04"), err)
+			continue
+		}
+		fmt.Printf("Streamed hourly aggregation completed for %s: %d zipcodes processed\n", hour.Format("2006-01-02 15:04"), len(metrics))
+		s.mu.Lock()
+		for _, m := range metrics {
+			delete(s.windows, windowKey{m.Zipcode, hour})
+		}
+		s.mu.Unlock()
+	}
+
+	s.commit(ctx)
+}
+
+// commit commits the messages read before any still in an open window
+func (s *StreamAggregator) commit(ctx context.Context) {
+	s.mu.Lock()
+	n := 0
+	for ; n < len(s.pending); n++ {
+		if key := s.pending[n].window; key != nil && s.windows[*key] != nil {
+			break
+		}
+	}
+	// Committing a Kafka message commits the partition up to it, so only
+	// the last of each partition's messages needs committing
+	last := make(map[int]queue.Message)
+	var partitions []int
+	for _, p := range s.pending[:n] {
+		if _, ok := last[p.msg.Partition]; !ok {
+			partitions = append(partitions, p.msg.Partition)
+		}
+		last[p.msg.Partition] = p.msg
+	}
+	s.pending = slices.Clone(s.pending[n:])
+	s.mu.Unlock()
+
+	for _, partition := range partitions {
+		if err := s.consumer.Commit(ctx, last[partition]); err != nil {
+			log.Printf("Failed to commit offset: %v\n", err)
+		}
+	}
+}
+
+// Late returns how many readings arrived after their window had closed
+func (s *StreamAggregator) Late() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.late
+}
+
+// window accumulates a zipcode's readings for an hour
+type window struct {
+	stations   map[string]*stationSums
+	values     [][]float64 // every reading of each of database.AggregateMetrics
+	directions map[string]int
+	samples    int
+	dirty      bool // has readings not yet written
+}
+
+// stationSums totals one station's readings of each metric, so stations
+// can be averaged first and then count equally, as the hourly query does
+type stationSums struct {
+	sums   []float64
+	counts []int
+}
+
+func newWindow() *window {
+	return &window{
+		stations:   make(map[string]*stationSums),
+		values:     make([][]float64, len(database.AggregateMetrics)),
+		directions: make(map[string]int),
+	}
+}
+
+// add records a reading, leaving out its flagged measurements, and derived
+// metrics any of whose inputs are flagged
+func (w *window) add(msg *protocol.MetricMessage, flags quality.Flags) {
+	station, ok := w.stations[msg.StationID]
+	if !ok {
+		station = &stationSums{
+			sums:   make([]float64, len(database.AggregateMetrics)),
+			counts: make([]int, len(database.AggregateMetrics)),
+		}
+		w.stations[msg.StationID] = station
+	}
+	for i, metric := range database.AggregateMetrics {
+		v := msg.Value(metric.Column)
+		if v == nil || isFlagged(flags, metric.Column) {
+			continue
+		}
+		station.sums[i] += *v
+		station.counts[i]++
+		w.values[i] = append(w.values[i], *v)
+	}
+	if d := msg.Data.WindDirection; d != nil {
+		w.directions[*d]++
+	}
+	w.samples++
+	w.dirty = true
+}
+
+// isFlagged reports whether a measurement, or any input of a derived
+// metric, is flagged
+func isFlagged(flags quality.Flags, column string) bool {
+	inputs, ok := derivedInputs[column]
+	if !ok {
+		inputs = []string{column}
+	}
+	for _, input := range inputs {
+		if _, ok := flags[input]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// metric returns the window's aggregates as an hourly_metrics row
+func (w *window) metric(key windowKey, completeness Completeness) *database.HourlyMetric {
+	m := &database.HourlyMetric{Zipcode: key.zipcode, HourTimestamp: key.hour, SampleCount: w.samples}
+	expected, percent, incomplete := completeness.of(w.samples, len(w.stations))
+	m.ExpectedSamples, m.Completeness, m.Incomplete = &expected, &percent, &incomplete
+	for i := range database.AggregateMetrics {
+		var sum float64
+		var stations int
+		for _, station := range w.stations {
+			if station.counts[i] > 0 {
+				sum += station.sums[i] / float64(station.counts[i])
+				stations++
+			}
+		}
+		var avg *float64
+		if stations > 0 {
+			avg = float(sum / float64(stations))
+		}
+		m.SetMetric(i, avg, metricStats(w.values[i]))
+	}
+
+	// The most common direction, the first alphabetically of those tied
+	// like Postgres mode()
+	best := 0
+	for direction, n := range w.directions {
+		if n > best || (n == best && direction < *m.ModeWindDirection) {
+			m.ModeWindDirection = &direction
+			best = n
+		}
+	}
+	return m
+}
+
+// metricStats returns the extremes and spread of a metric's readings, each
+// nil without readings and the standard deviation nil with only one
+func metricStats(values []float64) database.MetricStats {
+	if len(values) == 0 {
+		return database.MetricStats{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	stats := database.MetricStats{
+		Min: float(sorted[0]),
+		Max: float(sorted[len(sorted)-1]),
+		MetricSpread: database.MetricSpread{
+			P50: float(percentileCont(sorted, 0.5)),
+			P95: float(percentileCont(sorted, 0.95)),
+		},
+	}
+	if len(sorted) > 1 {
+		var mean, m2 float64
+		for i, v := range sorted {
+			delta := v - mean
+			mean += delta / float64(i+1)
+			m2 += delta * (v - mean)
+		}
+		stats.StdDev = float(math.Sqrt(m2 / float64(len(sorted)-1)))
+	}
+	return stats
+}
+
+// percentileCont interpolates the fraction-th percentile of sorted values
+// like Postgres percentile_cont
+func percentileCont(sorted []float64, fraction float64) float64 {
+	pos := fraction * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower == len(sorted)-1 {
+		return sorted[lower]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+func float(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,103 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// WindowAggregator aggregates each zipcode's readings over windows of a
+// configured length, e.g. 15 minutes or 6 hours, into windowed_metrics,
+// the way the hourly aggregator does hours. Windows are aligned to
+// multiples of their length since the zero time, in UTC. Its runs aren't
+// recorded in aggregation_runs, so a failed window is neither retried nor
+// backfilled.
+type WindowAggregator struct {
+	db             *database.DB
+	size           time.Duration
+	excludeFlagged bool
+}
+
+// NewWindowAggregator creates an aggregator of windows size long
+func NewWindowAggregator(db *database.DB, size time.Duration) *WindowAggregator {
+	return &WindowAggregator{db: db, size: size}
+}
+
+// Size is the length of the aggregator's windows
+func (w *WindowAggregator) Size() time.Duration {
+	return w.size
+}
+
+// SetExcludeFlagged leaves measurements flagged by the data-quality checks
+// out of the aggregates
+func (w *WindowAggregator) SetExcludeFlagged(exclude bool) {
+	w.excludeFlagged = exclude
+}
+
+// Aggregate aggregates the window containing target
+func (w *WindowAggregator) Aggregate(ctx context.Context, target time.Time) error {
+	start := target.Truncate(w.size)
+	fmt.Printf("Running %s window aggregation for %s\n", w.size, start.Format("2006-01-02 15:04:05"))
+
+	result, err := w.db.ExecContext(ctx, w.query(), start, start.Add(w.size), w.excludeFlagged)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate %s window: %w", w.size, err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	fmt.Printf("%s window aggregation completed: %d zipcodes processed\n", w.size, rowsAffected)
+	return nil
+}
+
+// AggregatePreviousWindow aggregates the last full window
+func (w *WindowAggregator) AggregatePreviousWindow(ctx context.Context) error {
+	return w.Aggregate(ctx, time.Now().Add(-w.size))
+}
+
+// query aggregates the window from $1 to $2 into windowed_metrics, by
+// zipcodeAggregatesSQL
+func (w *WindowAggregator) query() string {
+	averages := metricColumns("")
+	stats := append(metricColumns("min_", "max_", "p50_", "p95_", "stddev_"), "mode_wind_direction")
+	columns := slices.Concat(prefixed("avg_", averages), stats, []string{"sample_count"})
+	values := slices.Concat(prefixed("a.", averages), prefixed("s.", stats), []string{"a.samples"})
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = column + " = EXCLUDED." + column
+	}
+
+	// SQLite needs the WHERE to tell the upsert's ON CONFLICT from a join
+	// constraint
+	return `
+		WITH ` + zipcodeAggregatesSQL(w.db.Driver()) + `
+		INSERT INTO windowed_metrics (
+			zipcode, window_seconds, window_start, window_end,
+				` + sqlList(columns) + `
+		)
+		SELECT
+			a.zipcode, ` + strconv.FormatInt(int64(w.size.Seconds()), 10) + `, $1, $2,
+				` + sqlList(values) + `
+		FROM averages a
+		JOIN spread s ON s.zipcode = a.zipcode
+		WHERE true
+		ON CONFLICT (zipcode, window_seconds, window_start) DO UPDATE
+		SET
+				` + sqlList(updates) + `
+	`
+}
+
+// Schedule returns when the window aggregation runs as a recurrence:
+// delay past the end of every window, once its readings have arrived
+func (w *WindowAggregator) Schedule(delay time.Duration) (string, error) {
+	if w.size < time.Minute || w.size%time.Minute != 0 {
+		return "", fmt.Errorf("invalid aggregation window: %s (expected whole minutes)", w.size)
+	}
+	if delay < 0 || delay >= w.size {
+		return "", fmt.Errorf("invalid delay for the %s window: %s (expected less than the window)", w.size, delay)
+	}
+	return fmt.Sprintf("@every %s offset %s", w.size, delay), nil
+}
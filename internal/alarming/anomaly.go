@@ -0,0 +1,89 @@
+package alarming
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// anomalyMetric is the metric an anomaly rule's alarm state is kept and
+// logged under, apart from the metric's threshold
+func anomalyMetric(rule *database.AnomalyRule) string {
+	return rule.MetricName + "_anomaly"
+}
+
+// evaluateAnomaly runs an anomaly rule through the same states as a
+// threshold. Its threshold is the bound of its deviations from the
+// baseline on the side of the reading, so its notifications show e.g.
+// temperature_anomaly >= 31.4. A reading without the metric, or a
+// baseline too short to compare against, leaves the state unchanged.
+func (e *Evaluator) evaluateAnomaly(ctx context.Context, msg *protocol.MetricMessage, rule *database.AnomalyRule) error {
+	value := e.extractMetricValue(msg, rule.MetricName)
+	if value == nil {
+		return nil
+	}
+
+	now := time.Now()
+	baseline, err := e.getBaseline(ctx, msg.Zipcode, rule, now)
+	if err != nil {
+		return err
+	}
+	if baseline.Samples < rule.MinSamples {
+		return nil
+	}
+
+	threshold := &database.AlarmThreshold{
+		Zipcode:         msg.Zipcode,
+		MetricName:      anomalyMetric(rule),
+		Operator:        ">=",
+		ThresholdValue:  baseline.Mean + rule.Deviations*baseline.StdDev,
+		DurationMinutes: rule.DurationMinutes,
+		Severity:        rule.Severity,
+		IsActive:        true,
+	}
+	if *value < baseline.Mean {
+		threshold.Operator = "<="
+		threshold.ThresholdValue = baseline.Mean - rule.Deviations*baseline.StdDev
+	}
+
+	state, err := e.stateManager.GetState(ctx, msg.Zipcode, threshold.MetricName)
+	if err != nil {
+		return err
+	}
+	if baseline.StdDev > 0 && math.Abs(*value-baseline.Mean) >= rule.Deviations*baseline.StdDev {
+		return e.handleBreach(ctx, msg, threshold, *value, state, now)
+	}
+	return e.handleNoBreach(ctx, msg, threshold, *value, state, now)
+}
+
+// getBaseline returns the rule metric's baseline for the current hour,
+// loading each zipcode's once an hour
+func (e *Evaluator) getBaseline(ctx context.Context, zipcode string, rule *database.AnomalyRule, now time.Time) (*database.Baseline, error) {
+	hour := now.Truncate(time.Hour)
+	key := fmt.Sprintf("%s:%s:%d", zipcode, rule.MetricName, rule.BaselineDays)
+	e.mu.Lock()
+	if !hour.Equal(e.baselineHour) {
+		e.baselines = make(map[string]*database.Baseline)
+		e.baselineHour = hour
+	}
+	baseline, ok := e.baselines[key]
+	e.mu.Unlock()
+	if ok {
+		return baseline, nil
+	}
+
+	baseline, err := e.db.GetHourlyBaseline(ctx, zipcode, rule.MetricName, hour, rule.BaselineDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get baseline: %w", err)
+	}
+	e.mu.Lock()
+	if hour.Equal(e.baselineHour) {
+		e.baselines[key] = baseline
+	}
+	e.mu.Unlock()
+	return baseline, nil
+}
@@ -0,0 +1,234 @@
+package alarming
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/httputil"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// AckRequest is the body of an acknowledgement: who acknowledges the
+// alarm, and for how long its escalation is snoozed, e.g. "2h". Without a
+// snooze it stays silenced until the alarm clears.
+type AckRequest struct {
+	By     string `json:"by"`
+	Snooze string `json:"snooze,omitempty"`
+}
+
+// Suppression is a suppression window as the API reads and writes it
+type Suppression struct {
+	ID       int       `json:"id,omitempty"`
+	Zipcode  string    `json:"zipcode,omitempty"` // empty for every zipcode
+	Metric   string    `json:"metric,omitempty"`  // empty for every metric
+	StartsAt time.Time `json:"starts_at"`         // defaults to now
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// API serves alarm acknowledgements and suppression windows over HTTP:
+//
+//	POST   /v1/alarms/{id}/ack
+//	GET    /v1/suppressions
+//	POST   /v1/suppressions
+//	DELETE /v1/suppressions/{id}
+//
+// An acknowledgement stops the alarm's escalation while the evaluator
+// keeps tracking its state, and is announced with an ALARM_ACKNOWLEDGED
+// notification. Requests carry one of the API keys in X-API-Key or as a
+// bearer token.
+type API struct {
+	db            *database.DB
+	alarmProducer queue.Producer
+	apiKeys       httputil.APIKeys
+}
+
+// NewAPI creates an API accepting requests carrying one of apiKeys
+func NewAPI(db *database.DB, alarmProducer queue.Producer, apiKeys []string) *API {
+	return &API{db: db, alarmProducer: alarmProducer, apiKeys: httputil.NewAPIKeys(apiKeys)}
+}
+
+// Handler returns the API's routes
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/alarms/{id}/ack", a.acknowledge)
+	mux.HandleFunc("GET /v1/suppressions", a.listSuppressions)
+	mux.HandleFunc("POST /v1/suppressions", a.createSuppression)
+	mux.HandleFunc("DELETE /v1/suppressions/{id}", a.deleteSuppression)
+	return mux
+}
+
+func (a *API) acknowledge(w http.ResponseWriter, r *http.Request) {
+	if !a.apiKeys.Authorized(r) {
+		httputil.WriteError(w, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "missing or invalid API key")
+		return
+	}
+
+	alarmID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidMessage, "invalid alarm ID")
+		return
+	}
+	var req AckRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidJSON, fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+	if req.By == "" {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidMessage, "by is required")
+		return
+	}
+
+	now := time.Now()
+	var snoozedUntil time.Time
+	if req.Snooze != "" {
+		snooze, err := time.ParseDuration(req.Snooze)
+		if err != nil || snooze <= 0 {
+			httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidMessage, fmt.Sprintf("invalid snooze: %s", req.Snooze))
+			return
+		}
+		snoozedUntil = now.Add(snooze)
+	}
+
+	err = a.db.AcknowledgeAlarm(r.Context(), alarmID, req.By, now, snoozedUntil)
+	if errors.Is(err, database.ErrAlarmNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, protocol.ErrCodeNotFound, "no active alarm with this ID")
+		return
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	alarm, err := a.db.GetAlarmLog(r.Context(), alarmID)
+	if err != nil || alarm == nil {
+		httputil.WriteError(w, http.StatusInternalServerError, protocol.ErrCodeInternal, fmt.Sprintf("failed to read acknowledged alarm: %v", err))
+		return
+	}
+
+	fmt.Printf("👍 ALARM ACKNOWLEDGED: zipcode=%s, metric=%s, alarm=%d, by=%s\n",
+		alarm.Zipcode, alarm.MetricName, alarm.AlarmID, req.By)
+	notification := &protocol.AlarmNotification{
+		Type:           protocol.AlarmTypeAcknowledged,
+		Zipcode:        alarm.Zipcode,
+		Metric:         alarm.MetricName,
+		Value:          alarm.BreachValue,
+		StartTime:      alarm.StartTime,
+		AlarmID:        alarm.AlarmID,
+		Severity:       alarm.Severity,
+		AcknowledgedBy: req.By,
+		AcknowledgedAt: alarm.AcknowledgedAt,
+		SnoozedUntil:   alarm.SnoozedUntil,
+	}
+	if location, err := a.db.GetLocation(r.Context(), alarm.Zipcode); err == nil && location != nil {
+		notification.City = location.CityName
+	}
+	if err := publishNotification(r.Context(), a.alarmProducer, notification); err != nil {
+		// The acknowledgement stands; only its announcement is lost
+		fmt.Printf("Failed to announce acknowledgement of alarm %d: %v\n", alarm.AlarmID, err)
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, notification)
+}
+
+// listSuppressions returns the suppression windows in effect or to come
+func (a *API) listSuppressions(w http.ResponseWriter, r *http.Request) {
+	if !a.apiKeys.Authorized(r) {
+		httputil.WriteError(w, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "missing or invalid API key")
+		return
+	}
+
+	suppressions, err := a.db.ListAlarmSuppressions(r.Context(), time.Now())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	result := make([]Suppression, 0, len(suppressions))
+	for _, s := range suppressions {
+		result = append(result, Suppression{
+			ID:       s.ID,
+			Zipcode:  ptrValue(s.Zipcode),
+			Metric:   ptrValue(s.MetricName),
+			StartsAt: s.StartsAt,
+			EndsAt:   s.EndsAt,
+			Reason:   s.Reason,
+		})
+	}
+	httputil.WriteJSON(w, http.StatusOK, result)
+}
+
+func (a *API) createSuppression(w http.ResponseWriter, r *http.Request) {
+	if !a.apiKeys.Authorized(r) {
+		httputil.WriteError(w, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "missing or invalid API key")
+		return
+	}
+
+	var req Suppression
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidJSON, fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+	if req.StartsAt.IsZero() {
+		req.StartsAt = time.Now()
+	}
+	suppression := &database.AlarmSuppression{
+		StartsAt: req.StartsAt,
+		EndsAt:   req.EndsAt,
+		Reason:   req.Reason,
+	}
+	if req.Zipcode != "" {
+		suppression.Zipcode = &req.Zipcode
+	}
+	if req.Metric != "" {
+		suppression.MetricName = &req.Metric
+	}
+	if !suppression.EndsAt.After(suppression.StartsAt) {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidMessage, "ends_at must be after starts_at")
+		return
+	}
+	if err := a.db.CreateAlarmSuppression(r.Context(), suppression); err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	fmt.Printf("Alarm suppression %d created: zipcode=%q, metric=%q, %s to %s: %s\n", suppression.ID,
+		req.Zipcode, req.Metric, suppression.StartsAt.Format(time.RFC3339), suppression.EndsAt.Format(time.RFC3339), req.Reason)
+	req.ID = suppression.ID
+	httputil.WriteJSON(w, http.StatusCreated, req)
+}
+
+func (a *API) deleteSuppression(w http.ResponseWriter, r *http.Request) {
+	if !a.apiKeys.Authorized(r) {
+		httputil.WriteError(w, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "missing or invalid API key")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidMessage, "invalid suppression ID")
+		return
+	}
+	err = a.db.DeleteAlarmSuppression(r.Context(), id)
+	if errors.Is(err, database.ErrAlarmSuppressionNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, protocol.ErrCodeNotFound, "no suppression with this ID")
+		return
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ptrValue is the string s points to, or empty for nil
+func ptrValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,176 @@
+package alarming
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// DefaultEscalationChannels are the channels an escalation policy moves
+// through when none are configured
+var DefaultEscalationChannels = []string{"email", "sms", "pager"}
+
+// EscalationPolicy is when and where active alarms nobody has
+// acknowledged are notified again
+type EscalationPolicy struct {
+	// Channels are notified in turn: an alarm's first notification goes to
+	// the first, its first escalation to the second, and so on, staying on
+	// the last
+	Channels []string
+	// Intervals are how long an alarm goes unacknowledged before each
+	// escalation, the last repeating. Without any, nothing is escalated.
+	Intervals []time.Duration
+	// MinSeverity is the least severe alarm that is escalated
+	MinSeverity string
+}
+
+// ParseEscalationPolicy builds a policy from its configuration, e.g.
+// channels email,sms,pager and intervals 15m,30m
+func ParseEscalationPolicy(channels, intervals []string, minSeverity string) (EscalationPolicy, error) {
+	policy := EscalationPolicy{Channels: channels, MinSeverity: minSeverity}
+	if len(policy.Channels) == 0 {
+		policy.Channels = DefaultEscalationChannels
+	}
+	for _, s := range intervals {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval <= 0 {
+			return EscalationPolicy{}, fmt.Errorf("invalid escalation interval: %s", s)
+		}
+		policy.Intervals = append(policy.Intervals, interval)
+	}
+	if !slices.Contains(database.AlarmSeverities, minSeverity) {
+		return EscalationPolicy{}, fmt.Errorf("invalid escalation severity %q (want one of %s)",
+			minSeverity, strings.Join(database.AlarmSeverities, ", "))
+	}
+	return policy, nil
+}
+
+// Enabled reports whether the policy escalates anything
+func (p EscalationPolicy) Enabled() bool {
+	return len(p.Intervals) > 0
+}
+
+// due reports whether an alarm is due for its next escalation at now
+func (p EscalationPolicy) due(alarm *database.AlarmLog, now time.Time) bool {
+	if slices.Index(database.AlarmSeverities, alarm.Severity) < slices.Index(database.AlarmSeverities, p.MinSeverity) {
+		return false
+	}
+	// An alarm whose snooze ended since its last escalation is due at once
+	if alarm.SnoozedUntil != nil && (alarm.EscalatedAt == nil || alarm.EscalatedAt.Before(*alarm.SnoozedUntil)) {
+		return true
+	}
+	last := alarm.CreatedAt
+	if alarm.EscalatedAt != nil {
+		last = *alarm.EscalatedAt
+	}
+	interval := p.Intervals[min(alarm.EscalationLevel, len(p.Intervals)-1)]
+	return now.Sub(last) >= interval
+}
+
+// channel is where an alarm's escalation to level goes
+func (p EscalationPolicy) channel(level int) string {
+	return p.Channels[min(level, len(p.Channels)-1)]
+}
+
+// Escalator notifies active alarms again, on the next channel of its
+// policy, each time they have gone unacknowledged for its interval. It
+// works from alarms_log, so a new leader carries on where the last left
+// off.
+type Escalator struct {
+	db            *database.DB
+	alarmProducer queue.Producer
+	policy        EscalationPolicy
+}
+
+// NewEscalator creates an escalator publishing to the alarm topic
+func NewEscalator(db *database.DB, alarmProducer queue.Producer, policy EscalationPolicy) *Escalator {
+	return &Escalator{db: db, alarmProducer: alarmProducer, policy: policy}
+}
+
+// Run escalates the alarms due every interval until ctx is done
+func (e *Escalator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := e.Escalate(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			fmt.Printf("Alarm escalation failed: %v\n", err)
+		}
+	}
+}
+
+// Escalate notifies each alarm due for escalation at now
+func (e *Escalator) Escalate(ctx context.Context, now time.Time) error {
+	alarms, err := e.db.GetUnacknowledgedAlarms(ctx, now)
+	if err != nil {
+		return fmt.Errorf("failed to get unacknowledged alarms: %w", err)
+	}
+
+	for _, alarm := range alarms {
+		if !e.policy.due(alarm, now) {
+			continue
+		}
+		if err := e.escalate(ctx, alarm, now); err != nil {
+			fmt.Printf("Failed to escalate alarm %d: %v\n", alarm.AlarmID, err)
+		}
+	}
+	return nil
+}
+
+// escalate publishes an alarm's next escalation and records it. During a
+// suppression window it is put off until the window ends.
+func (e *Escalator) escalate(ctx context.Context, alarm *database.AlarmLog, now time.Time) error {
+	level := alarm.EscalationLevel + 1
+	channel := e.policy.channel(level)
+	// The logged threshold or rule supplies what was breached
+	var config struct {
+		Operator        string
+		ThresholdValue  float64
+		DurationMinutes int
+	}
+	_ = json.Unmarshal([]byte(alarm.ThresholdConfig), &config)
+
+	notification := &protocol.AlarmNotification{
+		Type:            protocol.AlarmTypeEscalated,
+		Zipcode:         alarm.Zipcode,
+		Metric:          alarm.MetricName,
+		Value:           alarm.BreachValue,
+		Threshold:       config.ThresholdValue,
+		Operator:        config.Operator,
+		Duration:        config.DurationMinutes,
+		StartTime:       alarm.StartTime,
+		AlarmID:         alarm.AlarmID,
+		Severity:        alarm.Severity,
+		Channel:         channel,
+		EscalationLevel: level,
+		AcknowledgedAt:  alarm.AcknowledgedAt,
+		SnoozedUntil:    alarm.SnoozedUntil,
+	}
+	if alarm.AcknowledgedBy != nil {
+		notification.AcknowledgedBy = *alarm.AcknowledgedBy
+	}
+	if location, err := e.db.GetLocation(ctx, alarm.Zipcode); err == nil && location != nil {
+		notification.City = location.CityName
+	}
+
+	if suppressed, err := withheld(ctx, e.db, notification, now); err != nil || suppressed {
+		return err
+	}
+	fmt.Printf("🔺 ALARM ESCALATED: zipcode=%s, metric=%s, alarm=%d, level=%d, channel=%s\n",
+		alarm.Zipcode, alarm.MetricName, alarm.AlarmID, level, channel)
+	if err := publishNotification(ctx, e.alarmProducer, notification); err != nil {
+		return err
+	}
+	return e.db.SetAlarmEscalation(ctx, alarm.AlarmID, level, now)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/smukkama/weather-server/internal/database"
@@ -11,44 +13,83 @@ import (
 	"github.com/smukkama/weather-server/internal/queue"
 )
 
-// Evaluator evaluates metrics against thresholds and manages alarm state
+// Evaluator evaluates metrics against thresholds and manages alarm state.
+// Readings of different zipcodes may be evaluated concurrently; those of
+// one zipcode must be evaluated in order, one at a time.
 type Evaluator struct {
-	db             *database.DB
-	stateManager   *StateManager
-	alarmProducer  *queue.Producer
-	thresholdCache map[string][]*database.AlarmThreshold
-	lastCacheLoad  time.Time
+	db            *database.DB
+	stateManager  *StateManager
+	alarmProducer queue.Producer
+	httpClient    *http.Client // calls thresholds' webhooks
+
+	// Caches of each zipcode's thresholds and rules, and of the current
+	// hour's baselines
+	mu              sync.Mutex
+	thresholdCache  map[string][]*database.AlarmThreshold
+	lastCacheLoad   time.Time
+	ruleCache       map[string][]*database.AlarmRule
+	lastRuleLoad    time.Time
+	anomalyCache    map[string][]*database.AnomalyRule
+	lastAnomalyLoad time.Time
+	baselines       map[string]*database.Baseline
+	baselineHour    time.Time
+
 	cacheValidity  time.Duration
+	flapThreshold  int
+	flapWindow     time.Duration
+	offlineTimeout time.Duration
+
+	predictionHorizon time.Duration
+	predictionWindow  time.Duration
 }
 
 // NewEvaluator creates a new alarm evaluator
-func NewEvaluator(db *database.DB, stateManager *StateManager, alarmProducer *queue.Producer) *Evaluator {
+func NewEvaluator(db *database.DB, stateManager *StateManager, alarmProducer queue.Producer) *Evaluator {
 	return &Evaluator{
 		db:             db,
 		stateManager:   stateManager,
 		alarmProducer:  alarmProducer,
+		httpClient:     &http.Client{},
 		thresholdCache: make(map[string][]*database.AlarmThreshold),
+		ruleCache:      make(map[string][]*database.AlarmRule),
+		anomalyCache:   make(map[string][]*database.AnomalyRule),
+		baselines:      make(map[string]*database.Baseline),
 		cacheValidity:  5 * time.Minute,
 	}
 }
 
-// EvaluateMetric evaluates a metric message against all thresholds
+// SetFlapDetection marks alarms triggering more than threshold times within
+// window as flapping: they are logged as FLAPPING, and a single
+// ALARM_FLAPPING notification replaces their triggered and cleared ones
+// until they settle. A threshold of zero disables flap detection.
+func (e *Evaluator) SetFlapDetection(threshold int, window time.Duration) {
+	e.flapThreshold = threshold
+	e.flapWindow = window
+}
+
+// EvaluateMetric evaluates a metric message against all thresholds,
+// composite rules and anomaly rules
 func (e *Evaluator) EvaluateMetric(ctx context.Context, msg *protocol.MetricMessage) error {
-	// Parse metric data
-	parsedData, err := msg.Data.Parse()
-	if err != nil {
+	// Validate metric data
+	if _, err := msg.Data.Parse(); err != nil {
 		return fmt.Errorf("failed to parse metric data: %w", err)
 	}
 
+	if e.offlineTimeout > 0 {
+		if err := e.stateManager.Touch(ctx, msg.Zipcode, time.Now()); err != nil {
+			return err
+		}
+	}
+
 	// Get thresholds for this zipcode
-	thresholds, err := e.getThresholds(msg.Zipcode)
+	thresholds, err := e.getThresholds(ctx, msg.Zipcode)
 	if err != nil {
 		return fmt.Errorf("failed to get thresholds: %w", err)
 	}
 
 	// Evaluate each threshold
 	for _, threshold := range thresholds {
-		value := e.extractMetricValue(parsedData, threshold.MetricName)
+		value := e.extractMetricValue(msg, threshold.MetricName)
 		if value == nil {
 			continue
 		}
@@ -58,6 +99,26 @@ func (e *Evaluator) EvaluateMetric(ctx context.Context, msg *protocol.MetricMess
 		}
 	}
 
+	rules, err := e.getRules(ctx, msg.Zipcode)
+	if err != nil {
+		return fmt.Errorf("failed to get alarm rules: %w", err)
+	}
+	for _, rule := range rules {
+		if err := e.evaluateRule(ctx, msg, rule); err != nil {
+			fmt.Printf("Failed to evaluate rule %s: %v\n", rule.Name, err)
+		}
+	}
+
+	anomalyRules, err := e.getAnomalyRules(ctx, msg.Zipcode)
+	if err != nil {
+		return fmt.Errorf("failed to get anomaly rules: %w", err)
+	}
+	for _, rule := range anomalyRules {
+		if err := e.evaluateAnomaly(ctx, msg, rule); err != nil {
+			fmt.Printf("Failed to evaluate anomaly rule on %s: %v\n", rule.MetricName, err)
+		}
+	}
+
 	return nil
 }
 
@@ -73,10 +134,16 @@ func (e *Evaluator) evaluateThreshold(ctx context.Context, msg *protocol.MetricM
 
 	now := time.Now()
 
+	if e.predictionHorizon > 0 {
+		if err := e.predictBreach(ctx, msg, threshold, value, breached, state, now); err != nil {
+			fmt.Printf("Failed to predict breach: %v\n", err)
+		}
+	}
+
 	if breached {
 		return e.handleBreach(ctx, msg, threshold, value, state, now)
 	} else {
-		return e.handleNoBreach(ctx, msg, threshold, state, now)
+		return e.handleNoBreach(ctx, msg, threshold, value, state, now)
 	}
 }
 
@@ -107,15 +174,17 @@ func (e *Evaluator) handleBreach(ctx context.Context, msg *protocol.MetricMessag
 		return e.stateManager.SetState(ctx, msg.Zipcode, threshold.MetricName, state)
 
 	case AlarmStateActive:
-		// Alarm already active, update last checked
+		// Alarm already active, update last checked; a breach interrupts
+		// clearing
 		state.LastChecked = now
+		state.ClearStartTime = nil
 		return e.stateManager.SetState(ctx, msg.Zipcode, threshold.MetricName, state)
 	}
 
 	return nil
 }
 
-func (e *Evaluator) handleNoBreach(ctx context.Context, msg *protocol.MetricMessage, threshold *database.AlarmThreshold, state *AlarmState, now time.Time) error {
+func (e *Evaluator) handleNoBreach(ctx context.Context, msg *protocol.MetricMessage, threshold *database.AlarmThreshold, value float64, state *AlarmState, now time.Time) error {
 	switch state.Status {
 	case AlarmStateClear:
 		// Nothing to do
@@ -126,17 +195,45 @@ func (e *Evaluator) handleNoBreach(ctx context.Context, msg *protocol.MetricMess
 		return e.stateManager.DeleteState(ctx, msg.Zipcode, threshold.MetricName)
 
 	case AlarmStateActive:
-		// CLEAR ALARM
-		return e.clearAlarm(ctx, msg, threshold, state, now)
+		return e.handleClearing(ctx, msg, threshold, value, state, now)
 	}
 
 	return nil
 }
 
+// handleClearing clears an active alarm once the metric has been past the
+// threshold's clear value for its clear duration. Between the threshold
+// and the clear value the alarm stays active, and clearing starts over.
+func (e *Evaluator) handleClearing(ctx context.Context, msg *protocol.MetricMessage, threshold *database.AlarmThreshold, value float64, state *AlarmState, now time.Time) error {
+	state.LastChecked = now
+	if !clearConditionMet(value, threshold) {
+		state.ClearStartTime = nil
+		return e.stateManager.SetState(ctx, msg.Zipcode, threshold.MetricName, state)
+	}
+	if state.ClearStartTime == nil {
+		state.ClearStartTime = &now
+	}
+	if now.Sub(*state.ClearStartTime) >= time.Duration(threshold.ClearDurationMinutes)*time.Minute {
+		// CLEAR ALARM
+		return e.clearAlarm(ctx, msg, threshold, state, now)
+	}
+	return e.stateManager.SetState(ctx, msg.Zipcode, threshold.MetricName, state)
+}
+
 func (e *Evaluator) triggerAlarm(ctx context.Context, msg *protocol.MetricMessage, threshold *database.AlarmThreshold, value float64, state *AlarmState, now time.Time) error {
 	fmt.Printf("🚨 ALARM TRIGGERED: %s (zipcode=%s, metric=%s, value=%.2f, threshold=%.2f)\n",
 		msg.City, msg.Zipcode, threshold.MetricName, value, threshold.ThresholdValue)
 
+	flaps, err := e.recordTrigger(ctx, msg.Zipcode, threshold.MetricName, now)
+	if err != nil {
+		return err
+	}
+	state.Flapping = e.flapThreshold > 0 && flaps > int64(e.flapThreshold)
+	status := database.AlarmStatusActive
+	if state.Flapping {
+		status = database.AlarmStatusFlapping
+	}
+
 	// Create alarm log entry
 	thresholdConfig, _ := json.Marshal(threshold)
 	alarmLog := &database.AlarmLog{
@@ -145,10 +242,11 @@ func (e *Evaluator) triggerAlarm(ctx context.Context, msg *protocol.MetricMessag
 		BreachValue:     value,
 		ThresholdConfig: string(thresholdConfig),
 		StartTime:       state.BreachStartTime,
-		Status:          database.AlarmStatusActive,
+		Status:          status,
+		Severity:        threshold.Severity,
 	}
 
-	if err := e.db.InsertAlarmLog(alarmLog); err != nil {
+	if err := e.db.InsertAlarmLog(ctx, alarmLog); err != nil {
 		return fmt.Errorf("failed to insert alarm log: %w", err)
 	}
 
@@ -160,9 +258,20 @@ func (e *Evaluator) triggerAlarm(ctx context.Context, msg *protocol.MetricMessag
 		return err
 	}
 
+	// Only the trigger that starts a flapping run is notified, as flapping
+	notificationType := protocol.AlarmTypeTriggered
+	if state.Flapping {
+		if flaps != int64(e.flapThreshold)+1 {
+			fmt.Printf("Suppressed notification for flapping alarm (zipcode=%s, metric=%s, triggers=%d in %s)\n",
+				msg.Zipcode, threshold.MetricName, flaps, e.flapWindow)
+			return nil
+		}
+		notificationType = protocol.AlarmTypeFlapping
+	}
+
 	// Send notification
 	notification := &protocol.AlarmNotification{
-		Type:      protocol.AlarmTypeTriggered,
+		Type:      notificationType,
 		Zipcode:   msg.Zipcode,
 		City:      msg.City,
 		Metric:    threshold.MetricName,
@@ -172,9 +281,10 @@ func (e *Evaluator) triggerAlarm(ctx context.Context, msg *protocol.MetricMessag
 		Duration:  threshold.DurationMinutes,
 		StartTime: state.BreachStartTime,
 		AlarmID:   alarmLog.AlarmID,
+		Severity:  alarmLog.Severity,
 	}
 
-	return e.sendNotification(ctx, notification)
+	return e.notifyThreshold(ctx, threshold, notification)
 }
 
 func (e *Evaluator) clearAlarm(ctx context.Context, msg *protocol.MetricMessage, threshold *database.AlarmThreshold, state *AlarmState, now time.Time) error {
@@ -183,7 +293,7 @@ func (e *Evaluator) clearAlarm(ctx context.Context, msg *protocol.MetricMessage,
 
 	// Update alarm log
 	if state.AlarmID > 0 {
-		if err := e.db.UpdateAlarmLogCleared(state.AlarmID, now); err != nil {
+		if err := e.db.UpdateAlarmLogCleared(ctx, state.AlarmID, now); err != nil {
 			return fmt.Errorf("failed to update alarm log: %w", err)
 		}
 	}
@@ -193,6 +303,12 @@ func (e *Evaluator) clearAlarm(ctx context.Context, msg *protocol.MetricMessage,
 		return err
 	}
 
+	if state.Flapping {
+		fmt.Printf("Suppressed clear notification for flapping alarm (zipcode=%s, metric=%s)\n",
+			msg.Zipcode, threshold.MetricName)
+		return nil
+	}
+
 	// Send clear notification
 	notification := &protocol.AlarmNotification{
 		Type:      protocol.AlarmTypeCleared,
@@ -201,57 +317,168 @@ func (e *Evaluator) clearAlarm(ctx context.Context, msg *protocol.MetricMessage,
 		Metric:    threshold.MetricName,
 		Threshold: threshold.ThresholdValue,
 		AlarmID:   state.AlarmID,
+		Severity:  threshold.Severity,
 	}
+	e.addAcknowledgement(ctx, notification)
 
-	return e.sendNotification(ctx, notification)
+	return e.notifyThreshold(ctx, threshold, notification)
+}
+
+// recordTrigger counts the alarm's triggers within the flap window,
+// including this one, or returns zero if flap detection is disabled
+func (e *Evaluator) recordTrigger(ctx context.Context, zipcode, metric string, now time.Time) (int64, error) {
+	if e.flapThreshold <= 0 {
+		return 0, nil
+	}
+	return e.stateManager.RecordTrigger(ctx, zipcode, metric, now, e.flapWindow)
+}
+
+// addAcknowledgement adds who acknowledged the notification's alarm, if
+// anyone did, to the notification
+func (e *Evaluator) addAcknowledgement(ctx context.Context, notification *protocol.AlarmNotification) {
+	if notification.AlarmID == 0 {
+		return
+	}
+	alarm, err := e.db.GetAlarmLog(ctx, notification.AlarmID)
+	if err != nil || alarm == nil || alarm.AcknowledgedBy == nil {
+		return
+	}
+	notification.AcknowledgedBy = *alarm.AcknowledgedBy
+	notification.AcknowledgedAt = alarm.AcknowledgedAt
+}
+
+// notifyThreshold sends a threshold's alarm notification and calls the
+// threshold's webhooks with it, unless a suppression window withholds it
+func (e *Evaluator) notifyThreshold(ctx context.Context, threshold *database.AlarmThreshold, notification *protocol.AlarmNotification) error {
+	if suppressed, err := withheld(ctx, e.db, notification, time.Now()); err != nil || suppressed {
+		return err
+	}
+	e.callWebhooks(ctx, threshold, notification)
+	return publishNotification(ctx, e.alarmProducer, notification)
 }
 
 func (e *Evaluator) sendNotification(ctx context.Context, notification *protocol.AlarmNotification) error {
+	if suppressed, err := withheld(ctx, e.db, notification, time.Now()); err != nil || suppressed {
+		return err
+	}
+	return publishNotification(ctx, e.alarmProducer, notification)
+}
+
+// withheld reports whether a suppression window withholds a notification
+// at the given time
+func withheld(ctx context.Context, db *database.DB, notification *protocol.AlarmNotification, at time.Time) (bool, error) {
+	suppression, err := db.GetAlarmSuppression(ctx, notification.Zipcode, notification.Metric, at)
+	if err != nil {
+		return false, fmt.Errorf("failed to check alarm suppressions: %w", err)
+	}
+	if suppression == nil {
+		return false, nil
+	}
+	fmt.Printf("Withheld %s notification (zipcode=%s, metric=%s) during suppression %d until %s: %s\n",
+		notification.Type, notification.Zipcode, notification.Metric, suppression.ID,
+		suppression.EndsAt.Format(time.RFC3339), suppression.Reason)
+	return true, nil
+}
+
+// publishNotification publishes a notification to the alarm topic, keyed
+// by its zipcode and metric so each alarm's notifications stay in order
+func publishNotification(ctx context.Context, producer queue.Producer, notification *protocol.AlarmNotification) error {
 	data, err := protocol.EncodeAlarmNotification(notification)
 	if err != nil {
 		return fmt.Errorf("failed to encode notification: %w", err)
 	}
 
 	key := fmt.Sprintf("%s-%s", notification.Zipcode, notification.Metric)
-	return e.alarmProducer.Publish(ctx, key, data)
+	return producer.Publish(ctx, key, data)
 }
 
-func (e *Evaluator) getThresholds(zipcode string) ([]*database.AlarmThreshold, error) {
+func (e *Evaluator) getThresholds(ctx context.Context, zipcode string) ([]*database.AlarmThreshold, error) {
 	// Check cache
-	if time.Since(e.lastCacheLoad) < e.cacheValidity {
-		if thresholds, ok := e.thresholdCache[zipcode]; ok {
-			return thresholds, nil
-		}
+	e.mu.Lock()
+	thresholds, ok := e.thresholdCache[zipcode]
+	fresh := time.Since(e.lastCacheLoad) < e.cacheValidity
+	e.mu.Unlock()
+	if ok && fresh {
+		return thresholds, nil
 	}
 
 	// Load from database
-	thresholds, err := e.db.GetActiveAlarmThresholds(zipcode)
+	thresholds, err := e.db.GetActiveAlarmThresholds(ctx, zipcode)
 	if err != nil {
 		return nil, err
 	}
 
+	e.mu.Lock()
 	e.thresholdCache[zipcode] = thresholds
 	e.lastCacheLoad = time.Now()
+	e.mu.Unlock()
 
 	return thresholds, nil
 }
 
-func (e *Evaluator) extractMetricValue(data *protocol.ParsedMetricData, metricName string) *float64 {
-	switch metricName {
-	case "temperature":
-		return &data.Temperature
-	case "humidity":
-		return &data.Humidity
-	case "precipitation":
-		return &data.Precipitation
-	case "wind_speed":
-		return &data.WindSpeed
-	case "pollution_index":
-		return &data.PollutionIndex
-	case "pollen_index":
-		return &data.PollenIndex
+func (e *Evaluator) getRules(ctx context.Context, zipcode string) ([]*database.AlarmRule, error) {
+	e.mu.Lock()
+	rules, ok := e.ruleCache[zipcode]
+	fresh := time.Since(e.lastRuleLoad) < e.cacheValidity
+	e.mu.Unlock()
+	if ok && fresh {
+		return rules, nil
+	}
+
+	rules, err := e.db.GetActiveAlarmRules(ctx, zipcode)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.ruleCache[zipcode] = rules
+	e.lastRuleLoad = time.Now()
+	e.mu.Unlock()
+
+	return rules, nil
+}
+
+func (e *Evaluator) getAnomalyRules(ctx context.Context, zipcode string) ([]*database.AnomalyRule, error) {
+	e.mu.Lock()
+	rules, ok := e.anomalyCache[zipcode]
+	fresh := time.Since(e.lastAnomalyLoad) < e.cacheValidity
+	e.mu.Unlock()
+	if ok && fresh {
+		return rules, nil
+	}
+
+	rules, err := e.db.GetActiveAnomalyRules(ctx, zipcode)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.anomalyCache[zipcode] = rules
+	e.lastAnomalyLoad = time.Now()
+	e.mu.Unlock()
+
+	return rules, nil
+}
+
+// extractMetricValue returns the reading's measured or derived value for a
+// metric, or nil if it isn't available; thresholds on missing metrics are
+// skipped so their alarm state is left unchanged
+func (e *Evaluator) extractMetricValue(msg *protocol.MetricMessage, metricName string) *float64 {
+	return msg.Value(metricName)
+}
+
+// clearConditionMet reports whether a value not breaching the threshold is
+// far enough back to clear its alarm: past the clear value, e.g. below 33
+// for temperature > 35, or just not breaching without one
+func clearConditionMet(value float64, threshold *database.AlarmThreshold) bool {
+	if threshold.ClearValue == nil {
+		return true
+	}
+	switch threshold.Operator {
+	case ">", ">=":
+		return value < *threshold.ClearValue
 	default:
-		return nil
+		return value > *threshold.ClearValue
 	}
 }
 
@@ -0,0 +1,100 @@
+package alarming
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// minTrendSamples is how many readings a trend is fitted to at least
+const minTrendSamples = 3
+
+// SetPrediction warns of breaches ahead: once a threshold's metric,
+// trending linearly over its readings of the last window, is projected to
+// breach the threshold within horizon, an ALARM_PREDICTED notification is
+// sent, at most once per horizon. A horizon of zero disables prediction.
+func (e *Evaluator) SetPrediction(horizon, window time.Duration) {
+	e.predictionHorizon = horizon
+	e.predictionWindow = window
+}
+
+// predictBreach records a reading's value of a threshold's metric and,
+// while the threshold is neither breached nor alarming, notifies if the
+// metric's trend is projected to breach it within the horizon
+func (e *Evaluator) predictBreach(ctx context.Context, msg *protocol.MetricMessage, threshold *database.AlarmThreshold, value float64, breached bool, state *AlarmState, now time.Time) error {
+	samples, err := e.stateManager.RecordSample(ctx, msg.Zipcode, threshold.MetricName, Sample{At: now, Value: value}, e.predictionWindow)
+	if err != nil {
+		return err
+	}
+	if breached || state.Status != AlarmStateClear {
+		return nil
+	}
+
+	breachAt, ok := projectBreach(samples, threshold, now, e.predictionHorizon)
+	if !ok {
+		return nil
+	}
+	marked, err := e.stateManager.MarkPredicted(ctx, msg.Zipcode, threshold.MetricName, e.predictionHorizon)
+	if err != nil || !marked {
+		return err
+	}
+
+	fmt.Printf("📈 BREACH PREDICTED: %s (zipcode=%s, metric=%s, value=%.2f, threshold=%.2f, at=%s)\n",
+		msg.City, msg.Zipcode, threshold.MetricName, value, threshold.ThresholdValue, breachAt.Format(time.RFC3339))
+
+	notification := &protocol.AlarmNotification{
+		Type:                protocol.AlarmTypePredicted,
+		Zipcode:             msg.Zipcode,
+		City:                msg.City,
+		Metric:              threshold.MetricName,
+		Value:               value,
+		Threshold:           threshold.ThresholdValue,
+		Operator:            threshold.Operator,
+		Duration:            threshold.DurationMinutes,
+		StartTime:           now,
+		Severity:            threshold.Severity,
+		PredictedBreachTime: &breachAt,
+	}
+	return e.sendNotification(ctx, notification)
+}
+
+// projectBreach fits a least-squares line to the samples and returns when
+// it crosses the threshold, if it heads towards it and gets there within
+// horizon of now. A line already past the threshold crosses it now.
+func projectBreach(samples []Sample, threshold *database.AlarmThreshold, now time.Time, horizon time.Duration) (time.Time, bool) {
+	if len(samples) < minTrendSamples {
+		return time.Time{}, false
+	}
+
+	// Seconds since the first sample against value
+	start := samples[0].At
+	var sumX, sumY, sumXX, sumXY float64
+	for _, s := range samples {
+		x := s.At.Sub(start).Seconds()
+		sumX += x
+		sumY += s.Value
+		sumXX += x * x
+		sumXY += x * s.Value
+	}
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return time.Time{}, false
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+
+	rising := threshold.Operator == ">" || threshold.Operator == ">="
+	if (rising && slope <= 0) || (!rising && slope >= 0) {
+		return time.Time{}, false
+	}
+	current := intercept + slope*now.Sub(start).Seconds()
+	seconds := max((threshold.ThresholdValue-current)/slope, 0)
+	if seconds > horizon.Seconds() {
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(seconds * float64(time.Second))), true
+}
@@ -0,0 +1,105 @@
+package alarming
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+func TestProjectBreach(t *testing.T) {
+	start := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	now := start.Add(2 * time.Minute)
+	// Readings a minute apart ending now
+	series := func(values ...float64) []Sample {
+		samples := make([]Sample, len(values))
+		for i, v := range values {
+			samples[i] = Sample{At: start.Add(time.Duration(i) * time.Minute), Value: v}
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name      string
+		samples   []Sample
+		operator  string
+		threshold float64
+		horizon   time.Duration
+		want      time.Duration // from now; negative for no breach
+	}{
+		{"rising towards >", series(30, 32, 34), ">", 40, 10 * time.Minute, 3 * time.Minute},
+		{"falling towards <", series(10, 8, 6), "<", 0, 10 * time.Minute, 3 * time.Minute},
+		{"falling towards <=", series(10, 8, 6), "<=", 0, 10 * time.Minute, 3 * time.Minute},
+		{"rising away from <", series(30, 32, 34), "<", 20, 10 * time.Minute, -1},
+		{"falling away from >=", series(10, 8, 6), ">=", 20, 10 * time.Minute, -1},
+		{"already past", series(30, 32, 34), ">", 33, 10 * time.Minute, 0},
+		{"beyond the horizon", series(30, 32, 34), ">", 40, 2 * time.Minute, -1},
+		{"flat", series(30, 30, 30), ">", 40, 10 * time.Minute, -1},
+		{"all at once", []Sample{{start, 30}, {start, 32}, {start, 34}}, ">", 40, 10 * time.Minute, -1},
+		{"too few readings", series(30, 34), ">", 40, 10 * time.Minute, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threshold := &database.AlarmThreshold{Operator: tt.operator, ThresholdValue: tt.threshold}
+			at, ok := projectBreach(tt.samples, threshold, now, tt.horizon)
+			if ok != (tt.want >= 0) {
+				t.Fatalf("Expected a breach %v, got %v at %v", tt.want >= 0, ok, at)
+			}
+			if !ok {
+				return
+			}
+			if d := at.Sub(now.Add(tt.want)); d < -time.Second || d > time.Second {
+				t.Errorf("Expected a breach %v from now, got %v", tt.want, at.Sub(now))
+			}
+		})
+	}
+}
+
+func TestClearConditionMet(t *testing.T) {
+	clear := 36.0
+	tests := []struct {
+		operator   string
+		clearValue *float64
+		value      float64
+		want       bool
+	}{
+		{">", nil, 40, true},
+		{">", &clear, 37, false},
+		{">", &clear, 35, true},
+		{">=", &clear, 36, false},
+		{"<", &clear, 35, false},
+		{"<", &clear, 37, true},
+		{"<=", &clear, 36, false},
+	}
+	for _, tt := range tests {
+		threshold := &database.AlarmThreshold{Operator: tt.operator, ClearValue: tt.clearValue}
+		if got := clearConditionMet(tt.value, threshold); got != tt.want {
+			t.Errorf("%s threshold clearing at %v, value %v: expected %v, got %v", tt.operator, tt.clearValue, tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestRuleConditionMet(t *testing.T) {
+	conditions := []database.AlarmCondition{
+		{MetricName: "temperature", Operator: ">", ThresholdValue: 30},
+		{MetricName: "humidity", Operator: ">", ThresholdValue: 80},
+	}
+	tests := []struct {
+		combinator string
+		values     []float64
+		want       bool
+		breach     float64
+	}{
+		{database.CombinatorAnd, []float64{32, 85}, true, 32},
+		{database.CombinatorAnd, []float64{32, 70}, false, 32},
+		{database.CombinatorOr, []float64{25, 85}, true, 85},
+		{database.CombinatorOr, []float64{25, 70}, false, 0},
+	}
+	for _, tt := range tests {
+		rule := &database.AlarmRule{Combinator: tt.combinator, Conditions: conditions}
+		met, breach := ruleConditionMet(rule, tt.values)
+		if met != tt.want || breach != tt.breach {
+			t.Errorf("%s of %v: expected %v at %v, got %v at %v", tt.combinator, tt.values, tt.want, tt.breach, met, breach)
+		}
+	}
+}
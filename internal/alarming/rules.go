@@ -0,0 +1,168 @@
+package alarming
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// ruleStateKey is the metric a rule's alarm state is kept under, apart
+// from the thresholds' states
+func ruleStateKey(rule *database.AlarmRule) string {
+	return "rule:" + rule.Name
+}
+
+// evaluateRule runs a composite rule through the same states as a
+// threshold, on the values of its metrics in one reading. A reading
+// missing any of them leaves the rule's state unchanged.
+func (e *Evaluator) evaluateRule(ctx context.Context, msg *protocol.MetricMessage, rule *database.AlarmRule) error {
+	values := make([]float64, len(rule.Conditions))
+	for i, c := range rule.Conditions {
+		value := e.extractMetricValue(msg, c.MetricName)
+		if value == nil {
+			return nil
+		}
+		values[i] = *value
+	}
+	breached, breachValue := ruleConditionMet(rule, values)
+
+	key := ruleStateKey(rule)
+	state, err := e.stateManager.GetState(ctx, msg.Zipcode, key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	switch {
+	case breached && state.Status == AlarmStateClear:
+		// New breach detected
+		newState := &AlarmState{
+			Status:          AlarmStatePending,
+			BreachStartTime: now,
+			LastChecked:     now,
+			BreachValue:     breachValue,
+		}
+		return e.stateManager.SetState(ctx, msg.Zipcode, key, newState)
+
+	case breached && state.Status == AlarmStatePending &&
+		now.Sub(state.BreachStartTime) >= time.Duration(rule.DurationMinutes)*time.Minute:
+		return e.triggerRule(ctx, msg, rule, values, breachValue, state, now)
+
+	case breached:
+		state.LastChecked = now
+		return e.stateManager.SetState(ctx, msg.Zipcode, key, state)
+
+	case state.Status == AlarmStatePending:
+		// Breach ended before alarm triggered
+		return e.stateManager.DeleteState(ctx, msg.Zipcode, key)
+
+	case state.Status == AlarmStateActive:
+		return e.clearRule(ctx, msg, rule, values, state)
+	}
+
+	return nil
+}
+
+func (e *Evaluator) triggerRule(ctx context.Context, msg *protocol.MetricMessage, rule *database.AlarmRule, values []float64, breachValue float64, state *AlarmState, now time.Time) error {
+	fmt.Printf("🚨 ALARM TRIGGERED: %s (zipcode=%s, rule=%s)\n", msg.City, msg.Zipcode, rule.Name)
+
+	// Create alarm log entry, under the rule's name
+	ruleConfig, _ := json.Marshal(rule)
+	alarmLog := &database.AlarmLog{
+		Zipcode:         msg.Zipcode,
+		MetricName:      rule.Name,
+		BreachValue:     breachValue,
+		ThresholdConfig: string(ruleConfig),
+		StartTime:       state.BreachStartTime,
+		Status:          database.AlarmStatusActive,
+		Severity:        rule.Severity,
+	}
+
+	if err := e.db.InsertAlarmLog(ctx, alarmLog); err != nil {
+		return fmt.Errorf("failed to insert alarm log: %w", err)
+	}
+
+	// Update state to ALARMING
+	state.Status = AlarmStateActive
+	state.AlarmID = alarmLog.AlarmID
+	state.LastChecked = now
+	if err := e.stateManager.SetState(ctx, msg.Zipcode, ruleStateKey(rule), state); err != nil {
+		return err
+	}
+
+	notification := ruleNotification(protocol.AlarmTypeTriggered, msg, rule, values)
+	notification.StartTime = state.BreachStartTime
+	notification.AlarmID = alarmLog.AlarmID
+	return e.sendNotification(ctx, notification)
+}
+
+func (e *Evaluator) clearRule(ctx context.Context, msg *protocol.MetricMessage, rule *database.AlarmRule, values []float64, state *AlarmState) error {
+	fmt.Printf("✅ ALARM CLEARED: %s (zipcode=%s, rule=%s)\n", msg.City, msg.Zipcode, rule.Name)
+
+	// Update alarm log
+	if state.AlarmID > 0 {
+		if err := e.db.UpdateAlarmLogCleared(ctx, state.AlarmID, time.Now()); err != nil {
+			return fmt.Errorf("failed to update alarm log: %w", err)
+		}
+	}
+
+	// Delete state
+	if err := e.stateManager.DeleteState(ctx, msg.Zipcode, ruleStateKey(rule)); err != nil {
+		return err
+	}
+
+	notification := ruleNotification(protocol.AlarmTypeCleared, msg, rule, values)
+	notification.AlarmID = state.AlarmID
+	e.addAcknowledgement(ctx, notification)
+	return e.sendNotification(ctx, notification)
+}
+
+// ruleNotification is a notification of a rule's alarm listing each of
+// its conditions with the reading's value
+func ruleNotification(notificationType string, msg *protocol.MetricMessage, rule *database.AlarmRule, values []float64) *protocol.AlarmNotification {
+	conditions := make([]protocol.AlarmCondition, len(rule.Conditions))
+	for i, c := range rule.Conditions {
+		conditions[i] = protocol.AlarmCondition{
+			Metric:    c.MetricName,
+			Operator:  c.Operator,
+			Threshold: c.ThresholdValue,
+			Value:     values[i],
+		}
+	}
+	return &protocol.AlarmNotification{
+		Type:       notificationType,
+		Zipcode:    msg.Zipcode,
+		City:       msg.City,
+		Metric:     rule.Name,
+		Duration:   rule.DurationMinutes,
+		Combinator: rule.Combinator,
+		Conditions: conditions,
+		Severity:   rule.Severity,
+	}
+}
+
+// ruleConditionMet reports whether a rule's conditions hold for the
+// values of their metrics, and the value of the first condition holding,
+// which its alarm is logged with
+func ruleConditionMet(rule *database.AlarmRule, values []float64) (bool, float64) {
+	met := rule.Combinator == database.CombinatorAnd
+	var breachValue float64
+	found := false
+	for i, c := range rule.Conditions {
+		holds := evaluateCondition(values[i], c.Operator, c.ThresholdValue)
+		if holds && !found {
+			breachValue, found = values[i], true
+		}
+		if rule.Combinator == database.CombinatorAnd {
+			met = met && holds
+		} else {
+			met = met || holds
+		}
+	}
+	return met, breachValue
+}
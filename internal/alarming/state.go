@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,6 +18,12 @@ type AlarmState struct {
 	LastChecked     time.Time `json:"last_checked"`
 	BreachValue     float64   `json:"breach_value"`
 	AlarmID         int64     `json:"alarm_id,omitempty"`
+	// ClearStartTime is when an active alarm's metric last passed its clear
+	// value, if it has stayed past it since
+	ClearStartTime *time.Time `json:"clear_start_time,omitempty"`
+	// Flapping is set on an alarm triggered while flapping, whose
+	// notifications are suppressed
+	Flapping bool `json:"flapping,omitempty"`
 }
 
 const (
@@ -80,6 +88,104 @@ func (sm *StateManager) DeleteState(ctx context.Context, zipcode, metric string)
 	return sm.redis.Del(ctx, key).Err()
 }
 
+// RecordTrigger records that the alarm for a location and metric triggered
+// at the given time, and returns how many times it has triggered within
+// window of it
+func (sm *StateManager) RecordTrigger(ctx context.Context, zipcode, metric string, at time.Time, window time.Duration) (int64, error) {
+	key := fmt.Sprintf("alarm_flaps:%s:%s", zipcode, metric)
+
+	pipe := sm.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(at.UnixNano()), Member: at.UnixNano()})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", at.Add(-window).UnixNano()))
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to record alarm trigger in Redis: %w", err)
+	}
+
+	return count.Val(), nil
+}
+
+// Sample is a metric's value in a reading
+type Sample struct {
+	At    time.Time
+	Value float64
+}
+
+// RecordSample records a reading's value of a metric for a location, and
+// returns its values within window of it, oldest first
+func (sm *StateManager) RecordSample(ctx context.Context, zipcode, metric string, sample Sample, window time.Duration) ([]Sample, error) {
+	key := fmt.Sprintf("alarm_trend:%s:%s", zipcode, metric)
+	at := sample.At.UnixNano()
+
+	pipe := sm.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(at), Member: fmt.Sprintf("%d:%g", at, sample.Value)})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", sample.At.Add(-window).UnixNano()))
+	members := pipe.ZRange(ctx, key, 0, -1)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record metric sample in Redis: %w", err)
+	}
+
+	samples := make([]Sample, 0, len(members.Val()))
+	for _, member := range members.Val() {
+		nanos, value, ok := strings.Cut(member, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, Sample{At: time.Unix(0, n), Value: v})
+	}
+	return samples, nil
+}
+
+// MarkPredicted records that a location's metric was predicted to breach
+// its threshold, for ttl, and reports whether it wasn't already
+func (sm *StateManager) MarkPredicted(ctx context.Context, zipcode, metric string, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("alarm_predicted:%s:%s", zipcode, metric)
+	marked, err := sm.redis.SetNX(ctx, key, time.Now().Unix(), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record prediction in Redis: %w", err)
+	}
+	return marked, nil
+}
+
+// lastSeenKey is the Redis hash of when each zipcode last reported
+const lastSeenKey = "alarm_last_seen"
+
+// Touch records that a zipcode reported at the given time
+func (sm *StateManager) Touch(ctx context.Context, zipcode string, at time.Time) error {
+	if err := sm.redis.HSet(ctx, lastSeenKey, zipcode, at.Unix()).Err(); err != nil {
+		return fmt.Errorf("failed to record last seen time in Redis: %w", err)
+	}
+	return nil
+}
+
+// LastSeen returns when each zipcode that has reported last did
+func (sm *StateManager) LastSeen(ctx context.Context) (map[string]time.Time, error) {
+	values, err := sm.redis.HGetAll(ctx, lastSeenKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last seen times from Redis: %w", err)
+	}
+
+	lastSeen := make(map[string]time.Time, len(values))
+	for zipcode, value := range values {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		lastSeen[zipcode] = time.Unix(seconds, 0)
+	}
+	return lastSeen, nil
+}
+
 // GetAllStates returns all active alarm states (for monitoring)
 func (sm *StateManager) GetAllStates(ctx context.Context) (map[string]*AlarmState, error) {
 	pattern := "alarm_state:*"
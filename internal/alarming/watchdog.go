@@ -0,0 +1,87 @@
+package alarming
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// OfflineMetric is the metric station offline alarms are kept and logged
+// under
+const OfflineMetric = "station_offline"
+
+// SetOfflineTimeout raises a station offline alarm for a zipcode that has
+// sent no readings for timeout, once WatchOffline is running. It clears
+// once readings resume. Zero disables it.
+func (e *Evaluator) SetOfflineTimeout(timeout time.Duration) {
+	e.offlineTimeout = timeout
+}
+
+// WatchOffline checks every interval, until ctx is done, for zipcodes that
+// went offline or came back
+func (e *Evaluator) WatchOffline(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := e.checkOffline(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			fmt.Printf("Offline check failed: %v\n", err)
+		}
+	}
+}
+
+// checkOffline triggers the offline alarm of each zipcode last seen at
+// least the timeout before now, and clears those of zipcodes seen since.
+// Only zipcodes that have reported since the last-seen times were first
+// recorded are watched.
+func (e *Evaluator) checkOffline(ctx context.Context, now time.Time) error {
+	lastSeen, err := e.stateManager.LastSeen(ctx)
+	if err != nil {
+		return err
+	}
+
+	for zipcode, seen := range lastSeen {
+		state, err := e.stateManager.GetState(ctx, zipcode, OfflineMetric)
+		if err != nil {
+			return err
+		}
+		offline := now.Sub(seen) >= e.offlineTimeout
+		if offline == (state.Status == AlarmStateActive) {
+			continue
+		}
+
+		// The alarm goes through the threshold path as a breach of the
+		// timeout, in minutes offline
+		msg := &protocol.MetricMessage{Zipcode: zipcode}
+		if location, err := e.db.GetLocation(ctx, zipcode); err == nil && location != nil {
+			msg.City = location.CityName
+		}
+		threshold := &database.AlarmThreshold{
+			Zipcode:         zipcode,
+			MetricName:      OfflineMetric,
+			Operator:        ">=",
+			ThresholdValue:  e.offlineTimeout.Minutes(),
+			DurationMinutes: int(e.offlineTimeout.Minutes()),
+			Severity:        database.SeverityWarning,
+			IsActive:        true,
+		}
+
+		if offline {
+			state.BreachStartTime = seen
+			err = e.triggerAlarm(ctx, msg, threshold, now.Sub(seen).Minutes(), state, now)
+		} else {
+			err = e.clearAlarm(ctx, msg, threshold, state, now)
+		}
+		if err != nil {
+			fmt.Printf("Failed to update offline alarm for %s: %v\n", zipcode, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package alarming
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// webhookTimeout bounds each webhook call
+const webhookTimeout = 10 * time.Second
+
+// webhookFuncs are the functions payload templates can use besides the
+// builtins: json writes a value as JSON, e.g. {"city": {{json .City}}}
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// callWebhooks calls a threshold's webhooks with its alarm's notification
+// in the background, so a slow endpoint never holds up evaluation. Calls
+// aren't retried; failures are only logged.
+func (e *Evaluator) callWebhooks(ctx context.Context, threshold *database.AlarmThreshold, notification *protocol.AlarmNotification) {
+	// Synthetic thresholds, such as the offline watchdog's, have none
+	if threshold.ID == 0 {
+		return
+	}
+	webhooks, err := e.db.GetActiveAlarmWebhooks(ctx, threshold.ID)
+	if err != nil {
+		fmt.Printf("Failed to get webhooks of threshold %d: %v\n", threshold.ID, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookTimeout)
+			defer cancel()
+			if err := callWebhook(ctx, e.httpClient, webhook, notification); err != nil {
+				fmt.Printf("Webhook %d (%s) failed for %s: %v\n", webhook.ID, webhook.URL, notification.Type, err)
+			}
+		}()
+	}
+}
+
+// callWebhook POSTs a notification to a webhook. With a secret the body's
+// HMAC-SHA256 is sent hex-encoded in X-Alarm-Signature as sha256=<hex>.
+func callWebhook(ctx context.Context, client *http.Client, webhook *database.AlarmWebhook, notification *protocol.AlarmNotification) error {
+	body, err := webhookPayload(webhook, notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alarm-Event", notification.Type)
+	if webhook.Secret != nil && *webhook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(*webhook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Alarm-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookPayload renders a webhook's payload template over the
+// notification, or marshals the notification without one
+func webhookPayload(webhook *database.AlarmWebhook, notification *protocol.AlarmNotification) ([]byte, error) {
+	if webhook.PayloadTemplate == nil || *webhook.PayloadTemplate == "" {
+		return json.Marshal(notification)
+	}
+
+	tmpl, err := template.New("payload").Funcs(webhookFuncs).Parse(*webhook.PayloadTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse payload template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, notification); err != nil {
+		return nil, fmt.Errorf("failed to render payload template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("payload template rendered invalid JSON")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/smukkama/weather-server/internal/alarming"
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/leader"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// RunAlarming runs the alarming service, evaluating readings from the queue
+// against alarm thresholds, until ctx is cancelled
+func RunAlarming(ctx context.Context, cfg *config.Config) error {
+	fmt.Println("Starting Alarming Service...")
+
+	// Connect to database
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	fmt.Println("Connected to database")
+
+	// Connect to Redis
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer redisClient.Close()
+
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	fmt.Println("Connected to Redis")
+
+	// Create state manager
+	stateManager := alarming.NewStateManager(redisClient)
+
+	broker, err := queue.NewBroker(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to message queue: %w", err)
+	}
+	defer broker.Close()
+
+	// Create alarm producer (for notifications)
+	alarmProducer, err := broker.NewProducer(cfg.Kafka.TopicAlarms)
+	if err != nil {
+		return fmt.Errorf("failed to create alarm producer: %w", err)
+	}
+	defer alarmProducer.Close()
+	fmt.Println("Alarm notification producer initialized")
+
+	// Create evaluator
+	evaluator := alarming.NewEvaluator(db, stateManager, alarmProducer)
+	evaluator.SetFlapDetection(cfg.Alarming.FlapThreshold, cfg.Alarming.FlapWindow)
+	evaluator.SetOfflineTimeout(cfg.Alarming.OfflineTimeout)
+	evaluator.SetPrediction(cfg.Alarming.PredictionHorizon, cfg.Alarming.PredictionWindow)
+
+	policy, err := alarming.ParseEscalationPolicy(cfg.Alarming.EscalationChannels,
+		cfg.Alarming.EscalationIntervals, cfg.Alarming.EscalationMinSeverity)
+	if err != nil {
+		return err
+	}
+	escalator := alarming.NewEscalator(db, alarmProducer, policy)
+
+	// Serve acknowledgements on every instance; they only touch the
+	// database
+	if cfg.Alarming.APIPort > 0 {
+		srv := &http.Server{
+			Addr:              fmt.Sprintf(":%d", cfg.Alarming.APIPort),
+			Handler:           alarming.NewAPI(db, alarmProducer, cfg.Alarming.APIKeys).Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Alarm API server failed: %v\n", err)
+			}
+		}()
+		defer srv.Close()
+		fmt.Printf("Serving alarm acknowledgements on :%d/v1/alarms/{id}/ack\n", cfg.Alarming.APIPort)
+	}
+
+	deadLetters, err := queue.OpenDeadLetterQueue(broker, cfg, "alarming-group")
+	if err != nil {
+		return err
+	}
+	defer deadLetters.Close()
+
+	// Evaluation needs both Redis (alarm state) and the database (thresholds)
+	dependencies := func(ctx context.Context) error {
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("redis: %w", err)
+		}
+		if err := db.CheckHealth(ctx); err != nil {
+			return fmt.Errorf("database: %w", err)
+		}
+		return nil
+	}
+
+	// consume evaluates readings until ctx is done
+	consume := func(ctx context.Context) error {
+		// Create consumer for metrics
+		consumer, err := broker.NewConsumer(cfg.Kafka.TopicMetrics, "alarming-group")
+		if err != nil {
+			return fmt.Errorf("failed to create consumer: %w", err)
+		}
+		defer consumer.Close()
+		fmt.Printf("%s consumer initialized (%d evaluation workers)\n", cfg.Queue.Backend, cfg.Alarming.Workers)
+
+		// Escalate and watch for offline stations alongside evaluation, on
+		// the leader only
+		if policy.Enabled() {
+			go escalator.Run(ctx, time.Minute)
+		}
+		if cfg.Alarming.OfflineTimeout > 0 {
+			go evaluator.WatchOffline(ctx, time.Minute)
+		}
+
+		// Readings are keyed by zipcode, so each zipcode's are evaluated
+		// in order on one worker
+		queue.NewKeyedPool(consumer, cfg.Alarming.Workers).Run(ctx, func(ctx context.Context, msg queue.Message) {
+			// Decode and evaluate, dead-lettering messages that keep failing
+			err := deadLetters.Handle(ctx, msg, func() error {
+				metricMsg, err := protocol.DecodeMetricMessage(msg.Value)
+				if err != nil {
+					return queue.Permanent(fmt.Errorf("failed to decode message: %w", err))
+				}
+				for {
+					// Alarms raised by this reading carry its trace ID
+					err := evaluator.EvaluateMetric(queue.WithHeaders(ctx, msg.Headers), metricMsg)
+					if err == nil {
+						return nil
+					}
+					if dependencies(ctx) == nil {
+						return fmt.Errorf("failed to evaluate metric: %w", err)
+					}
+					// Redis or the database is down: stop fetching until
+					// it's back, then evaluate the reading again
+					if err := queue.PauseWhileDown(ctx, consumer, "Redis or database", 5*time.Second, dependencies); err != nil {
+						return err
+					}
+				}
+			})
+			if err != nil {
+				log.Printf("%v\n", err)
+			}
+		})
+		return nil
+	}
+
+	fmt.Println("\n✓ Alarming Service is running")
+	fmt.Println("✓ Press Ctrl+C to stop")
+
+	// With several instances only the leader evaluates readings, so two
+	// instances never race on a zipcode's alarm state; the others stand by
+	// without joining the consumer group
+	if cfg.Leader.Election {
+		leadCtx, stopLeading := context.WithCancelCause(ctx)
+		defer stopLeading(nil)
+		leader.NewElector(redisClient, "alarming", cfg.Leader.InstanceID, cfg.Leader.TTL).Lead(leadCtx, func(ctx context.Context) {
+			if err := consume(ctx); err != nil {
+				stopLeading(err)
+			}
+		})
+		if ctx.Err() == nil {
+			return context.Cause(leadCtx)
+		}
+	} else if err := consume(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("\nShutting down gracefully...")
+	return nil
+}
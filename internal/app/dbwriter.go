@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/quality"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/internal/sink"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// RunDBWriter runs the database writer, storing readings and connection
+// sessions from the queue, until ctx is cancelled
+func RunDBWriter(ctx context.Context, cfg *config.Config) error {
+	fmt.Println("Starting Database Writer Service...")
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	fmt.Println("Connected to database")
+
+	if cfg.Database.Driver == database.DriverSQLite {
+		if cfg.Database.TimescaleDB || cfg.Database.Partitioned {
+			return fmt.Errorf("invalid configuration: DB_TIMESCALEDB and DB_PARTITIONED need Postgres")
+		}
+		if err := db.RunMigrations(ctx, "migrations/sqlite"); err != nil {
+			return fmt.Errorf("failed to run SQLite migrations: %w", err)
+		}
+	} else if err := db.RunMigrations(ctx, "migrations"); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+	if cfg.Database.TimescaleDB {
+		if err := db.RunMigrations(ctx, "migrations/timescaledb"); err != nil {
+			return fmt.Errorf("failed to run TimescaleDB migrations: %w", err)
+		}
+		if cfg.Aggregation.Continuous {
+			if err := db.RunMigrations(ctx, "migrations/timescaledb/continuous"); err != nil {
+				return fmt.Errorf("failed to run continuous aggregate migrations: %w", err)
+			}
+		}
+	} else if cfg.Aggregation.Continuous {
+		return fmt.Errorf("invalid configuration: AGGREGATION_CONTINUOUS needs DB_TIMESCALEDB=true")
+	}
+	if cfg.Database.Partitioned {
+		if cfg.Database.TimescaleDB {
+			return fmt.Errorf("invalid configuration: DB_PARTITIONED can't be combined with DB_TIMESCALEDB")
+		}
+		if err := db.RunMigrations(ctx, "migrations/partitioned"); err != nil {
+			return fmt.Errorf("failed to run partitioning migrations: %w", err)
+		}
+		if err := maintainPartitions(ctx, db, cfg.Database); err != nil {
+			return fmt.Errorf("failed to maintain partitions: %w", err)
+		}
+		go runPartitionMaintenance(ctx, db, cfg.Database)
+	}
+
+	broker, err := queue.NewBroker(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to message queue: %w", err)
+	}
+	defer broker.Close()
+
+	// Create metrics consumer
+	consumer, err := broker.NewConsumer(cfg.Kafka.TopicMetrics, "dbwriter-group")
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+	fmt.Printf("%s consumer created (registering with broker...)\n", cfg.Queue.Backend)
+
+	commitPolicy, err := queue.ParseCommitPolicy(cfg.DBWriter.CommitPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Create batch writer
+	batchWriter := queue.NewBatchWriter(consumer, db, cfg.DBWriter.BatchSize, cfg.DBWriter.FlushInterval)
+	batchWriter.SetMaxInFlight(cfg.DBWriter.MaxInFlight)
+	batchWriter.SetWorkers(cfg.DBWriter.Workers)
+	batchWriter.SetCommitPolicy(commitPolicy)
+	// Rows in time order land in as few hypertable chunks as possible
+	batchWriter.SetTimeOrdered(cfg.Database.TimescaleDB)
+	if cfg.Quality.Enabled {
+		batchWriter.SetQualityChecker(quality.NewChecker(quality.Config{
+			HistorySize:   cfg.Quality.HistorySize,
+			StuckReadings: cfg.Quality.StuckReadings,
+		}))
+		fmt.Println("Data-quality checks enabled")
+	}
+	deadLetters, err := queue.OpenDeadLetterQueue(broker, cfg, "dbwriter-group")
+	if err != nil {
+		return err
+	}
+	defer deadLetters.Close()
+	batchWriter.SetDeadLetterQueue(deadLetters)
+	if cfg.DBWriter.ExactlyOnce {
+		switch cfg.Queue.Backend {
+		case queue.BackendKafka, queue.BackendNATS, queue.BackendBolt:
+		default:
+			return fmt.Errorf("DBWRITER_EXACTLY_ONCE needs stable queue offsets, which QUEUE_BACKEND=%s doesn't have", cfg.Queue.Backend)
+		}
+		batchWriter.SetExactlyOnce("dbwriter-group")
+		fmt.Println("Exactly-once writes enabled")
+	}
+	sinks, err := sink.FromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	for _, s := range sinks {
+		defer s.Close()
+		fmt.Printf("Mirroring readings to %s\n", s.Name())
+	}
+	batchWriter.SetSinks(sinks...)
+	// Start batch writer
+	if err := batchWriter.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start batch writer: %w", err)
+	}
+	fmt.Println("Batch writer started")
+
+	// Record station connection history from connection events
+	sessionConsumer, err := broker.NewConsumer(cfg.Kafka.TopicConnections, "dbwriter-sessions-group")
+	if err != nil {
+		return fmt.Errorf("failed to create session consumer: %w", err)
+	}
+	defer sessionConsumer.Close()
+	sessionWriter := queue.NewSessionWriter(sessionConsumer, db)
+	if err := sessionWriter.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start session writer: %w", err)
+	}
+	fmt.Println("Session writer started")
+
+	// Serve writer statistics for monitoring
+	if cfg.DBWriter.StatsPort > 0 {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(batchWriter.Stats())
+		})
+		mux.HandleFunc("/stats/db", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(db.QueryStats())
+		})
+		srv := &http.Server{
+			Addr:              fmt.Sprintf(":%d", cfg.DBWriter.StatsPort),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Stats server failed: %v\n", err)
+			}
+		}()
+		defer srv.Close()
+		fmt.Printf("Serving writer statistics on :%d/stats and query statistics on :%d/stats/db\n", cfg.DBWriter.StatsPort, cfg.DBWriter.StatsPort)
+	}
+
+	// Print consumer stats periodically
+	go func() {
+		ticker := time.NewTicker(60 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats := consumer.Stats()
+				fmt.Printf("Consumer stats: Messages=%d, Bytes=%d, Errors=%d\n",
+					stats.Messages, stats.Bytes, stats.Errors)
+				writerStats := batchWriter.Stats()
+				fmt.Printf("Writer stats: Written=%d, Failed=%d, DeadLettered=%d, Batches=%d (failed %d), SinkErrors=%d, Flush avg=%v max=%v\n",
+					writerStats.Written, writerStats.Failed, writerStats.DeadLettered,
+					writerStats.Batches, writerStats.FailedBatches, writerStats.SinkErrors,
+					writerStats.AvgFlush, writerStats.MaxFlush)
+				for _, w := range writerStats.Workers {
+					fmt.Printf("Writer %d: Messages=%d, Written=%d, Batches=%d\n",
+						w.Worker, w.Messages, w.Written, w.Batches)
+				}
+				for _, q := range db.QueryStats() {
+					fmt.Printf("Query %s: Count=%d, Errors=%d, Slow=%d, avg=%v max=%v\n",
+						q.Query, q.Count, q.Errors, q.Slow, q.Mean(), q.Max)
+				}
+			}
+		}
+	}()
+
+	fmt.Println("\n✓ Database Writer Service is running")
+	fmt.Println("✓ Consuming from Kafka and writing to PostgreSQL")
+	fmt.Printf("✓ Batch size: %d messages | Flush interval: %v | Writers: %d | Commit: %s\n",
+		cfg.DBWriter.BatchSize, cfg.DBWriter.FlushInterval, len(batchWriter.WorkerStats()), commitPolicy)
+	fmt.Println("✓ Consumer group will register when first message is consumed")
+	fmt.Println("✓ Press Ctrl+C to stop")
+	fmt.Println("\nWaiting for messages...")
+
+	<-ctx.Done()
+
+	fmt.Println("\nShutting down gracefully...")
+	stopCtx, cancel := context.WithTimeout(context.Background(), cfg.DBWriter.ShutdownTimeout)
+	defer cancel()
+	stopErr := batchWriter.Stop(stopCtx)
+	sessionWriter.Stop()
+	if stopErr != nil {
+		return fmt.Errorf("batch writer stopped with errors: %w", stopErr)
+	}
+	fmt.Println("Database Writer Service stopped")
+	return nil
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// partitionMaintenanceInterval is how often monthly partitions are created
+// and expired
+const partitionMaintenanceInterval = 24 * time.Hour
+
+// maintainPartitions creates the coming months' partitions of each
+// partitioned table and detaches those past retention
+func maintainPartitions(ctx context.Context, db *database.DB, cfg config.DatabaseConfig) error {
+	now := time.Now().UTC()
+	tables := make([]string, 0, len(database.PartitionedTables))
+	for table := range database.PartitionedTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		created, err := db.EnsureMonthlyPartitions(ctx, table, now, cfg.PartitionAhead)
+		for _, name := range created {
+			fmt.Printf("Created partition %s\n", name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if cfg.PartitionRetention <= 0 {
+			continue
+		}
+		before := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -cfg.PartitionRetention, 0)
+		detached, err := db.DetachPartitionsBefore(ctx, table, before)
+		for _, name := range detached {
+			fmt.Printf("Detached partition %s\n", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPartitionMaintenance maintains partitions every
+// partitionMaintenanceInterval until ctx is cancelled
+func runPartitionMaintenance(ctx context.Context, db *database.DB, cfg config.DatabaseConfig) {
+	ticker := time.NewTicker(partitionMaintenanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := maintainPartitions(ctx, db, cfg); err != nil {
+				fmt.Printf("Partition maintenance failed: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/smukkama/weather-server/internal/connection"
+	"github.com/smukkama/weather-server/internal/ingest"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/internal/server"
+	"github.com/smukkama/weather-server/internal/timer"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// RunServer runs the TCP server, publishing readings to the queue, until
+// ctx is cancelled
+func RunServer(ctx context.Context, cfg *config.Config) error {
+	fmt.Println("Starting Weather Server (TCP + queue producer)...")
+
+	broker, err := queue.NewBroker(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to message queue: %w", err)
+	}
+	defer broker.Close()
+
+	// Create or check the Kafka topics; the other backends create theirs
+	// on first use
+	if cfg.Queue.Backend == queue.BackendKafka {
+		if err := ensureKafkaTopics(ctx, &cfg.Kafka); err != nil {
+			return err
+		}
+	}
+
+	// One router publishes readings, quarantined readings and connection
+	// events, each to its topic (batching and compression apply to Kafka)
+	router := queue.NewRouter(broker, cfg.Kafka.TopicMetrics)
+	defer router.Close()
+
+	// Spool readings to disk while the queue is unavailable
+	var spool *queue.SpoolProducer
+	if cfg.Queue.SpoolPath != "" {
+		metricsProducer, err := broker.NewProducer(cfg.Kafka.TopicMetrics)
+		if err != nil {
+			return fmt.Errorf("failed to create producer: %w", err)
+		}
+		spool, err = queue.NewSpoolProducer(metricsProducer, cfg.Queue.SpoolPath, cfg.Queue.SpoolMaxMessages, cfg.Queue.SpoolReplayInterval)
+		if err != nil {
+			metricsProducer.Close()
+			return fmt.Errorf("failed to open spool: %w", err)
+		}
+		router.SetProducer(cfg.Kafka.TopicMetrics, spool)
+		fmt.Printf("Publish spool enabled at %s\n", cfg.Queue.SpoolPath)
+	}
+	var producer queue.Producer = router.Topic(cfg.Kafka.TopicMetrics)
+	if cfg.Kafka.TopicLatest != "" {
+		// Keep the latest reading per zipcode on a compacted topic
+		producer = queue.NewLatestProducer(producer, router.Topic(cfg.Kafka.TopicLatest))
+	}
+	fmt.Printf("%s producer initialized (batch=%d, compression=%s, async=%v)\n",
+		cfg.Queue.Backend, cfg.Kafka.BatchSize, cfg.Kafka.Compression, producer.IsAsync())
+
+	// Readings outside physical ranges go to the quarantine topic
+	var quarantine *queue.Quarantine
+	if cfg.Validation.RangeValidation {
+		ranges, err := protocol.ParseMetricRanges(protocol.DefaultMetricRanges(), cfg.Validation.MetricRanges)
+		if err != nil {
+			return fmt.Errorf("invalid METRIC_RANGES: %w", err)
+		}
+		quarantine = queue.NewQuarantine(router.Topic(cfg.Kafka.TopicQuarantine), ranges)
+		defer quarantine.Close()
+		fmt.Printf("Range validation enabled (quarantine topic=%s)\n", cfg.Kafka.TopicQuarantine)
+	}
+
+	// Create connection manager
+	connManager := connection.NewManager(cfg.TCPServer.MaxConnections)
+	dupPolicy, err := connection.ParseDuplicatePolicy(cfg.TCPServer.DuplicateStationPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	connManager.SetDuplicatePolicy(dupPolicy)
+	connManager.SetWriteOptions(cfg.TCPServer.SendQueueSize, cfg.TCPServer.WriteTimeout)
+	connManager.SetMaxPerZipcode(cfg.TCPServer.MaxStationsPerZipcode)
+	evictPolicy, err := connection.ParseEvictionPolicy(cfg.TCPServer.EvictionPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	priorities, err := connection.ParseZipcodePriorities(cfg.TCPServer.ZipcodePriorities)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	connManager.SetEvictionPolicy(evictPolicy, priorities)
+	fmt.Printf("Connection manager initialized (duplicate station policy: %s, eviction policy: %s)\n", dupPolicy, evictPolicy)
+
+	// Publish station connect/idle/disconnect events
+	connEvents := queue.NewConnectionEvents(router.Topic(cfg.Kafka.TopicConnections))
+	defer connEvents.Close()
+	connManager.AddObserver(connEvents)
+	fmt.Printf("Publishing connection events to %s\n", cfg.Kafka.TopicConnections)
+
+	// Share station ownership with other instances through Redis
+	if cfg.TCPServer.SharedRegistry {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		defer redisClient.Close()
+		if err := redisClient.Ping(context.Background()).Err(); err != nil {
+			return fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+
+		registry := connection.NewRedisRegistry(redisClient, cfg.TCPServer.InstanceID, cfg.TCPServer.RegistryTTL)
+		registry.Start()
+		defer registry.Stop()
+		connManager.SetRegistry(registry)
+		fmt.Printf("Shared station registry enabled (instance=%s)\n", registry.Instance())
+	}
+
+	// Create timer manager
+	var timerManager *timer.TimerManager
+	switch cfg.Timer.Backend {
+	case "wheel":
+		timerManager = timer.NewTimerManagerWithWheel(cfg.Timer.Workers, cfg.Timer.QueueSize, cfg.Timer.WheelTick)
+	case "heap", "":
+		timerManager = timer.NewTimerManagerWithQueue(cfg.Timer.Workers, cfg.Timer.QueueSize)
+	default:
+		return fmt.Errorf("invalid configuration: unknown timer backend %q (expected heap or wheel)", cfg.Timer.Backend)
+	}
+	timerManager.Start()
+	defer timerManager.Stop()
+	fmt.Println("Timer manager started")
+
+	// Create TCP server with worker pool support (Phase 1!)
+	var tcpServer interface {
+		Start() error
+		Stop()
+		SetQuarantine(*queue.Quarantine)
+	}
+
+	if cfg.TCPServer.UseWorkerPool {
+		// Calculate worker count
+		workerCount := cfg.TCPServer.WorkerCount
+		if workerCount == 0 {
+			workerCount = runtime.NumCPU() * 4 // Auto: 4x CPU cores
+		}
+
+		fmt.Printf("Starting TCP server with worker pool (%d workers, queue size %d)\n",
+			workerCount, cfg.TCPServer.JobQueueSize)
+
+		tcpServer = server.NewWorkerPoolTCPServer(
+			&cfg.TCPServer,
+			connManager,
+			timerManager,
+			producer,
+			workerCount,
+			cfg.TCPServer.JobQueueSize,
+		)
+	} else {
+		fmt.Println("Starting TCP server with goroutine-per-connection")
+		tcpServer = server.NewTCPServer(&cfg.TCPServer, connManager, timerManager, producer)
+	}
+
+	tcpServer.SetQuarantine(quarantine)
+	if err := tcpServer.Start(); err != nil {
+		return fmt.Errorf("failed to start TCP server: %w", err)
+	}
+	defer tcpServer.Stop()
+
+	// Optional UDP ingest for sensors that can't hold a connection
+	if cfg.UDPIngest.Port > 0 {
+		if len(cfg.UDPIngest.Secrets) == 0 {
+			return fmt.Errorf("UDP_INGEST_SECRETS must be set when UDP_INGEST_PORT is enabled")
+		}
+		udpListener := ingest.NewUDPListener(
+			fmt.Sprintf(":%d", cfg.UDPIngest.Port),
+			cfg.UDPIngest.Secrets,
+			cfg.UDPIngest.MaxSkew,
+			producer,
+		)
+		udpListener.SetQuarantine(quarantine)
+		if err := udpListener.Start(); err != nil {
+			return fmt.Errorf("failed to start UDP ingest: %w", err)
+		}
+		defer udpListener.Stop()
+	}
+
+	// Database writer is a separate service (cmd/dbwriter)
+	// It handles: Kafka consumption, database writes, and migrations
+	// Run 'make run-dbwriter' in a separate terminal
+	fmt.Println("Note: Start dbwriter service separately for database persistence")
+
+	// Print statistics periodically
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats := connManager.Stats()
+				timerStats := timerManager.Stats()
+				fmt.Printf("\n--- Server Statistics ---\n")
+				fmt.Printf("Active Connections: %d / %d\n", stats.TotalConnections, stats.MaxConnections)
+				fmt.Printf("Unique Zipcodes: %d\n", stats.UniqueZipcodes)
+				if len(stats.Models) > 0 {
+					fmt.Printf("Station Models: %v\n", stats.Models)
+				}
+				if len(stats.FirmwareVersions) > 0 {
+					fmt.Printf("Firmware Versions: %v\n", stats.FirmwareVersions)
+				}
+				fmt.Printf("Scheduled Timers: %d (queued: %d / %d, overflows: %d, panics: %d)\n",
+					timerStats.ScheduledTasks, timerStats.QueuedTasks, timerStats.QueueCapacity,
+					timerStats.Overflows, timerStats.Panics)
+				fmt.Printf("Timer Tasks: executed %d, cancelled %d, missed %d, late %d, timed out %d\n",
+					timerStats.Executed, timerStats.Cancelled, timerStats.Missed, timerStats.Late, timerStats.TimedOut)
+				fmt.Printf("Timer Latency: start delay p50 %v / p99 %v, run time p50 %v / p99 %v\n",
+					timerStats.StartDelay.Percentile(50), timerStats.StartDelay.Percentile(99),
+					timerStats.RunTime.Percentile(50), timerStats.RunTime.Percentile(99))
+				producerStats := router.Stats()
+				fmt.Printf("Queue Producer: messages %d, bytes %d, errors %d, avg batch %.1f, pending %d\n",
+					producerStats.Messages, producerStats.Bytes, producerStats.Errors,
+					producerStats.AvgBatchSize(), producerStats.Pending)
+				if spool != nil {
+					spoolStats := spool.SpoolStats()
+					fmt.Printf("Publish Spool: pending %d, spooled %d, replayed %d, dropped %d\n",
+						spoolStats.Pending, spoolStats.Spooled, spoolStats.Replayed, spoolStats.Dropped)
+				}
+				fmt.Printf("------------------------\n\n")
+			}
+		}
+	}()
+
+	fmt.Println("\n✓ Weather Server is running")
+	fmt.Printf("✓ TCP Server listening on port %d\n", cfg.TCPServer.Port)
+	fmt.Println("✓ Press Ctrl+C to stop")
+
+	<-ctx.Done()
+
+	fmt.Println("\nShutting down gracefully...")
+	return nil
+}
+
+// ensureKafkaTopics creates the topics the pipeline uses, or checks and
+// updates existing ones, failing on a topic that can't be used as configured
+func ensureKafkaTopics(ctx context.Context, cfg *config.KafkaConfig) error {
+	admin, err := queue.NewTopicAdmin(cfg)
+	if err != nil {
+		return err
+	}
+
+	topic := func(name string, partitions int) queue.TopicSpec {
+		return queue.TopicSpec{
+			Name:              name,
+			Partitions:        partitions,
+			ReplicationFactor: cfg.ReplicationFactor,
+			Retention:         cfg.Retention,
+		}
+	}
+	specs := []queue.TopicSpec{
+		topic(cfg.TopicMetrics, cfg.NumPartitions),
+		topic(cfg.TopicAlarms, 1),     // single partition for alarms
+		topic(cfg.TopicQuarantine, 1), // single partition for quarantined readings
+		topic(cfg.TopicConnections, cfg.NumPartitions),
+	}
+	if cfg.TopicDeadLetter != "" {
+		specs = append(specs, topic(cfg.TopicDeadLetter, 1)) // single partition for dead letters
+	}
+	if cfg.TopicLatest != "" {
+		latest := topic(cfg.TopicLatest, cfg.NumPartitions)
+		latest.Compacted = true
+		latest.Retention = 0
+		specs = append(specs, latest)
+	}
+
+	for _, spec := range specs {
+		if err := admin.EnsureTopic(ctx, spec); err != nil {
+			return fmt.Errorf("failed to set up Kafka topic: %w", err)
+		}
+	}
+	return nil
+}
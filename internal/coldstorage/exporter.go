@@ -0,0 +1,162 @@
+// Package coldstorage moves old raw metrics out of Postgres into Parquet
+// files in object storage, where keeping years of readings is cheap.
+package coldstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// Exporter rolls raw metrics older than a cutoff into one Parquet file per
+// UTC day, partitioned by date (prefix/raw_metrics/date=2026-01-01/...),
+// and deletes them from Postgres once the file is stored
+type Exporter struct {
+	db     *database.DB
+	store  ObjectStore
+	after  time.Duration
+	prefix string
+	now    func() time.Time
+}
+
+// NewExporter creates an exporter moving readings older than afterDays
+// days to store, under prefix
+func NewExporter(db *database.DB, store ObjectStore, afterDays int, prefix string) *Exporter {
+	return &Exporter{
+		db:     db,
+		store:  store,
+		after:  time.Duration(afterDays) * 24 * time.Hour,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+// Cutoff returns the start of the oldest day that is kept in Postgres
+func (e *Exporter) Cutoff() time.Time {
+	return e.now().UTC().Truncate(24 * time.Hour).Add(-e.after)
+}
+
+// Run exports every day before the cutoff that still has readings, oldest
+// first, and returns how many files it wrote
+func (e *Exporter) Run(ctx context.Context) (int, error) {
+	cutoff := e.Cutoff()
+	files := 0
+	for {
+		oldest, ok, err := e.db.OldestRawMetric(ctx, cutoff)
+		if err != nil {
+			return files, fmt.Errorf("failed to find oldest reading: %w", err)
+		}
+		if !ok {
+			return files, nil
+		}
+
+		start := oldest.UTC().Truncate(24 * time.Hour)
+		rows, err := e.ExportRange(ctx, start, start.Add(24*time.Hour))
+		if err != nil {
+			return files, err
+		}
+		if rows == 0 {
+			// Whatever MIN saw was gone by the time the range was read;
+			// pick it up on the next run rather than spinning
+			return files, nil
+		}
+		files++
+	}
+}
+
+// ExportRange writes the readings of [start, end) to a Parquet file,
+// uploads it, records it in the manifest and deletes the readings, and
+// returns how many it moved. The read, the manifest entry and the delete
+// share a snapshot transaction: readings that arrive meanwhile are neither
+// exported nor deleted, and nothing is deleted unless the upload and the
+// manifest entry succeeded.
+func (e *Exporter) ExportRange(ctx context.Context, start, end time.Time) (int64, error) {
+	tx, err := e.db.BeginSnapshot(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err := os.CreateTemp("", "raw_metrics-*.parquet")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := newParquetWriter(file)
+	rows, err := tx.ScanRawMetrics(ctx, start, end, writer.Write)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read raw metrics: %w", err)
+	}
+	if rows == 0 {
+		return 0, nil
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to size parquet file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to rewind parquet file: %w", err)
+	}
+
+	key := e.objectKey(start)
+	if err := e.store.Put(ctx, key, file, size); err != nil {
+		return 0, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	export := &database.ColdStorageExport{
+		RangeStart: start,
+		RangeEnd:   end,
+		ObjectURL:  e.store.URL(key),
+		RowCount:   rows,
+		SizeBytes:  size,
+	}
+	err = e.prune(ctx, tx, export)
+	if err != nil {
+		// Nothing was deleted, so the file would only duplicate readings
+		// the next run exports again
+		if removeErr := e.store.Remove(context.WithoutCancel(ctx), key); removeErr != nil {
+			return 0, fmt.Errorf("%w (and failed to remove %s: %v)", err, key, removeErr)
+		}
+		return 0, err
+	}
+
+	fmt.Printf("Exported %d readings from %s to %s (%d bytes)\n",
+		rows, start.Format("2006-01-02"), export.ObjectURL, size)
+	return rows, nil
+}
+
+// prune records the export and deletes its readings
+func (e *Exporter) prune(ctx context.Context, tx *database.Tx, export *database.ColdStorageExport) error {
+	if err := tx.InsertColdStorageExport(ctx, export); err != nil {
+		return fmt.Errorf("failed to record export: %w", err)
+	}
+	deleted, err := tx.DeleteRawMetrics(ctx, export.RangeStart, export.RangeEnd)
+	if err != nil {
+		return fmt.Errorf("failed to delete exported readings: %w", err)
+	}
+	if deleted != export.RowCount {
+		return fmt.Errorf("exported %d readings but would delete %d", export.RowCount, deleted)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit export: %w", err)
+	}
+	return nil
+}
+
+// objectKey names the file for a day's readings. Each export of a day
+// gets its own part, so late readings exported later don't replace it.
+func (e *Exporter) objectKey(day time.Time) string {
+	return path.Join(e.prefix, "raw_metrics",
+		"date="+day.Format("2006-01-02"),
+		fmt.Sprintf("part-%s.parquet", e.now().UTC().Format("20060102T150405.000000000Z")))
+}
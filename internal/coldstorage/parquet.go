@@ -0,0 +1,117 @@
+package coldstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// parquetRow is a raw_metrics row as stored in the exported files. Column
+// names match raw_metrics so a query engine reads them like the table.
+type parquetRow struct {
+	ID             int64     `parquet:"id"`
+	Zipcode        string    `parquet:"zipcode,dict"`
+	StationID      string    `parquet:"station_id,dict"`
+	Timestamp      time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Temperature    *float64  `parquet:"temperature,optional"`
+	Humidity       *float64  `parquet:"humidity,optional"`
+	Precipitation  *float64  `parquet:"precipitation,optional"`
+	WindSpeed      *float64  `parquet:"wind_speed,optional"`
+	WindDirection  *string   `parquet:"wind_direction,optional,dict"`
+	PollutionIndex *float64  `parquet:"pollution_index,optional"`
+	PollenIndex    *float64  `parquet:"pollen_index,optional"`
+	Pressure       *float64  `parquet:"pressure,optional"`
+	Visibility     *float64  `parquet:"visibility,optional"`
+	UVIndex        *float64  `parquet:"uv_index,optional"`
+	SnowDepth      *float64  `parquet:"snow_depth,optional"`
+	HeatIndex      *float64  `parquet:"heat_index,optional"`
+	WindChill      *float64  `parquet:"wind_chill,optional"`
+	DewPoint       *float64  `parquet:"dew_point,optional"`
+	QualityFlags   string    `parquet:"quality_flags"` // JSON object
+	ReceivedAt     time.Time `parquet:"received_at,timestamp(millisecond)"`
+}
+
+func newParquetRow(m *database.RawMetric) (parquetRow, error) {
+	flags := []byte("{}")
+	if len(m.QualityFlags) > 0 {
+		var err error
+		if flags, err = json.Marshal(m.QualityFlags); err != nil {
+			return parquetRow{}, fmt.Errorf("failed to encode quality flags: %w", err)
+		}
+	}
+	return parquetRow{
+		ID:             m.ID,
+		Zipcode:        m.Zipcode,
+		StationID:      m.StationID,
+		Timestamp:      m.Timestamp.UTC(),
+		Temperature:    m.Temperature,
+		Humidity:       m.Humidity,
+		Precipitation:  m.Precipitation,
+		WindSpeed:      m.WindSpeed,
+		WindDirection:  m.WindDirection,
+		PollutionIndex: m.PollutionIndex,
+		PollenIndex:    m.PollenIndex,
+		Pressure:       m.Pressure,
+		Visibility:     m.Visibility,
+		UVIndex:        m.UVIndex,
+		SnowDepth:      m.SnowDepth,
+		HeatIndex:      m.HeatIndex,
+		WindChill:      m.WindChill,
+		DewPoint:       m.DewPoint,
+		QualityFlags:   string(flags),
+		ReceivedAt:     m.ReceivedAt.UTC(),
+	}, nil
+}
+
+// parquetBufferRows is how many rows are buffered before they are handed
+// to the Parquet writer
+const parquetBufferRows = 1000
+
+// parquetWriter writes readings to a zstd-compressed Parquet file
+type parquetWriter struct {
+	w      *parquet.GenericWriter[parquetRow]
+	buffer []parquetRow
+}
+
+func newParquetWriter(out io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w:      parquet.NewGenericWriter[parquetRow](out, parquet.Compression(&parquet.Zstd)),
+		buffer: make([]parquetRow, 0, parquetBufferRows),
+	}
+}
+
+// Write adds a reading to the file
+func (p *parquetWriter) Write(m *database.RawMetric) error {
+	row, err := newParquetRow(m)
+	if err != nil {
+		return err
+	}
+	p.buffer = append(p.buffer, row)
+	if len(p.buffer) == cap(p.buffer) {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flush() error {
+	if _, err := p.w.Write(p.buffer); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	p.buffer = p.buffer[:0]
+	return nil
+}
+
+// Close writes the buffered rows and the file footer
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	if err := p.w.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package coldstorage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+func TestParquetWriter_RoundTrip(t *testing.T) {
+	temp := 21.5
+	ts := time.Date(2026, 1, 1, 12, 5, 0, 0, time.UTC)
+	var metrics []*database.RawMetric
+	for i := 0; i < parquetBufferRows+5; i++ {
+		metrics = append(metrics, &database.RawMetric{
+			ID:           int64(i + 1),
+			Zipcode:      "94105",
+			StationID:    "roof",
+			Timestamp:    ts,
+			Temperature:  &temp,
+			QualityFlags: map[string]string{"temperature": "spike"},
+			ReceivedAt:   ts,
+		})
+	}
+	metrics[1].Temperature = nil
+
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf)
+	for _, m := range metrics {
+		if err := w.Write(m); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rows, err := parquet.Read[parquetRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read parquet file: %v", err)
+	}
+	if len(rows) != len(metrics) {
+		t.Fatalf("Expected %d rows, got %d", len(metrics), len(rows))
+	}
+	if rows[0].Temperature == nil || *rows[0].Temperature != temp || rows[1].Temperature != nil {
+		t.Errorf("Expected optional temperatures to round-trip, got %v and %v", rows[0].Temperature, rows[1].Temperature)
+	}
+	if !rows[0].Timestamp.Equal(ts) || rows[0].QualityFlags != `{"temperature":"spike"}` {
+		t.Errorf("Unexpected row %+v", rows[0])
+	}
+}
+
+func TestExporter_Keys(t *testing.T) {
+	e := NewExporter(nil, nil, 90, "weather")
+	e.now = func() time.Time { return time.Date(2026, 4, 1, 0, 5, 0, 0, time.UTC) }
+
+	if cutoff := e.Cutoff(); !cutoff.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected cutoff 2026-01-01, got %v", cutoff)
+	}
+	want := "weather/raw_metrics/date=2025-12-31/part-20260401T000500.000000000Z.parquet"
+	if key := e.objectKey(time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)); key != want {
+		t.Errorf("Expected key %q, got %q", want, key)
+	}
+}
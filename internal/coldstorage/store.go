@@ -0,0 +1,68 @@
+package coldstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// ObjectStore is where exported files are kept
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Remove(ctx context.Context, key string) error
+	// URL names an object in the manifest
+	URL(key string) string
+}
+
+// S3Store keeps files in a bucket of any S3-compatible store: AWS S3,
+// MinIO, or GCS through its interoperability endpoint with HMAC keys
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store connects to the bucket in cfg. Without an access key it
+// falls back to the AWS environment variables and instance credentials.
+func NewS3Store(cfg config.ExportConfig) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("EXPORT_S3_BUCKET is not set")
+	}
+
+	creds := credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	if cfg.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{},
+		})
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return &S3Store{client: client, bucket: cfg.Bucket}, nil
+}
+
+// Put uploads an object
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: "application/vnd.apache.parquet",
+	})
+	return err
+}
+
+// Remove deletes an object
+func (s *S3Store) Remove(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) URL(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key)
+}
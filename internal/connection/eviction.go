@@ -0,0 +1,80 @@
+package connection
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EvictionPolicy decides what happens when a station identifies while the
+// manager is at its connection limit
+type EvictionPolicy string
+
+const (
+	EvictNone     EvictionPolicy = "reject"         // reject the newcomer
+	EvictIdle     EvictionPolicy = "evict_idle"     // close the longest-idle connection
+	EvictPriority EvictionPolicy = "evict_priority" // close the longest-idle connection of the lowest-priority zipcode
+)
+
+// ParseEvictionPolicy parses a policy name from configuration
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(name); p {
+	case EvictNone, EvictIdle, EvictPriority:
+		return p, nil
+	case "":
+		return EvictNone, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy: %s", name)
+	}
+}
+
+// ParseZipcodePriorities parses "zipcode=priority" entries. Zipcodes not
+// listed have priority 0; higher priorities are evicted last.
+func ParseZipcodePriorities(specs []string) (map[string]int, error) {
+	priorities := make(map[string]int, len(specs))
+	for _, spec := range specs {
+		zipcode, value, ok := strings.Cut(spec, "=")
+		if !ok || strings.TrimSpace(zipcode) == "" {
+			return nil, fmt.Errorf("invalid zipcode priority %q, expected zipcode=priority", spec)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority for zipcode %s: %w", zipcode, err)
+		}
+		priorities[strings.TrimSpace(zipcode)] = priority
+	}
+	return priorities, nil
+}
+
+// evictionVictimLocked picks the connection to close to make room for a
+// station in zipcode, or nil if the policy allows none. Caller must hold m.mu.
+func (m *Manager) evictionVictimLocked(zipcode string) *ClientInfo {
+	if m.evictPolicy != EvictIdle && m.evictPolicy != EvictPriority {
+		return nil
+	}
+
+	newcomer := m.priorities[zipcode]
+	var victim *ClientInfo
+	for _, client := range m.clients {
+		if m.evictPolicy == EvictPriority {
+			priority := m.priorities[client.Zipcode]
+			if priority > newcomer {
+				continue // never make room for a less important station
+			}
+			if victim != nil {
+				current := m.priorities[victim.Zipcode]
+				if priority > current {
+					continue
+				}
+				if priority < current {
+					victim = client
+					continue
+				}
+			}
+		}
+		if victim == nil || client.GetLastHeardFrom().Before(victim.GetLastHeardFrom()) {
+			victim = client
+		}
+	}
+	return victim
+}
@@ -3,19 +3,39 @@ package connection
 import (
 	"fmt"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/smukkama/weather-server/internal/protocol"
 )
 
 // ClientInfo holds information about a connected client
 type ClientInfo struct {
-	ConnectionID  string
-	Zipcode       string
-	City          string
-	ConnectedAt   time.Time
-	LastHeardFrom time.Time
-	Conn          net.Conn
-	mu            sync.RWMutex
+	ConnectionID     string
+	Zipcode          string
+	City             string
+	StationID        string
+	RemoteAddr       string                    // client address, as reported by a PROXY header if present
+	Station          *protocol.StationMetadata // nil if the station sent no metadata
+	Units            protocol.UnitSystem       // unit system the station reports in
+	ConnectedAt      time.Time
+	LastHeardFrom    time.Time
+	Conn             net.Conn
+	DisconnectReason string
+	SessionToken     string
+	detachedAt       time.Time // non-zero while waiting for the client to resume
+	mu               sync.RWMutex
+	writeMu          sync.Mutex // serializes writes to Conn
+
+	// Outbound queue drained by a writer goroutine so senders never block
+	// on a slow client. A nil entry asks the writer to close the connection.
+	queueMu      sync.Mutex
+	outbound     chan []byte
+	writerOn     bool
+	writeTimeout time.Duration
 }
 
 // UpdateLastHeardFrom updates the last activity timestamp
@@ -32,84 +52,607 @@ func (c *ClientInfo) GetLastHeardFrom() time.Time {
 	return c.LastHeardFrom
 }
 
+// GetRemoteAddr returns the client's current network address
+func (c *ClientInfo) GetRemoteAddr() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.RemoteAddr
+}
+
+// GetStation returns the station metadata sent at identify, or nil
+func (c *ClientInfo) GetStation() *protocol.StationMetadata {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Station
+}
+
+// GetUnits returns the unit system the station reports in
+func (c *ClientInfo) GetUnits() protocol.UnitSystem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Units
+}
+
+// SetDisconnectReason records why the client disconnected
+func (c *ClientInfo) SetDisconnectReason(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.DisconnectReason = reason
+}
+
+// GetDisconnectReason returns the recorded disconnect reason
+func (c *ClientInfo) GetDisconnectReason() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.DisconnectReason
+}
+
+// IsDetached reports whether the client's connection dropped and its
+// session is waiting to be resumed
+func (c *ClientInfo) IsDetached() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.detachedAt.IsZero()
+}
+
+// Close closes the client's current network connection once messages
+// already queued for it have been written
+func (c *ClientInfo) Close() error {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.writerOn {
+		select {
+		case c.outbound <- nil:
+			return nil
+		default:
+			// Queue full: the client is stuck anyway, close right away
+		}
+	}
+	return c.closeConn()
+}
+
+// Send encodes a message and queues it for the client as a JSON line. It
+// never blocks: if the client is too slow to drain its queue the message is
+// dropped and ErrSendQueueFull returned. Without a writer goroutine (the
+// client was unregistered) the message is written directly.
+func (c *ClientInfo) Send(msg interface{}) error {
+	data, err := protocol.EncodeMessage(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	data = append(data, '\n')
+
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if !c.writerOn {
+		return c.write(data)
+	}
+	select {
+	case c.outbound <- data:
+		return nil
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// startWriter starts the goroutine draining the outbound queue
+func (c *ClientInfo) startWriter(queueSize int, writeTimeout time.Duration) {
+	c.outbound = make(chan []byte, queueSize)
+	c.writeTimeout = writeTimeout
+	c.writerOn = true
+	go c.writeLoop(c.outbound)
+}
+
+// stopWriter lets the writer finish the queued messages and exit; later
+// sends are written directly
+func (c *ClientInfo) stopWriter() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.writerOn {
+		c.writerOn = false
+		close(c.outbound)
+	}
+}
+
+func (c *ClientInfo) writeLoop(outbound <-chan []byte) {
+	for data := range outbound {
+		if data == nil {
+			c.closeConn()
+			continue
+		}
+		if err := c.write(data); err != nil {
+			// A client that can't take writes within the timeout is treated
+			// as dead; closing ends its read loop
+			fmt.Printf("Write to %s failed, closing connection: %v\n", c.ConnectionID, err)
+			c.closeConn()
+		}
+	}
+}
+
+// write writes data to the current connection, serialized with other
+// writers so lines never interleave
+func (c *ClientInfo) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.Conn.Write(data)
+	return err
+}
+
+func (c *ClientInfo) closeConn() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.Close()
+}
+
+// DuplicatePolicy decides what happens when a station identifies while
+// another connection already holds the same zipcode + station ID
+type DuplicatePolicy string
+
+const (
+	DuplicateAllow   DuplicatePolicy = "allow"   // keep both connections
+	DuplicateReject  DuplicatePolicy = "reject"  // reject the newcomer
+	DuplicateReplace DuplicatePolicy = "replace" // close the old connection
+)
+
+// ParseDuplicatePolicy parses a policy name from configuration
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(name); p {
+	case DuplicateAllow, DuplicateReject, DuplicateReplace:
+		return p, nil
+	case "":
+		return DuplicateAllow, nil
+	default:
+		return "", fmt.Errorf("unknown duplicate station policy: %s", name)
+	}
+}
+
+// Observer is notified of connection lifecycle events. The manager calls
+// observers while holding its lock, so they must not block or call back
+// into the manager.
+type Observer interface {
+	OnRegister(client *ClientInfo)   // a station identified
+	OnUnregister(client *ClientInfo) // a station went away (see GetDisconnectReason)
+	OnIdle(client *ClientInfo)       // a station stopped sending and is about to be closed
+}
+
 // Manager manages all active client connections
 type Manager struct {
-	clients   map[string]*ClientInfo // key: connection_id
-	byZipcode map[string][]string    // key: zipcode, value: []connection_id
-	mu        sync.RWMutex
-	maxConns  int
+	clients     map[string]*ClientInfo // key: connection_id
+	byZipcode   map[string][]string    // key: zipcode, value: []connection_id
+	byStation   map[string][]string    // key: zipcode/station_id, value: []connection_id
+	byCity      map[string][]string    // key: lowercased city, value: []connection_id
+	byStationID map[string][]string    // key: station_id alone, value: []connection_id
+	sessions    map[string]string      // key: session token, value: connection_id
+	mu          sync.RWMutex
+	maxConns    int
+	dupPolicy   DuplicatePolicy
+
+	maxPerZipcode int // 0 = unlimited
+
+	evictPolicy EvictionPolicy
+	priorities  map[string]int // zipcode priorities for EvictPriority
+
+	sendQueueSize int
+	writeTimeout  time.Duration
+
+	registry  Registry   // optional registry shared with other server instances
+	observers []Observer // notified of connection lifecycle events
 }
 
 // NewManager creates a new connection manager
 func NewManager(maxConnections int) *Manager {
 	return &Manager{
-		clients:   make(map[string]*ClientInfo),
-		byZipcode: make(map[string][]string),
-		maxConns:  maxConnections,
+		clients:     make(map[string]*ClientInfo),
+		byZipcode:   make(map[string][]string),
+		byStation:   make(map[string][]string),
+		byCity:      make(map[string][]string),
+		byStationID: make(map[string][]string),
+		sessions:    make(map[string]string),
+		maxConns:    maxConnections,
+		dupPolicy:   DuplicateAllow,
+		evictPolicy: EvictNone,
+
+		sendQueueSize: 64,
+		writeTimeout:  10 * time.Second,
 	}
 }
 
-// Register adds a new client connection
-func (m *Manager) Register(connectionID, zipcode, city string, conn net.Conn) error {
+// SetDuplicatePolicy sets the policy applied to duplicate station identities
+func (m *Manager) SetDuplicatePolicy(policy DuplicatePolicy) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.dupPolicy = policy
+}
 
-	// Check max connections
-	if len(m.clients) >= m.maxConns {
-		return ErrMaxConnectionsReached
+// SetMaxPerZipcode limits how many stations may be connected at once in a
+// single zipcode (0 = unlimited), so one dense deployment cannot take up the
+// whole server
+func (m *Manager) SetMaxPerZipcode(max int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxPerZipcode = max
+}
+
+// SetEvictionPolicy sets what happens when a station identifies at the
+// connection limit. priorities ranks zipcodes for EvictPriority.
+func (m *Manager) SetEvictionPolicy(policy EvictionPolicy, priorities map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictPolicy = policy
+	m.priorities = priorities
+}
+
+// Evicts reports whether the eviction policy may make room for a station
+// identifying at the connection limit
+func (m *Manager) Evicts() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.evictPolicy == EvictIdle || m.evictPolicy == EvictPriority
+}
+
+// AddObserver subscribes an observer to connection lifecycle events
+func (m *Manager) AddObserver(observer Observer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, observer)
+}
+
+// NotifyIdle tells observers that a client has been silent for too long,
+// before the server closes it
+func (m *Manager) NotifyIdle(connectionID string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	client, exists := m.clients[connectionID]
+	if !exists {
+		return
 	}
+	for _, observer := range m.observers {
+		observer.OnIdle(client)
+	}
+}
+
+// SetRegistry makes the manager record its stations in a registry shared
+// with other server instances
+func (m *Manager) SetRegistry(registry Registry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry = registry
+}
+
+// registryEntry describes a client for the shared registry
+func registryEntry(client *ClientInfo) RegistryEntry {
+	return RegistryEntry{
+		Zipcode:      client.Zipcode,
+		StationID:    client.StationID,
+		ConnectionID: client.ConnectionID,
+		RemoteAddr:   client.GetRemoteAddr(),
+		ConnectedAt:  client.ConnectedAt,
+	}
+}
+
+// SetWriteOptions sets the per-connection outbound queue size and the
+// deadline for each write. A queue size of 0 writes synchronously.
+func (m *Manager) SetWriteOptions(queueSize int, writeTimeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sendQueueSize = queueSize
+	m.writeTimeout = writeTimeout
+}
+
+// Register adds a new client connection
+func (m *Manager) Register(connectionID, zipcode, city string, conn net.Conn) error {
+	return m.RegisterStation(connectionID, zipcode, city, "", conn)
+}
+
+// RegisterStation adds a new client connection for a specific station.
+// Stations are identified by zipcode + station ID; an empty station ID is the
+// zipcode's default station. If another connection already holds the same
+// station identity, the manager's DuplicatePolicy decides what happens; at
+// the connection limit, its EvictionPolicy does.
+func (m *Manager) RegisterStation(connectionID, zipcode, city, stationID string, conn net.Conn) error {
+	// Close replaced or evicted connections once the lock is released; their
+	// handlers will find them already unregistered
+	var closing []*ClientInfo
+	defer func() {
+		for _, old := range closing {
+			old.Send(protocol.NewShutdownMessage(old.GetDisconnectReason()))
+			old.Close()
+		}
+	}()
+
+	m.mu.Lock()
 
 	// Check if connection ID already exists
 	if _, exists := m.clients[connectionID]; exists {
+		m.mu.Unlock()
 		return fmt.Errorf("connection ID %s already registered", connectionID)
 	}
 
+	// Apply duplicate station policy
+	identity := stationIdentity(zipcode, stationID)
+	if existing := m.byStation[identity]; len(existing) > 0 {
+		switch m.dupPolicy {
+		case DuplicateReject:
+			m.mu.Unlock()
+			return ErrDuplicateStation
+		case DuplicateReplace:
+			for _, oldID := range existing {
+				old := m.clients[oldID]
+				old.SetDisconnectReason("replaced by newer connection")
+				closing = append(closing, old)
+				m.removeLocked(oldID)
+			}
+		}
+	}
+
+	// Check the zipcode cap
+	if m.maxPerZipcode > 0 && len(m.byZipcode[zipcode]) >= m.maxPerZipcode {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: zipcode %s already has %d stations connected", ErrZipcodeFull, zipcode, m.maxPerZipcode)
+	}
+
+	// Check max connections, making room if the eviction policy allows
+	if len(m.clients) >= m.maxConns {
+		victim := m.evictionVictimLocked(zipcode)
+		if victim == nil {
+			m.mu.Unlock()
+			return ErrMaxConnectionsReached
+		}
+		victim.SetDisconnectReason("evicted to make room for another station")
+		closing = append(closing, victim)
+		m.removeLocked(victim.ConnectionID)
+	}
+
 	now := time.Now()
 	clientInfo := &ClientInfo{
 		ConnectionID:  connectionID,
 		Zipcode:       zipcode,
 		City:          city,
+		StationID:     stationID,
+		RemoteAddr:    remoteAddr(conn),
 		ConnectedAt:   now,
 		LastHeardFrom: now,
 		Conn:          conn,
+		writeTimeout:  m.writeTimeout,
+	}
+	if m.sendQueueSize > 0 {
+		clientInfo.startWriter(m.sendQueueSize, m.writeTimeout)
 	}
 
 	m.clients[connectionID] = clientInfo
 	m.byZipcode[zipcode] = append(m.byZipcode[zipcode], connectionID)
+	m.byStation[identity] = append(m.byStation[identity], connectionID)
+	m.byCity[cityKey(city)] = append(m.byCity[cityKey(city)], connectionID)
+	if stationID != "" {
+		m.byStationID[stationID] = append(m.byStationID[stationID], connectionID)
+	}
+	if m.registry != nil {
+		m.registry.Register(registryEntry(clientInfo))
+	}
+	for _, observer := range m.observers {
+		observer.OnRegister(clientInfo)
+	}
+	m.mu.Unlock()
 
 	return nil
 }
 
+// SetStationMetadata records the metadata a station sent at identify
+func (m *Manager) SetStationMetadata(connectionID string, station protocol.StationMetadata) error {
+	m.mu.RLock()
+	client, exists := m.clients[connectionID]
+	m.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("connection ID %s not found", connectionID)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if station.IsZero() {
+		client.Station = nil
+	} else {
+		client.Station = &station
+	}
+	return nil
+}
+
+// SetUnits records the unit system a station negotiated at identify
+func (m *Manager) SetUnits(connectionID string, units protocol.UnitSystem) error {
+	m.mu.RLock()
+	client, exists := m.clients[connectionID]
+	m.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("connection ID %s not found", connectionID)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.Units = units
+	return nil
+}
+
 // Unregister removes a client connection
 func (m *Manager) Unregister(connectionID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if _, exists := m.clients[connectionID]; !exists {
+		return fmt.Errorf("connection ID %s not found", connectionID)
+	}
+
+	m.removeLocked(connectionID)
+	return nil
+}
+
+// IssueSession creates a resumable session token for a registered connection
+func (m *Manager) IssueSession(connectionID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	client, exists := m.clients[connectionID]
 	if !exists {
-		return fmt.Errorf("connection ID %s not found", connectionID)
+		return "", fmt.Errorf("connection ID %s not found", connectionID)
 	}
 
-	// Remove from zipcode map
-	zipcode := client.Zipcode
-	if connIDs, ok := m.byZipcode[zipcode]; ok {
-		// Remove this connection ID from the slice
-		for i, id := range connIDs {
-			if id == connectionID {
-				m.byZipcode[zipcode] = append(connIDs[:i], connIDs[i+1:]...)
-				break
-			}
-		}
-		// Clean up empty zipcode entries
-		if len(m.byZipcode[zipcode]) == 0 {
-			delete(m.byZipcode, zipcode)
-		}
+	if client.SessionToken != "" {
+		delete(m.sessions, client.SessionToken)
+	}
+	token := uuid.New().String()
+	client.SessionToken = token
+	m.sessions[token] = connectionID
+	return token, nil
+}
+
+// Detach marks a client whose network connection dropped as waiting to be
+// resumed. It only detaches if conn is still the client's current connection,
+// so a stale handler cannot detach a session that already resumed elsewhere.
+func (m *Manager) Detach(connectionID string, conn net.Conn) bool {
+	m.mu.RLock()
+	client, exists := m.clients[connectionID]
+	m.mu.RUnlock()
+
+	if !exists || client.SessionToken == "" {
+		return false
+	}
+
+	client.writeMu.Lock()
+	current := client.Conn
+	client.writeMu.Unlock()
+	if current != conn {
+		return false
+	}
+
+	client.mu.Lock()
+	client.detachedAt = time.Now()
+	client.mu.Unlock()
+	return true
+}
+
+// Resume re-attaches a session to a new network connection, keeping the
+// logical connection ID and all per-station state. If the old connection is
+// still open (the server has not noticed it died yet) it is closed.
+func (m *Manager) Resume(token string, conn net.Conn) (*ClientInfo, error) {
+	m.mu.RLock()
+	connectionID, ok := m.sessions[token]
+	client := m.clients[connectionID]
+	m.mu.RUnlock()
+
+	if !ok || client == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	client.writeMu.Lock()
+	old := client.Conn
+	client.Conn = conn
+	client.writeMu.Unlock()
+
+	client.mu.Lock()
+	wasDetached := !client.detachedAt.IsZero()
+	client.detachedAt = time.Time{}
+	client.LastHeardFrom = time.Now()
+	client.RemoteAddr = remoteAddr(conn)
+	client.mu.Unlock()
+
+	if !wasDetached && old != nil && old != conn {
+		old.Close()
+	}
+
+	// The session may have moved here from another instance or address
+	m.mu.RLock()
+	if m.registry != nil {
+		m.registry.Register(registryEntry(client))
+	}
+	m.mu.RUnlock()
+
+	return client, nil
+}
+
+// ExpireSession unregisters a client that is still detached, returning true
+// if it did so
+func (m *Manager) ExpireSession(connectionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client, exists := m.clients[connectionID]
+	if !exists || !client.IsDetached() {
+		return false
+	}
+
+	client.SetDisconnectReason("session expired")
+	m.removeLocked(connectionID)
+	return true
+}
+
+// removeLocked removes a client from all maps. Caller must hold m.mu.
+func (m *Manager) removeLocked(connectionID string) {
+	client, exists := m.clients[connectionID]
+	if !exists {
+		return
+	}
+
+	removeFromIndex(m.byZipcode, client.Zipcode, connectionID)
+	removeFromIndex(m.byStation, stationIdentity(client.Zipcode, client.StationID), connectionID)
+	removeFromIndex(m.byCity, cityKey(client.City), connectionID)
+	removeFromIndex(m.byStationID, client.StationID, connectionID)
+	if client.SessionToken != "" {
+		delete(m.sessions, client.SessionToken)
+	}
+	client.stopWriter()
+	if m.registry != nil {
+		m.registry.Unregister(registryEntry(client))
+	}
+	for _, observer := range m.observers {
+		observer.OnUnregister(client)
 	}
 
 	// Remove from clients map
 	delete(m.clients, connectionID)
+}
 
-	return nil
+// removeFromIndex removes a connection ID from a secondary index entry,
+// cleaning up empty entries
+func removeFromIndex(index map[string][]string, key, connectionID string) {
+	connIDs, ok := index[key]
+	if !ok {
+		return
+	}
+
+	for i, id := range connIDs {
+		if id == connectionID {
+			index[key] = append(connIDs[:i], connIDs[i+1:]...)
+			break
+		}
+	}
+
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// cityKey normalizes a city name for the city index
+func cityKey(city string) string {
+	return strings.ToLower(strings.TrimSpace(city))
+}
+
+// copyIDs returns a copy of an index entry so callers can use it without
+// holding the lock
+func copyIDs(connIDs []string) []string {
+	result := make([]string, len(connIDs))
+	copy(result, connIDs)
+	return result
+}
+
+// stationIdentity returns the key identifying a station across connections
+func stationIdentity(zipcode, stationID string) string {
+	return zipcode + "/" + stationID
 }
 
 // Get retrieves client information by connection ID
@@ -126,11 +669,156 @@ func (m *Manager) GetByZipcode(zipcode string) []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	connIDs := m.byZipcode[zipcode]
-	// Return a copy to avoid race conditions
-	result := make([]string, len(connIDs))
-	copy(result, connIDs)
-	return result
+	return copyIDs(m.byZipcode[zipcode])
+}
+
+// GetByCity retrieves all connection IDs for a city, ignoring case
+func (m *Manager) GetByCity(city string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyIDs(m.byCity[cityKey(city)])
+}
+
+// GetByStationID retrieves all connection IDs for a station ID, across
+// zipcodes
+func (m *Manager) GetByStationID(stationID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyIDs(m.byStationID[stationID])
+}
+
+// ListFilter selects connections for List; empty fields match everything
+type ListFilter struct {
+	Zipcode   string
+	City      string // case-insensitive
+	StationID string
+}
+
+func (f ListFilter) matches(c *ClientInfo) bool {
+	return (f.Zipcode == "" || c.Zipcode == f.Zipcode) &&
+		(f.City == "" || cityKey(c.City) == cityKey(f.City)) &&
+		(f.StationID == "" || c.StationID == f.StationID)
+}
+
+// List returns one page of the clients matching filter, oldest connection
+// first, along with the total number of matches. A limit of 0 returns all
+// matches from offset on.
+func (m *Manager) List(filter ListFilter, offset, limit int) ([]*ClientInfo, int) {
+	m.mu.RLock()
+	var candidates []string
+	switch {
+	case filter.Zipcode != "":
+		candidates = m.byZipcode[filter.Zipcode]
+	case filter.StationID != "":
+		candidates = m.byStationID[filter.StationID]
+	case filter.City != "":
+		candidates = m.byCity[cityKey(filter.City)]
+	default:
+		candidates = make([]string, 0, len(m.clients))
+		for connID := range m.clients {
+			candidates = append(candidates, connID)
+		}
+	}
+
+	var matches []*ClientInfo
+	for _, connID := range candidates {
+		if client := m.clients[connID]; client != nil && filter.matches(client) {
+			matches = append(matches, client)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].ConnectedAt.Equal(matches[j].ConnectedAt) {
+			return matches[i].ConnectedAt.Before(matches[j].ConnectedAt)
+		}
+		return matches[i].ConnectionID < matches[j].ConnectionID
+	})
+
+	total := len(matches)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total {
+		return nil, total
+	}
+	end := total
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return matches[offset:end], total
+}
+
+// SendToConnection pushes a server-to-client message to a single connection
+func (m *Manager) SendToConnection(connectionID string, msg interface{}) error {
+	client, exists := m.Get(connectionID)
+	if !exists {
+		return fmt.Errorf("connection ID %s not found", connectionID)
+	}
+
+	if err := client.Send(msg); err != nil {
+		return fmt.Errorf("failed to send to connection %s: %w", connectionID, err)
+	}
+	return nil
+}
+
+// SendToZipcode pushes a server-to-client message to every connection in a zipcode.
+// It returns the number of connections the message was delivered to and the
+// last error encountered, if any.
+func (m *Manager) SendToZipcode(zipcode string, msg interface{}) (int, error) {
+	var lastErr error
+	sent := 0
+
+	for _, connID := range m.GetByZipcode(zipcode) {
+		if err := m.SendToConnection(connID, msg); err != nil {
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+
+	return sent, lastErr
+}
+
+// Kick disconnects a client: it is unregistered (a resumable session is
+// dropped too), told why with a shutdown message, and closed. The server
+// cleans up the connection's timers when its read loop exits.
+func (m *Manager) Kick(connectionID, reason string) error {
+	m.mu.Lock()
+	client, exists := m.clients[connectionID]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("connection ID %s not found", connectionID)
+	}
+	client.SetDisconnectReason("kicked: " + reason)
+	m.removeLocked(connectionID)
+	m.mu.Unlock()
+
+	// Best effort: the client may already be gone
+	client.Send(protocol.NewShutdownMessage(reason))
+	client.Close()
+	return nil
+}
+
+// Broadcast sends an operator notice to every connection in a zipcode, or to
+// all connections if zipcode is empty. It returns the number of connections
+// the notice was delivered to and the last error encountered, if any.
+func (m *Manager) Broadcast(zipcode, message string) (int, error) {
+	notice := protocol.NewNoticeMessage(message)
+	if zipcode != "" {
+		return m.SendToZipcode(zipcode, notice)
+	}
+
+	var lastErr error
+	sent := 0
+	for _, connID := range m.GetAllConnections() {
+		if err := m.SendToConnection(connID, notice); err != nil {
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	return sent, lastErr
 }
 
 // UpdateActivity updates the last heard from timestamp for a connection
@@ -201,11 +889,26 @@ func (m *Manager) Stats() ManagerStats {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return ManagerStats{
+	stats := ManagerStats{
 		TotalConnections: len(m.clients),
 		UniqueZipcodes:   len(m.byZipcode),
 		MaxConnections:   m.maxConns,
+		Models:           make(map[string]int),
+		FirmwareVersions: make(map[string]int),
+	}
+	for _, client := range m.clients {
+		station := client.GetStation()
+		if station == nil {
+			continue
+		}
+		if station.Model != "" {
+			stats.Models[station.Model]++
+		}
+		if station.FirmwareVersion != "" {
+			stats.FirmwareVersions[station.FirmwareVersion]++
+		}
 	}
+	return stats
 }
 
 // ManagerStats contains statistics about the connection manager
@@ -213,10 +916,16 @@ type ManagerStats struct {
 	TotalConnections int
 	UniqueZipcodes   int
 	MaxConnections   int
+	Models           map[string]int // connections per reported station model
+	FirmwareVersions map[string]int // connections per reported firmware version
 }
 
 var (
 	ErrMaxConnectionsReached = &ConnectionError{"maximum connections reached"}
+	ErrDuplicateStation      = &ConnectionError{"station already connected"}
+	ErrZipcodeFull           = &ConnectionError{"zipcode station limit reached"}
+	ErrSessionNotFound       = &ConnectionError{"session not found or expired"}
+	ErrSendQueueFull         = &ConnectionError{"send queue full"}
 )
 
 // ConnectionError represents a connection error
@@ -227,3 +936,11 @@ type ConnectionError struct {
 func (e *ConnectionError) Error() string {
 	return e.msg
 }
+
+// remoteAddr returns conn's remote address as a string, or "" if unknown
+func remoteAddr(conn net.Conn) string {
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
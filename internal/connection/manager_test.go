@@ -1,9 +1,16 @@
 package connection
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
 )
 
 type mockAddr struct{}
@@ -22,6 +29,34 @@ func (m *mockConn) SetDeadline(t time.Time) error      { return nil }
 func (m *mockConn) SetReadDeadline(t time.Time) error  { return nil }
 func (m *mockConn) SetWriteDeadline(t time.Time) error { return nil }
 
+// bufferConn records everything written to it
+type bufferConn struct {
+	mockConn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *bufferConn) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *bufferConn) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits for the client's writer goroutine to deliver substr
+func (b *bufferConn) waitFor(substr string) string {
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(b.String(), substr) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return b.String()
+}
+
 func TestManager_Register(t *testing.T) {
 	m := NewManager(10)
 	conn := &mockConn{}
@@ -167,3 +202,491 @@ func TestManager_Stats(t *testing.T) {
 		t.Errorf("Expected max 100, got %d", stats.MaxConnections)
 	}
 }
+
+func TestManager_StationMetadataStats(t *testing.T) {
+	m := NewManager(100)
+	conn := &mockConn{}
+
+	m.Register("conn1", "90210", "Beverly Hills", conn)
+	m.Register("conn2", "33139", "Miami Beach", conn)
+	m.Register("conn3", "10001", "New York", conn)
+	m.SetStationMetadata("conn1", protocol.StationMetadata{Model: "WS-2000", FirmwareVersion: "1.4.2"})
+	m.SetStationMetadata("conn2", protocol.StationMetadata{Model: "WS-2000", FirmwareVersion: "1.5.0"})
+	m.SetStationMetadata("conn3", protocol.StationMetadata{})
+
+	stats := m.Stats()
+	if stats.Models["WS-2000"] != 2 {
+		t.Errorf("Expected 2 WS-2000 stations, got %v", stats.Models)
+	}
+	if stats.FirmwareVersions["1.4.2"] != 1 || stats.FirmwareVersions["1.5.0"] != 1 {
+		t.Errorf("Unexpected firmware counts: %v", stats.FirmwareVersions)
+	}
+
+	client, _ := m.Get("conn3")
+	if client.GetStation() != nil {
+		t.Error("Expected no metadata for a station that sent none")
+	}
+}
+
+func TestManager_SendToConnection(t *testing.T) {
+	m := NewManager(10)
+	conn := &bufferConn{}
+
+	m.Register("conn1", "90210", "Beverly Hills", conn)
+
+	if err := m.SendToConnection("conn1", protocol.NewRequestMetricsNowMessage()); err != nil {
+		t.Fatalf("SendToConnection failed: %v", err)
+	}
+
+	want := `{"type":"request_metrics_now"}` + "\n"
+	if got := conn.waitFor("\n"); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if err := m.SendToConnection("missing", protocol.NewRequestMetricsNowMessage()); err == nil {
+		t.Error("Expected error for unknown connection")
+	}
+}
+
+func TestManager_SendToZipcode(t *testing.T) {
+	m := NewManager(10)
+	conn1 := &bufferConn{}
+	conn2 := &bufferConn{}
+	conn3 := &bufferConn{}
+
+	m.Register("conn1", "90210", "Beverly Hills", conn1)
+	m.Register("conn2", "90210", "Beverly Hills", conn2)
+	m.Register("conn3", "33139", "Miami Beach", conn3)
+
+	sent, err := m.SendToZipcode("90210", protocol.NewSetIntervalMessage(time.Minute))
+	if err != nil {
+		t.Fatalf("SendToZipcode failed: %v", err)
+	}
+	if sent != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", sent)
+	}
+
+	if !strings.Contains(conn1.waitFor(`"interval_seconds":60`), `"interval_seconds":60`) {
+		t.Errorf("conn1 did not receive set_interval: %q", conn1.String())
+	}
+	if conn3.String() != "" {
+		t.Errorf("conn3 should not receive messages for another zipcode: %q", conn3.String())
+	}
+}
+
+func TestManager_DuplicateStationReject(t *testing.T) {
+	m := NewManager(10)
+	m.SetDuplicatePolicy(DuplicateReject)
+	conn := &mockConn{}
+
+	if err := m.RegisterStation("conn1", "90210", "Beverly Hills", "roof", conn); err != nil {
+		t.Fatalf("RegisterStation failed: %v", err)
+	}
+
+	err := m.RegisterStation("conn2", "90210", "Beverly Hills", "roof", conn)
+	if err != ErrDuplicateStation {
+		t.Errorf("Expected ErrDuplicateStation, got %v", err)
+	}
+
+	// A different station in the same zipcode is fine
+	if err := m.RegisterStation("conn3", "90210", "Beverly Hills", "garden", conn); err != nil {
+		t.Errorf("Expected distinct station to register, got %v", err)
+	}
+
+	// Once the first connection leaves, the station may reconnect
+	m.Unregister("conn1")
+	if err := m.RegisterStation("conn4", "90210", "Beverly Hills", "roof", conn); err != nil {
+		t.Errorf("Expected reconnect to succeed, got %v", err)
+	}
+}
+
+func TestManager_DuplicateStationReplace(t *testing.T) {
+	m := NewManager(10)
+	m.SetDuplicatePolicy(DuplicateReplace)
+	oldConn := &bufferConn{}
+
+	m.RegisterStation("conn1", "90210", "Beverly Hills", "roof", oldConn)
+	if err := m.RegisterStation("conn2", "90210", "Beverly Hills", "roof", &mockConn{}); err != nil {
+		t.Fatalf("RegisterStation failed: %v", err)
+	}
+
+	if _, exists := m.Get("conn1"); exists {
+		t.Error("Expected old connection to be replaced")
+	}
+	if m.Count() != 1 {
+		t.Errorf("Expected 1 connection, got %d", m.Count())
+	}
+	if !strings.Contains(oldConn.String(), `"type":"shutdown"`) {
+		t.Errorf("Expected old connection to receive shutdown, got %q", oldConn.String())
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	if p, err := ParseDuplicatePolicy(""); err != nil || p != DuplicateAllow {
+		t.Errorf("Expected default allow policy, got %v (%v)", p, err)
+	}
+	if _, err := ParseDuplicatePolicy("bogus"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestManager_SessionResume(t *testing.T) {
+	m := NewManager(10)
+	oldConn := &mockConn{}
+	newConn := &bufferConn{}
+
+	m.Register("conn1", "90210", "Beverly Hills", oldConn)
+	token, err := m.IssueSession("conn1")
+	if err != nil {
+		t.Fatalf("IssueSession failed: %v", err)
+	}
+
+	// A handler for some other connection cannot detach the session
+	if m.Detach("conn1", &mockConn{}) {
+		t.Error("Detach should ignore connections the client no longer owns")
+	}
+
+	if !m.Detach("conn1", oldConn) {
+		t.Fatal("Detach failed")
+	}
+	client, _ := m.Get("conn1")
+	if !client.IsDetached() {
+		t.Error("Expected client to be detached")
+	}
+
+	resumed, err := m.Resume(token, newConn)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if resumed.ConnectionID != "conn1" || resumed.IsDetached() {
+		t.Errorf("Expected conn1 to be re-attached, got %s (detached=%v)", resumed.ConnectionID, resumed.IsDetached())
+	}
+
+	// Messages now go to the new connection
+	m.SendToConnection("conn1", protocol.NewRequestMetricsNowMessage())
+	if newConn.waitFor("\n") == "" {
+		t.Error("Expected message on resumed connection")
+	}
+
+	// Expiry only applies to detached sessions
+	if m.ExpireSession("conn1") {
+		t.Error("ExpireSession should not remove an attached client")
+	}
+}
+
+func TestManager_SessionExpire(t *testing.T) {
+	m := NewManager(10)
+	conn := &mockConn{}
+
+	m.Register("conn1", "90210", "Beverly Hills", conn)
+	token, _ := m.IssueSession("conn1")
+	m.Detach("conn1", conn)
+
+	if !m.ExpireSession("conn1") {
+		t.Fatal("Expected detached session to expire")
+	}
+	if m.Count() != 0 {
+		t.Errorf("Expected 0 connections, got %d", m.Count())
+	}
+	if _, err := m.Resume(token, conn); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+// blockingConn never completes a write, like a client that stopped reading
+type blockingConn struct {
+	mockConn
+	release chan struct{}
+}
+
+func (b *blockingConn) Write(p []byte) (n int, err error) {
+	<-b.release
+	return len(p), nil
+}
+
+func TestManager_SendQueueFull(t *testing.T) {
+	m := NewManager(10)
+	m.SetWriteOptions(2, time.Second)
+	conn := &blockingConn{release: make(chan struct{})}
+	defer close(conn.release)
+
+	m.Register("conn1", "90210", "Beverly Hills", conn)
+
+	// One message is stuck in the writer, two fill the queue; the next must
+	// fail fast instead of blocking the caller
+	done := make(chan error)
+	go func() {
+		var err error
+		for i := 0; i < 4 && err == nil; i++ {
+			err = m.SendToConnection("conn1", protocol.NewRequestMetricsNowMessage())
+			time.Sleep(10 * time.Millisecond)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSendQueueFull) {
+			t.Errorf("Expected ErrSendQueueFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked on a slow client")
+	}
+}
+
+// fakeRegistry records registry calls
+type fakeRegistry struct {
+	mu      sync.Mutex
+	entries map[string]RegistryEntry
+}
+
+func (f *fakeRegistry) Register(entry RegistryEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[registryKey(entry.Zipcode, entry.StationID)] = entry
+}
+
+func (f *fakeRegistry) Unregister(entry RegistryEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := registryKey(entry.Zipcode, entry.StationID)
+	if f.entries[key].ConnectionID == entry.ConnectionID {
+		delete(f.entries, key)
+	}
+}
+
+func TestManager_Registry(t *testing.T) {
+	m := NewManager(10)
+	registry := &fakeRegistry{entries: make(map[string]RegistryEntry)}
+	m.SetRegistry(registry)
+
+	m.RegisterStation("conn1", "90210", "Beverly Hills", "roof", &mockConn{})
+	entry, ok := registry.entries[registryKey("90210", "roof")]
+	if !ok || entry.ConnectionID != "conn1" {
+		t.Fatalf("Expected conn1 to be registered, got %+v", registry.entries)
+	}
+
+	// A newer connection for the station takes over the entry, and the old
+	// one leaving must not remove it
+	m.RegisterStation("conn2", "90210", "Beverly Hills", "roof", &mockConn{})
+	m.Unregister("conn1")
+	if entry := registry.entries[registryKey("90210", "roof")]; entry.ConnectionID != "conn2" {
+		t.Errorf("Expected conn2 to own the station, got %+v", entry)
+	}
+
+	m.Unregister("conn2")
+	if len(registry.entries) != 0 {
+		t.Errorf("Expected registry to be empty, got %+v", registry.entries)
+	}
+}
+
+// recordingObserver records lifecycle events as "event:connID"
+type recordingObserver struct {
+	events []string
+}
+
+func (r *recordingObserver) OnRegister(c *ClientInfo) {
+	r.events = append(r.events, "register:"+c.ConnectionID)
+}
+
+func (r *recordingObserver) OnUnregister(c *ClientInfo) {
+	r.events = append(r.events, "unregister:"+c.ConnectionID+":"+c.GetDisconnectReason())
+}
+
+func (r *recordingObserver) OnIdle(c *ClientInfo) {
+	r.events = append(r.events, "idle:"+c.ConnectionID)
+}
+
+func TestManager_Observers(t *testing.T) {
+	m := NewManager(10)
+	m.SetDuplicatePolicy(DuplicateReplace)
+	observer := &recordingObserver{}
+	m.AddObserver(observer)
+
+	m.RegisterStation("conn1", "90210", "Beverly Hills", "roof", &mockConn{})
+	m.NotifyIdle("conn1")
+	m.RegisterStation("conn2", "90210", "Beverly Hills", "roof", &mockConn{})
+	m.Unregister("conn2")
+	m.NotifyIdle("conn2") // already gone
+
+	want := []string{
+		"register:conn1",
+		"idle:conn1",
+		"unregister:conn1:replaced by newer connection",
+		"register:conn2",
+		"unregister:conn2:",
+	}
+	if strings.Join(observer.events, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected events %v, got %v", want, observer.events)
+	}
+}
+
+func TestManager_Kick(t *testing.T) {
+	m := NewManager(10)
+	conn := &bufferConn{}
+	m.Register("conn1", "90210", "Beverly Hills", conn)
+	client, _ := m.Get("conn1")
+
+	if err := m.Kick("conn1", "flooding"); err != nil {
+		t.Fatalf("Kick failed: %v", err)
+	}
+	if _, exists := m.Get("conn1"); exists {
+		t.Error("Expected kicked client to be unregistered")
+	}
+	if !strings.Contains(conn.waitFor("flooding"), `{"type":"shutdown","reason":"flooding"}`) {
+		t.Errorf("Expected shutdown message, got %q", conn.String())
+	}
+	if reason := client.GetDisconnectReason(); reason != "kicked: flooding" {
+		t.Errorf("Expected kick reason, got %q", reason)
+	}
+	if err := m.Kick("conn1", "again"); err == nil {
+		t.Error("Expected error kicking an unknown connection")
+	}
+}
+
+func TestManager_Broadcast(t *testing.T) {
+	m := NewManager(10)
+	local := &bufferConn{}
+	remote := &bufferConn{}
+	m.Register("conn1", "90210", "Beverly Hills", local)
+	m.Register("conn2", "10001", "New York", remote)
+
+	if sent, err := m.Broadcast("90210", "hello"); sent != 1 || err != nil {
+		t.Errorf("Expected 1 delivery, got %d (%v)", sent, err)
+	}
+	if sent, err := m.Broadcast("", "everyone"); sent != 2 || err != nil {
+		t.Errorf("Expected 2 deliveries, got %d (%v)", sent, err)
+	}
+
+	local.waitFor("everyone")
+	remote.waitFor("everyone")
+	if !strings.Contains(local.String(), `{"type":"notice","message":"hello"}`) {
+		t.Errorf("Expected zipcode notice, got %q", local.String())
+	}
+	if strings.Contains(remote.String(), "hello") {
+		t.Errorf("Notice leaked to another zipcode: %q", remote.String())
+	}
+}
+
+func TestManager_MaxPerZipcode(t *testing.T) {
+	m := NewManager(10)
+	m.SetMaxPerZipcode(2)
+
+	m.RegisterStation("conn1", "90210", "Beverly Hills", "roof", &mockConn{})
+	m.RegisterStation("conn2", "90210", "Beverly Hills", "garden", &mockConn{})
+
+	err := m.RegisterStation("conn3", "90210", "Beverly Hills", "shed", &mockConn{})
+	if !errors.Is(err, ErrZipcodeFull) {
+		t.Fatalf("Expected ErrZipcodeFull, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "90210") {
+		t.Errorf("Expected the error to name the zipcode, got %q", err)
+	}
+
+	// Other zipcodes are unaffected
+	if err := m.RegisterStation("conn4", "10001", "New York", "roof", &mockConn{}); err != nil {
+		t.Errorf("Expected other zipcode to register, got %v", err)
+	}
+
+	// A slot frees up when a station leaves
+	m.Unregister("conn1")
+	if err := m.RegisterStation("conn3", "90210", "Beverly Hills", "shed", &mockConn{}); err != nil {
+		t.Errorf("Expected registration after a station left, got %v", err)
+	}
+}
+
+func TestManager_CityAndStationIndexes(t *testing.T) {
+	m := NewManager(10)
+	m.RegisterStation("conn1", "90210", "Beverly Hills", "roof", &mockConn{})
+	m.RegisterStation("conn2", "90211", "Beverly Hills", "roof", &mockConn{})
+	m.RegisterStation("conn3", "10001", "New York", "garden", &mockConn{})
+
+	if ids := m.GetByCity("beverly hills"); len(ids) != 2 {
+		t.Errorf("Expected 2 connections in Beverly Hills, got %v", ids)
+	}
+	if ids := m.GetByStationID("roof"); len(ids) != 2 {
+		t.Errorf("Expected 2 roof stations, got %v", ids)
+	}
+
+	m.Unregister("conn1")
+	if ids := m.GetByCity("Beverly Hills"); len(ids) != 1 || ids[0] != "conn2" {
+		t.Errorf("Expected only conn2 in Beverly Hills, got %v", ids)
+	}
+	if ids := m.GetByStationID("roof"); len(ids) != 1 || ids[0] != "conn2" {
+		t.Errorf("Expected only conn2 as roof, got %v", ids)
+	}
+}
+
+func TestManager_List(t *testing.T) {
+	m := NewManager(10)
+	for i, zip := range []string{"90210", "90210", "90210", "10001"} {
+		m.RegisterStation(fmt.Sprintf("conn%d", i), zip, "Somewhere", fmt.Sprintf("s%d", i), &mockConn{})
+	}
+
+	page, total := m.List(ListFilter{Zipcode: "90210"}, 0, 2)
+	if total != 3 || len(page) != 2 {
+		t.Fatalf("Expected 2 of 3 matches, got %d of %d", len(page), total)
+	}
+	next, _ := m.List(ListFilter{Zipcode: "90210"}, 2, 2)
+	if len(next) != 1 || next[0].ConnectionID == page[0].ConnectionID || next[0].ConnectionID == page[1].ConnectionID {
+		t.Errorf("Expected a distinct last page, got %v", next)
+	}
+
+	if page, total := m.List(ListFilter{City: "SOMEWHERE", StationID: "s3"}, 0, 0); total != 1 || page[0].ConnectionID != "conn3" {
+		t.Errorf("Expected conn3, got %d matches", total)
+	}
+	if page, total := m.List(ListFilter{}, 10, 5); total != 4 || page != nil {
+		t.Errorf("Expected empty page past the end, got %d of %d", len(page), total)
+	}
+}
+
+func TestManager_EvictIdle(t *testing.T) {
+	m := NewManager(2)
+	m.SetEvictionPolicy(EvictIdle, nil)
+	idle := &bufferConn{}
+
+	m.Register("conn1", "90210", "Beverly Hills", idle)
+	m.Register("conn2", "10001", "New York", &mockConn{})
+	client, _ := m.Get("conn1")
+	client.mu.Lock()
+	client.LastHeardFrom = time.Now().Add(-time.Hour)
+	client.mu.Unlock()
+
+	if err := m.Register("conn3", "60601", "Chicago", &mockConn{}); err != nil {
+		t.Fatalf("Expected eviction to make room, got %v", err)
+	}
+	if _, exists := m.Get("conn1"); exists {
+		t.Error("Expected the longest-idle connection to be evicted")
+	}
+	if !strings.Contains(idle.String(), `"type":"shutdown"`) {
+		t.Errorf("Expected evicted connection to receive shutdown, got %q", idle.String())
+	}
+}
+
+func TestManager_EvictPriority(t *testing.T) {
+	m := NewManager(2)
+	priorities, err := ParseZipcodePriorities([]string{"90210=10", "10001=5"})
+	if err != nil {
+		t.Fatalf("ParseZipcodePriorities failed: %v", err)
+	}
+	m.SetEvictionPolicy(EvictPriority, priorities)
+
+	m.Register("conn1", "90210", "Beverly Hills", &mockConn{})
+	m.Register("conn2", "10001", "New York", &mockConn{})
+
+	// An unlisted (priority 0) zipcode cannot push anyone out
+	if err := m.Register("conn3", "60601", "Chicago", &mockConn{}); err != ErrMaxConnectionsReached {
+		t.Errorf("Expected ErrMaxConnectionsReached, got %v", err)
+	}
+
+	// A top-priority zipcode evicts the lowest-priority connection
+	if err := m.Register("conn4", "90210", "Beverly Hills", &mockConn{}); err != nil {
+		t.Fatalf("Expected eviction to make room, got %v", err)
+	}
+	if _, exists := m.Get("conn2"); exists {
+		t.Error("Expected the lower-priority connection to be evicted")
+	}
+	if _, exists := m.Get("conn1"); !exists {
+		t.Error("Expected the higher-priority connection to stay")
+	}
+}
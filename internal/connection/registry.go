@@ -0,0 +1,269 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Registry records which server instance owns each station, so several
+// instances can run behind a load balancer and tooling can find any
+// station. The manager calls it while holding its lock, so implementations
+// must not block.
+type Registry interface {
+	Register(entry RegistryEntry)
+	Unregister(entry RegistryEntry)
+}
+
+// RegistryEntry describes a station's connection to a server instance
+type RegistryEntry struct {
+	Zipcode      string    `json:"zipcode"`
+	StationID    string    `json:"station_id,omitempty"`
+	ConnectionID string    `json:"connection_id"`
+	Instance     string    `json:"instance"`
+	RemoteAddr   string    `json:"remote_addr,omitempty"`
+	ConnectedAt  time.Time `json:"connected_at"`
+}
+
+const registryKeyPrefix = "weather:station:"
+
+// compareAndDelete deletes a station key only if it still belongs to the
+// given connection, so an instance never removes a station that has since
+// reconnected elsewhere
+var compareAndDelete = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current and cjson.decode(current).connection_id == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// registryOp is a change waiting to be applied to Redis
+type registryOp struct {
+	entry  RegistryEntry
+	remove bool
+}
+
+// RedisRegistry is a Registry shared through Redis. Each station is a key
+// holding its RegistryEntry with a TTL that the owning instance keeps
+// refreshing, so stations of a crashed instance disappear on their own.
+// Changes are applied by a background goroutine.
+type RedisRegistry struct {
+	redis    *redis.Client
+	instance string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	local   map[string]RegistryEntry // stations owned by this instance
+	pending map[string]registryOp    // changes to apply, by key
+	notify  chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRedisRegistry creates a registry for the given server instance
+func NewRedisRegistry(client *redis.Client, instance string, ttl time.Duration) *RedisRegistry {
+	if ttl <= 0 {
+		ttl = 90 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RedisRegistry{
+		redis:    client,
+		instance: instance,
+		ttl:      ttl,
+		local:    make(map[string]RegistryEntry),
+		pending:  make(map[string]registryOp),
+		notify:   make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Instance returns the ID of the server instance this registry records
+func (r *RedisRegistry) Instance() string {
+	return r.instance
+}
+
+// Start starts applying changes and refreshing this instance's stations
+func (r *RedisRegistry) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop stops the registry and removes this instance's stations
+func (r *RedisRegistry) Stop() {
+	r.cancel()
+	r.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remove := make(map[string]string) // key -> connection ID
+	for key, op := range r.pending {
+		if op.remove {
+			remove[key] = op.entry.ConnectionID
+		}
+	}
+	for key, entry := range r.local {
+		remove[key] = entry.ConnectionID
+	}
+	for key, connID := range remove {
+		if err := compareAndDelete.Run(ctx, r.redis, []string{key}, connID).Err(); err != nil {
+			fmt.Printf("Failed to remove %s from registry: %v\n", key, err)
+		}
+	}
+}
+
+// Register records that this instance owns a station
+func (r *RedisRegistry) Register(entry RegistryEntry) {
+	entry.Instance = r.instance
+	key := registryKey(entry.Zipcode, entry.StationID)
+
+	r.mu.Lock()
+	r.local[key] = entry
+	r.pending[key] = registryOp{entry: entry}
+	r.mu.Unlock()
+	r.wake()
+}
+
+// Unregister records that this instance no longer owns a station
+func (r *RedisRegistry) Unregister(entry RegistryEntry) {
+	key := registryKey(entry.Zipcode, entry.StationID)
+
+	r.mu.Lock()
+	if current, ok := r.local[key]; ok && current.ConnectionID == entry.ConnectionID {
+		delete(r.local, key)
+		r.pending[key] = registryOp{entry: entry, remove: true}
+	}
+	r.mu.Unlock()
+	r.wake()
+}
+
+// Lookup finds which instance owns a station, returning nil if no instance
+// does
+func (r *RedisRegistry) Lookup(ctx context.Context, zipcode, stationID string) (*RegistryEntry, error) {
+	data, err := r.redis.Get(ctx, registryKey(zipcode, stationID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up station: %w", err)
+	}
+
+	var entry RegistryEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode registry entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// List returns every registered station across all instances
+func (r *RedisRegistry) List(ctx context.Context) ([]RegistryEntry, error) {
+	var entries []RegistryEntry
+	iter := r.redis.Scan(ctx, 0, registryKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		data, err := r.redis.Get(ctx, iter.Val()).Bytes()
+		if err == redis.Nil {
+			continue // expired while scanning
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read registry entry: %w", err)
+		}
+		var entry RegistryEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode registry entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan registry: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *RedisRegistry) wake() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RedisRegistry) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.notify:
+			r.flush()
+		case <-ticker.C:
+			r.refresh()
+		}
+	}
+}
+
+// flush applies pending registrations and removals
+func (r *RedisRegistry) flush() {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]registryOp)
+	r.mu.Unlock()
+
+	for key, op := range pending {
+		var err error
+		if op.remove {
+			err = compareAndDelete.Run(r.ctx, r.redis, []string{key}, op.entry.ConnectionID).Err()
+		} else {
+			err = r.set(key, op.entry)
+		}
+		if err != nil {
+			fmt.Printf("Failed to update registry for %s: %v\n", key, err)
+		}
+	}
+}
+
+// refresh rewrites every local station to extend its TTL
+func (r *RedisRegistry) refresh() {
+	r.mu.Lock()
+	entries := make(map[string]RegistryEntry, len(r.local))
+	for key, entry := range r.local {
+		entries[key] = entry
+	}
+	r.mu.Unlock()
+
+	pipe := r.redis.Pipeline()
+	for key, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		pipe.Set(r.ctx, key, data, r.ttl)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil && r.ctx.Err() == nil {
+		fmt.Printf("Failed to refresh registry: %v\n", err)
+	}
+}
+
+func (r *RedisRegistry) set(key string, entry RegistryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(r.ctx, key, data, r.ttl).Err()
+}
+
+func registryKey(zipcode, stationID string) string {
+	return registryKeyPrefix + stationIdentity(zipcode, stationID)
+}
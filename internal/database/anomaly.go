@@ -0,0 +1,173 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrAnomalyRuleNotFound is returned when deleting an anomaly rule that
+// doesn't exist
+var ErrAnomalyRuleNotFound = errors.New("anomaly rule not found")
+
+// ValidateAnomalyRule checks an anomaly rule before it is stored
+func ValidateAnomalyRule(r *AnomalyRule) error {
+	if r.Zipcode == "" {
+		return fmt.Errorf("zipcode is required")
+	}
+	if aggregateMetric(r.MetricName) == "" {
+		return fmt.Errorf("metric %q has no hourly averages to compare against", r.MetricName)
+	}
+	if r.Deviations <= 0 {
+		return fmt.Errorf("deviations must be positive, got %g", r.Deviations)
+	}
+	if r.BaselineDays < 0 {
+		return fmt.Errorf("baseline days must not be negative, got %d", r.BaselineDays)
+	}
+	if r.MinSamples != 0 && r.MinSamples < 2 {
+		return fmt.Errorf("a baseline needs at least two samples, got %d", r.MinSamples)
+	}
+	if r.DurationMinutes < 0 {
+		return fmt.Errorf("duration must not be negative, got %d minutes", r.DurationMinutes)
+	}
+	return validateSeverity(r.Severity)
+}
+
+// aggregateMetric returns the AggregateMetrics name of a raw metric, e.g.
+// temp for temperature, or "" if it isn't aggregated
+func aggregateMetric(metric string) string {
+	for _, m := range AggregateMetrics {
+		if m.Column == metric {
+			return m.Name
+		}
+	}
+	return ""
+}
+
+// GetActiveAnomalyRules retrieves all active anomaly rules for a zipcode
+func (db *DB) GetActiveAnomalyRules(ctx context.Context, zipcode string) ([]*AnomalyRule, error) {
+	query := `
+		SELECT id, zipcode, metric_name, deviations, baseline_days, min_samples, duration_minutes,
+		       severity, is_active, created_at, updated_at
+		FROM alarm_anomaly_rules
+		WHERE zipcode = $1 AND is_active = true
+		ORDER BY metric_name
+	`
+
+	rows, err := db.QueryContext(ctx, query, zipcode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*AnomalyRule
+	for rows.Next() {
+		r := &AnomalyRule{}
+		if err := rows.Scan(&r.ID, &r.Zipcode, &r.MetricName, &r.Deviations, &r.BaselineDays, &r.MinSamples,
+			&r.DurationMinutes, &r.Severity, &r.IsActive, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// CreateAnomalyRule validates and inserts an anomaly rule, setting its ID
+// and timestamps. A location has at most one rule per metric; zero
+// BaselineDays and MinSamples default to 30 days and 7 samples.
+func (db *DB) CreateAnomalyRule(ctx context.Context, r *AnomalyRule) error {
+	if err := ValidateAnomalyRule(r); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO alarm_anomaly_rules (zipcode, metric_name, deviations, baseline_days, min_samples,
+		                                 duration_minutes, severity, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at
+	`
+	r.BaselineDays = cmp.Or(r.BaselineDays, 30)
+	r.MinSamples = cmp.Or(r.MinSamples, 7)
+	r.Severity = cmp.Or(r.Severity, SeverityWarning)
+	return db.QueryRowContext(ctx, query,
+		r.Zipcode, r.MetricName, r.Deviations, r.BaselineDays, r.MinSamples,
+		r.DurationMinutes, r.Severity, r.IsActive,
+	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+}
+
+// DeleteAnomalyRule deletes an anomaly rule. Its logged alarms are kept.
+func (db *DB) DeleteAnomalyRule(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM alarm_anomaly_rules WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAnomalyRuleNotFound
+	}
+	return nil
+}
+
+// GetHourlyBaseline returns the distribution of a zipcode's hourly
+// averages of metric at hour's hour of the day, over the days before it.
+// Hours without an average are left out; with fewer than two, StdDev is
+// zero.
+func (db *DB) GetHourlyBaseline(ctx context.Context, zipcode, metric string, hour time.Time, days int) (*Baseline, error) {
+	name := aggregateMetric(metric)
+	if name == "" {
+		return nil, fmt.Errorf("metric %q has no hourly averages", metric)
+	}
+	hour = hour.Truncate(time.Hour)
+	query := `
+		SELECT hour_timestamp, avg_` + name + `
+		FROM hourly_metrics
+		WHERE zipcode = $1 AND hour_timestamp >= $2 AND hour_timestamp < $3 AND avg_` + name + ` IS NOT NULL
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, zipcode, hour.AddDate(0, 0, -days), hour)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []float64
+	for rows.Next() {
+		var at time.Time
+		var value float64
+		if err := rows.Scan(&at, &value); err != nil {
+			return nil, err
+		}
+		// Only the same hour of each day, in UTC like the aggregates
+		if hour.Sub(at)%(24*time.Hour) == 0 {
+			values = append(values, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	b := &Baseline{Samples: len(values)}
+	if len(values) == 0 {
+		return b, nil
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	b.Mean = sum / float64(len(values))
+	if len(values) > 1 {
+		var squares float64
+		for _, v := range values {
+			squares += (v - b.Mean) * (v - b.Mean)
+		}
+		b.StdDev = math.Sqrt(squares / float64(len(values)-1))
+	}
+	return b, nil
+}
@@ -1,50 +1,147 @@
 package database
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/lib/pq"
+	"github.com/smukkama/weather-server/pkg/config"
 )
 
-// DB wraps the database connection
+// Database drivers Connect can use
+const (
+	DriverPQ     = "postgres"
+	DriverPgx    = "pgx"
+	DriverSQLite = "sqlite"
+)
+
+// DB wraps the database connection to the primary, and any read replicas
 type DB struct {
 	*sql.DB
+	driver   dialect
+	replicas *replicaSet
+	metrics  *queryMetrics
+	health   *health
+}
+
+// reader is where read-only queries go: a healthy replica, or the primary
+// if there is none
+func (db *DB) reader() querier {
+	if r := db.replicas.reader(); r != nil {
+		return instrumented{r, db.metrics}
+	}
+	return db
+}
+
+// Close closes the replica pools and then the primary's
+func (db *DB) Close() error {
+	db.health.close()
+	db.replicas.close()
+	return db.DB.Close()
 }
 
-// Connect establishes a connection to the database
-func Connect(connectionString string) (*DB, error) {
-	db, err := sql.Open("postgres", connectionString)
+// Tx is a transaction offering the writes the DB writer makes, so a batch
+// of readings and its consumer offsets commit together
+type Tx struct {
+	*sql.Tx
+	driver  dialect
+	metrics *queryMetrics
+}
+
+// querier is what the shared write helpers need; *sql.DB and *sql.Tx both
+// provide it
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// BeginWrite starts a transaction
+func (db *DB) BeginWrite(ctx context.Context) (*Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx, db.driver, db.metrics}, nil
+}
+
+// Connect establishes a connection to the database with cfg's driver and
+// pool settings. With pgx each connection caches its prepared statements.
+// Read queries go to cfg's replicas while they are healthy. The primary's
+// health is checked in the background; see CheckHealth.
+func Connect(cfg config.DatabaseConfig) (*DB, error) {
+	driverName, dsn := cfg.Driver, cfg.ConnectionString()
+	switch cfg.Driver {
+	case DriverPQ, DriverPgx:
+	case DriverSQLite:
+		if len(cfg.Replicas) > 0 {
+			return nil, fmt.Errorf("read replicas need Postgres")
+		}
+		driverName, dsn = sqliteDriverName, sqliteDSN(cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q (want %s, %s or %s)", cfg.Driver, DriverPQ, DriverPgx, DriverSQLite)
+	}
+
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	// Set connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	var replicas *replicaSet
+	if len(cfg.Replicas) > 0 {
+		if replicas, err = openReplicas(cfg); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	var checks *health
+	if cfg.HealthCheckInterval > 0 {
+		checks = startHealthChecks(db, cfg.MaxIdleConns, cfg.HealthCheckInterval, cfg.HealthMaxBackoff)
+	}
 
-	return &DB{db}, nil
+	return &DB{
+		DB:       db,
+		driver:   dialect(cfg.Driver),
+		replicas: replicas,
+		metrics:  newQueryMetrics(cfg.SlowQueryThreshold),
+		health:   checks,
+	}, nil
 }
 
 // RunMigrations executes all SQL migration files in order
-func (db *DB) RunMigrations(migrationsDir string) error {
+func (db *DB) RunMigrations(ctx context.Context, migrationsDir string) error {
 	// Create migrations tracking table if it doesn't exist
-	if err := db.createMigrationsTable(); err != nil {
+	if err := db.createMigrationsTable(ctx); err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
 	}
 
 	// Get list of already executed migrations
-	executedMigrations, err := db.getExecutedMigrations()
+	executedMigrations, err := db.getExecutedMigrations(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get executed migrations: %w", err)
 	}
@@ -82,12 +179,12 @@ func (db *DB) RunMigrations(migrationsDir string) error {
 		}
 
 		// Execute migration
-		if _, err := db.Exec(string(content)); err != nil {
+		if _, err := db.ExecContext(ctx, string(content)); err != nil {
 			return fmt.Errorf("failed to execute migration %s: %w", filename, err)
 		}
 
 		// Record migration as executed
-		if err := db.recordMigration(filename); err != nil {
+		if err := db.recordMigration(ctx, filename); err != nil {
 			return fmt.Errorf("failed to record migration %s: %w", filename, err)
 		}
 
@@ -103,7 +200,7 @@ func (db *DB) RunMigrations(migrationsDir string) error {
 }
 
 // createMigrationsTable creates the schema_migrations table for tracking
-func (db *DB) createMigrationsTable() error {
+func (db *DB) createMigrationsTable(ctx context.Context) error {
 	query := `
 		CREATE TABLE IF NOT EXISTS schema_migrations (
 			id SERIAL PRIMARY KEY,
@@ -111,14 +208,14 @@ func (db *DB) createMigrationsTable() error {
 			executed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
 	`
-	_, err := db.Exec(query)
+	_, err := db.ExecContext(ctx, query)
 	return err
 }
 
 // getExecutedMigrations returns a map of already executed migrations
-func (db *DB) getExecutedMigrations() (map[string]bool, error) {
+func (db *DB) getExecutedMigrations(ctx context.Context) (map[string]bool, error) {
 	query := "SELECT filename FROM schema_migrations"
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -137,14 +234,14 @@ func (db *DB) getExecutedMigrations() (map[string]bool, error) {
 }
 
 // recordMigration records a migration as executed
-func (db *DB) recordMigration(filename string) error {
+func (db *DB) recordMigration(ctx context.Context, filename string) error {
 	query := "INSERT INTO schema_migrations (filename) VALUES ($1)"
-	_, err := db.Exec(query, filename)
+	_, err := db.ExecContext(ctx, query, filename)
 	return err
 }
 
-// UpsertLocation inserts or updates a location
-func (db *DB) UpsertLocation(loc *Location) error {
+// upsertLocation inserts or updates a location
+func upsertLocation(ctx context.Context, db querier, loc *Location) error {
 	query := `
 		INSERT INTO locations (zipcode, city_name, lat, lon)
 		VALUES ($1, $2, $3, $4)
@@ -154,22 +251,34 @@ func (db *DB) UpsertLocation(loc *Location) error {
 		    lon = EXCLUDED.lon,
 		    updated_at = CURRENT_TIMESTAMP
 	`
-	_, err := db.Exec(query, loc.Zipcode, loc.CityName, loc.Lat, loc.Lon)
+	_, err := db.ExecContext(ctx, query, loc.Zipcode, loc.CityName, loc.Lat, loc.Lon)
 	return err
 }
 
-// GetLocation retrieves a location by zipcode
-func (db *DB) GetLocation(zipcode string) (*Location, error) {
+// UpsertLocation calls upsertLocation on the connection pool
+func (db *DB) UpsertLocation(ctx context.Context, loc *Location) error {
+	return upsertLocation(ctx, db, loc)
+}
+
+// UpsertLocation calls upsertLocation in the transaction
+func (tx *Tx) UpsertLocation(ctx context.Context, loc *Location) error {
+	return upsertLocation(ctx, tx, loc)
+}
+
+// getLocation retrieves a location by zipcode
+func getLocation(ctx context.Context, db querier, zipcode string) (*Location, error) {
 	query := `
-		SELECT zipcode, city_name, lat, lon, created_at, updated_at
+		SELECT zipcode, city_name, county, state, lat, lon, created_at, updated_at
 		FROM locations
 		WHERE zipcode = $1
 	`
 
 	var loc Location
-	err := db.QueryRow(query, zipcode).Scan(
+	err := db.QueryRowContext(ctx, query, zipcode).Scan(
 		&loc.Zipcode,
 		&loc.CityName,
+		&loc.County,
+		&loc.State,
 		&loc.Lat,
 		&loc.Lon,
 		&loc.CreatedAt,
@@ -186,18 +295,170 @@ func (db *DB) GetLocation(zipcode string) (*Location, error) {
 	return &loc, nil
 }
 
-// InsertRawMetric inserts a raw weather metric
-func (db *DB) InsertRawMetric(metric *RawMetric) error {
+// GetLocation calls getLocation on the connection pool
+func (db *DB) GetLocation(ctx context.Context, zipcode string) (*Location, error) {
+	return getLocation(ctx, db, zipcode)
+}
+
+// GetLocation calls getLocation in the transaction
+func (tx *Tx) GetLocation(ctx context.Context, zipcode string) (*Location, error) {
+	return getLocation(ctx, tx, zipcode)
+}
+
+// upsertStation inserts or updates a station's metadata. Station
+// coordinates also fill in the location's coordinates if it has none.
+func upsertStation(ctx context.Context, db querier, station *Station) error {
 	query := `
-		INSERT INTO raw_metrics (
-			zipcode, timestamp, temperature, humidity, precipitation,
-			wind_speed, wind_direction, pollution_index, pollen_index, received_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		RETURNING id
+		INSERT INTO stations (zipcode, station_id, lat, lon, elevation_m, model, firmware_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (zipcode, station_id) DO UPDATE
+		SET lat = EXCLUDED.lat,
+		    lon = EXCLUDED.lon,
+		    elevation_m = EXCLUDED.elevation_m,
+		    model = EXCLUDED.model,
+		    firmware_version = EXCLUDED.firmware_version,
+		    updated_at = CURRENT_TIMESTAMP
 	`
+	_, err := db.ExecContext(ctx, query, station.Zipcode, station.StationID, station.Lat, station.Lon,
+		station.Elevation, station.Model, station.FirmwareVersion)
+	if err != nil {
+		return err
+	}
 
-	return db.QueryRow(
-		query,
+	if station.Lat == nil || station.Lon == nil {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE locations
+		SET lat = $2, lon = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE zipcode = $1 AND lat IS NULL AND lon IS NULL
+	`, station.Zipcode, station.Lat, station.Lon)
+	return err
+}
+
+// UpsertStation calls upsertStation on the connection pool
+func (db *DB) UpsertStation(ctx context.Context, station *Station) error {
+	return upsertStation(ctx, db, station)
+}
+
+// UpsertStation calls upsertStation in the transaction
+func (tx *Tx) UpsertStation(ctx context.Context, station *Station) error {
+	return upsertStation(ctx, tx, station)
+}
+
+// GetStations retrieves the stations of a zipcode, or every station if
+// zipcode is empty
+func (db *DB) GetStations(ctx context.Context, zipcode string) ([]*Station, error) {
+	query := `
+		SELECT zipcode, station_id, lat, lon, elevation_m, model, firmware_version,
+		       owner_name, owner_contact, created_at, updated_at
+		FROM stations
+		WHERE $1 = '' OR zipcode = $1
+		ORDER BY zipcode, station_id
+	`
+
+	rows, err := db.QueryContext(ctx, query, zipcode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stations []*Station
+	for rows.Next() {
+		var s Station
+		if err := rows.Scan(
+			&s.Zipcode,
+			&s.StationID,
+			&s.Lat,
+			&s.Lon,
+			&s.Elevation,
+			&s.Model,
+			&s.FirmwareVersion,
+			&s.OwnerName,
+			&s.OwnerContact,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		stations = append(stations, &s)
+	}
+	return stations, rows.Err()
+}
+
+// ErrStationNotFound is returned when updating a station that doesn't
+// exist
+var ErrStationNotFound = errors.New("station not found")
+
+// SetStationOwner records who runs a station and how to reach them. Nil
+// clears a field.
+func (db *DB) SetStationOwner(ctx context.Context, zipcode, stationID string, name, contact *string) error {
+	query := `
+		UPDATE stations
+		SET owner_name = $3, owner_contact = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE zipcode = $1 AND station_id = $2
+	`
+
+	result, err := db.ExecContext(ctx, query, zipcode, stationID, name, contact)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrStationNotFound
+	}
+	return nil
+}
+
+// rawMetricColumns are the raw_metrics columns written on insert, in the
+// order rawMetricArgs returns their values
+const rawMetricColumns = `
+			zipcode, timestamp, temperature, humidity, precipitation,
+			wind_speed, wind_direction, pollution_index, pollen_index, received_at,
+			quality_flags, heat_index, wind_chill, dew_point,
+			pressure, visibility, uv_index, snow_depth, station_id`
+
+const rawMetricColumnCount = 19
+
+// rawMetricUpsert replaces a stored reading with a repeat of it, so a
+// redelivered message or retried upload isn't stored twice
+const rawMetricUpsert = `
+		ON CONFLICT (zipcode, station_id, timestamp) DO UPDATE
+		SET temperature = EXCLUDED.temperature,
+		    humidity = EXCLUDED.humidity,
+		    precipitation = EXCLUDED.precipitation,
+		    wind_speed = EXCLUDED.wind_speed,
+		    wind_direction = EXCLUDED.wind_direction,
+		    pollution_index = EXCLUDED.pollution_index,
+		    pollen_index = EXCLUDED.pollen_index,
+		    received_at = EXCLUDED.received_at,
+		    quality_flags = EXCLUDED.quality_flags,
+		    heat_index = EXCLUDED.heat_index,
+		    wind_chill = EXCLUDED.wind_chill,
+		    dew_point = EXCLUDED.dew_point,
+		    pressure = EXCLUDED.pressure,
+		    visibility = EXCLUDED.visibility,
+		    uv_index = EXCLUDED.uv_index,
+		    snow_depth = EXCLUDED.snow_depth`
+
+// rawMetricBatchRows caps the rows per INSERT statement, keeping it well
+// under Postgres' 65535 bind parameters
+const rawMetricBatchRows = 1000
+
+// rawMetricArgs returns the values of rawMetricColumns for a metric
+func rawMetricArgs(metric *RawMetric) ([]any, error) {
+	flags := []byte("{}")
+	if len(metric.QualityFlags) > 0 {
+		var err error
+		if flags, err = json.Marshal(metric.QualityFlags); err != nil {
+			return nil, fmt.Errorf("failed to encode quality flags: %w", err)
+		}
+	}
+
+	return []any{
 		metric.Zipcode,
 		metric.Timestamp,
 		metric.Temperature,
@@ -208,58 +469,469 @@ func (db *DB) InsertRawMetric(metric *RawMetric) error {
 		metric.PollutionIndex,
 		metric.PollenIndex,
 		metric.ReceivedAt,
-	).Scan(&metric.ID)
+		string(flags),
+		metric.HeatIndex,
+		metric.WindChill,
+		metric.DewPoint,
+		metric.Pressure,
+		metric.Visibility,
+		metric.UVIndex,
+		metric.SnowDepth,
+		metric.StationID,
+	}, nil
+}
+
+// insertRawMetric inserts a raw weather metric, replacing a stored copy of
+// the same reading
+func insertRawMetric(ctx context.Context, db querier, metric *RawMetric) error {
+	return insertRawMetrics(ctx, db, []*RawMetric{metric})
+}
+
+// insertRawMetrics upserts raw weather metrics with one multi-row INSERT
+// per rawMetricBatchRows metrics, setting each metric's ID. Either all rows
+// of a statement are written or none are.
+func insertRawMetrics(ctx context.Context, db querier, metrics []*RawMetric) error {
+	for start := 0; start < len(metrics); start += rawMetricBatchRows {
+		end := start + rawMetricBatchRows
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		if err := insertRawMetricRows(ctx, db, metrics[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertRawMetricRows(ctx context.Context, db querier, metrics []*RawMetric) error {
+	stmt, err := newRawMetricInsert(metrics)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.QueryContext(ctx, stmt.query, stmt.args...)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	if err := stmt.scanIDs(result); err != nil {
+		return err
+	}
+	return result.Err()
+}
+
+// rawMetricInsert is one multi-row upsert of raw metrics
+type rawMetricInsert struct {
+	query   string
+	args    []any
+	metrics []*RawMetric
+	rows    []*RawMetric // metrics without repeats, in VALUES order
+	rowOf   map[rawMetricKey]int
+}
+
+// newRawMetricInsert builds the upsert of metrics
+func newRawMetricInsert(metrics []*RawMetric) (*rawMetricInsert, error) {
+	// An upsert can't touch the same row twice in one statement, so repeats
+	// within the batch collapse into their last copy
+	rows := make([]*RawMetric, 0, len(metrics))
+	rowOf := make(map[rawMetricKey]int, len(metrics))
+	for _, metric := range metrics {
+		key := rawMetricKey{metric.Zipcode, metric.StationID, metric.Timestamp.UnixNano()}
+		if i, ok := rowOf[key]; ok {
+			rows[i] = metric
+			continue
+		}
+		rowOf[key] = len(rows)
+		rows = append(rows, metric)
+	}
+
+	var query strings.Builder
+	query.WriteString("INSERT INTO raw_metrics (" + rawMetricColumns + "\n\t\t) VALUES ")
+
+	args := make([]any, 0, len(rows)*rawMetricColumnCount)
+	for i, metric := range rows {
+		values, err := rawMetricArgs(metric)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range values {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", len(args)+j+1)
+		}
+		query.WriteString(")")
+		args = append(args, values...)
+	}
+	query.WriteString(rawMetricUpsert + "\n\t\tRETURNING id")
+
+	return &rawMetricInsert{query: query.String(), args: args, metrics: metrics, rows: rows, rowOf: rowOf}, nil
+}
+
+// scanIDs sets each metric's ID from the statement's RETURNING rows
+func (stmt *rawMetricInsert) scanIDs(result rowIterator) error {
+	// Postgres returns the rows of an INSERT ... VALUES in VALUES order
+	i := 0
+	for result.Next() {
+		if i >= len(stmt.rows) {
+			return fmt.Errorf("insert returned more ids than rows")
+		}
+		if err := result.Scan(&stmt.rows[i].ID); err != nil {
+			return err
+		}
+		i++
+	}
+
+	for _, metric := range stmt.metrics {
+		key := rawMetricKey{metric.Zipcode, metric.StationID, metric.Timestamp.UnixNano()}
+		metric.ID = stmt.rows[stmt.rowOf[key]].ID
+	}
+	return nil
+}
+
+// insertRawMetricsPgx upserts metrics like insertRawMetrics, but sends
+// every statement in one pgx batch: a single round trip, and an implicit
+// transaction so either all rows are written or none are
+func (db *DB) insertRawMetricsPgx(ctx context.Context, metrics []*RawMetric) error {
+	var stmts []*rawMetricInsert
+	batch := &pgx.Batch{}
+	for start := 0; start < len(metrics); start += rawMetricBatchRows {
+		end := min(start+rawMetricBatchRows, len(metrics))
+		stmt, err := newRawMetricInsert(metrics[start:end])
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, stmt)
+		batch.Queue(stmt.query, stmt.args...)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	err = conn.Raw(func(driverConn any) error {
+		results := driverConn.(*stdlib.Conn).Conn().SendBatch(ctx, batch)
+		for _, stmt := range stmts {
+			rows, err := results.Query()
+			if err != nil {
+				results.Close()
+				return err
+			}
+			err = stmt.scanIDs(rows)
+			rows.Close()
+			if err == nil {
+				err = rows.Err()
+			}
+			if err != nil {
+				results.Close()
+				return err
+			}
+		}
+		return results.Close()
+	})
+	db.metrics.observe(stmts[0].query, time.Since(start), err)
+	return err
+}
+
+// rawMetricKey identifies a reading, as raw_metrics' unique index does
+type rawMetricKey struct {
+	zipcode   string
+	stationID string
+	timestamp int64
+}
+
+// InsertRawMetric calls insertRawMetric on the connection pool
+func (db *DB) InsertRawMetric(ctx context.Context, metric *RawMetric) error {
+	return insertRawMetric(ctx, db, metric)
+}
+
+// InsertRawMetric calls insertRawMetric in the transaction
+func (tx *Tx) InsertRawMetric(ctx context.Context, metric *RawMetric) error {
+	return insertRawMetric(ctx, tx, metric)
+}
+
+// InsertRawMetricsBatch calls insertRawMetrics on the connection pool. A
+// statement's rows are written atomically, but with more than
+// rawMetricBatchRows metrics an earlier statement may have been written
+// when a later one fails; use a transaction if that matters. With pgx the
+// statements go in one batch and are written atomically together.
+func (db *DB) InsertRawMetricsBatch(ctx context.Context, metrics []*RawMetric) error {
+	if db.driver == DriverPgx && len(metrics) > rawMetricBatchRows {
+		return db.insertRawMetricsPgx(ctx, metrics)
+	}
+	return insertRawMetrics(ctx, db, metrics)
+}
+
+// InsertRawMetricsBatch calls insertRawMetrics in the transaction
+func (tx *Tx) InsertRawMetricsBatch(ctx context.Context, metrics []*RawMetric) error {
+	return insertRawMetrics(ctx, tx, metrics)
+}
+
+// alarmThresholdColumns are the alarm_thresholds columns
+// scanAlarmThreshold reads
+const alarmThresholdColumns = `id, zipcode, metric_name, operator, threshold_value,
+		       duration_minutes, clear_value, clear_duration_minutes, severity, is_active,
+		       created_at, updated_at`
+
+// scanAlarmThreshold reads a row of alarmThresholdColumns
+func scanAlarmThreshold(row rowScanner) (*AlarmThreshold, error) {
+	var t AlarmThreshold
+	if err := row.Scan(
+		&t.ID,
+		&t.Zipcode,
+		&t.MetricName,
+		&t.Operator,
+		&t.ThresholdValue,
+		&t.DurationMinutes,
+		&t.ClearValue,
+		&t.ClearDurationMinutes,
+		&t.Severity,
+		&t.IsActive,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// queryAlarmThresholds runs a query selecting alarmThresholdColumns
+func (db *DB) queryAlarmThresholds(ctx context.Context, query string, args ...any) ([]*AlarmThreshold, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var thresholds []*AlarmThreshold
+	for rows.Next() {
+		t, err := scanAlarmThreshold(rows)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, t)
+	}
+
+	return thresholds, rows.Err()
 }
 
 // GetActiveAlarmThresholds retrieves all active alarm thresholds for a zipcode
-func (db *DB) GetActiveAlarmThresholds(zipcode string) ([]*AlarmThreshold, error) {
+func (db *DB) GetActiveAlarmThresholds(ctx context.Context, zipcode string) ([]*AlarmThreshold, error) {
 	query := `
-		SELECT id, zipcode, metric_name, operator, threshold_value,
-		       duration_minutes, is_active, created_at, updated_at
+		SELECT ` + alarmThresholdColumns + `
 		FROM alarm_thresholds
 		WHERE zipcode = $1 AND is_active = true
 		ORDER BY metric_name
 	`
 
-	rows, err := db.Query(query, zipcode)
+	return db.queryAlarmThresholds(ctx, query, zipcode)
+}
+
+// ListAlarmThresholds retrieves the alarm thresholds for a zipcode, active
+// or not, or every location's if zipcode is empty
+func (db *DB) ListAlarmThresholds(ctx context.Context, zipcode string) ([]*AlarmThreshold, error) {
+	query := `
+		SELECT ` + alarmThresholdColumns + `
+		FROM alarm_thresholds
+		WHERE $1 = '' OR zipcode = $1
+		ORDER BY zipcode, metric_name
+	`
+
+	return db.queryAlarmThresholds(ctx, query, zipcode)
+}
+
+// GetAlarmThreshold retrieves an alarm threshold by ID, or nil if there is
+// none
+func (db *DB) GetAlarmThreshold(ctx context.Context, id int) (*AlarmThreshold, error) {
+	query := `
+		SELECT ` + alarmThresholdColumns + `
+		FROM alarm_thresholds
+		WHERE id = $1
+	`
+
+	t, err := scanAlarmThreshold(db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return t, nil
+}
 
-	var thresholds []*AlarmThreshold
-	for rows.Next() {
-		var t AlarmThreshold
-		if err := rows.Scan(
-			&t.ID,
-			&t.Zipcode,
-			&t.MetricName,
-			&t.Operator,
-			&t.ThresholdValue,
-			&t.DurationMinutes,
-			&t.IsActive,
-			&t.CreatedAt,
-			&t.UpdatedAt,
-		); err != nil {
-			return nil, err
+// ErrAlarmThresholdNotFound is returned when updating or deleting an alarm
+// threshold that doesn't exist
+var ErrAlarmThresholdNotFound = errors.New("alarm threshold not found")
+
+// AlarmOperators are the comparisons an alarm threshold can use
+var AlarmOperators = []string{">", "<", ">=", "<="}
+
+// ValidateAlarmThreshold checks a threshold before it is stored
+func ValidateAlarmThreshold(t *AlarmThreshold) error {
+	if t.Zipcode == "" {
+		return fmt.Errorf("zipcode is required")
+	}
+	if t.MetricName == "" {
+		return fmt.Errorf("metric name is required")
+	}
+	if !slices.Contains(AlarmOperators, t.Operator) {
+		return fmt.Errorf("invalid operator %q (want one of %s)", t.Operator, strings.Join(AlarmOperators, ", "))
+	}
+	if t.DurationMinutes < 0 {
+		return fmt.Errorf("duration must not be negative, got %d minutes", t.DurationMinutes)
+	}
+	if t.ClearDurationMinutes < 0 {
+		return fmt.Errorf("clear duration must not be negative, got %d minutes", t.ClearDurationMinutes)
+	}
+	if err := validateSeverity(t.Severity); err != nil {
+		return err
+	}
+	if t.ClearValue != nil {
+		above := t.Operator == ">" || t.Operator == ">="
+		if above && *t.ClearValue > t.ThresholdValue || !above && *t.ClearValue < t.ThresholdValue {
+			return fmt.Errorf("clear value %v is on the alarming side of %s %v", *t.ClearValue, t.Operator, t.ThresholdValue)
 		}
-		thresholds = append(thresholds, &t)
 	}
+	return nil
+}
+
+// validateSeverity checks an alarm severity, where empty means warning
+func validateSeverity(severity string) error {
+	if severity != "" && !slices.Contains(AlarmSeverities, severity) {
+		return fmt.Errorf("invalid severity %q (want one of %s)", severity, strings.Join(AlarmSeverities, ", "))
+	}
+	return nil
+}
 
-	return thresholds, rows.Err()
+// CreateAlarmThreshold validates and inserts an alarm threshold, setting
+// its ID and timestamps. A location has at most one threshold per metric.
+func (db *DB) CreateAlarmThreshold(ctx context.Context, t *AlarmThreshold) error {
+	if err := ValidateAlarmThreshold(t); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, duration_minutes,
+		                              clear_value, clear_duration_minutes, severity, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, updated_at
+	`
+
+	t.Severity = cmp.Or(t.Severity, SeverityWarning)
+	return db.QueryRowContext(ctx, query,
+		t.Zipcode, t.MetricName, t.Operator, t.ThresholdValue, t.DurationMinutes,
+		t.ClearValue, t.ClearDurationMinutes, t.Severity, t.IsActive,
+	).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt)
+}
+
+// UpdateAlarmThreshold validates and stores every field of an existing
+// alarm threshold, setting its updated timestamp
+func (db *DB) UpdateAlarmThreshold(ctx context.Context, t *AlarmThreshold) error {
+	if err := ValidateAlarmThreshold(t); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE alarm_thresholds
+		SET zipcode = $2, metric_name = $3, operator = $4, threshold_value = $5,
+		    duration_minutes = $6, clear_value = $7, clear_duration_minutes = $8,
+		    severity = $9, is_active = $10, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING created_at, updated_at
+	`
+
+	t.Severity = cmp.Or(t.Severity, SeverityWarning)
+	err := db.QueryRowContext(ctx, query,
+		t.ID, t.Zipcode, t.MetricName, t.Operator, t.ThresholdValue, t.DurationMinutes,
+		t.ClearValue, t.ClearDurationMinutes, t.Severity, t.IsActive,
+	).Scan(&t.CreatedAt, &t.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrAlarmThresholdNotFound
+	}
+	return err
+}
+
+// DeleteAlarmThreshold deletes an alarm threshold. Its logged alarms are
+// kept.
+func (db *DB) DeleteAlarmThreshold(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM alarm_thresholds WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlarmThresholdNotFound
+	}
+	return nil
+}
+
+// StartConnectionSession records a station connecting. Replayed events are
+// ignored.
+func (db *DB) StartConnectionSession(ctx context.Context, session *ConnectionSession) error {
+	query := `
+		INSERT INTO connection_sessions (connection_id, zipcode, city, station_id, remote_addr, connected_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (connection_id) DO NOTHING
+	`
+	_, err := db.ExecContext(ctx, query, session.ConnectionID, session.Zipcode, session.City,
+		session.StationID, session.RemoteAddr, session.ConnectedAt)
+	return err
+}
+
+// MarkConnectionSessionIdle records when a station was last found idle
+func (db *DB) MarkConnectionSessionIdle(ctx context.Context, connectionID string, at time.Time) error {
+	query := `
+		UPDATE connection_sessions
+		SET last_idle_at = $2
+		WHERE connection_id = $1
+	`
+	_, err := db.ExecContext(ctx, query, connectionID, at)
+	return err
+}
+
+// EndConnectionSession records a station disconnecting. The session is
+// created if its start was never recorded.
+func (db *DB) EndConnectionSession(ctx context.Context, session *ConnectionSession) error {
+	query := `
+		INSERT INTO connection_sessions (
+			connection_id, zipcode, city, station_id, remote_addr, connected_at,
+			disconnected_at, duration_seconds, disconnect_reason
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (connection_id) DO UPDATE
+		SET remote_addr = EXCLUDED.remote_addr,
+		    disconnected_at = EXCLUDED.disconnected_at,
+		    duration_seconds = EXCLUDED.duration_seconds,
+		    disconnect_reason = EXCLUDED.disconnect_reason
+	`
+	_, err := db.ExecContext(ctx, query, session.ConnectionID, session.Zipcode, session.City,
+		session.StationID, session.RemoteAddr, session.ConnectedAt,
+		session.DisconnectedAt, session.DurationSeconds, session.DisconnectReason)
+	return err
 }
 
 // InsertAlarmLog inserts a new alarm log entry
-func (db *DB) InsertAlarmLog(alarm *AlarmLog) error {
+func (db *DB) InsertAlarmLog(ctx context.Context, alarm *AlarmLog) error {
 	query := `
 		INSERT INTO alarms_log (
 			zipcode, metric_name, breach_value, threshold_config,
-			start_time, status
-		) VALUES ($1, $2, $3, $4, $5, $6)
+			start_time, status, severity
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING alarm_id
 	`
 
-	return db.QueryRow(
+	alarm.Severity = cmp.Or(alarm.Severity, SeverityWarning)
+	return db.QueryRowContext(ctx,
 		query,
 		alarm.Zipcode,
 		alarm.MetricName,
@@ -267,17 +939,595 @@ func (db *DB) InsertAlarmLog(alarm *AlarmLog) error {
 		alarm.ThresholdConfig,
 		alarm.StartTime,
 		alarm.Status,
+		alarm.Severity,
 	).Scan(&alarm.AlarmID)
 }
 
-// UpdateAlarmLogCleared updates an alarm log to cleared status
-func (db *DB) UpdateAlarmLogCleared(alarmID int64, endTime time.Time) error {
+// UpdateAlarmLogCleared updates an alarm log to cleared status, or only
+// records its end if it was flapping
+func (db *DB) UpdateAlarmLogCleared(ctx context.Context, alarmID int64, endTime time.Time) error {
 	query := `
 		UPDATE alarms_log
-		SET status = $1, end_time = $2, updated_at = CURRENT_TIMESTAMP
+		SET status = CASE WHEN status = '` + AlarmStatusFlapping + `' THEN status ELSE $1 END,
+		    end_time = $2, updated_at = CURRENT_TIMESTAMP
 		WHERE alarm_id = $3
 	`
 
-	_, err := db.Exec(query, AlarmStatusCleared, endTime, alarmID)
+	_, err := db.ExecContext(ctx, query, AlarmStatusCleared, endTime, alarmID)
 	return err
 }
+
+// alarmLogColumns are the alarms_log columns scanAlarmLog reads
+const alarmLogColumns = `alarm_id, zipcode, metric_name, breach_value, threshold_config,
+		       start_time, end_time, status, severity, acknowledged_at, acknowledged_by,
+		       snoozed_until, escalation_level, escalated_at, created_at, updated_at`
+
+// scanAlarmLog reads a row of alarmLogColumns
+func scanAlarmLog(row rowScanner) (*AlarmLog, error) {
+	var a AlarmLog
+	if err := row.Scan(
+		&a.AlarmID,
+		&a.Zipcode,
+		&a.MetricName,
+		&a.BreachValue,
+		&a.ThresholdConfig,
+		&a.StartTime,
+		&a.EndTime,
+		&a.Status,
+		&a.Severity,
+		&a.AcknowledgedAt,
+		&a.AcknowledgedBy,
+		&a.SnoozedUntil,
+		&a.EscalationLevel,
+		&a.EscalatedAt,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// nullTime is a query argument for an optional time, NULL when unset
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// alarmFilterWhere matches the alarms selected by an AlarmFilter given as
+// $1 to $5 by alarmFilterArgs
+const alarmFilterWhere = `
+		WHERE ($1 = '' OR zipcode = $1)
+		  AND ($2 = '' OR metric_name = $2)
+		  AND ($3 = '' OR status = $3)
+		  AND (start_time >= $4 OR $4 IS NULL)
+		  AND (start_time < $5 OR $5 IS NULL)
+`
+
+// alarmFilterArgs are the arguments of alarmFilterWhere
+func alarmFilterArgs(filter AlarmFilter) []any {
+	return []any{filter.Zipcode, filter.MetricName, filter.Status, nullTime(filter.From), nullTime(filter.To)}
+}
+
+// GetActiveAlarms retrieves the alarms still ACTIVE for a zipcode, or for
+// every location if zipcode is empty, oldest first
+func (db *DB) GetActiveAlarms(ctx context.Context, zipcode string) ([]*AlarmLog, error) {
+	return db.GetAlarmHistory(ctx, AlarmFilter{Zipcode: zipcode, Status: AlarmStatusActive})
+}
+
+// GetAlarmHistory retrieves a page of the logged alarms matching filter,
+// oldest first
+func (db *DB) GetAlarmHistory(ctx context.Context, filter AlarmFilter) ([]*AlarmLog, error) {
+	query := `
+		SELECT ` + alarmLogColumns + `
+		FROM alarms_log` + alarmFilterWhere + `
+		ORDER BY start_time, alarm_id
+		LIMIT $6 OFFSET $7
+	`
+
+	args := append(alarmFilterArgs(filter), db.driver.pageLimit(filter.Limit), filter.Offset)
+	rows, err := db.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alarms []*AlarmLog
+	for rows.Next() {
+		a, err := scanAlarmLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, a)
+	}
+	return alarms, rows.Err()
+}
+
+// GetAlarmStats summarizes the logged alarms matching filter, ignoring its
+// limit and offset. Durations are over cleared alarms, since active ones
+// haven't ended.
+func (db *DB) GetAlarmStats(ctx context.Context, filter AlarmFilter) (*AlarmStats, error) {
+	duration := db.driver.secondsBetween("start_time", "end_time")
+	query := `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE status = '` + AlarmStatusActive + `'),
+		       COUNT(*) FILTER (WHERE status = '` + AlarmStatusCleared + `'),
+		       COUNT(*) FILTER (WHERE status = '` + AlarmStatusFlapping + `'),
+		       COALESCE(AVG(` + duration + `) FILTER (WHERE status = '` + AlarmStatusCleared + `'), 0),
+		       COALESCE(MAX(` + duration + `) FILTER (WHERE status = '` + AlarmStatusCleared + `'), 0)
+		FROM alarms_log` + alarmFilterWhere
+
+	var stats AlarmStats
+	var meanSeconds, maxSeconds float64
+	if err := db.reader().QueryRowContext(ctx, query, alarmFilterArgs(filter)...).Scan(
+		&stats.Count, &stats.Active, &stats.Cleared, &stats.Flapping, &meanSeconds, &maxSeconds,
+	); err != nil {
+		return nil, err
+	}
+	stats.MeanDuration = time.Duration(meanSeconds * float64(time.Second))
+	stats.MaxDuration = time.Duration(maxSeconds * float64(time.Second))
+	return &stats, nil
+}
+
+// ConsumerOffsets returns the last offset written per partition of topic
+// for a consumer group, locking the rows until the transaction ends
+func (tx *Tx) ConsumerOffsets(ctx context.Context, groupID, topic string) (map[int]int64, error) {
+	query := `
+		SELECT partition, committed_offset
+		FROM consumer_offsets
+		WHERE group_id = $1 AND topic = $2
+		` + tx.driver.forUpdate() + `
+	`
+
+	rows, err := tx.QueryContext(ctx, query, groupID, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offsets := make(map[int]int64)
+	for rows.Next() {
+		var partition int
+		var offset int64
+		if err := rows.Scan(&partition, &offset); err != nil {
+			return nil, err
+		}
+		offsets[partition] = offset
+	}
+	return offsets, rows.Err()
+}
+
+// SetConsumerOffset records the last offset written for a partition
+func (tx *Tx) SetConsumerOffset(ctx context.Context, groupID, topic string, partition int, offset int64) error {
+	query := `
+		INSERT INTO consumer_offsets (group_id, topic, partition, committed_offset)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (group_id, topic, partition) DO UPDATE
+		SET committed_offset = ` + tx.driver.greatest("consumer_offsets.committed_offset", "EXCLUDED.committed_offset") + `,
+		    updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := tx.ExecContext(ctx, query, groupID, topic, partition, offset)
+	return err
+}
+
+// Savepoint marks a point the transaction can roll back to, so one failed
+// statement doesn't abort the whole transaction
+func (tx *Tx) Savepoint(ctx context.Context, name string) error {
+	_, err := tx.ExecContext(ctx, "SAVEPOINT "+name)
+	return err
+}
+
+// RollbackTo undoes everything since the savepoint
+func (tx *Tx) RollbackTo(ctx context.Context, name string) error {
+	_, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+	return err
+}
+
+// ReleaseSavepoint keeps everything since the savepoint
+func (tx *Tx) ReleaseSavepoint(ctx context.Context, name string) error {
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// BeginSnapshot starts a read-write transaction that sees the database as
+// of its first query, so what it reads is exactly what a later delete in
+// it removes; rows written meanwhile by others are left alone
+func (db *DB) BeginSnapshot(ctx context.Context) (*Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx, db.driver, db.metrics}, nil
+}
+
+// OldestRawMetric returns the timestamp of the oldest reading before
+// before, or false if there is none
+func (db *DB) OldestRawMetric(ctx context.Context, before time.Time) (time.Time, bool, error) {
+	var oldest sql.NullTime
+	err := db.QueryRowContext(ctx, "SELECT MIN(timestamp) FROM raw_metrics WHERE timestamp < $1", before).Scan(&oldest)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return oldest.Time, oldest.Valid, nil
+}
+
+// ScanRawMetrics calls fn for each reading in [start, end), in timestamp
+// order, streaming them rather than loading them all, and returns how many
+// it read
+func (tx *Tx) ScanRawMetrics(ctx context.Context, start, end time.Time, fn func(*RawMetric) error) (int64, error) {
+	query := `
+		SELECT id,` + rawMetricColumns + `
+		FROM raw_metrics
+		WHERE timestamp >= $1 AND timestamp < $2
+		ORDER BY timestamp, zipcode, station_id
+	`
+
+	rows, err := tx.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int64
+	for rows.Next() {
+		metric, err := scanRawMetric(rows)
+		if err != nil {
+			return count, err
+		}
+		if err := fn(metric); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// DeleteRawMetrics deletes the readings in [start, end) and returns how
+// many it deleted
+func (tx *Tx) DeleteRawMetrics(ctx context.Context, start, end time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, "DELETE FROM raw_metrics WHERE timestamp >= $1 AND timestamp < $2", start, end)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// InsertColdStorageExport records an exported range in the manifest
+func (tx *Tx) InsertColdStorageExport(ctx context.Context, export *ColdStorageExport) error {
+	query := `
+		INSERT INTO cold_storage_exports (range_start, range_end, object_url, row_count, size_bytes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, exported_at
+	`
+
+	return tx.QueryRowContext(ctx, query,
+		export.RangeStart, export.RangeEnd, export.ObjectURL, export.RowCount, export.SizeBytes,
+	).Scan(&export.ID, &export.ExportedAt)
+}
+
+// rowScanner is a single row or the current one of many; *sql.Row and
+// *sql.Rows both provide it
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// rowIterator steps through result rows; *sql.Rows and pgx.Rows both
+// provide it
+type rowIterator interface {
+	rowScanner
+	Next() bool
+}
+
+// scanRawMetric reads a row of id and rawMetricColumns
+func scanRawMetric(row rowScanner) (*RawMetric, error) {
+	metric := &RawMetric{}
+	var flags []byte
+	if err := row.Scan(
+		&metric.ID,
+		&metric.Zipcode,
+		&metric.Timestamp,
+		&metric.Temperature,
+		&metric.Humidity,
+		&metric.Precipitation,
+		&metric.WindSpeed,
+		&metric.WindDirection,
+		&metric.PollutionIndex,
+		&metric.PollenIndex,
+		&metric.ReceivedAt,
+		&flags,
+		&metric.HeatIndex,
+		&metric.WindChill,
+		&metric.DewPoint,
+		&metric.Pressure,
+		&metric.Visibility,
+		&metric.UVIndex,
+		&metric.SnowDepth,
+		&metric.StationID,
+	); err != nil {
+		return nil, err
+	}
+	if len(flags) > 0 && string(flags) != "{}" {
+		if err := json.Unmarshal(flags, &metric.QualityFlags); err != nil {
+			return nil, fmt.Errorf("failed to decode quality flags: %w", err)
+		}
+	}
+	return metric, nil
+}
+
+// GetRawMetrics returns a page of a location's readings in [from, to), in
+// timestamp order, combining all its stations. A limit of zero or less
+// returns every reading after offset.
+func (db *DB) GetRawMetrics(ctx context.Context, zipcode string, from, to time.Time, limit, offset int) ([]*RawMetric, error) {
+	query := `
+		SELECT id,` + rawMetricColumns + `
+		FROM raw_metrics
+		WHERE zipcode = $1 AND timestamp >= $2 AND timestamp < $3
+		ORDER BY timestamp, station_id
+		LIMIT $4 OFFSET $5
+	`
+
+	return db.queryRawMetrics(ctx, query, zipcode, from, to, db.driver.pageLimit(limit), offset)
+}
+
+// GetStationMetrics returns a page of one station's readings in
+// [from, to), in timestamp order. A limit of zero or less returns every
+// reading after offset.
+func (db *DB) GetStationMetrics(ctx context.Context, zipcode, stationID string, from, to time.Time, limit, offset int) ([]*RawMetric, error) {
+	query := `
+		SELECT id,` + rawMetricColumns + `
+		FROM raw_metrics
+		WHERE zipcode = $1 AND station_id = $2 AND timestamp >= $3 AND timestamp < $4
+		ORDER BY timestamp
+		LIMIT $5 OFFSET $6
+	`
+
+	return db.queryRawMetrics(ctx, query, zipcode, stationID, from, to, db.driver.pageLimit(limit), offset)
+}
+
+// queryRawMetrics runs a read-only query selecting id and rawMetricColumns
+func (db *DB) queryRawMetrics(ctx context.Context, query string, args ...any) ([]*RawMetric, error) {
+	rows, err := db.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []*RawMetric
+	for rows.Next() {
+		metric, err := scanRawMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, rows.Err()
+}
+
+// GetLatestMetric returns a location's most recent reading, or nil if it
+// has none
+func (db *DB) GetLatestMetric(ctx context.Context, zipcode string) (*RawMetric, error) {
+	query := `
+		SELECT id,` + rawMetricColumns + `
+		FROM raw_metrics
+		WHERE zipcode = $1
+		ORDER BY timestamp DESC, station_id
+		LIMIT 1
+	`
+
+	metric, err := scanRawMetric(db.reader().QueryRowContext(ctx, query, zipcode))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// GetLatestMetricsAll returns the most recent reading of every location,
+// keyed by zipcode, in a single query ordered along the (zipcode,
+// timestamp) index. SQLite has no DISTINCT ON, so it ranks each location's
+// readings instead.
+func (db *DB) GetLatestMetricsAll(ctx context.Context) (map[string]*RawMetric, error) {
+	query := `
+		SELECT DISTINCT ON (zipcode) id,` + rawMetricColumns + `
+		FROM raw_metrics
+		ORDER BY zipcode, timestamp DESC, station_id
+	`
+	if db.driver == DriverSQLite {
+		query = `
+			SELECT id,` + rawMetricColumns + `
+			FROM (
+				SELECT *, ROW_NUMBER() OVER (PARTITION BY zipcode ORDER BY timestamp DESC, station_id) AS n
+				FROM raw_metrics
+			)
+			WHERE n = 1
+		`
+	}
+
+	rows, err := db.reader().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[string]*RawMetric)
+	for rows.Next() {
+		metric, err := scanRawMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		latest[metric.Zipcode] = metric
+	}
+	return latest, rows.Err()
+}
+
+// statsPrefixes and spreadPrefixes name the columns of a MetricStats and
+// a MetricSpread, e.g. p95_ in p95_temp
+var (
+	statsPrefixes  = []string{"min_", "max_", "p50_", "p95_", "stddev_"}
+	spreadPrefixes = []string{"p50_", "p95_", "stddev_"}
+)
+
+// aggregateColumns lists each AggregateMetrics column with each prefix, by
+// metric, e.g. p50_temp, p95_temp, p50_humidity, ...
+func aggregateColumns(prefixes ...string) string {
+	var columns []string
+	for _, metric := range AggregateMetrics {
+		for _, prefix := range prefixes {
+			columns = append(columns, prefix+metric.Name)
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// GetHourlyMetrics returns a page of a location's hourly aggregates for
+// the hours starting in [from, to), in hour order. A limit of zero or less
+// returns every hour after offset.
+func (db *DB) GetHourlyMetrics(ctx context.Context, zipcode string, from, to time.Time, limit, offset int) ([]*HourlyMetric, error) {
+	query := `
+		SELECT id, zipcode, hour_timestamp,
+			avg_temp, avg_humidity, avg_precip, avg_wind, avg_pollution, avg_pollen,
+			avg_pressure, avg_visibility, avg_uv_index, avg_snow_depth,
+			avg_heat_index, avg_wind_chill, avg_dew_point,
+			` + aggregateColumns(statsPrefixes...) + `, mode_wind_direction,
+			sample_count, expected_samples, completeness, incomplete, created_at
+		FROM hourly_metrics
+		WHERE zipcode = $1 AND hour_timestamp >= $2 AND hour_timestamp < $3
+		ORDER BY hour_timestamp
+		LIMIT $4 OFFSET $5
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, zipcode, from, to, db.driver.pageLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []*HourlyMetric
+	for rows.Next() {
+		m := &HourlyMetric{}
+		dest := []any{
+			&m.ID, &m.Zipcode, &m.HourTimestamp,
+			&m.AvgTemp, &m.AvgHumidity, &m.AvgPrecip, &m.AvgWind, &m.AvgPollution, &m.AvgPollen,
+			&m.AvgPressure, &m.AvgVisibility, &m.AvgUVIndex, &m.AvgSnowDepth,
+			&m.AvgHeatIndex, &m.AvgWindChill, &m.AvgDewPoint,
+		}
+		for _, stats := range m.stats() {
+			dest = append(dest, &stats.Min, &stats.Max, &stats.P50, &stats.P95, &stats.StdDev)
+		}
+		dest = append(dest, &m.ModeWindDirection, &m.SampleCount,
+			&m.ExpectedSamples, &m.Completeness, &m.Incomplete, &m.CreatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// UpsertHourlyMetrics writes hourly aggregates computed outside the
+// database, replacing those already stored for the same zipcode and hour,
+// in one transaction
+func (db *DB) UpsertHourlyMetrics(ctx context.Context, metrics []*HourlyMetric) error {
+	columns := []string{"zipcode", "hour_timestamp"}
+	for _, metric := range AggregateMetrics {
+		columns = append(columns, "avg_"+metric.Name)
+	}
+	for _, metric := range AggregateMetrics {
+		for _, prefix := range statsPrefixes {
+			columns = append(columns, prefix+metric.Name)
+		}
+	}
+	columns = append(columns, "mode_wind_direction", "sample_count", "expected_samples", "completeness", "incomplete")
+	placeholders := make([]string, len(columns))
+	var updates []string
+	for i, column := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		if i >= 2 {
+			updates = append(updates, column+" = EXCLUDED."+column)
+		}
+	}
+	query := `
+		INSERT INTO hourly_metrics (` + strings.Join(columns, ", ") + `)
+		VALUES (` + strings.Join(placeholders, ", ") + `)
+		ON CONFLICT (zipcode, hour_timestamp) DO UPDATE
+		SET ` + strings.Join(updates, ", ")
+
+	tx, err := db.BeginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, m := range metrics {
+		args := []any{m.Zipcode, m.HourTimestamp}
+		for _, avg := range m.averages() {
+			args = append(args, *avg)
+		}
+		for _, stats := range m.stats() {
+			args = append(args, stats.Min, stats.Max, stats.P50, stats.P95, stats.StdDev)
+		}
+		args = append(args, m.ModeWindDirection, m.SampleCount, m.ExpectedSamples, m.Completeness, m.Incomplete)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to upsert hourly metrics of %s at %s: %w", m.Zipcode, m.HourTimestamp.Format("2006-01-02 15:04"), err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDailySummaries returns a page of a location's daily summaries for the
+// dates from's date up to but not including to's date, in date order. A
+// limit of zero or less returns every day after offset.
+func (db *DB) GetDailySummaries(ctx context.Context, zipcode string, from, to time.Time, limit, offset int) ([]*DailySummary, error) {
+	query := `
+		SELECT id, zipcode, date,
+			min_temp, max_temp, min_humidity, max_humidity,
+			min_precip, max_precip, min_wind, max_wind,
+			min_pollution, max_pollution, min_pollen, max_pollen,
+			min_pressure, max_pressure, min_visibility, max_visibility,
+			min_uv_index, max_uv_index, min_snow_depth, max_snow_depth,
+			min_heat_index, max_heat_index, min_wind_chill, max_wind_chill,
+			min_dew_point, max_dew_point,
+			` + aggregateColumns(spreadPrefixes...) + `, mode_wind_direction,
+			heating_degree_days, cooling_degree_days, total_precip, precip_year_to_date,
+			created_at
+		FROM daily_summary
+		WHERE zipcode = $1 AND date >= ` + db.driver.date("$2") + ` AND date < ` + db.driver.date("$3") + `
+		ORDER BY date
+		LIMIT $4 OFFSET $5
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, zipcode, from.Format("2006-01-02"), to.Format("2006-01-02"), db.driver.pageLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*DailySummary
+	for rows.Next() {
+		s := &DailySummary{}
+		dest := []any{
+			&s.ID, &s.Zipcode, &s.Date,
+			&s.MinTemp, &s.MaxTemp, &s.MinHumidity, &s.MaxHumidity,
+			&s.MinPrecip, &s.MaxPrecip, &s.MinWind, &s.MaxWind,
+			&s.MinPollution, &s.MaxPollution, &s.MinPollen, &s.MaxPollen,
+			&s.MinPressure, &s.MaxPressure, &s.MinVisibility, &s.MaxVisibility,
+			&s.MinUVIndex, &s.MaxUVIndex, &s.MinSnowDepth, &s.MaxSnowDepth,
+			&s.MinHeatIndex, &s.MaxHeatIndex, &s.MinWindChill, &s.MaxWindChill,
+			&s.MinDewPoint, &s.MaxDewPoint,
+		}
+		for _, spread := range s.spreads() {
+			dest = append(dest, &spread.P50, &spread.P95, &spread.StdDev)
+		}
+		dest = append(dest, &s.ModeWindDirection,
+			&s.HeatingDegreeDays, &s.CoolingDegreeDays, &s.TotalPrecip, &s.PrecipYearToDate,
+			&s.CreatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestValidateAlarmThreshold(t *testing.T) {
+	valid := AlarmThreshold{Zipcode: "94105", MetricName: "temperature", Operator: ">=", ThresholdValue: 35, DurationMinutes: 15}
+	if err := ValidateAlarmThreshold(&valid); err != nil {
+		t.Fatalf("Expected valid threshold, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*AlarmThreshold)
+	}{
+		{"missing zipcode", func(t *AlarmThreshold) { t.Zipcode = "" }},
+		{"missing metric", func(t *AlarmThreshold) { t.MetricName = "" }},
+		{"unknown operator", func(t *AlarmThreshold) { t.Operator = "==" }},
+		{"negative duration", func(t *AlarmThreshold) { t.DurationMinutes = -1 }},
+		{"unknown severity", func(t *AlarmThreshold) { t.Severity = "urgent" }},
+	}
+	for _, tt := range tests {
+		threshold := valid
+		tt.modify(&threshold)
+		if err := ValidateAlarmThreshold(&threshold); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestPartitionNames(t *testing.T) {
+	month := time.Date(2026, time.October, 17, 23, 0, 0, 0, time.FixedZone("PDT", -7*3600))
+	name := partitionName("raw_metrics", month)
+	if name != "raw_metrics_p2026_10" {
+		t.Fatalf("Expected raw_metrics_p2026_10, got %s", name)
+	}
+
+	parsed, ok := partitionMonth("raw_metrics", name)
+	if !ok || !parsed.Equal(time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected October 2026, got %v (%v)", parsed, ok)
+	}
+	if _, ok := partitionMonth("raw_metrics", "raw_metrics_default"); ok {
+		t.Error("Expected the default partition not to parse as a month")
+	}
+}
+
+func TestReplicaReader(t *testing.T) {
+	var set replicaSet
+	for _, addr := range []string{"replica-a", "replica-b", "replica-c"} {
+		db, err := sql.Open("postgres", "host="+addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		set.replicas = append(set.replicas, &replica{addr: addr, db: db})
+	}
+	if set.reader() != nil {
+		t.Fatal("Expected no reader while every replica is unhealthy")
+	}
+
+	set.replicas[0].healthy.Store(true)
+	set.replicas[2].healthy.Store(true)
+	used := make(map[*sql.DB]int)
+	for i := 0; i < 10; i++ {
+		used[set.reader()]++
+	}
+	if len(used) != 2 || used[set.replicas[1].db] != 0 {
+		t.Errorf("Expected reads spread over the two healthy replicas, got %v", used)
+	}
+
+	var none *replicaSet
+	if none.reader() != nil {
+		t.Error("Expected no reader without replicas")
+	}
+}
+
+func TestDistanceKm(t *testing.T) {
+	// San Francisco to Los Angeles
+	if d := DistanceKm(37.7749, -122.4194, 34.0522, -118.2437); d < 555 || d > 565 {
+		t.Errorf("Expected about 559 km, got %.1f", d)
+	}
+	if d := DistanceKm(51.5, -0.12, 51.5, -0.12); d != 0 {
+		t.Errorf("Expected 0 km to the same point, got %v", d)
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	minLat, maxLat, minLon, maxLon := boundingBox(37.77, -122.42, 32)
+	for _, p := range [][2]float64{{37.77, -122.05}, {38.05, -122.42}} {
+		if DistanceKm(37.77, -122.42, p[0], p[1]) > 32 {
+			continue
+		}
+		if p[0] < minLat || p[0] > maxLat || p[1] < minLon || p[1] > maxLon {
+			t.Errorf("Expected %v within the box", p)
+		}
+	}
+
+	// Across the antimeridian every longitude is in range
+	if _, _, minLon, maxLon := boundingBox(0, 179.9, 50); minLon != -180 || maxLon != 180 {
+		t.Errorf("Expected the whole longitude range, got %v to %v", minLon, maxLon)
+	}
+}
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"github.com/smukkama/weather-server/internal/database.(*DB).GetRawMetrics":                      "database.DB.GetRawMetrics",
+		"github.com/smukkama/weather-server/internal/database.insertRawMetrics":                         "database.insertRawMetrics",
+		"github.com/smukkama/weather-server/internal/aggregation.(*HourlyAggregator).Aggregate.func1.2": "aggregation.HourlyAggregator.Aggregate",
+	}
+	for function, want := range tests {
+		if got := queryName(function); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", function, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+)
+
+// webhookDeliveryColumns are the webhook_deliveries columns
+// scanWebhookDelivery reads
+const webhookDeliveryColumns = `id, endpoint, notification_type, zipcode, metric_name, alarm_id, status, attempts,
+	last_status_code, last_error, created_at, updated_at`
+
+// scanWebhookDelivery reads a row of webhookDeliveryColumns
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	if err := row.Scan(&d.ID, &d.Endpoint, &d.NotificationType, &d.Zipcode, &d.MetricName, &d.AlarmID, &d.Status, &d.Attempts,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// InsertWebhookDelivery records a delivery as pending, setting its ID and
+// timestamps
+func (db *DB) InsertWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint, notification_type, zipcode, metric_name, alarm_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, status, created_at, updated_at
+	`
+
+	return db.QueryRowContext(ctx, query,
+		d.Endpoint, d.NotificationType, d.Zipcode, d.MetricName, d.AlarmID,
+	).Scan(&d.ID, &d.Status, &d.CreatedAt, &d.UpdatedAt)
+}
+
+// UpdateWebhookDelivery records a delivery's status and its last attempt
+func (db *DB) UpdateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_status_code = $4, last_error = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	_, err := db.ExecContext(ctx, query, d.ID, d.Status, d.Attempts, d.LastStatusCode, d.LastError)
+	return err
+}
+
+// ListWebhookDeliveries retrieves the most recent deliveries with a
+// status, or of every status if it is empty, newest first
+func (db *DB) ListWebhookDeliveries(ctx context.Context, status string, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := db.QueryContext(ctx, query, status, db.driver.pageLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
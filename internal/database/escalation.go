@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrAlarmNotFound is returned when updating a logged alarm that doesn't
+// exist, or acknowledging one that has already cleared
+var ErrAlarmNotFound = errors.New("alarm not found")
+
+// GetAlarmLog retrieves a logged alarm by ID, or nil if there is none
+func (db *DB) GetAlarmLog(ctx context.Context, alarmID int64) (*AlarmLog, error) {
+	query := `
+		SELECT ` + alarmLogColumns + `
+		FROM alarms_log
+		WHERE alarm_id = $1
+	`
+
+	a, err := scanAlarmLog(db.QueryRowContext(ctx, query, alarmID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetUnacknowledgedAlarms retrieves the ACTIVE and FLAPPING alarms nobody
+// has acknowledged, or whose snooze has ended by now, which escalation
+// considers, oldest first. A cleared flapping alarm stays FLAPPING, so
+// only alarms without an end time count.
+func (db *DB) GetUnacknowledgedAlarms(ctx context.Context, now time.Time) ([]*AlarmLog, error) {
+	query := `
+		SELECT ` + alarmLogColumns + `
+		FROM alarms_log
+		WHERE status IN ($1, $2) AND end_time IS NULL
+		  AND (acknowledged_at IS NULL OR snoozed_until <= $3)
+		ORDER BY start_time, alarm_id
+	`
+
+	rows, err := db.QueryContext(ctx, query, AlarmStatusActive, AlarmStatusFlapping, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alarms []*AlarmLog
+	for rows.Next() {
+		a, err := scanAlarmLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, a)
+	}
+	return alarms, rows.Err()
+}
+
+// SetAlarmEscalation records that an alarm was escalated to level at the
+// given time
+func (db *DB) SetAlarmEscalation(ctx context.Context, alarmID int64, level int, at time.Time) error {
+	query := `
+		UPDATE alarms_log
+		SET escalation_level = $2, escalated_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE alarm_id = $1
+	`
+
+	result, err := db.ExecContext(ctx, query, alarmID, level, at)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlarmNotFound
+	}
+	return nil
+}
+
+// AcknowledgeAlarm records that by acknowledged an alarm that hasn't
+// cleared at the given time, which stops its escalation until snoozedUntil,
+// or until it clears if that is zero. Acknowledging it again replaces the
+// acknowledgement.
+func (db *DB) AcknowledgeAlarm(ctx context.Context, alarmID int64, by string, at, snoozedUntil time.Time) error {
+	query := `
+		UPDATE alarms_log
+		SET acknowledged_at = $2, acknowledged_by = $3, snoozed_until = $4,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE alarm_id = $1 AND end_time IS NULL
+	`
+
+	result, err := db.ExecContext(ctx, query, alarmID, at, by, nullTime(snoozedUntil))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlarmNotFound
+	}
+	return nil
+}
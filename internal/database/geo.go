@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0
+
+// NearbyLocation is a location and its distance from a point
+type NearbyLocation struct {
+	Location
+	DistanceKm float64
+}
+
+// NearbyMetrics are the readings of a location near a point
+type NearbyMetrics struct {
+	NearbyLocation
+	Metrics []*RawMetric
+}
+
+// DistanceKm returns the great-circle distance between two coordinates
+// with the haversine formula
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// boundingBox returns the latitude and longitude ranges containing every
+// point within km of (lat, lon). Near a pole or the antimeridian the
+// longitude range is the whole circle.
+func boundingBox(lat, lon, km float64) (minLat, maxLat, minLon, maxLon float64) {
+	dLat := km / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat = lat-dLat, lat+dLat
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180
+	}
+	dLon := dLat / math.Cos(lat*math.Pi/180)
+	minLon, maxLon = lon-dLon, lon+dLon
+	if minLon < -180 || maxLon > 180 {
+		return minLat, maxLat, -180, 180
+	}
+	return minLat, maxLat, minLon, maxLon
+}
+
+// GetLocationsWithinRadius returns the locations with coordinates within
+// km of (lat, lon), nearest first. The bounding box around the point is
+// selected in SQL and the exact distance computed here, so it needs no
+// PostGIS and works on SQLite too.
+func (db *DB) GetLocationsWithinRadius(ctx context.Context, lat, lon, km float64) ([]*NearbyLocation, error) {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("invalid coordinates %v, %v", lat, lon)
+	}
+	if km <= 0 {
+		return nil, fmt.Errorf("radius must be positive, got %v km", km)
+	}
+
+	minLat, maxLat, minLon, maxLon := boundingBox(lat, lon, km)
+	query := `
+		SELECT zipcode, city_name, county, state, lat, lon, created_at, updated_at
+		FROM locations
+		WHERE lat BETWEEN $1 AND $2 AND lon BETWEEN $3 AND $4
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, minLat, maxLat, minLon, maxLon)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nearby []*NearbyLocation
+	for rows.Next() {
+		var n NearbyLocation
+		if err := rows.Scan(&n.Zipcode, &n.CityName, &n.County, &n.State, &n.Lat, &n.Lon, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		n.DistanceKm = DistanceKm(lat, lon, *n.Lat, *n.Lon)
+		if n.DistanceKm <= km {
+			nearby = append(nearby, &n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].DistanceKm < nearby[j].DistanceKm })
+	return nearby, nil
+}
+
+// GetMetricsNear returns the readings in [from, to) of each location
+// within km of (lat, lon), nearest location first and each location's
+// readings in timestamp order
+func (db *DB) GetMetricsNear(ctx context.Context, lat, lon, km float64, from, to time.Time) ([]*NearbyMetrics, error) {
+	locations, err := db.GetLocationsWithinRadius(ctx, lat, lon, km)
+	if err != nil || len(locations) == 0 {
+		return nil, err
+	}
+
+	args := []any{from, to}
+	placeholders := make([]string, len(locations))
+	byZipcode := make(map[string]*NearbyMetrics, len(locations))
+	nearby := make([]*NearbyMetrics, len(locations))
+	for i, loc := range locations {
+		args = append(args, loc.Zipcode)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+		nearby[i] = &NearbyMetrics{NearbyLocation: *loc}
+		byZipcode[loc.Zipcode] = nearby[i]
+	}
+
+	query := `
+		SELECT id,` + rawMetricColumns + `
+		FROM raw_metrics
+		WHERE timestamp >= $1 AND timestamp < $2
+		  AND zipcode IN (` + strings.Join(placeholders, ", ") + `)
+		ORDER BY timestamp, station_id
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		metric, err := scanRawMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		n := byZipcode[metric.Zipcode]
+		n.Metrics = append(n.Metrics, metric)
+	}
+	return nearby, rows.Err()
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// healthRetryMin is how soon a database that went down is checked again;
+// the wait doubles with every failed check up to the maximum backoff
+const healthRetryMin = time.Second
+
+// health pings the primary in the background and remembers whether it is
+// up, so services can pause while it is down instead of failing every
+// query, and retries with backoff until it is back
+type health struct {
+	db         *sql.DB
+	idleConns  int
+	interval   time.Duration
+	maxBackoff time.Duration
+
+	mu        sync.Mutex
+	err       error // nil while the database is up
+	downSince time.Time
+
+	wake chan struct{}
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// startHealthChecks checks db every interval while it is up, and with
+// backoff up to maxBackoff while it is down
+func startHealthChecks(db *sql.DB, idleConns int, interval, maxBackoff time.Duration) *health {
+	h := &health{
+		db:         db,
+		idleConns:  idleConns,
+		interval:   interval,
+		maxBackoff: max(maxBackoff, healthRetryMin),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	h.done.Add(1)
+	go h.monitor()
+	return h
+}
+
+// monitor runs the health checks until close
+func (h *health) monitor() {
+	defer h.done.Done()
+	delay := h.interval
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-h.wake:
+			// CheckHealth found the database down: retry soon
+			delay = healthRetryMin
+			timer.Reset(delay)
+			continue
+		case <-timer.C:
+		}
+
+		wasDown := h.status() != nil
+		if err := h.ping(context.Background()); err == nil {
+			delay = h.interval
+		} else if !wasDown {
+			delay = healthRetryMin
+		} else {
+			delay = min(delay*2, h.maxBackoff)
+		}
+		timer.Reset(delay)
+	}
+}
+
+// ping checks the database and records the result, unless ctx was done
+// first, which says nothing about the database
+func (h *health) ping(ctx context.Context) error {
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := h.db.PingContext(pingCtx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	h.set(err)
+	return err
+}
+
+// set records a check's result, logging changes. Once the database is
+// back the idle connections are dropped: those opened before a restart
+// are dead and would each fail the next query made on them.
+func (h *health) set(err error) {
+	h.mu.Lock()
+	wasUp := h.err == nil
+	h.err = err
+	downSince := h.downSince
+	if err != nil && wasUp {
+		h.downSince = time.Now()
+	}
+	h.mu.Unlock()
+
+	switch {
+	case err != nil && wasUp:
+		fmt.Printf("Database unhealthy, reconnecting with backoff: %v\n", err)
+	case err == nil && !wasUp:
+		h.db.SetMaxIdleConns(0)
+		h.db.SetMaxIdleConns(h.idleConns)
+		fmt.Printf("Database reconnected after %v\n", time.Since(downSince).Round(time.Second))
+	}
+}
+
+// status returns the last check's error, nil if the database was up
+func (h *health) status() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// close stops the health checks
+func (h *health) close() {
+	if h == nil {
+		return
+	}
+	select {
+	case <-h.stop:
+		return
+	default:
+		close(h.stop)
+	}
+	h.done.Wait()
+}
+
+// CheckHealth returns nil if the database is up. While the health checks
+// say it is up this pings it, so an outage is noticed straight away; once
+// they say it is down it returns their error until a check gets through,
+// so callers waiting out an outage don't add to the reconnection attempts.
+// Without health checks it just pings.
+func (db *DB) CheckHealth(ctx context.Context) error {
+	if db.health == nil {
+		return db.PingContext(ctx)
+	}
+	if err := db.health.status(); err != nil {
+		return fmt.Errorf("database unhealthy: %w", err)
+	}
+	err := db.health.ping(ctx)
+	if err != nil && ctx.Err() == nil {
+		select {
+		case db.health.wake <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// WaitHealthy returns once CheckHealth passes, checking every second, or
+// ctx's error if ctx is done first
+func (db *DB) WaitHealthy(ctx context.Context) error {
+	ticker := time.NewTicker(healthRetryMin)
+	defer ticker.Stop()
+	for db.CheckHealth(ctx) != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// QueryLatencyBounds are the upper bounds of the query latency buckets; a
+// final bucket holds everything slower
+var QueryLatencyBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// slowQueryLogLength is how much of a slow query's SQL is logged
+const slowQueryLogLength = 200
+
+// QueryStats is a snapshot of one query's latencies and errors. A query is
+// named after the method that made it, e.g. database.DB.GetRawMetrics.
+// Buckets[i] counts queries up to QueryLatencyBounds[i]; the last bucket
+// counts the rest.
+type QueryStats struct {
+	Query   string
+	Count   uint64
+	Errors  uint64
+	Slow    uint64
+	Total   time.Duration
+	Max     time.Duration
+	Buckets []uint64
+}
+
+// Mean returns the average latency
+func (s QueryStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// queryMetrics records the latency and errors of every query made through
+// a DB or Tx, and logs queries slower than slow. A query's latency runs
+// until its result, or its first rows, are ready; reading the rest of the
+// rows isn't included.
+type queryMetrics struct {
+	slow time.Duration // 0 = no slow-query log
+
+	mu      sync.Mutex
+	queries map[string]*QueryStats
+}
+
+func newQueryMetrics(slow time.Duration) *queryMetrics {
+	return &queryMetrics{slow: slow, queries: make(map[string]*QueryStats)}
+}
+
+// observe records a query that took took and failed with err, if not nil
+func (m *queryMetrics) observe(query string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	name := queryCaller()
+	slow := m.slow > 0 && took >= m.slow
+	bucket := sort.Search(len(QueryLatencyBounds), func(i int) bool { return took <= QueryLatencyBounds[i] })
+
+	m.mu.Lock()
+	stats, ok := m.queries[name]
+	if !ok {
+		stats = &QueryStats{Query: name, Buckets: make([]uint64, len(QueryLatencyBounds)+1)}
+		m.queries[name] = stats
+	}
+	stats.Count++
+	stats.Total += took
+	stats.Max = max(stats.Max, took)
+	stats.Buckets[bucket]++
+	if err != nil {
+		stats.Errors++
+	}
+	if slow {
+		stats.Slow++
+	}
+	m.mu.Unlock()
+
+	if slow {
+		text := strings.Join(strings.Fields(query), " ")
+		if len(text) > slowQueryLogLength {
+			text = text[:slowQueryLogLength] + "..."
+		}
+		fmt.Printf("Slow query %s took %v: %s\n", name, took.Round(time.Millisecond), text)
+	}
+}
+
+// snapshot returns the stats of every query, the most total time first
+func (m *queryMetrics) snapshot() []QueryStats {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	stats := make([]QueryStats, 0, len(m.queries))
+	for _, s := range m.queries {
+		snapshot := *s
+		snapshot.Buckets = append([]uint64(nil), s.Buckets...)
+		stats = append(stats, snapshot)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		return stats[i].Query < stats[j].Query
+	})
+	return stats
+}
+
+// queryCaller names the query being observed after the exported function
+// that made it, past the package's unexported helpers, e.g.
+// database.DB.GetRawMetrics, or after the function outside the package
+// that called ExecContext and the like directly
+func queryCaller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	first := ""
+	for {
+		frame, more := frames.Next()
+		if !strings.HasSuffix(frame.File, "/instrument.go") {
+			name := queryName(frame.Function)
+			function := name[strings.LastIndex(name, ".")+1:]
+			if !strings.HasPrefix(name, "database.") || (function != "" && unicode.IsUpper(rune(function[0]))) {
+				return name
+			}
+			if first == "" {
+				first = name
+			}
+		}
+		if !more {
+			return cmp.Or(first, "unknown")
+		}
+	}
+}
+
+// queryName shortens a function's full name to its package and function,
+// e.g. github.com/smukkama/weather-server/internal/database.(*DB).GetRawMetrics
+// to database.DB.GetRawMetrics, naming closures after their function
+func queryName(function string) string {
+	name := function[strings.LastIndex(function, "/")+1:]
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	for {
+		i := strings.LastIndex(name, ".func")
+		if i < 0 || strings.Trim(name[i+len(".func"):], "0123456789.") != "" {
+			return name
+		}
+		name = name[:i]
+	}
+}
+
+// instrumented records the queries made through q in metrics
+type instrumented struct {
+	q       querier
+	metrics *queryMetrics
+}
+
+func (i instrumented) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	result, err := i.q.ExecContext(ctx, query, args...)
+	i.metrics.observe(query, time.Since(start), err)
+	return result, err
+}
+
+func (i instrumented) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := i.q.QueryContext(ctx, query, args...)
+	i.metrics.observe(query, time.Since(start), err)
+	return rows, err
+}
+
+func (i instrumented) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := i.q.QueryRowContext(ctx, query, args...)
+	i.metrics.observe(query, time.Since(start), row.Err())
+	return row
+}
+
+// ExecContext runs a statement on the primary, recording it in the query
+// stats
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return instrumented{db.DB, db.metrics}.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs a query on the primary, recording it in the query stats
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return instrumented{db.DB, db.metrics}.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a single-row query on the primary, recording it in
+// the query stats
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return instrumented{db.DB, db.metrics}.QueryRowContext(ctx, query, args...)
+}
+
+// ExecContext runs a statement in the transaction, recording it in the
+// query stats
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return instrumented{tx.Tx, tx.metrics}.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs a query in the transaction, recording it in the query
+// stats
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return instrumented{tx.Tx, tx.metrics}.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a single-row query in the transaction, recording it
+// in the query stats
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return instrumented{tx.Tx, tx.metrics}.QueryRowContext(ctx, query, args...)
+}
+
+// QueryStats returns the latencies and errors of the queries made so far,
+// on the primary and the replicas, the most total time first
+func (db *DB) QueryStats() []QueryStats {
+	return db.metrics.snapshot()
+}
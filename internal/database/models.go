@@ -6,18 +6,39 @@ import (
 
 // Location represents a weather monitoring location
 type Location struct {
-	Zipcode   string
-	CityName  string
+	Zipcode  string
+	CityName string
+	// County and State place the zipcode in the region hierarchy, e.g.
+	// "Los Angeles County" and "CA". Kept by operators (SetLocationRegion);
+	// stations only report the city.
+	County    *string
+	State     *string
 	Lat       *float64
 	Lon       *float64
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Station represents a weather station and the metadata it reported
+type Station struct {
+	Zipcode         string
+	StationID       string
+	Lat             *float64
+	Lon             *float64
+	Elevation       *float64 // meters above sea level
+	Model           *string
+	FirmwareVersion *string
+	OwnerName       *string
+	OwnerContact    *string // email address or phone number
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
 // RawMetric represents a 5-minute weather measurement
 type RawMetric struct {
 	ID             int64
 	Zipcode        string
+	StationID      string // empty for readings not tied to a station
 	Timestamp      time.Time
 	Temperature    *float64
 	Humidity       *float64
@@ -26,6 +47,14 @@ type RawMetric struct {
 	WindDirection  *string
 	PollutionIndex *float64
 	PollenIndex    *float64
+	Pressure       *float64 // hPa
+	Visibility     *float64 // km
+	UVIndex        *float64
+	SnowDepth      *float64 // cm
+	HeatIndex      *float64
+	WindChill      *float64
+	DewPoint       *float64
+	QualityFlags   map[string]string // metric -> flag for suspect measurements
 	ReceivedAt     time.Time
 }
 
@@ -40,30 +69,290 @@ type HourlyMetric struct {
 	AvgWind       *float64
 	AvgPollution  *float64
 	AvgPollen     *float64
-	SampleCount   int
-	CreatedAt     time.Time
+	AvgPressure   *float64
+	AvgVisibility *float64
+	AvgUVIndex    *float64
+	AvgSnowDepth  *float64
+	AvgHeatIndex  *float64
+	AvgWindChill  *float64
+	AvgDewPoint   *float64
+
+	// Each metric's spread over the hour's readings, in
+	// AggregateMetrics order
+	TempStats       MetricStats
+	HumidityStats   MetricStats
+	PrecipStats     MetricStats
+	WindStats       MetricStats
+	PollutionStats  MetricStats
+	PollenStats     MetricStats
+	PressureStats   MetricStats
+	VisibilityStats MetricStats
+	UVIndexStats    MetricStats
+	SnowDepthStats  MetricStats
+	HeatIndexStats  MetricStats
+	WindChillStats  MetricStats
+	DewPointStats   MetricStats
+	// ModeWindDirection is the most common wind direction reported
+	ModeWindDirection *string
+
+	SampleCount int
+	// ExpectedSamples is how many readings the stations that reported
+	// should have sent, Completeness the percentage of them SampleCount
+	// is (at most 100), and Incomplete whether that is below the
+	// configured threshold. All nil for hours aggregated before they were
+	// recorded.
+	ExpectedSamples *int
+	Completeness    *float64
+	Incomplete      *bool
+	CreatedAt       time.Time
+}
+
+// stats returns the hour's MetricStats in AggregateMetrics order
+func (m *HourlyMetric) stats() []*MetricStats {
+	return []*MetricStats{
+		&m.TempStats, &m.HumidityStats, &m.PrecipStats, &m.WindStats,
+		&m.PollutionStats, &m.PollenStats, &m.PressureStats, &m.VisibilityStats,
+		&m.UVIndexStats, &m.SnowDepthStats, &m.HeatIndexStats, &m.WindChillStats,
+		&m.DewPointStats,
+	}
 }
 
-// DailySummary represents daily min/max data
+// averages returns the hour's averages in AggregateMetrics order
+func (m *HourlyMetric) averages() []**float64 {
+	return []**float64{
+		&m.AvgTemp, &m.AvgHumidity, &m.AvgPrecip, &m.AvgWind,
+		&m.AvgPollution, &m.AvgPollen, &m.AvgPressure, &m.AvgVisibility,
+		&m.AvgUVIndex, &m.AvgSnowDepth, &m.AvgHeatIndex, &m.AvgWindChill,
+		&m.AvgDewPoint,
+	}
+}
+
+// SetMetric sets the average and stats of AggregateMetrics[i]
+func (m *HourlyMetric) SetMetric(i int, avg *float64, stats MetricStats) {
+	*m.averages()[i] = avg
+	*m.stats()[i] = stats
+}
+
+// MetricSpread describes how a metric's readings are distributed: the
+// median, the 95th percentile (interpolated like Postgres percentile_cont)
+// and the sample standard deviation. Each is nil without readings;
+// StdDev is also nil with only one.
+type MetricSpread struct {
+	P50    *float64
+	P95    *float64
+	StdDev *float64
+}
+
+// MetricStats is a metric's extremes and spread over an hour
+type MetricStats struct {
+	Min *float64
+	Max *float64
+	MetricSpread
+}
+
+// AggregateMetrics are the metrics aggregated hourly and daily: Name is
+// the suffix of their aggregate columns, e.g. temp in avg_temp and
+// p95_temp, and Column their raw_metrics column
+var AggregateMetrics = []struct{ Name, Column string }{
+	{"temp", "temperature"},
+	{"humidity", "humidity"},
+	{"precip", "precipitation"},
+	{"wind", "wind_speed"},
+	{"pollution", "pollution_index"},
+	{"pollen", "pollen_index"},
+	{"pressure", "pressure"},
+	{"visibility", "visibility"},
+	{"uv_index", "uv_index"},
+	{"snow_depth", "snow_depth"},
+	{"heat_index", "heat_index"},
+	{"wind_chill", "wind_chill"},
+	{"dew_point", "dew_point"},
+}
+
+// DailySummary represents daily min/max data. The extremes come from
+// the hourly extremes, or the hourly averages for hours aggregated before
+// those were kept.
 type DailySummary struct {
+	ID            int64
+	Zipcode       string
+	Date          time.Time
+	MinTemp       *float64
+	MaxTemp       *float64
+	MinHumidity   *float64
+	MaxHumidity   *float64
+	MinPrecip     *float64
+	MaxPrecip     *float64
+	MinWind       *float64
+	MaxWind       *float64
+	MinPollution  *float64
+	MaxPollution  *float64
+	MinPollen     *float64
+	MaxPollen     *float64
+	MinPressure   *float64
+	MaxPressure   *float64
+	MinVisibility *float64
+	MaxVisibility *float64
+	MinUVIndex    *float64
+	MaxUVIndex    *float64
+	MinSnowDepth  *float64
+	MaxSnowDepth  *float64
+	MinHeatIndex  *float64
+	MaxHeatIndex  *float64
+	MinWindChill  *float64
+	MaxWindChill  *float64
+	MinDewPoint   *float64
+	MaxDewPoint   *float64
+
+	// Each metric's spread over the day's readings, in AggregateMetrics
+	// order
+	TempSpread       MetricSpread
+	HumiditySpread   MetricSpread
+	PrecipSpread     MetricSpread
+	WindSpread       MetricSpread
+	PollutionSpread  MetricSpread
+	PollenSpread     MetricSpread
+	PressureSpread   MetricSpread
+	VisibilitySpread MetricSpread
+	UVIndexSpread    MetricSpread
+	SnowDepthSpread  MetricSpread
+	HeatIndexSpread  MetricSpread
+	WindChillSpread  MetricSpread
+	DewPointSpread   MetricSpread
+	// ModeWindDirection is the most common wind direction reported
+	ModeWindDirection *string
+
+	// Degree days: how far the day's mean temperature, halfway between
+	// its extremes, fell below or rose above the base temperature, in °C
+	HeatingDegreeDays *float64
+	CoolingDegreeDays *float64
+	// TotalPrecip is the day's precipitation in mm, and PrecipYearToDate
+	// the total since January 1st
+	TotalPrecip      *float64
+	PrecipYearToDate *float64
+
+	CreatedAt time.Time
+}
+
+// spreads returns the day's MetricSpreads in AggregateMetrics order
+func (s *DailySummary) spreads() []*MetricSpread {
+	return []*MetricSpread{
+		&s.TempSpread, &s.HumiditySpread, &s.PrecipSpread, &s.WindSpread,
+		&s.PollutionSpread, &s.PollenSpread, &s.PressureSpread, &s.VisibilitySpread,
+		&s.UVIndexSpread, &s.SnowDepthSpread, &s.HeatIndexSpread, &s.WindChillSpread,
+		&s.DewPointSpread,
+	}
+}
+
+// extremes returns the day's minimum and maximum of each metric in
+// AggregateMetrics order
+func (s *DailySummary) extremes() [][2]**float64 {
+	return [][2]**float64{
+		{&s.MinTemp, &s.MaxTemp}, {&s.MinHumidity, &s.MaxHumidity},
+		{&s.MinPrecip, &s.MaxPrecip}, {&s.MinWind, &s.MaxWind},
+		{&s.MinPollution, &s.MaxPollution}, {&s.MinPollen, &s.MaxPollen},
+		{&s.MinPressure, &s.MaxPressure}, {&s.MinVisibility, &s.MaxVisibility},
+		{&s.MinUVIndex, &s.MaxUVIndex}, {&s.MinSnowDepth, &s.MaxSnowDepth},
+		{&s.MinHeatIndex, &s.MaxHeatIndex}, {&s.MinWindChill, &s.MaxWindChill},
+		{&s.MinDewPoint, &s.MaxDewPoint},
+	}
+}
+
+// ConnectionSession represents one station connection, from identify to
+// disconnect
+type ConnectionSession struct {
+	ConnectionID     string
+	Zipcode          string
+	City             string
+	StationID        string
+	RemoteAddr       string
+	ConnectedAt      time.Time
+	LastIdleAt       *time.Time
+	DisconnectedAt   *time.Time
+	DurationSeconds  *int
+	DisconnectReason *string
+}
+
+// ColdStorageExport records a range of raw metrics moved to a file in
+// object storage
+type ColdStorageExport struct {
+	ID         int64
+	RangeStart time.Time
+	RangeEnd   time.Time
+	ObjectURL  string
+	RowCount   int64
+	SizeBytes  int64
+	ExportedAt time.Time
+}
+
+// AggregationRun records one hourly or daily aggregation of a window, or a
+// pending request for one
+type AggregationRun struct {
 	ID           int64
-	Zipcode      string
-	Date         time.Time
-	MinTemp      *float64
-	MaxTemp      *float64
-	MinHumidity  *float64
-	MaxHumidity  *float64
-	MinPrecip    *float64
-	MaxPrecip    *float64
-	MinWind      *float64
-	MaxWind      *float64
-	MinPollution *float64
-	MaxPollution *float64
-	MinPollen    *float64
-	MaxPollen    *float64
+	Kind         string
+	WindowStart  time.Time
+	WindowEnd    time.Time
+	Status       string
+	StartedAt    *time.Time
+	FinishedAt   *time.Time
+	RowsAffected *int64
+	Error        *string
 	CreatedAt    time.Time
 }
 
+// RegionalHourlyMetric is an hour's aggregates across the zipcodes of a
+// city, county or state. The embedded HourlyMetric has no zipcode: its
+// averages are the zipcodes' averages, each counting equally, its extremes
+// the most extreme of theirs, and its SampleCount their total. The rest is
+// nil.
+type RegionalHourlyMetric struct {
+	RegionType   string
+	Region       string
+	ZipcodeCount int
+	HourlyMetric
+}
+
+// RegionalDailySummary is a day's summary across the zipcodes of a city,
+// county or state. The embedded DailySummary has no zipcode: its extremes
+// are the most extreme of theirs, and its degree days and precipitation
+// their average. The spreads and wind direction are nil.
+type RegionalDailySummary struct {
+	RegionType   string
+	Region       string
+	ZipcodeCount int
+	DailySummary
+}
+
+// WindowedMetric is a zipcode's aggregates over one of the configured
+// windows, e.g. 15 minutes. The embedded HourlyMetric holds them, with
+// HourTimestamp the window's start; its completeness is nil.
+type WindowedMetric struct {
+	Window    time.Duration
+	WindowEnd time.Time
+	HourlyMetric
+}
+
+// Region types, from smallest to largest. Cities and counties are named
+// with their state, e.g. "Los Angeles, CA"; states by themselves.
+const (
+	RegionCity   = "city"
+	RegionCounty = "county"
+	RegionState  = "state"
+)
+
+// Aggregation run kinds
+const (
+	AggregationHourly = "hourly"
+	AggregationDaily  = "daily"
+)
+
+// Aggregation run statuses
+const (
+	AggregationRunPending   = "pending"
+	AggregationRunRunning   = "running"
+	AggregationRunSucceeded = "succeeded"
+	AggregationRunFailed    = "failed"
+)
+
 // AlarmThreshold represents an alarm configuration
 type AlarmThreshold struct {
 	ID              int
@@ -72,11 +361,112 @@ type AlarmThreshold struct {
 	Operator        string
 	ThresholdValue  float64
 	DurationMinutes int
+	// ClearValue is what the metric must pass back through, on the other
+	// side of ThresholdValue, to clear an active alarm, and
+	// ClearDurationMinutes how long it must stay there. Without a
+	// ClearValue the alarm clears once ThresholdValue isn't breached.
+	ClearValue           *float64
+	ClearDurationMinutes int
+	Severity             string // info, warning or critical; empty means warning
+	IsActive             bool
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// AlarmRule is a composite alarm: its conditions, on metrics of the same
+// reading, combined with AND or OR, must hold for DurationMinutes to
+// trigger it. It clears as soon as they no longer do.
+type AlarmRule struct {
+	ID              int
+	Zipcode         string
+	Name            string
+	Combinator      string
+	Conditions      []AlarmCondition
+	DurationMinutes int
+	Severity        string // info, warning or critical; empty means warning
 	IsActive        bool
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
 
+// AlarmCondition is one comparison of an alarm rule
+type AlarmCondition struct {
+	MetricName     string
+	Operator       string
+	ThresholdValue float64
+}
+
+// AnomalyRule alarms when a metric is at least Deviations standard
+// deviations from its baseline, the hourly averages at the same hour of
+// the last BaselineDays days, for DurationMinutes. It isn't evaluated
+// while the baseline has fewer than MinSamples hours.
+type AnomalyRule struct {
+	ID              int
+	Zipcode         string
+	MetricName      string
+	Deviations      float64
+	BaselineDays    int
+	MinSamples      int
+	DurationMinutes int
+	Severity        string // info, warning or critical; empty means warning
+	IsActive        bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// AlarmWebhook is a URL called when its threshold's alarm triggers or
+// clears. The body is PayloadTemplate, a text/template over the alarm
+// notification, or the notification's JSON without one, signed with
+// Secret if set.
+type AlarmWebhook struct {
+	ID              int
+	ThresholdID     int
+	URL             string
+	PayloadTemplate *string
+	Secret          *string
+	IsActive        bool
+	CreatedAt       time.Time
+}
+
+// Baseline is the distribution of a metric's hourly averages at one hour
+// of the day: their mean, sample standard deviation and count
+type Baseline struct {
+	Mean    float64
+	StdDev  float64
+	Samples int
+}
+
+// Alarm severities, from least to most severe
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AlarmSeverities are the severities an alarm can have, from least to most
+// severe
+var AlarmSeverities = []string{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// Alarm rule combinators
+const (
+	CombinatorAnd = "AND"
+	CombinatorOr  = "OR"
+)
+
+// AlarmSuppression is a maintenance window from StartsAt to EndsAt
+// withholding the notifications of alarms matching it, which are still
+// evaluated and logged. A nil Zipcode or MetricName matches every zipcode
+// or metric; a rule's alarms match on the rule's name.
+type AlarmSuppression struct {
+	ID         int
+	Zipcode    *string
+	MetricName *string
+	StartsAt   time.Time
+	EndsAt     time.Time
+	Reason     string
+	CreatedAt  time.Time
+}
+
 // AlarmLog represents a logged alarm event
 type AlarmLog struct {
 	AlarmID         int64
@@ -87,11 +477,94 @@ type AlarmLog struct {
 	StartTime       time.Time
 	EndTime         *time.Time
 	Status          string
+	Severity        string
+	// AcknowledgedAt is when AcknowledgedBy last acknowledged the alarm,
+	// which stops its escalation until SnoozedUntil, or until it clears
+	// without one
+	AcknowledgedAt *time.Time
+	AcknowledgedBy *string
+	SnoozedUntil   *time.Time
+	// EscalationLevel is how many times the alarm has been escalated, last
+	// at EscalatedAt
+	EscalationLevel int
+	EscalatedAt     *time.Time
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
 
+// AlarmFilter selects logged alarms. Empty fields match every alarm.
+type AlarmFilter struct {
+	Zipcode    string
+	MetricName string
+	Status     string
+	From       time.Time // alarms starting at or after
+	To         time.Time // alarms starting before
+	Limit      int       // at most this many, if positive
+	Offset     int
+}
+
+// AlarmStats summarizes the alarms matching a filter
+type AlarmStats struct {
+	Count        int64
+	Active       int64
+	Cleared      int64
+	Flapping     int64
+	MeanDuration time.Duration // over cleared alarms
+	MaxDuration  time.Duration // over cleared alarms
+}
+
+// Alarm log statuses. An alarm triggered while flapping is logged as
+// FLAPPING and stays so once cleared.
+const (
+	AlarmStatusActive   = "ACTIVE"
+	AlarmStatusCleared  = "CLEARED"
+	AlarmStatusFlapping = "FLAPPING"
+)
+
+// WebhookDelivery is a notification sent, or being sent, to a webhook
+// endpoint
+type WebhookDelivery struct {
+	ID               int64
+	Endpoint         string
+	NotificationType string
+	Zipcode          string
+	MetricName       string
+	AlarmID          *int64
+	Status           string
+	Attempts         int
+	LastStatusCode   *int
+	LastError        *string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// Webhook delivery statuses
 const (
-	AlarmStatusActive  = "ACTIVE"
-	AlarmStatusCleared = "CLEARED"
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
 )
+
+// Subscription subscribes a recipient, an email address or phone number,
+// to the notifications of alarms matching it at MinSeverity or above. A
+// nil Zipcode or MetricName matches every zipcode or metric; a rule's
+// alarms match on the rule's name.
+type Subscription struct {
+	ID          int
+	Recipient   string
+	Channel     string
+	Zipcode     *string
+	MetricName  *string
+	MinSeverity string
+	IsActive    bool
+	CreatedAt   time.Time
+}
+
+// Subscription channels
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// SubscriptionChannels are the channels a recipient can subscribe on
+var SubscriptionChannels = []string{ChannelEmail, ChannelSMS}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PartitionedTables maps each table migrations/partitioned splits into
+// monthly partitions to its partition column
+var PartitionedTables = map[string]string{
+	"raw_metrics": "timestamp",
+	"alarms_log":  "start_time",
+}
+
+// partitionSuffix names a month's partition after its table, e.g.
+// raw_metrics_p2026_10
+const partitionSuffix = "_p2006_01"
+
+// monthStart returns the start of t's month in UTC
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionName returns the name of table's partition for month
+func partitionName(table string, month time.Time) string {
+	return table + monthStart(month).Format(partitionSuffix)
+}
+
+// partitionMonth parses the month of one of table's monthly partitions,
+// or returns false for any other partition
+func partitionMonth(table, partition string) (time.Time, bool) {
+	if !strings.HasPrefix(partition, table) {
+		return time.Time{}, false
+	}
+	month, err := time.Parse(partitionSuffix, partition[len(table):])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+// partitionColumn returns the partition column of one of PartitionedTables
+func partitionColumn(table string) (string, error) {
+	column, ok := PartitionedTables[table]
+	if !ok {
+		return "", fmt.Errorf("%s is not a partitioned table", table)
+	}
+	return column, nil
+}
+
+// MonthlyPartitions returns the months table has a partition for, by
+// partition name
+func (db *DB) MonthlyPartitions(ctx context.Context, table string) (map[string]time.Time, error) {
+	query := `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = $1
+	`
+
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := make(map[string]time.Time)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if month, ok := partitionMonth(table, name); ok {
+			partitions[name] = month
+		}
+	}
+	return partitions, rows.Err()
+}
+
+// EnsureMonthlyPartitions creates table's partitions for the months from
+// through ahead months after it, and for every month with rows in the
+// default partition, moving those rows in. It returns the partitions it
+// created.
+func (db *DB) EnsureMonthlyPartitions(ctx context.Context, table string, from time.Time, ahead int) ([]string, error) {
+	column, err := partitionColumn(table)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := db.MonthlyPartitions(ctx, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", table, err)
+	}
+
+	months := make(map[time.Time]bool)
+	for i := 0; i <= ahead; i++ {
+		months[monthStart(from).AddDate(0, i, 0)] = true
+	}
+
+	query := fmt.Sprintf(`
+		SELECT DISTINCT date_trunc('month', %s AT TIME ZONE 'UTC')
+		FROM %s_default
+	`, column, table)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find rows in the default partition of %s: %w", table, err)
+	}
+	for rows.Next() {
+		var month time.Time
+		if err := rows.Scan(&month); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		months[monthStart(month)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var created []string
+	for month := range months {
+		name := partitionName(table, month)
+		if _, ok := existing[name]; ok {
+			continue
+		}
+		if err := db.createMonthlyPartition(ctx, table, column, month); err != nil {
+			return created, fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+// createMonthlyPartition creates table's partition for month. Attaching a
+// partition fails while the default partition holds rows in its range, so
+// those are moved into the new table first, all in one transaction.
+func (db *DB) createMonthlyPartition(ctx context.Context, table, column string, month time.Time) error {
+	name := partitionName(table, month)
+	start := month.Format("2006-01-02 15:04:05Z07:00")
+	end := month.AddDate(0, 1, 0).Format("2006-01-02 15:04:05Z07:00")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", name, table),
+		fmt.Sprintf(`
+			WITH moved AS (
+				DELETE FROM %s_default WHERE %s >= '%s' AND %s < '%s' RETURNING *
+			)
+			INSERT INTO %s SELECT * FROM moved
+		`, table, column, start, column, end, name),
+		fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')", table, name, start, end),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DetachPartitionsBefore detaches table's monthly partitions that end at
+// or before before, leaving them as standalone tables to archive or drop.
+// It returns the partitions it detached.
+func (db *DB) DetachPartitionsBefore(ctx context.Context, table string, before time.Time) ([]string, error) {
+	if _, err := partitionColumn(table); err != nil {
+		return nil, err
+	}
+
+	existing, err := db.MonthlyPartitions(ctx, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", table, err)
+	}
+
+	var detached []string
+	for name, month := range existing {
+		if month.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", table, name)); err != nil {
+			return detached, fmt.Errorf("failed to detach partition %s: %w", name, err)
+		}
+		detached = append(detached, name)
+	}
+	return detached, nil
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLocationNotFound is returned when updating a location that doesn't
+// exist
+var ErrLocationNotFound = errors.New("location not found")
+
+// SetLocationRegion records the county and state a zipcode lies in, which
+// its city, county and state rollups are built from. Nil clears a field;
+// a location without a state is left out of every rollup, and one without
+// a county out of the county rollup.
+func (db *DB) SetLocationRegion(ctx context.Context, zipcode string, county, state *string) error {
+	query := `
+		UPDATE locations
+		SET county = $2, state = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE zipcode = $1
+	`
+
+	result, err := db.ExecContext(ctx, query, zipcode, county, state)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLocationNotFound
+	}
+	return nil
+}
+
+// GetRegionalHourlyMetrics returns a page of a region's hourly aggregates
+// for the hours starting in [from, to), in hour order, like
+// GetHourlyMetrics. A limit of zero or less returns every hour after
+// offset.
+func (db *DB) GetRegionalHourlyMetrics(ctx context.Context, regionType, region string, from, to time.Time, limit, offset int) ([]*RegionalHourlyMetric, error) {
+	query := `
+		SELECT id, region_type, region, hour_timestamp,
+			` + aggregateColumns("avg_") + `,
+			` + aggregateColumns("min_", "max_") + `,
+			zipcode_count, sample_count, created_at
+		FROM regional_hourly_metrics
+		WHERE region_type = $1 AND region = $2 AND hour_timestamp >= $3 AND hour_timestamp < $4
+		ORDER BY hour_timestamp
+		LIMIT $5 OFFSET $6
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, regionType, region, from, to, db.driver.pageLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []*RegionalHourlyMetric
+	for rows.Next() {
+		m := &RegionalHourlyMetric{}
+		dest := []any{&m.ID, &m.RegionType, &m.Region, &m.HourTimestamp}
+		for _, avg := range m.averages() {
+			dest = append(dest, avg)
+		}
+		for _, stats := range m.stats() {
+			dest = append(dest, &stats.Min, &stats.Max)
+		}
+		dest = append(dest, &m.ZipcodeCount, &m.SampleCount, &m.CreatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetRegionalDailySummaries returns a page of a region's daily summaries
+// for the dates from's date up to but not including to's date, in date
+// order, like GetDailySummaries. A limit of zero or less returns every day
+// after offset.
+func (db *DB) GetRegionalDailySummaries(ctx context.Context, regionType, region string, from, to time.Time, limit, offset int) ([]*RegionalDailySummary, error) {
+	query := `
+		SELECT id, region_type, region, date,
+			` + aggregateColumns("min_", "max_") + `,
+			heating_degree_days, cooling_degree_days, total_precip, precip_year_to_date,
+			zipcode_count, created_at
+		FROM regional_daily_summary
+		WHERE region_type = $1 AND region = $2 AND date >= ` + db.driver.date("$3") + ` AND date < ` + db.driver.date("$4") + `
+		ORDER BY date
+		LIMIT $5 OFFSET $6
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, regionType, region, from.Format("2006-01-02"), to.Format("2006-01-02"), db.driver.pageLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []*RegionalDailySummary
+	for rows.Next() {
+		s := &RegionalDailySummary{}
+		dest := []any{&s.ID, &s.RegionType, &s.Region, &s.Date}
+		for _, extremes := range s.extremes() {
+			dest = append(dest, extremes[0], extremes[1])
+		}
+		dest = append(dest, &s.HeatingDegreeDays, &s.CoolingDegreeDays, &s.TotalPrecip, &s.PrecipYearToDate,
+			&s.ZipcodeCount, &s.CreatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
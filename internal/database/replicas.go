@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// replica is a read-only copy of the database that read queries can use
+// while it is healthy
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet spreads reads over the healthy replicas
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	maxLag   time.Duration
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// openReplicas opens a pool per replica address, sharing the primary's
+// credentials and pool settings, and starts checking their health. A
+// replica that can't be reached yet is used once a check succeeds.
+func openReplicas(cfg config.DatabaseConfig) (*replicaSet, error) {
+	set := &replicaSet{maxLag: cfg.ReplicaMaxLag, stop: make(chan struct{})}
+	for _, addr := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.Host = addr
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			replicaCfg.Host = host
+			if replicaCfg.Port, err = strconv.Atoi(port); err != nil {
+				set.close()
+				return nil, fmt.Errorf("invalid replica address %q", addr)
+			}
+		}
+
+		db, err := sql.Open(cfg.Driver, replicaCfg.ConnectionString())
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("failed to open replica %s: %w", addr, err)
+		}
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+		set.replicas = append(set.replicas, &replica{addr: addr, db: db})
+	}
+
+	set.checkAll()
+	set.done.Add(1)
+	go set.monitor(cfg.ReplicaCheckInterval)
+	return set, nil
+}
+
+// reader returns the next healthy replica, or nil if none is
+func (s *replicaSet) reader() *sql.DB {
+	if s == nil || len(s.replicas) == 0 {
+		return nil
+	}
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return nil
+}
+
+// monitor checks the replicas every interval until close
+func (s *replicaSet) monitor(interval time.Duration) {
+	defer s.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkAll()
+		}
+	}
+}
+
+// checkAll marks each replica healthy if it answers and isn't lagging
+// more than maxLag behind the primary, logging changes
+func (s *replicaSet) checkAll() {
+	for _, r := range s.replicas {
+		err := s.check(r)
+		if healthy := err == nil; r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				fmt.Printf("Read replica %s is healthy, routing reads to it\n", r.addr)
+			} else {
+				fmt.Printf("Read replica %s is unhealthy, reads fall back to the others: %v\n", r.addr, err)
+			}
+		}
+	}
+}
+
+// check returns why a replica shouldn't serve reads, or nil
+func (s *replicaSet) check(r *replica) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A replica that has replayed everything it received isn't behind,
+	// however long ago the primary last wrote
+	query := `
+		SELECT CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END
+	`
+	var lagSeconds float64
+	if err := r.db.QueryRowContext(ctx, query).Scan(&lagSeconds); err != nil {
+		return err
+	}
+	if lag := time.Duration(lagSeconds * float64(time.Second)); s.maxLag > 0 && lag > s.maxLag {
+		return fmt.Errorf("replication lag %v exceeds %v", lag.Round(time.Second), s.maxLag)
+	}
+	return nil
+}
+
+// close stops the health checks and closes the replica pools
+func (s *replicaSet) close() {
+	if s == nil {
+		return
+	}
+	select {
+	case <-s.stop:
+		return
+	default:
+		close(s.stop)
+	}
+	s.done.Wait()
+	for _, r := range s.replicas {
+		r.db.Close()
+	}
+}
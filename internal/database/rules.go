@@ -0,0 +1,132 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrAlarmRuleNotFound is returned when deleting an alarm rule that
+// doesn't exist
+var ErrAlarmRuleNotFound = errors.New("alarm rule not found")
+
+// ValidateAlarmRule checks a rule before it is stored
+func ValidateAlarmRule(r *AlarmRule) error {
+	if r.Zipcode == "" {
+		return fmt.Errorf("zipcode is required")
+	}
+	if r.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if r.Combinator != CombinatorAnd && r.Combinator != CombinatorOr {
+		return fmt.Errorf("invalid combinator %q (want %s or %s)", r.Combinator, CombinatorAnd, CombinatorOr)
+	}
+	if len(r.Conditions) < 2 {
+		return fmt.Errorf("a rule needs at least two conditions, got %d", len(r.Conditions))
+	}
+	for _, c := range r.Conditions {
+		if c.MetricName == "" {
+			return fmt.Errorf("metric name is required")
+		}
+		if !slices.Contains(AlarmOperators, c.Operator) {
+			return fmt.Errorf("invalid operator %q (want one of %s)", c.Operator, strings.Join(AlarmOperators, ", "))
+		}
+	}
+	if r.DurationMinutes < 0 {
+		return fmt.Errorf("duration must not be negative, got %d minutes", r.DurationMinutes)
+	}
+	return validateSeverity(r.Severity)
+}
+
+// GetActiveAlarmRules retrieves all active alarm rules for a zipcode, with
+// their conditions
+func (db *DB) GetActiveAlarmRules(ctx context.Context, zipcode string) ([]*AlarmRule, error) {
+	query := `
+		SELECT r.id, r.zipcode, r.name, r.combinator, r.duration_minutes, r.severity, r.is_active,
+		       r.created_at, r.updated_at, c.metric_name, c.operator, c.threshold_value
+		FROM alarm_rules r
+		JOIN alarm_rule_conditions c ON c.rule_id = r.id
+		WHERE r.zipcode = $1 AND r.is_active = true
+		ORDER BY r.name, c.id
+	`
+
+	rows, err := db.QueryContext(ctx, query, zipcode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*AlarmRule
+	for rows.Next() {
+		var r AlarmRule
+		var c AlarmCondition
+		if err := rows.Scan(&r.ID, &r.Zipcode, &r.Name, &r.Combinator, &r.DurationMinutes, &r.Severity, &r.IsActive,
+			&r.CreatedAt, &r.UpdatedAt, &c.MetricName, &c.Operator, &c.ThresholdValue); err != nil {
+			return nil, err
+		}
+		if len(rules) == 0 || rules[len(rules)-1].ID != r.ID {
+			rules = append(rules, &r)
+		}
+		last := rules[len(rules)-1]
+		last.Conditions = append(last.Conditions, c)
+	}
+
+	return rules, rows.Err()
+}
+
+// CreateAlarmRule validates and inserts an alarm rule with its conditions,
+// setting its ID and timestamps. A location's rules have distinct names.
+func (db *DB) CreateAlarmRule(ctx context.Context, r *AlarmRule) error {
+	if err := ValidateAlarmRule(r); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO alarm_rules (zipcode, name, combinator, duration_minutes, severity, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`
+	r.Severity = cmp.Or(r.Severity, SeverityWarning)
+	if err := tx.QueryRowContext(ctx, query,
+		r.Zipcode, r.Name, r.Combinator, r.DurationMinutes, r.Severity, r.IsActive,
+	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return err
+	}
+
+	for _, c := range r.Conditions {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO alarm_rule_conditions (rule_id, metric_name, operator, threshold_value)
+			VALUES ($1, $2, $3, $4)
+		`, r.ID, c.MetricName, c.Operator, c.ThresholdValue); err != nil {
+			return fmt.Errorf("failed to insert rule condition: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAlarmRule deletes an alarm rule and its conditions. Its logged
+// alarms are kept.
+func (db *DB) DeleteAlarmRule(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM alarm_rules WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlarmRuleNotFound
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrAggregationRunning is returned when a window is already being
+// aggregated
+var ErrAggregationRunning = errors.New("aggregation already running")
+
+// aggregationRunColumns are the aggregation_runs columns scanAggregationRun
+// reads
+const aggregationRunColumns = `id, kind, window_start, window_end, status, started_at,
+		       finished_at, rows_affected, error, created_at`
+
+// scanAggregationRun reads a row of aggregationRunColumns
+func scanAggregationRun(row rowScanner) (*AggregationRun, error) {
+	var r AggregationRun
+	if err := row.Scan(
+		&r.ID,
+		&r.Kind,
+		&r.WindowStart,
+		&r.WindowEnd,
+		&r.Status,
+		&r.StartedAt,
+		&r.FinishedAt,
+		&r.RowsAffected,
+		&r.Error,
+		&r.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// StartAggregationRun records that a run of the window from start to end
+// has started and returns its ID, or ErrAggregationRunning if another run
+// of the window is running. A run still marked running that started before
+// abandonedBefore is taken to have died with its aggregator and is marked
+// failed instead.
+func (db *DB) StartAggregationRun(ctx context.Context, kind string, start, end, abandonedBefore time.Time) (int64, error) {
+	abandon := `
+		UPDATE aggregation_runs
+		SET status = 'failed', finished_at = CURRENT_TIMESTAMP, error = 'abandoned while running'
+		WHERE kind = $1 AND window_start = $2 AND status = 'running' AND started_at < $3
+	`
+	if _, err := db.ExecContext(ctx, abandon, kind, start, abandonedBefore); err != nil {
+		return 0, err
+	}
+
+	// The partial unique index lets only one run of a window be running
+	query := `
+		INSERT INTO aggregation_runs (kind, window_start, window_end, status, started_at)
+		VALUES ($1, $2, $3, 'running', $4)
+		ON CONFLICT (kind, window_start) WHERE status = 'running' DO NOTHING
+		RETURNING id
+	`
+	var id int64
+	err := db.QueryRowContext(ctx, query, kind, start, end, time.Now()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrAggregationRunning
+	}
+	return id, err
+}
+
+// FinishAggregationRun records how a run ended: succeeded with rows
+// affected, or failed with runErr
+func (db *DB) FinishAggregationRun(ctx context.Context, id int64, rows int64, runErr error) error {
+	status, message := AggregationRunSucceeded, sql.NullString{}
+	if runErr != nil {
+		status, message = AggregationRunFailed, sql.NullString{String: runErr.Error(), Valid: true}
+	}
+	query := `
+		UPDATE aggregation_runs
+		SET status = $2, finished_at = $3, rows_affected = $4, error = $5
+		WHERE id = $1
+	`
+	_, err := db.ExecContext(ctx, query, id, status, time.Now(), rows, message)
+	return err
+}
+
+// GetAggregationRuns retrieves the latest limit runs, newest first,
+// optionally only of one kind and status ("" for any)
+func (db *DB) GetAggregationRuns(ctx context.Context, kind, status string, limit int) ([]*AggregationRun, error) {
+	query := `
+		SELECT ` + aggregationRunColumns + `
+		FROM aggregation_runs
+		WHERE ($1 = '' OR kind = $1) AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, kind, status, db.driver.pageLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAggregationRuns(rows)
+}
+
+// GetAggregationRetries retrieves the windows starting at or after since
+// whose latest run failed, or that an operator asked to be aggregated
+// again with a pending run: the hours, oldest first, then the days
+func (db *DB) GetAggregationRetries(ctx context.Context, since time.Time) ([]*AggregationRun, error) {
+	query := `
+		SELECT ` + aggregationRunColumns + `
+		FROM aggregation_runs r
+		WHERE window_start >= $1
+		  AND status IN ('failed', 'pending')
+		  AND id = (
+			SELECT MAX(id) FROM aggregation_runs
+			WHERE kind = r.kind AND window_start = r.window_start
+		  )
+		ORDER BY kind DESC, window_start
+	`
+
+	rows, err := db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAggregationRuns(rows)
+}
+
+// scanAggregationRuns reads every row of aggregationRunColumns
+func scanAggregationRuns(rows *sql.Rows) ([]*AggregationRun, error) {
+	var runs []*AggregationRun
+	for rows.Next() {
+		run, err := scanAggregationRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriverName is the SQLite driver registered with database/sql. It
+// wraps modernc.org/sqlite, which needs no cgo and so cross-compiles for
+// gateways like a Raspberry Pi.
+const sqliteDriverName = "weather-sqlite"
+
+func init() {
+	sql.Register(sqliteDriverName, sqliteDriver{})
+}
+
+// sqliteDSN opens path in WAL mode, so reads don't wait for the writer,
+// with foreign keys enforced. Write transactions take the write lock when
+// they begin and wait up to busy_timeout for it, instead of failing when
+// two writers meet.
+func sqliteDSN(path string) string {
+	query := url.Values{}
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Set("_txlock", "immediate")
+	query.Set("_time_format", "sqlite")
+	return "file:" + path + "?" + query.Encode()
+}
+
+// sqliteDriver opens sqliteConns
+type sqliteDriver struct{}
+
+// sqliteBaseDriver is the driver modernc.org/sqlite registers as "sqlite",
+// which adds the functions registered with sqlite.RegisterFunction to the
+// connections it opens; a new sqlite.Driver wouldn't
+var sqliteBaseDriver = func() driver.Driver {
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	return db.Driver()
+}()
+
+func (sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := sqliteBaseDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{conn.(sqliteDriverConn)}, nil
+}
+
+// sqliteDriverConn is what database/sql uses of a modernc.org/sqlite
+// connection
+type sqliteDriverConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// sqliteConn stores times in UTC. SQLite keeps them as text, which only
+// compares in time order when every value has the same zone.
+type sqliteConn struct {
+	sqliteDriverConn
+}
+
+// CheckNamedValue converts time arguments to UTC, leaving the rest to the
+// default conversion
+func (c *sqliteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case time.Time:
+		nv.Value = v.UTC()
+		return nil
+	case *time.Time:
+		if v == nil {
+			nv.Value = nil
+		} else {
+			nv.Value = v.UTC()
+		}
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// dialect is the database driver in use, which decides the SQL that
+// differs between Postgres and SQLite
+type dialect string
+
+// greatest returns the larger of two expressions
+func (d dialect) greatest(a, b string) string {
+	if d == DriverSQLite {
+		return "MAX(" + a + ", " + b + ")"
+	}
+	return "GREATEST(" + a + ", " + b + ")"
+}
+
+// secondsBetween returns the seconds from one timestamp expression to
+// another
+func (d dialect) secondsBetween(from, to string) string {
+	if d == DriverSQLite {
+		return "(julianday(" + to + ") - julianday(" + from + ")) * 86400"
+	}
+	return "EXTRACT(EPOCH FROM " + to + " - " + from + ")"
+}
+
+// forUpdate locks the selected rows until the transaction ends. SQLite
+// has no row locks; its write transactions already exclude each other.
+func (d dialect) forUpdate() string {
+	if d == DriverSQLite {
+		return ""
+	}
+	return "FOR UPDATE"
+}
+
+// date casts a 'YYYY-MM-DD' parameter to a date. SQLite stores dates as
+// that text.
+func (d dialect) date(param string) string {
+	if d == DriverSQLite {
+		return param
+	}
+	return param + "::date"
+}
+
+// pageLimit is the LIMIT argument for a page of limit rows; with no limit
+// it is NULL, which Postgres treats as LIMIT ALL, or -1 for SQLite
+func (d dialect) pageLimit(limit int) any {
+	if limit > 0 {
+		return limit
+	}
+	if d == DriverSQLite {
+		return -1
+	}
+	return nil
+}
+
+// Driver returns the database driver, for the SQL other packages write
+func (db *DB) Driver() string {
+	return string(db.driver)
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"modernc.org/sqlite"
+)
+
+// SQLite lacks the aggregates the hourly and daily aggregation use, so
+// they are added in Go with Postgres' names and NULL handling:
+// percentile_cont(x, fraction) for Postgres' percentile_cont(fraction)
+// WITHIN GROUP (ORDER BY x), stddev_samp(x), and mode(x) for mode() WITHIN
+// GROUP (ORDER BY x).
+func init() {
+	sqlite.MustRegisterFunction("percentile_cont", &sqlite.FunctionImpl{
+		NArgs:         2,
+		Deterministic: true,
+		MakeAggregate: func(sqlite.FunctionContext) (sqlite.AggregateFunction, error) {
+			return &percentileAggregate{}, nil
+		},
+	})
+	sqlite.MustRegisterFunction("stddev_samp", &sqlite.FunctionImpl{
+		NArgs:         1,
+		Deterministic: true,
+		MakeAggregate: func(sqlite.FunctionContext) (sqlite.AggregateFunction, error) {
+			return &stddevAggregate{}, nil
+		},
+	})
+	sqlite.MustRegisterFunction("mode", &sqlite.FunctionImpl{
+		NArgs:         1,
+		Deterministic: true,
+		MakeAggregate: func(sqlite.FunctionContext) (sqlite.AggregateFunction, error) {
+			return &modeAggregate{counts: make(map[string]int)}, nil
+		},
+	})
+}
+
+// errNotWindowFunction is returned when an aggregate is used as a window
+// function, which the aggregation doesn't need
+var errNotWindowFunction = errors.New("not supported as a window function")
+
+// sqliteFloat converts a SQLite value to a float, or returns false for NULL
+func sqliteFloat(v driver.Value) (float64, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return 0, false, nil
+	case float64:
+		return v, true, nil
+	case int64:
+		return float64(v), true, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil, err
+	}
+	return 0, false, fmt.Errorf("not a number: %v", v)
+}
+
+// percentileAggregate interpolates between the two values nearest the
+// fraction's position, like Postgres percentile_cont
+type percentileAggregate struct {
+	values   []float64
+	fraction float64
+}
+
+func (a *percentileAggregate) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	fraction, ok, err := sqliteFloat(args[1])
+	if err != nil || !ok || fraction < 0 || fraction > 1 {
+		return fmt.Errorf("percentile_cont: fraction %v is not between 0 and 1", args[1])
+	}
+	a.fraction = fraction
+	v, ok, err := sqliteFloat(args[0])
+	if ok {
+		a.values = append(a.values, v)
+	}
+	return err
+}
+
+func (a *percentileAggregate) WindowInverse(*sqlite.FunctionContext, []driver.Value) error {
+	return errNotWindowFunction
+}
+
+func (a *percentileAggregate) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	if len(a.values) == 0 {
+		return nil, nil
+	}
+	slices.Sort(a.values)
+	pos := a.fraction * float64(len(a.values)-1)
+	lower := int(math.Floor(pos))
+	if lower == len(a.values)-1 {
+		return a.values[lower], nil
+	}
+	return a.values[lower] + (pos-float64(lower))*(a.values[lower+1]-a.values[lower]), nil
+}
+
+func (a *percentileAggregate) Final(*sqlite.FunctionContext) {}
+
+// stddevAggregate computes the sample standard deviation with Welford's
+// method
+type stddevAggregate struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func (a *stddevAggregate) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	v, ok, err := sqliteFloat(args[0])
+	if !ok {
+		return err
+	}
+	a.n++
+	delta := v - a.mean
+	a.mean += delta / float64(a.n)
+	a.m2 += delta * (v - a.mean)
+	return nil
+}
+
+func (a *stddevAggregate) WindowInverse(*sqlite.FunctionContext, []driver.Value) error {
+	return errNotWindowFunction
+}
+
+func (a *stddevAggregate) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	if a.n < 2 {
+		return nil, nil
+	}
+	return math.Sqrt(a.m2 / float64(a.n-1)), nil
+}
+
+func (a *stddevAggregate) Final(*sqlite.FunctionContext) {}
+
+// modeAggregate returns the most common value, the smallest of those tied
+// as Postgres mode() does
+type modeAggregate struct {
+	counts map[string]int
+}
+
+func (a *modeAggregate) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	switch v := args[0].(type) {
+	case nil:
+	case string:
+		a.counts[v]++
+	case []byte:
+		a.counts[string(v)]++
+	default:
+		a.counts[fmt.Sprint(v)]++
+	}
+	return nil
+}
+
+func (a *modeAggregate) WindowInverse(*sqlite.FunctionContext, []driver.Value) error {
+	return errNotWindowFunction
+}
+
+func (a *modeAggregate) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	var mode string
+	best := 0
+	for v, n := range a.counts {
+		if n > best || (n == best && v < mode) {
+			mode, best = v, n
+		}
+	}
+	if best == 0 {
+		return nil, nil
+	}
+	return mode, nil
+}
+
+func (a *modeAggregate) Final(*sqlite.FunctionContext) {}
@@ -0,0 +1,668 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+func openSQLite(t *testing.T) *DB {
+	t.Helper()
+	db, err := Connect(config.DatabaseConfig{
+		Driver:       DriverSQLite,
+		SQLitePath:   filepath.Join(t.TempDir(), "weather.db"),
+		MaxOpenConns: 4,
+		MaxIdleConns: 4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.RunMigrations(context.Background(), "../../migrations/sqlite"); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return db
+}
+
+func TestSQLiteMetrics(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatalf("Failed to upsert location: %v", err)
+	}
+	lat, lon := 37.79, -122.39
+	if err := db.UpsertStation(ctx, &Station{Zipcode: "94105", StationID: "roof", Lat: &lat, Lon: &lon}); err != nil {
+		t.Fatalf("Failed to upsert station: %v", err)
+	}
+	loc, err := db.GetLocation(ctx, "94105")
+	if err != nil || loc == nil || loc.Lat == nil || *loc.Lat != lat {
+		t.Fatalf("Expected the station to fill in the location's coordinates, got %+v (%v)", loc, err)
+	}
+
+	// Readings in another zone are stored in UTC, so they sort and compare
+	// in time order
+	pdt := time.FixedZone("PDT", -7*3600)
+	start := time.Date(2026, time.October, 17, 3, 0, 0, 0, pdt)
+	var metrics []*RawMetric
+	for i := 0; i < 12; i++ {
+		temp := 20 + float64(i)
+		metrics = append(metrics, &RawMetric{
+			Zipcode:      "94105",
+			StationID:    "roof",
+			Timestamp:    start.Add(time.Duration(i) * 5 * time.Minute),
+			Temperature:  &temp,
+			ReceivedAt:   start,
+			QualityFlags: map[string]string{},
+		})
+	}
+	metrics[3].QualityFlags = map[string]string{"temperature": "spike"}
+	if err := db.InsertRawMetricsBatch(ctx, metrics); err != nil {
+		t.Fatalf("Failed to insert metrics: %v", err)
+	}
+	// A redelivered reading replaces its row
+	if err := db.InsertRawMetric(ctx, metrics[0]); err != nil {
+		t.Fatalf("Failed to upsert metric: %v", err)
+	}
+
+	got, err := db.GetRawMetrics(ctx, "94105", start.UTC(), start.Add(time.Hour), 5, 2)
+	if err != nil {
+		t.Fatalf("Failed to query metrics: %v", err)
+	}
+	if len(got) != 5 || !got[0].Timestamp.Equal(metrics[2].Timestamp) {
+		t.Fatalf("Expected 5 readings from the third, got %d starting %v", len(got), got[0].Timestamp)
+	}
+	if got[1].QualityFlags["temperature"] != "spike" {
+		t.Errorf("Expected the quality flags back, got %v", got[1].QualityFlags)
+	}
+	all, err := db.GetRawMetrics(ctx, "94105", start, start.Add(time.Hour), 0, 0)
+	if err != nil || len(all) != 12 {
+		t.Fatalf("Expected all 12 readings without a limit, got %d (%v)", len(all), err)
+	}
+
+	latest, err := db.GetLatestMetricsAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query latest metrics: %v", err)
+	}
+	if m := latest["94105"]; m == nil || !m.Timestamp.Equal(metrics[11].Timestamp) {
+		t.Errorf("Expected the last reading as the latest, got %+v", m)
+	}
+
+	stats := make(map[string]QueryStats)
+	for _, q := range db.QueryStats() {
+		stats[q.Query] = q
+	}
+	if q := stats["database.DB.GetRawMetrics"]; q.Count != 2 || q.Errors != 0 {
+		t.Errorf("Expected 2 raw metric queries, got %+v", q)
+	}
+	if q := stats["database.DB.InsertRawMetricsBatch"]; q.Count != 1 {
+		t.Errorf("Expected the batch insert named after its method, not its helpers, got %+v", q)
+	}
+}
+
+func TestSQLiteStations(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"roof", "park"} {
+		if err := db.UpsertStation(ctx, &Station{Zipcode: "94105", StationID: id}); err != nil {
+			t.Fatal(err)
+		}
+		temp := float64(20 + i)
+		if err := db.InsertRawMetric(ctx, &RawMetric{Zipcode: "94105", StationID: id, Timestamp: now, Temperature: &temp, ReceivedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name, contact := "Parks Dept", "parks@example.com"
+	if err := db.SetStationOwner(ctx, "94105", "park", &name, &contact); err != nil {
+		t.Fatalf("Failed to set owner: %v", err)
+	}
+	if err := db.SetStationOwner(ctx, "94105", "missing", &name, nil); err != ErrStationNotFound {
+		t.Errorf("Expected ErrStationNotFound for an unknown station, got %v", err)
+	}
+	stations, err := db.GetStations(ctx, "94105")
+	if err != nil || len(stations) != 2 {
+		t.Fatalf("Expected two stations, got %d (%v)", len(stations), err)
+	}
+	for _, s := range stations {
+		if owned := s.OwnerContact != nil; owned != (s.StationID == "park") {
+			t.Errorf("Expected only the park station to have an owner, got %s with %v", s.StationID, s.OwnerContact)
+		}
+	}
+
+	metrics, err := db.GetStationMetrics(ctx, "94105", "park", now, now.Add(time.Minute), 0, 0)
+	if err != nil || len(metrics) != 1 || *metrics[0].Temperature != 21 {
+		t.Fatalf("Expected the park station's reading only, got %+v (%v)", metrics, err)
+	}
+}
+
+func TestSQLiteAlarms(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+
+	threshold := &AlarmThreshold{Zipcode: "94105", MetricName: "temperature", Operator: ">", ThresholdValue: 35, DurationMinutes: 10, IsActive: true}
+	if err := db.CreateAlarmThreshold(ctx, threshold); err != nil {
+		t.Fatalf("Failed to create threshold: %v", err)
+	}
+	threshold.ThresholdValue = 38
+	clear := 36.0
+	threshold.ClearValue, threshold.ClearDurationMinutes = &clear, 5
+	if err := db.UpdateAlarmThreshold(ctx, threshold); err != nil {
+		t.Fatalf("Failed to update threshold: %v", err)
+	}
+	active, err := db.GetActiveAlarmThresholds(ctx, "94105")
+	if err != nil || len(active) != 1 || active[0].ThresholdValue != 38 {
+		t.Fatalf("Expected the updated threshold, got %v (%v)", active, err)
+	}
+	if active[0].ClearValue == nil || *active[0].ClearValue != 36 || active[0].ClearDurationMinutes != 5 {
+		t.Errorf("Expected it to clear below 36 for 5 minutes, got %v for %d", active[0].ClearValue, active[0].ClearDurationMinutes)
+	}
+	clear = 40
+	if err := db.UpdateAlarmThreshold(ctx, threshold); err == nil {
+		t.Error("Expected an error for a clear value above a > threshold")
+	}
+
+	start := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	for i, minutes := range []int{10, 30, 0} {
+		alarm := &AlarmLog{
+			Zipcode:         "94105",
+			MetricName:      "temperature",
+			BreachValue:     40,
+			ThresholdConfig: "{}",
+			StartTime:       start.Add(time.Duration(i) * time.Hour),
+			Status:          AlarmStatusActive,
+		}
+		if err := db.InsertAlarmLog(ctx, alarm); err != nil {
+			t.Fatalf("Failed to log alarm: %v", err)
+		}
+		if minutes > 0 {
+			if err := db.UpdateAlarmLogCleared(ctx, alarm.AlarmID, alarm.StartTime.Add(time.Duration(minutes)*time.Minute)); err != nil {
+				t.Fatalf("Failed to clear alarm: %v", err)
+			}
+		}
+	}
+
+	stillActive, err := db.GetActiveAlarms(ctx, "")
+	if err != nil || len(stillActive) != 1 {
+		t.Fatalf("Expected one active alarm, got %d (%v)", len(stillActive), err)
+	}
+	stats, err := db.GetAlarmStats(ctx, AlarmFilter{Zipcode: "94105", From: start})
+	if err != nil {
+		t.Fatalf("Failed to compute alarm stats: %v", err)
+	}
+	if stats.Count != 3 || stats.Active != 1 || stats.Cleared != 2 {
+		t.Errorf("Expected 3 alarms, 1 active and 2 cleared, got %+v", stats)
+	}
+	if stats.MeanDuration.Round(time.Second) != 20*time.Minute || stats.MaxDuration.Round(time.Second) != 30*time.Minute {
+		t.Errorf("Expected a 20m mean and 30m longest alarm, got %v and %v", stats.MeanDuration, stats.MaxDuration)
+	}
+
+	flapping := &AlarmLog{
+		Zipcode:         "94105",
+		MetricName:      "temperature",
+		BreachValue:     40,
+		ThresholdConfig: "{}",
+		StartTime:       start.Add(3 * time.Hour),
+		Status:          AlarmStatusFlapping,
+	}
+	if err := db.InsertAlarmLog(ctx, flapping); err != nil {
+		t.Fatalf("Failed to log flapping alarm: %v", err)
+	}
+	if err := db.UpdateAlarmLogCleared(ctx, flapping.AlarmID, flapping.StartTime.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to clear flapping alarm: %v", err)
+	}
+	stats, err = db.GetAlarmStats(ctx, AlarmFilter{Zipcode: "94105", From: start})
+	if err != nil {
+		t.Fatalf("Failed to compute alarm stats: %v", err)
+	}
+	if stats.Count != 4 || stats.Cleared != 2 || stats.Flapping != 1 {
+		t.Errorf("Expected a cleared flapping alarm to stay FLAPPING, got %+v", stats)
+	}
+
+	if err := db.DeleteAlarmThreshold(ctx, threshold.ID); err != nil {
+		t.Fatalf("Failed to delete threshold: %v", err)
+	}
+	if err := db.DeleteAlarmThreshold(ctx, threshold.ID); err != ErrAlarmThresholdNotFound {
+		t.Errorf("Expected ErrAlarmThresholdNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteAlarmEscalation(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	alarm := &AlarmLog{Zipcode: "94105", MetricName: "wind_speed", BreachValue: 120, ThresholdConfig: "{}",
+		StartTime: start, Status: AlarmStatusActive, Severity: SeverityCritical}
+	if err := db.InsertAlarmLog(ctx, alarm); err != nil {
+		t.Fatalf("Failed to log alarm: %v", err)
+	}
+	if err := db.SetAlarmEscalation(ctx, alarm.AlarmID, 1, start.Add(15*time.Minute)); err != nil {
+		t.Fatalf("Failed to escalate alarm: %v", err)
+	}
+
+	alarms, err := db.GetUnacknowledgedAlarms(ctx, start)
+	if err != nil || len(alarms) != 1 {
+		t.Fatalf("Expected one unacknowledged alarm, got %d (%v)", len(alarms), err)
+	}
+	if a := alarms[0]; a.Severity != SeverityCritical || a.EscalationLevel != 1 || a.EscalatedAt == nil || !a.EscalatedAt.Equal(start.Add(15*time.Minute)) {
+		t.Errorf("Expected a critical alarm escalated once at 12:15, got %+v", a)
+	}
+
+	snoozedUntil := start.Add(2 * time.Hour)
+	if err := db.AcknowledgeAlarm(ctx, alarm.AlarmID, "alice", start.Add(20*time.Minute), snoozedUntil); err != nil {
+		t.Fatalf("Failed to acknowledge alarm: %v", err)
+	}
+	if alarms, err := db.GetUnacknowledgedAlarms(ctx, start.Add(time.Hour)); err != nil || len(alarms) != 0 {
+		t.Errorf("Expected no unacknowledged alarms while snoozed, got %d (%v)", len(alarms), err)
+	}
+	if alarms, err := db.GetUnacknowledgedAlarms(ctx, snoozedUntil); err != nil || len(alarms) != 1 {
+		t.Errorf("Expected the alarm back once its snooze ended, got %d (%v)", len(alarms), err)
+	}
+	acked, err := db.GetAlarmLog(ctx, alarm.AlarmID)
+	if err != nil || acked == nil || acked.AcknowledgedBy == nil || *acked.AcknowledgedBy != "alice" {
+		t.Errorf("Expected alice's acknowledgement, got %+v (%v)", acked, err)
+	}
+
+	if err := db.UpdateAlarmLogCleared(ctx, alarm.AlarmID, start.Add(3*time.Hour)); err != nil {
+		t.Fatalf("Failed to clear alarm: %v", err)
+	}
+	if err := db.AcknowledgeAlarm(ctx, alarm.AlarmID, "alice", start, time.Time{}); err != ErrAlarmNotFound {
+		t.Errorf("Expected ErrAlarmNotFound acknowledging a cleared alarm, got %v", err)
+	}
+
+	// Flapping alarms escalate too, until cleared
+	flapping := &AlarmLog{Zipcode: "94105", MetricName: "wind_speed", BreachValue: 110, ThresholdConfig: "{}",
+		StartTime: start.Add(4 * time.Hour), Status: AlarmStatusFlapping, Severity: SeverityCritical}
+	if err := db.InsertAlarmLog(ctx, flapping); err != nil {
+		t.Fatalf("Failed to log flapping alarm: %v", err)
+	}
+	alarms, err = db.GetUnacknowledgedAlarms(ctx, start.Add(5*time.Hour))
+	if err != nil || len(alarms) != 1 || alarms[0].AlarmID != flapping.AlarmID {
+		t.Fatalf("Expected the flapping alarm unacknowledged, got %d (%v)", len(alarms), err)
+	}
+	if err := db.UpdateAlarmLogCleared(ctx, flapping.AlarmID, start.Add(6*time.Hour)); err != nil {
+		t.Fatalf("Failed to clear flapping alarm: %v", err)
+	}
+	if alarms, err := db.GetUnacknowledgedAlarms(ctx, start.Add(7*time.Hour)); err != nil || len(alarms) != 0 {
+		t.Errorf("Expected a cleared flapping alarm not escalated, got %d (%v)", len(alarms), err)
+	}
+}
+
+func TestSQLiteAlarmSuppressions(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	zipcode, metric := "94105", "humidity"
+	sensor := &AlarmSuppression{Zipcode: &zipcode, MetricName: &metric, StartsAt: start, EndsAt: start.Add(2 * time.Hour), Reason: "sensor swap"}
+	if err := db.CreateAlarmSuppression(ctx, sensor); err != nil {
+		t.Fatalf("Failed to create suppression: %v", err)
+	}
+	global := &AlarmSuppression{StartsAt: start.Add(3 * time.Hour), EndsAt: start.Add(4 * time.Hour), Reason: "upgrade"}
+	if err := db.CreateAlarmSuppression(ctx, global); err != nil {
+		t.Fatalf("Failed to create suppression: %v", err)
+	}
+	if err := db.CreateAlarmSuppression(ctx, &AlarmSuppression{StartsAt: start, EndsAt: start}); err == nil {
+		t.Error("Expected an error for a suppression ending when it starts")
+	}
+
+	for _, tt := range []struct {
+		zipcode, metric string
+		at              time.Time
+		want            int
+	}{
+		{"94105", "humidity", start.Add(time.Hour), sensor.ID},
+		{"94105", "temperature", start.Add(time.Hour), 0},
+		{"94105", "humidity", start.Add(2 * time.Hour), 0},
+		{"10001", "temperature", start.Add(3 * time.Hour), global.ID},
+	} {
+		s, err := db.GetAlarmSuppression(ctx, tt.zipcode, tt.metric, tt.at)
+		if err != nil {
+			t.Fatalf("Failed to get suppression: %v", err)
+		}
+		got := 0
+		if s != nil {
+			got = s.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s/%s at %s: expected suppression %d, got %d", tt.zipcode, tt.metric, tt.at, tt.want, got)
+		}
+	}
+
+	if upcoming, err := db.ListAlarmSuppressions(ctx, start.Add(150*time.Minute)); err != nil || len(upcoming) != 1 || upcoming[0].ID != global.ID {
+		t.Errorf("Expected only the global suppression still to come, got %v (%v)", upcoming, err)
+	}
+	if err := db.DeleteAlarmSuppression(ctx, global.ID); err != nil {
+		t.Fatalf("Failed to delete suppression: %v", err)
+	}
+	if err := db.DeleteAlarmSuppression(ctx, global.ID); err != ErrAlarmSuppressionNotFound {
+		t.Errorf("Expected ErrAlarmSuppressionNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+
+	zipcode, metric := "94105", "wind_speed"
+	local := &Subscription{Recipient: "ops@example.com", Channel: ChannelEmail, Zipcode: &zipcode, IsActive: true}
+	wind := &Subscription{Recipient: "+14155550123", Channel: ChannelSMS, MetricName: &metric, MinSeverity: SeverityCritical, IsActive: true}
+	paused := &Subscription{Recipient: "oncall@example.com", Channel: ChannelEmail, IsActive: false}
+	for _, s := range []*Subscription{local, wind, paused} {
+		if err := db.CreateSubscription(ctx, s); err != nil {
+			t.Fatalf("Failed to create subscription: %v", err)
+		}
+	}
+	if local.MinSeverity != SeverityInfo {
+		t.Errorf("Expected min severity to default to info, got %q", local.MinSeverity)
+	}
+	if err := db.CreateSubscription(ctx, &Subscription{Recipient: "ops@example.com", Channel: "pager"}); err == nil {
+		t.Error("Expected an error for an unknown channel")
+	}
+	if err := db.CreateSubscription(ctx, &Subscription{Channel: ChannelEmail}); err == nil {
+		t.Error("Expected an error for a subscription without a recipient")
+	}
+
+	for _, tt := range []struct {
+		zipcode, metric, severity string
+		want                      []int
+	}{
+		{"94105", "wind_speed", SeverityCritical, []int{wind.ID, local.ID}},
+		{"94105", "wind_speed", SeverityWarning, []int{local.ID}},
+		{"10001", "wind_speed", SeverityCritical, []int{wind.ID}},
+		{"10001", "temperature", "", nil},
+	} {
+		subscribers, err := db.GetSubscribers(ctx, tt.zipcode, tt.metric, tt.severity)
+		if err != nil {
+			t.Fatalf("Failed to get subscribers: %v", err)
+		}
+		var got []int
+		for _, s := range subscribers {
+			got = append(got, s.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s/%s at %q: expected subscriptions %v, got %v", tt.zipcode, tt.metric, tt.severity, tt.want, got)
+		}
+	}
+
+	if all, err := db.ListSubscriptions(ctx); err != nil || len(all) != 3 {
+		t.Errorf("Expected 3 subscriptions, got %d (%v)", len(all), err)
+	}
+	if err := db.DeleteSubscription(ctx, paused.ID); err != nil {
+		t.Fatalf("Failed to delete subscription: %v", err)
+	}
+	if err := db.DeleteSubscription(ctx, paused.ID); err != ErrSubscriptionNotFound {
+		t.Errorf("Expected ErrSubscriptionNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteAlarmRules(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := &AlarmRule{Zipcode: "94105", Name: "heat_advisory", Combinator: CombinatorAnd, DurationMinutes: 15, IsActive: true,
+		Conditions: []AlarmCondition{
+			{MetricName: "temperature", Operator: ">", ThresholdValue: 30},
+			{MetricName: "humidity", Operator: ">", ThresholdValue: 80},
+		}}
+	if err := db.CreateAlarmRule(ctx, rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	if err := db.CreateAlarmRule(ctx, &AlarmRule{Zipcode: "94105", Name: "one", Combinator: CombinatorOr,
+		Conditions: rule.Conditions[:1]}); err == nil {
+		t.Error("Expected an error for a rule with a single condition")
+	}
+
+	rules, err := db.GetActiveAlarmRules(ctx, "94105")
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("Expected one active rule, got %v (%v)", rules, err)
+	}
+	if len(rules[0].Conditions) != 2 || rules[0].Conditions[1].MetricName != "humidity" || rules[0].Conditions[1].ThresholdValue != 80 {
+		t.Errorf("Expected the rule's conditions in order, got %+v", rules[0].Conditions)
+	}
+
+	if err := db.DeleteAlarmRule(ctx, rule.ID); err != nil {
+		t.Fatalf("Failed to delete rule: %v", err)
+	}
+	var conditions int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM alarm_rule_conditions").Scan(&conditions); err != nil || conditions != 0 {
+		t.Errorf("Expected the rule's conditions to be deleted with it, got %d (%v)", conditions, err)
+	}
+	if err := db.DeleteAlarmRule(ctx, rule.ID); err != ErrAlarmRuleNotFound {
+		t.Errorf("Expected ErrAlarmRuleNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteAnomalyRules(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := &AnomalyRule{Zipcode: "94105", MetricName: "temperature", Deviations: 3, DurationMinutes: 15, IsActive: true}
+	if err := db.CreateAnomalyRule(ctx, rule); err != nil {
+		t.Fatalf("Failed to create anomaly rule: %v", err)
+	}
+	if err := db.CreateAnomalyRule(ctx, &AnomalyRule{Zipcode: "94105", MetricName: "wind_direction", Deviations: 3}); err == nil {
+		t.Error("Expected an error for a metric without hourly averages")
+	}
+	rules, err := db.GetActiveAnomalyRules(ctx, "94105")
+	if err != nil || len(rules) != 1 || rules[0].BaselineDays != 30 || rules[0].MinSamples != 7 {
+		t.Fatalf("Expected one rule with the default baseline, got %+v (%v)", rules, err)
+	}
+
+	// 14:00 on each of the three days before, and a 15:00 left out
+	hour := time.Date(2026, 10, 17, 14, 0, 0, 0, time.UTC)
+	var metrics []*HourlyMetric
+	for i, temp := range []float64{18, 20, 22} {
+		metrics = append(metrics, &HourlyMetric{Zipcode: "94105", HourTimestamp: hour.AddDate(0, 0, -i-1), AvgTemp: &temp})
+	}
+	other := 40.0
+	metrics = append(metrics, &HourlyMetric{Zipcode: "94105", HourTimestamp: hour.Add(-23 * time.Hour), AvgTemp: &other})
+	if err := db.UpsertHourlyMetrics(ctx, metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	baseline, err := db.GetHourlyBaseline(ctx, "94105", "temperature", hour.Add(20*time.Minute), 30)
+	if err != nil {
+		t.Fatalf("Failed to get baseline: %v", err)
+	}
+	if baseline.Samples != 3 || baseline.Mean != 20 || baseline.StdDev != 2 {
+		t.Errorf("Expected 3 samples with mean 20 and deviation 2, got %+v", baseline)
+	}
+
+	if err := db.DeleteAnomalyRule(ctx, rule.ID); err != nil {
+		t.Fatalf("Failed to delete anomaly rule: %v", err)
+	}
+	if err := db.DeleteAnomalyRule(ctx, rule.ID); err != ErrAnomalyRuleNotFound {
+		t.Errorf("Expected ErrAnomalyRuleNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteAlarmWebhooks(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "94105", CityName: "San Francisco"}); err != nil {
+		t.Fatal(err)
+	}
+	threshold := &AlarmThreshold{Zipcode: "94105", MetricName: "temperature", Operator: ">", ThresholdValue: 35, DurationMinutes: 10, IsActive: true}
+	if err := db.CreateAlarmThreshold(ctx, threshold); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "s3cret"
+	webhook := &AlarmWebhook{ThresholdID: threshold.ID, URL: "https://hooks.example.com/alarm", Secret: &secret, IsActive: true}
+	if err := db.CreateAlarmWebhook(ctx, webhook); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if err := db.CreateAlarmWebhook(ctx, &AlarmWebhook{ThresholdID: threshold.ID, URL: "ftp://example.com", IsActive: true}); err == nil {
+		t.Error("Expected an error for a non-HTTP URL")
+	}
+	webhooks, err := db.GetActiveAlarmWebhooks(ctx, threshold.ID)
+	if err != nil || len(webhooks) != 1 || webhooks[0].Secret == nil || *webhooks[0].Secret != secret || webhooks[0].PayloadTemplate != nil {
+		t.Fatalf("Expected the webhook with its secret and no template, got %+v (%v)", webhooks, err)
+	}
+
+	if err := db.DeleteAlarmWebhook(ctx, webhook.ID); err != nil {
+		t.Fatalf("Failed to delete webhook: %v", err)
+	}
+	if err := db.DeleteAlarmWebhook(ctx, webhook.ID); err != ErrAlarmWebhookNotFound {
+		t.Errorf("Expected ErrAlarmWebhookNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteWebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	alarmID := int64(12)
+	delivery := &WebhookDelivery{Endpoint: "https://hooks.example.com/alarms", NotificationType: "ALARM_TRIGGERED",
+		Zipcode: "94105", MetricName: "temperature", AlarmID: &alarmID}
+	if err := db.InsertWebhookDelivery(ctx, delivery); err != nil {
+		t.Fatalf("Failed to insert delivery: %v", err)
+	}
+	if delivery.Status != DeliveryPending {
+		t.Errorf("Expected a new delivery to be pending, got %s", delivery.Status)
+	}
+
+	code, message := 503, "unexpected status 503 Service Unavailable"
+	delivery.Status, delivery.Attempts, delivery.LastStatusCode, delivery.LastError = DeliveryFailed, 3, &code, &message
+	if err := db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		t.Fatalf("Failed to update delivery: %v", err)
+	}
+
+	failed, err := db.ListWebhookDeliveries(ctx, DeliveryFailed, 10)
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("Expected one failed delivery, got %v (%v)", failed, err)
+	}
+	if got := failed[0]; got.Attempts != 3 || got.LastStatusCode == nil || *got.LastStatusCode != 503 || *got.AlarmID != 12 {
+		t.Errorf("Expected 3 attempts ending in a 503 for alarm 12, got %+v", got)
+	}
+	if pending, err := db.ListWebhookDeliveries(ctx, DeliveryPending, 10); err != nil || len(pending) != 0 {
+		t.Errorf("Expected no pending deliveries, got %v (%v)", pending, err)
+	}
+}
+
+func TestSQLiteConsumerOffsets(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	for _, offset := range []int64{7, 5} {
+		tx, err := db.BeginWrite(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.SetConsumerOffset(ctx, "dbwriter-group", "weather.metrics", 0, offset); err != nil {
+			t.Fatalf("Failed to set offset: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.BeginWrite(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	offsets, err := tx.ConsumerOffsets(ctx, "dbwriter-group", "weather.metrics")
+	if err != nil {
+		t.Fatalf("Failed to read offsets: %v", err)
+	}
+	if offsets[0] != 7 {
+		t.Errorf("Expected the offset to stay at the highest written, got %d", offsets[0])
+	}
+}
+
+func TestSQLiteRadius(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	coords := map[string][2]float64{
+		"94105": {37.7897, -122.3942}, // San Francisco
+		"94612": {37.8085, -122.2705}, // Oakland, ~11 km
+		"90012": {34.0614, -118.2385}, // Los Angeles, ~560 km
+	}
+	for zipcode, c := range coords {
+		lat, lon := c[0], c[1]
+		if err := db.UpsertLocation(ctx, &Location{Zipcode: zipcode, CityName: zipcode, Lat: &lat, Lon: &lon}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.UpsertLocation(ctx, &Location{Zipcode: "00000", CityName: "Unknown"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	temp := 18.0
+	if err := db.InsertRawMetric(ctx, &RawMetric{Zipcode: "94612", Timestamp: now, Temperature: &temp, ReceivedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 20 miles from downtown San Francisco
+	near, err := db.GetMetricsNear(ctx, 37.7749, -122.4194, 32, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to query nearby metrics: %v", err)
+	}
+	if len(near) != 2 || near[0].Zipcode != "94105" || near[1].Zipcode != "94612" {
+		t.Fatalf("Expected San Francisco then Oakland, got %+v", near)
+	}
+	if len(near[0].Metrics) != 0 || len(near[1].Metrics) != 1 {
+		t.Errorf("Expected Oakland's reading only, got %d and %d", len(near[0].Metrics), len(near[1].Metrics))
+	}
+}
+
+func TestSQLiteHealth(t *testing.T) {
+	ctx := context.Background()
+	conn, err := sql.Open(sqliteDriverName, sqliteDSN(filepath.Join(t.TempDir(), "weather.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &DB{DB: conn, driver: DriverSQLite, health: startHealthChecks(conn, 1, time.Hour, time.Second)}
+	defer db.health.close()
+
+	if err := db.CheckHealth(ctx); err != nil {
+		t.Fatalf("Expected a healthy database, got %v", err)
+	}
+	conn.Close()
+	if err := db.CheckHealth(ctx); err == nil {
+		t.Fatal("Expected the closed database to fail its check")
+	}
+	// Once down, callers get the recorded error instead of pinging again
+	if err := db.CheckHealth(ctx); err == nil || !strings.Contains(err.Error(), "unhealthy") {
+		t.Errorf("Expected the database marked unhealthy, got %v", err)
+	}
+
+	cancelled, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := db.WaitHealthy(cancelled); err != context.DeadlineExceeded {
+		t.Errorf("Expected WaitHealthy to give up with its context, got %v", err)
+	}
+}
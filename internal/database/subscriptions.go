@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrSubscriptionNotFound is returned when deleting a subscription that
+// doesn't exist
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// subscriptionColumns are the subscriptions columns scanSubscription reads
+const subscriptionColumns = `id, recipient, channel, zipcode, metric_name, min_severity, is_active, created_at`
+
+// scanSubscription reads a row of subscriptionColumns
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	var s Subscription
+	if err := row.Scan(&s.ID, &s.Recipient, &s.Channel, &s.Zipcode, &s.MetricName, &s.MinSeverity, &s.IsActive, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateSubscription inserts a subscription, setting its ID and created
+// timestamp. An empty MinSeverity subscribes to every severity.
+func (db *DB) CreateSubscription(ctx context.Context, s *Subscription) error {
+	if strings.TrimSpace(s.Recipient) == "" {
+		return fmt.Errorf("subscription needs a recipient")
+	}
+	if !slices.Contains(SubscriptionChannels, s.Channel) {
+		return fmt.Errorf("invalid channel %q (want one of %s)", s.Channel, strings.Join(SubscriptionChannels, ", "))
+	}
+	if s.MinSeverity == "" {
+		s.MinSeverity = SeverityInfo
+	}
+	if !slices.Contains(AlarmSeverities, s.MinSeverity) {
+		return fmt.Errorf("invalid severity %q (want one of %s)", s.MinSeverity, strings.Join(AlarmSeverities, ", "))
+	}
+
+	query := `
+		INSERT INTO subscriptions (recipient, channel, zipcode, metric_name, min_severity, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(ctx, query,
+		s.Recipient, s.Channel, s.Zipcode, s.MetricName, s.MinSeverity, s.IsActive,
+	).Scan(&s.ID, &s.CreatedAt)
+}
+
+// ListSubscriptions retrieves every subscription, by recipient
+func (db *DB) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions
+		ORDER BY recipient, channel, id
+	`
+
+	return db.querySubscriptions(ctx, query)
+}
+
+// GetSubscribers retrieves the active subscriptions to a zipcode's metric
+// at a severity, by recipient. An unknown severity counts as warning, the
+// default of thresholds.
+func (db *DB) GetSubscribers(ctx context.Context, zipcode, metric, severity string) ([]*Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions
+		WHERE is_active = true
+		  AND (zipcode IS NULL OR zipcode = $1)
+		  AND (metric_name IS NULL OR metric_name = $2)
+		ORDER BY recipient, channel, id
+	`
+
+	subscriptions, err := db.querySubscriptions(ctx, query, zipcode, metric)
+	if err != nil {
+		return nil, err
+	}
+
+	rank := slices.Index(AlarmSeverities, severity)
+	if rank < 0 {
+		rank = slices.Index(AlarmSeverities, SeverityWarning)
+	}
+	return slices.DeleteFunc(subscriptions, func(s *Subscription) bool {
+		return slices.Index(AlarmSeverities, s.MinSeverity) > rank
+	}), nil
+}
+
+// querySubscriptions runs a query selecting subscriptionColumns
+func (db *DB) querySubscriptions(ctx context.Context, query string, args ...any) ([]*Subscription, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []*Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// DeleteSubscription deletes a subscription
+func (db *DB) DeleteSubscription(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM subscriptions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
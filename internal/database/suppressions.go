@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAlarmSuppressionNotFound is returned when deleting a suppression
+// window that doesn't exist
+var ErrAlarmSuppressionNotFound = errors.New("alarm suppression not found")
+
+// alarmSuppressionColumns are the alarm_suppressions columns
+// scanAlarmSuppression reads
+const alarmSuppressionColumns = `id, zipcode, metric_name, starts_at, ends_at, reason, created_at`
+
+// scanAlarmSuppression reads a row of alarmSuppressionColumns
+func scanAlarmSuppression(row rowScanner) (*AlarmSuppression, error) {
+	var s AlarmSuppression
+	if err := row.Scan(&s.ID, &s.Zipcode, &s.MetricName, &s.StartsAt, &s.EndsAt, &s.Reason, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateAlarmSuppression inserts a suppression window, setting its ID and
+// created timestamp
+func (db *DB) CreateAlarmSuppression(ctx context.Context, s *AlarmSuppression) error {
+	if !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("suppression must end after it starts")
+	}
+
+	query := `
+		INSERT INTO alarm_suppressions (zipcode, metric_name, starts_at, ends_at, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(ctx, query,
+		s.Zipcode, s.MetricName, s.StartsAt, s.EndsAt, s.Reason,
+	).Scan(&s.ID, &s.CreatedAt)
+}
+
+// ListAlarmSuppressions retrieves the suppression windows that haven't
+// ended by after, in start order
+func (db *DB) ListAlarmSuppressions(ctx context.Context, after time.Time) ([]*AlarmSuppression, error) {
+	query := `
+		SELECT ` + alarmSuppressionColumns + `
+		FROM alarm_suppressions
+		WHERE ends_at > $1
+		ORDER BY starts_at, id
+	`
+
+	rows, err := db.QueryContext(ctx, query, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suppressions []*AlarmSuppression
+	for rows.Next() {
+		s, err := scanAlarmSuppression(rows)
+		if err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, s)
+	}
+	return suppressions, rows.Err()
+}
+
+// GetAlarmSuppression retrieves a suppression window covering at that
+// matches a zipcode's metric, or nil if none does
+func (db *DB) GetAlarmSuppression(ctx context.Context, zipcode, metric string, at time.Time) (*AlarmSuppression, error) {
+	query := `
+		SELECT ` + alarmSuppressionColumns + `
+		FROM alarm_suppressions
+		WHERE starts_at <= $3 AND ends_at > $3
+		  AND (zipcode IS NULL OR zipcode = $1)
+		  AND (metric_name IS NULL OR metric_name = $2)
+		ORDER BY ends_at DESC
+		LIMIT 1
+	`
+
+	s, err := scanAlarmSuppression(db.QueryRowContext(ctx, query, zipcode, metric, at))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteAlarmSuppression deletes a suppression window, ending it early
+func (db *DB) DeleteAlarmSuppression(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM alarm_suppressions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlarmSuppressionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrAlarmWebhookNotFound is returned when deleting an alarm webhook that
+// doesn't exist
+var ErrAlarmWebhookNotFound = errors.New("alarm webhook not found")
+
+// GetActiveAlarmWebhooks retrieves the active webhooks of a threshold
+func (db *DB) GetActiveAlarmWebhooks(ctx context.Context, thresholdID int) ([]*AlarmWebhook, error) {
+	query := `
+		SELECT id, threshold_id, url, payload_template, secret, is_active, created_at
+		FROM alarm_webhooks
+		WHERE threshold_id = $1 AND is_active = true
+		ORDER BY id
+	`
+
+	rows, err := db.QueryContext(ctx, query, thresholdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*AlarmWebhook
+	for rows.Next() {
+		w := &AlarmWebhook{}
+		if err := rows.Scan(&w.ID, &w.ThresholdID, &w.URL, &w.PayloadTemplate, &w.Secret, &w.IsActive, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// CreateAlarmWebhook inserts a webhook on a threshold, setting its ID and
+// created timestamp
+func (db *DB) CreateAlarmWebhook(ctx context.Context, w *AlarmWebhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q (want an http or https URL)", w.URL)
+	}
+
+	query := `
+		INSERT INTO alarm_webhooks (threshold_id, url, payload_template, secret, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(ctx, query,
+		w.ThresholdID, w.URL, w.PayloadTemplate, w.Secret, w.IsActive,
+	).Scan(&w.ID, &w.CreatedAt)
+}
+
+// DeleteAlarmWebhook deletes an alarm webhook
+func (db *DB) DeleteAlarmWebhook(ctx context.Context, id int) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM alarm_webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlarmWebhookNotFound
+	}
+	return nil
+}
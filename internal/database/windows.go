@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// GetWindowedMetrics returns a page of a location's aggregates over
+// windows of the given length starting in [from, to), in window order,
+// like GetHourlyMetrics. A limit of zero or less returns every window
+// after offset.
+func (db *DB) GetWindowedMetrics(ctx context.Context, zipcode string, window time.Duration, from, to time.Time, limit, offset int) ([]*WindowedMetric, error) {
+	query := `
+		SELECT id, zipcode, window_start, window_end,
+			` + aggregateColumns("avg_") + `,
+			` + aggregateColumns(statsPrefixes...) + `, mode_wind_direction,
+			sample_count, created_at
+		FROM windowed_metrics
+		WHERE zipcode = $1 AND window_seconds = $2 AND window_start >= $3 AND window_start < $4
+		ORDER BY window_start
+		LIMIT $5 OFFSET $6
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, zipcode, int64(window.Seconds()), from, to, db.driver.pageLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []*WindowedMetric
+	for rows.Next() {
+		m := &WindowedMetric{Window: window}
+		dest := []any{&m.ID, &m.Zipcode, &m.HourTimestamp, &m.WindowEnd}
+		for _, avg := range m.averages() {
+			dest = append(dest, avg)
+		}
+		for _, stats := range m.stats() {
+			dest = append(dest, &stats.Min, &stats.Max, &stats.P50, &stats.P95, &stats.StdDev)
+		}
+		dest = append(dest, &m.ModeWindDirection, &m.SampleCount, &m.CreatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
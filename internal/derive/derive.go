@@ -0,0 +1,85 @@
+// Package derive computes metrics derived from a station's native
+// measurements, so they can be stored, aggregated and alarmed on like the
+// measurements themselves. All inputs and outputs are in canonical metric
+// units (°C, %, km/h).
+package derive
+
+import (
+	"math"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// Apply computes the derived metrics for a message's reading and attaches
+// them, replacing any set before. A derived metric is left nil when one of
+// its inputs is missing or it isn't defined for the conditions.
+func Apply(msg *protocol.MetricMessage) {
+	data := &msg.Data
+	derived := protocol.DerivedMetrics{
+		HeatIndex: HeatIndex(data.Temperature, data.Humidity),
+		WindChill: WindChill(data.Temperature, data.WindSpeed),
+		DewPoint:  DewPoint(data.Temperature, data.Humidity),
+	}
+	if derived.HeatIndex == nil && derived.WindChill == nil && derived.DewPoint == nil {
+		msg.Derived = nil
+		return
+	}
+	msg.Derived = &derived
+}
+
+// HeatIndex returns the NWS heat index in °C. It is only defined from 27 °C
+// (80 °F); below that the temperature itself is what people feel.
+func HeatIndex(temperature, humidity *float64) *float64 {
+	if temperature == nil || humidity == nil {
+		return nil
+	}
+	t := *temperature*9/5 + 32 // the regression works in °F
+	rh := *humidity
+	if t < 80 {
+		return nil
+	}
+
+	// Rothfusz regression with the NWS adjustments
+	hi := -42.379 + 2.04901523*t + 10.14333127*rh -
+		0.22475541*t*rh - 0.00683783*t*t - 0.05481717*rh*rh +
+		0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+	switch {
+	case rh < 13 && t <= 112:
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	case rh > 85 && t <= 87:
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+
+	c := (hi - 32) * 5 / 9
+	return &c
+}
+
+// WindChill returns the wind chill index in °C (the formula used by
+// Environment Canada and the NWS). It is only defined at or below 10 °C with
+// wind above 4.8 km/h.
+func WindChill(temperature, windSpeed *float64) *float64 {
+	if temperature == nil || windSpeed == nil {
+		return nil
+	}
+	t, v := *temperature, *windSpeed
+	if t > 10 || v <= 4.8 {
+		return nil
+	}
+
+	v16 := math.Pow(v, 0.16)
+	wc := 13.12 + 0.6215*t - 11.37*v16 + 0.3965*t*v16
+	return &wc
+}
+
+// DewPoint returns the dew point in °C using the Magnus formula
+func DewPoint(temperature, humidity *float64) *float64 {
+	if temperature == nil || humidity == nil || *humidity <= 0 {
+		return nil
+	}
+	const a, b = 17.62, 243.12
+	t := *temperature
+
+	gamma := math.Log(*humidity/100) + a*t/(b+t)
+	dp := b * gamma / (a - gamma)
+	return &dp
+}
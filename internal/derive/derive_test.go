@@ -0,0 +1,57 @@
+package derive
+
+import (
+	"math"
+	"testing"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+func f(v float64) *float64 { return &v }
+
+func near(got *float64, want, tolerance float64) bool {
+	return got != nil && math.Abs(*got-want) <= tolerance
+}
+
+func TestHeatIndex(t *testing.T) {
+	// NWS table: 90 °F at 70% humidity feels like 106 °F (41.1 °C)
+	if hi := HeatIndex(f(32.22), f(70)); !near(hi, 41.1, 0.5) {
+		t.Errorf("Expected heat index near 41.1, got %v", hi)
+	}
+	if hi := HeatIndex(f(20), f(70)); hi != nil {
+		t.Errorf("Heat index should be undefined at 20 °C, got %v", *hi)
+	}
+}
+
+func TestWindChill(t *testing.T) {
+	// Environment Canada table: -10 °C with 20 km/h wind feels like -18 °C
+	if wc := WindChill(f(-10), f(20)); !near(wc, -17.9, 0.2) {
+		t.Errorf("Expected wind chill near -17.9, got %v", wc)
+	}
+	if wc := WindChill(f(15), f(20)); wc != nil {
+		t.Errorf("Wind chill should be undefined above 10 °C, got %v", *wc)
+	}
+}
+
+func TestDewPoint(t *testing.T) {
+	if dp := DewPoint(f(20), f(50)); !near(dp, 9.3, 0.1) {
+		t.Errorf("Expected dew point near 9.3, got %v", dp)
+	}
+}
+
+func TestApply(t *testing.T) {
+	msg := &protocol.MetricMessage{Data: protocol.MetricData{Temperature: f(20), Humidity: f(50)}}
+	Apply(msg)
+	if msg.Derived == nil || msg.Derived.DewPoint == nil || msg.Derived.HeatIndex != nil {
+		t.Fatalf("Unexpected derived metrics: %+v", msg.Derived)
+	}
+	if v := msg.Value("dew_point"); !near(v, 9.3, 0.1) {
+		t.Errorf("Derived metric not available by name, got %v", v)
+	}
+
+	msg = &protocol.MetricMessage{Data: protocol.MetricData{PollenIndex: f(3)}}
+	Apply(msg)
+	if msg.Derived != nil {
+		t.Errorf("Expected no derived metrics without inputs, got %+v", msg.Derived)
+	}
+}
@@ -0,0 +1,56 @@
+// Package httputil holds what the services' HTTP APIs share: API key
+// authentication and JSON responses in the protocol's error format.
+package httputil
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// APIKeys are the keys requests may carry
+type APIKeys [][]byte
+
+// NewAPIKeys creates the set of keys requests may carry
+func NewAPIKeys(keys []string) APIKeys {
+	apiKeys := make(APIKeys, 0, len(keys))
+	for _, key := range keys {
+		apiKeys = append(apiKeys, []byte(key))
+	}
+	return apiKeys
+}
+
+// Authorized checks the X-API-Key header (or an Authorization: Bearer token)
+// against the keys in constant time
+func (k APIKeys) Authorized(r *http.Request) bool {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if key == "" {
+		return false
+	}
+
+	ok := false
+	for _, valid := range k {
+		if subtle.ConstantTimeCompare([]byte(key), valid) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// WriteError writes a protocol error message with the given status
+func WriteError(w http.ResponseWriter, status int, code protocol.ErrorCode, detail string) {
+	WriteJSON(w, status, protocol.NewErrorMessage(code, detail))
+}
+
+// WriteJSON writes msg as JSON with the given status
+func WriteJSON(w http.ResponseWriter, status int, msg interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(msg)
+}
@@ -0,0 +1,112 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/smukkama/weather-server/internal/httputil"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// HTTPHandler accepts one metrics reading per POST and publishes it to the
+// same Kafka topic the TCP server uses
+type HTTPHandler struct {
+	producer     queue.Producer
+	quarantine   *queue.Quarantine
+	apiKeys      httputil.APIKeys
+	maxBodyBytes int64
+	instanceID   string
+}
+
+// NewHTTPHandler creates a handler accepting requests carrying one of apiKeys
+func NewHTTPHandler(producer queue.Producer, apiKeys []string, maxBodyBytes int64) *HTTPHandler {
+	return &HTTPHandler{
+		producer:     producer,
+		apiKeys:      httputil.NewAPIKeys(apiKeys),
+		maxBodyBytes: maxBodyBytes,
+	}
+}
+
+// SetQuarantine enables physical range validation; out-of-range readings
+// are rejected with 422 and published to the quarantine topic
+func (h *HTTPHandler) SetQuarantine(quarantine *queue.Quarantine) {
+	h.quarantine = quarantine
+}
+
+// SetInstanceID sets the server-instance header on published readings
+func (h *HTTPHandler) SetInstanceID(instanceID string) {
+	h.instanceID = instanceID
+}
+
+// publishContext returns the context to publish msg with, carrying the
+// same provenance headers as the TCP server's
+func (h *HTTPHandler) publishContext(r *http.Request, msg *protocol.MetricMessage) context.Context {
+	return queue.WithHeaders(r.Context(), queue.Headers{
+		queue.HeaderConnectionID:  msg.ConnectionID,
+		queue.HeaderInstance:      h.instanceID,
+		queue.HeaderTraceID:       queue.NewTraceID(),
+		queue.HeaderSchemaVersion: strconv.Itoa(protocol.MetricMessageSchemaVersion),
+	})
+}
+
+// ServeHTTP implements http.Handler
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httputil.WriteError(w, http.StatusMethodNotAllowed, protocol.ErrCodeInvalidMessage, "only POST is supported")
+		return
+	}
+
+	if !h.apiKeys.Authorized(r) {
+		httputil.WriteError(w, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "missing or invalid API key")
+		return
+	}
+
+	var reading Reading
+	body := http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+	if err := json.NewDecoder(body).Decode(&reading); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httputil.WriteError(w, http.StatusRequestEntityTooLarge, protocol.ErrCodeInvalidMessage, err.Error())
+			return
+		}
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidJSON, fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+
+	if err := reading.validate(); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, protocol.ErrCodeInvalidMessage, err.Error())
+		return
+	}
+
+	metricMsg := reading.toMetricMessage("http")
+	if err := h.quarantine.Divert(r.Context(), metricMsg); err != nil {
+		httputil.WriteError(w, http.StatusUnprocessableEntity, protocol.ErrCodeOutOfRange, err.Error())
+		return
+	}
+
+	data, err := protocol.EncodeMetricMessage(metricMsg)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, protocol.ErrCodeInternal, "failed to encode metric")
+		return
+	}
+
+	// Key is zipcode for partitioning, matching the TCP server
+	ctx, spool := queue.WithSpoolReport(h.publishContext(r, metricMsg))
+	if err := h.producer.Publish(ctx, reading.Zipcode, data); err != nil {
+		fmt.Printf("Failed to publish HTTP reading for zipcode %s: %v\n", reading.Zipcode, err)
+		httputil.WriteError(w, http.StatusBadGateway, protocol.ErrCodePublishFailed, "failed to publish metric")
+		return
+	}
+
+	status := protocol.AckStatusPersisted
+	if h.producer.IsAsync() || spool.Spooled {
+		status = protocol.AckStatusAccepted
+	}
+	httputil.WriteJSON(w, http.StatusAccepted, protocol.NewAckMessage(status))
+}
@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// recordingProducer keeps the key and headers of each message published
+type recordingProducer struct {
+	keys    []string
+	headers []queue.Headers
+}
+
+func (p *recordingProducer) Publish(ctx context.Context, key string, value []byte) error {
+	p.keys = append(p.keys, key)
+	p.headers = append(p.headers, queue.HeadersFrom(ctx))
+	return nil
+}
+
+func (p *recordingProducer) IsAsync() bool              { return false }
+func (p *recordingProducer) Stats() queue.ProducerStats { return queue.ProducerStats{} }
+func (p *recordingProducer) Close() error               { return nil }
+
+func TestHTTPHandler(t *testing.T) {
+	valid := `{"zipcode":"10001","city":"New York","station_id":"roof-1","data":{"timestamp":"2024-01-15T10:30:00Z","temperature":22.5}}`
+	tests := []struct {
+		name       string
+		apiKey     string
+		body       string
+		wantStatus int
+		wantCode   protocol.ErrorCode
+	}{
+		{"valid reading", "secret", valid, http.StatusAccepted, ""},
+		{"missing API key", "", valid, http.StatusUnauthorized, protocol.ErrCodeUnauthorized},
+		{"wrong API key", "guess", valid, http.StatusUnauthorized, protocol.ErrCodeUnauthorized},
+		{"malformed body", "secret", `{"zipcode":`, http.StatusBadRequest, protocol.ErrCodeInvalidJSON},
+		{"invalid reading", "secret", `{"zipcode":"10001","city":"New York","data":{}}`, http.StatusBadRequest, protocol.ErrCodeInvalidMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &recordingProducer{}
+			handler := NewHTTPHandler(producer, []string{"secret"}, 64*1024)
+			handler.SetInstanceID("ingest-1")
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(tt.body))
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				var errMsg protocol.ErrorMessage
+				if err := json.NewDecoder(rec.Body).Decode(&errMsg); err != nil || errMsg.Code != tt.wantCode {
+					t.Errorf("Expected error code %q, got %+v (%v)", tt.wantCode, errMsg, err)
+				}
+				if len(producer.keys) != 0 {
+					t.Errorf("Expected nothing published, got %v", producer.keys)
+				}
+				return
+			}
+
+			var ack protocol.AckMessage
+			if err := json.NewDecoder(rec.Body).Decode(&ack); err != nil || ack.Status != protocol.AckStatusPersisted {
+				t.Errorf("Expected a persisted ack, got %+v (%v)", ack, err)
+			}
+			if len(producer.keys) != 1 || producer.keys[0] != "10001" {
+				t.Fatalf("Expected one reading keyed by zipcode, got %v", producer.keys)
+			}
+			headers := producer.headers[0]
+			if headers[queue.HeaderConnectionID] != "http:10001/roof-1" || headers[queue.HeaderInstance] != "ingest-1" ||
+				headers[queue.HeaderTraceID] == "" || headers[queue.HeaderSchemaVersion] == "" {
+				t.Errorf("Expected provenance headers, got %v", headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// MQTTConfig holds the broker connection and subscription settings
+type MQTTConfig struct {
+	Broker   string // e.g. tcp://localhost:1883
+	ClientID string
+	Username string
+	Password string
+	Topics   []string // subscription filters, e.g. weather/+/metrics
+	QoS      byte
+}
+
+// MQTTBridge subscribes to MQTT topics and republishes readings into Kafka
+type MQTTBridge struct {
+	config     *MQTTConfig
+	producer   queue.Producer
+	quarantine *queue.Quarantine
+	client     mqtt.Client
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewMQTTBridge creates a new MQTT to Kafka bridge
+func NewMQTTBridge(cfg *MQTTConfig, producer queue.Producer) *MQTTBridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &MQTTBridge{
+		config:   cfg,
+		producer: producer,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetCleanSession(false).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			fmt.Printf("MQTT connection lost: %v\n", err)
+		})
+	b.client = mqtt.NewClient(opts)
+
+	return b
+}
+
+// Start connects to the broker; subscriptions are (re)made on every connect
+func (b *MQTTBridge) Start() error {
+	token := b.client.Connect()
+	if !token.WaitTimeout(30 * time.Second) {
+		return fmt.Errorf("timed out connecting to MQTT broker %s", b.config.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	return nil
+}
+
+// Stop disconnects from the broker
+func (b *MQTTBridge) Stop() {
+	b.cancel()
+	b.client.Disconnect(250)
+	fmt.Println("MQTT bridge stopped")
+}
+
+func (b *MQTTBridge) onConnect(client mqtt.Client) {
+	fmt.Printf("Connected to MQTT broker %s\n", b.config.Broker)
+
+	for _, filter := range b.config.Topics {
+		filter := filter
+		token := client.Subscribe(filter, b.config.QoS, func(_ mqtt.Client, msg mqtt.Message) {
+			b.handleMessage(filter, msg)
+		})
+		if token.Wait() && token.Error() != nil {
+			fmt.Printf("Failed to subscribe to %s: %v\n", filter, token.Error())
+			continue
+		}
+		fmt.Printf("Subscribed to %s (qos=%d)\n", filter, b.config.QoS)
+	}
+}
+
+// SetQuarantine enables physical range validation; out-of-range readings
+// are published to the quarantine topic instead
+func (b *MQTTBridge) SetQuarantine(quarantine *queue.Quarantine) {
+	b.quarantine = quarantine
+}
+
+// handleMessage maps an MQTT payload to a MetricMessage and publishes it.
+// The payload is a Reading; a missing zipcode is taken from the topic level
+// matched by the first '+' wildcard of the subscription filter.
+func (b *MQTTBridge) handleMessage(filter string, msg mqtt.Message) {
+	var reading Reading
+	if err := json.Unmarshal(msg.Payload(), &reading); err != nil {
+		fmt.Printf("Dropping MQTT message on %s: invalid JSON: %v\n", msg.Topic(), err)
+		return
+	}
+	if reading.Zipcode == "" {
+		reading.Zipcode = wildcardLevel(filter, msg.Topic())
+	}
+	if err := reading.validate(); err != nil {
+		fmt.Printf("Dropping MQTT message on %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	metricMsg := reading.toMetricMessage("mqtt")
+	if err := b.quarantine.Divert(b.ctx, metricMsg); err != nil {
+		fmt.Printf("Quarantined MQTT message on %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	data, err := protocol.EncodeMetricMessage(metricMsg)
+	if err != nil {
+		fmt.Printf("Failed to encode MQTT reading: %v\n", err)
+		return
+	}
+
+	// Key is zipcode for partitioning, matching the TCP server
+	if err := b.producer.Publish(b.ctx, reading.Zipcode, data); err != nil {
+		fmt.Printf("Failed to publish MQTT reading for zipcode %s: %v\n", reading.Zipcode, err)
+	}
+}
+
+// wildcardLevel returns the topic level matched by the first '+' in filter,
+// or "" if filter has no '+' or the topic is too short
+func wildcardLevel(filter, topic string) string {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "+" && i < len(topicLevels) {
+			return topicLevels[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,21 @@
+package ingest
+
+import "testing"
+
+func TestWildcardLevel(t *testing.T) {
+	tests := []struct {
+		filter, topic, want string
+	}{
+		{"weather/+/metrics", "weather/10001/metrics", "10001"},
+		{"stations/+/+/metrics", "stations/10001/roof-1/metrics", "10001"},
+		{"weather/#", "weather/10001/metrics", ""},
+		{"weather/metrics", "weather/metrics", ""},
+		{"weather/+", "weather", ""},
+	}
+
+	for _, tt := range tests {
+		if got := wildcardLevel(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("wildcardLevel(%q, %q) = %q, want %q", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
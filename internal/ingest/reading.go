@@ -0,0 +1,64 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/derive"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// Reading is a single self-describing metrics reading. Connectionless
+// transports (HTTP, MQTT) carry the station identity with every reading
+// instead of in an identify handshake.
+type Reading struct {
+	Zipcode   string                    `json:"zipcode"`
+	City      string                    `json:"city"`
+	StationID string                    `json:"station_id,omitempty"`
+	Station   *protocol.StationMetadata `json:"station,omitempty"`
+	Units     protocol.UnitSystem       `json:"units,omitempty"` // defaults to metric
+	Data      protocol.MetricData       `json:"data"`
+}
+
+func (r *Reading) validate() error {
+	if r.Zipcode == "" {
+		return fmt.Errorf("zipcode is required")
+	}
+	if r.City == "" {
+		return fmt.Errorf("city is required")
+	}
+	units, err := protocol.ParseUnitSystem(string(r.Units))
+	if err != nil {
+		return err
+	}
+	r.Units = units
+	if r.Station != nil {
+		if err := r.Station.Validate(); err != nil {
+			return err
+		}
+	}
+	return r.Data.Validate()
+}
+
+// toMetricMessage converts the reading into the Kafka message format. There
+// is no real connection to name, so the connection ID records the transport
+// and station instead (e.g. "http:10001/roof-1").
+func (r *Reading) toMetricMessage(transport string) *protocol.MetricMessage {
+	connectionID := fmt.Sprintf("%s:%s", transport, r.Zipcode)
+	if r.StationID != "" {
+		connectionID = fmt.Sprintf("%s/%s", connectionID, r.StationID)
+	}
+
+	msg := &protocol.MetricMessage{
+		ConnectionID: connectionID,
+		Zipcode:      r.Zipcode,
+		City:         r.City,
+		StationID:    r.StationID,
+		Station:      r.Station,
+		ReceivedAt:   time.Now(),
+		Data:         r.Data,
+	}
+	msg.Data.Normalize(r.Units)
+	derive.Apply(msg)
+	return msg
+}
@@ -0,0 +1,180 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+)
+
+// maxDatagramSize bounds a single UDP reading
+const maxDatagramSize = 8 * 1024
+
+// UDPListener accepts signed, self-contained readings from sensors that
+// cannot hold a TCP connection. Each datagram is a Reading as JSON, a
+// newline, and the hex HMAC-SHA256 of the JSON bytes under a shared secret:
+//
+//	{"zipcode":"10001","city":"New York","data":{...}}\n9f86d08...
+//
+// Readings whose timestamp is further than maxSkew from now are dropped to
+// limit replays. Nothing is sent back.
+type UDPListener struct {
+	addr       string
+	secrets    [][]byte
+	maxSkew    time.Duration
+	producer   queue.Producer
+	quarantine *queue.Quarantine
+
+	conn   *net.UDPConn
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewUDPListener creates a UDP listener accepting datagrams signed with any
+// of secrets (several may be configured to rotate keys)
+func NewUDPListener(addr string, secrets []string, maxSkew time.Duration, producer queue.Producer) *UDPListener {
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, []byte(secret))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &UDPListener{
+		addr:     addr,
+		secrets:  keys,
+		maxSkew:  maxSkew,
+		producer: producer,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// SetQuarantine enables physical range validation; out-of-range readings
+// are published to the quarantine topic instead
+func (l *UDPListener) SetQuarantine(quarantine *queue.Quarantine) {
+	l.quarantine = quarantine
+}
+
+// Start binds the UDP socket and starts reading datagrams
+func (l *UDPListener) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", l.addr)
+	if err != nil {
+		return fmt.Errorf("invalid UDP address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start UDP listener: %w", err)
+	}
+	l.conn = conn
+	fmt.Printf("UDP ingest listening on %s\n", l.addr)
+
+	l.wg.Add(1)
+	go l.readLoop()
+	return nil
+}
+
+// Stop closes the socket and waits for the read loop to exit
+func (l *UDPListener) Stop() {
+	l.cancel()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.wg.Wait()
+	fmt.Println("UDP ingest stopped")
+}
+
+func (l *UDPListener) readLoop() {
+	defer l.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("UDP read error: %v\n", err)
+			continue
+		}
+
+		reading, err := l.verify(buf[:n], time.Now())
+		if err != nil {
+			fmt.Printf("Dropping UDP datagram from %s: %v\n", from, err)
+			continue
+		}
+
+		metricMsg := reading.toMetricMessage("udp")
+		if err := l.quarantine.Divert(l.ctx, metricMsg); err != nil {
+			fmt.Printf("Quarantined UDP datagram from %s: %v\n", from, err)
+			continue
+		}
+
+		data, err := protocol.EncodeMetricMessage(metricMsg)
+		if err != nil {
+			fmt.Printf("Failed to encode UDP reading: %v\n", err)
+			continue
+		}
+
+		// Key is zipcode for partitioning, matching the TCP server
+		if err := l.producer.Publish(l.ctx, reading.Zipcode, data); err != nil {
+			fmt.Printf("Failed to publish UDP reading for zipcode %s: %v\n", reading.Zipcode, err)
+		}
+	}
+}
+
+// verify checks the datagram's signature and freshness and decodes it
+func (l *UDPListener) verify(datagram []byte, now time.Time) (*Reading, error) {
+	idx := bytes.LastIndexByte(datagram, '\n')
+	if idx < 0 {
+		return nil, fmt.Errorf("missing signature")
+	}
+	payload := datagram[:idx]
+	sig, err := hex.DecodeString(string(bytes.TrimSpace(datagram[idx+1:])))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	if !l.validSignature(payload, sig) {
+		return nil, fmt.Errorf("bad signature")
+	}
+
+	var reading Reading
+	if err := json.Unmarshal(payload, &reading); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := reading.validate(); err != nil {
+		return nil, err
+	}
+
+	if l.maxSkew > 0 {
+		ts, _ := time.Parse(time.RFC3339, reading.Data.Timestamp) // checked by validate
+		if skew := now.Sub(ts); skew > l.maxSkew || skew < -l.maxSkew {
+			return nil, fmt.Errorf("timestamp %s outside allowed skew of %s", reading.Data.Timestamp, l.maxSkew)
+		}
+	}
+
+	return &reading, nil
+}
+
+func (l *UDPListener) validSignature(payload, sig []byte) bool {
+	for _, secret := range l.secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		if hmac.Equal(mac.Sum(nil), sig) {
+			return true
+		}
+	}
+	return false
+}
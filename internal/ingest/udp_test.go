@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func sign(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return []byte(payload + "\n" + hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestUDPListener_Verify(t *testing.T) {
+	l := NewUDPListener(":0", []string{"old-secret", "new-secret"}, 5*time.Minute, nil)
+	now := time.Date(2024, 1, 15, 10, 32, 0, 0, time.UTC)
+	payload := `{"zipcode":"10001","city":"New York","data":{"timestamp":"2024-01-15T10:30:00Z","temperature":22.5}}`
+
+	reading, err := l.verify(sign("new-secret", payload), now)
+	if err != nil {
+		t.Fatalf("Expected valid datagram, got %v", err)
+	}
+	if reading.Zipcode != "10001" || reading.Data.Temperature == nil || *reading.Data.Temperature != 22.5 {
+		t.Errorf("Unexpected reading: %+v", reading)
+	}
+
+	// Any configured secret is accepted
+	if _, err := l.verify(sign("old-secret", payload), now); err != nil {
+		t.Errorf("Expected rotated secret to verify, got %v", err)
+	}
+
+	if _, err := l.verify(sign("wrong", payload), now); err == nil {
+		t.Error("Expected bad signature to be rejected")
+	}
+
+	if _, err := l.verify([]byte(payload), now); err == nil {
+		t.Error("Expected unsigned datagram to be rejected")
+	}
+
+	// Replayed an hour later
+	if _, err := l.verify(sign("new-secret", payload), now.Add(time.Hour)); err == nil {
+		t.Error("Expected stale reading to be rejected")
+	}
+}
@@ -0,0 +1,139 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const leaderKeyPrefix = "weather:leader:"
+
+// renew extends the leader key's TTL only while it still names this
+// instance, so an instance whose lease lapsed never extends another's
+var renew = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// release deletes the leader key only while it still names this instance
+var release = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Elector elects one of a service's instances as its leader through a
+// Redis key naming the leader. The leader renews the key's TTL every third
+// of it; when it can't, it steps down, and once the key expires another
+// instance takes over. Only the leader runs the service's work, so several
+// instances can run for availability without doing it twice.
+type Elector struct {
+	redis    *redis.Client
+	key      string
+	instance string
+	ttl      time.Duration
+}
+
+// NewElector creates an elector for one instance of service, e.g.
+// aggregator
+func NewElector(client *redis.Client, service, instance string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+	return &Elector{
+		redis:    client,
+		key:      leaderKeyPrefix + service,
+		instance: instance,
+		ttl:      ttl,
+	}
+}
+
+// Lead waits to be elected and runs work while this instance leads, with a
+// context cancelled as soon as it no longer does. Once work returns it
+// steps down and campaigns again, until ctx is done.
+func (e *Elector) Lead(ctx context.Context, work func(ctx context.Context)) {
+	for e.campaign(ctx) {
+		fmt.Printf("Elected leader of %s (instance=%s)\n", e.key, e.instance)
+		leaderCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			work(leaderCtx)
+		}()
+		e.hold(leaderCtx, cancel, done)
+		<-done
+		cancel()
+		e.stepDown()
+	}
+}
+
+// campaign returns true once this instance holds the leader key, trying
+// every third of the TTL, or false if ctx is done first
+func (e *Elector) campaign(ctx context.Context) bool {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	waiting := false
+	for {
+		elected, err := e.redis.SetNX(ctx, e.key, e.instance, e.ttl).Result()
+		switch {
+		case elected:
+			return true
+		case err != nil && ctx.Err() == nil:
+			fmt.Printf("Leader election for %s failed: %v\n", e.key, err)
+		case !waiting:
+			fmt.Printf("Standing by: another instance leads %s\n", e.key)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// hold renews the leader key until work is done, cancelling work if a
+// renewal fails: the lease may lapse before Redis is reachable again, and
+// another instance may take over then
+func (e *Elector) hold(ctx context.Context, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		renewed, err := renew.Run(ctx, e.redis, []string{e.key}, e.instance, e.ttl.Milliseconds()).Int()
+		if err == nil && renewed == 1 {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Printf("Stepping down as leader of %s: failed to renew: %v\n", e.key, err)
+		} else {
+			fmt.Printf("Stepping down as leader of %s: another instance took over\n", e.key)
+		}
+		cancel()
+		return
+	}
+}
+
+// stepDown gives up the leader key, if still held, so another instance
+// can take over without waiting for it to expire
+func (e *Elector) stepDown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := release.Run(ctx, e.redis, []string{e.key}, e.instance).Err(); err != nil {
+		fmt.Printf("Failed to release leadership of %s: %v\n", e.key, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/smtp"
+	"strings"
 	"time"
 
 	"github.com/smukkama/weather-server/internal/protocol"
@@ -21,8 +22,14 @@ func NewEmailNotifier(cfg *config.SMTPConfig) *EmailNotifier {
 	return &EmailNotifier{config: cfg}
 }
 
-// SendAlarmNotification sends an email for an alarm notification
+// SendAlarmNotification sends an email for an alarm notification to
+// SMTP_TO
 func (e *EmailNotifier) SendAlarmNotification(notification *protocol.AlarmNotification) error {
+	return e.SendTo([]string{e.config.To}, notification)
+}
+
+// SendTo sends an email for an alarm notification to recipients
+func (e *EmailNotifier) SendTo(recipients []string, notification *protocol.AlarmNotification) error {
 	var subject string
 	var body string
 	var err error
@@ -34,15 +41,37 @@ func (e *EmailNotifier) SendAlarmNotification(notification *protocol.AlarmNotifi
 	case protocol.AlarmTypeCleared:
 		subject = fmt.Sprintf("✅ Weather Alarm CLEARED - %s, %s", notification.City, notification.Zipcode)
 		body, err = e.renderClearedTemplate(notification)
+	case protocol.AlarmTypeFlapping:
+		subject = fmt.Sprintf("⚠️ Weather Alarm FLAPPING - %s, %s", notification.City, notification.Zipcode)
+		body, err = e.renderFlappingTemplate(notification)
+	case protocol.AlarmTypeEscalated:
+		subject = fmt.Sprintf("🔺 Weather Alarm ESCALATED (level %d) - %s, %s", notification.EscalationLevel, notification.City, notification.Zipcode)
+		body, err = e.renderEscalatedTemplate(notification)
+	case protocol.AlarmTypeAcknowledged:
+		subject = fmt.Sprintf("👍 Weather Alarm ACKNOWLEDGED by %s - %s, %s", notification.AcknowledgedBy, notification.City, notification.Zipcode)
+		body, err = e.renderAcknowledgedTemplate(notification)
+	case protocol.AlarmTypePredicted:
+		subject = fmt.Sprintf("📈 Weather Alarm PREDICTED - %s, %s", notification.City, notification.Zipcode)
+		body, err = e.renderPredictedTemplate(notification)
 	default:
 		return fmt.Errorf("unknown notification type: %s", notification.Type)
 	}
 
+	// A composite rule's body lists its conditions instead
+	if err == nil && len(notification.Conditions) > 0 {
+		body, err = e.renderRuleTemplate(notification)
+	}
+
 	if err != nil {
 		return fmt.Errorf("failed to render email template: %w", err)
 	}
 
-	return e.sendEmail(subject, body)
+	if notification.SuppressedDuplicates > 0 {
+		body = fmt.Sprintf("\n(Suppressed %d duplicate notifications for %s at %s since the last one.)\n",
+			notification.SuppressedDuplicates, notification.Metric, notification.Zipcode) + body
+	}
+
+	return e.sendEmail(recipients, subject, body)
 }
 
 func (e *EmailNotifier) renderTriggeredTemplate(notification *protocol.AlarmNotification) (string, error) {
@@ -55,6 +84,7 @@ Metric: {{.Metric}}
 Current Value: {{.Value}}
 Threshold: {{.Operator}} {{.Threshold}}
 Duration: {{.Duration}} minutes
+Severity: {{.Severity}}
 Start Time: {{.StartTime}}
 Alarm ID: {{.AlarmID}}
 
@@ -92,7 +122,9 @@ Weather Alarm Cleared
 Location: {{.City}}, {{.Zipcode}}
 Metric: {{.Metric}}
 Alarm ID: {{.AlarmID}}
-
+{{if .AcknowledgedBy}}Acknowledged by: {{.AcknowledgedBy}} at {{.AcknowledgedAt}}
+{{else}}Acknowledged: no
+{{end}}
 Description:
 The alarm for {{.Metric}} at {{.City}} ({{.Zipcode}}) has been cleared.
 The metric has returned to normal levels.
@@ -114,7 +146,7 @@ Weather Server Notification System
 	return buf.String(), nil
 }
 
-func (e *EmailNotifier) sendEmail(subject, body string) error {
+func (e *EmailNotifier) sendEmail(recipients []string, subject, body string) error {
 	// Skip sending if SMTP is not configured
 	if e.config.Username == "" || e.config.Password == "" {
 		fmt.Printf("SMTP not configured, skipping email:\nSubject: %s\n%s\n", subject, body)
@@ -123,7 +155,7 @@ func (e *EmailNotifier) sendEmail(subject, body string) error {
 
 	// Construct message
 	message := fmt.Sprintf("From: %s\r\n", e.config.From)
-	message += fmt.Sprintf("To: %s\r\n", e.config.To)
+	message += fmt.Sprintf("To: %s\r\n", strings.Join(recipients, ", "))
 	message += fmt.Sprintf("Subject: %s\r\n", subject)
 	message += fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z))
 	message += "\r\n"
@@ -134,12 +166,12 @@ func (e *EmailNotifier) sendEmail(subject, body string) error {
 
 	// Send email
 	addr := fmt.Sprintf("%s:%d", e.config.Host, e.config.Port)
-	err := smtp.SendMail(addr, auth, e.config.From, []string{e.config.To}, []byte(message))
+	err := smtp.SendMail(addr, auth, e.config.From, recipients, []byte(message))
 	if err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
-	fmt.Printf("Email sent successfully: %s\n", subject)
+	fmt.Printf("Email sent successfully to %d recipients: %s\n", len(recipients), subject)
 	return nil
 }
 
@@ -160,3 +192,189 @@ func (e *EmailNotifier) TestConnection() error {
 	fmt.Println("SMTP connection test successful")
 	return nil
 }
+
+func (e *EmailNotifier) renderFlappingTemplate(notification *protocol.AlarmNotification) (string, error) {
+	tmpl := `
+Weather Alarm Flapping
+======================
+
+Location: {{.City}}, {{.Zipcode}}
+Metric: {{.Metric}}
+Current Value: {{.Value}}
+Threshold: {{.Operator}} {{.Threshold}}
+Start Time: {{.StartTime}}
+Alarm ID: {{.AlarmID}}
+
+Description:
+The alarm for {{.Metric}} at {{.City}} ({{.Zipcode}}) keeps triggering and
+clearing around the threshold ({{.Operator}} {{.Threshold}}). The current
+value is {{.Value}}.
+
+Further notifications for this alarm are suppressed until it triggers
+less often.
+
+---
+Weather Server Notification System
+`
+
+	t, err := template.New("flapping").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, notification); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (e *EmailNotifier) renderRuleTemplate(notification *protocol.AlarmNotification) (string, error) {
+	tmpl := `
+Weather Alarm Rule {{if eq .Type "ALARM_CLEARED"}}Cleared{{else}}Triggered{{end}}
+============================
+
+Location: {{.City}}, {{.Zipcode}}
+Rule: {{.Metric}} ({{.Combinator}} of {{len .Conditions}} conditions)
+Duration: {{.Duration}} minutes
+{{if eq .Type "ALARM_CLEARED"}}{{else}}Start Time: {{.StartTime}}
+{{end}}Alarm ID: {{.AlarmID}}
+
+Conditions:
+{{range .Conditions}}- {{.Metric}} {{.Operator}} {{.Threshold}} (current value {{.Value}})
+{{end}}
+Description:
+{{if eq .Type "ALARM_CLEARED"}}The {{.Metric}} alarm at {{.City}} ({{.Zipcode}}) has been cleared.
+Its conditions no longer hold.{{else}}The {{.Metric}} conditions at {{.City}} ({{.Zipcode}}) have held
+for {{.Duration}} minutes.
+
+Please take appropriate action.{{end}}
+
+---
+Weather Server Notification System
+`
+
+	t, err := template.New("rule").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, notification); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (e *EmailNotifier) renderEscalatedTemplate(notification *protocol.AlarmNotification) (string, error) {
+	tmpl := `
+Weather Alarm Escalated
+=======================
+
+Location: {{.City}}, {{.Zipcode}}
+Metric: {{.Metric}}
+Value at Trigger: {{.Value}}
+Threshold: {{.Operator}} {{.Threshold}}
+Severity: {{.Severity}}
+Start Time: {{.StartTime}}
+Escalation Level: {{.EscalationLevel}}
+Alarm ID: {{.AlarmID}}
+{{if .AcknowledgedBy}}Acknowledged by: {{.AcknowledgedBy}} at {{.AcknowledgedAt}} (snoozed until {{.SnoozedUntil}})
+{{else}}Acknowledged: no
+{{end}}
+Description:
+The {{.Metric}} alarm at {{.City}} ({{.Zipcode}}) is still active and
+{{if .AcknowledgedBy}}its snooze has ended{{else}}nobody has acknowledged it{{end}}. It has been escalated to level
+{{.EscalationLevel}}.
+
+Please acknowledge the alarm or take appropriate action.
+
+---
+Weather Server Notification System
+`
+
+	t, err := template.New("escalated").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, notification); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (e *EmailNotifier) renderAcknowledgedTemplate(notification *protocol.AlarmNotification) (string, error) {
+	tmpl := `
+Weather Alarm Acknowledged
+==========================
+
+Location: {{.City}}, {{.Zipcode}}
+Metric: {{.Metric}}
+Severity: {{.Severity}}
+Start Time: {{.StartTime}}
+Alarm ID: {{.AlarmID}}
+Acknowledged by: {{.AcknowledgedBy}} at {{.AcknowledgedAt}}
+
+Description:
+{{.AcknowledgedBy}} has acknowledged the {{.Metric}} alarm at {{.City}} ({{.Zipcode}}).
+{{if .SnoozedUntil}}Escalation is snoozed until {{.SnoozedUntil}}.{{else}}It won't be escalated again before it clears.{{end}}
+The alarm is still active and will be cleared as usual.
+
+---
+Weather Server Notification System
+`
+
+	t, err := template.New("acknowledged").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, notification); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (e *EmailNotifier) renderPredictedTemplate(notification *protocol.AlarmNotification) (string, error) {
+	tmpl := `
+Weather Alarm Predicted
+=======================
+
+Location: {{.City}}, {{.Zipcode}}
+Metric: {{.Metric}}
+Current Value: {{.Value}}
+Threshold: {{.Operator}} {{.Threshold}}
+Severity: {{.Severity}}
+Predicted Breach: {{.PredictedBreachTime}}
+
+Description:
+The {{.Metric}} at {{.City}} ({{.Zipcode}}) is trending towards the
+threshold ({{.Operator}} {{.Threshold}}) and is projected to cross it at
+{{.PredictedBreachTime}}. The current value is {{.Value}}.
+
+No alarm has triggered yet; one will if the threshold is breached for
+{{.Duration}} minutes.
+
+---
+Weather Server Notification System
+`
+
+	t, err := template.New("predicted").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, notification); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
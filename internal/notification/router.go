@@ -0,0 +1,132 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// Notifier delivers alarm notifications over one channel
+type Notifier interface {
+	SendAlarmNotification(notification *protocol.AlarmNotification) error
+}
+
+// RecipientNotifier is a notifier that can also deliver to given
+// recipients, e.g. email addresses, instead of its configured ones
+type RecipientNotifier interface {
+	Notifier
+	SendTo(recipients []string, notification *protocol.AlarmNotification) error
+}
+
+// Subscriptions looks up who is subscribed to a zipcode's metric at a
+// severity; *database.DB is one
+type Subscriptions interface {
+	GetSubscribers(ctx context.Context, zipcode, metric, severity string) ([]*database.Subscription, error)
+}
+
+// Router sends each notification to its subscribers over their channels,
+// or, without any, through the notifier registered for its channel.
+// Notifications for the default channel, or one without a notifier, go
+// through the fallback.
+type Router struct {
+	notifiers     map[string]Notifier
+	fallback      Notifier
+	broadcast     []Notifier
+	subscriptions Subscriptions
+}
+
+// NewRouter creates a router sending through fallback until other
+// channels are registered
+func NewRouter(fallback Notifier) *Router {
+	return &Router{notifiers: make(map[string]Notifier), fallback: fallback}
+}
+
+// Register sends notifications for channel, e.g. sms, through notifier
+func (r *Router) Register(channel string, notifier Notifier) {
+	r.notifiers[channel] = notifier
+}
+
+// Broadcast sends every notification through notifier too, whatever its
+// channel, e.g. to webhooks
+func (r *Router) Broadcast(notifier Notifier) {
+	r.broadcast = append(r.broadcast, notifier)
+}
+
+// Subscribe sends notifications to their subscribers, looked up in
+// subscriptions, rather than everyone configured for the channel
+func (r *Router) Subscribe(subscriptions Subscriptions) {
+	r.subscriptions = subscriptions
+}
+
+// SendAlarmNotification sends a notification to its subscribers, or
+// through its channel's notifier if it has none, and through the broadcast
+// ones. Only the subscribers' or channel's failure is returned, so a retry
+// doesn't send it twice over the channel; the broadcast notifiers' are
+// printed.
+func (r *Router) SendAlarmNotification(notification *protocol.AlarmNotification) error {
+	for _, notifier := range r.broadcast {
+		if err := notifier.SendAlarmNotification(notification); err != nil {
+			fmt.Printf("Failed to broadcast alarm %d notification: %v\n", notification.AlarmID, err)
+		}
+	}
+
+	if r.subscriptions != nil {
+		sent, err := r.sendToSubscribers(notification)
+		if sent || err != nil {
+			return err
+		}
+	}
+
+	notifier, ok := r.notifiers[notification.Channel]
+	if !ok {
+		if notification.Channel != "" {
+			fmt.Printf("No %s notifier configured, sending alarm %d through the default channel\n",
+				notification.Channel, notification.AlarmID)
+		}
+		notifier = r.fallback
+	}
+	return notifier.SendAlarmNotification(notification)
+}
+
+// sendToSubscribers sends a notification to each subscriber over their
+// channel, or only to the subscribers of its channel if it names one, as
+// escalations do. It reports whether any subscriber was sent to.
+func (r *Router) sendToSubscribers(notification *protocol.AlarmNotification) (bool, error) {
+	subscribers, err := r.subscriptions.GetSubscribers(context.Background(), notification.Zipcode, notification.Metric, notification.Severity)
+	if err != nil {
+		return false, fmt.Errorf("failed to get subscribers: %w", err)
+	}
+
+	recipients := make(map[string][]string)
+	for _, s := range subscribers {
+		if notification.Channel != "" && s.Channel != notification.Channel {
+			continue
+		}
+		if !slices.Contains(recipients[s.Channel], s.Recipient) {
+			recipients[s.Channel] = append(recipients[s.Channel], s.Recipient)
+		}
+	}
+
+	sent := false
+	var errs []error
+	for _, channel := range database.SubscriptionChannels {
+		if len(recipients[channel]) == 0 {
+			continue
+		}
+		notifier, ok := r.notifiers[channel].(RecipientNotifier)
+		if !ok {
+			fmt.Printf("No %s notifier configured, skipping %d subscribers of alarm %d\n",
+				channel, len(recipients[channel]), notification.AlarmID)
+			continue
+		}
+		sent = true
+		if err := notifier.SendTo(recipients[channel], notification); err != nil {
+			errs = append(errs, fmt.Errorf("failed to notify %s subscribers: %w", channel, err))
+		}
+	}
+	return sent, errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// twilioAPI is the base URL of Twilio's REST API
+const twilioAPI = "https://api.twilio.com/2010-04-01"
+
+// SMS truncation strategies, for messages over the character budget
+const (
+	TruncateDrop = "drop" // drop details, least important first, then cut the end
+	TruncateEnd  = "end"  // cut the end
+)
+
+// SMSNotifier sends alarm notifications as text messages through Twilio.
+// Each goes to the numbers routed to its zipcode, or to the default
+// numbers if none are.
+type SMSNotifier struct {
+	config *config.SMSConfig
+	routes map[string][]string
+	client *http.Client
+}
+
+// NewSMSNotifier creates an SMS notifier, checking its routes and
+// truncation strategy
+func NewSMSNotifier(cfg *config.SMSConfig) (*SMSNotifier, error) {
+	if cfg.From == "" {
+		return nil, fmt.Errorf("SMS_FROM is required to send SMS")
+	}
+	if cfg.MaxLength < 20 {
+		return nil, fmt.Errorf("invalid SMS length %d (expected at least 20 characters)", cfg.MaxLength)
+	}
+	if cfg.Truncate != TruncateDrop && cfg.Truncate != TruncateEnd {
+		return nil, fmt.Errorf("unknown SMS truncation strategy: %s (want %s or %s)", cfg.Truncate, TruncateDrop, TruncateEnd)
+	}
+
+	routes := make(map[string][]string)
+	for _, route := range cfg.Routes {
+		zipcode, number, ok := strings.Cut(route, "=")
+		if !ok || zipcode == "" || number == "" {
+			return nil, fmt.Errorf("invalid SMS route %q (expected zipcode=number)", route)
+		}
+		routes[zipcode] = append(routes[zipcode], number)
+	}
+
+	return &SMSNotifier{
+		config: cfg,
+		routes: routes,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// SendAlarmNotification texts a notification to the numbers routed to its
+// zipcode
+func (s *SMSNotifier) SendAlarmNotification(notification *protocol.AlarmNotification) error {
+	recipients := s.routes[notification.Zipcode]
+	if len(recipients) == 0 {
+		recipients = s.config.To
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no SMS recipients for zipcode %s", notification.Zipcode)
+	}
+	return s.SendTo(recipients, notification)
+}
+
+// SendTo texts a notification to recipients
+func (s *SMSNotifier) SendTo(recipients []string, notification *protocol.AlarmNotification) error {
+	summary, details := smsText(notification)
+	body := fitSMS(summary, details, s.config.MaxLength, s.config.Truncate)
+	for _, to := range recipients {
+		if err := s.send(to, body); err != nil {
+			return fmt.Errorf("failed to send SMS to %s: %w", to, err)
+		}
+	}
+	fmt.Printf("SMS sent to %d recipients: %s\n", len(recipients), body)
+	return nil
+}
+
+// send sends one message through Twilio's Messages API
+func (s *SMSNotifier) send(to, body string) error {
+	form := url.Values{"From": {s.config.From}, "To": {to}, "Body": {body}}
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPI, url.PathEscape(s.config.AccountSID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.config.AccountSID, s.config.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from Twilio: %s", resp.Status)
+	}
+	return nil
+}
+
+// smsText is a notification as a concise summary and details, from most
+// to least important
+func smsText(n *protocol.AlarmNotification) (string, []string) {
+	where := n.Zipcode
+	if n.City != "" {
+		where = n.City + " " + n.Zipcode
+	}
+	reading := fmt.Sprintf("%s %g %s %g", n.Metric, n.Value, n.Operator, n.Threshold)
+	if len(n.Conditions) > 0 {
+		conditions := make([]string, len(n.Conditions))
+		for i, c := range n.Conditions {
+			conditions[i] = fmt.Sprintf("%s %g %s %g", c.Metric, c.Value, c.Operator, c.Threshold)
+		}
+		reading = n.Metric + " (" + strings.Join(conditions, " "+n.Combinator+" ") + ")"
+	}
+
+	var summary string
+	switch n.Type {
+	case protocol.AlarmTypeTriggered:
+		summary = "ALARM " + reading + " at " + where
+	case protocol.AlarmTypeCleared:
+		summary = "CLEARED " + n.Metric + " at " + where
+	case protocol.AlarmTypeFlapping:
+		summary = "FLAPPING " + reading + " at " + where
+	case protocol.AlarmTypeEscalated:
+		summary = fmt.Sprintf("ESCALATED L%d %s at %s, unacknowledged", n.EscalationLevel, reading, where)
+	case protocol.AlarmTypeAcknowledged:
+		summary = "ACK by " + n.AcknowledgedBy + ": " + n.Metric + " at " + where
+	case protocol.AlarmTypePredicted:
+		summary = fmt.Sprintf("PREDICTED %s %g heading %s %g at %s", n.Metric, n.Value, n.Operator, n.Threshold, where)
+		if n.PredictedBreachTime != nil {
+			summary += " by " + n.PredictedBreachTime.UTC().Format("15:04 MST")
+		}
+	default:
+		summary = n.Type + " " + n.Metric + " at " + where
+	}
+
+	var details []string
+	if n.Severity != "" {
+		details = append(details, n.Severity)
+	}
+	if n.AlarmID != 0 {
+		details = append(details, fmt.Sprintf("alarm %d", n.AlarmID))
+	}
+	if n.SuppressedDuplicates > 0 {
+		details = append(details, fmt.Sprintf("%d suppressed", n.SuppressedDuplicates))
+	}
+	return summary, details
+}
+
+// fitSMS joins a summary and its details into a message of at most
+// maxLength characters. The drop strategy drops details, least important
+// first, before cutting the end off.
+func fitSMS(summary string, details []string, maxLength int, strategy string) string {
+	join := func(details []string) string {
+		if len(details) == 0 {
+			return summary
+		}
+		return summary + " [" + strings.Join(details, ", ") + "]"
+	}
+
+	text := join(details)
+	if strategy == TruncateDrop {
+		for len(details) > 0 && len([]rune(text)) > maxLength {
+			details = details[:len(details)-1]
+			text = join(details)
+		}
+	}
+	if runes := []rune(text); len(runes) > maxLength {
+		// "..." rather than an ellipsis keeps the message in the GSM
+		// alphabet, whose segments fit more characters
+		text = string(runes[:maxLength-3]) + "..."
+	}
+	return text
+}
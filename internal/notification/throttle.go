@@ -0,0 +1,157 @@
+package notification
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// Throttle passes notifications on to a notifier at most limit times per
+// window for each zipcode and metric, so an alarm that keeps re-triggering
+// can't send dozens of emails an hour. A notification of the same type and
+// alarm as the last one passed on within the window is a duplicate, e.g. a
+// redelivered message, and is dropped.
+//
+// Over the limit, the latest notification is held back and passed on once
+// the window allows, unless it is of the same type as the last one passed
+// on, so recipients still learn how the alarm ended up. The next one passed
+// on carries how many were suppressed meanwhile. Escalations and
+// acknowledgements are never throttled.
+type Throttle struct {
+	next   Notifier
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	alarms map[string]*throttled
+}
+
+// throttled is what a Throttle tracks of a zipcode and metric
+type throttled struct {
+	sent       []time.Time                 // when notifications were passed on, within the window
+	last       *protocol.AlarmNotification // the last one passed on
+	held       *protocol.AlarmNotification // the latest one held back
+	suppressed int                         // held back or dropped since last
+	flushing   bool                        // a flush of held is scheduled
+}
+
+// NewThrottle creates a throttle passing notifications on to next. A limit
+// of zero or less passes every notification on.
+func NewThrottle(next Notifier, limit int, window time.Duration) *Throttle {
+	return &Throttle{
+		next:   next,
+		limit:  limit,
+		window: window,
+		alarms: make(map[string]*throttled),
+	}
+}
+
+// SendAlarmNotification passes a notification on, or holds it back or
+// drops it
+func (t *Throttle) SendAlarmNotification(notification *protocol.AlarmNotification) error {
+	if t.limit <= 0 || notification.Type == protocol.AlarmTypeEscalated || notification.Type == protocol.AlarmTypeAcknowledged {
+		return t.next.SendAlarmNotification(notification)
+	}
+
+	key := notification.Zipcode + "/" + notification.Metric
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.alarms[key]
+	if a == nil {
+		a = &throttled{}
+		t.alarms[key] = a
+	}
+	a.prune(now, t.window)
+
+	switch {
+	case len(a.sent) > 0 && sameAlarm(a.last, notification):
+		a.suppressed++
+		fmt.Printf("Dropped duplicate %s notification (zipcode=%s, metric=%s, alarm=%d)\n",
+			notification.Type, notification.Zipcode, notification.Metric, notification.AlarmID)
+		return nil
+
+	case len(a.sent) >= t.limit:
+		if a.held != nil {
+			a.suppressed++
+		}
+		a.held = notification
+		if !a.flushing {
+			a.flushing = true
+			time.AfterFunc(a.sent[0].Add(t.window).Sub(now), func() { t.flush(key) })
+		}
+		fmt.Printf("Throttled %s notification (zipcode=%s, metric=%s): %d sent in the last %s\n",
+			notification.Type, notification.Zipcode, notification.Metric, len(a.sent), t.window)
+		return nil
+	}
+
+	// A held notification is superseded by this one
+	if a.held != nil {
+		a.suppressed++
+		a.held = nil
+	}
+	return t.pass(a, notification, now)
+}
+
+// flush passes on a zipcode and metric's held notification once the
+// window allows, or drops it if the last one passed on already told the
+// same
+func (t *Throttle) flush(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.alarms[key]
+	a.flushing = false
+	if a.held == nil {
+		return
+	}
+
+	now := time.Now()
+	a.prune(now, t.window)
+	if len(a.sent) >= t.limit {
+		a.flushing = true
+		time.AfterFunc(a.sent[0].Add(t.window).Sub(now), func() { t.flush(key) })
+		return
+	}
+
+	held := a.held
+	a.held = nil
+	if a.last != nil && a.last.Type == held.Type {
+		a.suppressed++
+		return
+	}
+	if err := t.pass(a, held, now); err != nil {
+		fmt.Printf("Failed to send held %s notification (zipcode=%s, metric=%s): %v\n",
+			held.Type, held.Zipcode, held.Metric, err)
+	}
+}
+
+// pass passes a notification on with the count of those suppressed before
+// it, and records it as sent if it was
+func (t *Throttle) pass(a *throttled, notification *protocol.AlarmNotification, now time.Time) error {
+	notification.SuppressedDuplicates = a.suppressed
+	if err := t.next.SendAlarmNotification(notification); err != nil {
+		return err
+	}
+	a.sent = append(a.sent, now)
+	a.last = notification
+	a.suppressed = 0
+	return nil
+}
+
+// prune forgets notifications passed on before the window
+func (a *throttled) prune(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(a.sent) && now.Sub(a.sent[i]) >= window {
+		i++
+	}
+	a.sent = a.sent[i:]
+}
+
+// sameAlarm reports whether two notifications tell the same about the
+// same alarm
+func sameAlarm(a, b *protocol.AlarmNotification) bool {
+	return a != nil && a.Type == b.Type && a.AlarmID == b.AlarmID
+}
@@ -0,0 +1,120 @@
+package notification
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// recordingNotifier keeps a copy of each notification sent through it
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []protocol.AlarmNotification
+}
+
+func (r *recordingNotifier) SendAlarmNotification(notification *protocol.AlarmNotification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, *notification)
+	return nil
+}
+
+func (r *recordingNotifier) notifications() []protocol.AlarmNotification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]protocol.AlarmNotification(nil), r.sent...)
+}
+
+func alarm(notificationType string, alarmID int64) *protocol.AlarmNotification {
+	return &protocol.AlarmNotification{Type: notificationType, AlarmID: alarmID, Zipcode: "94105", Metric: "temperature"}
+}
+
+// waitForSent waits until n notifications have been sent, or fails
+func waitForSent(t *testing.T, r *recordingNotifier, n int) []protocol.AlarmNotification {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sent := r.notifications()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d notifications sent, got %d", n, len(sent))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestThrottle_DropsDuplicates(t *testing.T) {
+	next := &recordingNotifier{}
+	throttle := NewThrottle(next, 5, time.Minute)
+
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeTriggered, 1))
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeTriggered, 1))
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeCleared, 1))
+
+	sent := next.notifications()
+	if len(sent) != 2 || sent[0].Type != protocol.AlarmTypeTriggered || sent[1].Type != protocol.AlarmTypeCleared {
+		t.Fatalf("Expected the duplicate trigger dropped, got %+v", sent)
+	}
+	// The next one sent tells how many were dropped
+	if sent[0].SuppressedDuplicates != 0 || sent[1].SuppressedDuplicates != 1 {
+		t.Errorf("Expected 1 suppressed carried over to the clear, got %d and %d",
+			sent[0].SuppressedDuplicates, sent[1].SuppressedDuplicates)
+	}
+}
+
+func TestThrottle_FlushesHeld(t *testing.T) {
+	next := &recordingNotifier{}
+	throttle := NewThrottle(next, 1, 50*time.Millisecond)
+
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeTriggered, 1))
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeCleared, 1))
+	if sent := next.notifications(); len(sent) != 1 {
+		t.Fatalf("Expected the clear held back over the limit, got %+v", sent)
+	}
+
+	sent := waitForSent(t, next, 2)
+	if sent[1].Type != protocol.AlarmTypeCleared || sent[1].SuppressedDuplicates != 0 {
+		t.Errorf("Expected the held clear sent once the window allows, got %+v", sent[1])
+	}
+}
+
+func TestThrottle_DropsHeldOfSameType(t *testing.T) {
+	next := &recordingNotifier{}
+	throttle := NewThrottle(next, 1, 50*time.Millisecond)
+
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeTriggered, 1))
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeCleared, 1))
+	// Supersedes the held clear
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeTriggered, 2))
+
+	// The held trigger tells nothing new after the last trigger sent
+	time.Sleep(150 * time.Millisecond)
+	if sent := next.notifications(); len(sent) != 1 {
+		t.Fatalf("Expected the held trigger dropped, got %+v", sent)
+	}
+
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeCleared, 2))
+	sent := next.notifications()
+	if len(sent) != 2 || sent[1].Type != protocol.AlarmTypeCleared {
+		t.Fatalf("Expected the clear sent in the next window, got %+v", sent)
+	}
+	if sent[1].SuppressedDuplicates != 2 {
+		t.Errorf("Expected the superseded clear and dropped trigger counted, got %d", sent[1].SuppressedDuplicates)
+	}
+}
+
+func TestThrottle_EscalationsBypass(t *testing.T) {
+	next := &recordingNotifier{}
+	throttle := NewThrottle(next, 1, time.Minute)
+
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeTriggered, 1))
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeEscalated, 1))
+	throttle.SendAlarmNotification(alarm(protocol.AlarmTypeAcknowledged, 1))
+	if sent := next.notifications(); len(sent) != 3 {
+		t.Errorf("Expected escalations and acknowledgements past the limit, got %+v", sent)
+	}
+}
@@ -0,0 +1,193 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// webhookQueueSize is how many deliveries may wait for each endpoint
+const webhookQueueSize = 1000
+
+// WebhookNotifier POSTs alarm notifications as JSON to webhook endpoints.
+// Each endpoint delivers from its own queue, in order, retrying failures
+// with exponential backoff without holding up the other endpoints or the
+// notification service. Every delivery is logged in webhook_deliveries:
+// pending until it is delivered or has failed every attempt, or if the
+// service stopped first.
+type WebhookNotifier struct {
+	db        *database.DB
+	config    *config.WebhookConfig
+	client    *http.Client
+	endpoints []*webhookEndpoint
+}
+
+// webhookEndpoint is an endpoint and its queue of deliveries
+type webhookEndpoint struct {
+	url   string
+	queue chan *webhookDelivery
+}
+
+type webhookDelivery struct {
+	delivery     *database.WebhookDelivery
+	notification string // its type
+	body         []byte
+}
+
+// NewWebhookNotifier creates a webhook notifier delivering until ctx is
+// done
+func NewWebhookNotifier(ctx context.Context, db *database.DB, cfg *config.WebhookConfig) (*WebhookNotifier, error) {
+	w := &WebhookNotifier{
+		db:     db,
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, endpoint := range cfg.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook endpoint %q (want an http or https URL)", endpoint)
+		}
+		w.endpoints = append(w.endpoints, &webhookEndpoint{url: endpoint, queue: make(chan *webhookDelivery, webhookQueueSize)})
+	}
+
+	for _, endpoint := range w.endpoints {
+		go w.run(ctx, endpoint)
+	}
+	return w, nil
+}
+
+// SendAlarmNotification logs a delivery of the notification to each
+// endpoint and queues it. It only fails if a delivery can't be logged.
+func (w *WebhookNotifier) SendAlarmNotification(notification *protocol.AlarmNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	var alarmID *int64
+	if notification.AlarmID != 0 {
+		alarmID = &notification.AlarmID
+	}
+
+	ctx := context.Background()
+	for _, endpoint := range w.endpoints {
+		d := &database.WebhookDelivery{
+			Endpoint:         endpoint.url,
+			NotificationType: notification.Type,
+			Zipcode:          notification.Zipcode,
+			MetricName:       notification.Metric,
+			AlarmID:          alarmID,
+		}
+		if err := w.db.InsertWebhookDelivery(ctx, d); err != nil {
+			return fmt.Errorf("failed to log webhook delivery: %w", err)
+		}
+
+		select {
+		case endpoint.queue <- &webhookDelivery{delivery: d, notification: notification.Type, body: body}:
+		default:
+			// The endpoint has fallen too far behind
+			message := "queue full"
+			d.Status, d.LastError = database.DeliveryFailed, &message
+			w.record(ctx, d)
+		}
+	}
+	return nil
+}
+
+// run delivers an endpoint's queue until ctx is done
+func (w *WebhookNotifier) run(ctx context.Context, endpoint *webhookEndpoint) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-endpoint.queue:
+			w.deliver(ctx, endpoint, item)
+		}
+	}
+}
+
+// deliver POSTs a notification to an endpoint until it succeeds, fails
+// for good or runs out of retries, and logs the outcome. Client errors
+// other than 408 and 429 aren't retried.
+func (w *WebhookNotifier) deliver(ctx context.Context, endpoint *webhookEndpoint, item *webhookDelivery) {
+	d := item.delivery
+	backoff := w.config.InitialBackoff
+	for {
+		d.Attempts++
+		code, err := w.post(ctx, endpoint, item)
+		d.LastStatusCode = nil
+		if code != 0 {
+			d.LastStatusCode = &code
+		}
+		if err == nil {
+			d.Status, d.LastError = database.DeliveryDelivered, nil
+			w.record(ctx, d)
+			return
+		}
+
+		message := err.Error()
+		d.LastError = &message
+		permanent := code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+		if permanent || d.Attempts > w.config.MaxRetries {
+			d.Status = database.DeliveryFailed
+			w.record(ctx, d)
+			fmt.Printf("Webhook delivery %d to %s failed after %d attempts: %v\n", d.ID, endpoint.url, d.Attempts, err)
+			return
+		}
+		w.record(ctx, d)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, w.config.MaxBackoff)
+	}
+}
+
+// post makes one delivery attempt, returning the response status code if
+// there was a response. With a secret the body's HMAC-SHA256 is sent
+// hex-encoded in X-Alarm-Signature as sha256=<hex>.
+func (w *WebhookNotifier) post(ctx context.Context, endpoint *webhookEndpoint, item *webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.url, bytes.NewReader(item.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alarm-Event", item.notification)
+	req.Header.Set("X-Alarm-Delivery", strconv.FormatInt(item.delivery.ID, 10))
+	if w.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.config.Secret))
+		mac.Write(item.body)
+		req.Header.Set("X-Alarm-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record logs a delivery's status in the database; failing that, it is
+// only printed
+func (w *WebhookNotifier) record(ctx context.Context, d *database.WebhookDelivery) {
+	if err := w.db.UpdateWebhookDelivery(context.WithoutCancel(ctx), d); err != nil {
+		fmt.Printf("Failed to log webhook delivery %d: %v\n", d.ID, err)
+	}
+}
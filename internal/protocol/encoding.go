@@ -0,0 +1,256 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/smukkama/weather-server/pkg/eventspb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Encoding is the wire format of metric and alarm payloads on the queue
+type Encoding string
+
+const (
+	EncodingJSON     Encoding = "json"
+	EncodingProtobuf Encoding = "protobuf"
+)
+
+// payloadEncoding is the format EncodeMetricMessage and
+// EncodeAlarmNotification write
+var payloadEncoding atomic.Value
+
+func init() {
+	payloadEncoding.Store(EncodingJSON)
+}
+
+// SetPayloadEncoding selects the format metric and alarm payloads are
+// written in. Decoding detects the format of each payload, so consumers
+// read both and a topic can be switched over without draining it.
+func SetPayloadEncoding(encoding Encoding) error {
+	switch encoding {
+	case EncodingJSON, EncodingProtobuf:
+		payloadEncoding.Store(encoding)
+		return nil
+	default:
+		return fmt.Errorf("unknown payload encoding %q (expected json or protobuf)", encoding)
+	}
+}
+
+func currentEncoding() Encoding {
+	return payloadEncoding.Load().(Encoding)
+}
+
+// isJSON reports whether a payload is a JSON document. No protobuf payload
+// starts with '{': it would be field 15 with the deprecated group type.
+func isJSON(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timestampFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// optionalTimestampProto is timestampProto for an optional time
+func optionalTimestampProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestampProto(*t)
+}
+
+// optionalTimeFromProto is timestampFromProto for an optional time
+func optionalTimeFromProto(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+func marshalMetricProto(msg *MetricMessage) ([]byte, error) {
+	pb := &eventspb.MetricMessage{
+		Version:      int32(msg.Version),
+		ConnectionId: msg.ConnectionID,
+		Zipcode:      msg.Zipcode,
+		City:         msg.City,
+		StationId:    msg.StationID,
+		ReceivedAt:   timestampProto(msg.ReceivedAt),
+		Data: &eventspb.MetricData{
+			Timestamp:      msg.Data.Timestamp,
+			Temperature:    msg.Data.Temperature,
+			Humidity:       msg.Data.Humidity,
+			Precipitation:  msg.Data.Precipitation,
+			WindSpeed:      msg.Data.WindSpeed,
+			WindDirection:  msg.Data.WindDirection,
+			PollutionIndex: msg.Data.PollutionIndex,
+			PollenIndex:    msg.Data.PollenIndex,
+			Pressure:       msg.Data.Pressure,
+			Visibility:     msg.Data.Visibility,
+			UvIndex:        msg.Data.UVIndex,
+			SnowDepth:      msg.Data.SnowDepth,
+		},
+	}
+	if s := msg.Station; s != nil {
+		pb.Station = &eventspb.StationMetadata{
+			Latitude:        s.Latitude,
+			Longitude:       s.Longitude,
+			Elevation:       s.Elevation,
+			Model:           s.Model,
+			FirmwareVersion: s.FirmwareVersion,
+		}
+	}
+	if d := msg.Derived; d != nil {
+		pb.Derived = &eventspb.DerivedMetrics{
+			HeatIndex: d.HeatIndex,
+			WindChill: d.WindChill,
+			DewPoint:  d.DewPoint,
+		}
+	}
+	return proto.Marshal(pb)
+}
+
+func unmarshalMetricProto(data []byte) (*MetricMessage, error) {
+	var pb eventspb.MetricMessage
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, err
+	}
+
+	version := int(pb.Version)
+	if version == 0 {
+		version = 1
+	}
+	// Protobuf evolves by field numbers, so older versions decode as is;
+	// only a newer one may mean something this build can't read
+	if version > MetricMessageSchemaVersion {
+		return nil, &SchemaVersionError{Version: version, Supported: MetricMessageSchemaVersion}
+	}
+
+	msg := &MetricMessage{
+		Version:      version,
+		ConnectionID: pb.ConnectionId,
+		Zipcode:      pb.Zipcode,
+		City:         pb.City,
+		StationID:    pb.StationId,
+		ReceivedAt:   timestampFromProto(pb.ReceivedAt),
+	}
+	if d := pb.Data; d != nil {
+		msg.Data = MetricData{
+			Timestamp:      d.Timestamp,
+			Temperature:    d.Temperature,
+			Humidity:       d.Humidity,
+			Precipitation:  d.Precipitation,
+			WindSpeed:      d.WindSpeed,
+			WindDirection:  d.WindDirection,
+			PollutionIndex: d.PollutionIndex,
+			PollenIndex:    d.PollenIndex,
+			Pressure:       d.Pressure,
+			Visibility:     d.Visibility,
+			UVIndex:        d.UvIndex,
+			SnowDepth:      d.SnowDepth,
+		}
+	}
+	if s := pb.Station; s != nil {
+		msg.Station = &StationMetadata{
+			Latitude:        s.Latitude,
+			Longitude:       s.Longitude,
+			Elevation:       s.Elevation,
+			Model:           s.Model,
+			FirmwareVersion: s.FirmwareVersion,
+		}
+	}
+	if d := pb.Derived; d != nil {
+		msg.Derived = &DerivedMetrics{
+			HeatIndex: d.HeatIndex,
+			WindChill: d.WindChill,
+			DewPoint:  d.DewPoint,
+		}
+	}
+	return msg, nil
+}
+
+func marshalAlarmProto(alarm *AlarmNotification) ([]byte, error) {
+	var conditions []*eventspb.AlarmCondition
+	for _, c := range alarm.Conditions {
+		conditions = append(conditions, &eventspb.AlarmCondition{
+			Metric:    c.Metric,
+			Operator:  c.Operator,
+			Threshold: c.Threshold,
+			Value:     c.Value,
+		})
+	}
+	return proto.Marshal(&eventspb.AlarmNotification{
+		Type:                 alarm.Type,
+		Zipcode:              alarm.Zipcode,
+		City:                 alarm.City,
+		Metric:               alarm.Metric,
+		Value:                alarm.Value,
+		Threshold:            alarm.Threshold,
+		Operator:             alarm.Operator,
+		DurationMinutes:      int32(alarm.Duration),
+		StartTime:            timestampProto(alarm.StartTime),
+		AlarmId:              alarm.AlarmID,
+		Combinator:           alarm.Combinator,
+		Conditions:           conditions,
+		Severity:             alarm.Severity,
+		Channel:              alarm.Channel,
+		EscalationLevel:      int32(alarm.EscalationLevel),
+		AcknowledgedBy:       alarm.AcknowledgedBy,
+		AcknowledgedAt:       optionalTimestampProto(alarm.AcknowledgedAt),
+		SnoozedUntil:         optionalTimestampProto(alarm.SnoozedUntil),
+		PredictedBreachTime:  optionalTimestampProto(alarm.PredictedBreachTime),
+		SuppressedDuplicates: int32(alarm.SuppressedDuplicates),
+	})
+}
+
+func unmarshalAlarmProto(data []byte) (*AlarmNotification, error) {
+	var pb eventspb.AlarmNotification
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, err
+	}
+	var conditions []AlarmCondition
+	for _, c := range pb.Conditions {
+		conditions = append(conditions, AlarmCondition{
+			Metric:    c.Metric,
+			Operator:  c.Operator,
+			Threshold: c.Threshold,
+			Value:     c.Value,
+		})
+	}
+	return &AlarmNotification{
+		Type:                 pb.Type,
+		Zipcode:              pb.Zipcode,
+		City:                 pb.City,
+		Metric:               pb.Metric,
+		Value:                pb.Value,
+		Threshold:            pb.Threshold,
+		Operator:             pb.Operator,
+		Duration:             int(pb.DurationMinutes),
+		StartTime:            timestampFromProto(pb.StartTime),
+		AlarmID:              pb.AlarmId,
+		Combinator:           pb.Combinator,
+		Conditions:           conditions,
+		Severity:             pb.Severity,
+		Channel:              pb.Channel,
+		EscalationLevel:      int(pb.EscalationLevel),
+		AcknowledgedBy:       pb.AcknowledgedBy,
+		AcknowledgedAt:       optionalTimeFromProto(pb.AcknowledgedAt),
+		SnoozedUntil:         optionalTimeFromProto(pb.SnoozedUntil),
+		PredictedBreachTime:  optionalTimeFromProto(pb.PredictedBreachTime),
+		SuppressedDuplicates: int(pb.SuppressedDuplicates),
+	}, nil
+}
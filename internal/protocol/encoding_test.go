@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetricMessage_ProtobufRoundTrip(t *testing.T) {
+	if err := SetPayloadEncoding(EncodingProtobuf); err != nil {
+		t.Fatal(err)
+	}
+	defer SetPayloadEncoding(EncodingJSON)
+
+	direction := "NW"
+	original := &MetricMessage{
+		ConnectionID: "conn-1",
+		Zipcode:      "10001",
+		City:         "New York",
+		StationID:    "roof",
+		Station:      &StationMetadata{Latitude: float(40.7), Model: "WS-2000"},
+		ReceivedAt:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Data:         MetricData{Timestamp: "2024-05-01T12:00:00Z", Temperature: float(21.5), WindDirection: &direction},
+		Derived:      &DerivedMetrics{DewPoint: float(10.2)},
+	}
+	data, err := EncodeMetricMessage(original)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if isJSON(data) {
+		t.Fatal("Expected a protobuf payload")
+	}
+
+	decoded, err := DecodeMetricMessage(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	// Compare through JSON, which covers every field and nil-ness
+	want, _ := json.Marshal(original)
+	got, _ := json.Marshal(decoded)
+	if string(got) != string(want) {
+		t.Errorf("Round trip changed the message:\n got %s\nwant %s", got, want)
+	}
+	if decoded.Data.Humidity != nil {
+		t.Error("Missing measurement should stay nil")
+	}
+}
+
+func TestAlarmNotification_DecodesEitherEncoding(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	snoozedUntil := start.Add(2 * time.Hour)
+	alarms := []*AlarmNotification{
+		{Type: AlarmTypeTriggered, Zipcode: "10001", Metric: "temperature",
+			Value: 41, Threshold: 40, Operator: ">", Duration: 15, StartTime: start},
+		{Type: AlarmTypeEscalated, Zipcode: "10001", Metric: "temperature", Value: 41, Threshold: 40, Operator: ">",
+			StartTime: start, Severity: "critical", Channel: "sms", EscalationLevel: 1},
+		{Type: AlarmTypeAcknowledged, Zipcode: "10001", Metric: "temperature", StartTime: start,
+			AcknowledgedBy: "alice", AcknowledgedAt: &start, SnoozedUntil: &snoozedUntil},
+		{Type: AlarmTypePredicted, Zipcode: "10001", Metric: "temperature", Value: 33.5, Threshold: 35, Operator: ">",
+			StartTime: start, PredictedBreachTime: &snoozedUntil, SuppressedDuplicates: 3},
+		{Type: AlarmTypeTriggered, Zipcode: "10001", Metric: "heat_advisory", Duration: 15, StartTime: start,
+			Combinator: "AND", Conditions: []AlarmCondition{
+				{Metric: "temperature", Operator: ">", Threshold: 30, Value: 32},
+				{Metric: "humidity", Operator: ">", Threshold: 80, Value: 85},
+			}},
+	}
+
+	for _, encoding := range []Encoding{EncodingJSON, EncodingProtobuf} {
+		SetPayloadEncoding(encoding)
+		for _, alarm := range alarms {
+			data, err := EncodeAlarmNotification(alarm)
+			if err != nil {
+				t.Fatalf("%s: encode failed: %v", encoding, err)
+			}
+			decoded, err := DecodeAlarmNotification(data)
+			if err != nil {
+				t.Fatalf("%s: decode failed: %v", encoding, err)
+			}
+			if !reflect.DeepEqual(decoded, alarm) {
+				t.Errorf("%s: expected %+v, got %+v", encoding, alarm, decoded)
+			}
+		}
+	}
+	SetPayloadEncoding(EncodingJSON)
+}
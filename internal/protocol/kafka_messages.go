@@ -7,23 +7,95 @@ import (
 
 // MetricMessage is the internal message format for Kafka
 type MetricMessage struct {
-	ConnectionID string     `json:"connection_id"`
-	Zipcode      string     `json:"zipcode"`
-	City         string     `json:"city"`
-	ReceivedAt   time.Time  `json:"received_at"`
-	Data         MetricData `json:"data"`
+	Version      int              `json:"version"` // layout version; see schema.go
+	ConnectionID string           `json:"connection_id"`
+	Zipcode      string           `json:"zipcode"`
+	City         string           `json:"city"`
+	StationID    string           `json:"station_id,omitempty"`
+	Station      *StationMetadata `json:"station,omitempty"` // set when the station reported metadata
+	ReceivedAt   time.Time        `json:"received_at"`
+	Data         MetricData       `json:"data"`
+	Derived      *DerivedMetrics  `json:"derived,omitempty"` // computed server-side from Data
 }
 
-// ParsedMetricData contains the metric data with parsed timestamp
+// DerivedMetrics are computed from a reading's measurements (see package
+// derive); each is nil when its inputs are missing or it isn't defined for
+// the conditions
+type DerivedMetrics struct {
+	HeatIndex *float64 `json:"heat_index,omitempty"` // °C
+	WindChill *float64 `json:"wind_chill,omitempty"` // °C
+	DewPoint  *float64 `json:"dew_point,omitempty"`  // °C
+}
+
+// Value returns a measured or derived metric by name, or nil if the message
+// doesn't carry it
+func (m *MetricMessage) Value(name string) *float64 {
+	if v := m.Data.Value(name); v != nil {
+		return v
+	}
+	if m.Derived == nil {
+		return nil
+	}
+	switch name {
+	case "heat_index":
+		return m.Derived.HeatIndex
+	case "wind_chill":
+		return m.Derived.WindChill
+	case "dew_point":
+		return m.Derived.DewPoint
+	default:
+		return nil
+	}
+}
+
+// QuarantinedMetric is published to the quarantine topic for a reading that
+// failed physical range validation
+type QuarantinedMetric struct {
+	MetricMessage
+	Reason        string           `json:"reason"`
+	Violations    []RangeViolation `json:"violations"`
+	QuarantinedAt time.Time        `json:"quarantined_at"`
+}
+
+// ConnectionEventType identifies a connection lifecycle event
+type ConnectionEventType string
+
+const (
+	ConnectionEventConnected    ConnectionEventType = "connected"
+	ConnectionEventDisconnected ConnectionEventType = "disconnected"
+	ConnectionEventIdle         ConnectionEventType = "idle"
+)
+
+// ConnectionEvent is published to the connections topic when a station
+// connects, goes idle or disconnects
+type ConnectionEvent struct {
+	Type         ConnectionEventType `json:"type"`
+	ConnectionID string              `json:"connection_id"`
+	Zipcode      string              `json:"zipcode"`
+	City         string              `json:"city"`
+	StationID    string              `json:"station_id,omitempty"`
+	RemoteAddr   string              `json:"remote_addr,omitempty"`
+	ConnectedAt  time.Time           `json:"connected_at"`
+	LastHeard    time.Time           `json:"last_heard"`
+	Reason       string              `json:"reason,omitempty"` // why the station went idle or disconnected
+	Timestamp    time.Time           `json:"timestamp"`
+}
+
+// ParsedMetricData contains the metric data with parsed timestamp. Missing
+// measurements are nil.
 type ParsedMetricData struct {
 	Timestamp      time.Time
-	Temperature    float64
-	Humidity       float64
-	Precipitation  float64
-	WindSpeed      float64
-	WindDirection  string
-	PollutionIndex float64
-	PollenIndex    float64
+	Temperature    *float64
+	Humidity       *float64
+	Precipitation  *float64
+	WindSpeed      *float64
+	WindDirection  *string
+	PollutionIndex *float64
+	PollenIndex    *float64
+	Pressure       *float64
+	Visibility     *float64
+	UVIndex        *float64
+	SnowDepth      *float64
 }
 
 // ParseMetricData converts MetricData to ParsedMetricData
@@ -42,12 +114,16 @@ func (m *MetricData) Parse() (*ParsedMetricData, error) {
 		WindDirection:  m.WindDirection,
 		PollutionIndex: m.PollutionIndex,
 		PollenIndex:    m.PollenIndex,
+		Pressure:       m.Pressure,
+		Visibility:     m.Visibility,
+		UVIndex:        m.UVIndex,
+		SnowDepth:      m.SnowDepth,
 	}, nil
 }
 
 // AlarmNotification is the message format for alarm notifications
 type AlarmNotification struct {
-	Type      string    `json:"type"` // ALARM_TRIGGERED, ALARM_CLEARED
+	Type      string    `json:"type"` // ALARM_TRIGGERED, ALARM_CLEARED, ALARM_FLAPPING, ALARM_ESCALATED, ALARM_ACKNOWLEDGED, ALARM_PREDICTED
 	Zipcode   string    `json:"zipcode"`
 	City      string    `json:"city"`
 	Metric    string    `json:"metric"`
@@ -57,20 +133,72 @@ type AlarmNotification struct {
 	Duration  int       `json:"duration_minutes"`
 	StartTime time.Time `json:"start_time"`
 	AlarmID   int64     `json:"alarm_id,omitempty"`
+	// For a composite rule, Metric is the rule's name, and Combinator and
+	// Conditions stand in for Value, Threshold and Operator
+	Combinator string           `json:"combinator,omitempty"`
+	Conditions []AlarmCondition `json:"conditions,omitempty"`
+	Severity   string           `json:"severity,omitempty"` // info, warning or critical
+	// Channel is where an escalated alarm is sent, e.g. sms or pager, at
+	// its EscalationLevel; empty for the default, email
+	Channel         string `json:"channel,omitempty"`
+	EscalationLevel int    `json:"escalation_level,omitempty"`
+	// AcknowledgedBy acknowledged the alarm at AcknowledgedAt, silencing
+	// its escalation until SnoozedUntil, or until it clears without one
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	SnoozedUntil   *time.Time `json:"snoozed_until,omitempty"`
+	// PredictedBreachTime is when an ALARM_PREDICTED notification's metric
+	// is projected to cross its threshold
+	PredictedBreachTime *time.Time `json:"predicted_breach_time,omitempty"`
+	// SuppressedDuplicates counts the notifications of the same zipcode
+	// and metric the notification service held back before this one
+	SuppressedDuplicates int `json:"suppressed_duplicates,omitempty"`
 }
 
+// AlarmCondition is one condition of a composite alarm rule, with the
+// reading's value of its metric
+type AlarmCondition struct {
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	Value     float64 `json:"value"`
+}
+
+// Alarm notification types. ALARM_FLAPPING is sent once when an alarm
+// starts flapping, in place of its triggered and cleared notifications
+// until it settles. ALARM_ESCALATED repeats an active alarm nobody has
+// acknowledged, and ALARM_ACKNOWLEDGED announces an acknowledgement.
+// ALARM_PREDICTED warns that a metric's trend will breach its threshold
+// soon; it isn't logged and is followed by no clear.
 const (
-	AlarmTypeTriggered = "ALARM_TRIGGERED"
-	AlarmTypeCleared   = "ALARM_CLEARED"
+	AlarmTypeTriggered    = "ALARM_TRIGGERED"
+	AlarmTypeCleared      = "ALARM_CLEARED"
+	AlarmTypeFlapping     = "ALARM_FLAPPING"
+	AlarmTypeEscalated    = "ALARM_ESCALATED"
+	AlarmTypeAcknowledged = "ALARM_ACKNOWLEDGED"
+	AlarmTypePredicted    = "ALARM_PREDICTED"
 )
 
-// EncodeMetricMessage encodes a MetricMessage to JSON
+// EncodeMetricMessage encodes a MetricMessage in the current schema
+// version and payload encoding
 func EncodeMetricMessage(msg *MetricMessage) ([]byte, error) {
+	msg.Version = MetricMessageSchemaVersion
+	if currentEncoding() == EncodingProtobuf {
+		return marshalMetricProto(msg)
+	}
 	return json.Marshal(msg)
 }
 
-// DecodeMetricMessage decodes JSON to MetricMessage
+// DecodeMetricMessage decodes a JSON or protobuf MetricMessage, upgrading
+// JSON payloads written in older schema versions
 func DecodeMetricMessage(data []byte) (*MetricMessage, error) {
+	if !isJSON(data) {
+		return unmarshalMetricProto(data)
+	}
+	data, err := upgradeMetricMessage(data)
+	if err != nil {
+		return nil, err
+	}
 	var msg MetricMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return nil, err
@@ -78,13 +206,20 @@ func DecodeMetricMessage(data []byte) (*MetricMessage, error) {
 	return &msg, nil
 }
 
-// EncodeAlarmNotification encodes an AlarmNotification to JSON
+// EncodeAlarmNotification encodes an AlarmNotification in the current
+// payload encoding
 func EncodeAlarmNotification(alarm *AlarmNotification) ([]byte, error) {
+	if currentEncoding() == EncodingProtobuf {
+		return marshalAlarmProto(alarm)
+	}
 	return json.Marshal(alarm)
 }
 
-// DecodeAlarmNotification decodes JSON to AlarmNotification
+// DecodeAlarmNotification decodes a JSON or protobuf AlarmNotification
 func DecodeAlarmNotification(data []byte) (*AlarmNotification, error) {
+	if !isJSON(data) {
+		return unmarshalAlarmProto(data)
+	}
 	var alarm AlarmNotification
 	if err := json.Unmarshal(data, &alarm); err != nil {
 		return nil, err
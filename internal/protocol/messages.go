@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -14,9 +15,16 @@ const (
 	MsgTypeIdentify  MessageType = "identify"
 	MsgTypeMetrics   MessageType = "metrics"
 	MsgTypeKeepalive MessageType = "keepalive"
+	MsgTypeGoodbye   MessageType = "goodbye"
+	MsgTypeResume    MessageType = "resume"
 
 	// Server to Client
-	MsgTypeAck MessageType = "ack"
+	MsgTypeAck               MessageType = "ack"
+	MsgTypeError             MessageType = "error"
+	MsgTypeSetInterval       MessageType = "set_interval"
+	MsgTypeRequestMetricsNow MessageType = "request_metrics_now"
+	MsgTypeShutdown          MessageType = "shutdown"
+	MsgTypeNotice            MessageType = "notice"
 )
 
 // BaseMessage is the common structure for all messages
@@ -26,26 +34,82 @@ type BaseMessage struct {
 
 // IdentifyMessage is sent by the client on connection
 type IdentifyMessage struct {
-	Type    MessageType `json:"type"`
-	Zipcode string      `json:"zipcode"`
-	City    string      `json:"city"`
+	Type      MessageType `json:"type"`
+	Zipcode   string      `json:"zipcode"`
+	City      string      `json:"city"`
+	StationID string      `json:"station_id,omitempty"` // distinguishes stations sharing a zipcode
+	Units     UnitSystem  `json:"units,omitempty"`      // unit system of the readings; defaults to metric
+	StationMetadata
+}
+
+// StationMetadata optionally describes where a station is installed and
+// what hardware it runs
+type StationMetadata struct {
+	Latitude        *float64 `json:"latitude,omitempty"`
+	Longitude       *float64 `json:"longitude,omitempty"`
+	Elevation       *float64 `json:"elevation,omitempty"` // meters above sea level
+	Model           string   `json:"model,omitempty"`
+	FirmwareVersion string   `json:"firmware_version,omitempty"`
+}
+
+// IsZero reports whether no metadata was provided
+func (s *StationMetadata) IsZero() bool {
+	return s.Latitude == nil && s.Longitude == nil && s.Elevation == nil &&
+		s.Model == "" && s.FirmwareVersion == ""
+}
+
+// Equal reports whether two metadata values describe the same station setup
+func (s *StationMetadata) Equal(o *StationMetadata) bool {
+	return equalFloat(s.Latitude, o.Latitude) &&
+		equalFloat(s.Longitude, o.Longitude) &&
+		equalFloat(s.Elevation, o.Elevation) &&
+		s.Model == o.Model &&
+		s.FirmwareVersion == o.FirmwareVersion
+}
+
+// Validate checks that coordinates are in range
+func (s *StationMetadata) Validate() error {
+	if s.Latitude != nil && (*s.Latitude < -90 || *s.Latitude > 90) {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if s.Longitude != nil && (*s.Longitude < -180 || *s.Longitude > 180) {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	if (s.Latitude == nil) != (s.Longitude == nil) {
+		return fmt.Errorf("latitude and longitude must be given together")
+	}
+	return nil
+}
+
+func equalFloat(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
-// MetricData contains the actual weather measurements
+// MetricData contains the actual weather measurements. Each measurement is
+// optional: a station with a missing or broken sensor omits it (or sends
+// null) rather than reporting zero.
 type MetricData struct {
-	Timestamp      string  `json:"timestamp"`
-	Temperature    float64 `json:"temperature"`
-	Humidity       float64 `json:"humidity"`
-	Precipitation  float64 `json:"precipitation"`
-	WindSpeed      float64 `json:"wind_speed"`
-	WindDirection  string  `json:"wind_direction"`
-	PollutionIndex float64 `json:"pollution_index"`
-	PollenIndex    float64 `json:"pollen_index"`
+	Timestamp      string   `json:"timestamp"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+	Humidity       *float64 `json:"humidity,omitempty"`
+	Precipitation  *float64 `json:"precipitation,omitempty"`
+	WindSpeed      *float64 `json:"wind_speed,omitempty"`
+	WindDirection  *string  `json:"wind_direction,omitempty"`
+	PollutionIndex *float64 `json:"pollution_index,omitempty"`
+	PollenIndex    *float64 `json:"pollen_index,omitempty"`
+	Pressure       *float64 `json:"pressure,omitempty"` // barometric, sea-level adjusted
+	Visibility     *float64 `json:"visibility,omitempty"`
+	UVIndex        *float64 `json:"uv_index,omitempty"`
+	SnowDepth      *float64 `json:"snow_depth,omitempty"`
 }
 
 // MetricsMessage is sent by the client every 5 minutes
 type MetricsMessage struct {
 	Type MessageType `json:"type"`
+	Seq  *uint64     `json:"seq,omitempty"` // optional; when set the server acks the reading
 	Data MetricData  `json:"data"`
 }
 
@@ -54,24 +118,130 @@ type KeepaliveMessage struct {
 	Type MessageType `json:"type"`
 }
 
+// GoodbyeMessage is sent by the client before it disconnects cleanly
+type GoodbyeMessage struct {
+	Type   MessageType `json:"type"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// ResumeMessage is sent instead of identify by a client reconnecting within
+// the session grace period
+type ResumeMessage struct {
+	Type         MessageType `json:"type"`
+	SessionToken string      `json:"session_token"`
+}
+
 // AckMessage is sent by the server in response to messages
 type AckMessage struct {
+	Type         MessageType `json:"type"`
+	Status       string      `json:"status"`
+	SessionToken string      `json:"session_token,omitempty"` // set on identified/resumed acks
+	Seq          *uint64     `json:"seq,omitempty"`           // set on metrics acks
+}
+
+// SetIntervalMessage asks the client to change its metrics reporting interval
+type SetIntervalMessage struct {
+	Type            MessageType `json:"type"`
+	IntervalSeconds int         `json:"interval_seconds"`
+}
+
+// RequestMetricsNowMessage asks the client to send a metrics reading immediately
+type RequestMetricsNowMessage struct {
+	Type MessageType `json:"type"`
+}
+
+// ShutdownMessage tells the client the server is closing the connection
+type ShutdownMessage struct {
 	Type   MessageType `json:"type"`
-	Status string      `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// NoticeMessage carries an operator message for the client to log or display
+type NoticeMessage struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
 }
 
 // AckStatus constants
 const (
 	AckStatusIdentified = "identified"
 	AckStatusAlive      = "alive"
-	AckStatusError      = "error"
+	AckStatusGoodbye    = "goodbye"
+	AckStatusResumed    = "resumed"
+	AckStatusAccepted   = "accepted"  // metrics queued for publishing (async producer)
+	AckStatusPersisted  = "persisted" // metrics acknowledged by Kafka (sync producer)
+)
+
+// ErrorCode is a machine-readable reason a message or connection was rejected
+type ErrorCode string
+
+// ErrorCode constants
+const (
+	ErrCodeInvalidJSON       ErrorCode = "invalid_json"
+	ErrCodeInvalidMessage    ErrorCode = "invalid_message"
+	ErrCodeUnexpectedMessage ErrorCode = "unexpected_message"
+	ErrCodeUnauthorized      ErrorCode = "unauthorized"
+	ErrCodeRateLimited       ErrorCode = "rate_limited"
+	ErrCodeDuplicateStation  ErrorCode = "duplicate_station"
+	ErrCodeServerFull        ErrorCode = "server_full"
+	ErrCodeZipcodeFull       ErrorCode = "zipcode_full"
+	ErrCodeSessionExpired    ErrorCode = "session_expired"
+	ErrCodePublishFailed     ErrorCode = "publish_failed"
+	ErrCodeOutOfRange        ErrorCode = "out_of_range"
+	ErrCodeNotFound          ErrorCode = "not_found"
+	ErrCodeInternal          ErrorCode = "internal_error"
 )
 
+// ErrorMessage is sent by the server when it rejects a message or connection
+type ErrorMessage struct {
+	Type   MessageType `json:"type"`
+	Code   ErrorCode   `json:"code"`
+	Detail string      `json:"detail,omitempty"`
+	Seq    *uint64     `json:"seq,omitempty"` // sequence of the rejected metrics message, if any
+}
+
+// ParseError is returned by ParseMessage and carries the error code to report
+// back to the client
+type ParseError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCodeFor returns the error code for an error returned by ParseMessage,
+// falling back to ErrCodeInvalidMessage for other errors
+func ErrorCodeFor(err error) ErrorCode {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Code
+	}
+	return ErrCodeInvalidMessage
+}
+
 // ParseMessage parses a JSON line into the appropriate message type
 func ParseMessage(data []byte) (interface{}, error) {
+	msg, err := parseMessage(data)
+	if err != nil {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			err = &ParseError{Code: ErrCodeInvalidMessage, Err: err}
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func parseMessage(data []byte) (interface{}, error) {
 	var base BaseMessage
 	if err := json.Unmarshal(data, &base); err != nil {
-		return nil, fmt.Errorf("invalid JSON: %w", err)
+		return nil, &ParseError{Code: ErrCodeInvalidJSON, Err: fmt.Errorf("invalid JSON: %w", err)}
 	}
 
 	switch base.Type {
@@ -102,6 +272,23 @@ func ParseMessage(data []byte) (interface{}, error) {
 		}
 		return &msg, nil
 
+	case MsgTypeResume:
+		var msg ResumeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("invalid resume message: %w", err)
+		}
+		if msg.SessionToken == "" {
+			return nil, fmt.Errorf("session_token is required")
+		}
+		return &msg, nil
+
+	case MsgTypeGoodbye:
+		var msg GoodbyeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("invalid goodbye message: %w", err)
+		}
+		return &msg, nil
+
 	default:
 		return nil, fmt.Errorf("unknown message type: %s", base.Type)
 	}
@@ -115,18 +302,34 @@ func validateIdentify(msg *IdentifyMessage) error {
 	if msg.City == "" {
 		return fmt.Errorf("city is required")
 	}
-	return nil
+	units, err := ParseUnitSystem(string(msg.Units))
+	if err != nil {
+		return err
+	}
+	msg.Units = units
+	return msg.StationMetadata.Validate()
 }
 
 // validateMetrics validates a metrics message
 func validateMetrics(msg *MetricsMessage) error {
-	if msg.Data.Timestamp == "" {
+	return msg.Data.Validate()
+}
+
+// Validate checks a reading independently of the transport it arrived on
+func (m *MetricData) Validate() error {
+	if m.Timestamp == "" {
 		return fmt.Errorf("timestamp is required")
 	}
 	// Validate timestamp format
-	if _, err := time.Parse(time.RFC3339, msg.Data.Timestamp); err != nil {
+	if _, err := time.Parse(time.RFC3339, m.Timestamp); err != nil {
 		return fmt.Errorf("invalid timestamp format (must be RFC3339): %w", err)
 	}
+	if m.Temperature == nil && m.Humidity == nil && m.Precipitation == nil &&
+		m.WindSpeed == nil && m.WindDirection == nil && m.PollutionIndex == nil &&
+		m.PollenIndex == nil && m.Pressure == nil && m.Visibility == nil &&
+		m.UVIndex == nil && m.SnowDepth == nil {
+		return fmt.Errorf("reading contains no measurements")
+	}
 	return nil
 }
 
@@ -142,3 +345,56 @@ func NewAckMessage(status string) *AckMessage {
 		Status: status,
 	}
 }
+
+// NewSetIntervalMessage creates a new set_interval command
+func NewSetIntervalMessage(interval time.Duration) *SetIntervalMessage {
+	return &SetIntervalMessage{
+		Type:            MsgTypeSetInterval,
+		IntervalSeconds: int(interval / time.Second),
+	}
+}
+
+// NewRequestMetricsNowMessage creates a new request_metrics_now command
+func NewRequestMetricsNowMessage() *RequestMetricsNowMessage {
+	return &RequestMetricsNowMessage{
+		Type: MsgTypeRequestMetricsNow,
+	}
+}
+
+// NewShutdownMessage creates a new shutdown command
+func NewShutdownMessage(reason string) *ShutdownMessage {
+	return &ShutdownMessage{
+		Type:   MsgTypeShutdown,
+		Reason: reason,
+	}
+}
+
+// NewNoticeMessage creates a new operator notice
+func NewNoticeMessage(message string) *NoticeMessage {
+	return &NoticeMessage{
+		Type:    MsgTypeNotice,
+		Message: message,
+	}
+}
+
+// NewErrorMessage creates a new structured error message
+func NewErrorMessage(code ErrorCode, detail string) *ErrorMessage {
+	return &ErrorMessage{
+		Type:   MsgTypeError,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// NewMetricsAckMessage creates an acknowledgment for a sequenced metrics message
+func NewMetricsAckMessage(seq uint64, persisted bool) *AckMessage {
+	status := AckStatusAccepted
+	if persisted {
+		status = AckStatusPersisted
+	}
+	return &AckMessage{
+		Type:   MsgTypeAck,
+		Status: status,
+		Seq:    &seq,
+	}
+}
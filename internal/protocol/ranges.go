@@ -0,0 +1,166 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricNames lists the numeric measurements a reading can carry, in the
+// names used by alarm thresholds and range configuration
+var MetricNames = []string{
+	"temperature",
+	"humidity",
+	"precipitation",
+	"wind_speed",
+	"pollution_index",
+	"pollen_index",
+	"pressure",
+	"visibility",
+	"uv_index",
+	"snow_depth",
+}
+
+// Value returns a numeric measurement by name, or nil if the reading doesn't
+// carry it (or the name is unknown)
+func (m *MetricData) Value(name string) *float64 {
+	switch name {
+	case "temperature":
+		return m.Temperature
+	case "humidity":
+		return m.Humidity
+	case "precipitation":
+		return m.Precipitation
+	case "wind_speed":
+		return m.WindSpeed
+	case "pollution_index":
+		return m.PollutionIndex
+	case "pollen_index":
+		return m.PollenIndex
+	case "pressure":
+		return m.Pressure
+	case "visibility":
+		return m.Visibility
+	case "uv_index":
+		return m.UVIndex
+	case "snow_depth":
+		return m.SnowDepth
+	default:
+		return nil
+	}
+}
+
+// Range is an inclusive physical range for a measurement
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// MetricRanges maps metric names to their plausible range, in canonical
+// (metric) units
+type MetricRanges map[string]Range
+
+// DefaultMetricRanges returns ranges outside which a reading can only be a
+// sensor fault
+func DefaultMetricRanges() MetricRanges {
+	return MetricRanges{
+		"temperature":     {Min: -90, Max: 60}, // °C, beyond recorded extremes
+		"humidity":        {Min: 0, Max: 100},  // %
+		"precipitation":   {Min: 0, Max: 500},  // mm
+		"wind_speed":      {Min: 0, Max: 500},  // km/h
+		"pollution_index": {Min: 0, Max: 1000}, // AQI-style index
+		"pollen_index":    {Min: 0, Max: 1000},
+		"pressure":        {Min: 850, Max: 1090}, // hPa, beyond recorded extremes
+		"visibility":      {Min: 0, Max: 500},    // km
+		"uv_index":        {Min: 0, Max: 20},
+		"snow_depth":      {Min: 0, Max: 2000}, // cm
+	}
+}
+
+// ParseMetricRanges applies overrides of the form "metric=min:max" (e.g.
+// "temperature=-60:55") on top of base
+func ParseMetricRanges(base MetricRanges, specs []string) (MetricRanges, error) {
+	ranges := make(MetricRanges, len(base))
+	for name, r := range base {
+		ranges[name] = r
+	}
+
+	for _, spec := range specs {
+		name, bounds, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metric range %q: expected metric=min:max", spec)
+		}
+		name = strings.TrimSpace(name)
+		if !isMetricName(name) {
+			return nil, fmt.Errorf("invalid metric range %q: unknown metric %s", spec, name)
+		}
+		minStr, maxStr, ok := strings.Cut(bounds, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid metric range %q: expected metric=min:max", spec)
+		}
+		min, err := strconv.ParseFloat(strings.TrimSpace(minStr), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric range %q: %w", spec, err)
+		}
+		max, err := strconv.ParseFloat(strings.TrimSpace(maxStr), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric range %q: %w", spec, err)
+		}
+		if min > max {
+			return nil, fmt.Errorf("invalid metric range %q: min exceeds max", spec)
+		}
+		ranges[name] = Range{Min: min, Max: max}
+	}
+	return ranges, nil
+}
+
+func isMetricName(name string) bool {
+	for _, n := range MetricNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RangeViolation describes one measurement outside its range
+type RangeViolation struct {
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+	Range  Range   `json:"range"`
+}
+
+// RangeError lists every out-of-range measurement in a reading
+type RangeError struct {
+	Violations []RangeViolation
+}
+
+func (e *RangeError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = fmt.Sprintf("%s=%g outside [%g, %g]", v.Metric, v.Value, v.Range.Min, v.Range.Max)
+	}
+	return "out of range: " + strings.Join(parts, ", ")
+}
+
+// Check returns a *RangeError if any measurement present in the reading is
+// outside its range. Missing measurements and metrics without a configured
+// range are not checked.
+func (r MetricRanges) Check(data *MetricData) error {
+	var violations []RangeViolation
+	for name, rng := range r {
+		v := data.Value(name)
+		if v == nil {
+			continue
+		}
+		if *v < rng.Min || *v > rng.Max {
+			violations = append(violations, RangeViolation{Metric: name, Value: *v, Range: rng})
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Metric < violations[j].Metric })
+	return &RangeError{Violations: violations}
+}
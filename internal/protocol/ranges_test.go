@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMetricRanges_Check(t *testing.T) {
+	ranges := DefaultMetricRanges()
+
+	ok := MetricData{Temperature: float(22.5), Humidity: float(65)}
+	if err := ranges.Check(&ok); err != nil {
+		t.Errorf("Expected plausible reading to pass, got %v", err)
+	}
+
+	bad := MetricData{Temperature: float(-999), Humidity: float(140), WindSpeed: float(12)}
+	err := ranges.Check(&bad)
+	var rangeErr *RangeError
+	if !errors.As(err, &rangeErr) {
+		t.Fatalf("Expected RangeError, got %v", err)
+	}
+	if len(rangeErr.Violations) != 2 || rangeErr.Violations[0].Metric != "humidity" || rangeErr.Violations[1].Metric != "temperature" {
+		t.Errorf("Unexpected violations: %+v", rangeErr.Violations)
+	}
+}
+
+func TestParseMetricRanges(t *testing.T) {
+	ranges, err := ParseMetricRanges(DefaultMetricRanges(), []string{"temperature=-60:55"})
+	if err != nil {
+		t.Fatalf("Failed to parse ranges: %v", err)
+	}
+	if ranges["temperature"] != (Range{Min: -60, Max: 55}) {
+		t.Errorf("Override not applied: %+v", ranges["temperature"])
+	}
+	if ranges["humidity"] != DefaultMetricRanges()["humidity"] {
+		t.Error("Defaults should be kept for metrics without an override")
+	}
+
+	for _, spec := range []string{"temperature", "dew=0:1", "humidity=100:0", "humidity=a:b"} {
+		if _, err := ParseMetricRanges(nil, []string{spec}); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
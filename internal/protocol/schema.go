@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MetricMessageSchemaVersion is the MetricMessage layout producers write.
+// It is stored in the payload's version field and sent in the
+// schema-version message header.
+//
+// To change the layout without a coordinated deploy of server, dbwriter
+// and alarming:
+//  1. bump MetricMessageSchemaVersion and change MetricMessage
+//  2. append a migration to metricMigrations that rewrites a payload of the
+//     previous version into the new layout (renames, defaults, splits)
+//  3. deploy consumers first: they read both versions, while producers
+//     still write the old one
+//
+// Consumers that meet a version newer than they know reject it with a
+// SchemaVersionError rather than misread it; the message can then be
+// dead-lettered and replayed once they are upgraded.
+const MetricMessageSchemaVersion = 1
+
+// metricMigrations[i] upgrades a payload from version i+1 to version i+2.
+// Payloads without a version field predate versioning and are version 1.
+var metricMigrations []func(fields map[string]json.RawMessage) error
+
+// SchemaVersionError reports a payload newer than this build understands
+type SchemaVersionError struct {
+	Version   int
+	Supported int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("unsupported metric message version %d (this build reads up to %d)", e.Version, e.Supported)
+}
+
+// upgradeMetricMessage rewrites a payload of an older schema version into
+// the current layout. Current payloads are returned untouched.
+func upgradeMetricMessage(data []byte) ([]byte, error) {
+	return upgradeMetricMessageTo(data, MetricMessageSchemaVersion)
+}
+
+func upgradeMetricMessageTo(data []byte, current int) ([]byte, error) {
+	var envelope struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	version := envelope.Version
+	if version == 0 {
+		version = 1
+	}
+	if version == current {
+		return data, nil
+	}
+	if version > current || version < 1 {
+		return nil, &SchemaVersionError{Version: version, Supported: current}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for ; version < current; version++ {
+		if err := metricMigrations[version-1](fields); err != nil {
+			return nil, fmt.Errorf("failed to upgrade metric message from version %d: %w", version, err)
+		}
+	}
+	fields["version"] = json.RawMessage(fmt.Sprint(version))
+	return json.Marshal(fields)
+}
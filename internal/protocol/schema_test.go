@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMetricMigrations_CoverEveryVersion(t *testing.T) {
+	if len(metricMigrations) != MetricMessageSchemaVersion-1 {
+		t.Fatalf("Expected %d migrations for schema version %d, got %d",
+			MetricMessageSchemaVersion-1, MetricMessageSchemaVersion, len(metricMigrations))
+	}
+}
+
+func TestDecodeMetricMessage_Unversioned(t *testing.T) {
+	msg, err := DecodeMetricMessage([]byte(`{"zipcode":"10001","data":{"temperature":21.5}}`))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if msg.Zipcode != "10001" || msg.Data.Temperature == nil || *msg.Data.Temperature != 21.5 {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+}
+
+func TestDecodeMetricMessage_RoundTrip(t *testing.T) {
+	data, err := EncodeMetricMessage(&MetricMessage{Zipcode: "10001"})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	msg, err := DecodeMetricMessage(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if msg.Version != MetricMessageSchemaVersion {
+		t.Errorf("Expected version %d, got %d", MetricMessageSchemaVersion, msg.Version)
+	}
+}
+
+func TestDecodeMetricMessage_NewerVersion(t *testing.T) {
+	_, err := DecodeMetricMessage([]byte(`{"version":99,"zipcode":"10001"}`))
+	var versionErr *SchemaVersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != 99 {
+		t.Errorf("Expected SchemaVersionError for version 99, got %v", err)
+	}
+}
+
+func TestDecodeMetricMessage_Migrates(t *testing.T) {
+	// Pretend the current layout renamed city to city_name
+	saved := metricMigrations
+	defer func() { metricMigrations = saved }()
+	metricMigrations = []func(map[string]json.RawMessage) error{
+		func(fields map[string]json.RawMessage) error {
+			fields["city_name"] = fields["city"]
+			delete(fields, "city")
+			return nil
+		},
+	}
+
+	data, err := upgradeMetricMessageTo([]byte(`{"zipcode":"10001","city":"New York"}`), 2)
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Upgraded payload is invalid: %v", err)
+	}
+	if string(fields["city_name"]) != `"New York"` || fields["city"] != nil || string(fields["version"]) != "2" {
+		t.Errorf("Unexpected upgraded payload: %s", data)
+	}
+}
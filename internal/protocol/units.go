@@ -0,0 +1,55 @@
+package protocol
+
+import "fmt"
+
+// UnitSystem is the unit system a station reports its readings in
+type UnitSystem string
+
+// Supported unit systems. Readings are published in the canonical metric
+// system: temperature in °C, precipitation in mm, wind speed in km/h,
+// pressure in hPa, visibility in km and snow depth in cm. Humidity, wind
+// direction and the pollution/pollen/UV indexes are unitless.
+const (
+	UnitsMetric   UnitSystem = "metric"
+	UnitsImperial UnitSystem = "imperial" // °F, inches, mph, inHg, miles
+)
+
+// ParseUnitSystem parses a unit system name; empty means metric
+func ParseUnitSystem(name string) (UnitSystem, error) {
+	switch u := UnitSystem(name); u {
+	case UnitsMetric, UnitsImperial:
+		return u, nil
+	case "":
+		return UnitsMetric, nil
+	default:
+		return "", fmt.Errorf("unknown unit system: %s", name)
+	}
+}
+
+// Normalize converts a reading from the given unit system to the canonical
+// metric units in place
+func (m *MetricData) Normalize(units UnitSystem) {
+	if units != UnitsImperial {
+		return
+	}
+	m.Temperature = convert(m.Temperature, fahrenheitToCelsius)
+	m.Precipitation = convert(m.Precipitation, func(in float64) float64 { return in * 25.4 })
+	m.WindSpeed = convert(m.WindSpeed, func(mph float64) float64 { return mph * 1.609344 })
+	m.Pressure = convert(m.Pressure, func(inHg float64) float64 { return inHg * 33.8639 })
+	m.Visibility = convert(m.Visibility, func(mi float64) float64 { return mi * 1.609344 })
+	m.SnowDepth = convert(m.SnowDepth, func(in float64) float64 { return in * 2.54 })
+}
+
+// convert applies fn to a measurement if present, without modifying the
+// value the original pointer refers to
+func convert(v *float64, fn func(float64) float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	out := fn(*v)
+	return &out
+}
+
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
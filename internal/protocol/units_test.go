@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestMetricData_NormalizeImperial(t *testing.T) {
+	original := float(212)
+	data := MetricData{Temperature: original, Precipitation: float(1), WindSpeed: float(10), Humidity: float(50),
+		Pressure: float(30), SnowDepth: float(10), UVIndex: float(7)}
+	data.Normalize(UnitsImperial)
+
+	for _, c := range []struct {
+		name string
+		got  *float64
+		want float64
+	}{
+		{"temperature", data.Temperature, 100},
+		{"precipitation", data.Precipitation, 25.4},
+		{"wind_speed", data.WindSpeed, 16.09344},
+		{"humidity", data.Humidity, 50},
+		{"pressure", data.Pressure, 1015.917},
+		{"snow_depth", data.SnowDepth, 25.4},
+		{"uv_index", data.UVIndex, 7},
+	} {
+		if c.got == nil || math.Abs(*c.got-c.want) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, c.got)
+		}
+	}
+	if *original != 212 {
+		t.Error("Normalize must not modify the caller's values")
+	}
+}
+
+func TestMetricData_NormalizeMissing(t *testing.T) {
+	data := MetricData{Humidity: float(40)}
+	data.Normalize(UnitsImperial)
+	if data.Temperature != nil || data.WindSpeed != nil || data.Precipitation != nil {
+		t.Errorf("Missing measurements should stay missing, got %+v", data)
+	}
+}
+
+func TestParseMessage_IdentifyUnits(t *testing.T) {
+	if _, err := ParseMessage([]byte(`{"type":"identify","zipcode":"1","city":"x","units":"imperial"}`)); err != nil {
+		t.Errorf("Expected imperial units to be accepted: %v", err)
+	}
+	if _, err := ParseMessage([]byte(`{"type":"identify","zipcode":"1","city":"x","units":"furlongs"}`)); err == nil {
+		t.Error("Expected unknown units to be rejected")
+	}
+}
+
+func TestParseMessage_PartialMetrics(t *testing.T) {
+	msg, err := ParseMessage([]byte(`{"type":"metrics","data":{"timestamp":"2024-01-15T10:30:00Z","temperature":21.5,"humidity":null}}`))
+	if err != nil {
+		t.Fatalf("Expected partial reading to be accepted: %v", err)
+	}
+	data := msg.(*MetricsMessage).Data
+	if data.Temperature == nil || *data.Temperature != 21.5 || data.Humidity != nil {
+		t.Errorf("Unexpected reading: %+v", data)
+	}
+
+	if _, err := ParseMessage([]byte(`{"type":"metrics","data":{"timestamp":"2024-01-15T10:30:00Z"}}`)); err == nil {
+		t.Error("Expected a reading without measurements to be rejected")
+	}
+}
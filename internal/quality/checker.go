@@ -0,0 +1,174 @@
+package quality
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// Flag marks a measurement as suspect
+type Flag string
+
+const (
+	FlagSpike Flag = "spike" // jumped far from the station's recent readings
+	FlagStuck Flag = "stuck" // identical value for too many readings in a row
+)
+
+// Flags maps metric names to the flag raised for them; empty means good
+type Flags map[string]Flag
+
+// Rule configures the checks for one metric. A zero MaxDeviation disables
+// spike detection; metrics that legitimately stay constant (precipitation,
+// calm wind) should not enable stuck detection.
+type Rule struct {
+	MaxDeviation float64 // max distance from the recent median, in metric units
+	DetectStuck  bool
+}
+
+// Config configures a Checker
+type Config struct {
+	HistorySize   int // readings kept per station for spike detection
+	StuckReadings int // identical consecutive readings before a sensor is stuck
+	Rules         map[string]Rule
+}
+
+// DefaultRules returns rules for the standard metrics
+func DefaultRules() map[string]Rule {
+	return map[string]Rule{
+		"temperature":     {MaxDeviation: 10, DetectStuck: true}, // °C
+		"humidity":        {MaxDeviation: 30, DetectStuck: true}, // %
+		"wind_speed":      {MaxDeviation: 80},                    // km/h
+		"pollution_index": {MaxDeviation: 200},
+		"pressure":        {MaxDeviation: 15, DetectStuck: true}, // hPa
+	}
+}
+
+// metricHistory is the recent history of one metric at one station
+type metricHistory struct {
+	recent []float64 // ring buffer of the last HistorySize values
+	next   int
+	last   float64
+	repeat int       // consecutive readings equal to last
+	at     time.Time // when the last reading was taken
+	flag   Flag      // what the last reading was flagged
+}
+
+// Checker scores readings against each station's recent history. It keeps
+// state in memory, so a station must always be checked by the same process
+// (readings are partitioned by zipcode, which guarantees this).
+type Checker struct {
+	config   Config
+	mu       sync.Mutex
+	stations map[string]map[string]*metricHistory // station key -> metric -> history
+}
+
+// NewChecker creates a new data-quality checker
+func NewChecker(config Config) *Checker {
+	if config.HistorySize <= 0 {
+		config.HistorySize = 6
+	}
+	if config.StuckReadings <= 0 {
+		config.StuckReadings = 12
+	}
+	if config.Rules == nil {
+		config.Rules = DefaultRules()
+	}
+	return &Checker{
+		config:   config,
+		stations: make(map[string]map[string]*metricHistory),
+	}
+}
+
+// Check scores a reading taken at the given time from the given station
+// (e.g. zipcode/station_id) and records it in the station's history. It
+// returns nil if every measurement looks good. A nil Checker flags nothing.
+//
+// Measurements no newer than the station's last are neither scored nor
+// recorded, so retries and redeliveries don't skew the history; one taken
+// at the same time as the last gets the flag that one got.
+func (c *Checker) Check(station string, at time.Time, data *protocol.MetricData) Flags {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics, ok := c.stations[station]
+	if !ok {
+		metrics = make(map[string]*metricHistory)
+		c.stations[station] = metrics
+	}
+
+	var flags Flags
+	for name, rule := range c.config.Rules {
+		v := data.Value(name)
+		if v == nil {
+			continue
+		}
+		h, ok := metrics[name]
+		if !ok {
+			h = &metricHistory{}
+			metrics[name] = h
+		}
+
+		var flag Flag
+		switch {
+		case at.After(h.at):
+			flag = c.score(h, rule, *v)
+			c.record(h, *v)
+			h.at, h.flag = at, flag
+		case at.Equal(h.at):
+			flag = h.flag
+		}
+		if flag != "" {
+			if flags == nil {
+				flags = make(Flags)
+			}
+			flags[name] = flag
+		}
+	}
+	return flags
+}
+
+// score checks a value against the history before it is recorded
+func (c *Checker) score(h *metricHistory, rule Rule, v float64) Flag {
+	if rule.DetectStuck && len(h.recent) > 0 && v == h.last && h.repeat+1 >= c.config.StuckReadings {
+		return FlagStuck
+	}
+	// Need a few readings before the median means anything
+	if rule.MaxDeviation > 0 && len(h.recent) >= 3 {
+		if d := v - median(h.recent); d > rule.MaxDeviation || d < -rule.MaxDeviation {
+			return FlagSpike
+		}
+	}
+	return ""
+}
+
+func (c *Checker) record(h *metricHistory, v float64) {
+	if len(h.recent) > 0 && v == h.last {
+		h.repeat++
+	} else {
+		h.repeat = 1
+	}
+	h.last = v
+
+	if len(h.recent) < c.config.HistorySize {
+		h.recent = append(h.recent, v)
+		return
+	}
+	h.recent[h.next] = v
+	h.next = (h.next + 1) % c.config.HistorySize
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
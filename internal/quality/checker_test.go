@@ -0,0 +1,98 @@
+package quality
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+func reading(temp float64) *protocol.MetricData {
+	return &protocol.MetricData{Temperature: &temp}
+}
+
+// clock returns times a minute apart, as a station reporting every minute
+func clock() func() time.Time {
+	at := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		at = at.Add(time.Minute)
+		return at
+	}
+}
+
+func TestChecker_Spike(t *testing.T) {
+	c := NewChecker(Config{})
+	next := clock()
+
+	for _, temp := range []float64{20, 20.5, 21, 20.8} {
+		if flags := c.Check("10001", next(), reading(temp)); flags != nil {
+			t.Fatalf("Unexpected flags for %v: %v", temp, flags)
+		}
+	}
+
+	if flags := c.Check("10001", next(), reading(85)); flags["temperature"] != FlagSpike {
+		t.Errorf("Expected spike, got %v", flags)
+	}
+	// The spike doesn't poison the median for the next reading
+	if flags := c.Check("10001", next(), reading(21.2)); flags != nil {
+		t.Errorf("Expected normal reading after spike to pass, got %v", flags)
+	}
+	// Other stations have their own history
+	if flags := c.Check("33139", next(), reading(85)); flags != nil {
+		t.Errorf("Expected first reading of another station to pass, got %v", flags)
+	}
+}
+
+func TestChecker_Stuck(t *testing.T) {
+	c := NewChecker(Config{StuckReadings: 4})
+	next := clock()
+
+	for i := 0; i < 3; i++ {
+		if flags := c.Check("10001", next(), reading(18.25)); flags != nil {
+			t.Fatalf("Reading %d flagged too early: %v", i, flags)
+		}
+	}
+	if flags := c.Check("10001", next(), reading(18.25)); flags["temperature"] != FlagStuck {
+		t.Errorf("Expected stuck sensor, got %v", flags)
+	}
+	if flags := c.Check("10001", next(), reading(18.5)); flags != nil {
+		t.Errorf("Expected changed value to pass, got %v", flags)
+	}
+}
+
+func TestChecker_Nil(t *testing.T) {
+	var c *Checker
+	if flags := c.Check("10001", time.Now(), reading(20)); flags != nil {
+		t.Errorf("Nil checker should flag nothing, got %v", flags)
+	}
+}
+
+func TestChecker_Replay(t *testing.T) {
+	c := NewChecker(Config{StuckReadings: 3})
+	next := clock()
+
+	var at time.Time
+	for _, temp := range []float64{20, 20.5, 21} {
+		at = next()
+		c.Check("10001", at, reading(temp))
+	}
+	spikeAt := next()
+	if flags := c.Check("10001", spikeAt, reading(85)); flags["temperature"] != FlagSpike {
+		t.Fatalf("Expected spike, got %v", flags)
+	}
+
+	// A retried reading keeps its flag without being recorded again, and
+	// older ones are ignored
+	for i := 0; i < 3; i++ {
+		if flags := c.Check("10001", spikeAt, reading(85)); flags["temperature"] != FlagSpike {
+			t.Errorf("Expected the retried reading flagged as before, got %v", flags)
+		}
+		if flags := c.Check("10001", at, reading(85)); flags != nil {
+			t.Errorf("Expected an older reading ignored, got %v", flags)
+		}
+	}
+	// Had the retries been recorded, 85 would be stuck and the median
+	if flags := c.Check("10001", next(), reading(21.2)); flags != nil {
+		t.Errorf("Expected the next reading to pass, got %v", flags)
+	}
+}
@@ -2,140 +2,867 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/segmentio/kafka-go"
 	"github.com/smukkama/weather-server/internal/database"
 	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/quality"
+	"github.com/smukkama/weather-server/internal/sink"
 )
 
+// metricStore is where processMessage writes a reading: the connection
+// pool, or the batch's transaction in exactly-once mode
+type metricStore interface {
+	GetLocation(ctx context.Context, zipcode string) (*database.Location, error)
+	UpsertLocation(ctx context.Context, loc *database.Location) error
+	UpsertStation(ctx context.Context, station *database.Station) error
+	InsertRawMetric(ctx context.Context, metric *database.RawMetric) error
+	InsertRawMetricsBatch(ctx context.Context, metrics []*database.RawMetric) error
+}
+
+// CommitPolicy decides how the BatchWriter commits the messages of a
+// written batch
+type CommitPolicy string
+
+const (
+	CommitEachMessage CommitPolicy = "message" // commit every message
+	CommitBatch       CommitPolicy = "batch"   // commit the last message of each partition
+)
+
+// ParseCommitPolicy parses a policy name from configuration
+func ParseCommitPolicy(name string) (CommitPolicy, error) {
+	switch p := CommitPolicy(name); p {
+	case CommitEachMessage, CommitBatch:
+		return p, nil
+	case "":
+		return CommitEachMessage, nil
+	default:
+		return "", fmt.Errorf("unknown commit policy: %s", name)
+	}
+}
+
+// cumulativeCommitter is implemented by consumers whose Commit marks every
+// earlier message of the partition as processed too, as Kafka offsets do
+type cumulativeCommitter interface {
+	commitsCumulatively()
+}
+
 // BatchWriter consumes from Kafka and batch-writes to database
 type BatchWriter struct {
-	consumer      *Consumer
+	consumer      Consumer
 	db            *database.DB
 	batchSize     int
 	flushInterval time.Duration
-	stopCh        chan struct{}
 	wg            sync.WaitGroup
+
+	// Stop consuming, and give up on writes still pending at shutdown
+	cancelConsume context.CancelFunc
+	cancelWrites  context.CancelFunc
+
+	// Optional data-quality scoring against each station's recent readings
+	quality *quality.Checker
+
+	// Optional dead-letter topic for messages that keep failing
+	deadLetters *DeadLetterQueue
+
+	// Consumer group the batch offsets are stored under in exactly-once
+	// mode; empty for at-least-once
+	exactlyOnceGroup string
+
+	// Writers flushing batches in parallel, each owning a share of the
+	// partitions
+	workers []*batchWorker
+
+	// Batches a worker may hold beyond the one it is flushing
+	maxInFlight int
+
+	commitPolicy CommitPolicy
+
+	// Partitions of a cumulative consumer whose commits stopped at a
+	// message that failed, with that message's offset
+	commitMu sync.Mutex
+	held     map[string]int64
+
+	// Messages still unwritten when the writer stopped
+	unwritten atomic.Int64
+
+	// Insert each batch's rows in timestamp order
+	timeOrdered bool
+
+	// Optional systems each written batch is mirrored to
+	sinks []sink.MetricSink
+
+	// Last metadata written per station (zipcode/station_id), so stations
+	// are only upserted when their metadata changes
+	stationsMu sync.Mutex
+	stations   map[string]protocol.StationMetadata
+
+	// Counters across all workers
+	failed        atomic.Int64
+	deadLettered  atomic.Int64
+	skipped       atomic.Int64
+	failedBatches atomic.Int64
+	sinkErrors    atomic.Int64
+	flushTime     atomic.Int64 // total, in nanoseconds
+	maxFlush      atomic.Int64
+	lastFlush     atomic.Int64 // unix nanoseconds
+}
+
+// BatchWriterStats counts what a BatchWriter has done
+type BatchWriterStats struct {
+	Consumed      int64              `json:"consumed"` // messages received from the consumer
+	Written       int64              `json:"written"`  // rows written
+	Failed        int64              `json:"failed"`   // messages that failed and weren't dead-lettered
+	DeadLettered  int64              `json:"dead_lettered"`
+	Skipped       int64              `json:"skipped"`        // already written, in exactly-once mode
+	Batches       int64              `json:"batches"`        // batches flushed
+	FailedBatches int64              `json:"failed_batches"` // flushes that failed and were retried as a whole
+	SinkErrors    int64              `json:"sink_errors"`    // batches a mirror sink failed to write
+	AvgFlush      time.Duration      `json:"avg_flush_ns"`
+	MaxFlush      time.Duration      `json:"max_flush_ns"`
+	LastFlush     time.Time          `json:"last_flush"` // zero before the first flush
+	Workers       []BatchWorkerStats `json:"workers"`
+}
+
+// batchWorker batches and writes the messages of the partitions assigned
+// to it
+type batchWorker struct {
+	id    int
+	input chan Message
+
+	messages atomic.Int64
+	written  atomic.Int64
+	batches  atomic.Int64
+}
+
+// BatchWorkerStats counts what one of a BatchWriter's workers has done
+type BatchWorkerStats struct {
+	Worker   int   `json:"worker"`
+	Messages int64 `json:"messages"` // messages received
+	Written  int64 `json:"written"`  // rows written
+	Batches  int64 `json:"batches"`  // batches flushed
 }
 
 // NewBatchWriter creates a new batch writer
-func NewBatchWriter(consumer *Consumer, db *database.DB, batchSize int, flushInterval time.Duration) *BatchWriter {
+func NewBatchWriter(consumer Consumer, db *database.DB, batchSize int, flushInterval time.Duration) *BatchWriter {
 	return &BatchWriter{
 		consumer:      consumer,
 		db:            db,
 		batchSize:     batchSize,
 		flushInterval: flushInterval,
-		stopCh:        make(chan struct{}),
+		held:          make(map[string]int64),
+		stations:      make(map[string]protocol.StationMetadata),
+		workers:       newBatchWorkers(1, batchSize),
+		maxInFlight:   1,
+		commitPolicy:  CommitEachMessage,
+	}
+}
+
+func newBatchWorkers(count, buffer int) []*batchWorker {
+	workers := make([]*batchWorker, count)
+	for i := range workers {
+		workers[i] = &batchWorker{id: i, input: make(chan Message, buffer)}
+	}
+	return workers
+}
+
+// SetWorkers sets how many writers flush batches in parallel. Each
+// partition is assigned to one writer, so offsets are still committed in
+// order; writers beyond the partition count stay idle. Must be called
+// before Start.
+func (bw *BatchWriter) SetWorkers(count int) {
+	if count < 1 {
+		count = 1
+	}
+	bw.workers = newBatchWorkers(count, bw.batchSize*bw.maxInFlight)
+}
+
+// SetMaxInFlight sets how many batches' worth of messages each worker may
+// hold beyond the batch it is flushing. While a batch can't be written the
+// worker keeps it and stops taking messages once it holds that many, so a
+// database outage applies backpressure instead of growing memory. Must be
+// called before SetWorkers and Start.
+func (bw *BatchWriter) SetMaxInFlight(batches int) {
+	if batches < 1 {
+		batches = 1
+	}
+	bw.maxInFlight = batches
+	bw.workers = newBatchWorkers(len(bw.workers), bw.batchSize*batches)
+}
+
+// SetCommitPolicy sets how written messages are committed. CommitBatch
+// only saves commits on consumers whose commits are cumulative (Kafka,
+// bolt); others still commit every message.
+func (bw *BatchWriter) SetCommitPolicy(policy CommitPolicy) {
+	bw.commitPolicy = policy
+}
+
+// Stats returns the writer's counters, with each worker's
+func (bw *BatchWriter) Stats() BatchWriterStats {
+	stats := BatchWriterStats{
+		Failed:        bw.failed.Load(),
+		DeadLettered:  bw.deadLettered.Load(),
+		Skipped:       bw.skipped.Load(),
+		FailedBatches: bw.failedBatches.Load(),
+		SinkErrors:    bw.sinkErrors.Load(),
+		MaxFlush:      time.Duration(bw.maxFlush.Load()),
+		Workers:       bw.WorkerStats(),
 	}
+	for _, w := range stats.Workers {
+		stats.Consumed += w.Messages
+		stats.Written += w.Written
+		stats.Batches += w.Batches
+	}
+	if flushes := stats.Batches + stats.FailedBatches; flushes > 0 {
+		stats.AvgFlush = time.Duration(bw.flushTime.Load() / flushes)
+	}
+	if last := bw.lastFlush.Load(); last > 0 {
+		stats.LastFlush = time.Unix(0, last)
+	}
+	return stats
+}
+
+// WorkerStats returns each worker's counters
+func (bw *BatchWriter) WorkerStats() []BatchWorkerStats {
+	stats := make([]BatchWorkerStats, len(bw.workers))
+	for i, w := range bw.workers {
+		stats[i] = BatchWorkerStats{
+			Worker:   w.id,
+			Messages: w.messages.Load(),
+			Written:  w.written.Load(),
+			Batches:  w.batches.Load(),
+		}
+	}
+	return stats
+}
+
+// SetQualityChecker enables data-quality flags on stored readings
+func (bw *BatchWriter) SetQualityChecker(checker *quality.Checker) {
+	bw.quality = checker
+}
+
+// SetDeadLetterQueue retries messages that fail to process and
+// dead-letters them when they keep failing, instead of leaving them
+// uncommitted
+func (bw *BatchWriter) SetDeadLetterQueue(dlq *DeadLetterQueue) {
+	bw.deadLetters = dlq
+}
+
+// SetExactlyOnce writes each batch in one transaction together with the
+// offsets it reaches, stored under groupID, and skips messages at or below
+// the stored offsets. A crash between the insert and the queue commit then
+// redelivers messages that are recognised as already written, instead of
+// inserting them twice. The backend's offsets must be stable across
+// restarts.
+func (bw *BatchWriter) SetExactlyOnce(groupID string) {
+	bw.exactlyOnceGroup = groupID
+}
+
+// SetTimeOrdered sorts each batch's rows by timestamp before inserting
+// them. Partitions interleave stations that report at different times; in
+// order, a multi-row INSERT into a TimescaleDB hypertable touches each
+// time chunk once.
+func (bw *BatchWriter) SetTimeOrdered(ordered bool) {
+	bw.timeOrdered = ordered
+}
+
+// SetSinks mirrors every batch written to Postgres into sinks, before its
+// messages are committed. A sink that fails only logs and counts the
+// error: Postgres stays the system of record and an outage elsewhere
+// doesn't hold up the pipeline.
+func (bw *BatchWriter) SetSinks(sinks ...sink.MetricSink) {
+	bw.sinks = sinks
 }
 
-// Start begins consuming and writing to database
+// Start begins consuming and writing to database. Consuming stops when
+// ctx is done or Stop is called; batches already read are still written
+// until Stop gives up on them.
 func (bw *BatchWriter) Start(ctx context.Context) error {
+	consumeCtx, cancelConsume := context.WithCancel(ctx)
+	writeCtx, cancelWrites := context.WithCancel(context.WithoutCancel(ctx))
+	bw.cancelConsume = cancelConsume
+	bw.cancelWrites = cancelWrites
+
 	bw.wg.Add(1)
-	go bw.run(ctx)
+	go bw.run(consumeCtx, writeCtx)
 	return nil
 }
 
-// Stop stops the batch writer gracefully
-func (bw *BatchWriter) Stop() {
-	close(bw.stopCh)
-	bw.wg.Wait()
+// Stop stops consuming, writes the batches already read and commits what
+// was written. If ctx ends first, pending writes are abandoned and their
+// messages left uncommitted for redelivery. The error sums up what wasn't
+// written or committed.
+func (bw *BatchWriter) Stop(ctx context.Context) error {
+	if bw.cancelConsume == nil {
+		return nil
+	}
+	bw.cancelConsume()
+
+	done := make(chan struct{})
+	go func() {
+		bw.wg.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("gave up on pending writes: %w", ctx.Err()))
+		bw.cancelWrites()
+		<-done
+	}
+	bw.cancelWrites()
+
+	if n := bw.unwritten.Load(); n > 0 {
+		errs = append(errs, fmt.Errorf("%d message(s) not written, left uncommitted for redelivery", n))
+	}
+	bw.commitMu.Lock()
+	for partition, offset := range bw.held {
+		errs = append(errs, fmt.Errorf("commits on %s held at offset %d after a failed message", partition, offset))
+	}
+	bw.commitMu.Unlock()
+	return errors.Join(errs...)
 }
 
-func (bw *BatchWriter) run(ctx context.Context) {
+func (bw *BatchWriter) run(consumeCtx, writeCtx context.Context) {
 	defer bw.wg.Done()
 
-	var batch []kafka.Message
-	ticker := time.NewTicker(bw.flushInterval)
-	defer ticker.Stop()
+	var workers sync.WaitGroup
+	for _, w := range bw.workers {
+		workers.Add(1)
+		go func(w *batchWorker) {
+			defer workers.Done()
+			bw.runWorker(writeCtx, w)
+		}(w)
+	}
 
-	// Consume messages in a goroutine (like your test program)
-	msgChan := make(chan kafka.Message, 10)
+	// Consume in a goroutine so a Consume waiting for a message doesn't
+	// hold up dispatching; it closes msgChan once consuming stops
+	msgChan := make(chan Message, 10)
 	go func() {
+		defer close(msgChan)
 		for {
-			msg, err := bw.consumer.Consume(ctx)
+			msg, err := bw.consumer.Consume(consumeCtx)
 			if err != nil {
+				if consumeCtx.Err() != nil {
+					return
+				}
 				fmt.Printf("Consumer error: %v\n", err)
 				continue
 			}
-			msgChan <- msg
+			select {
+			case msgChan <- msg:
+			case <-consumeCtx.Done():
+				// Not committed, so it is redelivered
+				return
+			}
 		}
 	}()
 
-	for {
+	for msg := range msgChan {
+		fmt.Printf("Consumed message from topic (partition=%d, offset=%d)\n",
+			msg.Partition, msg.Offset)
+		// One worker per partition keeps each partition's offsets in order
+		w := bw.workers[msg.Partition%len(bw.workers)]
 		select {
-		case <-bw.stopCh:
-			// Flush remaining batch before stopping
-			if len(batch) > 0 {
-				bw.flush(ctx, batch)
-			}
+		case w.input <- msg:
+		case <-writeCtx.Done():
+			bw.unwritten.Add(1)
+		}
+	}
+
+	for _, w := range bw.workers {
+		close(w.input)
+	}
+	workers.Wait()
+}
+
+// runWorker batches the worker's messages and flushes them when the batch
+// is full or the flush interval passes, until its input is closed
+func (bw *BatchWriter) runWorker(ctx context.Context, w *batchWorker) {
+	var batch []Message
+	ticker := time.NewTicker(bw.flushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		start := time.Now()
+		written, ok := bw.flush(ctx, batch)
+		bw.recordFlush(time.Since(start))
+		if !ok {
+			bw.failedBatches.Add(1)
 			return
+		}
+		w.written.Add(int64(written))
+		w.batches.Add(1)
+		batch = nil
+	}
 
-		case <-ticker.C:
-			// Periodic flush
-			if len(batch) > 0 {
-				fmt.Printf("Flush interval reached (%d messages), flushing...\n", len(batch))
-				bw.flush(ctx, batch)
-				batch = nil
-			}
+	for {
+		// A batch that failed to flush keeps growing until it holds
+		// maxInFlight batches; then stop taking messages until it's written
+		input := w.input
+		if len(batch) >= bw.batchSize*(bw.maxInFlight+1) {
+			input = nil
+		}
 
-		case msg := <-msgChan:
-			fmt.Printf("Consumed message from topic (partition=%d, offset=%d)\n",
-				msg.Partition, msg.Offset)
+		select {
+		case msg, ok := <-input:
+			if !ok {
+				// Write the remaining batch before stopping, retrying
+				// until it is written or Stop gives up on it
+				for len(batch) > 0 {
+					flush()
+					if len(batch) == 0 {
+						break
+					}
+					select {
+					case <-ctx.Done():
+						bw.unwritten.Add(int64(len(batch)))
+						return
+					case <-ticker.C:
+					}
+				}
+				return
+			}
+			w.messages.Add(1)
 			batch = append(batch, msg)
 
 			// Flush if batch is full
 			if len(batch) >= bw.batchSize {
 				fmt.Printf("Batch full (%d messages), flushing...\n", len(batch))
-				bw.flush(ctx, batch)
-				batch = nil
+				flush()
 			}
+
+		case <-ticker.C:
+			// Periodic flush
+			if len(batch) > 0 {
+				fmt.Printf("Flush interval reached (%d messages), flushing...\n", len(batch))
+				flush()
+			}
+		}
+	}
+}
+
+// recordFlush adds a flush's duration to the latency counters
+func (bw *BatchWriter) recordFlush(d time.Duration) {
+	bw.flushTime.Add(int64(d))
+	bw.lastFlush.Store(time.Now().UnixNano())
+	for {
+		max := bw.maxFlush.Load()
+		if int64(d) <= max || bw.maxFlush.CompareAndSwap(max, int64(d)) {
+			return
 		}
 	}
 }
 
-func (bw *BatchWriter) flush(ctx context.Context, batch []kafka.Message) {
+// flush writes a batch, returning how many rows it wrote, or false if the
+// batch should be retried as a whole
+func (bw *BatchWriter) flush(ctx context.Context, batch []Message) (int, bool) {
 	if len(batch) == 0 {
-		return
+		return 0, true
+	}
+	// Wait out a database outage rather than failing (and dead-lettering)
+	// the batch message by message
+	if err := bw.waitForDatabase(ctx); err != nil {
+		return 0, false
+	}
+	if bw.exactlyOnceGroup != "" {
+		written, err := bw.flushTransaction(ctx, batch)
+		if err != nil {
+			fmt.Printf("Failed to write batch of %d messages, retrying on next flush: %v\n", len(batch), err)
+			// The station cache may hold upserts that were rolled back
+			bw.stationsMu.Lock()
+			bw.stations = make(map[string]protocol.StationMetadata)
+			bw.stationsMu.Unlock()
+			return 0, false
+		}
+		return written, true
 	}
 
-	successCount := 0
+	var commit []Message
+	var rows []pendingRow
 	for _, msg := range batch {
-		if err := bw.processMessage(msg); err != nil {
+		var metric *database.RawMetric
+		err := bw.deadLetters.Handle(ctx, msg, func() error {
+			return bw.whileDatabaseUp(ctx, func() error {
+				var err error
+				metric, err = bw.prepareMessage(ctx, bw.db, msg)
+				return err
+			})
+		})
+		if err != nil {
 			fmt.Printf("Failed to process message: %v\n", err)
+			bw.failed.Add(1)
+			continue
+		}
+		if metric == nil {
+			bw.deadLettered.Add(1)
+			commit = append(commit, msg)
 			continue
 		}
-		successCount++
+		rows = append(rows, pendingRow{msg: msg, metric: metric})
+	}
+
+	done, metrics := bw.writeRows(ctx, bw.db, rows, func(fn func() error) error {
+		return bw.whileDatabaseUp(ctx, fn)
+	}, false)
+	written := len(metrics)
+	bw.mirror(ctx, metrics)
+
+	// Commit offsets after successful processing
+	bw.commit(ctx, batch, append(commit, done...))
+
+	fmt.Printf("Flushed batch of %d messages to database\n", written)
+	return written, true
+}
+
+// mirror writes a batch's readings to the sinks in parallel
+func (bw *BatchWriter) mirror(ctx context.Context, metrics []*database.RawMetric) {
+	if len(metrics) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, s := range bw.sinks {
+		wg.Add(1)
+		go func(s sink.MetricSink) {
+			defer wg.Done()
+			if err := s.WriteBatch(ctx, metrics); err != nil {
+				fmt.Printf("Failed to write batch of %d metrics to %s sink: %v\n", len(metrics), s.Name(), err)
+				bw.sinkErrors.Add(1)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// commit commits the messages of batch that are in done: written, or
+// dead-lettered. A cumulative consumer's commit would also cover earlier
+// messages, so on those each partition is only committed up to its first
+// message that isn't done, and no further until restart, when that
+// message is redelivered.
+func (bw *BatchWriter) commit(ctx context.Context, batch, done []Message) {
+	if _, ok := bw.consumer.(cumulativeCommitter); !ok {
+		for _, msg := range done {
+			bw.commitMessage(ctx, msg)
+		}
+		return
+	}
+
+	type position struct {
+		partition string
+		offset    int64
+	}
+	isDone := make(map[position]bool, len(done))
+	for _, msg := range done {
+		isDone[position{partitionKey(msg), msg.Offset}] = true
+	}
+
+	var commit []Message
+	last := make(map[string]int)
+	bw.commitMu.Lock()
+	for _, msg := range batch {
+		partition := partitionKey(msg)
+		if _, held := bw.held[partition]; held {
+			continue
+		}
+		if !isDone[position{partition, msg.Offset}] {
+			bw.held[partition] = msg.Offset
+			fmt.Printf("Holding commits on %s at offset %d after a failed message; it is redelivered on restart\n", partition, msg.Offset)
+			continue
+		}
+		if bw.commitPolicy == CommitBatch {
+			if i, ok := last[partition]; ok {
+				commit[i] = msg
+				continue
+			}
+			last[partition] = len(commit)
+		}
+		commit = append(commit, msg)
+	}
+	bw.commitMu.Unlock()
+
+	for _, msg := range commit {
+		bw.commitMessage(ctx, msg)
+	}
+}
+
+func (bw *BatchWriter) commitMessage(ctx context.Context, msg Message) {
+	if err := bw.consumer.Commit(ctx, msg); err != nil {
+		fmt.Printf("Failed to commit offset: %v\n", err)
+	}
+}
+
+// partitionKey names a message's partition, e.g. weather.metrics/3
+func partitionKey(msg Message) string {
+	return fmt.Sprintf("%s/%d", msg.Topic, msg.Partition)
+}
+
+// waitForDatabase pauses consuming while the database is unreachable
+func (bw *BatchWriter) waitForDatabase(ctx context.Context) error {
+	return PauseWhileDown(ctx, bw.consumer, "Database", bw.flushInterval, bw.db.CheckHealth)
+}
+
+// whileDatabaseUp runs fn, and runs it again after waiting out a database
+// outage if it failed because the database went down
+func (bw *BatchWriter) whileDatabaseUp(ctx context.Context, fn func() error) error {
+	for {
+		err := fn()
+		if err == nil || IsPermanent(err) || bw.db.CheckHealth(ctx) == nil {
+			return err
+		}
+		if err := bw.waitForDatabase(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// pendingRow is a decoded reading waiting for the batch insert
+type pendingRow struct {
+	msg    Message
+	metric *database.RawMetric
+}
+
+// writeRows inserts rows with one multi-row INSERT, each statement run
+// through guard. If that fails it inserts them one at a time, so one bad
+// row doesn't fail the others and is retried or dead-lettered on its own.
+// allOrNothing says a failed batch insert wrote nothing; otherwise rows
+// that got an ID were written and aren't inserted again. It returns the
+// messages of the rows that were written or dead-lettered, and the rows
+// that were written.
+func (bw *BatchWriter) writeRows(ctx context.Context, store metricStore, rows []pendingRow, guard func(func() error) error, allOrNothing bool) ([]Message, []*database.RawMetric) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	metrics := make([]*database.RawMetric, len(rows))
+	for i, row := range rows {
+		metrics[i] = row.metric
+	}
+	if bw.timeOrdered {
+		// Only the insert is reordered; messages stay in offset order so
+		// commits never move an offset back
+		sort.SliceStable(metrics, func(i, j int) bool {
+			return metrics[i].Timestamp.Before(metrics[j].Timestamp)
+		})
+	}
+	err := guard(func() error {
+		return store.InsertRawMetricsBatch(ctx, metrics)
+	})
+
+	done := make([]Message, 0, len(rows))
+	if err == nil {
+		for _, row := range rows {
+			done = append(done, row.msg)
+		}
+		return done, metrics
+	}
+	fmt.Printf("Batch insert of %d rows failed, inserting them one at a time: %v\n", len(rows), err)
+
+	var written []*database.RawMetric
+
+	for _, row := range rows {
+		if allOrNothing {
+			row.metric.ID = 0
+		} else if row.metric.ID != 0 {
+			done = append(done, row.msg)
+			written = append(written, row.metric)
+			continue
+		}
+		inserted := false
+		err := bw.deadLetters.Handle(ctx, row.msg, func() error {
+			return guard(func() error {
+				err := store.InsertRawMetric(ctx, row.metric)
+				inserted = err == nil
+				return err
+			})
+		})
+		if err != nil {
+			fmt.Printf("Failed to insert metric: %v\n", err)
+			bw.failed.Add(1)
+			continue
+		}
+		if inserted {
+			written = append(written, row.metric)
+		} else {
+			bw.deadLettered.Add(1)
+		}
+		done = append(done, row.msg)
+	}
+	return done, written
+}
+
+// inSavepoint runs fn under a savepoint, rolling back what it did if it
+// fails so the transaction can go on
+func inSavepoint(ctx context.Context, tx *database.Tx, fn func() error) error {
+	if err := tx.Savepoint(ctx, "message"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rollbackErr := tx.RollbackTo(ctx, "message"); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return tx.ReleaseSavepoint(ctx, "message")
+}
+
+// flushTransaction writes a batch and the offsets it reaches in one
+// transaction, then commits the messages to the queue. Each message runs
+// under a savepoint so one failing message doesn't abort the batch; what
+// happens to it then is the same as in at-least-once mode. A partition's
+// stored offset stops short of its first message that failed, and stays
+// there until restart, when that message is redelivered.
+func (bw *BatchWriter) flushTransaction(ctx context.Context, batch []Message) (int, error) {
+	tx, err := bw.db.BeginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stored := make(map[string]map[int]int64)
+	var rows []pendingRow
+	var done []Message
+	skipped := 0
+	for _, msg := range batch {
+		offsets, ok := stored[msg.Topic]
+		if !ok {
+			if offsets, err = tx.ConsumerOffsets(ctx, bw.exactlyOnceGroup, msg.Topic); err != nil {
+				return 0, fmt.Errorf("failed to read consumer offsets: %w", err)
+			}
+			stored[msg.Topic] = offsets
+		}
+		if last, ok := offsets[msg.Partition]; ok && msg.Offset <= last {
+			skipped++
+			done = append(done, msg)
+			continue
+		}
+
+		var metric *database.RawMetric
+		err := bw.deadLetters.Handle(ctx, msg, func() error {
+			return inSavepoint(ctx, tx, func() error {
+				var err error
+				metric, err = bw.prepareMessage(ctx, tx, msg)
+				return err
+			})
+		})
+		if err != nil {
+			fmt.Printf("Failed to process message: %v\n", err)
+			bw.failed.Add(1)
+		} else if metric != nil {
+			rows = append(rows, pendingRow{msg: msg, metric: metric})
+		} else {
+			bw.deadLettered.Add(1)
+			done = append(done, msg)
+		}
+	}
+
+	written, metrics := bw.writeRows(ctx, tx, rows, func(fn func() error) error {
+		return inSavepoint(ctx, tx, fn)
+	}, true)
+	done = append(done, written...)
+
+	reached, failedAt := bw.reachedOffsets(batch, done)
+	for topic, partitions := range reached {
+		for partition, offset := range partitions {
+			if err := tx.SetConsumerOffset(ctx, bw.exactlyOnceGroup, topic, partition, offset); err != nil {
+				return 0, fmt.Errorf("failed to store consumer offset: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
-		// Commit offset after successful processing
-		if err := bw.consumer.Commit(ctx, msg); err != nil {
-			fmt.Printf("Failed to commit offset: %v\n", err)
+	// Committed readings are safe now; a failed queue commit only means
+	// redelivered messages get skipped
+	bw.mirror(ctx, metrics)
+	bw.commit(ctx, batch, done)
+	bw.commitMu.Lock()
+	for partition, offset := range failedAt {
+		if _, held := bw.held[partition]; !held {
+			bw.held[partition] = offset
+			fmt.Printf("Holding offsets on %s at offset %d after a failed message; it is redelivered on restart\n", partition, offset)
 		}
 	}
+	bw.commitMu.Unlock()
 
-	fmt.Printf("Flushed batch of %d messages to database\n", successCount)
+	bw.skipped.Add(int64(skipped))
+	if skipped > 0 {
+		fmt.Printf("Flushed batch of %d messages to database (%d already written, skipped)\n", len(metrics), skipped)
+	} else {
+		fmt.Printf("Flushed batch of %d messages to database\n", len(metrics))
+	}
+	return len(metrics), nil
 }
 
-func (bw *BatchWriter) processMessage(msg kafka.Message) error {
+// reachedOffsets returns the offset each partition of batch can be stored
+// at: its last message before the first one that isn't done, and before
+// any offset already held. failedAt has the offset of each partition's
+// first message that isn't done and wasn't held before.
+func (bw *BatchWriter) reachedOffsets(batch, done []Message) (reached map[string]map[int]int64, failedAt map[string]int64) {
+	type position struct {
+		partition string
+		offset    int64
+	}
+	isDone := make(map[position]bool, len(done))
+	for _, msg := range done {
+		isDone[position{partitionKey(msg), msg.Offset}] = true
+	}
+
+	bw.commitMu.Lock()
+	held := make(map[string]bool, len(bw.held))
+	for partition := range bw.held {
+		held[partition] = true
+	}
+	bw.commitMu.Unlock()
+
+	reached = make(map[string]map[int]int64)
+	failedAt = make(map[string]int64)
+	for _, msg := range batch {
+		partition := partitionKey(msg)
+		if held[partition] {
+			continue
+		}
+		if !isDone[position{partition, msg.Offset}] {
+			held[partition] = true
+			failedAt[partition] = msg.Offset
+			continue
+		}
+		if reached[msg.Topic] == nil {
+			reached[msg.Topic] = make(map[int]int64)
+		}
+		if offset, ok := reached[msg.Topic][msg.Partition]; !ok || msg.Offset > offset {
+			reached[msg.Topic][msg.Partition] = msg.Offset
+		}
+	}
+	return reached, failedAt
+}
+
+// prepareMessage decodes a message into the row to insert, making sure its
+// location and station exist first
+func (bw *BatchWriter) prepareMessage(ctx context.Context, store metricStore, msg Message) (*database.RawMetric, error) {
 	// Decode Kafka message
 	metricMsg, err := protocol.DecodeMetricMessage(msg.Value)
 	if err != nil {
-		return fmt.Errorf("failed to decode message: %w", err)
+		return nil, Permanent(fmt.Errorf("failed to decode message: %w", err))
 	}
 
 	// Parse metric data
 	parsedData, err := metricMsg.Data.Parse()
 	if err != nil {
-		return fmt.Errorf("failed to parse metric data: %w", err)
+		return nil, Permanent(fmt.Errorf("failed to parse metric data: %w", err))
 	}
 
 	// Ensure location exists
-	location, err := bw.db.GetLocation(metricMsg.Zipcode)
+	location, err := store.GetLocation(ctx, metricMsg.Zipcode)
 	if err != nil {
-		return fmt.Errorf("failed to get location: %w", err)
+		return nil, fmt.Errorf("failed to get location: %w", err)
 	}
 
 	if location == nil {
@@ -144,28 +871,81 @@ func (bw *BatchWriter) processMessage(msg kafka.Message) error {
 			Zipcode:  metricMsg.Zipcode,
 			CityName: metricMsg.City,
 		}
-		if err := bw.db.UpsertLocation(newLocation); err != nil {
-			return fmt.Errorf("failed to create location: %w", err)
+		if err := store.UpsertLocation(ctx, newLocation); err != nil {
+			return nil, fmt.Errorf("failed to create location: %w", err)
+		}
+	}
+
+	if metricMsg.Station != nil {
+		if err := bw.upsertStation(ctx, store, metricMsg); err != nil {
+			return nil, fmt.Errorf("failed to upsert station: %w", err)
 		}
 	}
 
-	// Insert raw metric
 	rawMetric := &database.RawMetric{
 		Zipcode:        metricMsg.Zipcode,
+		StationID:      metricMsg.StationID,
 		Timestamp:      parsedData.Timestamp,
-		Temperature:    &parsedData.Temperature,
-		Humidity:       &parsedData.Humidity,
-		Precipitation:  &parsedData.Precipitation,
-		WindSpeed:      &parsedData.WindSpeed,
-		WindDirection:  &parsedData.WindDirection,
-		PollutionIndex: &parsedData.PollutionIndex,
-		PollenIndex:    &parsedData.PollenIndex,
+		Temperature:    parsedData.Temperature,
+		Humidity:       parsedData.Humidity,
+		Precipitation:  parsedData.Precipitation,
+		WindSpeed:      parsedData.WindSpeed,
+		WindDirection:  parsedData.WindDirection,
+		PollutionIndex: parsedData.PollutionIndex,
+		PollenIndex:    parsedData.PollenIndex,
+		Pressure:       parsedData.Pressure,
+		Visibility:     parsedData.Visibility,
+		UVIndex:        parsedData.UVIndex,
+		SnowDepth:      parsedData.SnowDepth,
 		ReceivedAt:     metricMsg.ReceivedAt,
 	}
+	if d := metricMsg.Derived; d != nil {
+		rawMetric.HeatIndex = d.HeatIndex
+		rawMetric.WindChill = d.WindChill
+		rawMetric.DewPoint = d.DewPoint
+	}
+	if flags := bw.quality.Check(metricMsg.Zipcode+"/"+metricMsg.StationID, parsedData.Timestamp, &metricMsg.Data); len(flags) > 0 {
+		rawMetric.QualityFlags = make(map[string]string, len(flags))
+		for metric, flag := range flags {
+			rawMetric.QualityFlags[metric] = string(flag)
+		}
+		fmt.Printf("Flagged reading from zipcode %s: %v\n", metricMsg.Zipcode, rawMetric.QualityFlags)
+	}
 
-	if err := bw.db.InsertRawMetric(rawMetric); err != nil {
-		return fmt.Errorf("failed to insert metric: %w", err)
+	return rawMetric, nil
+}
+
+// upsertStation persists the station metadata carried by a metric message
+// unless it matches what was last written for that station
+func (bw *BatchWriter) upsertStation(ctx context.Context, store metricStore, metricMsg *protocol.MetricMessage) error {
+	key := metricMsg.Zipcode + "/" + metricMsg.StationID
+	bw.stationsMu.Lock()
+	last, ok := bw.stations[key]
+	bw.stationsMu.Unlock()
+	if ok && last.Equal(metricMsg.Station) {
+		return nil
+	}
+
+	meta := metricMsg.Station
+	station := &database.Station{
+		Zipcode:   metricMsg.Zipcode,
+		StationID: metricMsg.StationID,
+		Lat:       meta.Latitude,
+		Lon:       meta.Longitude,
+		Elevation: meta.Elevation,
+	}
+	if meta.Model != "" {
+		station.Model = &meta.Model
+	}
+	if meta.FirmwareVersion != "" {
+		station.FirmwareVersion = &meta.FirmwareVersion
+	}
+	if err := store.UpsertStation(ctx, station); err != nil {
+		return err
 	}
 
+	bw.stationsMu.Lock()
+	bw.stations[key] = *meta
+	bw.stationsMu.Unlock()
 	return nil
 }
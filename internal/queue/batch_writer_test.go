@@ -0,0 +1,226 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+func TestBatchWriter_Stats(t *testing.T) {
+	bw := NewBatchWriter(nil, nil, 10, time.Second)
+	bw.SetWorkers(2)
+
+	bw.workers[0].messages.Add(3)
+	bw.workers[0].written.Add(3)
+	bw.workers[0].batches.Add(1)
+	bw.workers[1].messages.Add(2)
+	bw.workers[1].written.Add(1)
+	bw.workers[1].batches.Add(1)
+	bw.failed.Add(1)
+	bw.recordFlush(10 * time.Millisecond)
+	bw.recordFlush(30 * time.Millisecond)
+
+	stats := bw.Stats()
+	if stats.Consumed != 5 || stats.Written != 4 || stats.Batches != 2 || stats.Failed != 1 {
+		t.Errorf("Unexpected totals: %+v", stats)
+	}
+	if stats.AvgFlush != 20*time.Millisecond || stats.MaxFlush != 30*time.Millisecond {
+		t.Errorf("Expected avg 20ms and max 30ms, got %v and %v", stats.AvgFlush, stats.MaxFlush)
+	}
+	if stats.LastFlush.IsZero() || len(stats.Workers) != 2 {
+		t.Errorf("Expected a last flush time and 2 workers, got %+v", stats)
+	}
+}
+
+type recordingConsumer struct {
+	Consumer
+	commits []Message
+}
+
+func (c *recordingConsumer) Commit(ctx context.Context, msg Message) error {
+	c.commits = append(c.commits, msg)
+	return nil
+}
+
+type cumulativeRecordingConsumer struct{ recordingConsumer }
+
+func (c *cumulativeRecordingConsumer) commitsCumulatively() {}
+
+func TestBatchWriter_CommitPolicy(t *testing.T) {
+	msgs := []Message{
+		{Topic: "metrics", Partition: 0, Offset: 1},
+		{Topic: "metrics", Partition: 1, Offset: 7},
+		{Topic: "metrics", Partition: 0, Offset: 2},
+		{Topic: "metrics", Partition: 0, Offset: 3},
+	}
+
+	cumulative := &cumulativeRecordingConsumer{}
+	bw := NewBatchWriter(cumulative, nil, 10, time.Second)
+	bw.SetCommitPolicy(CommitBatch)
+	bw.commit(context.Background(), msgs, msgs)
+	if len(cumulative.commits) != 2 {
+		t.Fatalf("Expected one commit per partition, got %+v", cumulative.commits)
+	}
+	for _, msg := range cumulative.commits {
+		if msg.Partition == 0 && msg.Offset != 3 {
+			t.Errorf("Expected partition 0 committed at offset 3, got %d", msg.Offset)
+		}
+	}
+
+	// Consumers acking messages one by one still commit every message
+	plain := &recordingConsumer{}
+	bw = NewBatchWriter(plain, nil, 10, time.Second)
+	bw.SetCommitPolicy(CommitBatch)
+	bw.commit(context.Background(), msgs, msgs)
+	if len(plain.commits) != len(msgs) {
+		t.Errorf("Expected %d commits, got %d", len(msgs), len(plain.commits))
+	}
+}
+
+func TestBatchWriter_CommitHeldAfterFailure(t *testing.T) {
+	batch := []Message{
+		{Topic: "metrics", Partition: 0, Offset: 1},
+		{Topic: "metrics", Partition: 0, Offset: 2},
+		{Topic: "metrics", Partition: 0, Offset: 3},
+		{Topic: "metrics", Partition: 1, Offset: 5},
+	}
+	done := []Message{batch[0], batch[2], batch[3]}
+
+	consumer := &cumulativeRecordingConsumer{}
+	bw := NewBatchWriter(consumer, nil, 10, time.Second)
+	bw.commit(context.Background(), batch, done)
+
+	// Committing offset 3 would skip the failed offset 2
+	for _, msg := range consumer.commits {
+		if msg.Partition == 0 && msg.Offset > 1 {
+			t.Errorf("Expected partition 0 committed no further than offset 1, got %d", msg.Offset)
+		}
+	}
+	if len(consumer.commits) != 2 {
+		t.Errorf("Expected offsets 1 and 5 committed, got %+v", consumer.commits)
+	}
+
+	// Later batches of the partition stay uncommitted too
+	consumer.commits = nil
+	next := []Message{{Topic: "metrics", Partition: 0, Offset: 4}}
+	bw.commit(context.Background(), next, next)
+	if len(consumer.commits) != 0 {
+		t.Errorf("Expected no commits on a held partition, got %+v", consumer.commits)
+	}
+}
+
+func TestBatchWriter_ReachedOffsetsStopAtFailure(t *testing.T) {
+	batch := []Message{
+		{Topic: "metrics", Partition: 0, Offset: 1},
+		{Topic: "metrics", Partition: 0, Offset: 2},
+		{Topic: "metrics", Partition: 0, Offset: 3},
+		{Topic: "metrics", Partition: 1, Offset: 5},
+		{Topic: "metrics", Partition: 2, Offset: 9},
+	}
+	done := []Message{batch[0], batch[2], batch[3], batch[4]}
+
+	bw := NewBatchWriter(&recordingConsumer{}, nil, 10, time.Second)
+	bw.held["metrics/2"] = 8
+	reached, failedAt := bw.reachedOffsets(batch, done)
+
+	// Storing offset 3 would skip the failed offset 2 on restart
+	if offset, ok := reached["metrics"][0]; !ok || offset != 1 {
+		t.Errorf("Expected partition 0 stored at offset 1, got %d (%v)", offset, ok)
+	}
+	if offset := reached["metrics"][1]; offset != 5 {
+		t.Errorf("Expected partition 1 stored at offset 5, got %d", offset)
+	}
+	if _, ok := reached["metrics"][2]; ok {
+		t.Error("Expected nothing stored on a partition already held")
+	}
+	if len(failedAt) != 1 || failedAt["metrics/0"] != 2 {
+		t.Errorf("Expected partition 0 to fail at offset 2, got %v", failedAt)
+	}
+}
+
+// blockingConsumer has no messages and waits in Consume until its context
+// is done
+type blockingConsumer struct {
+	recordingConsumer
+	returned chan struct{}
+}
+
+func (c *blockingConsumer) Consume(ctx context.Context) (Message, error) {
+	<-ctx.Done()
+	close(c.returned)
+	return Message{}, ctx.Err()
+}
+
+func TestBatchWriter_Stop(t *testing.T) {
+	consumer := &blockingConsumer{returned: make(chan struct{})}
+	bw := NewBatchWriter(consumer, nil, 10, time.Second)
+	if err := bw.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := bw.Stop(ctx); err != nil {
+		t.Errorf("Expected a clean stop, got %v", err)
+	}
+	select {
+	case <-consumer.returned:
+	default:
+		t.Error("Expected Stop to end the pending Consume")
+	}
+}
+
+func TestParseCommitPolicy(t *testing.T) {
+	if p, err := ParseCommitPolicy(""); err != nil || p != CommitEachMessage {
+		t.Errorf("Expected default policy %q, got %q (%v)", CommitEachMessage, p, err)
+	}
+	if p, err := ParseCommitPolicy("batch"); err != nil || p != CommitBatch {
+		t.Errorf("Expected %q, got %q (%v)", CommitBatch, p, err)
+	}
+	if _, err := ParseCommitPolicy("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+type recordingStore struct {
+	metricStore
+	batches [][]*database.RawMetric
+}
+
+func (s *recordingStore) InsertRawMetricsBatch(ctx context.Context, metrics []*database.RawMetric) error {
+	s.batches = append(s.batches, append([]*database.RawMetric(nil), metrics...))
+	return nil
+}
+
+func TestBatchWriter_TimeOrdered(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var rows []pendingRow
+	for i, minutes := range []int{10, 0, 5} {
+		rows = append(rows, pendingRow{
+			msg:    Message{Offset: int64(i)},
+			metric: &database.RawMetric{Zipcode: "94105", Timestamp: base.Add(time.Duration(minutes) * time.Minute)},
+		})
+	}
+
+	bw := NewBatchWriter(nil, nil, 10, time.Second)
+	bw.SetTimeOrdered(true)
+	store := &recordingStore{}
+	done, written := bw.writeRows(context.Background(), store, rows, func(fn func() error) error { return fn() }, false)
+
+	inserted := store.batches[0]
+	for i := 1; i < len(inserted); i++ {
+		if inserted[i].Timestamp.Before(inserted[i-1].Timestamp) {
+			t.Fatalf("Expected rows inserted in time order, got %v before %v", inserted[i-1].Timestamp, inserted[i].Timestamp)
+		}
+	}
+	for i, msg := range done {
+		if msg.Offset != int64(i) {
+			t.Errorf("Expected messages kept in offset order, got offset %d at %d", msg.Offset, i)
+		}
+	}
+	if len(written) != 3 {
+		t.Errorf("Expected 3 rows written, got %d", len(written))
+	}
+}
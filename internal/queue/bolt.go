@@ -0,0 +1,424 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltBroker keeps topics in a local bbolt file, for single-node
+// deployments that want messages to survive a restart without running a
+// broker. Each topic is a bucket of messages keyed by sequence number, and
+// each consumer group's committed offset is stored alongside, so a group
+// resumes where it left off. Messages every group has committed are pruned.
+// All components using the file must share one process.
+type BoltBroker struct {
+	db   *bolt.DB
+	path string
+
+	mu     sync.Mutex
+	topics map[string]*boltTopic
+	refs   int // NewBroker callers sharing this broker
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// boltTopic wakes consumers when messages are published
+type boltTopic struct {
+	mu     sync.Mutex
+	notify chan struct{} // closed and replaced on every publish
+	groups map[string]*boltGroup
+}
+
+// boltGroup is the read position shared by the members of a group
+type boltGroup struct {
+	mu   sync.Mutex
+	next uint64 // next sequence number to hand out
+}
+
+var offsetsBucket = []byte("offsets")
+
+// boltPruneInterval is how often committed messages are deleted
+const boltPruneInterval = time.Minute
+
+var (
+	boltBrokersMu sync.Mutex
+	boltBrokers   = make(map[string]*BoltBroker)
+)
+
+// SharedBoltBroker opens the queue file at path, or returns the broker
+// already open on it: bbolt locks the file, so components in one process
+// must share the broker. Each call must be matched by a Close.
+func SharedBoltBroker(path string) (*BoltBroker, error) {
+	boltBrokersMu.Lock()
+	defer boltBrokersMu.Unlock()
+
+	if b, ok := boltBrokers[path]; ok {
+		b.refs++
+		return b, nil
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue file %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(offsetsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise queue file: %w", err)
+	}
+
+	b := &BoltBroker{
+		db:     db,
+		path:   path,
+		topics: make(map[string]*boltTopic),
+		refs:   1,
+		stopCh: make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.pruneLoop()
+
+	boltBrokers[path] = b
+	return b, nil
+}
+
+// Close releases this caller's reference, closing the file with the last one
+func (b *BoltBroker) Close() error {
+	boltBrokersMu.Lock()
+	defer boltBrokersMu.Unlock()
+
+	b.refs--
+	if b.refs > 0 {
+		return nil
+	}
+	delete(boltBrokers, b.path)
+	close(b.stopCh)
+	b.wg.Wait()
+	return b.db.Close()
+}
+
+func topicBucket(topic string) []byte {
+	return []byte("topic:" + topic)
+}
+
+func offsetKey(topic, groupID string) []byte {
+	return []byte(topic + "/" + groupID)
+}
+
+func (b *BoltBroker) topic(name string) *boltTopic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[name]
+	if !ok {
+		t = &boltTopic{notify: make(chan struct{}), groups: make(map[string]*boltGroup)}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// EnsureTopic creates the topic's bucket
+func (b *BoltBroker) EnsureTopic(topic string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(topicBucket(topic))
+		return err
+	})
+}
+
+// NewProducer creates a producer appending to the topic
+func (b *BoltBroker) NewProducer(topic string) (Producer, error) {
+	if err := b.EnsureTopic(topic); err != nil {
+		return nil, fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+	return &boltProducer{broker: b, topic: topic}, nil
+}
+
+// NewConsumer joins a group, which starts from its committed offset or,
+// for a new group, from the oldest retained message
+func (b *BoltBroker) NewConsumer(topic, groupID string) (Consumer, error) {
+	if err := b.EnsureTopic(topic); err != nil {
+		return nil, fmt.Errorf("failed to create topic %s: %w", topic, err)
+	}
+
+	t := b.topic(topic)
+	t.mu.Lock()
+	group, ok := t.groups[groupID]
+	if !ok {
+		group = &boltGroup{}
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			offsets := tx.Bucket(offsetsBucket)
+			if v := offsets.Get(offsetKey(topic, groupID)); v != nil {
+				group.next = binary.BigEndian.Uint64(v)
+				return nil
+			}
+			// Register the group so pruning waits for it
+			group.next = 1
+			return offsets.Put(offsetKey(topic, groupID), encodeSeq(group.next))
+		})
+		if err != nil {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("failed to load offset of %s: %w", groupID, err)
+		}
+		t.groups[groupID] = group
+	}
+	t.mu.Unlock()
+
+	return &boltConsumer{broker: b, topicName: topic, groupID: groupID, topic: t, group: group}, nil
+}
+
+func encodeSeq(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return buf
+}
+
+// encodeBoltMessage lays out a message as time, key length, key, headers
+// length, JSON headers, value
+func encodeBoltMessage(key string, headers Headers, value []byte, at time.Time) []byte {
+	var encodedHeaders []byte
+	if len(headers) > 0 {
+		encodedHeaders, _ = json.Marshal(headers)
+	}
+	buf := make([]byte, 16+len(key)+len(encodedHeaders)+len(value))
+	binary.BigEndian.PutUint64(buf, uint64(at.UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(key)))
+	n := 12 + copy(buf[12:], key)
+	binary.BigEndian.PutUint32(buf[n:], uint32(len(encodedHeaders)))
+	n += 4 + copy(buf[n+4:], encodedHeaders)
+	copy(buf[n:], value)
+	return buf
+}
+
+func decodeBoltMessage(data []byte) (key []byte, headers Headers, value []byte, at time.Time, err error) {
+	corrupt := fmt.Errorf("corrupt queue message")
+	if len(data) < 16 {
+		return nil, nil, nil, time.Time{}, corrupt
+	}
+	keyLen := int(binary.BigEndian.Uint32(data[8:]))
+	if len(data) < 16+keyLen {
+		return nil, nil, nil, time.Time{}, corrupt
+	}
+	n := 12 + keyLen
+	headersLen := int(binary.BigEndian.Uint32(data[n:]))
+	n += 4
+	if len(data) < n+headersLen {
+		return nil, nil, nil, time.Time{}, corrupt
+	}
+	if headersLen > 0 {
+		if err := json.Unmarshal(data[n:n+headersLen], &headers); err != nil {
+			return nil, nil, nil, time.Time{}, corrupt
+		}
+	}
+
+	at = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	key = append([]byte(nil), data[12:12+keyLen]...)
+	value = append([]byte(nil), data[n+headersLen:]...)
+	return key, headers, value, at, nil
+}
+
+func (b *BoltBroker) publish(topicName, key string, headers Headers, value []byte) error {
+	data := encodeBoltMessage(key, headers, value, time.Now())
+	// Batch coalesces concurrent publishes into one transaction and fsync
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(topicBucket(topicName))
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(encodeSeq(seq), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	t := b.topic(topicName)
+	t.mu.Lock()
+	close(t.notify)
+	t.notify = make(chan struct{})
+	t.mu.Unlock()
+	return nil
+}
+
+// pruneLoop periodically deletes messages every group has committed
+func (b *BoltBroker) pruneLoop() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(boltPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+			if err := b.prune(); err != nil {
+				fmt.Printf("Failed to prune queue file: %v\n", err)
+			}
+		}
+	}
+}
+
+func (b *BoltBroker) prune() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// Lowest committed offset per topic across its groups
+		lowest := make(map[string]uint64)
+		err := tx.Bucket(offsetsBucket).ForEach(func(k, v []byte) error {
+			i := bytes.LastIndexByte(k, '/')
+			if i < 0 {
+				return nil
+			}
+			topic, offset := string(k[:i]), binary.BigEndian.Uint64(v)
+			if current, ok := lowest[topic]; !ok || offset < current {
+				lowest[topic] = offset
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for topic, offset := range lowest {
+			bucket := tx.Bucket(topicBucket(topic))
+			if bucket == nil {
+				continue
+			}
+			// Collect first: deleting while iterating can skip keys
+			var expired [][]byte
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) < offset; k, _ = c.Next() {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+type boltProducer struct {
+	broker *BoltBroker
+	topic  string
+	producerCounters
+}
+
+// Publish appends the message and waits for it to be synced to disk
+func (p *boltProducer) Publish(ctx context.Context, key string, value []byte) error {
+	err := p.broker.publish(p.topic, key, HeadersFrom(ctx), value)
+	p.record(value, err)
+	return err
+}
+
+func (p *boltProducer) IsAsync() bool { return false }
+
+func (p *boltProducer) Close() error { return nil }
+
+type boltConsumer struct {
+	pauseGate
+	broker    *BoltBroker
+	topicName string
+	groupID   string
+	topic     *boltTopic
+	group     *boltGroup
+
+	messages atomic.Int64
+	bytes    atomic.Int64
+	errors   atomic.Int64
+}
+
+// Consume returns the group's next message, waiting for one to be published
+func (c *boltConsumer) Consume(ctx context.Context) (Message, error) {
+	if err := c.wait(ctx); err != nil {
+		return Message{}, err
+	}
+	for {
+		// Take the notify channel before looking, so a publish in between
+		// is not missed
+		c.topic.mu.Lock()
+		notify := c.topic.notify
+		c.topic.mu.Unlock()
+
+		msg, found, err := c.next()
+		if err != nil {
+			c.errors.Add(1)
+			return Message{}, fmt.Errorf("failed to read message: %w", err)
+		}
+		if found {
+			c.messages.Add(1)
+			c.bytes.Add(int64(len(msg.Value)))
+			return msg, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// next hands out the first message at or after the group's position
+func (c *boltConsumer) next() (Message, bool, error) {
+	c.group.mu.Lock()
+	defer c.group.mu.Unlock()
+
+	var msg Message
+	found := false
+	err := c.broker.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(topicBucket(c.topicName)).Cursor()
+		k, v := cursor.Seek(encodeSeq(c.group.next))
+		if k == nil {
+			return nil
+		}
+		key, headers, value, at, err := decodeBoltMessage(v)
+		if err != nil {
+			return err
+		}
+		seq := binary.BigEndian.Uint64(k)
+		msg = Message{Topic: c.topicName, Offset: int64(seq), Key: key, Value: value, Headers: headers, Time: at}
+		c.group.next = seq + 1
+		found = true
+		return nil
+	})
+	return msg, found, err
+}
+
+// Committing an offset commits everything before it in the partition
+func (c *boltConsumer) commitsCumulatively() {}
+
+// Commit records the message as processed. Offsets only move forward, so
+// committing out of order never rewinds the group.
+func (c *boltConsumer) Commit(ctx context.Context, msg Message) error {
+	key := offsetKey(c.topicName, c.groupID)
+	next := uint64(msg.Offset) + 1
+	err := c.broker.db.Batch(func(tx *bolt.Tx) error {
+		offsets := tx.Bucket(offsetsBucket)
+		if v := offsets.Get(key); v != nil && binary.BigEndian.Uint64(v) >= next {
+			return nil
+		}
+		return offsets.Put(key, encodeSeq(next))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit message: %w", err)
+	}
+	return nil
+}
+
+func (c *boltConsumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Messages: c.messages.Load(),
+		Bytes:    c.bytes.Load(),
+		Errors:   c.errors.Load(),
+	}
+}
+
+func (c *boltConsumer) Close() error { return nil }
@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/connection"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// ConnectionEvents publishes connection lifecycle events to a topic so
+// downstream services learn when stations come and go. It is a
+// connection.Observer.
+type ConnectionEvents struct {
+	producer Producer
+
+	// Events waiting to be published when the producer is synchronous, so
+	// the connection manager never waits on the broker
+	pending chan connectionEvent
+	wg      sync.WaitGroup
+}
+
+type connectionEvent struct {
+	key  string
+	data []byte
+}
+
+// NewConnectionEvents creates a publisher using producer, which it takes
+// ownership of
+func NewConnectionEvents(producer Producer) *ConnectionEvents {
+	e := &ConnectionEvents{
+		producer: producer,
+	}
+	if !producer.IsAsync() {
+		e.pending = make(chan connectionEvent, 1000)
+		e.wg.Add(1)
+		go e.run()
+	}
+	return e
+}
+
+// Close publishes any pending events and closes the producer
+func (e *ConnectionEvents) Close() error {
+	if e.pending != nil {
+		close(e.pending)
+		e.wg.Wait()
+	}
+	return e.producer.Close()
+}
+
+func (e *ConnectionEvents) run() {
+	defer e.wg.Done()
+	for event := range e.pending {
+		e.send(event)
+	}
+}
+
+func (e *ConnectionEvents) send(event connectionEvent) {
+	if err := e.producer.Publish(context.Background(), event.key, event.data); err != nil {
+		fmt.Printf("Failed to publish connection event for zipcode %s: %v\n", event.key, err)
+	}
+}
+
+// OnRegister publishes a connected event
+func (e *ConnectionEvents) OnRegister(client *connection.ClientInfo) {
+	e.publish(protocol.ConnectionEventConnected, client)
+}
+
+// OnUnregister publishes a disconnected event
+func (e *ConnectionEvents) OnUnregister(client *connection.ClientInfo) {
+	e.publish(protocol.ConnectionEventDisconnected, client)
+}
+
+// OnIdle publishes an idle event
+func (e *ConnectionEvents) OnIdle(client *connection.ClientInfo) {
+	e.publish(protocol.ConnectionEventIdle, client)
+}
+
+// publish encodes and sends an event keyed by zipcode, like metrics, so a
+// station's events stay in order. Observers run under the connection
+// manager's lock, so a synchronous producer is fed through a queue instead;
+// events are dropped if it is full.
+func (e *ConnectionEvents) publish(eventType protocol.ConnectionEventType, client *connection.ClientInfo) {
+	event := &protocol.ConnectionEvent{
+		Type:         eventType,
+		ConnectionID: client.ConnectionID,
+		Zipcode:      client.Zipcode,
+		City:         client.City,
+		StationID:    client.StationID,
+		RemoteAddr:   client.GetRemoteAddr(),
+		ConnectedAt:  client.ConnectedAt,
+		LastHeard:    client.GetLastHeardFrom(),
+		Reason:       client.GetDisconnectReason(),
+		Timestamp:    time.Now(),
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Failed to encode connection event: %v\n", err)
+		return
+	}
+	pending := connectionEvent{key: client.Zipcode, data: data}
+	if e.pending == nil {
+		e.send(pending)
+		return
+	}
+	select {
+	case e.pending <- pending:
+	default:
+		fmt.Printf("Connection event queue full, dropping %s event for %s\n", eventType, client.ConnectionID)
+	}
+}
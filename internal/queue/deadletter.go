@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// deadLetterBackoff is the wait before retrying a failed message; it grows
+// linearly with each attempt
+const deadLetterBackoff = 500 * time.Millisecond
+
+// DeadLetter is what is published to the dead-letter topic: the original
+// message untouched, plus where it came from and why it was given up on
+type DeadLetter struct {
+	Topic     string    `json:"topic"`
+	Group     string    `json:"group"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"` // base64 in JSON, since it may not be valid JSON itself
+	Headers   Headers   `json:"headers,omitempty"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, such as a message that cannot
+// be decoded, so it is dead-lettered on the first attempt
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// DeadLetterQueue retries messages a consumer fails to process and, when
+// they keep failing, publishes them to a dead-letter topic so the consumer
+// can commit and move on. A nil *DeadLetterQueue runs each message once and
+// returns its error.
+type DeadLetterQueue struct {
+	producer    Producer
+	group       string
+	maxAttempts int
+
+	deadLettered atomic.Int64
+}
+
+// NewDeadLetterQueue publishes messages consumer group fails maxAttempts
+// times to producer
+func NewDeadLetterQueue(producer Producer, group string, maxAttempts int) *DeadLetterQueue {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &DeadLetterQueue{producer: producer, group: group, maxAttempts: maxAttempts}
+}
+
+// OpenDeadLetterQueue creates the dead-letter queue for a consumer group
+// from the configured topic and attempt limit. It returns nil when
+// KAFKA_TOPIC_DEADLETTER is empty.
+func OpenDeadLetterQueue(broker Broker, cfg *config.Config, group string) (*DeadLetterQueue, error) {
+	if cfg.Kafka.TopicDeadLetter == "" {
+		return nil, nil
+	}
+	producer, err := broker.NewProducer(cfg.Kafka.TopicDeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter producer: %w", err)
+	}
+	return NewDeadLetterQueue(producer, group, cfg.Queue.DeadLetterAttempts), nil
+}
+
+// Handle runs process for msg, retrying failures up to the attempt limit.
+// A message that still fails, or fails with a Permanent error, is
+// dead-lettered. Handle returns nil once the message may be committed,
+// whether it was processed or dead-lettered.
+func (d *DeadLetterQueue) Handle(ctx context.Context, msg Message, process func() error) error {
+	if d == nil {
+		return process()
+	}
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		if err = process(); err == nil {
+			return nil
+		}
+		if IsPermanent(err) || attempt >= d.maxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * deadLetterBackoff):
+		}
+	}
+
+	if dlqErr := d.send(ctx, msg, err, attempt); dlqErr != nil {
+		return fmt.Errorf("%w (and %v)", err, dlqErr)
+	}
+	fmt.Printf("Dead-lettered message from %s (partition=%d, offset=%d) after %d attempts: %v\n",
+		msg.Topic, msg.Partition, msg.Offset, attempt, err)
+	return nil
+}
+
+func (d *DeadLetterQueue) send(ctx context.Context, msg Message, cause error, attempts int) error {
+	data, err := json.Marshal(DeadLetter{
+		Topic:     msg.Topic,
+		Group:     d.group,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		Value:     msg.Value,
+		Headers:   msg.Headers,
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	// Keep the trace ID and provenance on the dead letter too
+	if err := d.producer.Publish(WithHeaders(ctx, msg.Headers), string(msg.Key), data); err != nil {
+		return fmt.Errorf("failed to publish dead letter: %w", err)
+	}
+	d.deadLettered.Add(1)
+	return nil
+}
+
+// Close closes the dead-letter producer
+func (d *DeadLetterQueue) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.producer.Close()
+}
+
+// Count returns how many messages have been dead-lettered
+func (d *DeadLetterQueue) Count() int64 {
+	if d == nil {
+		return 0
+	}
+	return d.deadLettered.Load()
+}
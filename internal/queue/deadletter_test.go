@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDeadLetterQueue_RetriesThenDeadLetters(t *testing.T) {
+	broker := NewMemoryBroker(10)
+	producer, _ := broker.NewProducer("dead")
+	consumer, _ := broker.NewConsumer("dead", "test")
+	dlq := NewDeadLetterQueue(producer, "dbwriter-group", 2)
+
+	msg := Message{Topic: "metrics", Partition: 3, Offset: 42, Key: []byte("94107"), Value: []byte("{}")}
+	attempts := 0
+	err := dlq.Handle(context.Background(), msg, func() error {
+		attempts++
+		return errors.New("database unavailable")
+	})
+	if err != nil {
+		t.Fatalf("Expected the message to be dead-lettered, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+
+	out, err := consumer.Consume(context.Background())
+	if err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+	var letter DeadLetter
+	if err := json.Unmarshal(out.Value, &letter); err != nil {
+		t.Fatalf("Failed to decode dead letter: %v", err)
+	}
+	if letter.Topic != "metrics" || letter.Offset != 42 || letter.Group != "dbwriter-group" ||
+		letter.Attempts != 2 || letter.Error != "database unavailable" || string(letter.Value) != "{}" {
+		t.Errorf("Unexpected dead letter: %+v", letter)
+	}
+}
+
+func TestDeadLetterQueue_PermanentSkipsRetries(t *testing.T) {
+	producer, _ := NewMemoryBroker(10).NewProducer("dead")
+	dlq := NewDeadLetterQueue(producer, "alarming-group", 5)
+
+	attempts := 0
+	dlq.Handle(context.Background(), Message{}, func() error {
+		attempts++
+		return Permanent(errors.New("invalid JSON"))
+	})
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+	if dlq.Count() != 1 {
+		t.Errorf("Expected 1 dead letter, got %d", dlq.Count())
+	}
+}
+
+func TestDeadLetterQueue_Nil(t *testing.T) {
+	var dlq *DeadLetterQueue
+	want := errors.New("failed")
+	if err := dlq.Handle(context.Background(), Message{}, func() error { return want }); err != want {
+		t.Errorf("Expected the processing error, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header keys set on produced messages
+const (
+	HeaderConnectionID  = "connection-id"
+	HeaderInstance      = "server-instance"
+	HeaderTraceID       = "trace-id"
+	HeaderSchemaVersion = "schema-version"
+)
+
+// Headers are string metadata carried alongside a message's key and value,
+// for tracing and provenance. Every backend carries them: Kafka and NATS
+// natively, RabbitMQ as AMQP headers, and the in-process backends as is.
+type Headers map[string]string
+
+type headersKey struct{}
+
+// WithHeaders returns a context whose publishes carry headers, on top of
+// any headers ctx already carries. Consumers pass a received message's
+// headers this way to propagate them to what they publish in turn.
+func WithHeaders(ctx context.Context, headers Headers) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	merged := make(Headers, len(headers))
+	for k, v := range HeadersFrom(ctx) {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// HeadersFrom returns the headers set on ctx with WithHeaders
+func HeadersFrom(ctx context.Context) Headers {
+	headers, _ := ctx.Value(headersKey{}).(Headers)
+	return headers
+}
+
+// NewTraceID returns a random W3C-style 128-bit trace ID
+func NewTraceID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"hash/crc32"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/compress"
+	"github.com/smukkama/weather-server/pkg/config"
 )
 
 // ProducerConfig holds configuration for the Kafka producer
@@ -23,18 +26,34 @@ type ProducerConfig struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	BatchBytes   int64 // Max bytes per batch
+
+	// SASL and TLS settings; nil connects plaintext without authentication
+	Security *config.KafkaSecurityConfig
 }
 
-// Producer wraps a Kafka producer with optimizations
-type Producer struct {
+// KafkaProducer is a Producer writing to Kafka with batching and compression
+type KafkaProducer struct {
 	writer *kafka.Writer
 	config *ProducerConfig
+
+	handlerMu sync.RWMutex
+	onError   ErrorHandler
+
+	// Counted from completed batches, since kafka-go's own writer stats
+	// reset on every read
+	accepted atomic.Int64
+	messages atomic.Int64
+	bytes    atomic.Int64
+	errors   atomic.Int64
+	batches  atomic.Int64
 }
 
-// NewProducer creates a new optimized Kafka producer
-func NewProducer(brokers []string, topic string) *Producer {
+// NewKafkaProducer creates a new optimized Kafka producer for a plaintext
+// cluster
+func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
 	// Default optimized configuration
-	return NewProducerWithConfig(&ProducerConfig{
+	// Without Security there is nothing that can fail
+	p, _ := NewKafkaProducerWithConfig(&ProducerConfig{
 		Brokers:      brokers,
 		Topic:        topic,
 		BatchSize:    100,                    // Batch up to 100 messages
@@ -47,10 +66,11 @@ func NewProducer(brokers []string, topic string) *Producer {
 		WriteTimeout: 10 * time.Second,
 		BatchBytes:   1048576, // 1MB per batch
 	})
+	return p
 }
 
-// NewProducerWithConfig creates a producer with custom configuration
-func NewProducerWithConfig(config *ProducerConfig) *Producer {
+// NewKafkaProducerWithConfig creates a producer with custom configuration
+func NewKafkaProducerWithConfig(config *ProducerConfig) (*KafkaProducer, error) {
 	// Select compression algorithm
 	var compression compress.Compression
 	switch config.Compression {
@@ -101,51 +121,104 @@ func NewProducerWithConfig(config *ProducerConfig) *Producer {
 		WriteTimeout: config.WriteTimeout,
 	}
 
-	return &Producer{
+	transport, err := kafkaTransport(config.Security)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Kafka security: %w", err)
+	}
+	if transport != nil {
+		writer.Transport = transport
+	}
+
+	p := &KafkaProducer{
 		writer: writer,
 		config: config,
 	}
+	// In async mode WriteMessages returns before the write, so failures
+	// are only seen here
+	writer.Completion = p.complete
+	return p, nil
 }
 
-// NewProducerFromKafkaConfig creates a producer from KafkaConfig
-func NewProducerFromKafkaConfig(cfg interface{}) *Producer {
-	// This accepts the config interface to avoid circular imports
-	// Expected to be called with pkg/config.KafkaConfig
+// SetErrorHandler sets the callback for async writes that failed after
+// kafka-go exhausted its attempts
+func (p *KafkaProducer) SetErrorHandler(fn ErrorHandler) {
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	p.onError = fn
+}
 
-	// Use reflection or type assertion based on your config package
-	// For simplicity, extract values dynamically
-	type kafkaConfig struct {
-		Brokers      []string
-		TopicMetrics string
-		BatchSize    int
-		BatchTimeout time.Duration
-		Compression  string
-		Async        bool
-		MaxAttempts  int
-		RequiredAcks int
+// complete is called by kafka-go for every batch written or failed
+func (p *KafkaProducer) complete(messages []kafka.Message, err error) {
+	if err != nil {
+		p.errors.Add(int64(len(messages)))
+	} else {
+		p.messages.Add(int64(len(messages)))
+		p.batches.Add(1)
+		for _, msg := range messages {
+			p.bytes.Add(int64(len(msg.Value)))
+		}
 	}
 
-	// This is a helper - typically you'd pass values directly
-	// or make config package importable
-	return NewProducer(nil, "")
+	if err == nil || !p.config.Async {
+		return
+	}
+	p.handlerMu.RLock()
+	fn := p.onError
+	p.handlerMu.RUnlock()
+	if fn == nil {
+		fmt.Printf("Failed to deliver %d messages to %s: %v\n", len(messages), p.config.Topic, err)
+		return
+	}
+	for _, msg := range messages {
+		fn(Message{
+			Topic:   p.config.Topic,
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: kafkaHeaders(msg.Headers),
+			Time:    msg.Time,
+		}, err)
+	}
 }
 
 // Publish sends a message to Kafka
-func (p *Producer) Publish(ctx context.Context, key string, value []byte) error {
+func (p *KafkaProducer) Publish(ctx context.Context, key string, value []byte) error {
 	msg := kafka.Message{
 		Key:   []byte(key),
 		Value: value,
 	}
+	for k, v := range HeadersFrom(ctx) {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
 
 	if err := p.writer.WriteMessages(ctx, msg); err != nil {
 		return fmt.Errorf("failed to write message: %w", err)
 	}
+	p.accepted.Add(1)
 
 	return nil
 }
 
+// Stats returns counters since the producer was created
+func (p *KafkaProducer) Stats() ProducerStats {
+	stats := ProducerStats{
+		Messages: p.messages.Load(),
+		Bytes:    p.bytes.Load(),
+		Errors:   p.errors.Load(),
+		Batches:  p.batches.Load(),
+	}
+	if p.config.Async {
+		stats.Pending = max(p.accepted.Load()-stats.Messages-stats.Errors, 0)
+	}
+	return stats
+}
+
+// IsAsync reports whether Publish returns before Kafka acknowledges the write
+func (p *KafkaProducer) IsAsync() bool {
+	return p.config.Async
+}
+
 // PublishBatch sends multiple messages to Kafka
-func (p *Producer) PublishBatch(ctx context.Context, messages []kafka.Message) error {
+func (p *KafkaProducer) PublishBatch(ctx context.Context, messages []kafka.Message) error {
 	if err := p.writer.WriteMessages(ctx, messages...); err != nil {
 		return fmt.Errorf("failed to write batch: %w", err)
 	}
@@ -153,93 +226,225 @@ func (p *Producer) PublishBatch(ctx context.Context, messages []kafka.Message) e
 }
 
 // Close closes the producer
-func (p *Producer) Close() error {
+func (p *KafkaProducer) Close() error {
 	return p.writer.Close()
 }
 
-// Consumer wraps a Kafka consumer
-type Consumer struct {
+// KafkaConsumer is a Consumer reading from Kafka
+type KafkaConsumer struct {
+	pauseGate
 	reader *kafka.Reader
 }
 
-// NewConsumer creates a new Kafka consumer
-func NewConsumer(brokers []string, topic, groupID string) *Consumer {
+// NewKafkaConsumer creates a new Kafka consumer. A nil security config
+// connects plaintext without authentication.
+func NewKafkaConsumer(brokers []string, topic, groupID string, security *config.KafkaSecurityConfig) (*KafkaConsumer, error) {
+	dialer, err := kafkaDialer(security)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Kafka security: %w", err)
+	}
+
 	fmt.Printf("Creating new consumer of broker %s for topic %s in group %s\n", brokers, topic, groupID)
-	return &Consumer{
+	return &KafkaConsumer{
 		reader: kafka.NewReader(kafka.ReaderConfig{
 			Brokers: brokers,
 			Topic:   topic,
 			GroupID: groupID,
+			Dialer:  dialer,
 			// Use library defaults - simpler configuration is more reliable
 		}),
-	}
+	}, nil
 }
 
 // Consume reads messages from Kafka
-func (c *Consumer) Consume(ctx context.Context) (kafka.Message, error) {
-	msg, err := c.reader.ReadMessage(context.Background())
+func (c *KafkaConsumer) Consume(ctx context.Context) (Message, error) {
+	if err := c.wait(ctx); err != nil {
+		return Message{}, err
+	}
+	msg, err := c.reader.ReadMessage(ctx)
 	if err != nil {
-		return kafka.Message{}, fmt.Errorf("failed to read message: %w", err)
+		return Message{}, fmt.Errorf("failed to read message: %w", err)
 	}
-	return msg, nil
+	return Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   kafkaHeaders(msg.Headers),
+		Time:      msg.Time,
+		ack:       msg,
+	}, nil
 }
 
+func kafkaHeaders(headers []kafka.Header) Headers {
+	if len(headers) == 0 {
+		return nil
+	}
+	result := make(Headers, len(headers))
+	for _, h := range headers {
+		result[h.Key] = string(h.Value)
+	}
+	return result
+}
+
+// Committing an offset commits everything before it in the partition
+func (c *KafkaConsumer) commitsCumulatively() {}
+
 // Commit commits the message offset
-func (c *Consumer) Commit(ctx context.Context, msg kafka.Message) error {
-	if err := c.reader.CommitMessages(ctx, msg); err != nil {
+func (c *KafkaConsumer) Commit(ctx context.Context, msg Message) error {
+	kafkaMsg, ok := msg.ack.(kafka.Message)
+	if !ok {
+		return fmt.Errorf("failed to commit message: not read from Kafka")
+	}
+	if err := c.reader.CommitMessages(ctx, kafkaMsg); err != nil {
 		return fmt.Errorf("failed to commit message: %w", err)
 	}
 	return nil
 }
 
 // Stats returns consumer statistics
-func (c *Consumer) Stats() kafka.ReaderStats {
+func (c *KafkaConsumer) Stats() ConsumerStats {
+	stats := c.reader.Stats()
+	return ConsumerStats{
+		Messages: stats.Messages,
+		Bytes:    stats.Bytes,
+		Errors:   stats.Errors,
+	}
+}
+
+// ReaderStats returns the underlying kafka-go reader statistics
+func (c *KafkaConsumer) ReaderStats() kafka.ReaderStats {
 	return c.reader.Stats()
 }
 
 // Close closes the consumer
-func (c *Consumer) Close() error {
+func (c *KafkaConsumer) Close() error {
 	return c.reader.Close()
 }
 
+// KafkaBroker creates Kafka producers and consumers from KafkaConfig
+type KafkaBroker struct {
+	cfg *config.KafkaConfig
+}
+
+// NewKafkaBroker creates a broker for the configured Kafka cluster
+func NewKafkaBroker(cfg *config.KafkaConfig) *KafkaBroker {
+	return &KafkaBroker{cfg: cfg}
+}
+
+// NewProducer creates a producer using the configured batching,
+// compression and acknowledgement settings
+func (b *KafkaBroker) NewProducer(topic string) (Producer, error) {
+	return NewKafkaProducerWithConfig(&ProducerConfig{
+		Brokers:      b.cfg.Brokers,
+		Topic:        topic,
+		BatchSize:    b.cfg.BatchSize,
+		BatchTimeout: b.cfg.BatchTimeout,
+		Compression:  b.cfg.Compression,
+		Async:        b.cfg.Async,
+		MaxAttempts:  b.cfg.MaxAttempts,
+		RequiredAcks: b.cfg.RequiredAcks,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		BatchBytes:   1048576, // 1MB
+		Security:     &b.cfg.Security,
+	})
+}
+
+// NewConsumer creates a consumer in the given group
+func (b *KafkaBroker) NewConsumer(topic, groupID string) (Consumer, error) {
+	return NewKafkaConsumer(b.cfg.Brokers, topic, groupID, &b.cfg.Security)
+}
+
+// EnsureTopic creates the topic with the configured partition count
+func (b *KafkaBroker) EnsureTopic(topic string) error {
+	return CreateTopic(b.cfg, topic, b.cfg.NumPartitions, 1)
+}
+
+// Close is a no-op; producers and consumers are closed individually
+func (b *KafkaBroker) Close() error {
+	return nil
+}
+
 // GetPartitionForZipcode returns the partition number for a zipcode
 func GetPartitionForZipcode(zipcode string, numPartitions int) int {
 	hash := crc32.ChecksumIEEE([]byte(zipcode))
 	return int(hash % uint32(numPartitions))
 }
 
-// CreateTopic creates a Kafka topic with the specified number of partitions
-func CreateTopic(brokers []string, topic string, numPartitions int, replicationFactor int) error {
-	conn, err := kafka.Dial("tcp", brokers[0])
+// ReadCompactedTopic reads a topic from the beginning up to its end at the
+// time of the call and returns the latest message per key. It reads without
+// a consumer group, so a new consumer can load current state from a
+// compacted topic before it starts consuming.
+func ReadCompactedTopic(ctx context.Context, cfg *config.KafkaConfig, topic string) (map[string]Message, error) {
+	dialer, err := kafkaDialer(&cfg.Security)
 	if err != nil {
-		return fmt.Errorf("failed to dial broker: %w", err)
+		return nil, fmt.Errorf("failed to configure Kafka security: %w", err)
 	}
-	defer conn.Close()
 
-	controller, err := conn.Controller()
+	partitions, err := dialer.LookupPartitions(ctx, "tcp", cfg.Brokers[0], topic)
 	if err != nil {
-		return fmt.Errorf("failed to get controller: %w", err)
+		return nil, fmt.Errorf("failed to look up partitions of %s: %w", topic, err)
 	}
 
-	controllerConn, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
-	if err != nil {
-		return fmt.Errorf("failed to dial controller: %w", err)
+	latest := make(map[string]Message)
+	for _, partition := range partitions {
+		if err := readPartitionTo(ctx, dialer, cfg.Brokers[0], topic, partition.ID, latest); err != nil {
+			return nil, fmt.Errorf("failed to read %s partition %d: %w", topic, partition.ID, err)
+		}
 	}
-	defer controllerConn.Close()
+	return latest, nil
+}
 
-	topicConfigs := []kafka.TopicConfig{
-		{
-			Topic:             topic,
-			NumPartitions:     numPartitions,
-			ReplicationFactor: replicationFactor,
-		},
+func readPartitionTo(ctx context.Context, dialer *kafka.Dialer, broker, topic string, partition int, latest map[string]Message) error {
+	conn, err := dialer.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return err
 	}
+	defer conn.Close()
 
-	err = controllerConn.CreateTopics(topicConfigs...)
+	first, last, err := conn.ReadOffsets()
 	if err != nil {
-		return fmt.Errorf("failed to create topic: %w", err)
+		return err
+	}
+	if _, err := conn.Seek(first, kafka.SeekAbsolute); err != nil {
+		return err
 	}
 
-	fmt.Printf("Created topic %s with %d partitions\n", topic, numPartitions)
+	for offset := first; offset < last; {
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetReadDeadline(deadline)
+		}
+		batch := conn.ReadBatch(1, 10e6)
+		read := 0
+		for offset < last {
+			msg, err := batch.ReadMessage()
+			if err != nil {
+				break
+			}
+			read++
+			offset = msg.Offset + 1
+			latest[string(msg.Key)] = Message{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    msg.Offset,
+				Key:       msg.Key,
+				Value:     msg.Value,
+				Headers:   kafkaHeaders(msg.Headers),
+				Time:      msg.Time,
+			}
+		}
+		if err := batch.Close(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Compaction can leave no message at the offsets before the end
+		if read == 0 {
+			break
+		}
+	}
 	return nil
 }
@@ -0,0 +1,265 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// TopicSpec is how a Kafka topic should be set up
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration // 0 leaves the broker default
+	Compacted         bool          // cleanup.policy=compact instead of delete
+}
+
+// TopicHealth is the replica state of a topic's partitions
+type TopicHealth struct {
+	Partitions      int
+	UnderReplicated []int // partitions with replicas out of sync
+	Offline         []int // partitions without a leader
+}
+
+// TopicAdmin creates and checks Kafka topics. Services ensure their topics
+// at startup so a misconfigured or unhealthy topic stops them with a clear
+// error rather than surfacing later as failed publishes.
+type TopicAdmin struct {
+	client *kafka.Client
+}
+
+// NewTopicAdmin creates an admin client for the cluster in cfg
+func NewTopicAdmin(cfg *config.KafkaConfig) (*TopicAdmin, error) {
+	transport, err := kafkaTransport(&cfg.Security)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Kafka security: %w", err)
+	}
+	client := &kafka.Client{
+		Addr:    kafka.TCP(cfg.Brokers...),
+		Timeout: 10 * time.Second,
+	}
+	if transport != nil {
+		client.Transport = transport
+	}
+	return &TopicAdmin{client: client}, nil
+}
+
+// CreateTopic creates a Kafka topic with the specified number of partitions,
+// or checks an existing one against it
+func CreateTopic(cfg *config.KafkaConfig, topic string, numPartitions int, replicationFactor int) error {
+	admin, err := NewTopicAdmin(cfg)
+	if err != nil {
+		return err
+	}
+	return admin.EnsureTopic(context.Background(), TopicSpec{
+		Name:              topic,
+		Partitions:        numPartitions,
+		ReplicationFactor: replicationFactor,
+	})
+}
+
+// EnsureTopic creates the topic if it doesn't exist. An existing topic gets
+// partitions added up to spec.Partitions and its cleanup policy and
+// retention set to the spec's; a replication factor that differs from the
+// spec, or a partition without a leader, is an error.
+func (a *TopicAdmin) EnsureTopic(ctx context.Context, spec TopicSpec) error {
+	topic, err := a.describe(ctx, spec.Name)
+	if err != nil {
+		return err
+	}
+	if topic == nil {
+		return a.create(ctx, spec)
+	}
+
+	if replicas := len(topic.Partitions[0].Replicas); replicas != spec.ReplicationFactor {
+		return fmt.Errorf("topic %s has replication factor %d, expected %d; reassign its partitions to change it",
+			spec.Name, replicas, spec.ReplicationFactor)
+	}
+
+	switch partitions := len(topic.Partitions); {
+	case partitions < spec.Partitions:
+		if err := a.addPartitions(ctx, spec.Name, spec.Partitions); err != nil {
+			return err
+		}
+		fmt.Printf("Increased partitions of topic %s from %d to %d\n", spec.Name, partitions, spec.Partitions)
+	case partitions > spec.Partitions:
+		fmt.Printf("Note: topic %s has %d partitions, more than the %d configured; partitions can't be removed\n",
+			spec.Name, partitions, spec.Partitions)
+	}
+
+	if err := a.reconcileConfig(ctx, spec); err != nil {
+		return err
+	}
+
+	health, err := a.Health(ctx, spec.Name)
+	if err != nil {
+		return err
+	}
+	if len(health.Offline) > 0 {
+		return fmt.Errorf("topic %s has partitions without a leader: %v", spec.Name, health.Offline)
+	}
+	if len(health.UnderReplicated) > 0 {
+		fmt.Printf("Warning: topic %s has under-replicated partitions: %v\n", spec.Name, health.UnderReplicated)
+	}
+	return nil
+}
+
+// Health reports which of the topic's partitions are under-replicated or
+// offline
+func (a *TopicAdmin) Health(ctx context.Context, name string) (TopicHealth, error) {
+	topic, err := a.describe(ctx, name)
+	if err != nil {
+		return TopicHealth{}, err
+	}
+	if topic == nil {
+		return TopicHealth{}, fmt.Errorf("topic %s does not exist", name)
+	}
+
+	health := TopicHealth{Partitions: len(topic.Partitions)}
+	for _, p := range topic.Partitions {
+		if p.Leader.Host == "" || p.Leader.ID < 0 {
+			health.Offline = append(health.Offline, p.ID)
+		} else if len(p.Isr) < len(p.Replicas) {
+			health.UnderReplicated = append(health.UnderReplicated, p.ID)
+		}
+	}
+	return health, nil
+}
+
+// describe returns the topic's metadata, or nil if it doesn't exist
+func (a *TopicAdmin) describe(ctx context.Context, name string) (*kafka.Topic, error) {
+	resp, err := a.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{name}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata for topic %s: %w", name, err)
+	}
+	for i := range resp.Topics {
+		topic := &resp.Topics[i]
+		if topic.Name != name {
+			continue
+		}
+		if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			return nil, nil
+		}
+		if topic.Error != nil {
+			return nil, fmt.Errorf("failed to get metadata for topic %s: %w", name, topic.Error)
+		}
+		if len(topic.Partitions) == 0 {
+			return nil, nil
+		}
+		return topic, nil
+	}
+	return nil, nil
+}
+
+func (a *TopicAdmin) create(ctx context.Context, spec TopicSpec) error {
+	resp, err := a.client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+			ConfigEntries:     topicConfigEntries(spec),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %w", spec.Name, err)
+	}
+	if err := resp.Errors[spec.Name]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+		return fmt.Errorf("failed to create topic %s: %w", spec.Name, err)
+	}
+
+	fmt.Printf("Created topic %s with %d partitions\n", spec.Name, spec.Partitions)
+	return nil
+}
+
+func (a *TopicAdmin) addPartitions(ctx context.Context, name string, count int) error {
+	resp, err := a.client.CreatePartitions(ctx, &kafka.CreatePartitionsRequest{
+		Topics: []kafka.TopicPartitionsConfig{{Name: name, Count: int32(count)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add partitions to topic %s: %w", name, err)
+	}
+	if err := resp.Errors[name]; err != nil {
+		return fmt.Errorf("failed to add partitions to topic %s: %w", name, err)
+	}
+	return nil
+}
+
+// reconcileConfig sets the topic's cleanup policy and retention where they
+// differ from the spec
+func (a *TopicAdmin) reconcileConfig(ctx context.Context, spec TopicSpec) error {
+	resp, err := a.client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: spec.Name,
+			ConfigNames:  []string{"cleanup.policy", "retention.ms"},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe config of topic %s: %w", spec.Name, err)
+	}
+
+	current := make(map[string]string)
+	for _, resource := range resp.Resources {
+		if resource.Error != nil {
+			return fmt.Errorf("failed to describe config of topic %s: %w", spec.Name, resource.Error)
+		}
+		for _, entry := range resource.ConfigEntries {
+			current[entry.ConfigName] = entry.ConfigValue
+		}
+	}
+
+	var changes []kafka.IncrementalAlterConfigsRequestConfig
+	for _, entry := range topicConfigEntries(spec) {
+		if current[entry.ConfigName] == entry.ConfigValue {
+			continue
+		}
+		fmt.Printf("Changing %s of topic %s from %q to %q\n", entry.ConfigName, spec.Name, current[entry.ConfigName], entry.ConfigValue)
+		changes = append(changes, kafka.IncrementalAlterConfigsRequestConfig{
+			Name:            entry.ConfigName,
+			Value:           entry.ConfigValue,
+			ConfigOperation: kafka.ConfigOperationSet,
+		})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	alter, err := a.client.IncrementalAlterConfigs(ctx, &kafka.IncrementalAlterConfigsRequest{
+		Resources: []kafka.IncrementalAlterConfigsRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: spec.Name,
+			Configs:      changes,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update config of topic %s: %w", spec.Name, err)
+	}
+	for _, resource := range alter.Resources {
+		if resource.Error != nil {
+			return fmt.Errorf("failed to update config of topic %s: %w", spec.Name, resource.Error)
+		}
+	}
+	return nil
+}
+
+// topicConfigEntries returns the topic-level settings a spec asks for
+func topicConfigEntries(spec TopicSpec) []kafka.ConfigEntry {
+	policy := "delete"
+	if spec.Compacted {
+		policy = "compact"
+	}
+	entries := []kafka.ConfigEntry{{ConfigName: "cleanup.policy", ConfigValue: policy}}
+	if spec.Retention > 0 {
+		entries = append(entries, kafka.ConfigEntry{
+			ConfigName:  "retention.ms",
+			ConfigValue: strconv.FormatInt(spec.Retention.Milliseconds(), 10),
+		})
+	}
+	return entries
+}
@@ -0,0 +1,24 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopicConfigEntries(t *testing.T) {
+	entries := topicConfigEntries(TopicSpec{Retention: 7 * 24 * time.Hour})
+	want := map[string]string{"cleanup.policy": "delete", "retention.ms": "604800000"}
+	if len(entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %+v", len(want), entries)
+	}
+	for _, e := range entries {
+		if want[e.ConfigName] != e.ConfigValue {
+			t.Errorf("Expected %s=%s, got %s", e.ConfigName, want[e.ConfigName], e.ConfigValue)
+		}
+	}
+
+	entries = topicConfigEntries(TopicSpec{Compacted: true})
+	if len(entries) != 1 || entries[0].ConfigValue != "compact" {
+		t.Errorf("Expected only cleanup.policy=compact, got %+v", entries)
+	}
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// saslMechanism builds the configured SASL mechanism, or nil without SASL
+func saslMechanism(sec *config.KafkaSecurityConfig) (sasl.Mechanism, error) {
+	switch strings.ToLower(sec.SASLMechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: sec.SASLUsername, Password: sec.SASLPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, sec.SASLUsername, sec.SASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, sec.SASLUsername, sec.SASLPassword)
+	default:
+		return nil, fmt.Errorf("unknown SASL mechanism %q (expected plain, scram-sha-256 or scram-sha-512)", sec.SASLMechanism)
+	}
+}
+
+// tlsConfig builds the TLS configuration, or nil when TLS is off
+func tlsConfig(sec *config.KafkaSecurityConfig) (*tls.Config, error) {
+	if !sec.TLS {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: sec.TLSInsecureSkipVerify,
+	}
+	if sec.TLSCAFile != "" {
+		pem, err := os.ReadFile(sec.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", sec.TLSCAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if sec.TLSCertFile != "" || sec.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(sec.TLSCertFile, sec.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// kafkaDialer returns a dialer for consumers and admin connections. A nil
+// config dials plaintext.
+func kafkaDialer(sec *config.KafkaSecurityConfig) (*kafka.Dialer, error) {
+	dialer := &kafka.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
+	}
+	if sec == nil {
+		return dialer, nil
+	}
+
+	mechanism, err := saslMechanism(sec)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := tlsConfig(sec)
+	if err != nil {
+		return nil, err
+	}
+	dialer.SASLMechanism = mechanism
+	dialer.TLS = tlsCfg
+	return dialer, nil
+}
+
+// kafkaTransport returns the writer transport for a producer, or nil for
+// kafka-go's default plaintext transport
+func kafkaTransport(sec *config.KafkaSecurityConfig) (*kafka.Transport, error) {
+	if sec == nil || (sec.SASLMechanism == "" && !sec.TLS) {
+		return nil, nil
+	}
+
+	mechanism, err := saslMechanism(sec)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := tlsConfig(sec)
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Transport{
+		SASL: mechanism,
+		TLS:  tlsCfg,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// KeyedPool consumes messages and handles them on several workers. The
+// messages of a key, e.g. a zipcode, all go to the same worker, so they are
+// handled in the order they were consumed; messages of different keys are
+// handled in parallel.
+//
+// Each message is committed once handled. A cumulative consumer's commit
+// would also cover earlier messages still being handled on other workers,
+// so on those each partition is only committed up to its first message
+// that isn't handled yet.
+type KeyedPool struct {
+	consumer Consumer
+	workers  int
+
+	// Offsets consumed from each partition of a cumulative consumer, in
+	// order, until committed
+	mu      sync.Mutex
+	pending map[string][]*pendingMessage
+}
+
+type pendingMessage struct {
+	msg     Message
+	handled bool
+}
+
+// NewKeyedPool creates a pool of workers handling consumer's messages
+func NewKeyedPool(consumer Consumer, workers int) *KeyedPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &KeyedPool{
+		consumer: consumer,
+		workers:  workers,
+		pending:  make(map[string][]*pendingMessage),
+	}
+}
+
+// Run consumes messages and handles them on the workers until ctx is done.
+// A worker busy with a message holds up the messages of its other keys, and
+// consumption stops once its buffer is full. Messages still buffered at
+// shutdown aren't handled or committed, so they are redelivered.
+func (p *KeyedPool) Run(ctx context.Context, handle func(ctx context.Context, msg Message)) {
+	inputs := make([]chan Message, p.workers)
+	var wg sync.WaitGroup
+	for i := range inputs {
+		inputs[i] = make(chan Message, 64)
+		wg.Add(1)
+		go func(input <-chan Message) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-input:
+					handle(ctx, msg)
+					p.commit(ctx, msg)
+				}
+			}
+		}(inputs[i])
+	}
+	defer wg.Wait()
+
+	for {
+		msg, err := p.consumer.Consume(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// Don't log EOF errors (happens when no messages available)
+			if err.Error() != "failed to fetch message: EOF" {
+				log.Printf("Failed to consume message: %v\n", err)
+			}
+			continue
+		}
+
+		p.track(msg)
+		select {
+		case <-ctx.Done():
+			return
+		case inputs[p.worker(msg)] <- msg:
+		}
+	}
+}
+
+// worker returns the index of the worker handling msg's key
+func (p *KeyedPool) worker(msg Message) int {
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	return int(h.Sum32() % uint32(p.workers))
+}
+
+// track records a cumulative consumer's message as consumed, ahead of its
+// partition's later messages
+func (p *KeyedPool) track(msg Message) {
+	if _, ok := p.consumer.(cumulativeCommitter); !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	partition := partitionKey(msg)
+	p.pending[partition] = append(p.pending[partition], &pendingMessage{msg: msg})
+}
+
+// commit commits a handled message, or on a cumulative consumer the last
+// of its partition's handled messages not preceded by an unhandled one.
+// Commits are made under the lock so a partition's never go backwards.
+func (p *KeyedPool) commit(ctx context.Context, msg Message) {
+	if _, ok := p.consumer.(cumulativeCommitter); !ok {
+		if err := p.consumer.Commit(ctx, msg); err != nil {
+			log.Printf("Failed to commit offset: %v\n", err)
+		}
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	partition := partitionKey(msg)
+	pending := p.pending[partition]
+	for _, m := range pending {
+		if m.msg.Offset == msg.Offset {
+			m.handled = true
+			break
+		}
+	}
+	n := 0
+	for n < len(pending) && pending[n].handled {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	if err := p.consumer.Commit(ctx, pending[n-1].msg); err != nil {
+		log.Printf("Failed to commit offset: %v\n", err)
+	}
+	p.pending[partition] = pending[n:]
+}
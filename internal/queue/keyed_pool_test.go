@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKeyedPool_CommitsInOrder(t *testing.T) {
+	ctx := context.Background()
+	consumer := &cumulativeRecordingConsumer{}
+	p := NewKeyedPool(consumer, 4)
+
+	msgs := []Message{
+		{Topic: "metrics", Partition: 0, Offset: 1, Key: []byte("90210")},
+		{Topic: "metrics", Partition: 0, Offset: 2, Key: []byte("10001")},
+		{Topic: "metrics", Partition: 0, Offset: 3, Key: []byte("60601")},
+		{Topic: "metrics", Partition: 1, Offset: 1, Key: []byte("33139")},
+	}
+	for _, msg := range msgs {
+		p.track(msg)
+	}
+
+	// Offset 2 is handled first, but offset 1 is still being handled
+	p.commit(ctx, msgs[1])
+	p.commit(ctx, msgs[3])
+	if len(consumer.commits) != 1 || consumer.commits[0].Partition != 1 {
+		t.Fatalf("Expected only partition 1 committed, got %+v", consumer.commits)
+	}
+
+	p.commit(ctx, msgs[0])
+	if last := consumer.commits[len(consumer.commits)-1]; last.Partition != 0 || last.Offset != 2 {
+		t.Errorf("Expected partition 0 committed through offset 2, got %+v", last)
+	}
+	p.commit(ctx, msgs[2])
+	if last := consumer.commits[len(consumer.commits)-1]; last.Offset != 3 || len(p.pending["metrics/0"]) != 0 {
+		t.Errorf("Expected partition 0 committed through offset 3, got %+v", last)
+	}
+}
+
+func TestKeyedPool_SameKeySameWorker(t *testing.T) {
+	p := NewKeyedPool(&recordingConsumer{}, 8)
+	first := p.worker(Message{Key: []byte("90210"), Offset: 1})
+	for offset := int64(2); offset < 10; offset++ {
+		if got := p.worker(Message{Key: []byte("90210"), Offset: offset}); got != first {
+			t.Fatalf("Expected every 90210 message on worker %d, got %d", first, got)
+		}
+	}
+}
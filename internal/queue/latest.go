@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+)
+
+// LatestProducer publishes readings with the producer it wraps and copies
+// each one to a second topic keyed the same way (by zipcode). With that
+// topic log-compacted it holds the latest reading per zipcode, so a new
+// consumer can load current conditions from it (see ReadCompactedTopic)
+// instead of scanning the history or querying Postgres.
+type LatestProducer struct {
+	producer Producer
+	latest   Producer
+}
+
+// NewLatestProducer wraps producer, copying what it publishes to latest.
+// It takes ownership of both.
+func NewLatestProducer(producer, latest Producer) *LatestProducer {
+	return &LatestProducer{
+		producer: producer,
+		latest:   latest,
+	}
+}
+
+// Publish publishes the reading, then copies it to the latest topic. A
+// failed copy is only logged: the reading itself was published, and the
+// station's next reading replaces it.
+func (p *LatestProducer) Publish(ctx context.Context, key string, value []byte) error {
+	if err := p.producer.Publish(ctx, key, value); err != nil {
+		return err
+	}
+	if err := p.latest.Publish(ctx, key, value); err != nil {
+		fmt.Printf("Failed to publish latest reading for %s: %v\n", key, err)
+	}
+	return nil
+}
+
+// IsAsync reports whether the wrapped producer is async
+func (p *LatestProducer) IsAsync() bool {
+	return p.producer.IsAsync()
+}
+
+// Stats returns the wrapped producer's counters
+func (p *LatestProducer) Stats() ProducerStats {
+	return p.producer.Stats()
+}
+
+// Close closes both producers
+func (p *LatestProducer) Close() error {
+	latestErr := p.latest.Close()
+	if err := p.producer.Close(); err != nil {
+		return err
+	}
+	return latestErr
+}
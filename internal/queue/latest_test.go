@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLatestProducer_CopiesPublishedReadings(t *testing.T) {
+	metrics := &switchProducer{}
+	latest := &switchProducer{}
+	p := NewLatestProducer(metrics, latest)
+
+	if err := p.Publish(context.Background(), "10001", []byte("1")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	// A failed copy doesn't fail the publish
+	latest.setDown(true)
+	if err := p.Publish(context.Background(), "10001", []byte("2")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	// Nothing is copied for a reading that wasn't published
+	latest.setDown(false)
+	metrics.setDown(true)
+	if err := p.Publish(context.Background(), "10001", []byte("3")); err == nil {
+		t.Fatal("Expected Publish to fail")
+	}
+
+	if got := metrics.messages(); len(got) != 2 {
+		t.Errorf("Expected 2 published readings, got %v", got)
+	}
+	if got := latest.messages(); len(got) != 1 || got[0] != "1" {
+		t.Errorf("Expected only the first reading copied, got %v", got)
+	}
+}
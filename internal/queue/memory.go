@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMemoryBuffer is how many messages each consumer group of a memory
+// topic holds before publishers block
+const defaultMemoryBuffer = 10000
+
+// MemoryBroker passes messages between components of one process over
+// channels, for small deployments and tests that don't want a broker.
+// Messages are lost when the process exits, and Commit does nothing.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	topics     map[string]*memoryTopic
+	bufferSize int
+}
+
+// memoryTopic fans published messages out to one channel per group
+type memoryTopic struct {
+	groups  map[string]chan Message
+	backlog []Message // published before any group subscribed
+	offset  int64
+}
+
+var (
+	sharedMemoryBroker     *MemoryBroker
+	sharedMemoryBrokerOnce sync.Once
+)
+
+// SharedMemoryBroker returns the process-wide memory broker, so components
+// that each call NewBroker still reach each other
+func SharedMemoryBroker() *MemoryBroker {
+	sharedMemoryBrokerOnce.Do(func() {
+		sharedMemoryBroker = NewMemoryBroker(defaultMemoryBuffer)
+	})
+	return sharedMemoryBroker
+}
+
+// NewMemoryBroker creates a memory broker buffering up to bufferSize
+// messages per consumer group
+func NewMemoryBroker(bufferSize int) *MemoryBroker {
+	if bufferSize <= 0 {
+		bufferSize = defaultMemoryBuffer
+	}
+	return &MemoryBroker{
+		topics:     make(map[string]*memoryTopic),
+		bufferSize: bufferSize,
+	}
+}
+
+func (b *MemoryBroker) topicLocked(name string) *memoryTopic {
+	topic, ok := b.topics[name]
+	if !ok {
+		topic = &memoryTopic{groups: make(map[string]chan Message)}
+		b.topics[name] = topic
+	}
+	return topic
+}
+
+// EnsureTopic creates the topic
+func (b *MemoryBroker) EnsureTopic(topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.topicLocked(topic)
+	return nil
+}
+
+// NewProducer creates a producer for the topic
+func (b *MemoryBroker) NewProducer(topic string) (Producer, error) {
+	return &memoryProducer{broker: b, topic: topic}, nil
+}
+
+// NewConsumer joins a consumer group, creating it if needed. The first
+// group of a topic receives the messages published before it existed.
+func (b *MemoryBroker) NewConsumer(topic, groupID string) (Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topicLocked(topic)
+	ch, ok := t.groups[groupID]
+	if !ok {
+		ch = make(chan Message, b.bufferSize)
+		if len(t.groups) == 0 {
+			for _, msg := range t.backlog {
+				ch <- msg
+			}
+			t.backlog = nil
+		}
+		t.groups[groupID] = ch
+	}
+	return &memoryConsumer{messages: ch}, nil
+}
+
+// Close is a no-op; the broker lives as long as the process
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// publish hands a message to every group of the topic, blocking while a
+// group's buffer is full
+func (b *MemoryBroker) publish(ctx context.Context, topicName string, key string, value []byte) error {
+	b.mu.Lock()
+	t := b.topicLocked(topicName)
+	msg := Message{
+		Topic:   topicName,
+		Offset:  t.offset,
+		Key:     []byte(key),
+		Value:   value,
+		Headers: HeadersFrom(ctx),
+		Time:    time.Now(),
+	}
+	if len(t.groups) == 0 {
+		defer b.mu.Unlock()
+		if len(t.backlog) >= b.bufferSize {
+			return fmt.Errorf("failed to write message: no consumers and backlog of %s is full", topicName)
+		}
+		t.offset++
+		t.backlog = append(t.backlog, msg)
+		return nil
+	}
+	t.offset++
+	groups := make([]chan Message, 0, len(t.groups))
+	for _, ch := range t.groups {
+		groups = append(groups, ch)
+	}
+	b.mu.Unlock()
+
+	for _, ch := range groups {
+		select {
+		case ch <- msg:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to write message: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+type memoryProducer struct {
+	broker *MemoryBroker
+	topic  string
+	producerCounters
+}
+
+func (p *memoryProducer) Publish(ctx context.Context, key string, value []byte) error {
+	err := p.broker.publish(ctx, p.topic, key, value)
+	p.record(value, err)
+	return err
+}
+
+func (p *memoryProducer) IsAsync() bool { return false }
+
+func (p *memoryProducer) Close() error { return nil }
+
+type memoryConsumer struct {
+	pauseGate
+	messages chan Message
+
+	count atomic.Int64
+	bytes atomic.Int64
+}
+
+func (c *memoryConsumer) Consume(ctx context.Context) (Message, error) {
+	if err := c.wait(ctx); err != nil {
+		return Message{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case msg := <-c.messages:
+		c.count.Add(1)
+		c.bytes.Add(int64(len(msg.Value)))
+		return msg, nil
+	}
+}
+
+func (c *memoryConsumer) Commit(ctx context.Context, msg Message) error { return nil }
+
+func (c *memoryConsumer) Stats() ConsumerStats {
+	return ConsumerStats{Messages: c.count.Load(), Bytes: c.bytes.Load()}
+}
+
+func (c *memoryConsumer) Close() error { return nil }
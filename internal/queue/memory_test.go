@@ -0,0 +1,53 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBroker_GroupsEachGetEveryMessage(t *testing.T) {
+	broker := NewMemoryBroker(10)
+	producer, _ := broker.NewProducer("metrics")
+
+	// Published before any group exists: kept for the first group
+	if err := producer.Publish(context.Background(), "10001", []byte("early")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	writer, _ := broker.NewConsumer("metrics", "dbwriter")
+	alarming, _ := broker.NewConsumer("metrics", "alarming")
+	if err := producer.Publish(context.Background(), "10002", []byte("late")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, want := range []string{"early", "late"} {
+		msg, err := writer.Consume(ctx)
+		if err != nil || string(msg.Value) != want {
+			t.Fatalf("dbwriter: expected %q, got %q (%v)", want, msg.Value, err)
+		}
+	}
+	msg, err := alarming.Consume(ctx)
+	if err != nil || string(msg.Value) != "late" || string(msg.Key) != "10002" {
+		t.Fatalf("alarming: expected late message, got %q (%v)", msg.Value, err)
+	}
+
+	stats := producer.Stats()
+	if stats.Messages != 2 || stats.Bytes != int64(len("early")+len("late")) || stats.AvgBatchSize() != 1 {
+		t.Errorf("Unexpected producer stats: %+v", stats)
+	}
+}
+
+func TestMemoryBroker_ConsumeHonoursContext(t *testing.T) {
+	broker := NewMemoryBroker(10)
+	consumer, _ := broker.NewConsumer("metrics", "group")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := consumer.Consume(ctx); err == nil {
+		t.Error("Expected Consume to fail once the context is done")
+	}
+}
@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// keyHeader carries the message key, which NATS has no field for
+const keyHeader = "Weather-Key"
+
+// NATSBroker carries topics over NATS JetStream. Each topic is a stream
+// with the topic as its subject, and each consumer group is a durable pull
+// consumer on that stream, shared by the group's members.
+type NATSBroker struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+// NewNATSBroker connects to a NATS server with JetStream enabled
+func NewNATSBroker(url string) (*NATSBroker, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+	return &NATSBroker{conn: conn, js: js}, nil
+}
+
+// EnsureTopic creates the topic's stream if it does not exist
+func (b *NATSBroker) EnsureTopic(topic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := b.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     natsName(topic),
+		Subjects: []string{topic},
+		Storage:  jetstream.FileStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stream for %s: %w", topic, err)
+	}
+	return nil
+}
+
+// NewProducer creates a producer publishing to the topic's subject
+func (b *NATSBroker) NewProducer(topic string) (Producer, error) {
+	if err := b.EnsureTopic(topic); err != nil {
+		return nil, err
+	}
+	return &natsProducer{js: b.js, topic: topic}, nil
+}
+
+// NewConsumer creates or joins the group's durable consumer
+func (b *NATSBroker) NewConsumer(topic, groupID string) (Consumer, error) {
+	if err := b.EnsureTopic(topic); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	consumer, err := b.js.CreateOrUpdateConsumer(ctx, natsName(topic), jetstream.ConsumerConfig{
+		Durable:   natsName(groupID),
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer %s on %s: %w", groupID, topic, err)
+	}
+	fmt.Printf("Creating new NATS consumer for topic %s in group %s\n", topic, groupID)
+	return &natsConsumer{consumer: consumer, topic: topic}, nil
+}
+
+// Close closes the NATS connection
+func (b *NATSBroker) Close() error {
+	b.conn.Close()
+	return nil
+}
+
+// natsName turns a topic or group into a valid stream or consumer name
+func natsName(name string) string {
+	return strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_").Replace(name)
+}
+
+type natsProducer struct {
+	js    jetstream.JetStream
+	topic string
+	producerCounters
+}
+
+// Publish publishes a message and waits for JetStream to store it
+func (p *natsProducer) Publish(ctx context.Context, key string, value []byte) error {
+	msg := nats.NewMsg(p.topic)
+	for k, v := range HeadersFrom(ctx) {
+		msg.Header.Set(k, v)
+	}
+	msg.Header.Set(keyHeader, key)
+	msg.Data = value
+	_, err := p.js.PublishMsg(ctx, msg)
+	p.record(value, err)
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+func (p *natsProducer) IsAsync() bool { return false }
+
+func (p *natsProducer) Close() error { return nil }
+
+type natsConsumer struct {
+	pauseGate
+	consumer jetstream.Consumer
+	topic    string
+
+	messages atomic.Int64
+	bytes    atomic.Int64
+	errors   atomic.Int64
+}
+
+// Consume waits for the next message, polling so ctx is honoured
+func (c *natsConsumer) Consume(ctx context.Context) (Message, error) {
+	if err := c.wait(ctx); err != nil {
+		return Message{}, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return Message{}, err
+		}
+
+		msg, err := c.consumer.Next(jetstream.FetchMaxWait(time.Second))
+		if errors.Is(err, nats.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			c.errors.Add(1)
+			return Message{}, fmt.Errorf("failed to read message: %w", err)
+		}
+
+		c.messages.Add(1)
+		c.bytes.Add(int64(len(msg.Data())))
+		result := Message{
+			Topic:   c.topic,
+			Key:     []byte(msg.Headers().Get(keyHeader)),
+			Value:   msg.Data(),
+			Headers: natsHeaders(msg.Headers()),
+			ack:     msg,
+		}
+		if meta, err := msg.Metadata(); err == nil {
+			result.Offset = int64(meta.Sequence.Stream)
+			result.Time = meta.Timestamp
+		}
+		return result, nil
+	}
+}
+
+// natsHeaders returns a message's headers other than the key
+func natsHeaders(header nats.Header) Headers {
+	var result Headers
+	for k := range header {
+		if k == keyHeader {
+			continue
+		}
+		if result == nil {
+			result = make(Headers, len(header))
+		}
+		result[k] = header.Get(k)
+	}
+	return result
+}
+
+// Commit acknowledges the message
+func (c *natsConsumer) Commit(ctx context.Context, msg Message) error {
+	natsMsg, ok := msg.ack.(jetstream.Msg)
+	if !ok {
+		return fmt.Errorf("failed to commit message: not read from NATS")
+	}
+	if err := natsMsg.Ack(); err != nil {
+		return fmt.Errorf("failed to commit message: %w", err)
+	}
+	return nil
+}
+
+func (c *natsConsumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Messages: c.messages.Load(),
+		Bytes:    c.bytes.Load(),
+		Errors:   c.errors.Load(),
+	}
+}
+
+func (c *natsConsumer) Close() error { return nil }
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// pauseGate implements Consumer's Pause, Resume and Paused. Consume waits
+// at the gate while the consumer is paused.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // closed by Resume
+}
+
+// Pause stops Consume from returning messages until Resume
+func (g *pauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets a paused Consume continue
+func (g *pauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resumed)
+	}
+}
+
+// Paused reports whether the consumer is paused
+func (g *pauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait blocks while the consumer is paused
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	if !g.paused {
+		g.mu.Unlock()
+		return nil
+	}
+	resumed := g.resumed
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+// PauseWhileDown pauses consumer while check fails, checking again every
+// interval, and resumes it once check passes. It returns nil straight away
+// if check passes, and ctx's error if ctx is done first. Consumers call it
+// when a dependency such as the database looks down, so they wait for it
+// instead of failing message after message in a tight loop.
+func PauseWhileDown(ctx context.Context, consumer Consumer, dependency string, interval time.Duration, check func(context.Context) error) error {
+	err := check(ctx)
+	if err == nil {
+		return nil
+	}
+
+	consumer.Pause()
+	defer consumer.Resume()
+	fmt.Printf("%s unavailable, pausing consumption: %v\n", dependency, err)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if err := check(ctx); err == nil {
+			fmt.Printf("%s is back, resuming consumption\n", dependency)
+			return nil
+		}
+	}
+}
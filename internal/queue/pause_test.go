@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsumer_PauseBlocksConsume(t *testing.T) {
+	broker := NewMemoryBroker(10)
+	consumer, _ := broker.NewConsumer("metrics", "group")
+	producer, _ := broker.NewProducer("metrics")
+	producer.Publish(context.Background(), "10001", []byte("reading"))
+
+	consumer.Pause()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := consumer.Consume(ctx); err == nil {
+		t.Fatal("Expected a paused Consume to wait until the context is done")
+	}
+
+	consumer.Resume()
+	msg, err := consumer.Consume(context.Background())
+	if err != nil || string(msg.Value) != "reading" {
+		t.Fatalf("Expected the reading after Resume, got %q (%v)", msg.Value, err)
+	}
+}
+
+func TestPauseWhileDown(t *testing.T) {
+	broker := NewMemoryBroker(10)
+	consumer, _ := broker.NewConsumer("metrics", "group")
+
+	var checks atomic.Int32
+	check := func(ctx context.Context) error {
+		if checks.Add(1) < 3 {
+			if !consumer.Paused() && checks.Load() > 1 {
+				t.Error("Expected the consumer to be paused while the check fails")
+			}
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	if err := PauseWhileDown(context.Background(), consumer, "Database", time.Millisecond, check); err != nil {
+		t.Fatalf("PauseWhileDown failed: %v", err)
+	}
+	if consumer.Paused() {
+		t.Error("Expected the consumer to be resumed")
+	}
+	if checks.Load() != 3 {
+		t.Errorf("Expected 3 checks, got %d", checks.Load())
+	}
+}
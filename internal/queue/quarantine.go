@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// Quarantine diverts readings outside their physical ranges to a separate
+// topic so garbage sensor values never reach storage, aggregates or alarms,
+// but remain available for inspection
+type Quarantine struct {
+	ranges   protocol.MetricRanges
+	producer Producer
+}
+
+// NewQuarantine creates a quarantine checking readings against ranges and
+// publishing rejected ones with producer, which it takes ownership of
+func NewQuarantine(producer Producer, ranges protocol.MetricRanges) *Quarantine {
+	return &Quarantine{
+		ranges:   ranges,
+		producer: producer,
+	}
+}
+
+// Close closes the quarantine producer
+func (q *Quarantine) Close() error {
+	if q == nil {
+		return nil
+	}
+	return q.producer.Close()
+}
+
+// Divert checks a metric message (already in canonical units) and, if it is
+// out of range, publishes it to the quarantine topic. It returns the
+// *protocol.RangeError when the message was diverted, in which case it must
+// not be published to the metrics topic. A nil Quarantine accepts everything.
+func (q *Quarantine) Divert(ctx context.Context, msg *protocol.MetricMessage) error {
+	if q == nil {
+		return nil
+	}
+
+	err := q.ranges.Check(&msg.Data)
+	var rangeErr *protocol.RangeError
+	if !errors.As(err, &rangeErr) {
+		return nil
+	}
+
+	quarantined := &protocol.QuarantinedMetric{
+		MetricMessage: *msg,
+		Reason:        rangeErr.Error(),
+		Violations:    rangeErr.Violations,
+		QuarantinedAt: time.Now(),
+	}
+	data, encErr := json.Marshal(quarantined)
+	if encErr != nil {
+		fmt.Printf("Failed to encode quarantined metric: %v\n", encErr)
+		return err
+	}
+	if pubErr := q.producer.Publish(ctx, msg.Zipcode, data); pubErr != nil {
+		fmt.Printf("Failed to publish quarantined metric for zipcode %s: %v\n", msg.Zipcode, pubErr)
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// Message is a message read from a topic, whichever backend carried it
+type Message struct {
+	Topic     string
+	Partition int   // Kafka partition; 0 for backends without partitions
+	Offset    int64 // position in the partition or stream, where the backend has one
+	Key       []byte
+	Value     []byte
+	Headers   Headers
+	Time      time.Time
+
+	ack interface{} // backend handle used by Commit
+}
+
+// Producer publishes messages to one topic. Messages with the same key are
+// delivered in order. Headers set on ctx with WithHeaders are attached to
+// the message.
+type Producer interface {
+	Publish(ctx context.Context, key string, value []byte) error
+	// IsAsync reports whether Publish returns before the backend has
+	// durably accepted the message
+	IsAsync() bool
+	Stats() ProducerStats
+	Close() error
+}
+
+// ProducerStats counts what a producer has written
+type ProducerStats struct {
+	Messages int64 // messages the backend accepted
+	Bytes    int64
+	Errors   int64 // messages that failed to write
+	Batches  int64 // writes to the backend; one per message where the backend doesn't batch
+	Pending  int64 // async only: published but not yet written or failed
+}
+
+// AvgBatchSize returns the mean number of messages per write
+func (s ProducerStats) AvgBatchSize() float64 {
+	if s.Batches == 0 {
+		return 0
+	}
+	return float64(s.Messages) / float64(s.Batches)
+}
+
+// producerCounters tracks ProducerStats for producers that write one
+// message per Publish
+type producerCounters struct {
+	messages atomic.Int64
+	bytes    atomic.Int64
+	errors   atomic.Int64
+}
+
+func (c *producerCounters) record(value []byte, err error) {
+	if err != nil {
+		c.errors.Add(1)
+		return
+	}
+	c.messages.Add(1)
+	c.bytes.Add(int64(len(value)))
+}
+
+func (c *producerCounters) Stats() ProducerStats {
+	messages := c.messages.Load()
+	return ProducerStats{
+		Messages: messages,
+		Bytes:    c.bytes.Load(),
+		Errors:   c.errors.Load(),
+		Batches:  messages,
+	}
+}
+
+// Consumer reads a topic as a member of a consumer group: every group sees
+// every message, and the members of a group share them
+type Consumer interface {
+	Consume(ctx context.Context) (Message, error)
+	// Commit marks a message as processed so it is not redelivered
+	Commit(ctx context.Context, msg Message) error
+	// Pause stops Consume from returning messages until Resume, so a
+	// consumer whose dependencies are down stops fetching. A Consume
+	// already waiting for a message may still return one.
+	Pause()
+	Resume()
+	Paused() bool
+	Stats() ConsumerStats
+	Close() error
+}
+
+// ConsumerStats counts what a consumer has read
+type ConsumerStats struct {
+	Messages int64
+	Bytes    int64
+	Errors   int64
+}
+
+// Broker creates producers and consumers for one message-queue backend
+type Broker interface {
+	NewProducer(topic string) (Producer, error)
+	NewConsumer(topic, groupID string) (Consumer, error)
+	// EnsureTopic creates a topic if the backend needs it created up front
+	EnsureTopic(topic string) error
+	Close() error
+}
+
+// Backends
+const (
+	BackendKafka    = "kafka"
+	BackendNATS     = "nats"
+	BackendRabbitMQ = "rabbitmq"
+	BackendMemory   = "memory"
+	BackendBolt     = "bolt"
+)
+
+// NewBroker connects to the backend selected by QUEUE_BACKEND. Its
+// producers retry failed publishes as configured in QueueConfig. It also
+// selects the payload encoding (QUEUE_ENCODING) for the process, since
+// every service that publishes creates a broker first.
+func NewBroker(cfg *config.Config) (Broker, error) {
+	if err := protocol.SetPayloadEncoding(protocol.Encoding(cfg.Queue.Encoding)); err != nil {
+		return nil, err
+	}
+
+	broker, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &retryBroker{Broker: broker, config: RetryConfig{
+		MaxRetries:       cfg.Queue.PublishRetries,
+		InitialBackoff:   cfg.Queue.PublishBackoff,
+		MaxBackoff:       cfg.Queue.PublishMaxBackoff,
+		BreakerThreshold: cfg.Queue.BreakerThreshold,
+		BreakerCooldown:  cfg.Queue.BreakerCooldown,
+	}}, nil
+}
+
+func newBackend(cfg *config.Config) (Broker, error) {
+	switch cfg.Queue.Backend {
+	case BackendKafka, "":
+		return NewKafkaBroker(&cfg.Kafka), nil
+	case BackendNATS:
+		return NewNATSBroker(cfg.Queue.NATSURL)
+	case BackendRabbitMQ:
+		return NewRabbitMQBroker(cfg.Queue.RabbitMQURL)
+	case BackendMemory:
+		return SharedMemoryBroker(), nil
+	case BackendBolt:
+		return SharedBoltBroker(cfg.Queue.BoltPath)
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q (expected kafka, nats, rabbitmq, memory or bolt)", cfg.Queue.Backend)
+	}
+}
@@ -0,0 +1,225 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQBroker carries topics over RabbitMQ. Each topic is a durable
+// fanout exchange and each consumer group a durable queue bound to it, so
+// every group receives every message and a group's members share its queue.
+// RabbitMQ has no partitions, so per-key ordering holds only while a group
+// has a single member.
+type RabbitMQBroker struct {
+	conn *amqp.Connection
+}
+
+// NewRabbitMQBroker connects to a RabbitMQ server
+func NewRabbitMQBroker(url string) (*RabbitMQBroker, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	return &RabbitMQBroker{conn: conn}, nil
+}
+
+// EnsureTopic declares the topic's exchange
+func (b *RabbitMQBroker) EnsureTopic(topic string) error {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+	return declareExchange(ch, topic)
+}
+
+func declareExchange(ch *amqp.Channel, topic string) error {
+	if err := ch.ExchangeDeclare(topic, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", topic, err)
+	}
+	return nil
+}
+
+// NewProducer creates a producer with its own channel in confirm mode
+func (b *RabbitMQBroker) NewProducer(topic string) (Producer, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := declareExchange(ch, topic); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	return &rabbitProducer{ch: ch, topic: topic}, nil
+}
+
+// NewConsumer declares the group's queue, binds it to the topic and starts
+// receiving deliveries
+func (b *RabbitMQBroker) NewConsumer(topic, groupID string) (Consumer, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := declareExchange(ch, topic); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	queueName := topic + "." + groupID
+	if _, err := ch.QueueDeclare(queueName, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+	if err := ch.QueueBind(queueName, "", topic, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to bind queue %s: %w", queueName, err)
+	}
+	if err := ch.Qos(100, 0, false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(queueName, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to consume %s: %w", queueName, err)
+	}
+
+	fmt.Printf("Creating new RabbitMQ consumer for topic %s in group %s\n", topic, groupID)
+	return &rabbitConsumer{ch: ch, topic: topic, deliveries: deliveries}, nil
+}
+
+// Close closes the RabbitMQ connection
+func (b *RabbitMQBroker) Close() error {
+	return b.conn.Close()
+}
+
+type rabbitProducer struct {
+	mu    sync.Mutex // a channel must not be used concurrently
+	ch    *amqp.Channel
+	topic string
+	producerCounters
+}
+
+// Publish publishes a persistent message and waits for the broker's confirm
+func (p *rabbitProducer) Publish(ctx context.Context, key string, value []byte) error {
+	err := p.publish(ctx, key, value)
+	p.record(value, err)
+	return err
+}
+
+func (p *rabbitProducer) publish(ctx context.Context, key string, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	publishing := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Body:         value,
+	}
+	if headers := HeadersFrom(ctx); len(headers) > 0 {
+		publishing.Headers = make(amqp.Table, len(headers))
+		for k, v := range headers {
+			publishing.Headers[k] = v
+		}
+	}
+	confirm, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, p.topic, key, false, false, publishing)
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	ok, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("failed to write message: rejected by broker")
+	}
+	return nil
+}
+
+func (p *rabbitProducer) IsAsync() bool { return false }
+
+func (p *rabbitProducer) Close() error {
+	return p.ch.Close()
+}
+
+type rabbitConsumer struct {
+	pauseGate
+	ch         *amqp.Channel
+	topic      string
+	deliveries <-chan amqp.Delivery
+
+	messages atomic.Int64
+	bytes    atomic.Int64
+	errors   atomic.Int64
+}
+
+// Consume waits for the next delivery
+func (c *rabbitConsumer) Consume(ctx context.Context) (Message, error) {
+	if err := c.wait(ctx); err != nil {
+		return Message{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case delivery, ok := <-c.deliveries:
+		if !ok {
+			c.errors.Add(1)
+			return Message{}, fmt.Errorf("failed to read message: channel closed")
+		}
+		c.messages.Add(1)
+		c.bytes.Add(int64(len(delivery.Body)))
+		return Message{
+			Topic:   c.topic,
+			Offset:  int64(delivery.DeliveryTag),
+			Key:     []byte(delivery.RoutingKey),
+			Value:   delivery.Body,
+			Headers: amqpHeaders(delivery.Headers),
+			Time:    delivery.Timestamp,
+			ack:     delivery,
+		}, nil
+	}
+}
+
+func amqpHeaders(table amqp.Table) Headers {
+	if len(table) == 0 {
+		return nil
+	}
+	result := make(Headers, len(table))
+	for k, v := range table {
+		if s, ok := v.(string); ok {
+			result[k] = s
+		}
+	}
+	return result
+}
+
+// Commit acknowledges the delivery
+func (c *rabbitConsumer) Commit(ctx context.Context, msg Message) error {
+	delivery, ok := msg.ack.(amqp.Delivery)
+	if !ok {
+		return fmt.Errorf("failed to commit message: not read from RabbitMQ")
+	}
+	if err := delivery.Ack(false); err != nil {
+		return fmt.Errorf("failed to commit message: %w", err)
+	}
+	return nil
+}
+
+func (c *rabbitConsumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Messages: c.messages.Load(),
+		Bytes:    c.bytes.Load(),
+		Errors:   c.errors.Load(),
+	}
+}
+
+func (c *rabbitConsumer) Close() error {
+	return c.ch.Close()
+}
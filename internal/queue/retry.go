@@ -0,0 +1,256 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = &QueueError{"circuit breaker open"}
+)
+
+// QueueError represents a message queue error
+type QueueError struct {
+	msg string
+}
+
+func (e *QueueError) Error() string {
+	return e.msg
+}
+
+// ErrorHandler is called for messages an async producer failed to deliver
+// after Publish had already returned
+type ErrorHandler func(msg Message, err error)
+
+// asyncErrorReporter is implemented by producers that can report write
+// errors that happen after Publish returns
+type asyncErrorReporter interface {
+	SetErrorHandler(fn ErrorHandler)
+}
+
+// RetryConfig controls publish retries and the circuit breaker
+type RetryConfig struct {
+	MaxRetries     int           // Retries after the first attempt; 0 disables retrying
+	InitialBackoff time.Duration // Wait before the first retry, doubled on each one
+	MaxBackoff     time.Duration
+
+	// After BreakerThreshold consecutive failures, publishes fail fast with
+	// ErrCircuitOpen for BreakerCooldown; then a single publish is let
+	// through to probe the backend. 0 disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// RetryProducer wraps a Producer with retries, exponential backoff and a
+// circuit breaker, so a broker hiccup delays messages rather than losing
+// them, and a broker outage does not stall every caller for the full
+// retry schedule.
+type RetryProducer struct {
+	producer Producer
+	topic    string
+	config   RetryConfig
+	breaker  circuitBreaker
+
+	handlerMu sync.RWMutex
+	onError   ErrorHandler
+
+	retries      atomic.Int64
+	failures     atomic.Int64
+	rejected     atomic.Int64
+	asyncFailure atomic.Int64
+}
+
+// RetryStats counts what the retry layer has done
+type RetryStats struct {
+	Retries       int64 // publish attempts after the first
+	Failures      int64 // publishes that failed after all retries
+	Rejected      int64 // publishes refused while the breaker was open
+	AsyncFailures int64 // messages an async producer failed to deliver
+	BreakerOpen   bool
+}
+
+// NewRetryProducer wraps producer, which writes to topic
+func NewRetryProducer(producer Producer, topic string, config RetryConfig) *RetryProducer {
+	p := &RetryProducer{
+		producer: producer,
+		topic:    topic,
+		config:   config,
+		breaker:  circuitBreaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown},
+	}
+	if reporter, ok := producer.(asyncErrorReporter); ok {
+		reporter.SetErrorHandler(p.asyncError)
+	}
+	return p
+}
+
+// SetErrorHandler sets the callback for messages an async producer failed
+// to deliver. Without one, failures are logged.
+func (p *RetryProducer) SetErrorHandler(fn ErrorHandler) {
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
+	p.onError = fn
+}
+
+func (p *RetryProducer) asyncError(msg Message, err error) {
+	p.asyncFailure.Add(1)
+	p.recordFailure()
+
+	p.handlerMu.RLock()
+	fn := p.onError
+	p.handlerMu.RUnlock()
+	if fn != nil {
+		fn(msg, err)
+		return
+	}
+	fmt.Printf("Failed to deliver message with key %s to %s: %v\n", msg.Key, p.topic, err)
+}
+
+// Publish publishes the message, retrying failures with exponential backoff
+// until it succeeds, the retries run out, ctx ends or the breaker opens
+func (p *RetryProducer) Publish(ctx context.Context, key string, value []byte) error {
+	backoff := p.config.InitialBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if !p.breaker.allow(time.Now()) {
+			p.rejected.Add(1)
+			return fmt.Errorf("failed to write message to %s: %w", p.topic, ErrCircuitOpen)
+		}
+
+		err = p.producer.Publish(ctx, key, value)
+		if err == nil {
+			if p.breaker.success() {
+				fmt.Printf("Circuit breaker for %s closed\n", p.topic)
+			}
+			return nil
+		}
+		p.recordFailure()
+
+		if attempt >= p.config.MaxRetries || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		p.retries.Add(1)
+		backoff *= 2
+		if p.config.MaxBackoff > 0 && backoff > p.config.MaxBackoff {
+			backoff = p.config.MaxBackoff
+		}
+	}
+
+	p.failures.Add(1)
+	return err
+}
+
+func (p *RetryProducer) recordFailure() {
+	if p.breaker.failure(time.Now()) {
+		fmt.Printf("Circuit breaker for %s opened after %d consecutive failures; retrying in %v\n",
+			p.topic, p.config.BreakerThreshold, p.config.BreakerCooldown)
+	}
+}
+
+// IsAsync reports whether the wrapped producer is async
+func (p *RetryProducer) IsAsync() bool {
+	return p.producer.IsAsync()
+}
+
+// Stats returns the wrapped producer's counters
+func (p *RetryProducer) Stats() ProducerStats {
+	return p.producer.Stats()
+}
+
+// RetryStats returns retry and breaker counters
+func (p *RetryProducer) RetryStats() RetryStats {
+	return RetryStats{
+		Retries:       p.retries.Load(),
+		Failures:      p.failures.Load(),
+		Rejected:      p.rejected.Load(),
+		AsyncFailures: p.asyncFailure.Load(),
+		BreakerOpen:   p.breaker.isOpen(time.Now()),
+	}
+}
+
+// Close closes the wrapped producer
+func (p *RetryProducer) Close() error {
+	return p.producer.Close()
+}
+
+// circuitBreaker counts consecutive failures. Once the threshold is hit it
+// is open until the cooldown passes, then lets one probe through: success
+// closes it, failure opens it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success records a successful publish, reporting whether it closed the breaker
+func (b *circuitBreaker) success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.threshold > 0 && b.failures >= b.threshold
+	b.failures = 0
+	b.probing = false
+	return wasOpen
+}
+
+// failure records a failed publish, reporting whether it opened the breaker
+func (b *circuitBreaker) failure(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return false
+	}
+	wasOpen := b.failures >= b.threshold
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+		return !wasOpen
+	}
+	return false
+}
+
+func (b *circuitBreaker) isOpen(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.threshold > 0 && b.failures >= b.threshold && now.Before(b.openUntil)
+}
+
+// retryBroker wraps every producer of a broker in a RetryProducer
+type retryBroker struct {
+	Broker
+	config RetryConfig
+}
+
+func (b *retryBroker) NewProducer(topic string) (Producer, error) {
+	producer, err := b.Broker.NewProducer(topic)
+	if err != nil {
+		return nil, err
+	}
+	return NewRetryProducer(producer, topic, b.config), nil
+}
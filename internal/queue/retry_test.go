@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyProducer fails its first failures publishes
+type flakyProducer struct {
+	failures int
+	calls    int
+	onError  ErrorHandler
+}
+
+func (p *flakyProducer) Publish(ctx context.Context, key string, value []byte) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func (p *flakyProducer) IsAsync() bool                   { return false }
+func (p *flakyProducer) Stats() ProducerStats            { return ProducerStats{} }
+func (p *flakyProducer) Close() error                    { return nil }
+func (p *flakyProducer) SetErrorHandler(fn ErrorHandler) { p.onError = fn }
+
+func TestRetryProducer_RetriesUntilSuccess(t *testing.T) {
+	inner := &flakyProducer{failures: 2}
+	p := NewRetryProducer(inner, "metrics", RetryConfig{MaxRetries: 3, InitialBackoff: time.Millisecond})
+
+	if err := p.Publish(context.Background(), "k", []byte("v")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", inner.calls)
+	}
+	if stats := p.RetryStats(); stats.Retries != 2 || stats.Failures != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRetryProducer_GivesUp(t *testing.T) {
+	inner := &flakyProducer{failures: 10}
+	p := NewRetryProducer(inner, "metrics", RetryConfig{MaxRetries: 2, InitialBackoff: time.Millisecond})
+
+	if err := p.Publish(context.Background(), "k", []byte("v")); err == nil {
+		t.Fatal("Expected Publish to fail")
+	}
+	if inner.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", inner.calls)
+	}
+	if stats := p.RetryStats(); stats.Failures != 1 {
+		t.Errorf("Expected 1 failure, got %d", stats.Failures)
+	}
+}
+
+func TestRetryProducer_CircuitBreaker(t *testing.T) {
+	inner := &flakyProducer{failures: 3}
+	p := NewRetryProducer(inner, "metrics", RetryConfig{BreakerThreshold: 3, BreakerCooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 3; i++ {
+		p.Publish(context.Background(), "k", []byte("v"))
+	}
+
+	err := p.Publish(context.Background(), "k", []byte("v"))
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("Open breaker should not reach the producer, got %d calls", inner.calls)
+	}
+
+	// After the cooldown a probe goes through and closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	if err := p.Publish(context.Background(), "k", []byte("v")); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if stats := p.RetryStats(); stats.BreakerOpen || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRetryProducer_AsyncErrors(t *testing.T) {
+	inner := &flakyProducer{}
+	p := NewRetryProducer(inner, "metrics", RetryConfig{})
+
+	var failedKey string
+	p.SetErrorHandler(func(msg Message, err error) {
+		failedKey = string(msg.Key)
+	})
+	inner.onError(Message{Key: []byte("94107")}, errors.New("write timeout"))
+
+	if failedKey != "94107" {
+		t.Errorf("Expected error handler to get key 94107, got %q", failedKey)
+	}
+	if stats := p.RetryStats(); stats.AsyncFailures != 1 {
+		t.Errorf("Expected 1 async failure, got %d", stats.AsyncFailures)
+	}
+}
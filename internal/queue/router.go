@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RouteMatch decides whether a message goes to a rule's topic
+type RouteMatch func(ctx context.Context, key string, value []byte) bool
+
+type routeRule struct {
+	topic string
+	match RouteMatch
+}
+
+type topicKey struct{}
+
+// WithTopic returns a context whose publishes through a Router go to topic,
+// ahead of any rule
+func WithTopic(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, topicKey{}, topic)
+}
+
+// Router is a Producer that sends each message to a topic chosen by rule,
+// so a service publishing metrics, events and quarantined readings needs
+// one producer rather than one per topic. A message goes to the topic set
+// on its context with WithTopic, else to the first matching rule's topic,
+// else to the default topic. Producers are created on first use and shared.
+type Router struct {
+	broker       Broker
+	defaultTopic string
+
+	mu        sync.Mutex
+	rules     []routeRule
+	producers map[string]Producer
+	order     []string // topics in the order their producers were added
+}
+
+// NewRouter creates a router publishing through broker, sending messages
+// no rule matches to defaultTopic
+func NewRouter(broker Broker, defaultTopic string) *Router {
+	return &Router{
+		broker:       broker,
+		defaultTopic: defaultTopic,
+		producers:    make(map[string]Producer),
+	}
+}
+
+// AddRule sends messages match accepts to topic. Rules are tried in the
+// order they were added.
+func (r *Router) AddRule(topic string, match RouteMatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, routeRule{topic: topic, match: match})
+}
+
+// SetProducer makes the router publish to topic with producer, e.g. one
+// wrapped in a spool, instead of creating one. The router takes ownership
+// of it.
+func (r *Router) SetProducer(topic string, producer Producer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.producers[topic]; ok {
+		old.Close()
+	} else {
+		r.order = append(r.order, topic)
+	}
+	r.producers[topic] = producer
+}
+
+// Topic returns a Producer publishing to topic through the router. Closing
+// it is a no-op; the router closes the underlying producer.
+func (r *Router) Topic(topic string) Producer {
+	return &routedProducer{router: r, topic: topic}
+}
+
+// route picks the topic for a message
+func (r *Router) route(ctx context.Context, key string, value []byte) string {
+	if topic, ok := ctx.Value(topicKey{}).(string); ok && topic != "" {
+		return topic
+	}
+
+	r.mu.Lock()
+	rules := r.rules
+	r.mu.Unlock()
+	for _, rule := range rules {
+		if rule.match(ctx, key, value) {
+			return rule.topic
+		}
+	}
+	return r.defaultTopic
+}
+
+// producer returns the producer for topic, creating it on first use
+func (r *Router) producer(topic string) (Producer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if producer, ok := r.producers[topic]; ok {
+		return producer, nil
+	}
+
+	producer, err := r.broker.NewProducer(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create producer for %s: %w", topic, err)
+	}
+	r.producers[topic] = producer
+	r.order = append(r.order, topic)
+	return producer, nil
+}
+
+// Publish sends a message to the topic its context or the rules select
+func (r *Router) Publish(ctx context.Context, key string, value []byte) error {
+	producer, err := r.producer(r.route(ctx, key, value))
+	if err != nil {
+		return err
+	}
+	return producer.Publish(ctx, key, value)
+}
+
+// IsAsync reports whether the default topic's producer is async
+func (r *Router) IsAsync() bool {
+	producer, err := r.producer(r.defaultTopic)
+	return err == nil && producer.IsAsync()
+}
+
+// Stats returns the counters of all the router's producers added up
+func (r *Router) Stats() ProducerStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total ProducerStats
+	for _, producer := range r.producers {
+		stats := producer.Stats()
+		total.Messages += stats.Messages
+		total.Bytes += stats.Bytes
+		total.Errors += stats.Errors
+		total.Batches += stats.Batches
+		total.Pending += stats.Pending
+	}
+	return total
+}
+
+// Close closes every producer the router created or was given
+func (r *Router) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var firstErr error
+	for _, topic := range r.order {
+		if err := r.producers[topic].Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close producer for %s: %w", topic, err)
+		}
+	}
+	r.producers = make(map[string]Producer)
+	r.order = nil
+	return firstErr
+}
+
+// routedProducer is a Router's view of one topic
+type routedProducer struct {
+	router *Router
+	topic  string
+}
+
+func (p *routedProducer) Publish(ctx context.Context, key string, value []byte) error {
+	return p.router.Publish(WithTopic(ctx, p.topic), key, value)
+}
+
+func (p *routedProducer) IsAsync() bool {
+	producer, err := p.router.producer(p.topic)
+	return err == nil && producer.IsAsync()
+}
+
+func (p *routedProducer) Stats() ProducerStats {
+	producer, err := p.router.producer(p.topic)
+	if err != nil {
+		return ProducerStats{}
+	}
+	return producer.Stats()
+}
+
+func (p *routedProducer) Close() error {
+	return nil
+}
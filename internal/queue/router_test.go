@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRouter_RoutesByRule(t *testing.T) {
+	broker := NewMemoryBroker(10)
+	metrics, _ := broker.NewConsumer("metrics", "test")
+	events, _ := broker.NewConsumer("events", "test")
+	quarantine, _ := broker.NewConsumer("quarantine", "test")
+
+	router := NewRouter(broker, "metrics")
+	defer router.Close()
+	router.AddRule("events", func(ctx context.Context, key string, value []byte) bool {
+		return bytes.HasPrefix(value, []byte("event:"))
+	})
+
+	ctx := context.Background()
+	router.Publish(ctx, "10001", []byte("reading"))
+	router.Publish(ctx, "10001", []byte("event:connected"))
+	// An explicit topic wins over the rules
+	router.Publish(WithTopic(ctx, "quarantine"), "10001", []byte("event:bad"))
+	router.Topic("quarantine").Publish(ctx, "10001", []byte("bad reading"))
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	for consumer, want := range map[Consumer][]string{
+		metrics:    {"reading"},
+		events:     {"event:connected"},
+		quarantine: {"event:bad", "bad reading"},
+	} {
+		for _, w := range want {
+			msg, err := consumer.Consume(ctx)
+			if err != nil || string(msg.Value) != w {
+				t.Fatalf("Expected %q on its topic, got %q (%v)", w, msg.Value, err)
+			}
+		}
+	}
+
+	if stats := router.Stats(); stats.Messages != 4 {
+		t.Errorf("Expected 4 messages across topics, got %d", stats.Messages)
+	}
+}
@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/internal/protocol"
+)
+
+// SessionWriter consumes connection events and records each station
+// connection in the connection_sessions table. Events are few compared to
+// metrics, so each one is written as it arrives.
+type SessionWriter struct {
+	consumer Consumer
+	db       *database.DB
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewSessionWriter creates a new session writer
+func NewSessionWriter(consumer Consumer, db *database.DB) *SessionWriter {
+	return &SessionWriter{
+		consumer: consumer,
+		db:       db,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins consuming connection events
+func (sw *SessionWriter) Start(ctx context.Context) error {
+	sw.wg.Add(1)
+	go sw.run(ctx)
+	return nil
+}
+
+// Stop stops the session writer
+func (sw *SessionWriter) Stop() {
+	close(sw.stopCh)
+	sw.wg.Wait()
+}
+
+func (sw *SessionWriter) run(ctx context.Context) {
+	defer sw.wg.Done()
+
+	// Consume in a goroutine so Stop doesn't wait for the next event
+	msgChan := make(chan Message, 10)
+	go func() {
+		for {
+			msg, err := sw.consumer.Consume(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				fmt.Printf("Connection event consumer error: %v\n", err)
+				continue
+			}
+			msgChan <- msg
+		}
+	}()
+
+	for {
+		select {
+		case <-sw.stopCh:
+			return
+
+		case msg := <-msgChan:
+			var event protocol.ConnectionEvent
+			if err := json.Unmarshal(msg.Value, &event); err != nil {
+				fmt.Printf("Failed to decode connection event: %v\n", err)
+			} else if err := sw.record(ctx, &event); err != nil {
+				fmt.Printf("Failed to record %s event for %s: %v\n", event.Type, event.ConnectionID, err)
+				continue
+			}
+
+			if err := sw.consumer.Commit(ctx, msg); err != nil {
+				fmt.Printf("Failed to commit offset: %v\n", err)
+			}
+		}
+	}
+}
+
+func (sw *SessionWriter) record(ctx context.Context, event *protocol.ConnectionEvent) error {
+	session := &database.ConnectionSession{
+		ConnectionID: event.ConnectionID,
+		Zipcode:      event.Zipcode,
+		City:         event.City,
+		StationID:    event.StationID,
+		RemoteAddr:   event.RemoteAddr,
+		ConnectedAt:  event.ConnectedAt,
+	}
+
+	switch event.Type {
+	case protocol.ConnectionEventConnected:
+		return sw.db.StartConnectionSession(ctx, session)
+	case protocol.ConnectionEventIdle:
+		return sw.db.MarkConnectionSessionIdle(ctx, event.ConnectionID, event.Timestamp)
+	case protocol.ConnectionEventDisconnected:
+		duration := int(event.Timestamp.Sub(event.ConnectedAt).Seconds())
+		session.DisconnectedAt = &event.Timestamp
+		session.DurationSeconds = &duration
+		if event.Reason != "" {
+			session.DisconnectReason = &event.Reason
+		}
+		return sw.db.EndConnectionSession(ctx, session)
+	default:
+		return nil
+	}
+}
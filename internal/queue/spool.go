@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var spoolBucket = []byte("spool")
+
+// spoolReplayBatch is how many spooled messages are read per transaction
+const spoolReplayBatch = 100
+
+// SpoolProducer wraps a Producer with a local write-ahead spool. When a
+// publish fails the message is written to a bbolt file instead, and a
+// background loop replays the spool, oldest first, once the backend accepts
+// writes again. While anything is spooled new messages are spooled behind
+// it, so per-key order is kept. The spool survives restarts.
+type SpoolProducer struct {
+	producer    Producer
+	db          *bolt.DB
+	maxMessages int64
+
+	pending  atomic.Int64
+	spooled  atomic.Int64
+	replayed atomic.Int64
+	dropped  atomic.Int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// SpoolReport tells the publisher whether a message was only spooled, so
+// it isn't reported as persisted
+type SpoolReport struct {
+	Spooled bool
+}
+
+// spoolReportKey is the context key of a publish's SpoolReport
+type spoolReportKey struct{}
+
+// WithSpoolReport returns a context whose publish through a SpoolProducer
+// records in the report whether the message was spooled
+func WithSpoolReport(ctx context.Context) (context.Context, *SpoolReport) {
+	report := &SpoolReport{}
+	return context.WithValue(ctx, spoolReportKey{}, report), report
+}
+
+// SpoolStats counts spool activity
+type SpoolStats struct {
+	Pending  int64 // messages waiting to be replayed
+	Spooled  int64
+	Replayed int64
+	Dropped  int64 // messages lost because the spool was full
+}
+
+// NewSpoolProducer opens the spool file at path, replays whatever a
+// previous run left in it, and retries the spool every replayInterval.
+// maxMessages caps the spool; 0 means unlimited.
+func NewSpoolProducer(producer Producer, path string, maxMessages int, replayInterval time.Duration) (*SpoolProducer, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool file %s: %w", path, err)
+	}
+
+	var pending int
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(spoolBucket)
+		if err != nil {
+			return err
+		}
+		pending = bucket.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise spool file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &SpoolProducer{
+		producer:    producer,
+		db:          db,
+		maxMessages: int64(maxMessages),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	s.pending.Store(int64(pending))
+	if pending > 0 {
+		fmt.Printf("Spool %s holds %d messages from a previous run; replaying\n", path, pending)
+	}
+
+	// Async write failures are reported after Publish returns; spool those too
+	if reporter, ok := producer.(asyncErrorReporter); ok {
+		reporter.SetErrorHandler(func(msg Message, err error) {
+			if spoolErr := s.append(string(msg.Key), msg.Headers, msg.Value); spoolErr != nil {
+				fmt.Printf("Failed to spool undelivered message: %v (write error: %v)\n", spoolErr, err)
+			}
+		})
+	}
+
+	s.wg.Add(1)
+	go s.replayLoop(replayInterval)
+	return s, nil
+}
+
+// Publish publishes the message, spooling it if the backend rejects it.
+// It only fails when the message could not be spooled either; a spooled
+// message is recorded in the context's SpoolReport, if any.
+func (s *SpoolProducer) Publish(ctx context.Context, key string, value []byte) error {
+	if s.pending.Load() == 0 {
+		err := s.producer.Publish(ctx, key, value)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		fmt.Printf("Publish failed, spooling until the queue recovers: %v\n", err)
+	}
+	if err := s.append(key, HeadersFrom(ctx), value); err != nil {
+		return err
+	}
+	if report, ok := ctx.Value(spoolReportKey{}).(*SpoolReport); ok {
+		report.Spooled = true
+	}
+	return nil
+}
+
+func (s *SpoolProducer) append(key string, headers Headers, value []byte) error {
+	if s.maxMessages > 0 && s.pending.Load() >= s.maxMessages {
+		s.dropped.Add(1)
+		return fmt.Errorf("failed to spool message: spool full (%d messages)", s.maxMessages)
+	}
+
+	data := encodeBoltMessage(key, headers, value, time.Now())
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(spoolBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(encodeSeq(seq), data)
+	})
+	if err != nil {
+		s.dropped.Add(1)
+		return fmt.Errorf("failed to spool message: %w", err)
+	}
+	s.pending.Add(1)
+	s.spooled.Add(1)
+	return nil
+}
+
+func (s *SpoolProducer) replayLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if s.pending.Load() > 0 {
+			if n, err := s.replay(); err != nil {
+				fmt.Printf("Spool replay stopped after %d messages: %v\n", n, err)
+			} else if n > 0 {
+				fmt.Printf("Replayed %d spooled messages\n", n)
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay publishes spooled messages in order until the spool is empty or a
+// publish fails, returning how many were delivered
+func (s *SpoolProducer) replay() (int, error) {
+	total := 0
+	for {
+		var keys [][]byte
+		var messages [][]byte
+		err := s.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(spoolBucket).Cursor()
+			for k, v := c.First(); k != nil && len(keys) < spoolReplayBatch; k, v = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+				messages = append(messages, append([]byte(nil), v...))
+			}
+			return nil
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to read spool: %w", err)
+		}
+		if len(keys) == 0 {
+			return total, nil
+		}
+
+		delivered := 0
+		var publishErr error
+		for i, data := range messages {
+			key, headers, value, _, err := decodeBoltMessage(data)
+			if err != nil {
+				// Unreadable entries would block the spool forever
+				fmt.Printf("Dropping corrupt spool entry: %v\n", err)
+				delivered = i + 1
+				continue
+			}
+			if publishErr = s.producer.Publish(WithHeaders(s.ctx, headers), string(key), value); publishErr != nil {
+				break
+			}
+			delivered = i + 1
+		}
+
+		if delivered > 0 {
+			err := s.db.Update(func(tx *bolt.Tx) error {
+				bucket := tx.Bucket(spoolBucket)
+				for _, k := range keys[:delivered] {
+					if err := bucket.Delete(k); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return total, fmt.Errorf("failed to trim spool: %w", err)
+			}
+			s.pending.Add(-int64(delivered))
+			s.replayed.Add(int64(delivered))
+			total += delivered
+		}
+		if publishErr != nil {
+			return total, publishErr
+		}
+	}
+}
+
+// IsAsync reports whether the wrapped producer is async
+func (s *SpoolProducer) IsAsync() bool {
+	return s.producer.IsAsync()
+}
+
+// Stats returns the wrapped producer's counters
+func (s *SpoolProducer) Stats() ProducerStats {
+	return s.producer.Stats()
+}
+
+// SpoolStats returns spool counters
+func (s *SpoolProducer) SpoolStats() SpoolStats {
+	return SpoolStats{
+		Pending:  s.pending.Load(),
+		Spooled:  s.spooled.Load(),
+		Replayed: s.replayed.Load(),
+		Dropped:  s.dropped.Load(),
+	}
+}
+
+// Close stops replaying and closes the spool and the wrapped producer.
+// Messages still spooled are replayed on the next start.
+func (s *SpoolProducer) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	if err := s.db.Close(); err != nil {
+		s.producer.Close()
+		return fmt.Errorf("failed to close spool: %w", err)
+	}
+	return s.producer.Close()
+}
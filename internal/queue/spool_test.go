@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// switchProducer fails while down and records what it delivered
+type switchProducer struct {
+	mu        sync.Mutex
+	down      bool
+	delivered []string
+}
+
+func (p *switchProducer) Publish(ctx context.Context, key string, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return errors.New("broker unavailable")
+	}
+	p.delivered = append(p.delivered, string(value))
+	return nil
+}
+
+func (p *switchProducer) setDown(down bool) {
+	p.mu.Lock()
+	p.down = down
+	p.mu.Unlock()
+}
+
+func (p *switchProducer) messages() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.delivered...)
+}
+
+func (p *switchProducer) IsAsync() bool        { return false }
+func (p *switchProducer) Stats() ProducerStats { return ProducerStats{} }
+func (p *switchProducer) Close() error         { return nil }
+
+func TestSpoolProducer_ReplaysInOrder(t *testing.T) {
+	inner := &switchProducer{}
+	spool, err := NewSpoolProducer(inner, filepath.Join(t.TempDir(), "spool.db"), 0, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewSpoolProducer failed: %v", err)
+	}
+	defer spool.Close()
+
+	ctx := context.Background()
+	spool.Publish(ctx, "k", []byte("1"))
+
+	inner.setDown(true)
+	for _, v := range []string{"2", "3"} {
+		if err := spool.Publish(ctx, "k", []byte(v)); err != nil {
+			t.Fatalf("Publish should spool, got %v", err)
+		}
+	}
+	inner.setDown(false)
+	// Spooled messages are pending, so this one queues behind them
+	spool.Publish(ctx, "k", []byte("4"))
+
+	deadline := time.Now().Add(time.Second)
+	for spool.SpoolStats().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	got := inner.messages()
+	want := []string{"1", "2", "3", "4"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+	if stats := spool.SpoolStats(); stats.Spooled != 3 || stats.Replayed != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestSpoolProducer_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.db")
+	inner := &switchProducer{down: true}
+
+	spool, err := NewSpoolProducer(inner, path, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSpoolProducer failed: %v", err)
+	}
+	spool.Publish(context.Background(), "k", []byte("1"))
+	spool.Close()
+
+	inner.setDown(false)
+	spool, err = NewSpoolProducer(inner, path, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSpoolProducer failed: %v", err)
+	}
+	defer spool.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for spool.SpoolStats().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := inner.messages(); len(got) != 1 || got[0] != "1" {
+		t.Errorf("Expected the spooled message to be replayed, got %v", got)
+	}
+}
+
+func TestSpoolProducer_Full(t *testing.T) {
+	inner := &switchProducer{down: true}
+	spool, err := NewSpoolProducer(inner, filepath.Join(t.TempDir(), "spool.db"), 1, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSpoolProducer failed: %v", err)
+	}
+	defer spool.Close()
+
+	spool.Publish(context.Background(), "k", []byte("1"))
+	if err := spool.Publish(context.Background(), "k", []byte("2")); err == nil {
+		t.Error("Expected an error once the spool is full")
+	}
+	if stats := spool.SpoolStats(); stats.Dropped != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", stats.Dropped)
+	}
+}
+
+func TestSpoolProducer_ReportsSpooled(t *testing.T) {
+	inner := &switchProducer{}
+	spool, err := NewSpoolProducer(inner, filepath.Join(t.TempDir(), "spool.db"), 0, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSpoolProducer failed: %v", err)
+	}
+	defer spool.Close()
+
+	ctx, report := WithSpoolReport(context.Background())
+	spool.Publish(ctx, "k", []byte("1"))
+	if report.Spooled {
+		t.Error("Expected a delivered message not reported as spooled")
+	}
+
+	inner.setDown(true)
+	ctx, report = WithSpoolReport(context.Background())
+	if err := spool.Publish(ctx, "k", []byte("2")); err != nil {
+		t.Fatalf("Publish should spool, got %v", err)
+	}
+	if !report.Spooled {
+		t.Error("Expected the message reported as spooled")
+	}
+}
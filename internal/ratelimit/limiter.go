@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a single token bucket
+type bucket struct {
+	tokens   float64
+	lastFill time.Time
+}
+
+// Limiter is a set of token buckets keyed by an arbitrary string
+// (connection ID, zipcode, ...). A nil Limiter or one with a non-positive
+// rate allows everything.
+type Limiter struct {
+	rate    float64 // tokens added per second
+	burst   float64 // bucket capacity
+	buckets map[string]*bucket
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewLimiter creates a limiter allowing rate events per second per key with
+// bursts of up to burst events
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow reports whether an event for key may happen now, consuming a token if so
+func (l *Limiter) Allow(key string) bool {
+	if l == nil || l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastFill: now}
+		l.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.lastFill).Seconds()
+		b.tokens += elapsed * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.lastFill = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Refund gives back a token Allow consumed for key, e.g. when the event
+// was rejected by another limit after all
+func (l *Limiter) Refund(key string) {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = min(b.tokens+1, l.burst)
+	}
+}
+
+// Prune forgets the buckets that have refilled by now, which behave like
+// new ones, so keys that go quiet don't pile up
+func (l *Limiter) Prune() {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.lastFill).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Remove forgets the bucket for key (e.g., when a connection closes)
+func (l *Limiter) Remove(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, key)
+}
+
+// Len returns the number of tracked keys
+func (l *Limiter) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
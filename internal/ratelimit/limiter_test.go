@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Burst(t *testing.T) {
+	l := NewLimiter(1, 3)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("conn1") {
+			t.Fatalf("Expected event %d to be allowed", i)
+		}
+	}
+
+	if l.Allow("conn1") {
+		t.Error("Expected event beyond burst to be rejected")
+	}
+
+	// Other keys have their own bucket
+	if !l.Allow("conn2") {
+		t.Error("Expected conn2 to be allowed")
+	}
+}
+
+func TestLimiter_Refill(t *testing.T) {
+	l := NewLimiter(2, 1)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("conn1") {
+		t.Fatal("Expected first event to be allowed")
+	}
+	if l.Allow("conn1") {
+		t.Fatal("Expected second event to be rejected")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !l.Allow("conn1") {
+		t.Error("Expected event to be allowed after refill")
+	}
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	var nilLimiter *Limiter
+	if !nilLimiter.Allow("conn1") {
+		t.Error("Nil limiter should allow everything")
+	}
+
+	l := NewLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		if !l.Allow("conn1") {
+			t.Fatal("Zero-rate limiter should allow everything")
+		}
+	}
+}
+
+func TestLimiter_Remove(t *testing.T) {
+	l := NewLimiter(1, 1)
+	l.Allow("conn1")
+	l.Allow("conn2")
+
+	l.Remove("conn1")
+	if l.Len() != 1 {
+		t.Errorf("Expected 1 tracked key, got %d", l.Len())
+	}
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	l := NewLimiter(1, 1)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("conn1") {
+		t.Fatal("Expected first event to be allowed")
+	}
+	l.Refund("conn1")
+	if !l.Allow("conn1") {
+		t.Error("Expected the refunded token to allow another event")
+	}
+
+	// Refunds never grow a bucket past its burst
+	l.Refund("conn1")
+	l.Refund("conn1")
+	l.Allow("conn1")
+	if l.Allow("conn1") {
+		t.Error("Expected refunds capped at the burst")
+	}
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	l := NewLimiter(1, 2)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.Allow("94105")
+	now = now.Add(500 * time.Millisecond)
+	l.Allow("10001")
+	l.Allow("10001")
+
+	// 94105 has refilled; 10001 is still down a token and a half
+	now = now.Add(500 * time.Millisecond)
+	l.Prune()
+	if l.Len() != 1 {
+		t.Errorf("Expected only the busy key tracked, got %d", l.Len())
+	}
+	if l.Allow("10001") && l.Allow("10001") {
+		t.Error("Expected the busy key to keep its state")
+	}
+}
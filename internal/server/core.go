@@ -0,0 +1,596 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/smukkama/weather-server/internal/connection"
+	"github.com/smukkama/weather-server/internal/derive"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/queue"
+	"github.com/smukkama/weather-server/internal/timer"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// serverCore holds the dependencies and protocol handling shared by the
+// goroutine-per-connection and worker pool TCP servers
+type serverCore struct {
+	config       *config.TCPServerConfig
+	connManager  *connection.Manager
+	timerManager *timer.TimerManager
+	producer     queue.Producer
+	quarantine   *queue.Quarantine
+	limiter      *messageLimiter
+	admission    *admissionControl
+	active       *connSet
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// newServerCore creates the shared server state
+func newServerCore(cfg *config.TCPServerConfig, connManager *connection.Manager, timerManager *timer.TimerManager, producer queue.Producer) serverCore {
+	ctx, cancel := context.WithCancel(context.Background())
+	return serverCore{
+		config:       cfg,
+		connManager:  connManager,
+		timerManager: timerManager,
+		producer:     producer,
+		limiter:      newMessageLimiter(cfg),
+		active:       newConnSet(),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// SetQuarantine enables physical range validation; readings that fail it
+// go to the quarantine instead of the metrics topic
+func (s *serverCore) SetQuarantine(quarantine *queue.Quarantine) {
+	s.quarantine = quarantine
+}
+
+// initAdmission builds admission control from the configuration; called from Start
+func (s *serverCore) initAdmission() error {
+	admission, err := newAdmissionControl(s.config)
+	if err != nil {
+		return fmt.Errorf("failed to configure admission control: %w", err)
+	}
+	s.admission = admission
+	return nil
+}
+
+// openListeners opens the TCP listener(s) plus any additional transports
+// (WebSocket, gRPC) that speak the same protocol
+func (s *serverCore) openListeners() ([]net.Listener, error) {
+	listeners, err := s.openTCPListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.WebSocketPort > 0 {
+		wsAddr := fmt.Sprintf(":%d", s.config.WebSocketPort)
+		wsListener, err := newWebSocketListener(wsAddr, s.config.WebSocketPath, s.config.WebSocketAllowedOrigins)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, wsListener)
+		fmt.Printf("WebSocket transport listening on %s%s\n", wsAddr, s.config.WebSocketPath)
+	}
+
+	if s.config.GRPCPort > 0 {
+		grpcAddr := fmt.Sprintf(":%d", s.config.GRPCPort)
+		grpcListener, err := newGRPCListener(grpcAddr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, grpcListener)
+		fmt.Printf("gRPC transport listening on %s\n", grpcAddr)
+	}
+
+	return listeners, nil
+}
+
+// openTCPListeners opens the protocol's TCP listener. With AcceptListeners
+// > 1 it opens that many SO_REUSEPORT listeners on the same port so the
+// kernel load-balances new connections across several accept loops, which
+// keeps up with reconnect storms better than a single loop.
+func (s *serverCore) openTCPListeners() ([]net.Listener, error) {
+	addr := fmt.Sprintf(":%d", s.config.Port)
+
+	var trusted []*net.IPNet
+	if s.config.ProxyProtocol {
+		// Any client could otherwise forge its address past the deny list
+		// and per-IP limits
+		if len(s.config.ProxyTrustedCIDRs) == 0 {
+			return nil, fmt.Errorf("TCP_PROXY_TRUSTED_CIDRS is required with the PROXY protocol enabled")
+		}
+		var err error
+		if trusted, err = parseCIDRs(s.config.ProxyTrustedCIDRs); err != nil {
+			return nil, fmt.Errorf("invalid proxy trusted networks: %w", err)
+		}
+		fmt.Println("PROXY protocol v2 enabled on TCP listener")
+	}
+
+	count := s.config.AcceptListeners
+	if count < 1 {
+		count = 1
+	}
+
+	var listeners []net.Listener
+	for i := 0; i < count; i++ {
+		var listener net.Listener
+		var err error
+		if count > 1 {
+			listener, err = listenReusePort(addr)
+		} else {
+			listener, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to start TCP server: %w", err)
+		}
+
+		if s.config.ProxyProtocol {
+			listener = newProxyListener(listener, trusted, s.config.IdentifyTimeout)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if count > 1 {
+		fmt.Printf("Opened %d SO_REUSEPORT listeners on %s\n", count, addr)
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// handshake reads the first message from a new connection, which must be an
+// identify or resume, registers (or re-attaches) the client and acknowledges it.
+// On success the read deadline is cleared for normal operation.
+func (s *serverCore) handshake(conn net.Conn, reader *lineReader) (*connection.ClientInfo, error) {
+	// Set identify timeout
+	conn.SetReadDeadline(time.Now().Add(s.config.IdentifyTimeout))
+
+	// Read identification message
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identify message: %w", err)
+	}
+
+	// Parse identification message
+	msg, err := protocol.ParseMessage(line)
+	if err != nil {
+		s.sendError(conn, protocol.ErrorCodeFor(err), err.Error())
+		return nil, fmt.Errorf("failed to parse identify message: %w", err)
+	}
+
+	var client *connection.ClientInfo
+	switch m := msg.(type) {
+	case *protocol.IdentifyMessage:
+		client, err = s.identify(conn, m)
+	case *protocol.ResumeMessage:
+		client, err = s.resume(conn, m)
+	default:
+		s.sendError(conn, protocol.ErrCodeUnexpectedMessage, "expected identify message")
+		return nil, fmt.Errorf("expected identify message, got %T", msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Clear read deadline for normal operation
+	conn.SetReadDeadline(time.Time{})
+	return client, nil
+}
+
+// identify registers a new client and sends the identified ack
+func (s *serverCore) identify(conn net.Conn, msg *protocol.IdentifyMessage) (*connection.ClientInfo, error) {
+	// Generate connection ID
+	connectionID := uuid.New().String()
+	fmt.Printf("New connection: %s from %s\n", connectionID, conn.RemoteAddr())
+
+	// Register client
+	if err := s.connManager.RegisterStation(connectionID, msg.Zipcode, msg.City, msg.StationID, conn); err != nil {
+		s.sendError(conn, registerErrorCode(err), err.Error())
+		return nil, fmt.Errorf("failed to register client: %w", err)
+	}
+
+	if err := s.connManager.SetStationMetadata(connectionID, msg.StationMetadata); err != nil {
+		s.connManager.Unregister(connectionID)
+		return nil, fmt.Errorf("failed to record station metadata: %w", err)
+	}
+	if err := s.connManager.SetUnits(connectionID, msg.Units); err != nil {
+		s.connManager.Unregister(connectionID)
+		return nil, fmt.Errorf("failed to record station units: %w", err)
+	}
+
+	client, exists := s.connManager.Get(connectionID)
+	if !exists {
+		return nil, fmt.Errorf("connection %s vanished during registration", connectionID)
+	}
+
+	fmt.Printf("Client identified: %s (zipcode=%s, city=%s)\n", connectionID, msg.Zipcode, msg.City)
+
+	// Send acknowledgment (through the manager so writes are serialized with
+	// server-initiated commands)
+	ack := protocol.NewAckMessage(protocol.AckStatusIdentified)
+	if s.config.SessionGracePeriod > 0 {
+		token, err := s.connManager.IssueSession(connectionID)
+		if err != nil {
+			s.connManager.Unregister(connectionID)
+			return nil, fmt.Errorf("failed to issue session: %w", err)
+		}
+		ack.SessionToken = token
+	}
+	if err := s.connManager.SendToConnection(connectionID, ack); err != nil {
+		s.connManager.Unregister(connectionID)
+		return nil, fmt.Errorf("failed to send ack: %w", err)
+	}
+
+	// Schedule inactivity timer
+	s.scheduleInactivityTimer(connectionID)
+
+	return client, nil
+}
+
+// resume re-attaches a reconnecting client to its existing logical connection
+func (s *serverCore) resume(conn net.Conn, msg *protocol.ResumeMessage) (*connection.ClientInfo, error) {
+	if s.config.SessionGracePeriod <= 0 {
+		s.sendError(conn, protocol.ErrCodeSessionExpired, "session resume is disabled")
+		return nil, fmt.Errorf("session resume is disabled")
+	}
+
+	client, err := s.connManager.Resume(msg.SessionToken, conn)
+	if err != nil {
+		s.sendError(conn, protocol.ErrCodeSessionExpired, err.Error())
+		return nil, fmt.Errorf("failed to resume session: %w", err)
+	}
+
+	connectionID := client.ConnectionID
+	s.timerManager.Cancel(sessionExpiryTimerID(connectionID))
+
+	fmt.Printf("Client resumed: %s from %s (zipcode=%s, city=%s)\n",
+		connectionID, conn.RemoteAddr(), client.Zipcode, client.City)
+
+	ack := protocol.NewAckMessage(protocol.AckStatusResumed)
+	ack.SessionToken = msg.SessionToken
+	if err := s.connManager.SendToConnection(connectionID, ack); err != nil {
+		return nil, fmt.Errorf("failed to send ack: %w", err)
+	}
+
+	// Reconnecting counts as activity
+	s.scheduleInactivityTimer(connectionID)
+
+	return client, nil
+}
+
+// releaseConnection runs when a connection's read loop exits. With session
+// resume enabled the client is detached and kept for the grace period;
+// otherwise it is unregistered immediately.
+func (s *serverCore) releaseConnection(connectionID string, conn net.Conn) {
+	if s.config.SessionGracePeriod > 0 {
+		if s.connManager.Detach(connectionID, conn) {
+			fmt.Printf("Connection %s detached, session kept for %s\n", connectionID, s.config.SessionGracePeriod)
+			s.timerManager.Schedule(
+				sessionExpiryTimerID(connectionID),
+				time.Now().Add(s.config.SessionGracePeriod),
+				func(context.Context) { s.expireSession(connectionID) },
+			)
+			return
+		}
+
+		// Already resumed on another connection
+		if _, exists := s.connManager.Get(connectionID); exists {
+			return
+		}
+	}
+
+	s.timerManager.Cancel(inactivityTimerID(connectionID))
+	s.limiter.Forget(connectionID)
+	s.connManager.Unregister(connectionID)
+}
+
+// expireSession unregisters a detached client whose grace period ran out
+func (s *serverCore) expireSession(connectionID string) {
+	if !s.connManager.ExpireSession(connectionID) {
+		return
+	}
+
+	s.timerManager.Cancel(inactivityTimerID(connectionID))
+	s.limiter.Forget(connectionID)
+	fmt.Printf("Session for connection %s expired\n", connectionID)
+}
+
+// Kick disconnects a client on an operator's request and releases its
+// timers and rate limit state right away, which matters for a detached
+// session that has no read loop left to do it
+func (s *serverCore) Kick(connectionID, reason string) error {
+	if err := s.connManager.Kick(connectionID, reason); err != nil {
+		return err
+	}
+
+	s.timerManager.Cancel(inactivityTimerID(connectionID))
+	s.timerManager.Cancel(sessionExpiryTimerID(connectionID))
+	s.limiter.Forget(connectionID)
+	fmt.Printf("Connection %s kicked: %s\n", connectionID, reason)
+	return nil
+}
+
+// allowMessage applies rate limits to a parsed message, or nil for a line
+// that didn't parse, telling the client when a message is dropped.
+// Keepalives and goodbyes aren't limited: a dropped keepalive would leave
+// the inactivity timer to disconnect a station that is still sending.
+func (s *serverCore) allowMessage(connectionID, zipcode string, msg interface{}) bool {
+	switch msg.(type) {
+	case *protocol.KeepaliveMessage, *protocol.GoodbyeMessage:
+		return true
+	}
+	if s.limiter.Allow(connectionID, zipcode) {
+		return true
+	}
+
+	fmt.Printf("Rate limit exceeded for %s, dropping message\n", connectionID)
+	errMsg := protocol.NewErrorMessage(protocol.ErrCodeRateLimited, "message rate limit exceeded")
+	s.connManager.SendToConnection(connectionID, errMsg)
+	return false
+}
+
+// metricMessage builds the Kafka message for a reading, converted to
+// canonical units, tagged with the station identity and any metadata the
+// station sent at identify, and with derived metrics attached
+func (s *serverCore) metricMessage(connectionID, zipcode, city string, receivedAt time.Time, data protocol.MetricData) *protocol.MetricMessage {
+	msg := &protocol.MetricMessage{
+		ConnectionID: connectionID,
+		Zipcode:      zipcode,
+		City:         city,
+		ReceivedAt:   receivedAt,
+		Data:         data,
+	}
+	if client, ok := s.connManager.Get(connectionID); ok {
+		msg.StationID = client.StationID
+		msg.Station = client.GetStation()
+		msg.Data.Normalize(client.GetUnits())
+	}
+	derive.Apply(msg)
+	return msg
+}
+
+// publishContext returns the context to publish a connection's reading
+// with: it carries provenance headers and a new trace ID
+func (s *serverCore) publishContext(connectionID string) context.Context {
+	return queue.WithHeaders(s.ctx, queue.Headers{
+		queue.HeaderConnectionID:  connectionID,
+		queue.HeaderInstance:      s.config.InstanceID,
+		queue.HeaderTraceID:       queue.NewTraceID(),
+		queue.HeaderSchemaVersion: strconv.Itoa(protocol.MetricMessageSchemaVersion),
+	})
+}
+
+// divert quarantines an out-of-range reading and tells the client why.
+// It reports whether the reading was diverted.
+func (s *serverCore) divert(connectionID string, seq *uint64, msg *protocol.MetricMessage) bool {
+	err := s.quarantine.Divert(s.ctx, msg)
+	if err == nil {
+		return false
+	}
+
+	fmt.Printf("Quarantined metrics from %s (zipcode=%s): %v\n", connectionID, msg.Zipcode, err)
+	errMsg := protocol.NewErrorMessage(protocol.ErrCodeOutOfRange, err.Error())
+	errMsg.Seq = seq
+	s.connManager.SendToConnection(connectionID, errMsg)
+	return true
+}
+
+// ackMetrics acknowledges a sequenced metrics message with the publish
+// result; a reading only spooled locally isn't persisted yet. Messages
+// without a sequence number are not acknowledged.
+func (s *serverCore) ackMetrics(connectionID string, seq *uint64, spooled bool, publishErr error) {
+	if seq == nil {
+		return
+	}
+
+	var reply interface{}
+	if publishErr != nil {
+		errMsg := protocol.NewErrorMessage(protocol.ErrCodePublishFailed, publishErr.Error())
+		errMsg.Seq = seq
+		reply = errMsg
+	} else {
+		reply = protocol.NewMetricsAckMessage(*seq, !s.producer.IsAsync() && !spooled)
+	}
+
+	if err := s.connManager.SendToConnection(connectionID, reply); err != nil {
+		fmt.Printf("Failed to ack metrics seq %d for %s: %v\n", *seq, connectionID, err)
+	}
+}
+
+// handleGoodbye acknowledges a clean client disconnect and releases its
+// resources immediately instead of waiting for a read error or timeout
+func (s *serverCore) handleGoodbye(connectionID string, msg *protocol.GoodbyeMessage) {
+	reason := msg.Reason
+	if reason == "" {
+		reason = "client goodbye"
+	}
+
+	if client, exists := s.connManager.Get(connectionID); exists {
+		client.SetDisconnectReason(reason)
+	}
+
+	ack := protocol.NewAckMessage(protocol.AckStatusGoodbye)
+	if err := s.connManager.SendToConnection(connectionID, ack); err != nil {
+		fmt.Printf("Failed to send goodbye ack: %v\n", err)
+	}
+
+	s.timerManager.Cancel(inactivityTimerID(connectionID))
+	s.limiter.Forget(connectionID)
+	s.connManager.Unregister(connectionID)
+
+	fmt.Printf("Connection %s said goodbye: %s\n", connectionID, reason)
+}
+
+func (s *serverCore) sendMessage(conn net.Conn, msg interface{}) error {
+	data, err := protocol.EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	// Only used before the client is registered (and has a writer
+	// goroutine), so bound the write here
+	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+func (s *serverCore) sendError(conn net.Conn, code protocol.ErrorCode, detail string) {
+	s.sendMessage(conn, protocol.NewErrorMessage(code, detail))
+}
+
+// scheduleInactivityTimer (re)arms a connection's inactivity timeout. In
+// sweeper mode it does nothing: the sweeper finds idle clients from their
+// last activity instead.
+func (s *serverCore) scheduleInactivityTimer(connectionID string) {
+	if s.config.InactivitySweeper {
+		return
+	}
+
+	timerID := inactivityTimerID(connectionID)
+	expiryAt := time.Now().Add(s.config.InactivityTimeout)
+	s.timerManager.Schedule(timerID, expiryAt, func(context.Context) {
+		fmt.Printf("Inactivity timeout for connection %s\n", connectionID)
+		s.closeIdle(connectionID)
+	})
+}
+
+// startInactivitySweeper starts the sweeper in sweeper mode; called from
+// Start. Rescheduling a timer on every message churns the timer heap at high
+// message rates, so large fleets can instead sweep all connections at an
+// interval, at the cost of closing idle clients up to one interval late.
+func (s *serverCore) startInactivitySweeper() {
+	if !s.config.InactivitySweeper {
+		return
+	}
+
+	interval := s.config.InactivitySweepInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.sweepInactive()
+			}
+		}
+	}()
+}
+
+// sweepInactive closes every attached client not heard from within the
+// inactivity timeout
+func (s *serverCore) sweepInactive() {
+	idle := s.connManager.GetInactiveConnections(s.config.InactivityTimeout)
+	closed := 0
+	for _, connectionID := range idle {
+		if s.closeIdle(connectionID) {
+			closed++
+		}
+	}
+	if closed > 0 {
+		fmt.Printf("Inactivity sweep closed %d connections\n", closed)
+	}
+}
+
+// closeIdle closes a client that timed out, returning false if it is gone or
+// detached (a detached session is waiting to resume and has its own expiry)
+func (s *serverCore) closeIdle(connectionID string) bool {
+	client, exists := s.connManager.Get(connectionID)
+	if !exists || client.IsDetached() {
+		return false
+	}
+
+	// Tell observers before closing, so they see the idle station
+	client.SetDisconnectReason("inactivity timeout")
+	s.connManager.NotifyIdle(connectionID)
+
+	// Unregister happens in the connection handler's cleanup
+	client.Close()
+	return true
+}
+
+// inactivityTimerID returns the timer ID used for a connection's inactivity timeout
+func inactivityTimerID(connectionID string) string {
+	return fmt.Sprintf("inactivity-%s", connectionID)
+}
+
+// sessionExpiryTimerID returns the timer ID used to expire a detached session
+func sessionExpiryTimerID(connectionID string) string {
+	return fmt.Sprintf("session-expiry-%s", connectionID)
+}
+
+// registerErrorCode maps a connection manager registration error to the
+// error code reported to the client
+func registerErrorCode(err error) protocol.ErrorCode {
+	switch {
+	case errors.Is(err, connection.ErrMaxConnectionsReached):
+		return protocol.ErrCodeServerFull
+	case errors.Is(err, connection.ErrDuplicateStation):
+		return protocol.ErrCodeDuplicateStation
+	case errors.Is(err, connection.ErrZipcodeFull):
+		return protocol.ErrCodeZipcodeFull
+	default:
+		return protocol.ErrCodeInternal
+	}
+}
+
+// connSet tracks open client connections so Stop can close them; reads
+// have no deadline once a client is identified, so closing is what
+// unblocks them
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newConnSet() *connSet {
+	return &connSet{conns: make(map[net.Conn]struct{})}
+}
+
+// add tracks conn, returning false if the server is already stopping
+func (c *connSet) add(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *connSet) remove(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+}
+
+// closeAll closes every tracked connection and refuses new ones
+func (c *connSet) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for conn := range c.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/connection"
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/timer"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// station is a test client speaking the line protocol
+type station struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialStation connects to addr and identifies as a station in zipcode
+func dialStation(t *testing.T, addr net.Addr, zipcode string) *station {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &station{conn: conn, reader: bufio.NewReader(conn)}
+	fmt.Fprintf(conn, `{"type":"identify","zipcode":%q,"city":"Test"}`+"\n", zipcode)
+	return s
+}
+
+// next reads the next message type from the server
+func (s *station) next(t *testing.T) (protocol.MessageType, error) {
+	t.Helper()
+	s.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := s.reader.ReadBytes('\n')
+	if err != nil {
+		return "", err
+	}
+	var base protocol.BaseMessage
+	if err := json.Unmarshal(line, &base); err != nil {
+		t.Fatalf("Failed to decode %q: %v", line, err)
+	}
+	return base.Type, nil
+}
+
+func TestServers_EvictAtConnectionLimit(t *testing.T) {
+	type server interface {
+		Start() error
+		Stop()
+	}
+	servers := map[string]func(*config.TCPServerConfig, *connection.Manager, *timer.TimerManager) (server, func() net.Addr){
+		"goroutine per connection": func(cfg *config.TCPServerConfig, m *connection.Manager, tm *timer.TimerManager) (server, func() net.Addr) {
+			s := NewTCPServer(cfg, m, tm, nil)
+			return s, func() net.Addr { return s.listeners[0].Addr() }
+		},
+		"worker pool": func(cfg *config.TCPServerConfig, m *connection.Manager, tm *timer.TimerManager) (server, func() net.Addr) {
+			s := NewWorkerPoolTCPServer(cfg, m, tm, nil, 1, 10)
+			return s, func() net.Addr { return s.listeners[0].Addr() }
+		},
+	}
+
+	for name, newServer := range servers {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.TCPServerConfig{
+				MaxConnections:    1,
+				IdentifyTimeout:   2 * time.Second,
+				InactivityTimeout: time.Minute,
+			}
+			manager := connection.NewManager(cfg.MaxConnections)
+			manager.SetWriteOptions(0, time.Second)
+			manager.SetEvictionPolicy(connection.EvictIdle, nil)
+			timers := timer.NewTimerManager(1)
+			timers.Start()
+			defer timers.Stop()
+
+			s, addr := newServer(cfg, manager, timers)
+			if err := s.Start(); err != nil {
+				t.Fatalf("Failed to start server: %v", err)
+			}
+			defer s.Stop()
+
+			idle := dialStation(t, addr(), "10001")
+			if msgType, err := idle.next(t); err != nil || msgType != protocol.MsgTypeAck {
+				t.Fatalf("Expected the first station acked, got %q (%v)", msgType, err)
+			}
+
+			newcomer := dialStation(t, addr(), "94105")
+			if msgType, err := newcomer.next(t); err != nil || msgType != protocol.MsgTypeAck {
+				t.Fatalf("Expected the station past the limit acked, got %q (%v)", msgType, err)
+			}
+
+			// The idle station is told why, then closed
+			if msgType, err := idle.next(t); err != nil || msgType != protocol.MsgTypeShutdown {
+				t.Fatalf("Expected the idle station shut down, got %q (%v)", msgType, err)
+			}
+			if _, err := idle.next(t); err == nil {
+				t.Error("Expected the idle station's connection closed")
+			}
+			if counts := manager.CountByZipcode(); len(counts) != 1 || counts["94105"] != 1 {
+				t.Errorf("Expected only the newcomer connected, got %v", counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // lets clients request gzip compression
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/pkg/ingestpb"
+)
+
+// grpcListener is a net.Listener that serves the Ingest gRPC service and
+// hands each IdentifyAndStream call out as a line-oriented net.Conn, so the
+// TCP servers run the same handshake and message loop over gRPC streams.
+type grpcListener struct {
+	ingestpb.UnimplementedIngestServer
+
+	ln        net.Listener
+	server    *grpc.Server
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newGRPCListener starts a gRPC server on addr
+func newGRPCListener(addr string) (*grpcListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start gRPC listener: %w", err)
+	}
+
+	l := &grpcListener{
+		ln:     ln,
+		server: grpc.NewServer(),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	ingestpb.RegisterIngestServer(l.server, l)
+
+	go func() {
+		if err := l.server.Serve(ln); err != nil {
+			fmt.Printf("gRPC listener error: %v\n", err)
+		}
+	}()
+
+	return l, nil
+}
+
+// IdentifyAndStream implements ingestpb.IngestServer. The stream stays open
+// until the server closes the adapted conn or the client goes away.
+func (l *grpcListener) IdentifyAndStream(stream ingestpb.Ingest_IdentifyAndStreamServer) error {
+	conn := newGRPCConn(stream, l.ln.Addr())
+
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		return status.Error(codes.Unavailable, "server shutting down")
+	}
+
+	select {
+	case <-conn.done:
+	case <-stream.Context().Done():
+		conn.markClosed()
+	}
+	return nil
+}
+
+// Accept waits for the next gRPC stream
+func (l *grpcListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the gRPC server, cancelling all open streams
+func (l *grpcListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.server.Stop()
+	})
+	return nil
+}
+
+// Addr returns the listener's network address
+func (l *grpcListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// grpcConn adapts an IdentifyAndStream call to a newline-delimited net.Conn,
+// translating client frames to JSON protocol lines and written lines back
+// to server frames
+type grpcConn struct {
+	messageConn
+	stream ingestpb.Ingest_IdentifyAndStreamServer
+	local  net.Addr
+	remote net.Addr
+
+	writeMu sync.Mutex
+}
+
+func newGRPCConn(stream ingestpb.Ingest_IdentifyAndStreamServer, local net.Addr) *grpcConn {
+	c := &grpcConn{
+		messageConn: newMessageConn(),
+		stream:      stream,
+		local:       local,
+		remote:      local,
+	}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		c.remote = p.Addr
+	}
+	go c.readPump()
+	return c
+}
+
+func (c *grpcConn) readPump() {
+	for {
+		frame, err := c.stream.Recv()
+		if err != nil {
+			c.finish(err)
+			return
+		}
+		line, err := clientFrameLine(frame)
+		if err != nil {
+			c.finish(err)
+			return
+		}
+		if !c.deliver(line) {
+			c.finish(net.ErrClosed)
+			return
+		}
+	}
+}
+
+// Write implements net.Conn, sending each line as one server frame
+func (c *grpcConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	for _, line := range splitLines(p) {
+		frame, err := serverFrame(line)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.stream.Send(frame); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close implements net.Conn; the RPC returns and the stream ends
+func (c *grpcConn) Close() error {
+	c.markClosed()
+	return nil
+}
+
+// LocalAddr implements net.Conn
+func (c *grpcConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr implements net.Conn
+func (c *grpcConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline implements net.Conn
+func (c *grpcConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn; gRPC flow control bounds writes
+func (c *grpcConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// clientFrameLine converts a client frame to the equivalent JSON line. An
+// empty frame becomes a message of unknown type, which the server rejects.
+func clientFrameLine(frame *ingestpb.ClientFrame) ([]byte, error) {
+	var msg interface{}
+	switch f := frame.Frame.(type) {
+	case *ingestpb.ClientFrame_Identify:
+		msg = &protocol.IdentifyMessage{
+			Type:      protocol.MsgTypeIdentify,
+			Zipcode:   f.Identify.GetZipcode(),
+			City:      f.Identify.GetCity(),
+			StationID: f.Identify.GetStationId(),
+			Units:     protocol.UnitSystem(f.Identify.GetUnits()),
+			StationMetadata: protocol.StationMetadata{
+				Latitude:        f.Identify.Latitude,
+				Longitude:       f.Identify.Longitude,
+				Elevation:       f.Identify.Elevation,
+				Model:           f.Identify.GetModel(),
+				FirmwareVersion: f.Identify.GetFirmwareVersion(),
+			},
+		}
+
+	case *ingestpb.ClientFrame_Metrics:
+		reading := f.Metrics.GetData()
+		if reading == nil {
+			reading = &ingestpb.Reading{}
+		}
+		msg = &protocol.MetricsMessage{
+			Type: protocol.MsgTypeMetrics,
+			Seq:  f.Metrics.Seq,
+			Data: protocol.MetricData{
+				Timestamp:      reading.GetTimestamp(),
+				Temperature:    reading.Temperature,
+				Humidity:       reading.Humidity,
+				Precipitation:  reading.Precipitation,
+				WindSpeed:      reading.WindSpeed,
+				WindDirection:  reading.WindDirection,
+				PollutionIndex: reading.PollutionIndex,
+				PollenIndex:    reading.PollenIndex,
+				Pressure:       reading.Pressure,
+				Visibility:     reading.Visibility,
+				UVIndex:        reading.UvIndex,
+				SnowDepth:      reading.SnowDepth,
+			},
+		}
+
+	case *ingestpb.ClientFrame_Keepalive:
+		msg = &protocol.KeepaliveMessage{Type: protocol.MsgTypeKeepalive}
+
+	case *ingestpb.ClientFrame_Goodbye:
+		msg = &protocol.GoodbyeMessage{
+			Type:   protocol.MsgTypeGoodbye,
+			Reason: f.Goodbye.GetReason(),
+		}
+
+	case *ingestpb.ClientFrame_Resume:
+		msg = &protocol.ResumeMessage{
+			Type:         protocol.MsgTypeResume,
+			SessionToken: f.Resume.GetSessionToken(),
+		}
+
+	default:
+		msg = &protocol.BaseMessage{}
+	}
+
+	return protocol.EncodeMessage(msg)
+}
+
+// serverFrame converts a JSON line written by the server to a server frame
+func serverFrame(line []byte) (*ingestpb.ServerFrame, error) {
+	var base protocol.BaseMessage
+	if err := json.Unmarshal(line, &base); err != nil {
+		return nil, fmt.Errorf("invalid server message: %w", err)
+	}
+
+	switch base.Type {
+	case protocol.MsgTypeAck:
+		var msg protocol.AckMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("invalid ack message: %w", err)
+		}
+		return &ingestpb.ServerFrame{Frame: &ingestpb.ServerFrame_Ack{Ack: &ingestpb.Ack{
+			Status:       msg.Status,
+			Seq:          msg.Seq,
+			SessionToken: msg.SessionToken,
+		}}}, nil
+
+	case protocol.MsgTypeError:
+		var msg protocol.ErrorMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("invalid error message: %w", err)
+		}
+		return &ingestpb.ServerFrame{Frame: &ingestpb.ServerFrame_Error{Error: &ingestpb.Error{
+			Code:   string(msg.Code),
+			Detail: msg.Detail,
+			Seq:    msg.Seq,
+		}}}, nil
+
+	case protocol.MsgTypeSetInterval:
+		var msg protocol.SetIntervalMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("invalid set_interval message: %w", err)
+		}
+		return &ingestpb.ServerFrame{Frame: &ingestpb.ServerFrame_SetInterval{SetInterval: &ingestpb.SetInterval{
+			IntervalSeconds: int32(msg.IntervalSeconds),
+		}}}, nil
+
+	case protocol.MsgTypeRequestMetricsNow:
+		return &ingestpb.ServerFrame{Frame: &ingestpb.ServerFrame_RequestMetricsNow{
+			RequestMetricsNow: &ingestpb.RequestMetricsNow{},
+		}}, nil
+
+	case protocol.MsgTypeShutdown:
+		var msg protocol.ShutdownMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("invalid shutdown message: %w", err)
+		}
+		return &ingestpb.ServerFrame{Frame: &ingestpb.ServerFrame_Shutdown{Shutdown: &ingestpb.Shutdown{
+			Reason: msg.Reason,
+		}}}, nil
+
+	case protocol.MsgTypeNotice:
+		var msg protocol.NoticeMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("invalid notice message: %w", err)
+		}
+		return &ingestpb.ServerFrame{Frame: &ingestpb.ServerFrame_Notice{Notice: &ingestpb.Notice{
+			Message: msg.Message,
+		}}}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown server message type: %s", base.Type)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/smukkama/weather-server/internal/protocol"
+	"github.com/smukkama/weather-server/internal/ratelimit"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// messageLimiter applies per-connection and per-zipcode message rate limits
+type messageLimiter struct {
+	perConn    *ratelimit.Limiter
+	perZipcode *ratelimit.Limiter
+}
+
+// newMessageLimiter creates a limiter from the TCP server configuration.
+// Limits with a rate of 0 are disabled.
+func newMessageLimiter(cfg *config.TCPServerConfig) *messageLimiter {
+	l := &messageLimiter{}
+	if cfg.ConnRateLimit > 0 {
+		l.perConn = ratelimit.NewLimiter(cfg.ConnRateLimit, cfg.ConnRateBurst)
+	}
+	if cfg.ZipcodeRateLimit > 0 {
+		l.perZipcode = ratelimit.NewLimiter(cfg.ZipcodeRateLimit, cfg.ZipcodeRateBurst)
+	}
+	return l
+}
+
+// Allow reports whether a message from the connection may be processed.
+// A message the zipcode's limit rejects doesn't count against the
+// connection's.
+func (l *messageLimiter) Allow(connectionID, zipcode string) bool {
+	if !l.perConn.Allow(connectionID) {
+		return false
+	}
+	if !l.perZipcode.Allow(zipcode) {
+		l.perConn.Refund(connectionID)
+		return false
+	}
+	return true
+}
+
+// Forget releases the per-connection state once a connection closes, and
+// the per-zipcode state of zipcodes that have gone quiet
+func (l *messageLimiter) Forget(connectionID string) {
+	l.perConn.Remove(connectionID)
+	l.perZipcode.Prune()
+}
+
+// admissionError is returned when a connection is refused by admission control
+type admissionError struct {
+	code protocol.ErrorCode
+	msg  string
+}
+
+func (e *admissionError) Error() string {
+	return e.msg
+}
+
+// admissionControl enforces per-IP connection limits and CIDR allow/deny lists
+type admissionControl struct {
+	maxPerIP int
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	counts   map[string]int
+	mu       sync.Mutex
+}
+
+// newAdmissionControl creates admission control from the TCP server configuration
+func newAdmissionControl(cfg *config.TCPServerConfig) (*admissionControl, error) {
+	allow, err := parseCIDRs(cfg.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	deny, err := parseCIDRs(cfg.DenyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+
+	return &admissionControl{
+		maxPerIP: cfg.MaxConnsPerIP,
+		allow:    allow,
+		deny:     deny,
+		counts:   make(map[string]int),
+	}, nil
+}
+
+// Admit checks whether a connection from addr may proceed and, if so,
+// counts it against the source IP. Every admitted connection must be
+// paired with a call to Release.
+func (a *admissionControl) Admit(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	if containsIP(a.deny, ip) {
+		return &admissionError{protocol.ErrCodeUnauthorized, fmt.Sprintf("address %s is blocked", ip)}
+	}
+	if len(a.allow) > 0 && !containsIP(a.allow, ip) {
+		return &admissionError{protocol.ErrCodeUnauthorized, fmt.Sprintf("address %s is not allowed", ip)}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := ip.String()
+	if a.maxPerIP > 0 && a.counts[key] >= a.maxPerIP {
+		return &admissionError{protocol.ErrCodeServerFull, fmt.Sprintf("too many connections from %s", ip)}
+	}
+	a.counts[key]++
+	return nil
+}
+
+// Release gives back the slot taken by Admit
+func (a *admissionControl) Release(addr net.Addr) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := ip.String()
+	if a.counts[key] <= 1 {
+		delete(a.counts, key)
+		return
+	}
+	a.counts[key]--
+}
+
+// parseCIDRs parses networks in CIDR notation; bare IPs are treated as single hosts
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP extracts the IP from a connection address
+func addrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// lineBufferSize is the pooled buffer size; almost every message fits
+	lineBufferSize = 4096
+
+	// maxLineLength bounds a single protocol message
+	maxLineLength = 64 * 1024
+)
+
+var errLineTooLong = errors.New("message exceeds maximum line length")
+
+// readerPool holds buffered readers for connections that are mid-message
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, lineBufferSize)
+	},
+}
+
+// lineReader reads newline-delimited messages from a connection without
+// per-message allocations. An idle connection holds no buffer: it blocks
+// reading a single byte, and only once data arrives borrows a pooled
+// bufio.Reader, which goes back to the pool as soon as everything buffered
+// has been consumed. With 100k mostly idle connections this keeps memory
+// proportional to the connections actually sending.
+type lineReader struct {
+	src    prefixReader
+	br     *bufio.Reader // nil while idle
+	long   []byte        // scratch for lines longer than the buffer
+	maxLen int
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{
+		src:    prefixReader{r: r},
+		maxLen: maxLineLength,
+	}
+}
+
+// ReadLine returns the next line including its trailing newline. The slice
+// is only valid until the next call; callers that keep it must copy it.
+func (l *lineReader) ReadLine() ([]byte, error) {
+	// The previous line has been consumed; give the buffer back if nothing
+	// else is waiting in it
+	if l.br != nil && l.br.Buffered() == 0 {
+		l.release()
+	}
+
+	if l.br == nil {
+		// Wait for the first byte without holding a buffer
+		if err := l.src.fill(); err != nil {
+			return nil, err
+		}
+		l.br = readerPool.Get().(*bufio.Reader)
+		l.br.Reset(&l.src)
+	}
+
+	line, err := l.br.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+
+	// Rare: a line longer than the pooled buffer
+	l.long = append(l.long[:0], line...)
+	for err == bufio.ErrBufferFull {
+		if len(l.long) > l.maxLen {
+			return nil, errLineTooLong
+		}
+		line, err = l.br.ReadSlice('\n')
+		l.long = append(l.long, line...)
+	}
+	if len(l.long) > l.maxLen {
+		return nil, errLineTooLong
+	}
+	return l.long, err
+}
+
+// Release returns any pooled buffer; the reader must not be used afterwards
+func (l *lineReader) Release() {
+	if l.br != nil {
+		l.release()
+	}
+	l.long = nil
+}
+
+func (l *lineReader) release() {
+	l.br.Reset(nil)
+	readerPool.Put(l.br)
+	l.br = nil
+	if cap(l.long) > lineBufferSize {
+		l.long = nil
+	}
+}
+
+// prefixReader replays one byte read while idle before reading from r
+type prefixReader struct {
+	r       io.Reader
+	b       [1]byte
+	pending bool
+}
+
+// fill blocks until a byte is available and holds it for the next Read
+func (p *prefixReader) fill() error {
+	for {
+		n, err := p.r.Read(p.b[:])
+		if n > 0 {
+			p.pending = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Read implements io.Reader
+func (p *prefixReader) Read(buf []byte) (int, error) {
+	if p.pending {
+		if len(buf) == 0 {
+			return 0, nil
+		}
+		buf[0] = p.b[0]
+		p.pending = false
+		return 1, nil
+	}
+	return p.r.Read(buf)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLineReader_Lines(t *testing.T) {
+	input := `{"type":"keepalive"}` + "\n" + `{"type":"goodbye"}` + "\n"
+	r := newLineReader(strings.NewReader(input))
+	defer r.Release()
+
+	for _, want := range []string{`{"type":"keepalive"}` + "\n", `{"type":"goodbye"}` + "\n"} {
+		line, err := r.ReadLine()
+		if err != nil {
+			t.Fatalf("ReadLine failed: %v", err)
+		}
+		if string(line) != want {
+			t.Errorf("Expected %q, got %q", want, line)
+		}
+	}
+
+	if _, err := r.ReadLine(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	if r.br != nil {
+		t.Error("Expected buffer to be returned to the pool once drained")
+	}
+}
+
+func TestLineReader_LongLine(t *testing.T) {
+	long := strings.Repeat("x", lineBufferSize*3) + "\n"
+	r := newLineReader(strings.NewReader(long + "short\n"))
+	defer r.Release()
+
+	line, err := r.ReadLine()
+	if err != nil {
+		t.Fatalf("ReadLine failed: %v", err)
+	}
+	if string(line) != long {
+		t.Errorf("Long line corrupted: got %d bytes, want %d", len(line), len(long))
+	}
+
+	line, err = r.ReadLine()
+	if err != nil || string(line) != "short\n" {
+		t.Errorf("Expected short line after long one, got %q (%v)", line, err)
+	}
+}
+
+func TestLineReader_TooLong(t *testing.T) {
+	r := newLineReader(strings.NewReader(strings.Repeat("x", maxLineLength*2) + "\n"))
+	defer r.Release()
+
+	if _, err := r.ReadLine(); err != errLineTooLong {
+		t.Errorf("Expected errLineTooLong, got %v", err)
+	}
+}
+
+// repeatReader yields the same line forever without allocating
+type repeatReader struct {
+	line []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.line[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.line)
+	}
+	return n, nil
+}
+
+const benchLine = `{"type":"metrics","seq":42,"data":{"timestamp":"2024-01-15T10:30:00Z","temperature":22.5,"humidity":65,"precipitation":0,"wind_speed":12.3,"wind_direction":"NW","pollution_index":42,"pollen_index":3.1}}` + "\n"
+
+// BenchmarkLineReader measures the read path; it should report 0 allocs/op
+func BenchmarkLineReader(b *testing.B) {
+	r := newLineReader(&repeatReader{line: []byte(benchLine)})
+	defer r.Release()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchLine)))
+	for i := 0; i < b.N; i++ {
+		if _, err := r.ReadLine(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBufioReadString is the previous read path, for comparison
+func BenchmarkBufioReadString(b *testing.B) {
+	r := bufio.NewReader(&repeatReader{line: []byte(benchLine)})
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchLine)))
+	for i := 0; i < b.N; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = []byte(line)
+	}
+}
+
+// idleReader yields one line, then blocks like an idle connection until
+// closed
+type idleReader struct {
+	line   []byte
+	closed chan struct{}
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if len(r.line) > 0 {
+		n := copy(p, r.line)
+		r.line = r.line[n:]
+		return n, nil
+	}
+	<-r.closed
+	return 0, io.EOF
+}
+
+// BenchmarkIdleConnections reports the heap held per idle connection
+// (reader goroutines parked waiting for their next message)
+func BenchmarkIdleConnections(b *testing.B) {
+	const conns = 10000
+
+	for i := 0; i < b.N; i++ {
+		closed := make(chan struct{})
+		var ready, done sync.WaitGroup
+		ready.Add(conns)
+		done.Add(conns)
+
+		var before runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		for j := 0; j < conns; j++ {
+			go func() {
+				defer done.Done()
+				r := newLineReader(&idleReader{line: []byte(benchLine), closed: closed})
+				defer r.Release()
+				r.ReadLine()
+				ready.Done()
+				r.ReadLine() // blocks until closed
+			}()
+		}
+		ready.Wait()
+
+		var after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapInuse-before.HeapInuse)/conns, "heap-bytes/conn")
+
+		close(closed)
+		done.Wait()
+	}
+}
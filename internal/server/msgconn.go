@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// messageConn implements the read side of a net.Conn for message-oriented
+// transports (WebSocket, gRPC). A pump goroutine delivers one protocol line
+// per message; Read hands them out as a byte stream and honours the read
+// deadline without touching the underlying transport, so the servers'
+// timeout-and-retry read loop works unchanged.
+type messageConn struct {
+	messages  chan []byte
+	readErr   error // set before messages is closed
+	pending   []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+}
+
+func newMessageConn() messageConn {
+	return messageConn{
+		messages: make(chan []byte, 16),
+		done:     make(chan struct{}),
+	}
+}
+
+// deliver queues one line for Read, appending the newline delimiter if
+// missing. It returns false once the conn is closed.
+func (c *messageConn) deliver(line []byte) bool {
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		line = append(line, '\n')
+	}
+	select {
+	case c.messages <- line:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// finish ends the stream; Read returns err once queued lines are consumed
+func (c *messageConn) finish(err error) {
+	c.readErr = err
+	close(c.messages)
+}
+
+// markClosed releases a pump blocked in deliver
+func (c *messageConn) markClosed() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Read implements net.Conn
+func (c *messageConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		c.deadlineMu.Lock()
+		deadline := c.readDeadline
+		c.deadlineMu.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case data, ok := <-c.messages:
+			if !ok {
+				return 0, c.readErr
+			}
+			c.pending = data
+		case <-timeout:
+			return 0, readTimeoutError{}
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// SetReadDeadline implements net.Conn
+func (c *messageConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// splitLines splits a written buffer into its non-empty protocol lines
+func splitLines(p []byte) [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(p, []byte{'\n'}) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// readTimeoutError is returned by messageConn.Read when the read deadline passes
+type readTimeoutError struct{}
+
+func (readTimeoutError) Error() string   { return "read timeout" }
+func (readTimeoutError) Timeout() bool   { return true }
+func (readTimeoutError) Temporary() bool { return true }
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2HeaderLen = 16 // signature + ver/cmd + family + length
+
+	proxyCmdLocal = 0x0
+	proxyCmdProxy = 0x1
+
+	proxyFamTCP4 = 0x11
+	proxyFamTCP6 = 0x21
+)
+
+// proxyListener wraps a TCP listener behind a load balancer that sends a
+// PROXY protocol v2 header (HAProxy send-proxy-v2, AWS NLB). Accepted conns
+// report the original client address from the header as RemoteAddr, so
+// admission control, rate limiting and logs see the real client.
+//
+// Headers are read in a goroutine per connection so a slow or silent peer
+// can't stall Accept. Connections from outside the trusted networks are
+// passed through untouched; trusted connections without a valid header are
+// dropped.
+type proxyListener struct {
+	net.Listener
+	trusted       []*net.IPNet // peers whose headers are believed
+	headerTimeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyListener(inner net.Listener, trusted []*net.IPNet, headerTimeout time.Duration) *proxyListener {
+	l := &proxyListener{
+		Listener:      inner,
+		trusted:       trusted,
+		headerTimeout: headerTimeout,
+		conns:         make(chan net.Conn),
+		errs:          make(chan error),
+		closed:        make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.closed:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	if !containsIP(l.trusted, addrIP(conn.RemoteAddr())) {
+		l.deliver(conn)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(l.headerTimeout))
+	src, dst, err := readProxyV2Header(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		fmt.Printf("Dropping connection from %s: invalid PROXY header: %v\n", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	if src != nil {
+		conn = &proxyConn{Conn: conn, remote: src, local: dst}
+	}
+	l.deliver(conn)
+}
+
+func (l *proxyListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Accept returns the next connection whose PROXY header has been read
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the underlying listener
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// proxyConn overrides the addresses of a connection with those from its
+// PROXY header
+type proxyConn struct {
+	net.Conn
+	remote net.Addr
+	local  net.Addr
+}
+
+// RemoteAddr returns the original client address
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr returns the original destination address
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// readProxyV2Header consumes a PROXY protocol v2 header from r. It returns
+// nil addresses for LOCAL commands (load balancer health checks) and for
+// address families other than TCP over IPv4/IPv6.
+func readProxyV2Header(r io.Reader) (src, dst net.Addr, err error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil, fmt.Errorf("missing PROXY v2 signature")
+	}
+
+	version, command := header[12]>>4, header[12]&0x0F
+	if version != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY version %d", version)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("failed to read addresses: %w", err)
+	}
+
+	switch command {
+	case proxyCmdLocal:
+		return nil, nil, nil
+	case proxyCmdProxy:
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY command %d", command)
+	}
+
+	// Any TLVs after the addresses are ignored
+	switch family := header[13]; family {
+	case proxyFamTCP4:
+		if len(payload) < 12 {
+			return nil, nil, fmt.Errorf("short IPv4 address block")
+		}
+		src = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		dst = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case proxyFamTCP6:
+		if len(payload) < 36 {
+			return nil, nil, fmt.Errorf("short IPv6 address block")
+		}
+		src = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		dst = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	default:
+		return nil, nil, nil
+	}
+
+	return src, dst, nil
+}
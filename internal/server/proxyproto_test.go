@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(command byte, family byte, addrs []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(0x20 | command)
+	buf.WriteByte(family)
+	binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+func tcp4Addrs(src, dst string, srcPort, dstPort uint16) []byte {
+	addrs := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, srcPort)
+	return binary.BigEndian.AppendUint16(addrs, dstPort)
+}
+
+func TestReadProxyV2Header(t *testing.T) {
+	header := proxyV2Header(proxyCmdProxy, proxyFamTCP4, tcp4Addrs("203.0.113.7", "10.0.0.1", 51000, 8080))
+	r := bytes.NewReader(append(header, `{"type":"identify"}`...))
+
+	src, dst, err := readProxyV2Header(r)
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	if src.String() != "203.0.113.7:51000" || dst.String() != "10.0.0.1:8080" {
+		t.Errorf("Unexpected addresses: src=%v dst=%v", src, dst)
+	}
+
+	// The protocol stream must start right after the header
+	rest := make([]byte, r.Len())
+	r.Read(rest)
+	if string(rest) != `{"type":"identify"}` {
+		t.Errorf("Header consumed too much: %q", rest)
+	}
+}
+
+func TestReadProxyV2Header_Local(t *testing.T) {
+	src, _, err := readProxyV2Header(bytes.NewReader(proxyV2Header(proxyCmdLocal, 0x00, nil)))
+	if err != nil {
+		t.Fatalf("Failed to read LOCAL header: %v", err)
+	}
+	if src != nil {
+		t.Errorf("Expected no address for LOCAL command, got %v", src)
+	}
+}
+
+func TestReadProxyV2Header_Invalid(t *testing.T) {
+	if _, _, err := readProxyV2Header(bytes.NewReader([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))); err == nil {
+		t.Error("Expected error for data without a PROXY signature")
+	}
+
+	short := proxyV2Header(proxyCmdProxy, proxyFamTCP4, []byte{1, 2, 3})
+	if _, _, err := readProxyV2Header(bytes.NewReader(short)); err == nil {
+		t.Error("Expected error for truncated IPv4 address block")
+	}
+}
+
+func TestProxyListener_RemoteAddr(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	loopback, _ := parseCIDRs([]string{"127.0.0.1"})
+	l := newProxyListener(inner, loopback, time.Second)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	client.Write(proxyV2Header(proxyCmdProxy, proxyFamTCP4, tcp4Addrs("198.51.100.9", "10.0.0.1", 40000, 8080)))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.RemoteAddr().String(); got != "198.51.100.9:40000" {
+		t.Errorf("Expected real client address, got %s", got)
+	}
+}
+
+func TestProxyListener_UntrustedPeer(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	balancers, _ := parseCIDRs([]string{"10.0.0.0/8"})
+	l := newProxyListener(inner, balancers, time.Second)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	client.Write(proxyV2Header(proxyCmdProxy, proxyFamTCP4, tcp4Addrs("198.51.100.9", "10.0.0.1", 40000, 8080)))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	// A forged header from outside the load balancers is never believed
+	if got := addrIP(conn.RemoteAddr()).String(); got != "127.0.0.1" {
+		t.Errorf("Expected the peer's own address, got %s", got)
+	}
+}
@@ -0,0 +1,18 @@
+package server
+
+import "testing"
+
+func TestListenReusePort(t *testing.T) {
+	first, err := listenReusePort("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to open first listener: %v", err)
+	}
+	defer first.Close()
+
+	// A second listener may bind the very same port
+	second, err := listenReusePort(first.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to open second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+}
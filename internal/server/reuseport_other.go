@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenReusePort is only supported on Linux
+func listenReusePort(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("SO_REUSEPORT listeners are only supported on linux")
+}
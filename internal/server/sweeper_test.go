@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/connection"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+func TestSweepInactive(t *testing.T) {
+	cfg := &config.TCPServerConfig{
+		InactivityTimeout: 50 * time.Millisecond,
+		InactivitySweeper: true,
+	}
+	manager := connection.NewManager(10)
+	manager.SetWriteOptions(0, time.Second)
+	s := newServerCore(cfg, manager, nil, nil)
+
+	idleConn, idlePeer := net.Pipe()
+	defer idlePeer.Close()
+	activeConn, activePeer := net.Pipe()
+	defer activePeer.Close()
+
+	manager.Register("idle", "90210", "Beverly Hills", idleConn)
+	time.Sleep(100 * time.Millisecond)
+	manager.Register("active", "10001", "New York", activeConn)
+
+	s.sweepInactive()
+
+	idlePeer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idlePeer.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the idle connection to be closed")
+	}
+	if client, _ := manager.Get("idle"); client.GetDisconnectReason() != "inactivity timeout" {
+		t.Errorf("Expected inactivity timeout reason, got %q", client.GetDisconnectReason())
+	}
+
+	activePeer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := activePeer.Read(make([]byte, 1)); err == nil || !isTimeout(err) {
+		t.Errorf("Expected the active connection to stay open, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -1,14 +1,11 @@
 package server
 
 import (
-	"bufio"
-	"context"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/smukkama/weather-server/internal/connection"
 	"github.com/smukkama/weather-server/internal/protocol"
 	"github.com/smukkama/weather-server/internal/queue"
@@ -18,44 +15,39 @@ import (
 
 // TCPServer is the main TCP server for weather clients
 type TCPServer struct {
-	config       *config.TCPServerConfig
-	connManager  *connection.Manager
-	timerManager *timer.TimerManager
-	producer     *queue.Producer
-	listener     net.Listener
-	wg           sync.WaitGroup
-	stopCh       chan struct{}
-	ctx          context.Context
-	cancel       context.CancelFunc
+	serverCore
+	listeners []net.Listener
+	wg        sync.WaitGroup
+	stopCh    chan struct{}
 }
 
 // NewTCPServer creates a new TCP server
-func NewTCPServer(cfg *config.TCPServerConfig, connManager *connection.Manager, timerManager *timer.TimerManager, producer *queue.Producer) *TCPServer {
-	ctx, cancel := context.WithCancel(context.Background())
+func NewTCPServer(cfg *config.TCPServerConfig, connManager *connection.Manager, timerManager *timer.TimerManager, producer queue.Producer) *TCPServer {
 	return &TCPServer{
-		config:       cfg,
-		connManager:  connManager,
-		timerManager: timerManager,
-		producer:     producer,
-		stopCh:       make(chan struct{}),
-		ctx:          ctx,
-		cancel:       cancel,
+		serverCore: newServerCore(cfg, connManager, timerManager, producer),
+		stopCh:     make(chan struct{}),
 	}
 }
 
 // Start starts the TCP server
 func (s *TCPServer) Start() error {
-	addr := fmt.Sprintf(":%d", s.config.Port)
-	listener, err := net.Listen("tcp", addr)
+	if err := s.initAdmission(); err != nil {
+		return err
+	}
+
+	listeners, err := s.openListeners()
 	if err != nil {
-		return fmt.Errorf("failed to start TCP server: %w", err)
+		return err
 	}
 
-	s.listener = listener
-	fmt.Printf("TCP server listening on %s\n", addr)
+	s.listeners = listeners
+	s.startInactivitySweeper()
+	fmt.Printf("TCP server listening on :%d\n", s.config.Port)
 
-	s.wg.Add(1)
-	go s.acceptConnections()
+	for _, listener := range s.listeners {
+		s.wg.Add(1)
+		go s.acceptConnections(listener)
+	}
 
 	return nil
 }
@@ -65,19 +57,20 @@ func (s *TCPServer) Stop() {
 	close(s.stopCh)
 	s.cancel()
 
-	if s.listener != nil {
-		s.listener.Close()
+	for _, listener := range s.listeners {
+		listener.Close()
 	}
+	s.active.closeAll()
 
 	s.wg.Wait()
 	fmt.Println("TCP server stopped")
 }
 
-func (s *TCPServer) acceptConnections() {
+func (s *TCPServer) acceptConnections(listener net.Listener) {
 	defer s.wg.Done()
 
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-s.stopCh:
@@ -88,13 +81,27 @@ func (s *TCPServer) acceptConnections() {
 			}
 		}
 
-		// Check max connections
-		if s.connManager.Count() >= s.config.MaxConnections {
+		// Check max connections, unless the eviction policy may make room
+		// once the station identifies
+		if s.connManager.Count() >= s.config.MaxConnections && !s.connManager.Evicts() {
 			fmt.Println("Maximum connections reached, rejecting connection")
 			conn.Close()
 			continue
 		}
 
+		// Per-IP limits and allow/deny lists
+		if err := s.admission.Admit(conn.RemoteAddr()); err != nil {
+			fmt.Printf("Rejecting connection from %s: %v\n", conn.RemoteAddr(), err)
+			// Reply off the accept loop so a slow peer can't stall it
+			go func(conn net.Conn) {
+				if admErr, ok := err.(*admissionError); ok {
+					s.sendError(conn, admErr.code, admErr.msg)
+				}
+				conn.Close()
+			}(conn)
+			continue
+		}
+
 		// Handle connection in a new goroutine
 		s.wg.Add(1)
 		go s.handleConnection(conn)
@@ -104,90 +111,54 @@ func (s *TCPServer) acceptConnections() {
 func (s *TCPServer) handleConnection(conn net.Conn) {
 	defer s.wg.Done()
 	defer conn.Close()
-
-	// Generate connection ID
-	connectionID := uuid.New().String()
-	fmt.Printf("New connection: %s from %s\n", connectionID, conn.RemoteAddr())
-
-	// Set identify timeout
-	conn.SetReadDeadline(time.Now().Add(s.config.IdentifyTimeout))
-
-	// Read identification message
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Printf("Failed to read identify message: %v\n", err)
+	defer s.admission.Release(conn.RemoteAddr())
+	if !s.active.add(conn) {
 		return
 	}
+	defer s.active.remove(conn)
 
-	// Parse identification message
-	msg, err := protocol.ParseMessage([]byte(line))
+	// Identify (or resume) the client
+	reader := newLineReader(conn)
+	defer reader.Release()
+	client, err := s.handshake(conn, reader)
 	if err != nil {
-		fmt.Printf("Failed to parse identify message: %v\n", err)
-		s.sendError(conn, "invalid message format")
+		fmt.Printf("Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
 		return
 	}
+	connectionID := client.ConnectionID
+	defer s.releaseConnection(connectionID, conn)
 
-	identifyMsg, ok := msg.(*protocol.IdentifyMessage)
-	if !ok {
-		fmt.Printf("Expected identify message, got %T\n", msg)
-		s.sendError(conn, "expected identify message")
-		return
-	}
-
-	// Register client
-	if err := s.connManager.Register(connectionID, identifyMsg.Zipcode, identifyMsg.City, conn); err != nil {
-		fmt.Printf("Failed to register client: %v\n", err)
-		s.sendError(conn, "failed to register")
-		return
-	}
-	defer s.connManager.Unregister(connectionID)
-
-	fmt.Printf("Client identified: %s (zipcode=%s, city=%s)\n", connectionID, identifyMsg.Zipcode, identifyMsg.City)
-
-	// Send acknowledgment
-	ack := protocol.NewAckMessage(protocol.AckStatusIdentified)
-	if err := s.sendMessage(conn, ack); err != nil {
-		fmt.Printf("Failed to send ack: %v\n", err)
-		return
-	}
-
-	// Schedule inactivity timer
-	s.scheduleInactivityTimer(connectionID)
-
-	// Clear read deadline for normal operation
-	conn.SetReadDeadline(time.Time{})
-
-	// Handle messages
+	// Handle messages. Reads block without a deadline: the inactivity timer
+	// or Stop closes the connection to end the loop.
 	for {
-		select {
-		case <-s.stopCh:
-			return
-		default:
-		}
-
-		// Read message with a reasonable timeout
-		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		line, err := reader.ReadString('\n')
+		line, err := reader.ReadLine()
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				// Timeout, continue reading
-				continue
-			}
 			// Connection closed or error
 			fmt.Printf("Connection %s closed: %v\n", connectionID, err)
 			return
 		}
 
-		// Parse message
-		msg, err := protocol.ParseMessage([]byte(line))
+		// Parse message, then drop it if the connection exceeds its rate
+		// limit
+		msg, err := protocol.ParseMessage(line)
+		if !s.allowMessage(connectionID, client.Zipcode, msg) {
+			continue
+		}
 		if err != nil {
 			fmt.Printf("Failed to parse message: %v\n", err)
+			errMsg := protocol.NewErrorMessage(protocol.ErrorCodeFor(err), err.Error())
+			s.connManager.SendToConnection(connectionID, errMsg)
 			continue
 		}
 
+		// Clean disconnect requested by the client
+		if goodbye, ok := msg.(*protocol.GoodbyeMessage); ok {
+			s.handleGoodbye(connectionID, goodbye)
+			return
+		}
+
 		// Handle message
-		if err := s.handleMessage(connectionID, identifyMsg.Zipcode, identifyMsg.City, msg, conn); err != nil {
+		if err := s.handleMessage(connectionID, client.Zipcode, client.City, msg); err != nil {
 			fmt.Printf("Failed to handle message: %v\n", err)
 		}
 
@@ -199,13 +170,13 @@ func (s *TCPServer) handleConnection(conn net.Conn) {
 	}
 }
 
-func (s *TCPServer) handleMessage(connectionID, zipcode, city string, msg interface{}, conn net.Conn) error {
+func (s *TCPServer) handleMessage(connectionID, zipcode, city string, msg interface{}) error {
 	switch m := msg.(type) {
 	case *protocol.MetricsMessage:
 		return s.handleMetrics(connectionID, zipcode, city, m)
 
 	case *protocol.KeepaliveMessage:
-		return s.handleKeepalive(conn)
+		return s.handleKeepalive(connectionID)
 
 	default:
 		return fmt.Errorf("unknown message type: %T", msg)
@@ -214,12 +185,9 @@ func (s *TCPServer) handleMessage(connectionID, zipcode, city string, msg interf
 
 func (s *TCPServer) handleMetrics(connectionID, zipcode, city string, msg *protocol.MetricsMessage) error {
 	// Create internal metric message
-	metricMsg := &protocol.MetricMessage{
-		ConnectionID: connectionID,
-		Zipcode:      zipcode,
-		City:         city,
-		ReceivedAt:   time.Now(),
-		Data:         msg.Data,
+	metricMsg := s.metricMessage(connectionID, zipcode, city, time.Now(), msg.Data)
+	if s.divert(connectionID, msg.Seq, metricMsg) {
+		return nil
 	}
 
 	// Encode to JSON
@@ -229,7 +197,10 @@ func (s *TCPServer) handleMetrics(connectionID, zipcode, city string, msg *proto
 	}
 
 	// Publish to Kafka (key is zipcode for partitioning)
-	if err := s.producer.Publish(s.ctx, zipcode, data); err != nil {
+	ctx, spool := queue.WithSpoolReport(s.publishContext(connectionID))
+	err = s.producer.Publish(ctx, zipcode, data)
+	s.ackMetrics(connectionID, msg.Seq, spool.Spooled, err)
+	if err != nil {
 		return fmt.Errorf("failed to publish metric: %w", err)
 	}
 
@@ -237,44 +208,7 @@ func (s *TCPServer) handleMetrics(connectionID, zipcode, city string, msg *proto
 	return nil
 }
 
-func (s *TCPServer) handleKeepalive(conn net.Conn) error {
+func (s *TCPServer) handleKeepalive(connectionID string) error {
 	ack := protocol.NewAckMessage(protocol.AckStatusAlive)
-	return s.sendMessage(conn, ack)
-}
-
-func (s *TCPServer) sendMessage(conn net.Conn, msg interface{}) error {
-	data, err := protocol.EncodeMessage(msg)
-	if err != nil {
-		return err
-	}
-
-	_, err = conn.Write(append(data, '\n'))
-	return err
-}
-
-func (s *TCPServer) sendError(conn net.Conn, errMsg string) {
-	ack := protocol.NewAckMessage(protocol.AckStatusError)
-	s.sendMessage(conn, ack)
-}
-
-func (s *TCPServer) scheduleInactivityTimer(connectionID string) {
-	timerID := fmt.Sprintf("inactivity-%s", connectionID)
-	expiryAt := time.Now().Add(s.config.InactivityTimeout)
-
-	callback := func() {
-		fmt.Printf("Inactivity timeout for connection %s\n", connectionID)
-
-		// Get client info
-		client, exists := s.connManager.Get(connectionID)
-		if !exists {
-			return
-		}
-
-		// Close connection
-		client.Conn.Close()
-
-		// Unregister will happen automatically in deferred cleanup
-	}
-
-	s.timerManager.Schedule(timerID, expiryAt, callback)
+	return s.connManager.SendToConnection(connectionID, ack)
 }
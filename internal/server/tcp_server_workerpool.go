@@ -1,14 +1,11 @@
 package server
 
 import (
-	"bufio"
-	"context"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/smukkama/weather-server/internal/connection"
 	"github.com/smukkama/weather-server/internal/protocol"
 	"github.com/smukkama/weather-server/internal/queue"
@@ -21,18 +18,14 @@ type ConnectionJob struct {
 	ConnectionID string
 	Zipcode      string
 	City         string
-	Data         []byte
-	Conn         net.Conn
+	Message      interface{}
 	Timestamp    time.Time
 }
 
 // WorkerPoolTCPServer is a TCP server using worker pool pattern
 type WorkerPoolTCPServer struct {
-	config       *config.TCPServerConfig
-	connManager  *connection.Manager
-	timerManager *timer.TimerManager
-	producer     *queue.Producer
-	listener     net.Listener
+	serverCore
+	listeners []net.Listener
 
 	// Worker pool components
 	jobQueue    chan *ConnectionJob
@@ -41,8 +34,6 @@ type WorkerPoolTCPServer struct {
 
 	wg     sync.WaitGroup
 	stopCh chan struct{}
-	ctx    context.Context
-	cancel context.CancelFunc
 }
 
 // Worker represents a worker that processes connection jobs
@@ -58,12 +49,10 @@ func NewWorkerPoolTCPServer(
 	cfg *config.TCPServerConfig,
 	connManager *connection.Manager,
 	timerManager *timer.TimerManager,
-	producer *queue.Producer,
+	producer queue.Producer,
 	workerCount int,
 	jobQueueSize int,
 ) *WorkerPoolTCPServer {
-	ctx, cancel := context.WithCancel(context.Background())
-
 	if workerCount <= 0 {
 		workerCount = 10 // Default 10 workers
 	}
@@ -73,35 +62,36 @@ func NewWorkerPoolTCPServer(
 	}
 
 	return &WorkerPoolTCPServer{
-		config:       cfg,
-		connManager:  connManager,
-		timerManager: timerManager,
-		producer:     producer,
-		jobQueue:     make(chan *ConnectionJob, jobQueueSize),
-		workerCount:  workerCount,
-		stopCh:       make(chan struct{}),
-		ctx:          ctx,
-		cancel:       cancel,
+		serverCore:  newServerCore(cfg, connManager, timerManager, producer),
+		jobQueue:    make(chan *ConnectionJob, jobQueueSize),
+		workerCount: workerCount,
+		stopCh:      make(chan struct{}),
 	}
 }
 
 // Start starts the TCP server and worker pool
 func (s *WorkerPoolTCPServer) Start() error {
-	addr := fmt.Sprintf(":%d", s.config.Port)
-	listener, err := net.Listen("tcp", addr)
+	if err := s.initAdmission(); err != nil {
+		return err
+	}
+
+	listeners, err := s.openListeners()
 	if err != nil {
-		return fmt.Errorf("failed to start TCP server: %w", err)
+		return err
 	}
 
-	s.listener = listener
-	fmt.Printf("Worker Pool TCP server listening on %s with %d workers\n", addr, s.workerCount)
+	s.listeners = listeners
+	s.startInactivitySweeper()
+	fmt.Printf("Worker Pool TCP server listening on :%d with %d workers\n", s.config.Port, s.workerCount)
 
 	// Start workers
 	s.startWorkers()
 
 	// Start accepting connections
-	s.wg.Add(1)
-	go s.acceptConnections()
+	for _, listener := range s.listeners {
+		s.wg.Add(1)
+		go s.acceptConnections(listener)
+	}
 
 	return nil
 }
@@ -112,10 +102,13 @@ func (s *WorkerPoolTCPServer) Stop() {
 	close(s.stopCh)
 	s.cancel()
 
-	if s.listener != nil {
-		s.listener.Close()
+	for _, listener := range s.listeners {
+		listener.Close()
 	}
 
+	// Unblock connection readers
+	s.active.closeAll()
+
 	// Wait for accept loop to finish
 	s.wg.Wait()
 
@@ -147,11 +140,11 @@ func (s *WorkerPoolTCPServer) startWorkers() {
 }
 
 // acceptConnections accepts incoming connections
-func (s *WorkerPoolTCPServer) acceptConnections() {
+func (s *WorkerPoolTCPServer) acceptConnections(listener net.Listener) {
 	defer s.wg.Done()
 
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-s.stopCh:
@@ -162,13 +155,27 @@ func (s *WorkerPoolTCPServer) acceptConnections() {
 			}
 		}
 
-		// Check max connections
-		if s.connManager.Count() >= s.config.MaxConnections {
+		// Check max connections, unless the eviction policy may make room
+		// once the station identifies
+		if s.connManager.Count() >= s.config.MaxConnections && !s.connManager.Evicts() {
 			fmt.Println("Maximum connections reached, rejecting connection")
 			conn.Close()
 			continue
 		}
 
+		// Per-IP limits and allow/deny lists
+		if err := s.admission.Admit(conn.RemoteAddr()); err != nil {
+			fmt.Printf("Rejecting connection from %s: %v\n", conn.RemoteAddr(), err)
+			// Reply off the accept loop so a slow peer can't stall it
+			go func(conn net.Conn) {
+				if admErr, ok := err.(*admissionError); ok {
+					s.sendError(conn, admErr.code, admErr.msg)
+				}
+				conn.Close()
+			}(conn)
+			continue
+		}
+
 		// Handle connection in a lightweight goroutine (just for reading)
 		s.wg.Add(1)
 		go s.handleConnection(conn)
@@ -180,88 +187,59 @@ func (s *WorkerPoolTCPServer) acceptConnections() {
 func (s *WorkerPoolTCPServer) handleConnection(conn net.Conn) {
 	defer s.wg.Done()
 	defer conn.Close()
-
-	// Generate connection ID
-	connectionID := uuid.New().String()
-	fmt.Printf("New connection: %s from %s\n", connectionID, conn.RemoteAddr())
-
-	// Set identify timeout
-	conn.SetReadDeadline(time.Now().Add(s.config.IdentifyTimeout))
-
-	// Read identification message
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Printf("Failed to read identify message: %v\n", err)
+	defer s.admission.Release(conn.RemoteAddr())
+	if !s.active.add(conn) {
 		return
 	}
+	defer s.active.remove(conn)
 
-	// Parse identification message
-	msg, err := protocol.ParseMessage([]byte(line))
+	// Identify (or resume) the client
+	reader := newLineReader(conn)
+	defer reader.Release()
+	client, err := s.handshake(conn, reader)
 	if err != nil {
-		fmt.Printf("Failed to parse identify message: %v\n", err)
-		s.sendError(conn, "invalid message format")
+		fmt.Printf("Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
 		return
 	}
+	connectionID := client.ConnectionID
+	defer s.releaseConnection(connectionID, conn)
 
-	identifyMsg, ok := msg.(*protocol.IdentifyMessage)
-	if !ok {
-		fmt.Printf("Expected identify message, got %T\n", msg)
-		s.sendError(conn, "expected identify message")
-		return
-	}
-
-	// Register client
-	if err := s.connManager.Register(connectionID, identifyMsg.Zipcode, identifyMsg.City, conn); err != nil {
-		fmt.Printf("Failed to register client: %v\n", err)
-		s.sendError(conn, "failed to register")
-		return
-	}
-	defer s.connManager.Unregister(connectionID)
-
-	fmt.Printf("Client identified: %s (zipcode=%s, city=%s)\n", connectionID, identifyMsg.Zipcode, identifyMsg.City)
-
-	// Send acknowledgment
-	ack := protocol.NewAckMessage(protocol.AckStatusIdentified)
-	if err := s.sendMessage(conn, ack); err != nil {
-		fmt.Printf("Failed to send ack: %v\n", err)
-		return
-	}
-
-	// Schedule inactivity timer
-	s.scheduleInactivityTimer(connectionID)
-
-	// Clear read deadline for normal operation
-	conn.SetReadDeadline(time.Time{})
-
-	// Read messages and dispatch to workers
+	// Read messages and dispatch to workers. Reads block without a deadline:
+	// the inactivity timer or Stop closes the connection to end the loop.
 	for {
-		select {
-		case <-s.stopCh:
+		line, err := reader.ReadLine()
+		if err != nil {
+			// Connection closed or error
+			fmt.Printf("Connection %s closed: %v\n", connectionID, err)
 			return
-		default:
 		}
 
-		// Read message with a reasonable timeout
-		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		line, err := reader.ReadString('\n')
+		// Parse message, then drop it if the connection exceeds its rate
+		// limit
+		msg, err := protocol.ParseMessage(line)
+		if !s.allowMessage(connectionID, client.Zipcode, msg) {
+			continue
+		}
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				// Timeout, continue reading
-				continue
-			}
-			// Connection closed or error
-			fmt.Printf("Connection %s closed: %v\n", connectionID, err)
+			fmt.Printf("Failed to parse message: %v\n", err)
+			errMsg := protocol.NewErrorMessage(protocol.ErrorCodeFor(err), err.Error())
+			s.connManager.SendToConnection(connectionID, errMsg)
+			continue
+		}
+
+		// Clean disconnect requested by the client; handled here rather
+		// than queued, so it can't be dropped with a full queue
+		if goodbye, ok := msg.(*protocol.GoodbyeMessage); ok {
+			s.handleGoodbye(connectionID, goodbye)
 			return
 		}
 
 		// Create job and send to worker pool
 		job := &ConnectionJob{
 			ConnectionID: connectionID,
-			Zipcode:      identifyMsg.Zipcode,
-			City:         identifyMsg.City,
-			Data:         []byte(line),
-			Conn:         conn,
+			Zipcode:      client.Zipcode,
+			City:         client.City,
+			Message:      msg,
 			Timestamp:    time.Now(),
 		}
 
@@ -310,15 +288,8 @@ func (w *Worker) Start(wg *sync.WaitGroup) {
 
 // processJob processes a connection job
 func (w *Worker) processJob(job *ConnectionJob) {
-	// Parse message
-	msg, err := protocol.ParseMessage(job.Data)
-	if err != nil {
-		fmt.Printf("Worker %d: Failed to parse message: %v\n", w.id, err)
-		return
-	}
-
 	// Handle message based on type
-	switch m := msg.(type) {
+	switch m := job.Message.(type) {
 	case *protocol.MetricsMessage:
 		if err := w.handleMetrics(job, m); err != nil {
 			fmt.Printf("Worker %d: Failed to handle metrics: %v\n", w.id, err)
@@ -330,19 +301,16 @@ func (w *Worker) processJob(job *ConnectionJob) {
 		}
 
 	default:
-		fmt.Printf("Worker %d: Unknown message type: %T\n", w.id, msg)
+		fmt.Printf("Worker %d: Unknown message type: %T\n", w.id, job.Message)
 	}
 }
 
 // handleMetrics handles metrics message
 func (w *Worker) handleMetrics(job *ConnectionJob, msg *protocol.MetricsMessage) error {
 	// Create internal metric message
-	metricMsg := &protocol.MetricMessage{
-		ConnectionID: job.ConnectionID,
-		Zipcode:      job.Zipcode,
-		City:         job.City,
-		ReceivedAt:   job.Timestamp,
-		Data:         msg.Data,
+	metricMsg := w.server.metricMessage(job.ConnectionID, job.Zipcode, job.City, job.Timestamp, msg.Data)
+	if w.server.divert(job.ConnectionID, msg.Seq, metricMsg) {
+		return nil
 	}
 
 	// Encode to JSON
@@ -352,7 +320,10 @@ func (w *Worker) handleMetrics(job *ConnectionJob, msg *protocol.MetricsMessage)
 	}
 
 	// Publish to Kafka (key is zipcode for partitioning)
-	if err := w.server.producer.Publish(w.server.ctx, job.Zipcode, data); err != nil {
+	ctx, spool := queue.WithSpoolReport(w.server.publishContext(job.ConnectionID))
+	err = w.server.producer.Publish(ctx, job.Zipcode, data)
+	w.server.ackMetrics(job.ConnectionID, msg.Seq, spool.Spooled, err)
+	if err != nil {
 		return fmt.Errorf("failed to publish metric: %w", err)
 	}
 
@@ -363,44 +334,5 @@ func (w *Worker) handleMetrics(job *ConnectionJob, msg *protocol.MetricsMessage)
 // handleKeepalive handles keepalive message
 func (w *Worker) handleKeepalive(job *ConnectionJob) error {
 	ack := protocol.NewAckMessage(protocol.AckStatusAlive)
-	return w.server.sendMessage(job.Conn, ack)
-}
-
-// Helper methods
-
-func (s *WorkerPoolTCPServer) sendMessage(conn net.Conn, msg interface{}) error {
-	data, err := protocol.EncodeMessage(msg)
-	if err != nil {
-		return err
-	}
-
-	_, err = conn.Write(append(data, '\n'))
-	return err
-}
-
-func (s *WorkerPoolTCPServer) sendError(conn net.Conn, errMsg string) {
-	ack := protocol.NewAckMessage(protocol.AckStatusError)
-	s.sendMessage(conn, ack)
-}
-
-func (s *WorkerPoolTCPServer) scheduleInactivityTimer(connectionID string) {
-	timerID := fmt.Sprintf("inactivity-%s", connectionID)
-	expiryAt := time.Now().Add(s.config.InactivityTimeout)
-
-	callback := func() {
-		fmt.Printf("Inactivity timeout for connection %s\n", connectionID)
-
-		// Get client info
-		client, exists := s.connManager.Get(connectionID)
-		if !exists {
-			return
-		}
-
-		// Close connection
-		client.Conn.Close()
-
-		// Unregister will happen automatically in deferred cleanup
-	}
-
-	s.timerManager.Schedule(timerID, expiryAt, callback)
+	return w.server.connManager.SendToConnection(job.ConnectionID, ack)
 }
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsListener is a net.Listener that accepts WebSocket connections over HTTP
+// and hands them out as line-oriented net.Conns, so the TCP servers can serve
+// the same identify/metrics/keepalive protocol over WebSocket unchanged.
+type wsListener struct {
+	ln        net.Listener
+	server    *http.Server
+	upgrader  websocket.Upgrader
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newWebSocketListener starts an HTTP server on addr upgrading requests on
+// path to WebSocket. If allowedOrigins is empty any origin is accepted.
+func newWebSocketListener(addr, path string, allowedOrigins []string) (*wsListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start WebSocket listener: %w", err)
+	}
+
+	l := &wsListener{
+		ln:     ln,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	l.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     originChecker(allowedOrigins),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, l.handleUpgrade)
+	l.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := l.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("WebSocket listener error: %v\n", err)
+		}
+	}()
+
+	return l, nil
+}
+
+func (l *wsListener) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	ws, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already wrote an HTTP error response
+		fmt.Printf("WebSocket upgrade failed from %s: %v\n", r.RemoteAddr, err)
+		return
+	}
+
+	conn := newWSConn(ws)
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Accept waits for the next WebSocket connection
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the HTTP server; established WebSocket connections are
+// closed by their handlers
+func (l *wsListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = l.server.Shutdown(ctx)
+	})
+	return err
+}
+
+// Addr returns the listener's network address
+func (l *wsListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// originChecker returns a CheckOrigin function for the upgrader
+func originChecker(allowedOrigins []string) func(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return func(r *http.Request) bool { return true }
+	}
+
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		// Non-browser clients don't send an Origin header
+		return origin == "" || allowed[origin]
+	}
+}
+
+// wsConn adapts a WebSocket connection to a newline-delimited net.Conn.
+// Each incoming text message becomes one line; each written line is sent as
+// one text message. Reads go through messageConn so read deadlines can time
+// out without breaking the WebSocket (gorilla treats a timed-out read as
+// fatal).
+type wsConn struct {
+	messageConn
+	ws *websocket.Conn
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	c := &wsConn{
+		messageConn: newMessageConn(),
+		ws:          ws,
+	}
+	go c.readPump()
+	return c
+}
+
+func (c *wsConn) readPump() {
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.finish(err)
+			return
+		}
+		if !c.deliver(data) {
+			c.finish(net.ErrClosed)
+			return
+		}
+	}
+}
+
+// Write implements net.Conn, sending each line as a separate text message
+func (c *wsConn) Write(p []byte) (int, error) {
+	for _, line := range splitLines(p) {
+		if err := c.ws.WriteMessage(websocket.TextMessage, line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close implements net.Conn
+func (c *wsConn) Close() error {
+	c.markClosed()
+	return c.ws.Close()
+}
+
+// LocalAddr implements net.Conn
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn
+func (c *wsConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
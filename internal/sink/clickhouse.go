@@ -0,0 +1,73 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// ClickHouse inserts readings through ClickHouse's HTTP interface in
+// JSONEachRow format, so the table's columns are matched by name
+type ClickHouse struct {
+	cfg    config.ClickHouseConfig
+	client *http.Client
+}
+
+// NewClickHouse creates a sink inserting into cfg.Table
+func NewClickHouse(cfg config.ClickHouseConfig) *ClickHouse {
+	return &ClickHouse{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (c *ClickHouse) Name() string { return "clickhouse" }
+
+// WriteBatch inserts the readings with one INSERT
+func (c *ClickHouse) WriteBatch(ctx context.Context, metrics []*database.RawMetric) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, m := range metrics {
+		if err := enc.Encode(newRow(m)); err != nil {
+			return fmt.Errorf("failed to encode metric: %w", err)
+		}
+	}
+
+	query := url.Values{"query": {fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", c.cfg.Table)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(c.cfg.URL, "/")+"/?"+query.Encode(), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.cfg.User != "" {
+		req.Header.Set("X-ClickHouse-User", c.cfg.User)
+		req.Header.Set("X-ClickHouse-Key", c.cfg.Password)
+	}
+	return send(c.client, req)
+}
+
+func (c *ClickHouse) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// send posts a request, turning a non-2xx response into an error with the
+// start of its body
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,109 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// InfluxDB writes readings to an InfluxDB 2.x bucket in line protocol,
+// tagged by zipcode and station
+type InfluxDB struct {
+	cfg    config.InfluxDBConfig
+	client *http.Client
+}
+
+// NewInfluxDB creates a sink writing to cfg.Bucket
+func NewInfluxDB(cfg config.InfluxDBConfig) *InfluxDB {
+	return &InfluxDB{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (i *InfluxDB) Name() string { return "influxdb" }
+
+// WriteBatch writes one point per reading
+func (i *InfluxDB) WriteBatch(ctx context.Context, metrics []*database.RawMetric) error {
+	var body strings.Builder
+	for _, m := range metrics {
+		if line := lineProtocol(i.cfg.Measurement, m); line != "" {
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	query := url.Values{
+		"org":       {i.cfg.Org},
+		"bucket":    {i.cfg.Bucket},
+		"precision": {"s"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(i.cfg.URL, "/")+"/api/v2/write?"+query.Encode(), strings.NewReader(body.String()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+i.cfg.Token)
+	}
+	return send(i.client, req)
+}
+
+func (i *InfluxDB) Close() error {
+	i.client.CloseIdleConnections()
+	return nil
+}
+
+// lineProtocol formats a reading as one line-protocol point, or "" if it
+// has no measurements
+func lineProtocol(measurement string, m *database.RawMetric) string {
+	fields := map[string]*float64{
+		"temperature":     m.Temperature,
+		"humidity":        m.Humidity,
+		"precipitation":   m.Precipitation,
+		"wind_speed":      m.WindSpeed,
+		"pollution_index": m.PollutionIndex,
+		"pollen_index":    m.PollenIndex,
+		"pressure":        m.Pressure,
+		"visibility":      m.Visibility,
+		"uv_index":        m.UVIndex,
+		"snow_depth":      m.SnowDepth,
+		"heat_index":      m.HeatIndex,
+		"wind_chill":      m.WindChill,
+		"dew_point":       m.DewPoint,
+	}
+	var values []string
+	for name, v := range fields {
+		if v != nil {
+			values = append(values, name+"="+strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	if m.WindDirection != nil {
+		values = append(values, "wind_direction="+strconv.Quote(*m.WindDirection))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	sort.Strings(values)
+
+	tags := escapeKey(measurement) + ",zipcode=" + escapeKey(m.Zipcode)
+	if m.StationID != "" {
+		tags += ",station_id=" + escapeKey(m.StationID)
+	}
+	return fmt.Sprintf("%s %s %d", tags, strings.Join(values, ","), m.Timestamp.Unix())
+}
+
+var keyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// escapeKey escapes a measurement name or tag value
+func escapeKey(s string) string {
+	return keyEscaper.Replace(s)
+}
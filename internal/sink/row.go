@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// row is a reading as the JSON sinks write it, one object per line
+type row struct {
+	Zipcode        string            `json:"zipcode"`
+	StationID      string            `json:"station_id"`
+	Timestamp      time.Time         `json:"timestamp"`
+	Temperature    *float64          `json:"temperature"`
+	Humidity       *float64          `json:"humidity"`
+	Precipitation  *float64          `json:"precipitation"`
+	WindSpeed      *float64          `json:"wind_speed"`
+	WindDirection  *string           `json:"wind_direction"`
+	PollutionIndex *float64          `json:"pollution_index"`
+	PollenIndex    *float64          `json:"pollen_index"`
+	Pressure       *float64          `json:"pressure"`
+	Visibility     *float64          `json:"visibility"`
+	UVIndex        *float64          `json:"uv_index"`
+	SnowDepth      *float64          `json:"snow_depth"`
+	HeatIndex      *float64          `json:"heat_index"`
+	WindChill      *float64          `json:"wind_chill"`
+	DewPoint       *float64          `json:"dew_point"`
+	QualityFlags   map[string]string `json:"quality_flags,omitempty"`
+	ReceivedAt     time.Time         `json:"received_at"`
+}
+
+func newRow(m *database.RawMetric) row {
+	return row{
+		Zipcode:        m.Zipcode,
+		StationID:      m.StationID,
+		Timestamp:      m.Timestamp.UTC(),
+		Temperature:    m.Temperature,
+		Humidity:       m.Humidity,
+		Precipitation:  m.Precipitation,
+		WindSpeed:      m.WindSpeed,
+		WindDirection:  m.WindDirection,
+		PollutionIndex: m.PollutionIndex,
+		PollenIndex:    m.PollenIndex,
+		Pressure:       m.Pressure,
+		Visibility:     m.Visibility,
+		UVIndex:        m.UVIndex,
+		SnowDepth:      m.SnowDepth,
+		HeatIndex:      m.HeatIndex,
+		WindChill:      m.WindChill,
+		DewPoint:       m.DewPoint,
+		QualityFlags:   m.QualityFlags,
+		ReceivedAt:     m.ReceivedAt.UTC(),
+	}
+}
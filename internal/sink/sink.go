@@ -0,0 +1,61 @@
+// Package sink mirrors the readings the DB writer stores into other
+// systems, such as a team's existing time-series database.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+// MetricSink receives each batch of readings after it is written to
+// Postgres
+type MetricSink interface {
+	Name() string
+	WriteBatch(ctx context.Context, metrics []*database.RawMetric) error
+	Close() error
+}
+
+// Postgres is the name of the DB writer's own store in DBWRITER_SINKS.
+// Stations, locations and offsets are tracked there, so it can't be left
+// out; every other sink mirrors it.
+const Postgres = "postgres"
+
+// FromConfig creates the sinks named in cfg.DBWriter.Sinks besides
+// Postgres
+func FromConfig(cfg *config.Config) ([]MetricSink, error) {
+	var sinks []MetricSink
+	hasPostgres := false
+	for _, name := range cfg.DBWriter.Sinks {
+		var s MetricSink
+		switch strings.ToLower(name) {
+		case Postgres:
+			hasPostgres = true
+			continue
+		case "clickhouse":
+			s = NewClickHouse(cfg.ClickHouse)
+		case "influxdb":
+			s = NewInfluxDB(cfg.InfluxDB)
+		case "stdout":
+			s = NewStdout()
+		default:
+			closeAll(sinks)
+			return nil, fmt.Errorf("unknown metric sink: %s", name)
+		}
+		sinks = append(sinks, s)
+	}
+	if !hasPostgres {
+		closeAll(sinks)
+		return nil, fmt.Errorf("metric sinks must include %s", Postgres)
+	}
+	return sinks, nil
+}
+
+func closeAll(sinks []MetricSink) {
+	for _, s := range sinks {
+		s.Close()
+	}
+}
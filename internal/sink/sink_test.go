@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smukkama/weather-server/internal/database"
+	"github.com/smukkama/weather-server/pkg/config"
+)
+
+func testMetric() *database.RawMetric {
+	temp, humidity, dir := 21.5, 40.0, "NW"
+	return &database.RawMetric{
+		Zipcode:       "94105",
+		StationID:     "roof 1",
+		Timestamp:     time.Unix(1700000000, 0),
+		Temperature:   &temp,
+		Humidity:      &humidity,
+		WindDirection: &dir,
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	got := lineProtocol("weather", testMetric())
+	want := `weather,zipcode=94105,station_id=roof\ 1 humidity=40,temperature=21.5,wind_direction="NW" 1700000000`
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if got := lineProtocol("weather", &database.RawMetric{Zipcode: "94105"}); got != "" {
+		t.Errorf("Expected no point for a reading without measurements, got %q", got)
+	}
+}
+
+func TestClickHouse_WriteBatch(t *testing.T) {
+	var query, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	ch := NewClickHouse(config.ClickHouseConfig{URL: srv.URL, Table: "weather.raw_metrics", Timeout: time.Second})
+	if err := ch.WriteBatch(context.Background(), []*database.RawMetric{testMetric(), testMetric()}); err != nil {
+		t.Fatalf("WriteBatch failed: %v", err)
+	}
+	if query != "INSERT INTO weather.raw_metrics FORMAT JSONEachRow" {
+		t.Errorf("Unexpected query %q", query)
+	}
+	if lines := strings.Count(body, "\n"); lines != 2 || !strings.Contains(body, `"station_id":"roof 1"`) {
+		t.Errorf("Expected 2 JSON rows, got %q", body)
+	}
+}
+
+func TestInfluxDB_WriteBatchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("Expected token auth, got %q", r.Header.Get("Authorization"))
+		}
+		http.Error(w, "bucket not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	influx := NewInfluxDB(config.InfluxDBConfig{URL: srv.URL, Bucket: "weather", Token: "secret", Measurement: "weather", Timeout: time.Second})
+	err := influx.WriteBatch(context.Background(), []*database.RawMetric{testMetric()})
+	if err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+}
+
+func TestStdout_WriteBatch(t *testing.T) {
+	var out bytes.Buffer
+	s := &Stdout{out: &out}
+	if err := s.WriteBatch(context.Background(), []*database.RawMetric{testMetric()}); err != nil {
+		t.Fatalf("WriteBatch failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"zipcode":"94105"`) {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	cfg := &config.Config{DBWriter: config.DBWriterConfig{Sinks: []string{"postgres", "stdout"}}}
+	sinks, err := FromConfig(cfg)
+	if err != nil || len(sinks) != 1 || sinks[0].Name() != "stdout" {
+		t.Errorf("Expected a stdout mirror, got %v (%v)", sinks, err)
+	}
+
+	cfg.DBWriter.Sinks = []string{"stdout"}
+	if _, err := FromConfig(cfg); err == nil {
+		t.Error("Expected an error without postgres")
+	}
+	cfg.DBWriter.Sinks = []string{"postgres", "mongodb"}
+	if _, err := FromConfig(cfg); err == nil {
+		t.Error("Expected an error for an unknown sink")
+	}
+}
@@ -0,0 +1,43 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/smukkama/weather-server/internal/database"
+)
+
+// Stdout writes readings as JSON lines, for piping into another tool or
+// debugging
+type Stdout struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewStdout creates a sink writing to standard output
+func NewStdout() *Stdout {
+	return &Stdout{out: os.Stdout}
+}
+
+func (s *Stdout) Name() string { return "stdout" }
+
+// WriteBatch writes one JSON object per reading
+func (s *Stdout) WriteBatch(ctx context.Context, metrics []*database.RawMetric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(s.out)
+	enc := json.NewEncoder(w)
+	for _, m := range metrics {
+		if err := enc.Encode(newRow(m)); err != nil {
+			return fmt.Errorf("failed to encode metric: %w", err)
+		}
+	}
+	return w.Flush()
+}
+
+func (s *Stdout) Close() error { return nil }
@@ -0,0 +1,76 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	base := time.Date(2024, time.March, 15, 10, 2, 30, 0, time.Local) // a Friday
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"5 * * * *", time.Date(2024, time.March, 15, 10, 5, 0, 0, time.Local)},
+		{"5 0 * * *", time.Date(2024, time.March, 16, 0, 5, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2024, time.March, 15, 10, 15, 0, 0, time.Local)},
+		{"0 9-17/4 * * *", time.Date(2024, time.March, 15, 13, 0, 0, 0, time.Local)},
+		{"0 0 * * 1", time.Date(2024, time.March, 18, 0, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2024, time.March, 17, 0, 0, 0, 0, time.Local)},
+		{"0 0 1,20 * *", time.Date(2024, time.March, 20, 0, 0, 0, 0, time.Local)},
+		{"0 0 31 * *", time.Date(2024, time.March, 31, 0, 0, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2024, time.April, 1, 0, 0, 0, 0, time.Local)},
+		// Day of month OR day of week when both are restricted
+		{"0 0 1 * 6", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := r.Next(base); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %s, got %s", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	base := time.Date(2024, time.March, 15, 10, 2, 30, 0, time.UTC)
+
+	r, err := ParseRecurrence("@every 15m")
+	if err != nil {
+		t.Fatalf("ParseRecurrence failed: %v", err)
+	}
+	if got, want := r.Next(base), time.Date(2024, time.March, 15, 10, 15, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if got, want := EveryWithOffset(time.Hour, 5*time.Minute).Next(base), time.Date(2024, time.March, 15, 10, 5, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	r, err = ParseRecurrence("@every 15m offset 5m")
+	if err != nil {
+		t.Fatalf("ParseRecurrence failed: %v", err)
+	}
+	if got, want := r.Next(base), time.Date(2024, time.March, 15, 10, 5, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if _, err := ParseRecurrence("0 * * * * offset 5m"); err == nil {
+		t.Error("Expected error for an offset on a cron expression")
+	}
+	if _, err := ParseRecurrence("-5m"); err == nil {
+		t.Error("Expected error for a negative interval")
+	}
+}
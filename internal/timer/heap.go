@@ -2,16 +2,24 @@ package timer
 
 import (
 	"container/heap"
+	"context"
+	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// defaultQueueSize is how many expired tasks may wait for a free worker
+const defaultQueueSize = 1000
+
 // TimerTask represents a task scheduled for future execution
 type TimerTask struct {
 	ID       string
 	ExpiryAt time.Time
-	Callback func()
-	index    int // index in the heap (for heap.Interface)
+	Callback func(ctx context.Context)
+	Timeout  time.Duration // limit on the callback's context; 0 uses the manager default
+	index    int           // position in the heap, or bucket in the timing wheel
 }
 
 // timerHeap is a min-heap of TimerTasks ordered by ExpiryAt
@@ -46,28 +54,126 @@ func (h *timerHeap) Pop() interface{} {
 	return task
 }
 
-// TimerManager manages scheduled tasks using a min-heap
+// taskQueue orders scheduled tasks for the scheduler loop. Implementations
+// are not safe for concurrent use; the manager calls them under tm.mu.
+type taskQueue interface {
+	// schedule adds a task, reporting whether the scheduler must wake up
+	// because it may now have to run sooner
+	schedule(task *TimerTask) bool
+	unschedule(task *TimerTask)
+	// popExpired removes and returns a task due at now, or nil if none is
+	popExpired(now time.Time) *TimerTask
+	// nextWait returns how long the scheduler may sleep before polling again
+	nextWait(now time.Time) time.Duration
+	size() int
+}
+
+func (h *timerHeap) schedule(task *TimerTask) bool {
+	heap.Push(h, task)
+	return (*h)[0] == task
+}
+
+func (h *timerHeap) unschedule(task *TimerTask) {
+	heap.Remove(h, task.index)
+}
+
+func (h *timerHeap) popExpired(now time.Time) *TimerTask {
+	if h.Len() == 0 || (*h)[0].ExpiryAt.After(now) {
+		return nil
+	}
+	return heap.Pop(h).(*TimerTask)
+}
+
+func (h *timerHeap) nextWait(now time.Time) time.Duration {
+	if h.Len() == 0 {
+		return idleWait
+	}
+	return (*h)[0].ExpiryAt.Sub(now)
+}
+
+func (h *timerHeap) size() int { return h.Len() }
+
+// idleWait is how long the scheduler sleeps with nothing scheduled
+const idleWait = 24 * time.Hour
+
+// TimerManager manages scheduled tasks using a min-heap, or a timing wheel
+// (see NewTimerManagerWithWheel)
 type TimerManager struct {
-	heap     timerHeap
-	mu       sync.Mutex
-	wakeup   chan struct{}
-	tasks    map[string]*TimerTask // for O(1) lookup by ID
-	workers  int
-	workerWg sync.WaitGroup
-	stopped  bool
-	stopCh   chan struct{}
+	queue     taskQueue
+	mu        sync.Mutex
+	wakeup    chan struct{}
+	tasks     map[string]*TimerTask     // for O(1) lookup by ID
+	recurring map[string]*recurringTask // tasks rescheduled after each run
+	workers   int
+	workerWg  sync.WaitGroup
+	stopped   bool
+	stopCh    chan struct{}
+
+	// ctx is the parent of every callback's context and is cancelled by Stop
+	ctx            context.Context
+	cancel         context.CancelFunc
+	defaultTimeout time.Duration
+
+	// Expired tasks waiting for a worker. When it is full the scheduler
+	// waits for room, so at most workers callbacks run at once.
+	taskCh    chan *TimerTask
+	overflows atomic.Uint64 // times an expired task found the queue full
+
+	executed  atomic.Uint64
+	cancelled atomic.Uint64
+	missed    atomic.Uint64 // recurring runs skipped because a run overran
+	late      atomic.Uint64 // tasks started more than lateThreshold after expiry
+	timedOut  atomic.Uint64 // callbacks still running when their timeout expired
+	delay     *histogram    // time from expiry to the callback starting
+	runTime   *histogram    // time the callback ran
+
+	panics  atomic.Uint64
+	onPanic func(taskID string, recovered interface{}, stack []byte)
+
+	// Optional persistence (see persist.go). persistMu orders store writes
+	// with the state changes they record.
+	store     Store
+	handlers  map[string]Handler
+	persisted map[string]*PersistedTask // records of persistent tasks, by ID
+	persistMu sync.Mutex
 }
 
 // NewTimerManager creates a new timer manager with a worker pool
 func NewTimerManager(workers int) *TimerManager {
+	return NewTimerManagerWithQueue(workers, defaultQueueSize)
+}
+
+// NewTimerManagerWithQueue creates a timer manager whose workers take
+// expired tasks from a queue of the given size
+func NewTimerManagerWithQueue(workers, queueSize int) *TimerManager {
+	h := make(timerHeap, 0)
+	heap.Init(&h)
+	return newTimerManager(workers, queueSize, &h)
+}
+
+func newTimerManager(workers, queueSize int, queue taskQueue) *TimerManager {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	tm := &TimerManager{
-		heap:    make(timerHeap, 0),
-		wakeup:  make(chan struct{}, 1),
-		tasks:   make(map[string]*TimerTask),
-		workers: workers,
-		stopCh:  make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+		queue:     queue,
+		wakeup:    make(chan struct{}, 1),
+		tasks:     make(map[string]*TimerTask),
+		recurring: make(map[string]*recurringTask),
+		handlers:  make(map[string]Handler),
+		persisted: make(map[string]*PersistedTask),
+		workers:   workers,
+		stopCh:    make(chan struct{}),
+		taskCh:    make(chan *TimerTask, queueSize),
+		delay:     newHistogram(),
+		runTime:   newHistogram(),
 	}
-	heap.Init(&tm.heap)
 	return tm
 }
 
@@ -83,7 +189,8 @@ func (tm *TimerManager) Start() {
 	go tm.run()
 }
 
-// Stop stops the timer manager gracefully
+// Stop stops the timer manager, cancelling the context of running callbacks
+// and waiting for them to return
 func (tm *TimerManager) Stop() {
 	tm.mu.Lock()
 	if tm.stopped {
@@ -93,23 +200,65 @@ func (tm *TimerManager) Stop() {
 	tm.stopped = true
 	close(tm.stopCh)
 	tm.mu.Unlock()
+	tm.cancel()
 
 	// Wait for workers to finish
 	tm.workerWg.Wait()
 }
 
-// Schedule adds a new task to be executed at the specified time
-func (tm *TimerManager) Schedule(id string, expiryAt time.Time, callback func()) error {
+// SetOnPanic sets a hook called after a callback panics, e.g. to alert or
+// record the failure. The panic is always recovered and logged; the hook runs
+// on the worker goroutine.
+func (tm *TimerManager) SetOnPanic(hook func(taskID string, recovered interface{}, stack []byte)) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.onPanic = hook
+}
+
+// SetDefaultTimeout limits how long callbacks without a timeout of their own
+// may run: their context is cancelled after d. Zero means no limit.
+func (tm *TimerManager) SetDefaultTimeout(d time.Duration) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
+	tm.defaultTimeout = d
+}
+
+// Schedule adds a new task to be executed at the specified time. It
+// replaces any task with the same ID, including a recurring or persistent one.
+// The callback's context is cancelled when the manager stops or the default
+// timeout expires.
+func (tm *TimerManager) Schedule(id string, expiryAt time.Time, callback func(ctx context.Context)) error {
+	return tm.ScheduleWithTimeout(id, expiryAt, 0, callback)
+}
 
+// ScheduleWithTimeout is Schedule with the callback's context cancelled
+// after timeout
+func (tm *TimerManager) ScheduleWithTimeout(id string, expiryAt time.Time, timeout time.Duration, callback func(ctx context.Context)) error {
+	unlock := tm.lockPersistent(id)
+	defer unlock()
+
+	tm.mu.Lock()
 	if tm.stopped {
+		tm.mu.Unlock()
 		return ErrManagerStopped
 	}
 
+	delete(tm.recurring, id)
+	dropped := tm.dropRecordLocked(id)
+	tm.scheduleLocked(id, expiryAt, timeout, callback)
+	tm.mu.Unlock()
+
+	if dropped {
+		tm.deleteRecord(id)
+	}
+	return nil
+}
+
+// scheduleLocked adds or replaces a task. Caller must hold tm.mu.
+func (tm *TimerManager) scheduleLocked(id string, expiryAt time.Time, timeout time.Duration, callback func(ctx context.Context)) {
 	// Remove existing task with same ID if present
 	if existing, ok := tm.tasks[id]; ok {
-		heap.Remove(&tm.heap, existing.index)
+		tm.queue.unschedule(existing)
 		delete(tm.tasks, id)
 	}
 
@@ -117,35 +266,45 @@ func (tm *TimerManager) Schedule(id string, expiryAt time.Time, callback func())
 		ID:       id,
 		ExpiryAt: expiryAt,
 		Callback: callback,
+		Timeout:  timeout,
 	}
 
-	heap.Push(&tm.heap, task)
 	tm.tasks[id] = task
 
-	// Wake up the scheduler if this is the earliest task
-	if tm.heap[0] == task {
+	// Wake up the scheduler if this may be the earliest task
+	if tm.queue.schedule(task) {
 		select {
 		case tm.wakeup <- struct{}{}:
 		default:
 		}
 	}
-
-	return nil
 }
 
-// Cancel removes a scheduled task
+// Cancel removes a scheduled task. A recurring task is not rescheduled
+// again, even if it is running right now; a persistent task is forgotten.
 func (tm *TimerManager) Cancel(id string) bool {
+	unlock := tm.lockPersistent(id)
+	defer unlock()
+
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
+	_, found := tm.recurring[id]
+	delete(tm.recurring, id)
+	dropped := tm.dropRecordLocked(id)
 
-	task, ok := tm.tasks[id]
-	if !ok {
-		return false
+	if task, ok := tm.tasks[id]; ok {
+		tm.queue.unschedule(task)
+		delete(tm.tasks, id)
+		found = true
 	}
+	tm.mu.Unlock()
 
-	heap.Remove(&tm.heap, task.index)
-	delete(tm.tasks, id)
-	return true
+	if found {
+		tm.cancelled.Add(1)
+	}
+	if dropped {
+		tm.deleteRecord(id)
+	}
+	return found
 }
 
 // run is the main scheduler loop
@@ -158,28 +317,20 @@ func (tm *TimerManager) run() {
 			return
 		}
 
-		var waitDuration time.Duration
-		if tm.heap.Len() == 0 {
-			// No tasks, wait indefinitely
-			waitDuration = 24 * time.Hour
-		} else {
-			// Calculate wait time until next task
-			nextTask := tm.heap[0]
-			waitDuration = time.Until(nextTask.ExpiryAt)
-
-			if waitDuration <= 0 {
-				// Task is ready to execute
-				task := heap.Pop(&tm.heap).(*TimerTask)
-				delete(tm.tasks, task.ID)
-
-				// Submit to worker pool (non-blocking)
-				go task.Callback()
-
-				tm.mu.Unlock()
-				continue
+		now := time.Now()
+		if task := tm.queue.popExpired(now); task != nil {
+			// Task is ready to execute
+			delete(tm.tasks, task.ID)
+
+			tm.mu.Unlock()
+			if !tm.submit(task) {
+				return
 			}
+			continue
 		}
 
+		// Wait until the next task may be due
+		waitDuration := tm.queue.nextWait(now)
 		tm.mu.Unlock()
 
 		// Wait for either timeout or wakeup signal
@@ -197,11 +348,86 @@ func (tm *TimerManager) run() {
 	}
 }
 
+// submit hands an expired task to the worker pool. If every worker is busy
+// and the queue is full it waits for room, counting the overflow; it returns
+// false if the manager stopped meanwhile.
+func (tm *TimerManager) submit(task *TimerTask) bool {
+	select {
+	case tm.taskCh <- task:
+		return true
+	default:
+	}
+
+	tm.overflows.Add(1)
+	select {
+	case tm.taskCh <- task:
+		return true
+	case <-tm.stopCh:
+		return false
+	}
+}
+
 // worker processes tasks from the task channel
 func (tm *TimerManager) worker() {
 	defer tm.workerWg.Done()
 
-	<-tm.stopCh
+	for {
+		select {
+		case task := <-tm.taskCh:
+			tm.execute(task)
+		case <-tm.stopCh:
+			return
+		}
+	}
+}
+
+// execute runs a task's callback, recovering a panic so one bad callback
+// cannot take down the worker or the process
+func (tm *TimerManager) execute(task *TimerTask) {
+	tm.mu.Lock()
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = tm.defaultTimeout
+	}
+	tm.mu.Unlock()
+
+	ctx, cancel := tm.ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(tm.ctx, timeout)
+	}
+	defer cancel()
+
+	start := time.Now()
+	delay := start.Sub(task.ExpiryAt)
+	tm.delay.observe(delay)
+	if delay > lateThreshold {
+		tm.late.Add(1)
+	}
+
+	defer func() {
+		tm.runTime.observe(time.Since(start))
+		tm.executed.Add(1)
+		if ctx.Err() == context.DeadlineExceeded {
+			tm.timedOut.Add(1)
+		}
+
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		stack := debug.Stack()
+		tm.panics.Add(1)
+		fmt.Printf("Timer task %s panicked: %v\n%s", task.ID, recovered, stack)
+
+		tm.mu.Lock()
+		hook := tm.onPanic
+		tm.mu.Unlock()
+		if hook != nil {
+			hook(task.ID, recovered, stack)
+		}
+	}()
+
+	task.Callback(ctx)
 }
 
 // Stats returns statistics about the timer manager
@@ -212,6 +438,17 @@ func (tm *TimerManager) Stats() TimerStats {
 	return TimerStats{
 		ScheduledTasks: len(tm.tasks),
 		Workers:        tm.workers,
+		QueuedTasks:    len(tm.taskCh),
+		QueueCapacity:  cap(tm.taskCh),
+		Overflows:      tm.overflows.Load(),
+		Panics:         tm.panics.Load(),
+		Executed:       tm.executed.Load(),
+		Cancelled:      tm.cancelled.Load(),
+		Missed:         tm.missed.Load(),
+		Late:           tm.late.Load(),
+		TimedOut:       tm.timedOut.Load(),
+		StartDelay:     tm.delay.snapshot(),
+		RunTime:        tm.runTime.snapshot(),
 	}
 }
 
@@ -219,6 +456,17 @@ func (tm *TimerManager) Stats() TimerStats {
 type TimerStats struct {
 	ScheduledTasks int
 	Workers        int
+	QueuedTasks    int // expired tasks waiting for a worker
+	QueueCapacity  int
+	Overflows      uint64 // times the queue was full and the scheduler had to wait
+	Panics         uint64 // callbacks that panicked
+	Executed       uint64
+	Cancelled      uint64
+	Missed         uint64           // recurring runs skipped because an earlier run overran
+	Late           uint64           // tasks that started more than a second after expiry
+	TimedOut       uint64           // callbacks still running when their timeout expired
+	StartDelay     LatencyHistogram // time from expiry to the callback starting
+	RunTime        LatencyHistogram // time callbacks took to run
 }
 
 var (
@@ -1,6 +1,8 @@
 package timer
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -14,7 +16,7 @@ func TestTimerManager_Schedule(t *testing.T) {
 	executed := false
 	var mu sync.Mutex
 
-	err := tm.Schedule("test1", time.Now().Add(100*time.Millisecond), func() {
+	err := tm.Schedule("test1", time.Now().Add(100*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		executed = true
 		mu.Unlock()
@@ -41,7 +43,7 @@ func TestTimerManager_Cancel(t *testing.T) {
 	executed := false
 	var mu sync.Mutex
 
-	err := tm.Schedule("test1", time.Now().Add(100*time.Millisecond), func() {
+	err := tm.Schedule("test1", time.Now().Add(100*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		executed = true
 		mu.Unlock()
@@ -75,19 +77,19 @@ func TestTimerManager_MultipleTasksOrdering(t *testing.T) {
 	var mu sync.Mutex
 
 	// Schedule tasks in reverse order
-	tm.Schedule("task3", time.Now().Add(150*time.Millisecond), func() {
+	tm.Schedule("task3", time.Now().Add(150*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		results = append(results, 3)
 		mu.Unlock()
 	})
 
-	tm.Schedule("task1", time.Now().Add(50*time.Millisecond), func() {
+	tm.Schedule("task1", time.Now().Add(50*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		results = append(results, 1)
 		mu.Unlock()
 	})
 
-	tm.Schedule("task2", time.Now().Add(100*time.Millisecond), func() {
+	tm.Schedule("task2", time.Now().Add(100*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		results = append(results, 2)
 		mu.Unlock()
@@ -114,14 +116,14 @@ func TestTimerManager_RescheduleExisting(t *testing.T) {
 	var mu sync.Mutex
 
 	// Schedule a task
-	tm.Schedule("test1", time.Now().Add(100*time.Millisecond), func() {
+	tm.Schedule("test1", time.Now().Add(100*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		count++
 		mu.Unlock()
 	})
 
 	// Reschedule with same ID (should replace)
-	tm.Schedule("test1", time.Now().Add(50*time.Millisecond), func() {
+	tm.Schedule("test1", time.Now().Add(50*time.Millisecond), func(context.Context) {
 		mu.Lock()
 		count += 10
 		mu.Unlock()
@@ -142,9 +144,9 @@ func TestTimerManager_Stats(t *testing.T) {
 	defer tm.Stop()
 
 	// Schedule some tasks
-	tm.Schedule("task1", time.Now().Add(1*time.Hour), func() {})
-	tm.Schedule("task2", time.Now().Add(2*time.Hour), func() {})
-	tm.Schedule("task3", time.Now().Add(3*time.Hour), func() {})
+	tm.Schedule("task1", time.Now().Add(1*time.Hour), func(context.Context) {})
+	tm.Schedule("task2", time.Now().Add(2*time.Hour), func(context.Context) {})
+	tm.Schedule("task3", time.Now().Add(3*time.Hour), func(context.Context) {})
 
 	stats := tm.Stats()
 	if stats.ScheduledTasks != 3 {
@@ -154,3 +156,219 @@ func TestTimerManager_Stats(t *testing.T) {
 		t.Errorf("Expected 5 workers, got %d", stats.Workers)
 	}
 }
+
+func TestTimerManager_ListTasksAndCounters(t *testing.T) {
+	tm := NewTimerManager(1)
+	tm.Start()
+	defer tm.Stop()
+
+	now := time.Now()
+	tm.Schedule("later", now.Add(2*time.Hour), func(context.Context) {})
+	tm.Schedule("sooner", now.Add(1*time.Hour), func(context.Context) {})
+	tm.Schedule("cancelme", now.Add(3*time.Hour), func(context.Context) {})
+
+	tasks := tm.ListTasks()
+	if len(tasks) != 3 || tasks[0].ID != "sooner" || tasks[1].ID != "later" {
+		t.Fatalf("Unexpected task list %+v", tasks)
+	}
+
+	tm.Cancel("cancelme")
+
+	done := make(chan struct{})
+	tm.Schedule("now", time.Now(), func(context.Context) { close(done) })
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Task did not run")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	stats := tm.Stats()
+	if stats.Executed != 1 || stats.Cancelled != 1 {
+		t.Errorf("Expected 1 executed and 1 cancelled, got %d and %d", stats.Executed, stats.Cancelled)
+	}
+	if stats.StartDelay.Count != 1 || stats.RunTime.Count != 1 {
+		t.Errorf("Expected one latency sample each, got %d and %d", stats.StartDelay.Count, stats.RunTime.Count)
+	}
+}
+
+func TestLatencyHistogram_Percentile(t *testing.T) {
+	h := newHistogram()
+	for i := 0; i < 99; i++ {
+		h.observe(2 * time.Millisecond)
+	}
+	h.observe(time.Minute)
+
+	snap := h.snapshot()
+	if got := snap.Percentile(50); got != 5*time.Millisecond {
+		t.Errorf("Expected p50 of 5ms, got %v", got)
+	}
+	if got := snap.Percentile(100); got != 10*time.Second {
+		t.Errorf("Expected p100 to report the largest bound, got %v", got)
+	}
+}
+
+func TestTimerManager_BoundedWorkers(t *testing.T) {
+	tm := NewTimerManagerWithQueue(2, 1)
+	tm.Start()
+	defer tm.Stop()
+
+	var mu sync.Mutex
+	running, peak, done := 0, 0, 0
+	release := make(chan struct{})
+
+	for i := 0; i < 6; i++ {
+		tm.Schedule(fmt.Sprintf("task%d", i), time.Now(), func(context.Context) {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			<-release
+
+			mu.Lock()
+			running--
+			done++
+			mu.Unlock()
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if stats := tm.Stats(); stats.Overflows == 0 {
+		t.Error("Expected the full queue to be counted as an overflow")
+	}
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > 2 {
+		t.Errorf("Expected at most 2 callbacks at once, got %d", peak)
+	}
+	if done != 6 {
+		t.Errorf("Expected all 6 tasks to run, got %d", done)
+	}
+}
+
+func TestTimerManager_PanicRecovery(t *testing.T) {
+	tm := NewTimerManager(1)
+	panicked := make(chan string, 1)
+	tm.SetOnPanic(func(taskID string, recovered interface{}, stack []byte) {
+		panicked <- taskID
+	})
+	tm.Start()
+	defer tm.Stop()
+
+	tm.Schedule("bad", time.Now(), func(context.Context) { panic("boom") })
+
+	select {
+	case id := <-panicked:
+		if id != "bad" {
+			t.Errorf("Expected hook for task bad, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnPanic hook was not called")
+	}
+
+	// The single worker survives and keeps running tasks
+	ran := make(chan struct{})
+	tm.Schedule("good", time.Now(), func(context.Context) { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("Worker did not survive the panic")
+	}
+	if stats := tm.Stats(); stats.Panics != 1 {
+		t.Errorf("Expected 1 panic, got %d", stats.Panics)
+	}
+}
+
+func TestTimerManager_ScheduleRecurring(t *testing.T) {
+	tm := NewTimerManager(2)
+	tm.Start()
+	defer tm.Stop()
+
+	var mu sync.Mutex
+	runs := 0
+	_, err := tm.ScheduleRecurring("tick", Every(50*time.Millisecond), func(context.Context) {
+		mu.Lock()
+		runs++
+		n := runs
+		mu.Unlock()
+		if n == 1 {
+			panic("first run fails") // must not stop later runs
+		}
+	})
+	if err != nil {
+		t.Fatalf("ScheduleRecurring failed: %v", err)
+	}
+
+	time.Sleep(280 * time.Millisecond)
+	if !tm.Cancel("tick") {
+		t.Error("Expected Cancel to find the recurring task")
+	}
+
+	mu.Lock()
+	got := runs
+	mu.Unlock()
+	if got < 3 {
+		t.Errorf("Expected at least 3 runs, got %d", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if runs != got {
+		t.Errorf("Expected no runs after Cancel, got %d more", runs-got)
+	}
+}
+
+func TestTimerManager_CallbackTimeout(t *testing.T) {
+	tm := NewTimerManager(1)
+	tm.Start()
+	defer tm.Stop()
+
+	done := make(chan error, 1)
+	tm.ScheduleWithTimeout("slow", time.Now(), 50*time.Millisecond, func(ctx context.Context) {
+		<-ctx.Done()
+		done <- ctx.Err()
+	})
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected deadline exceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Callback context was not cancelled")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := tm.Stats().TimedOut; got != 1 {
+		t.Errorf("Expected 1 timed out callback, got %d", got)
+	}
+}
+
+func TestTimerManager_StopCancelsCallbacks(t *testing.T) {
+	tm := NewTimerManager(1)
+	tm.Start()
+
+	started := make(chan struct{})
+	tm.Schedule("long", time.Now(), func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		tm.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not cancel the running callback")
+	}
+}
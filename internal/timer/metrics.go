@@ -0,0 +1,129 @@
+package timer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// lateThreshold is how long after its expiry a task may start before it
+// counts as late
+const lateThreshold = time.Second
+
+// latencyBounds are the upper bounds of the latency histogram buckets; a
+// final bucket holds everything slower
+var latencyBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// TaskInfo describes a scheduled task
+type TaskInfo struct {
+	ID         string
+	ExpiryAt   time.Time
+	Recurring  bool
+	Persistent bool
+}
+
+// ListTasks returns the scheduled tasks, soonest first. Tasks whose
+// callback is running right now are not included.
+func (tm *TimerManager) ListTasks() []TaskInfo {
+	tm.mu.Lock()
+	tasks := make([]TaskInfo, 0, len(tm.tasks))
+	for id, task := range tm.tasks {
+		_, recurring := tm.recurring[id]
+		_, persistent := tm.persisted[id]
+		tasks = append(tasks, TaskInfo{
+			ID:         id,
+			ExpiryAt:   task.ExpiryAt,
+			Recurring:  recurring,
+			Persistent: persistent,
+		})
+	}
+	tm.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].ExpiryAt.Equal(tasks[j].ExpiryAt) {
+			return tasks[i].ExpiryAt.Before(tasks[j].ExpiryAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
+// LatencyHistogram is a snapshot of latencies counted in fixed buckets.
+// Counts[i] holds latencies up to Bounds[i]; the last count holds the rest.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Mean returns the average latency
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th
+// percentile (0-100). For the slowest bucket it returns the largest bound.
+func (h LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 || len(h.Bounds) == 0 {
+		return 0
+	}
+	rank := uint64(float64(h.Count) * p / 100)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen >= rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// histogram accumulates latencies for a LatencyHistogram
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBounds), func(i int) bool { return d <= latencyBounds[i] })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) snapshot() LatencyHistogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return LatencyHistogram{
+		Bounds: latencyBounds,
+		Counts: append([]uint64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+}
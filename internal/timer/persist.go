@@ -0,0 +1,286 @@
+package timer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PersistedTask is the stored form of a persistent task. Callbacks can't be
+// stored, so a task names its type and carries a payload, and a Handler
+// registered for the type runs it.
+type PersistedTask struct {
+	ID         string          `json:"id"`
+	ExpiryAt   time.Time       `json:"expiry_at"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Recurrence string          `json:"recurrence,omitempty"` // spec for ParseRecurrence, if recurring
+}
+
+// Handler runs a persistent task of one type. ctx is cancelled when the
+// manager stops or the default timeout expires.
+type Handler func(ctx context.Context, taskID string, payload json.RawMessage)
+
+// Store keeps persistent tasks across restarts
+type Store interface {
+	Save(ctx context.Context, task *PersistedTask) error
+	Delete(ctx context.Context, id string) error
+	Load(ctx context.Context) ([]*PersistedTask, error)
+}
+
+// storeTimeout bounds each store operation
+const storeTimeout = 5 * time.Second
+
+// SetStore enables persistent tasks. Call before scheduling any.
+func (tm *TimerManager) SetStore(store Store) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.store = store
+}
+
+// RegisterHandler sets the handler that runs persistent tasks of a type,
+// both newly scheduled and restored ones
+func (tm *TimerManager) RegisterHandler(taskType string, handler Handler) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.handlers[taskType] = handler
+}
+
+// SchedulePersistent schedules a one-off task that survives restarts: it is
+// saved to the store and removed from it once it has run
+func (tm *TimerManager) SchedulePersistent(id string, expiryAt time.Time, taskType string, payload json.RawMessage) error {
+	record := &PersistedTask{ID: id, ExpiryAt: expiryAt, Type: taskType, Payload: payload}
+	return tm.schedulePersistent(record, nil)
+}
+
+// ScheduleRecurringPersistent schedules a recurring task (see
+// ScheduleRecurring) whose next run survives restarts. If a restored run of
+// the task is overdue, it still runs first, so a run missed while the
+// process was down is caught up.
+func (tm *TimerManager) ScheduleRecurringPersistent(id, spec, taskType string, payload json.RawMessage) (time.Time, error) {
+	recurrence, err := ParseRecurrence(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := recurrence.Next(time.Now())
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("recurrence for %s never runs", id)
+	}
+
+	tm.mu.Lock()
+	if existing, ok := tm.tasks[id]; ok && tm.persisted[id] != nil && existing.ExpiryAt.Before(next) {
+		next = existing.ExpiryAt
+	}
+	tm.mu.Unlock()
+
+	record := &PersistedTask{ID: id, ExpiryAt: next, Type: taskType, Payload: payload, Recurrence: spec}
+	return next, tm.schedulePersistent(record, recurrence)
+}
+
+// Restore re-arms the tasks in the store; overdue ones run as soon as the
+// manager is started. Tasks whose type has no registered handler are left
+// in the store and skipped.
+func (tm *TimerManager) Restore(ctx context.Context) (int, error) {
+	tm.persistMu.Lock()
+	defer tm.persistMu.Unlock()
+
+	if tm.store == nil {
+		return 0, nil
+	}
+	records, err := tm.store.Load(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load timers: %w", err)
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	restored := 0
+	for _, record := range records {
+		if _, ok := tm.handlers[record.Type]; !ok {
+			fmt.Printf("No handler for persisted timer %s (type %s), skipping\n", record.ID, record.Type)
+			continue
+		}
+		if err := tm.armLocked(record, nil); err != nil {
+			fmt.Printf("Failed to restore timer %s: %v\n", record.ID, err)
+			continue
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// schedulePersistent saves and arms a persistent task. recurrence is the
+// parsed record.Recurrence, if any.
+func (tm *TimerManager) schedulePersistent(record *PersistedTask, recurrence Recurrence) error {
+	tm.persistMu.Lock()
+	defer tm.persistMu.Unlock()
+
+	tm.mu.Lock()
+	store, stopped := tm.store, tm.stopped
+	tm.mu.Unlock()
+	if stopped {
+		return ErrManagerStopped
+	}
+	if store == nil {
+		return fmt.Errorf("no timer store configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := store.Save(ctx, record); err != nil {
+		return fmt.Errorf("failed to save timer %s: %w", record.ID, err)
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.armLocked(record, recurrence)
+}
+
+// armLocked schedules a persistent task from its record. Caller must hold
+// tm.persistMu and tm.mu.
+func (tm *TimerManager) armLocked(record *PersistedTask, recurrence Recurrence) error {
+	run := func(ctx context.Context) { tm.runHandler(ctx, record.ID, record.Type, record.Payload) }
+
+	if record.Recurrence != "" {
+		if recurrence == nil {
+			var err error
+			if recurrence, err = ParseRecurrence(record.Recurrence); err != nil {
+				return err
+			}
+		}
+		tm.persisted[record.ID] = record
+		task := &recurringTask{recurrence: recurrence, callback: run, persistent: true}
+		tm.scheduleRecurringLocked(record.ID, task, record.ExpiryAt)
+		return nil
+	}
+
+	delete(tm.recurring, record.ID)
+	tm.persisted[record.ID] = record
+	tm.scheduleLocked(record.ID, record.ExpiryAt, 0, func(ctx context.Context) {
+		defer tm.finishPersistent(record)
+		run(ctx)
+	})
+	return nil
+}
+
+// runHandler runs a persistent task with the handler for its type
+func (tm *TimerManager) runHandler(ctx context.Context, id, taskType string, payload json.RawMessage) {
+	tm.mu.Lock()
+	handler := tm.handlers[taskType]
+	tm.mu.Unlock()
+
+	if handler == nil {
+		fmt.Printf("No handler for timer %s (type %s)\n", id, taskType)
+		return
+	}
+	handler(ctx, id, payload)
+}
+
+// finishPersistent removes a one-off task's record once it has run, unless
+// the task was rescheduled meanwhile
+func (tm *TimerManager) finishPersistent(record *PersistedTask) {
+	tm.persistMu.Lock()
+	defer tm.persistMu.Unlock()
+
+	tm.mu.Lock()
+	current := tm.persisted[record.ID] == record
+	if current {
+		delete(tm.persisted, record.ID)
+	}
+	tm.mu.Unlock()
+
+	if current {
+		tm.deleteRecord(record.ID)
+	}
+}
+
+// lockPersistent takes tm.persistMu if id is a persistent task, so the
+// store stays in step with the change about to be made, and returns the
+// matching unlock
+func (tm *TimerManager) lockPersistent(id string) func() {
+	tm.mu.Lock()
+	_, persistent := tm.persisted[id]
+	tm.mu.Unlock()
+
+	if !persistent {
+		return func() {}
+	}
+	tm.persistMu.Lock()
+	return tm.persistMu.Unlock
+}
+
+// dropRecordLocked forgets a persistent task's record, reporting whether
+// there was one to delete from the store. Caller must hold tm.mu.
+func (tm *TimerManager) dropRecordLocked(id string) bool {
+	if _, ok := tm.persisted[id]; !ok {
+		return false
+	}
+	delete(tm.persisted, id)
+	return true
+}
+
+func (tm *TimerManager) saveRecord(record *PersistedTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := tm.store.Save(ctx, record); err != nil {
+		fmt.Printf("Failed to save timer %s: %v\n", record.ID, err)
+	}
+}
+
+func (tm *TimerManager) deleteRecord(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := tm.store.Delete(ctx, id); err != nil {
+		fmt.Printf("Failed to delete timer %s: %v\n", id, err)
+	}
+}
+
+// RedisStore keeps persistent tasks in a Redis hash, one field per task ID
+type RedisStore struct {
+	redis *redis.Client
+	key   string
+}
+
+// NewRedisStore creates a store in the given hash key, e.g.
+// "weather:timers:aggregator"; use one key per service
+func NewRedisStore(client *redis.Client, key string) *RedisStore {
+	return &RedisStore{redis: client, key: key}
+}
+
+// Save stores or replaces a task
+func (s *RedisStore) Save(ctx context.Context, task *PersistedTask) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return s.redis.HSet(ctx, s.key, task.ID, data).Err()
+}
+
+// Delete removes a task
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return s.redis.HDel(ctx, s.key, id).Err()
+}
+
+// Load returns every stored task
+func (s *RedisStore) Load(ctx context.Context) ([]*PersistedTask, error) {
+	fields, err := s.redis.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*PersistedTask, 0, len(fields))
+	for id, data := range fields {
+		var task PersistedTask
+		if err := json.Unmarshal([]byte(data), &task); err != nil {
+			fmt.Printf("Skipping unreadable timer %s: %v\n", id, err)
+			continue
+		}
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
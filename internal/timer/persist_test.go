@@ -0,0 +1,115 @@
+package timer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is an in-memory Store for tests
+type memoryStore struct {
+	mu    sync.Mutex
+	tasks map[string]PersistedTask
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{tasks: make(map[string]PersistedTask)}
+}
+
+func (s *memoryStore) Save(ctx context.Context, task *PersistedTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[task.ID] = *task
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tasks, id)
+	return nil
+}
+
+func (s *memoryStore) Load(ctx context.Context) ([]*PersistedTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []*PersistedTask
+	for _, task := range s.tasks {
+		task := task
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+func (s *memoryStore) has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tasks[id]
+	return ok
+}
+
+func TestTimerManager_RestorePersistent(t *testing.T) {
+	store := newMemoryStore()
+
+	// Schedule with one manager and "restart" before the task runs
+	first := NewTimerManager(1)
+	first.SetStore(store)
+	first.RegisterHandler("greet", func(context.Context, string, json.RawMessage) {})
+	if err := first.SchedulePersistent("task1", time.Now().Add(50*time.Millisecond), "greet", json.RawMessage(`"hello"`)); err != nil {
+		t.Fatalf("SchedulePersistent failed: %v", err)
+	}
+	if !store.has("task1") {
+		t.Fatal("Expected task to be saved")
+	}
+
+	second := NewTimerManager(1)
+	second.SetStore(store)
+	got := make(chan string, 1)
+	second.RegisterHandler("greet", func(ctx context.Context, taskID string, payload json.RawMessage) {
+		got <- taskID + ":" + string(payload)
+	})
+
+	restored, err := second.Restore(context.Background())
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored != 1 {
+		t.Fatalf("Expected 1 restored task, got %d", restored)
+	}
+
+	second.Start()
+	defer second.Stop()
+
+	select {
+	case v := <-got:
+		if v != `task1:"hello"` {
+			t.Errorf("Unexpected handler call %s", v)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Restored task did not run")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if store.has("task1") {
+		t.Error("Expected record to be deleted after running")
+	}
+}
+
+func TestTimerManager_CancelPersistent(t *testing.T) {
+	store := newMemoryStore()
+	tm := NewTimerManager(1)
+	tm.SetStore(store)
+	tm.RegisterHandler("noop", func(context.Context, string, json.RawMessage) {})
+
+	if err := tm.SchedulePersistent("task1", time.Now().Add(time.Hour), "noop", nil); err != nil {
+		t.Fatalf("SchedulePersistent failed: %v", err)
+	}
+	if !tm.Cancel("task1") {
+		t.Fatal("Expected Cancel to find the task")
+	}
+	if store.has("task1") {
+		t.Error("Expected record to be deleted on cancel")
+	}
+}
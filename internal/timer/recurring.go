@@ -0,0 +1,171 @@
+package timer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recurrence computes the run times of a recurring task
+type Recurrence interface {
+	// Next returns the first run time strictly after t, or the zero time if
+	// there is none
+	Next(t time.Time) time.Time
+}
+
+// interval runs every period, aligned to multiples of the period (since the
+// zero time) plus an offset
+type interval struct {
+	period time.Duration
+	offset time.Duration
+}
+
+// Every returns a recurrence running at every multiple of period, e.g.
+// Every(time.Hour) runs on the hour
+func Every(period time.Duration) Recurrence {
+	return EveryWithOffset(period, 0)
+}
+
+// EveryWithOffset returns a recurrence running offset after every multiple
+// of period, e.g. EveryWithOffset(time.Hour, 5*time.Minute) runs at HH:05
+func EveryWithOffset(period, offset time.Duration) Recurrence {
+	return interval{period: period, offset: offset}
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(-i.offset).Truncate(i.period).Add(i.period).Add(i.offset)
+}
+
+// ParseRecurrence parses "@every <duration>", optionally followed by
+// "offset <duration>" (EveryWithOffset), a bare duration, a cron macro such
+// as "@hourly", or a five-field cron expression
+func ParseRecurrence(spec string) (Recurrence, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		spec = strings.TrimSpace(rest)
+	}
+	var offset time.Duration
+	if every, after, ok := strings.Cut(spec, " offset "); ok {
+		var err error
+		if offset, err = time.ParseDuration(strings.TrimSpace(after)); err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid recurrence %q: invalid offset", spec)
+		}
+		spec = strings.TrimSpace(every)
+		if _, err := time.ParseDuration(spec); err != nil {
+			return nil, fmt.Errorf("invalid recurrence %q: an offset needs an interval", spec)
+		}
+	}
+	if period, err := time.ParseDuration(spec); err == nil {
+		if period <= 0 {
+			return nil, fmt.Errorf("invalid recurrence %q: interval must be positive", spec)
+		}
+		return EveryWithOffset(period, offset), nil
+	}
+	return ParseCron(spec)
+}
+
+// recurringTask is a task that reschedules itself after each run
+type recurringTask struct {
+	recurrence Recurrence
+	callback   func(ctx context.Context)
+	timeout    time.Duration
+	persistent bool // its next run is kept in the store
+}
+
+// ScheduleRecurring runs callback at every time of the recurrence until
+// the task is cancelled or replaced. The next run is computed from the
+// scheduled time, not from when the callback finished, so runs don't drift;
+// runs missed while a callback was slow are skipped rather than bunched up.
+func (tm *TimerManager) ScheduleRecurring(id string, recurrence Recurrence, callback func(ctx context.Context)) (time.Time, error) {
+	return tm.ScheduleRecurringWithTimeout(id, recurrence, 0, callback)
+}
+
+// ScheduleRecurringWithTimeout is ScheduleRecurring with each run's context
+// cancelled after timeout
+func (tm *TimerManager) ScheduleRecurringWithTimeout(id string, recurrence Recurrence, timeout time.Duration, callback func(ctx context.Context)) (time.Time, error) {
+	unlock := tm.lockPersistent(id)
+	defer unlock()
+
+	tm.mu.Lock()
+	if tm.stopped {
+		tm.mu.Unlock()
+		return time.Time{}, ErrManagerStopped
+	}
+
+	next := recurrence.Next(time.Now())
+	if next.IsZero() {
+		tm.mu.Unlock()
+		return time.Time{}, fmt.Errorf("recurrence for %s never runs", id)
+	}
+
+	dropped := tm.dropRecordLocked(id)
+	tm.scheduleRecurringLocked(id, &recurringTask{recurrence: recurrence, callback: callback, timeout: timeout}, next)
+	tm.mu.Unlock()
+
+	if dropped {
+		tm.deleteRecord(id)
+	}
+	return next, nil
+}
+
+// scheduleRecurringLocked arms a recurring task's first run. Caller must
+// hold tm.mu.
+func (tm *TimerManager) scheduleRecurringLocked(id string, task *recurringTask, first time.Time) {
+	tm.recurring[id] = task
+	tm.scheduleLocked(id, first, task.timeout, tm.recurringCallback(id, task, first))
+}
+
+// recurringCallback runs one occurrence of a recurring task and then arms
+// the next, even if the callback panics
+func (tm *TimerManager) recurringCallback(id string, task *recurringTask, at time.Time) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		defer tm.rearm(id, task, at)
+		task.callback(ctx)
+	}
+}
+
+// rearm schedules the occurrence after at, unless the task was cancelled or
+// replaced meanwhile
+func (tm *TimerManager) rearm(id string, task *recurringTask, at time.Time) {
+	if task.persistent {
+		tm.persistMu.Lock()
+		defer tm.persistMu.Unlock()
+	}
+
+	tm.mu.Lock()
+	if tm.stopped || tm.recurring[id] != task {
+		tm.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	next := task.recurrence.Next(at)
+	for !next.IsZero() && !next.After(now) {
+		next = task.recurrence.Next(next)
+		tm.missed.Add(1)
+	}
+	if next.IsZero() {
+		delete(tm.recurring, id)
+		dropped := tm.dropRecordLocked(id)
+		tm.mu.Unlock()
+		if dropped {
+			tm.deleteRecord(id)
+		}
+		return
+	}
+
+	tm.scheduleLocked(id, next, task.timeout, tm.recurringCallback(id, task, next))
+	var record *PersistedTask
+	if current, ok := tm.persisted[id]; ok {
+		updated := *current
+		updated.ExpiryAt = next
+		tm.persisted[id] = &updated
+		record = &updated
+	}
+	tm.mu.Unlock()
+
+	if record != nil {
+		tm.saveRecord(record)
+	}
+}
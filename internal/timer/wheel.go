@@ -0,0 +1,160 @@
+package timer
+
+import "time"
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits // slots per level
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 6 // 64^6 ticks: about 218 years at a 100ms tick
+
+	// readyBucket holds tasks already due, after the wheel's slots
+	readyBucket = wheelLevels * wheelSlots
+)
+
+// timingWheel is a hierarchical timing wheel. Level 0 has a slot per tick;
+// each higher level has a slot per full turn of the level below, and its
+// tasks cascade down a level when the wheel reaches their slot. Scheduling
+// and cancelling are O(1) however many tasks there are, at the cost of
+// expiries being rounded up to a whole tick, so it suits large numbers of
+// coarse timers such as connection inactivity timeouts.
+type timingWheel struct {
+	tick    time.Duration
+	cur     int64                                    // next tick to process
+	buckets [readyBucket + 1]map[*TimerTask]struct{} // level*wheelSlots+slot, then ready
+	count   int
+}
+
+func newTimingWheel(tick time.Duration) *timingWheel {
+	if tick <= 0 {
+		tick = 100 * time.Millisecond
+	}
+	w := &timingWheel{tick: tick}
+	w.cur = w.tickOf(time.Now())
+	for i := range w.buckets {
+		w.buckets[i] = make(map[*TimerTask]struct{})
+	}
+	return w
+}
+
+// NewTimerManagerWithWheel creates a timer manager backed by a timing wheel
+// with the given tick instead of a heap. Tasks run up to one tick late.
+func NewTimerManagerWithWheel(workers, queueSize int, tick time.Duration) *TimerManager {
+	return newTimerManager(workers, queueSize, newTimingWheel(tick))
+}
+
+// tickOf returns the tick containing t
+func (w *timingWheel) tickOf(t time.Time) int64 {
+	return t.UnixNano() / int64(w.tick)
+}
+
+func (w *timingWheel) schedule(task *TimerTask) bool {
+	wasEmpty := w.count == 0
+	if wasEmpty {
+		// Nothing advanced the wheel while it was empty
+		w.cur = w.tickOf(time.Now())
+	}
+	w.count++
+	bucket := w.place(task)
+	return wasEmpty || bucket < wheelSlots || bucket == readyBucket
+}
+
+// place puts a task in the bucket for its expiry and returns the bucket
+func (w *timingWheel) place(task *TimerTask) int {
+	// Round up so a task never runs before its expiry
+	expTick := (task.ExpiryAt.UnixNano() + int64(w.tick) - 1) / int64(w.tick)
+
+	bucket := readyBucket
+	if delta := expTick - w.cur; delta >= 0 {
+		level := 0
+		for level < wheelLevels-1 && delta >= int64(1)<<(wheelBits*(level+1)) {
+			level++
+		}
+		if limit := int64(1) << (wheelBits * wheelLevels); delta >= limit {
+			expTick = w.cur + limit - 1 // re-placed when its slot cascades
+		}
+		bucket = level*wheelSlots + int((expTick>>(wheelBits*level))&wheelMask)
+	}
+
+	task.index = bucket
+	w.buckets[bucket][task] = struct{}{}
+	return bucket
+}
+
+func (w *timingWheel) unschedule(task *TimerTask) {
+	delete(w.buckets[task.index], task)
+	task.index = -1
+	w.count--
+}
+
+func (w *timingWheel) popExpired(now time.Time) *TimerTask {
+	w.advance(w.tickOf(now))
+	for task := range w.buckets[readyBucket] {
+		w.unschedule(task)
+		return task
+	}
+	return nil
+}
+
+// advance processes every tick up to and including nowTick, moving due
+// tasks to the ready bucket
+func (w *timingWheel) advance(nowTick int64) {
+	if w.count == 0 {
+		w.cur = nowTick + 1
+		return
+	}
+	for ; w.cur <= nowTick; w.cur++ {
+		// Cascade higher levels whose slot starts at this tick, highest
+		// first so tasks can fall through several levels
+		for level := wheelLevels - 1; level > 0; level-- {
+			if w.cur&(int64(1)<<(wheelBits*level)-1) == 0 {
+				w.cascade(level*wheelSlots + int((w.cur>>(wheelBits*level))&wheelMask))
+			}
+		}
+		w.cascade(int(w.cur & wheelMask))
+	}
+}
+
+// cascade re-places the tasks of a bucket relative to the current tick
+func (w *timingWheel) cascade(bucket int) {
+	tasks := w.buckets[bucket]
+	if len(tasks) == 0 {
+		return
+	}
+	w.buckets[bucket] = make(map[*TimerTask]struct{})
+	for task := range tasks {
+		if bucket < wheelSlots {
+			// Level 0 slots hold only tasks due at this tick
+			task.index = readyBucket
+			w.buckets[readyBucket][task] = struct{}{}
+			continue
+		}
+		w.place(task)
+	}
+}
+
+func (w *timingWheel) nextWait(now time.Time) time.Duration {
+	if w.count == 0 {
+		return idleWait
+	}
+	if len(w.buckets[readyBucket]) > 0 {
+		return 0
+	}
+
+	// Sleep until the next occupied level 0 slot, or until the next level 1
+	// cascade, whichever comes first
+	next := (w.cur + wheelMask) &^ wheelMask
+	for t := w.cur; t < next; t++ {
+		if len(w.buckets[t&wheelMask]) > 0 {
+			next = t
+			break
+		}
+	}
+	wait := time.Unix(0, next*int64(w.tick)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (w *timingWheel) size() int { return w.count }
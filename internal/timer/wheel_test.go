@@ -0,0 +1,90 @@
+package timer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel_ExpiresAcrossLevels(t *testing.T) {
+	tick := 10 * time.Millisecond
+	w := newTimingWheel(tick)
+	base := time.Now()
+
+	offsets := []time.Duration{50 * time.Millisecond, 7 * time.Second, 10 * time.Minute, 3 * time.Hour}
+	for i, offset := range offsets {
+		w.schedule(&TimerTask{ID: fmt.Sprintf("task%d", i), ExpiryAt: base.Add(offset)})
+	}
+
+	for i, offset := range offsets {
+		if task := w.popExpired(base.Add(offset - tick)); task != nil {
+			t.Fatalf("Task %s expired early at offset %s", task.ID, offset-tick)
+		}
+		task := w.popExpired(base.Add(offset + tick))
+		if task == nil || task.ID != fmt.Sprintf("task%d", i) {
+			t.Fatalf("Expected task%d at offset %s, got %v", i, offset, task)
+		}
+	}
+	if w.size() != 0 {
+		t.Errorf("Expected empty wheel, got %d tasks", w.size())
+	}
+}
+
+func TestTimingWheel_Unschedule(t *testing.T) {
+	w := newTimingWheel(10 * time.Millisecond)
+	base := time.Now()
+
+	task := &TimerTask{ID: "task", ExpiryAt: base.Add(time.Minute)}
+	w.schedule(task)
+	w.unschedule(task)
+
+	if got := w.popExpired(base.Add(2 * time.Minute)); got != nil {
+		t.Errorf("Expected no task after unschedule, got %s", got.ID)
+	}
+}
+
+func TestTimerManager_Wheel(t *testing.T) {
+	tm := NewTimerManagerWithWheel(2, 100, 10*time.Millisecond)
+	tm.Start()
+	defer tm.Stop()
+
+	ran := make(chan string, 2)
+	tm.Schedule("cancelled", time.Now().Add(50*time.Millisecond), func(context.Context) { ran <- "cancelled" })
+	tm.Schedule("kept", time.Now().Add(100*time.Millisecond), func(context.Context) { ran <- "kept" })
+	tm.Cancel("cancelled")
+
+	select {
+	case id := <-ran:
+		if id != "kept" {
+			t.Errorf("Expected kept task to run, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Task did not run")
+	}
+}
+
+// benchmarkReschedule models inactivity timers: a large population of
+// coarse timers, each pushed back on every message
+func benchmarkReschedule(b *testing.B, tm *TimerManager) {
+	const timers = 200000
+	ids := make([]string, timers)
+	now := time.Now()
+	for i := range ids {
+		ids[i] = fmt.Sprintf("inactivity:%d", i)
+		tm.Schedule(ids[i], now.Add(time.Hour), func(context.Context) {})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tm.Schedule(ids[i%timers], now.Add(time.Hour+time.Duration(i)*time.Millisecond), func(context.Context) {})
+	}
+}
+
+func BenchmarkReschedule_Heap(b *testing.B) {
+	benchmarkReschedule(b, NewTimerManager(1))
+}
+
+func BenchmarkReschedule_Wheel(b *testing.B) {
+	benchmarkReschedule(b, NewTimerManagerWithWheel(1, 0, 100*time.Millisecond))
+}
@@ -0,0 +1,20 @@
+-- Weather Server Database Schema
+-- Migration 003: Stations
+
+-- Stations table stores metadata reported by each station at identify.
+-- The default station of a zipcode has an empty station_id.
+CREATE TABLE IF NOT EXISTS stations (
+    zipcode VARCHAR(10) NOT NULL,
+    station_id VARCHAR(64) NOT NULL DEFAULT '',
+    lat DECIMAL(10, 7),
+    lon DECIMAL(10, 7),
+    elevation_m DECIMAL(7, 2),
+    model VARCHAR(100),
+    firmware_version VARCHAR(50),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (zipcode, station_id),
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_stations_model ON stations(model);
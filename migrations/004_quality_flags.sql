@@ -0,0 +1,9 @@
+-- Weather Server Database Schema
+-- Migration 004: Data-quality flags
+
+-- Suspect measurements per reading, e.g. {"temperature": "spike"}.
+-- An empty object means every measurement passed the quality checks.
+ALTER TABLE raw_metrics ADD COLUMN IF NOT EXISTS quality_flags JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_raw_metrics_flagged ON raw_metrics(zipcode, timestamp)
+    WHERE quality_flags <> '{}';
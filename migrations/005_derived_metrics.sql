@@ -0,0 +1,20 @@
+-- Weather Server Database Schema
+-- Migration 005: Derived metrics (heat index, wind chill, dew point)
+
+ALTER TABLE raw_metrics
+    ADD COLUMN IF NOT EXISTS heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS dew_point DECIMAL(5, 2);
+
+ALTER TABLE hourly_metrics
+    ADD COLUMN IF NOT EXISTS avg_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS avg_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS avg_dew_point DECIMAL(5, 2);
+
+ALTER TABLE daily_summary
+    ADD COLUMN IF NOT EXISTS min_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_dew_point DECIMAL(5, 2);
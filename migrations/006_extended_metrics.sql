@@ -0,0 +1,24 @@
+-- Weather Server Database Schema
+-- Migration 006: Pressure, visibility, UV index and snow depth
+
+ALTER TABLE raw_metrics
+    ADD COLUMN IF NOT EXISTS pressure DECIMAL(6, 1),   -- hPa
+    ADD COLUMN IF NOT EXISTS visibility DECIMAL(6, 2), -- km
+    ADD COLUMN IF NOT EXISTS uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS snow_depth DECIMAL(6, 1); -- cm
+
+ALTER TABLE hourly_metrics
+    ADD COLUMN IF NOT EXISTS avg_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS avg_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS avg_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS avg_snow_depth DECIMAL(6, 1);
+
+ALTER TABLE daily_summary
+    ADD COLUMN IF NOT EXISTS min_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS max_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS min_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS max_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS min_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS max_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS min_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS max_snow_depth DECIMAL(6, 1);
@@ -0,0 +1,21 @@
+-- Weather Server Database Schema
+-- Migration 007: Connection sessions
+
+-- One row per station connection, written by the DB writer from the
+-- connection events topic. disconnected_at stays NULL while the station is
+-- connected (or if the server died without reporting the disconnect).
+CREATE TABLE IF NOT EXISTS connection_sessions (
+    connection_id VARCHAR(64) PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    city VARCHAR(100),
+    station_id VARCHAR(64) NOT NULL DEFAULT '',
+    remote_addr VARCHAR(64),
+    connected_at TIMESTAMPTZ NOT NULL,
+    last_idle_at TIMESTAMPTZ,
+    disconnected_at TIMESTAMPTZ,
+    duration_seconds INTEGER,
+    disconnect_reason TEXT
+);
+
+CREATE INDEX idx_connection_sessions_station ON connection_sessions(zipcode, station_id, connected_at DESC);
+CREATE INDEX idx_connection_sessions_connected_at ON connection_sessions(connected_at);
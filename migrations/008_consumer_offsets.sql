@@ -0,0 +1,15 @@
+-- Weather Server Database Schema
+-- Migration 008: Consumer offsets
+
+-- Offsets the DB writer has written through, per consumer group and
+-- partition. In exactly-once mode they are updated in the same transaction
+-- as the batch of readings, so a redelivered message can be recognised and
+-- skipped after a crash between the insert and the queue commit.
+CREATE TABLE IF NOT EXISTS consumer_offsets (
+    group_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    committed_offset BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, topic, partition)
+);
@@ -0,0 +1,19 @@
+-- Weather Server Database Schema
+-- Migration 009: One row per reading
+
+-- Queue redeliveries and client retries can deliver a reading twice. Rows
+-- are now unique per station and timestamp, and the DB writer upserts, so
+-- a repeated reading replaces the row instead of being counted twice in
+-- hourly averages. Readings from before stations were tracked have an
+-- empty station_id.
+ALTER TABLE raw_metrics ADD COLUMN IF NOT EXISTS station_id VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Drop duplicates already stored, keeping the first copy
+DELETE FROM raw_metrics a
+USING raw_metrics b
+WHERE a.zipcode = b.zipcode
+  AND a.station_id = b.station_id
+  AND a.timestamp = b.timestamp
+  AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_raw_metrics_reading ON raw_metrics(zipcode, station_id, timestamp);
@@ -0,0 +1,21 @@
+-- Weather Server Database Schema
+-- Migration 010: Cold-storage export manifest
+
+-- One row per Parquet file the exporter wrote to object storage. The rows
+-- of [range_start, range_end) in the file were deleted from raw_metrics in
+-- the same transaction that recorded it, so a range listed here is no
+-- longer in Postgres. A range can have several files when late readings
+-- arrived after it was first exported.
+CREATE TABLE IF NOT EXISTS cold_storage_exports (
+    id BIGSERIAL PRIMARY KEY,
+    range_start TIMESTAMPTZ NOT NULL,
+    range_end TIMESTAMPTZ NOT NULL,
+    object_url TEXT NOT NULL,       -- e.g. s3://bucket/weather/raw_metrics/date=2026-01-01/part-....parquet
+    row_count BIGINT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    exported_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_cold_storage_exports_range ON cold_storage_exports(range_start, range_end);
+
+COMMENT ON TABLE cold_storage_exports IS 'Raw metric ranges moved to Parquet files in object storage';
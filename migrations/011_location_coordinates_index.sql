@@ -0,0 +1,6 @@
+-- Weather Server Database Schema
+-- Migration 011: Location coordinates index
+
+-- Radius queries first narrow locations to a bounding box around the
+-- point, which this index serves
+CREATE INDEX IF NOT EXISTS idx_locations_lat_lon ON locations(lat, lon);
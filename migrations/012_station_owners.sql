@@ -0,0 +1,10 @@
+-- Weather Server Database Schema
+-- Migration 012: Station owners
+
+-- Who runs a station and how to reach them, e.g. when it goes quiet or
+-- reports stuck sensors. Set by operators; stations don't report it.
+ALTER TABLE stations
+    ADD COLUMN IF NOT EXISTS owner_name VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS owner_contact VARCHAR(255);
+
+COMMENT ON COLUMN stations.owner_contact IS 'Email address or phone number of the station owner';
@@ -0,0 +1,24 @@
+-- Weather Server Database Schema
+-- Migration 013: Aggregation runs
+
+-- One row per hourly or daily aggregation run of a window. A window whose
+-- latest run failed is retried by the aggregator; operators re-trigger a
+-- window by inserting a 'pending' row for it. Only one run of a window may
+-- be running at a time.
+CREATE TABLE IF NOT EXISTS aggregation_runs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('hourly', 'daily')),
+    window_start TIMESTAMPTZ NOT NULL,
+    window_end TIMESTAMPTZ NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    rows_affected BIGINT,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_aggregation_runs_window ON aggregation_runs(kind, window_start);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_aggregation_runs_running ON aggregation_runs(kind, window_start) WHERE status = 'running';
+
+COMMENT ON TABLE aggregation_runs IS 'History of hourly and daily aggregation runs';
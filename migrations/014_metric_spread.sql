@@ -0,0 +1,117 @@
+-- Weather Server Database Schema
+-- Migration 014: Percentile and variance aggregates
+
+-- Each metric's spread over the hour, next to its average: extremes,
+-- median, 95th percentile and sample standard deviation of the readings,
+-- and the most common wind direction
+ALTER TABLE hourly_metrics
+    ADD COLUMN IF NOT EXISTS min_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS max_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p50_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p95_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS stddev_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS min_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS max_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS p50_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS p95_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS stddev_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS min_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS max_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS p50_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS p95_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS stddev_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS min_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS max_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p50_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p95_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS stddev_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS min_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS min_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS max_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS mode_wind_direction VARCHAR(3);
+
+-- The same over the day's readings. The daily min_/max_ columns now come
+-- from the hourly extremes rather than the hourly averages.
+ALTER TABLE daily_summary
+    ADD COLUMN IF NOT EXISTS p50_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_temp DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_humidity DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_precip DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_wind DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_pollution DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_pollen DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p95_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS stddev_pressure DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p50_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS p95_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS stddev_visibility DECIMAL(6, 2),
+    ADD COLUMN IF NOT EXISTS p50_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS p95_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS stddev_uv_index DECIMAL(4, 1),
+    ADD COLUMN IF NOT EXISTS p50_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p95_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS stddev_snow_depth DECIMAL(6, 1),
+    ADD COLUMN IF NOT EXISTS p50_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_heat_index DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_wind_chill DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p50_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS p95_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS stddev_dew_point DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS mode_wind_direction VARCHAR(3);
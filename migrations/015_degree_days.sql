@@ -0,0 +1,10 @@
+-- Weather Server Database Schema
+-- Migration 015: Degree days and precipitation totals
+
+-- Heating and cooling degree days from the day's mean temperature, the
+-- day's precipitation and the total since January 1st
+ALTER TABLE daily_summary
+    ADD COLUMN IF NOT EXISTS heating_degree_days DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS cooling_degree_days DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS total_precip DECIMAL(7, 2),
+    ADD COLUMN IF NOT EXISTS precip_year_to_date DECIMAL(8, 2);
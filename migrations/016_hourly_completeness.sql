@@ -0,0 +1,13 @@
+-- Weather Server Database Schema
+-- Migration 016: Hourly data completeness
+
+-- How many readings each hour should have had from the stations that
+-- reported, what share of them arrived, and whether that is too few to
+-- trust the hour's aggregates
+ALTER TABLE hourly_metrics
+    ADD COLUMN IF NOT EXISTS expected_samples INTEGER,
+    ADD COLUMN IF NOT EXISTS completeness DECIMAL(5, 2),
+    ADD COLUMN IF NOT EXISTS incomplete BOOLEAN;
+
+CREATE INDEX IF NOT EXISTS idx_hourly_metrics_incomplete
+    ON hourly_metrics(zipcode, hour_timestamp) WHERE incomplete;
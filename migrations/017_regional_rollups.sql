@@ -0,0 +1,113 @@
+-- Weather Server Database Schema
+-- Migration 017: Regional rollups
+
+-- Where a zipcode lies above its city: its county and state, e.g.
+-- 'Los Angeles County' and 'CA'. Set by operators; stations only report
+-- the city.
+ALTER TABLE locations
+    ADD COLUMN IF NOT EXISTS county VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS state VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_locations_state ON locations(state, county, city_name);
+
+-- Hourly aggregates across the zipcodes of a city, county or state, each
+-- zipcode counting equally. Cities and counties are named with their state,
+-- e.g. 'Los Angeles, CA'; states by themselves.
+CREATE TABLE IF NOT EXISTS regional_hourly_metrics (
+    id BIGSERIAL PRIMARY KEY,
+    region_type VARCHAR(10) NOT NULL CHECK (region_type IN ('city', 'county', 'state')),
+    region VARCHAR(255) NOT NULL,
+    hour_timestamp TIMESTAMPTZ NOT NULL,
+    avg_temp DECIMAL(5, 2),
+    min_temp DECIMAL(5, 2),
+    max_temp DECIMAL(5, 2),
+    avg_humidity DECIMAL(5, 2),
+    min_humidity DECIMAL(5, 2),
+    max_humidity DECIMAL(5, 2),
+    avg_precip DECIMAL(5, 2),
+    min_precip DECIMAL(5, 2),
+    max_precip DECIMAL(5, 2),
+    avg_wind DECIMAL(5, 2),
+    min_wind DECIMAL(5, 2),
+    max_wind DECIMAL(5, 2),
+    avg_pollution DECIMAL(5, 2),
+    min_pollution DECIMAL(5, 2),
+    max_pollution DECIMAL(5, 2),
+    avg_pollen DECIMAL(5, 2),
+    min_pollen DECIMAL(5, 2),
+    max_pollen DECIMAL(5, 2),
+    avg_pressure DECIMAL(6, 1),
+    min_pressure DECIMAL(6, 1),
+    max_pressure DECIMAL(6, 1),
+    avg_visibility DECIMAL(6, 2),
+    min_visibility DECIMAL(6, 2),
+    max_visibility DECIMAL(6, 2),
+    avg_uv_index DECIMAL(4, 1),
+    min_uv_index DECIMAL(4, 1),
+    max_uv_index DECIMAL(4, 1),
+    avg_snow_depth DECIMAL(6, 1),
+    min_snow_depth DECIMAL(6, 1),
+    max_snow_depth DECIMAL(6, 1),
+    avg_heat_index DECIMAL(5, 2),
+    min_heat_index DECIMAL(5, 2),
+    max_heat_index DECIMAL(5, 2),
+    avg_wind_chill DECIMAL(5, 2),
+    min_wind_chill DECIMAL(5, 2),
+    max_wind_chill DECIMAL(5, 2),
+    avg_dew_point DECIMAL(5, 2),
+    min_dew_point DECIMAL(5, 2),
+    max_dew_point DECIMAL(5, 2),
+    zipcode_count INTEGER NOT NULL,
+    sample_count INTEGER NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(region_type, region, hour_timestamp)
+);
+
+CREATE INDEX IF NOT EXISTS idx_regional_hourly_metrics_hour ON regional_hourly_metrics(hour_timestamp);
+
+-- Daily summaries across the zipcodes of a region: the extremes of theirs,
+-- and their degree days and precipitation averaged
+CREATE TABLE IF NOT EXISTS regional_daily_summary (
+    id BIGSERIAL PRIMARY KEY,
+    region_type VARCHAR(10) NOT NULL CHECK (region_type IN ('city', 'county', 'state')),
+    region VARCHAR(255) NOT NULL,
+    date DATE NOT NULL,
+    min_temp DECIMAL(5, 2),
+    max_temp DECIMAL(5, 2),
+    min_humidity DECIMAL(5, 2),
+    max_humidity DECIMAL(5, 2),
+    min_precip DECIMAL(5, 2),
+    max_precip DECIMAL(5, 2),
+    min_wind DECIMAL(5, 2),
+    max_wind DECIMAL(5, 2),
+    min_pollution DECIMAL(5, 2),
+    max_pollution DECIMAL(5, 2),
+    min_pollen DECIMAL(5, 2),
+    max_pollen DECIMAL(5, 2),
+    min_pressure DECIMAL(6, 1),
+    max_pressure DECIMAL(6, 1),
+    min_visibility DECIMAL(6, 2),
+    max_visibility DECIMAL(6, 2),
+    min_uv_index DECIMAL(4, 1),
+    max_uv_index DECIMAL(4, 1),
+    min_snow_depth DECIMAL(6, 1),
+    max_snow_depth DECIMAL(6, 1),
+    min_heat_index DECIMAL(5, 2),
+    max_heat_index DECIMAL(5, 2),
+    min_wind_chill DECIMAL(5, 2),
+    max_wind_chill DECIMAL(5, 2),
+    min_dew_point DECIMAL(5, 2),
+    max_dew_point DECIMAL(5, 2),
+    heating_degree_days DECIMAL(5, 2),
+    cooling_degree_days DECIMAL(5, 2),
+    total_precip DECIMAL(7, 2),
+    precip_year_to_date DECIMAL(8, 2),
+    zipcode_count INTEGER NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(region_type, region, date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_regional_daily_summary_date ON regional_daily_summary(date);
+
+COMMENT ON TABLE regional_hourly_metrics IS 'Hourly aggregates rolled up by city, county and state';
+COMMENT ON TABLE regional_daily_summary IS 'Daily summaries rolled up by city, county and state';
@@ -0,0 +1,101 @@
+-- Weather Server Database Schema
+-- Migration 018: Windowed metrics
+
+-- Aggregates over the windows configured in AGGREGATION_WINDOWS, e.g.
+-- 15-minute or 6-hour ones, next to the hourly and daily tables. A window
+-- is identified by its length in seconds and its start; windows of a
+-- length are aligned to multiples of it in UTC.
+CREATE TABLE IF NOT EXISTS windowed_metrics (
+    id BIGSERIAL PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    window_seconds INTEGER NOT NULL CHECK (window_seconds > 0),
+    window_start TIMESTAMPTZ NOT NULL,
+    window_end TIMESTAMPTZ NOT NULL,
+    avg_temp DECIMAL(5, 2),
+    min_temp DECIMAL(5, 2),
+    max_temp DECIMAL(5, 2),
+    p50_temp DECIMAL(5, 2),
+    p95_temp DECIMAL(5, 2),
+    stddev_temp DECIMAL(5, 2),
+    avg_humidity DECIMAL(5, 2),
+    min_humidity DECIMAL(5, 2),
+    max_humidity DECIMAL(5, 2),
+    p50_humidity DECIMAL(5, 2),
+    p95_humidity DECIMAL(5, 2),
+    stddev_humidity DECIMAL(5, 2),
+    avg_precip DECIMAL(5, 2),
+    min_precip DECIMAL(5, 2),
+    max_precip DECIMAL(5, 2),
+    p50_precip DECIMAL(5, 2),
+    p95_precip DECIMAL(5, 2),
+    stddev_precip DECIMAL(5, 2),
+    avg_wind DECIMAL(5, 2),
+    min_wind DECIMAL(5, 2),
+    max_wind DECIMAL(5, 2),
+    p50_wind DECIMAL(5, 2),
+    p95_wind DECIMAL(5, 2),
+    stddev_wind DECIMAL(5, 2),
+    avg_pollution DECIMAL(5, 2),
+    min_pollution DECIMAL(5, 2),
+    max_pollution DECIMAL(5, 2),
+    p50_pollution DECIMAL(5, 2),
+    p95_pollution DECIMAL(5, 2),
+    stddev_pollution DECIMAL(5, 2),
+    avg_pollen DECIMAL(5, 2),
+    min_pollen DECIMAL(5, 2),
+    max_pollen DECIMAL(5, 2),
+    p50_pollen DECIMAL(5, 2),
+    p95_pollen DECIMAL(5, 2),
+    stddev_pollen DECIMAL(5, 2),
+    avg_pressure DECIMAL(6, 1),
+    min_pressure DECIMAL(6, 1),
+    max_pressure DECIMAL(6, 1),
+    p50_pressure DECIMAL(6, 1),
+    p95_pressure DECIMAL(6, 1),
+    stddev_pressure DECIMAL(6, 1),
+    avg_visibility DECIMAL(6, 2),
+    min_visibility DECIMAL(6, 2),
+    max_visibility DECIMAL(6, 2),
+    p50_visibility DECIMAL(6, 2),
+    p95_visibility DECIMAL(6, 2),
+    stddev_visibility DECIMAL(6, 2),
+    avg_uv_index DECIMAL(4, 1),
+    min_uv_index DECIMAL(4, 1),
+    max_uv_index DECIMAL(4, 1),
+    p50_uv_index DECIMAL(4, 1),
+    p95_uv_index DECIMAL(4, 1),
+    stddev_uv_index DECIMAL(4, 1),
+    avg_snow_depth DECIMAL(6, 1),
+    min_snow_depth DECIMAL(6, 1),
+    max_snow_depth DECIMAL(6, 1),
+    p50_snow_depth DECIMAL(6, 1),
+    p95_snow_depth DECIMAL(6, 1),
+    stddev_snow_depth DECIMAL(6, 1),
+    avg_heat_index DECIMAL(5, 2),
+    min_heat_index DECIMAL(5, 2),
+    max_heat_index DECIMAL(5, 2),
+    p50_heat_index DECIMAL(5, 2),
+    p95_heat_index DECIMAL(5, 2),
+    stddev_heat_index DECIMAL(5, 2),
+    avg_wind_chill DECIMAL(5, 2),
+    min_wind_chill DECIMAL(5, 2),
+    max_wind_chill DECIMAL(5, 2),
+    p50_wind_chill DECIMAL(5, 2),
+    p95_wind_chill DECIMAL(5, 2),
+    stddev_wind_chill DECIMAL(5, 2),
+    avg_dew_point DECIMAL(5, 2),
+    min_dew_point DECIMAL(5, 2),
+    max_dew_point DECIMAL(5, 2),
+    p50_dew_point DECIMAL(5, 2),
+    p95_dew_point DECIMAL(5, 2),
+    stddev_dew_point DECIMAL(5, 2),
+    mode_wind_direction VARCHAR(3),
+    sample_count INTEGER DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, window_seconds, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_windowed_metrics_start ON windowed_metrics(window_seconds, window_start);
+
+COMMENT ON TABLE windowed_metrics IS 'Aggregates over configurable windows, e.g. 15 minutes or 6 hours';
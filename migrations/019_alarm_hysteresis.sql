@@ -0,0 +1,12 @@
+-- Weather Server Database Schema
+-- Migration 019: Alarm hysteresis
+
+-- An active alarm clears only once the metric is past clear_value, on the
+-- other side of the threshold, for clear_duration_minutes. Without a
+-- clear_value it clears once the threshold is no longer breached.
+ALTER TABLE alarm_thresholds
+    ADD COLUMN IF NOT EXISTS clear_value DECIMAL(10, 2),
+    ADD COLUMN IF NOT EXISTS clear_duration_minutes INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN alarm_thresholds.clear_value IS 'Value the metric must pass back through to clear an active alarm, e.g. 33 for temperature > 35';
+COMMENT ON COLUMN alarm_thresholds.clear_duration_minutes IS 'Duration the clear condition must hold before an active alarm clears';
@@ -0,0 +1,9 @@
+-- Weather Server Database Schema
+-- Migration 020: Flapping alarms
+
+-- Alarms triggered while their metric keeps triggering and clearing are
+-- logged as FLAPPING instead of ACTIVE, and keep that status once cleared.
+-- The constraint keeps its name on a partitioned alarms_log too.
+ALTER TABLE alarms_log DROP CONSTRAINT IF EXISTS alarms_log_status_check;
+ALTER TABLE alarms_log ADD CONSTRAINT alarms_log_status_check
+    CHECK (status IN ('ACTIVE', 'CLEARED', 'FLAPPING'));
@@ -0,0 +1,30 @@
+-- Weather Server Database Schema
+-- Migration 021: Composite alarm rules
+
+-- A rule alarms when its conditions, on metrics of the same reading, all
+-- hold (AND) or any holds (OR) for duration_minutes, e.g. a heat advisory
+-- on temperature > 30 AND humidity > 80
+CREATE TABLE IF NOT EXISTS alarm_rules (
+    id SERIAL PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    combinator VARCHAR(3) NOT NULL CHECK (combinator IN ('AND', 'OR')),
+    duration_minutes INTEGER NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_rules_zipcode ON alarm_rules(zipcode);
+
+CREATE TABLE IF NOT EXISTS alarm_rule_conditions (
+    id SERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES alarm_rules(id) ON DELETE CASCADE,
+    metric_name VARCHAR(50) NOT NULL,
+    operator VARCHAR(2) NOT NULL CHECK (operator IN ('>', '<', '>=', '<=')),
+    threshold_value DECIMAL(10, 2) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_rule_conditions_rule ON alarm_rule_conditions(rule_id);
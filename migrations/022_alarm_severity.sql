@@ -0,0 +1,24 @@
+-- Weather Server Database Schema
+-- Migration 022: Alarm severity and escalation
+
+-- Thresholds and rules carry a severity their logged alarms copy
+ALTER TABLE alarm_thresholds
+    ADD COLUMN IF NOT EXISTS severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+        CHECK (severity IN ('info', 'warning', 'critical'));
+
+ALTER TABLE alarm_rules
+    ADD COLUMN IF NOT EXISTS severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+        CHECK (severity IN ('info', 'warning', 'critical'));
+
+-- An active alarm nobody acknowledged is notified again, on the next
+-- channel of the escalation policy, each time it has gone unacknowledged
+-- for the policy's interval
+ALTER TABLE alarms_log
+    ADD COLUMN IF NOT EXISTS severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+        CHECK (severity IN ('info', 'warning', 'critical')),
+    ADD COLUMN IF NOT EXISTS acknowledged_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS escalation_level INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS escalated_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN alarms_log.escalation_level IS 'Times the alarm has been escalated; 0 until it first is';
+COMMENT ON COLUMN alarms_log.escalated_at IS 'When the alarm was last escalated';
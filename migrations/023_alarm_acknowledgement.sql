@@ -0,0 +1,10 @@
+-- Weather Server Database Schema
+-- Migration 023: Alarm acknowledgement and snooze
+
+-- Who acknowledged an alarm, and until when escalation is snoozed; without
+-- a snooze it stays silenced until the alarm clears
+ALTER TABLE alarms_log
+    ADD COLUMN IF NOT EXISTS acknowledged_by VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMPTZ;
+
+COMMENT ON COLUMN alarms_log.snoozed_until IS 'When escalation of an acknowledged alarm resumes; NULL silences it until it clears';
@@ -0,0 +1,19 @@
+-- Weather Server Database Schema
+-- Migration 024: Alarm suppression windows
+
+-- Maintenance windows withholding alarm notifications for a zipcode's
+-- metric, every metric of a zipcode, a metric everywhere, or everything
+-- (NULL matches all). Alarms are still evaluated and logged meanwhile.
+CREATE TABLE IF NOT EXISTS alarm_suppressions (
+    id SERIAL PRIMARY KEY,
+    zipcode VARCHAR(10),
+    metric_name VARCHAR(50),
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_suppressions_ends_at ON alarm_suppressions(ends_at);
@@ -0,0 +1,24 @@
+-- Weather Server Database Schema
+-- Migration 025: Anomaly alarm rules
+
+-- An anomaly rule alarms when a metric is more than `deviations` standard
+-- deviations from its hourly averages at the same hour over the last
+-- baseline_days days, for duration_minutes
+CREATE TABLE IF NOT EXISTS alarm_anomaly_rules (
+    id SERIAL PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    metric_name VARCHAR(50) NOT NULL,
+    deviations DECIMAL(4, 2) NOT NULL CHECK (deviations > 0),
+    baseline_days INTEGER NOT NULL DEFAULT 30 CHECK (baseline_days > 0),
+    min_samples INTEGER NOT NULL DEFAULT 7 CHECK (min_samples >= 2),
+    duration_minutes INTEGER NOT NULL,
+    severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+        CHECK (severity IN ('info', 'warning', 'critical')),
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, metric_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_anomaly_rules_zipcode ON alarm_anomaly_rules(zipcode);
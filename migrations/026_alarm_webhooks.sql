@@ -0,0 +1,18 @@
+-- Weather Server Database Schema
+-- Migration 026: Alarm webhooks
+
+-- URLs the alarming service POSTs to when a threshold's alarm triggers or
+-- clears. payload_template is a Go text/template over the notification,
+-- NULL for the notification's JSON; with a secret the body is signed with
+-- HMAC-SHA256.
+CREATE TABLE IF NOT EXISTS alarm_webhooks (
+    id SERIAL PRIMARY KEY,
+    threshold_id INTEGER NOT NULL REFERENCES alarm_thresholds(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    payload_template TEXT,
+    secret TEXT,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_webhooks_threshold ON alarm_webhooks(threshold_id);
@@ -0,0 +1,23 @@
+-- Weather Server Database Schema
+-- Migration 027: Webhook notification deliveries
+
+-- One row per notification the notification service sent, or is still
+-- sending, to each webhook endpoint: pending until it is delivered or
+-- has failed every attempt
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint TEXT NOT NULL,
+    notification_type VARCHAR(30) NOT NULL,
+    zipcode VARCHAR(10) NOT NULL,
+    metric_name VARCHAR(50) NOT NULL,
+    alarm_id BIGINT,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status, created_at);
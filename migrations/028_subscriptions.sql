@@ -0,0 +1,20 @@
+-- Weather Server Database Schema
+-- Migration 028: Notification subscriptions
+
+-- Who gets which alarm notifications, over which channel: an email
+-- address or phone number subscribed to a zipcode's metric, every metric
+-- of a zipcode, a metric everywhere, or everything (NULL matches all), at
+-- min_severity or above. One row per recipient, channel and match.
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id SERIAL PRIMARY KEY,
+    recipient VARCHAR(255) NOT NULL,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('email', 'sms')),
+    zipcode VARCHAR(10),
+    metric_name VARCHAR(50),
+    min_severity VARCHAR(10) NOT NULL DEFAULT 'info' CHECK (min_severity IN ('info', 'warning', 'critical')),
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_zipcode ON subscriptions(zipcode) WHERE is_active;
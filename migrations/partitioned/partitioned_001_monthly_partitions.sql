@@ -0,0 +1,63 @@
+-- Weather Server Database Schema
+-- Partitioning Migration 001: Monthly partitions
+-- Only run with DB_PARTITIONED=true, after the plain migrations.
+
+-- raw_metrics and alarms_log become range-partitioned by month. Queries for
+-- a time range only scan the months it covers, and a month past retention
+-- is detached instead of deleted row by row. The DB writer creates the
+-- monthly partitions; rows without one land in the default partition until
+-- it does.
+
+-- Unique constraints on a partitioned table must include the partition
+-- column. Nothing references these ids, so the keys just gain the time.
+
+-- raw_metrics, partitioned by timestamp
+ALTER TABLE raw_metrics RENAME TO raw_metrics_unpartitioned;
+
+CREATE TABLE raw_metrics (LIKE raw_metrics_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+    PARTITION BY RANGE (timestamp);
+
+-- Keep the id sequence when the old table is dropped
+ALTER SEQUENCE raw_metrics_id_seq OWNED BY raw_metrics.id;
+
+CREATE TABLE raw_metrics_default PARTITION OF raw_metrics DEFAULT;
+
+-- Existing rows are copied into the default partition, which locks the
+-- table while it runs; the DB writer then moves them into their months
+INSERT INTO raw_metrics SELECT * FROM raw_metrics_unpartitioned;
+DROP TABLE raw_metrics_unpartitioned;
+
+COMMENT ON TABLE raw_metrics IS '5-minute interval weather measurements';
+
+ALTER TABLE raw_metrics ADD PRIMARY KEY (id, timestamp);
+ALTER TABLE raw_metrics ADD FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE;
+
+CREATE INDEX idx_raw_metrics_zipcode_timestamp ON raw_metrics(zipcode, timestamp);
+CREATE INDEX idx_raw_metrics_timestamp ON raw_metrics(timestamp);
+CREATE INDEX idx_raw_metrics_flagged ON raw_metrics(zipcode, timestamp)
+    WHERE quality_flags <> '{}';
+CREATE UNIQUE INDEX idx_raw_metrics_reading ON raw_metrics(zipcode, station_id, timestamp);
+
+-- alarms_log, partitioned by start_time
+ALTER TABLE alarms_log RENAME TO alarms_log_unpartitioned;
+
+CREATE TABLE alarms_log (LIKE alarms_log_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+    PARTITION BY RANGE (start_time);
+
+ALTER SEQUENCE alarms_log_alarm_id_seq OWNED BY alarms_log.alarm_id;
+
+CREATE TABLE alarms_log_default PARTITION OF alarms_log DEFAULT;
+
+INSERT INTO alarms_log SELECT * FROM alarms_log_unpartitioned;
+DROP TABLE alarms_log_unpartitioned;
+
+ALTER TABLE alarms_log ADD PRIMARY KEY (alarm_id, start_time);
+ALTER TABLE alarms_log ADD FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE;
+
+CREATE INDEX idx_alarms_log_zipcode ON alarms_log(zipcode);
+CREATE INDEX idx_alarms_log_status ON alarms_log(status);
+CREATE INDEX idx_alarms_log_start_time ON alarms_log(start_time);
+CREATE INDEX idx_alarms_log_zipcode_status ON alarms_log(zipcode, status);
+
+COMMENT ON TABLE alarms_log IS 'Historical log of all triggered alarms';
+COMMENT ON COLUMN alarms_log.threshold_config IS 'JSON snapshot of the threshold configuration at time of alarm';
@@ -0,0 +1,197 @@
+-- Weather Server Database Schema
+-- SQLite Migration 001: Schema
+-- Only run with DB_DRIVER=sqlite, instead of the plain migrations.
+
+-- The schema of Postgres migrations 001 to 010 in SQLite's terms: ids are
+-- INTEGER PRIMARY KEYs, decimals are REAL, JSON is TEXT, and timestamps are
+-- DATETIME text in UTC, which sorts in time order. Later Postgres
+-- migrations that change these tables need a matching SQLite migration.
+
+CREATE TABLE IF NOT EXISTS locations (
+    zipcode VARCHAR(10) PRIMARY KEY,
+    city_name VARCHAR(255) NOT NULL,
+    lat REAL,
+    lon REAL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_locations_city ON locations(city_name);
+
+CREATE TABLE IF NOT EXISTS stations (
+    zipcode VARCHAR(10) NOT NULL,
+    station_id VARCHAR(64) NOT NULL DEFAULT '',
+    lat REAL,
+    lon REAL,
+    elevation_m REAL,
+    model VARCHAR(100),
+    firmware_version VARCHAR(50),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (zipcode, station_id),
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stations_model ON stations(model);
+
+CREATE TABLE IF NOT EXISTS raw_metrics (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    station_id VARCHAR(64) NOT NULL DEFAULT '',
+    timestamp DATETIME NOT NULL,
+    temperature REAL,
+    humidity REAL,
+    precipitation REAL,
+    wind_speed REAL,
+    wind_direction VARCHAR(3),
+    pollution_index REAL,
+    pollen_index REAL,
+    pressure REAL,   -- hPa
+    visibility REAL, -- km
+    uv_index REAL,
+    snow_depth REAL, -- cm
+    heat_index REAL,
+    wind_chill REAL,
+    dew_point REAL,
+    quality_flags TEXT NOT NULL DEFAULT '{}',
+    received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_raw_metrics_zipcode_timestamp ON raw_metrics(zipcode, timestamp);
+CREATE INDEX IF NOT EXISTS idx_raw_metrics_timestamp ON raw_metrics(timestamp);
+CREATE INDEX IF NOT EXISTS idx_raw_metrics_flagged ON raw_metrics(zipcode, timestamp)
+    WHERE quality_flags <> '{}';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_raw_metrics_reading ON raw_metrics(zipcode, station_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS hourly_metrics (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    hour_timestamp DATETIME NOT NULL,
+    avg_temp REAL,
+    avg_humidity REAL,
+    avg_precip REAL,
+    avg_wind REAL,
+    avg_pollution REAL,
+    avg_pollen REAL,
+    avg_pressure REAL,
+    avg_visibility REAL,
+    avg_uv_index REAL,
+    avg_snow_depth REAL,
+    avg_heat_index REAL,
+    avg_wind_chill REAL,
+    avg_dew_point REAL,
+    sample_count INTEGER DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, hour_timestamp)
+);
+
+CREATE INDEX IF NOT EXISTS idx_hourly_metrics_hour ON hourly_metrics(hour_timestamp);
+
+CREATE TABLE IF NOT EXISTS daily_summary (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    date DATE NOT NULL, -- YYYY-MM-DD
+    min_temp REAL,
+    max_temp REAL,
+    min_humidity REAL,
+    max_humidity REAL,
+    min_precip REAL,
+    max_precip REAL,
+    min_wind REAL,
+    max_wind REAL,
+    min_pollution REAL,
+    max_pollution REAL,
+    min_pollen REAL,
+    max_pollen REAL,
+    min_pressure REAL,
+    max_pressure REAL,
+    min_visibility REAL,
+    max_visibility REAL,
+    min_uv_index REAL,
+    max_uv_index REAL,
+    min_snow_depth REAL,
+    max_snow_depth REAL,
+    min_heat_index REAL,
+    max_heat_index REAL,
+    min_wind_chill REAL,
+    max_wind_chill REAL,
+    min_dew_point REAL,
+    max_dew_point REAL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_daily_summary_date ON daily_summary(date);
+
+CREATE TABLE IF NOT EXISTS alarm_thresholds (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    metric_name VARCHAR(50) NOT NULL,
+    operator VARCHAR(2) NOT NULL CHECK (operator IN ('>', '<', '>=', '<=')),
+    threshold_value REAL NOT NULL,
+    duration_minutes INTEGER NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, metric_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_thresholds_active ON alarm_thresholds(is_active) WHERE is_active = true;
+
+CREATE TABLE IF NOT EXISTS alarms_log (
+    alarm_id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    metric_name VARCHAR(50) NOT NULL,
+    breach_value REAL NOT NULL,
+    threshold_config TEXT NOT NULL, -- JSON
+    start_time DATETIME NOT NULL,
+    end_time DATETIME,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('ACTIVE', 'CLEARED')),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarms_log_start_time ON alarms_log(start_time);
+CREATE INDEX IF NOT EXISTS idx_alarms_log_zipcode_status ON alarms_log(zipcode, status);
+
+CREATE TABLE IF NOT EXISTS connection_sessions (
+    connection_id VARCHAR(64) PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    city VARCHAR(100),
+    station_id VARCHAR(64) NOT NULL DEFAULT '',
+    remote_addr VARCHAR(64),
+    connected_at DATETIME NOT NULL,
+    last_idle_at DATETIME,
+    disconnected_at DATETIME,
+    duration_seconds INTEGER,
+    disconnect_reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_connection_sessions_station ON connection_sessions(zipcode, station_id, connected_at DESC);
+CREATE INDEX IF NOT EXISTS idx_connection_sessions_connected_at ON connection_sessions(connected_at);
+
+CREATE TABLE IF NOT EXISTS consumer_offsets (
+    group_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    committed_offset BIGINT NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, topic, partition)
+);
+
+CREATE TABLE IF NOT EXISTS cold_storage_exports (
+    id INTEGER PRIMARY KEY,
+    range_start DATETIME NOT NULL,
+    range_end DATETIME NOT NULL,
+    object_url TEXT NOT NULL,
+    row_count BIGINT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    exported_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_cold_storage_exports_range ON cold_storage_exports(range_start, range_end);
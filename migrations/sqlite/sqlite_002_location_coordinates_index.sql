@@ -0,0 +1,5 @@
+-- Weather Server Database Schema
+-- SQLite Migration 002: Location coordinates index
+-- Matches Postgres migration 011.
+
+CREATE INDEX IF NOT EXISTS idx_locations_lat_lon ON locations(lat, lon);
@@ -0,0 +1,6 @@
+-- Weather Server Database Schema
+-- SQLite Migration 003: Station owners
+-- Matches Postgres migration 012.
+
+ALTER TABLE stations ADD COLUMN owner_name VARCHAR(255);
+ALTER TABLE stations ADD COLUMN owner_contact VARCHAR(255);
@@ -0,0 +1,19 @@
+-- Weather Server Database Schema
+-- SQLite Migration 004: Aggregation runs
+-- Matches Postgres migration 013.
+
+CREATE TABLE IF NOT EXISTS aggregation_runs (
+    id INTEGER PRIMARY KEY,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('hourly', 'daily')),
+    window_start DATETIME NOT NULL,
+    window_end DATETIME NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    started_at DATETIME,
+    finished_at DATETIME,
+    rows_affected BIGINT,
+    error TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_aggregation_runs_window ON aggregation_runs(kind, window_start);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_aggregation_runs_running ON aggregation_runs(kind, window_start) WHERE status = 'running';
@@ -0,0 +1,111 @@
+-- Weather Server Database Schema
+-- SQLite Migration 005: Percentile and variance aggregates
+-- Matches Postgres migration 014.
+
+ALTER TABLE hourly_metrics ADD COLUMN min_temp REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_temp REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_temp REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_temp REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_temp REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_humidity REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_humidity REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_humidity REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_humidity REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_humidity REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_precip REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_precip REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_precip REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_precip REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_precip REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_wind REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_wind REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_wind REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_wind REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_wind REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_pollution REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_pollution REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_pollution REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_pollution REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_pollution REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_pollen REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_pollen REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_pollen REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_pollen REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_pollen REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_pressure REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_pressure REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_pressure REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_pressure REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_pressure REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_visibility REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_visibility REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_visibility REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_visibility REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_visibility REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_uv_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_uv_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_uv_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_uv_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_uv_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_snow_depth REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_snow_depth REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_snow_depth REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_snow_depth REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_snow_depth REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_heat_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_heat_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_heat_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_heat_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_heat_index REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_wind_chill REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_wind_chill REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_wind_chill REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_wind_chill REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_wind_chill REAL;
+ALTER TABLE hourly_metrics ADD COLUMN min_dew_point REAL;
+ALTER TABLE hourly_metrics ADD COLUMN max_dew_point REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p50_dew_point REAL;
+ALTER TABLE hourly_metrics ADD COLUMN p95_dew_point REAL;
+ALTER TABLE hourly_metrics ADD COLUMN stddev_dew_point REAL;
+ALTER TABLE hourly_metrics ADD COLUMN mode_wind_direction VARCHAR(3);
+
+ALTER TABLE daily_summary ADD COLUMN p50_temp REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_temp REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_temp REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_humidity REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_humidity REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_humidity REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_precip REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_precip REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_precip REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_wind REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_wind REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_wind REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_pollution REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_pollution REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_pollution REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_pollen REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_pollen REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_pollen REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_pressure REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_pressure REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_pressure REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_visibility REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_visibility REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_visibility REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_uv_index REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_uv_index REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_uv_index REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_snow_depth REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_snow_depth REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_snow_depth REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_heat_index REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_heat_index REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_heat_index REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_wind_chill REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_wind_chill REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_wind_chill REAL;
+ALTER TABLE daily_summary ADD COLUMN p50_dew_point REAL;
+ALTER TABLE daily_summary ADD COLUMN p95_dew_point REAL;
+ALTER TABLE daily_summary ADD COLUMN stddev_dew_point REAL;
+ALTER TABLE daily_summary ADD COLUMN mode_wind_direction VARCHAR(3);
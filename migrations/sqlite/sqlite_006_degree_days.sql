@@ -0,0 +1,8 @@
+-- Weather Server Database Schema
+-- SQLite Migration 006: Degree days and precipitation totals
+-- Matches Postgres migration 015.
+
+ALTER TABLE daily_summary ADD COLUMN heating_degree_days REAL;
+ALTER TABLE daily_summary ADD COLUMN cooling_degree_days REAL;
+ALTER TABLE daily_summary ADD COLUMN total_precip REAL;
+ALTER TABLE daily_summary ADD COLUMN precip_year_to_date REAL;
@@ -0,0 +1,10 @@
+-- Weather Server Database Schema
+-- SQLite Migration 007: Hourly data completeness
+-- Matches Postgres migration 016.
+
+ALTER TABLE hourly_metrics ADD COLUMN expected_samples INTEGER;
+ALTER TABLE hourly_metrics ADD COLUMN completeness REAL;
+ALTER TABLE hourly_metrics ADD COLUMN incomplete BOOLEAN;
+
+CREATE INDEX IF NOT EXISTS idx_hourly_metrics_incomplete
+    ON hourly_metrics(zipcode, hour_timestamp) WHERE incomplete;
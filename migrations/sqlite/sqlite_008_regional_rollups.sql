@@ -0,0 +1,102 @@
+-- Weather Server Database Schema
+-- SQLite Migration 008: Regional rollups
+-- Matches Postgres migration 017.
+
+ALTER TABLE locations ADD COLUMN county VARCHAR(255);
+ALTER TABLE locations ADD COLUMN state VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_locations_state ON locations(state, county, city_name);
+
+CREATE TABLE IF NOT EXISTS regional_hourly_metrics (
+    id INTEGER PRIMARY KEY,
+    region_type VARCHAR(10) NOT NULL CHECK (region_type IN ('city', 'county', 'state')),
+    region VARCHAR(255) NOT NULL,
+    hour_timestamp DATETIME NOT NULL,
+    avg_temp REAL,
+    min_temp REAL,
+    max_temp REAL,
+    avg_humidity REAL,
+    min_humidity REAL,
+    max_humidity REAL,
+    avg_precip REAL,
+    min_precip REAL,
+    max_precip REAL,
+    avg_wind REAL,
+    min_wind REAL,
+    max_wind REAL,
+    avg_pollution REAL,
+    min_pollution REAL,
+    max_pollution REAL,
+    avg_pollen REAL,
+    min_pollen REAL,
+    max_pollen REAL,
+    avg_pressure REAL,
+    min_pressure REAL,
+    max_pressure REAL,
+    avg_visibility REAL,
+    min_visibility REAL,
+    max_visibility REAL,
+    avg_uv_index REAL,
+    min_uv_index REAL,
+    max_uv_index REAL,
+    avg_snow_depth REAL,
+    min_snow_depth REAL,
+    max_snow_depth REAL,
+    avg_heat_index REAL,
+    min_heat_index REAL,
+    max_heat_index REAL,
+    avg_wind_chill REAL,
+    min_wind_chill REAL,
+    max_wind_chill REAL,
+    avg_dew_point REAL,
+    min_dew_point REAL,
+    max_dew_point REAL,
+    zipcode_count INTEGER NOT NULL,
+    sample_count INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(region_type, region, hour_timestamp)
+);
+
+CREATE INDEX IF NOT EXISTS idx_regional_hourly_metrics_hour ON regional_hourly_metrics(hour_timestamp);
+
+CREATE TABLE IF NOT EXISTS regional_daily_summary (
+    id INTEGER PRIMARY KEY,
+    region_type VARCHAR(10) NOT NULL CHECK (region_type IN ('city', 'county', 'state')),
+    region VARCHAR(255) NOT NULL,
+    date DATE NOT NULL, -- YYYY-MM-DD
+    min_temp REAL,
+    max_temp REAL,
+    min_humidity REAL,
+    max_humidity REAL,
+    min_precip REAL,
+    max_precip REAL,
+    min_wind REAL,
+    max_wind REAL,
+    min_pollution REAL,
+    max_pollution REAL,
+    min_pollen REAL,
+    max_pollen REAL,
+    min_pressure REAL,
+    max_pressure REAL,
+    min_visibility REAL,
+    max_visibility REAL,
+    min_uv_index REAL,
+    max_uv_index REAL,
+    min_snow_depth REAL,
+    max_snow_depth REAL,
+    min_heat_index REAL,
+    max_heat_index REAL,
+    min_wind_chill REAL,
+    max_wind_chill REAL,
+    min_dew_point REAL,
+    max_dew_point REAL,
+    heating_degree_days REAL,
+    cooling_degree_days REAL,
+    total_precip REAL,
+    precip_year_to_date REAL,
+    zipcode_count INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(region_type, region, date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_regional_daily_summary_date ON regional_daily_summary(date);
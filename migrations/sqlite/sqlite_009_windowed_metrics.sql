@@ -0,0 +1,96 @@
+-- Weather Server Database Schema
+-- SQLite Migration 009: Windowed metrics
+-- Matches Postgres migration 018.
+
+CREATE TABLE IF NOT EXISTS windowed_metrics (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    window_seconds INTEGER NOT NULL CHECK (window_seconds > 0),
+    window_start DATETIME NOT NULL,
+    window_end DATETIME NOT NULL,
+    avg_temp REAL,
+    min_temp REAL,
+    max_temp REAL,
+    p50_temp REAL,
+    p95_temp REAL,
+    stddev_temp REAL,
+    avg_humidity REAL,
+    min_humidity REAL,
+    max_humidity REAL,
+    p50_humidity REAL,
+    p95_humidity REAL,
+    stddev_humidity REAL,
+    avg_precip REAL,
+    min_precip REAL,
+    max_precip REAL,
+    p50_precip REAL,
+    p95_precip REAL,
+    stddev_precip REAL,
+    avg_wind REAL,
+    min_wind REAL,
+    max_wind REAL,
+    p50_wind REAL,
+    p95_wind REAL,
+    stddev_wind REAL,
+    avg_pollution REAL,
+    min_pollution REAL,
+    max_pollution REAL,
+    p50_pollution REAL,
+    p95_pollution REAL,
+    stddev_pollution REAL,
+    avg_pollen REAL,
+    min_pollen REAL,
+    max_pollen REAL,
+    p50_pollen REAL,
+    p95_pollen REAL,
+    stddev_pollen REAL,
+    avg_pressure REAL,
+    min_pressure REAL,
+    max_pressure REAL,
+    p50_pressure REAL,
+    p95_pressure REAL,
+    stddev_pressure REAL,
+    avg_visibility REAL,
+    min_visibility REAL,
+    max_visibility REAL,
+    p50_visibility REAL,
+    p95_visibility REAL,
+    stddev_visibility REAL,
+    avg_uv_index REAL,
+    min_uv_index REAL,
+    max_uv_index REAL,
+    p50_uv_index REAL,
+    p95_uv_index REAL,
+    stddev_uv_index REAL,
+    avg_snow_depth REAL,
+    min_snow_depth REAL,
+    max_snow_depth REAL,
+    p50_snow_depth REAL,
+    p95_snow_depth REAL,
+    stddev_snow_depth REAL,
+    avg_heat_index REAL,
+    min_heat_index REAL,
+    max_heat_index REAL,
+    p50_heat_index REAL,
+    p95_heat_index REAL,
+    stddev_heat_index REAL,
+    avg_wind_chill REAL,
+    min_wind_chill REAL,
+    max_wind_chill REAL,
+    p50_wind_chill REAL,
+    p95_wind_chill REAL,
+    stddev_wind_chill REAL,
+    avg_dew_point REAL,
+    min_dew_point REAL,
+    max_dew_point REAL,
+    p50_dew_point REAL,
+    p95_dew_point REAL,
+    stddev_dew_point REAL,
+    mode_wind_direction VARCHAR(3),
+    sample_count INTEGER DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, window_seconds, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_windowed_metrics_start ON windowed_metrics(window_seconds, window_start);
@@ -0,0 +1,6 @@
+-- Weather Server Database Schema
+-- SQLite Migration 010: Alarm hysteresis
+-- Matches Postgres migration 019.
+
+ALTER TABLE alarm_thresholds ADD COLUMN clear_value REAL;
+ALTER TABLE alarm_thresholds ADD COLUMN clear_duration_minutes INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,25 @@
+-- Weather Server Database Schema
+-- SQLite Migration 011: Flapping alarms
+-- Matches Postgres migration 020. SQLite can't change a CHECK constraint,
+-- so alarms_log is rebuilt.
+
+CREATE TABLE alarms_log_flapping (
+    alarm_id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    metric_name VARCHAR(50) NOT NULL,
+    breach_value REAL NOT NULL,
+    threshold_config TEXT NOT NULL, -- JSON
+    start_time DATETIME NOT NULL,
+    end_time DATETIME,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('ACTIVE', 'CLEARED', 'FLAPPING')),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+INSERT INTO alarms_log_flapping SELECT * FROM alarms_log;
+DROP TABLE alarms_log;
+ALTER TABLE alarms_log_flapping RENAME TO alarms_log;
+
+CREATE INDEX IF NOT EXISTS idx_alarms_log_start_time ON alarms_log(start_time);
+CREATE INDEX IF NOT EXISTS idx_alarms_log_zipcode_status ON alarms_log(zipcode, status);
@@ -0,0 +1,15 @@
+-- Weather Server Database Schema
+-- SQLite Migration 013: Alarm severity and escalation
+-- Matches Postgres migration 022.
+
+ALTER TABLE alarm_thresholds ADD COLUMN severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+    CHECK (severity IN ('info', 'warning', 'critical'));
+
+ALTER TABLE alarm_rules ADD COLUMN severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+    CHECK (severity IN ('info', 'warning', 'critical'));
+
+ALTER TABLE alarms_log ADD COLUMN severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+    CHECK (severity IN ('info', 'warning', 'critical'));
+ALTER TABLE alarms_log ADD COLUMN acknowledged_at DATETIME;
+ALTER TABLE alarms_log ADD COLUMN escalation_level INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE alarms_log ADD COLUMN escalated_at DATETIME;
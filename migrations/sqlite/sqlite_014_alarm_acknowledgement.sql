@@ -0,0 +1,6 @@
+-- Weather Server Database Schema
+-- SQLite Migration 014: Alarm acknowledgement and snooze
+-- Matches Postgres migration 023.
+
+ALTER TABLE alarms_log ADD COLUMN acknowledged_by VARCHAR(100);
+ALTER TABLE alarms_log ADD COLUMN snoozed_until DATETIME;
@@ -0,0 +1,17 @@
+-- Weather Server Database Schema
+-- SQLite Migration 015: Alarm suppression windows
+-- Matches Postgres migration 024.
+
+CREATE TABLE IF NOT EXISTS alarm_suppressions (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10),
+    metric_name VARCHAR(50),
+    starts_at DATETIME NOT NULL,
+    ends_at DATETIME NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_suppressions_ends_at ON alarm_suppressions(ends_at);
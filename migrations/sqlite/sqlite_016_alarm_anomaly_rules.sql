@@ -0,0 +1,22 @@
+-- Weather Server Database Schema
+-- SQLite Migration 016: Anomaly alarm rules
+-- Matches Postgres migration 025.
+
+CREATE TABLE IF NOT EXISTS alarm_anomaly_rules (
+    id INTEGER PRIMARY KEY,
+    zipcode VARCHAR(10) NOT NULL,
+    metric_name VARCHAR(50) NOT NULL,
+    deviations REAL NOT NULL CHECK (deviations > 0),
+    baseline_days INTEGER NOT NULL DEFAULT 30 CHECK (baseline_days > 0),
+    min_samples INTEGER NOT NULL DEFAULT 7 CHECK (min_samples >= 2),
+    duration_minutes INTEGER NOT NULL,
+    severity VARCHAR(10) NOT NULL DEFAULT 'warning'
+        CHECK (severity IN ('info', 'warning', 'critical')),
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE,
+    UNIQUE(zipcode, metric_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_anomaly_rules_zipcode ON alarm_anomaly_rules(zipcode);
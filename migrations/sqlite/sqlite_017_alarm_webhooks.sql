@@ -0,0 +1,15 @@
+-- Weather Server Database Schema
+-- SQLite Migration 017: Alarm webhooks
+-- Matches Postgres migration 026.
+
+CREATE TABLE IF NOT EXISTS alarm_webhooks (
+    id INTEGER PRIMARY KEY,
+    threshold_id INTEGER NOT NULL REFERENCES alarm_thresholds(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    payload_template TEXT,
+    secret TEXT,
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alarm_webhooks_threshold ON alarm_webhooks(threshold_id);
@@ -0,0 +1,17 @@
+-- Weather Server Database Schema
+-- SQLite Migration 019: Notification subscriptions
+-- Matches Postgres migration 028.
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id INTEGER PRIMARY KEY,
+    recipient VARCHAR(255) NOT NULL,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('email', 'sms')),
+    zipcode VARCHAR(10),
+    metric_name VARCHAR(50),
+    min_severity VARCHAR(10) NOT NULL DEFAULT 'info' CHECK (min_severity IN ('info', 'warning', 'critical')),
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (zipcode) REFERENCES locations(zipcode) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_zipcode ON subscriptions(zipcode) WHERE is_active;
@@ -0,0 +1,39 @@
+-- Weather Server Database Schema
+-- TimescaleDB Migration 002: Hourly continuous aggregate
+-- Only run with DB_TIMESCALEDB=true and AGGREGATION_CONTINUOUS=true.
+
+-- Hourly averages kept up to date by TimescaleDB as readings arrive. The
+-- hourly aggregator copies a finished hour from here into hourly_metrics
+-- instead of scanning raw_metrics. Like AGGREGATION_EXCLUDE_FLAGGED=true,
+-- quality-flagged measurements are left out; see the hourly aggregator's
+-- query for what each column averages.
+CREATE MATERIALIZED VIEW IF NOT EXISTS raw_metrics_hourly
+WITH (timescaledb.continuous) AS
+SELECT
+    zipcode,
+    time_bucket(INTERVAL '1 hour', timestamp) AS hour_timestamp,
+    AVG(temperature) FILTER (WHERE NOT quality_flags ? 'temperature') AS avg_temp,
+    AVG(humidity) FILTER (WHERE NOT quality_flags ? 'humidity') AS avg_humidity,
+    AVG(precipitation) FILTER (WHERE NOT quality_flags ? 'precipitation') AS avg_precip,
+    AVG(wind_speed) FILTER (WHERE NOT quality_flags ? 'wind_speed') AS avg_wind,
+    AVG(pollution_index) FILTER (WHERE NOT quality_flags ? 'pollution_index') AS avg_pollution,
+    AVG(pollen_index) FILTER (WHERE NOT quality_flags ? 'pollen_index') AS avg_pollen,
+    AVG(pressure) FILTER (WHERE NOT quality_flags ? 'pressure') AS avg_pressure,
+    AVG(visibility) FILTER (WHERE NOT quality_flags ? 'visibility') AS avg_visibility,
+    AVG(uv_index) FILTER (WHERE NOT quality_flags ? 'uv_index') AS avg_uv_index,
+    AVG(snow_depth) FILTER (WHERE NOT quality_flags ? 'snow_depth') AS avg_snow_depth,
+    AVG(heat_index) FILTER (WHERE NOT quality_flags ?| ARRAY['temperature', 'humidity']) AS avg_heat_index,
+    AVG(wind_chill) FILTER (WHERE NOT quality_flags ?| ARRAY['temperature', 'wind_speed']) AS avg_wind_chill,
+    AVG(dew_point) FILTER (WHERE NOT quality_flags ?| ARRAY['temperature', 'humidity']) AS avg_dew_point,
+    COUNT(*) AS sample_count
+FROM raw_metrics
+GROUP BY zipcode, time_bucket(INTERVAL '1 hour', timestamp)
+WITH NO DATA;
+
+-- Refresh recent hours in the background; the aggregator refreshes the
+-- hour it copies itself, so late readings are never missed
+SELECT add_continuous_aggregate_policy('raw_metrics_hourly',
+    start_offset => INTERVAL '1 day',
+    end_offset => INTERVAL '1 hour',
+    schedule_interval => INTERVAL '30 minutes',
+    if_not_exists => true);
@@ -0,0 +1,38 @@
+-- Weather Server Database Schema
+-- TimescaleDB Migration 001: Hypertables
+-- Only run with DB_TIMESCALEDB=true, after the plain migrations.
+
+-- A year of 5-minute readings from 10k stations is about a billion rows.
+-- As hypertables raw_metrics and hourly_metrics are split into time chunks,
+-- so inserts only touch the newest chunk's indexes and old data can be
+-- compressed or dropped a chunk at a time.
+CREATE EXTENSION IF NOT EXISTS timescaledb;
+
+-- Unique constraints on a hypertable must include its time column. Nothing
+-- references these ids, so the keys just gain the timestamp.
+ALTER TABLE raw_metrics DROP CONSTRAINT IF EXISTS raw_metrics_pkey;
+ALTER TABLE raw_metrics ADD PRIMARY KEY (id, timestamp);
+
+ALTER TABLE hourly_metrics DROP CONSTRAINT IF EXISTS hourly_metrics_pkey;
+ALTER TABLE hourly_metrics ADD PRIMARY KEY (id, hour_timestamp);
+
+-- Existing rows are moved into chunks, which locks the tables while it runs
+SELECT create_hypertable('raw_metrics', 'timestamp',
+    chunk_time_interval => INTERVAL '1 day',
+    migrate_data => true,
+    if_not_exists => true);
+
+SELECT create_hypertable('hourly_metrics', 'hour_timestamp',
+    chunk_time_interval => INTERVAL '30 days',
+    migrate_data => true,
+    if_not_exists => true);
+
+-- Compress readings once they are too old to be redelivered or
+-- re-aggregated. Upserting a late reading into a compressed chunk needs
+-- TimescaleDB 2.11 or later.
+ALTER TABLE raw_metrics SET (
+    timescaledb.compress,
+    timescaledb.compress_segmentby = 'zipcode, station_id',
+    timescaledb.compress_orderby = 'timestamp DESC'
+);
+SELECT add_compression_policy('raw_metrics', INTERVAL '30 days', if_not_exists => true);
@@ -1,9 +1,9 @@
 -- Sample alarm thresholds for testing
 -- Run this after the server has created some locations
 
--- Miami Beach: Hurricane warning (wind > 74 mph for 15 minutes)
-INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, duration_minutes, is_active)
-VALUES ('33139', 'wind_speed', '>', 74.0, 15, true)
+-- Miami Beach: Hurricane warning (wind > 74 mph for 15 minutes), critical
+INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, duration_minutes, severity, is_active)
+VALUES ('33139', 'wind_speed', '>', 74.0, 15, 'critical', true)
 ON CONFLICT (zipcode, metric_name) DO NOTHING;
 
 -- Minneapolis: Extreme cold warning (temp < -20°C for 60 minutes)
@@ -21,9 +21,11 @@ INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, d
 VALUES ('94102', 'precipitation', '>', 50.0, 60, true)
 ON CONFLICT (zipcode, metric_name) DO NOTHING;
 
--- Phoenix: Extreme heat warning (temp > 45°C for 120 minutes)
-INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, duration_minutes, is_active)
-VALUES ('85001', 'temperature', '>', 45.0, 120, true)
+-- Phoenix: Extreme heat warning (temp > 45°C for 120 minutes, clearing
+-- once below 43°C for 30 minutes)
+INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, duration_minutes,
+                              clear_value, clear_duration_minutes, is_active)
+VALUES ('85001', 'temperature', '>', 45.0, 120, 43.0, 30, true)
 ON CONFLICT (zipcode, metric_name) DO NOTHING;
 
 -- Seattle: High pollen alert (pollen > 200 for 180 minutes)
@@ -31,6 +33,30 @@ INSERT INTO alarm_thresholds (zipcode, metric_name, operator, threshold_value, d
 VALUES ('98101', 'pollen_index', '>', 200.0, 180, true)
 ON CONFLICT (zipcode, metric_name) DO NOTHING;
 
+-- Miami Beach: Heat advisory (temp > 30°C AND humidity > 80% for 30 minutes)
+INSERT INTO alarm_rules (zipcode, name, combinator, duration_minutes, is_active)
+VALUES ('33139', 'heat_advisory', 'AND', 30, true)
+ON CONFLICT (zipcode, name) DO NOTHING;
+
+INSERT INTO alarm_rule_conditions (rule_id, metric_name, operator, threshold_value)
+SELECT r.id, c.metric_name, c.operator, c.threshold_value
+FROM alarm_rules r
+CROSS JOIN (VALUES ('temperature', '>', 30.0), ('humidity', '>', 80.0)) AS c(metric_name, operator, threshold_value)
+WHERE r.zipcode = '33139' AND r.name = 'heat_advisory'
+  AND NOT EXISTS (SELECT 1 FROM alarm_rule_conditions WHERE rule_id = r.id);
+
+-- Miami Beach: Pressure anomaly (3 standard deviations from the same hour
+-- over the last 30 days, for 20 minutes)
+INSERT INTO alarm_anomaly_rules (zipcode, metric_name, deviations, baseline_days, duration_minutes, is_active)
+VALUES ('33139', 'pressure', 3.0, 30, 20, true)
+ON CONFLICT (zipcode, metric_name) DO NOTHING;
+
+-- Subscriptions: Miami Beach's operators get everything there by email,
+-- the storm desk critical wind alarms anywhere by SMS
+INSERT INTO subscriptions (recipient, channel, zipcode, metric_name, min_severity)
+VALUES ('miami-ops@example.com', 'email', '33139', NULL, 'info'),
+       ('+13055550100', 'sms', NULL, 'wind_speed', 'critical');
+
 -- Verify insertion
 SELECT 
     zipcode, 